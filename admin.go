@@ -0,0 +1,312 @@
+package vncrps
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/alltom/vncrps/game"
+	"github.com/alltom/vncrps/rfb"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How long announcements stay on players' screens.
+const announcementDuration = 15 * time.Second
+
+// AdminPlayer is how the admin API describes a player.
+type AdminPlayer struct {
+	Id           int64  `json:"id"`
+	Name         string `json:"name"`
+	Rank         int    `json:"rank"`
+	Disconnected bool   `json:"disconnected"`
+	Bot          bool   `json:"bot,omitempty"`
+	Rating       int    `json:"rating"`
+	Wins         int    `json:"wins"`
+	Losses       int    `json:"losses"`
+	Draws        int    `json:"draws"`
+	Forfeits     int    `json:"forfeits"`
+	Played       int    `json:"played"`
+	Streak       int    `json:"streak"`
+	BestStreak   int    `json:"best_streak"`
+
+	// What the player's connection has carried, if they're connected. FPS is averaged since they connected.
+	BytesSent       int64   `json:"bytes_sent,omitempty"`
+	BytesReceived   int64   `json:"bytes_received,omitempty"`
+	FPS             float64 `json:"fps,omitempty"`
+	Encoding        string  `json:"encoding,omitempty"`
+	UpdateLatencyMs float64 `json:"update_latency_ms,omitempty"`
+}
+
+// AdminRoom is how the admin API describes a room. Without Config.Rooms, the game is one room, Main.
+type AdminRoom struct {
+	Name    string `json:"name"`
+	Players int    `json:"players"` // Connected, not counting the bot.
+	Phase   string `json:"phase"`   // "waiting", "picking", or "review".
+	Round   int    `json:"round"`
+}
+
+// AdminHandler serves the admin API, which cmd/vncrpsctl talks to:
+//
+//	GET  /players                   every player, highest rank first, as JSON
+//	GET  /standings?format=csv      the same, as CSV
+//	POST /kick?player=ID            disconnects a player
+//	POST /announce                  shows the request body to every player for a while
+//	GET  /security                  the server's CheckSecurity findings, as JSON
+//	GET  /screenshot?player=ID      what a player's viewer is showing, as a PNG
+//	GET  /bans                      the addresses and networks connections are turned away from, as JSON
+//	POST /ban?addr=ADDR             bans an address, such as 203.0.113.7, or a network, such as 203.0.113.0/24
+//	POST /unban?addr=ADDR           lifts a ban
+//	POST /reload                    re-reads the ban file, the only configuration that can change while the server runs
+//	GET  /tunnels                   the ssh commands players run to reach the server with -ssh-host, as JSON
+//	GET  /rooms                     every room, with how many are playing and the round, as JSON
+//	POST /start                     starts a round without waiting for the last one
+//
+// Players, standings, kick, screenshot, and start act on the room named by a room parameter, such as ?room=Room+2, or
+// Main without one. It does no authentication, so only expose it on a UNIX socket or loopback address.
+func (s *Server) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/players", s.handlePlayers)
+	mux.HandleFunc("/standings", s.handlePlayers)
+	mux.HandleFunc("/kick", s.handleKick)
+	mux.HandleFunc("/announce", s.handleAnnounce)
+	mux.HandleFunc("/security", s.handleSecurity)
+	mux.HandleFunc("/screenshot", s.handleScreenshot)
+	mux.HandleFunc("/bans", s.handleBans)
+	mux.HandleFunc("/ban", s.handleBan)
+	mux.HandleFunc("/unban", s.handleBan)
+	mux.HandleFunc("/reload", s.handleReload)
+	mux.HandleFunc("/tunnels", s.handleTunnels)
+	mux.HandleFunc("/rooms", s.handleRooms)
+	mux.HandleFunc("/start", s.handleStart)
+	return mux
+}
+
+func (s *Server) handlePlayers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	room, err := s.room(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	var players []AdminPlayer
+	for _, p := range room.Game.Standings() {
+		player := AdminPlayer{Id: int64(p.PlayerId), Name: p.Name, Rank: p.Rank, Disconnected: p.Disconnected, Bot: p.Bot,
+			Rating: p.Rating, Wins: p.Wins, Losses: p.Losses, Draws: p.Draws, Forfeits: p.Forfeits, Played: p.Played,
+			Streak: p.Streak, BestStreak: p.BestStreak}
+		if stats, err := s.PlayerStats(room.Game, p.PlayerId); err == nil {
+			player.BytesSent = stats.BytesWritten
+			player.BytesReceived = stats.BytesRead
+			player.FPS = float64(stats.FramebufferUpdates) / time.Since(stats.Connected).Seconds()
+			player.Encoding = rfb.EncodingName(stats.Encoding)
+			player.UpdateLatencyMs = float64(stats.UpdateLatency) / float64(time.Millisecond)
+		}
+		players = append(players, player)
+	}
+
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+		if players == nil {
+			players = []AdminPlayer{}
+		}
+		json.NewEncoder(w).Encode(players)
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"id", "name", "rank", "disconnected", "rating", "wins", "losses", "draws", "forfeits", "played", "streak", "best_streak"})
+		for _, p := range players {
+			cw.Write([]string{strconv.FormatInt(p.Id, 10), p.Name, strconv.Itoa(p.Rank), strconv.FormatBool(p.Disconnected),
+				strconv.Itoa(p.Rating), strconv.Itoa(p.Wins), strconv.Itoa(p.Losses), strconv.Itoa(p.Draws), strconv.Itoa(p.Forfeits),
+				strconv.Itoa(p.Played), strconv.Itoa(p.Streak), strconv.Itoa(p.BestStreak)})
+		}
+		cw.Flush()
+	default:
+		http.Error(w, fmt.Sprintf("unrecognized format %q", format), http.StatusBadRequest)
+	}
+}
+
+func (s *Server) handleKick(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	room, err := s.room(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	id, err := strconv.ParseInt(r.URL.Query().Get("player"), 10, 64)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid player ID: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := s.Kick(room.Game, game.PlayerId(id)); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+}
+
+func (s *Server) handleAnnounce(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 1024))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("read message: %v", err), http.StatusBadRequest)
+		return
+	}
+	message := strings.TrimSpace(string(body))
+	if message == "" {
+		http.Error(w, "message is empty", http.StatusBadRequest)
+		return
+	}
+	s.game.Announce(message, announcementDuration)
+}
+
+func (s *Server) handleSecurity(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Security())
+}
+
+func (s *Server) handleScreenshot(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	room, err := s.room(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	id, err := strconv.ParseInt(r.URL.Query().Get("player"), 10, 64)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid player ID: %v", err), http.StatusBadRequest)
+		return
+	}
+	img, err := s.Screenshot(room.Game, game.PlayerId(id))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	png.Encode(w, img)
+}
+
+func (s *Server) handleBans(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Bans())
+}
+
+func (s *Server) handleTunnels(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.TunnelCommands())
+}
+
+func (s *Server) handleRooms(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	rooms := []AdminRoom{}
+	for _, room := range s.rooms() {
+		rooms = append(rooms, AdminRoom{room.Name, room.Game.Playing(), room.Game.Overview().Phase.String(), room.Game.Counters().Rounds})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rooms)
+}
+
+func (s *Server) handleStart(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	room, err := s.room(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err := room.Game.StartRound(); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+}
+
+// room returns the room a request's room parameter names, or Main without one.
+func (s *Server) room(r *http.Request) (*Room, error) {
+	name := r.URL.Query().Get("room")
+	if name == "" {
+		name = "Main"
+	}
+	for _, room := range s.rooms() {
+		if room.Name == name {
+			return room, nil
+		}
+	}
+	return nil, fmt.Errorf("no room named %q", name)
+}
+
+// rooms returns the lobby's rooms, or the game as the one room, Main, without Config.Rooms.
+func (s *Server) rooms() []*Room {
+	if s.lobby == nil {
+		return []*Room{{Name: "Main", Game: s.game}}
+	}
+	return s.lobby.Rooms()
+}
+
+func (s *Server) handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := s.ReloadBans(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleBan serves both /ban and /unban.
+func (s *Server) handleBan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	addr := r.URL.Query().Get("addr")
+	prefix, err := parseBan(addr)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid address: %v", err), http.StatusBadRequest)
+		return
+	}
+	if r.URL.Path == "/unban" && !s.guard.banned(prefix) {
+		http.Error(w, fmt.Sprintf("%v isn't banned", formatBan(prefix)), http.StatusNotFound)
+		return
+	}
+	ban := s.Ban
+	if r.URL.Path == "/unban" {
+		ban = s.Unban
+	}
+	if err := ban(addr); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
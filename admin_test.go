@@ -0,0 +1,182 @@
+package vncrps
+
+import (
+	"encoding/json"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestAdminKick(t *testing.T) {
+	server, err := NewServer(Config{Addr: "127.0.0.1:0", Seed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+	admin := server.AdminHandler()
+
+	conn, client := dial(t, server)
+	defer conn.Close()
+
+	// Wait for the handler to be created.
+	if _, err := client.Update(false); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/players", nil))
+	var players []AdminPlayer
+	if err := json.NewDecoder(rec.Body).Decode(&players); err != nil {
+		t.Fatalf("decode players: %v", err)
+	}
+	if len(players) != 1 {
+		t.Fatalf("got %d players, want 1", len(players))
+	}
+	if p := players[0]; p.BytesSent == 0 || p.BytesReceived == 0 || p.FPS == 0 || p.Encoding == "" {
+		t.Errorf("player is %+v, want their connection's stats", p)
+	}
+
+	rec = httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/announce", strings.NewReader("hello")))
+	if rec.Code != http.StatusOK {
+		t.Errorf("announce failed: %d %s", rec.Code, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/kick?player=12345", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("kicking a nonexistent player returned %d, want 404", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/kick?player="+strconv.FormatInt(players[0].Id, 10), nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("kick failed: %d %s", rec.Code, rec.Body)
+	}
+	if _, err := client.ReadMessage(); err == nil {
+		t.Error("connection is still open after kick")
+	}
+}
+
+func TestAdminScreenshot(t *testing.T) {
+	server, err := NewServer(Config{Addr: "127.0.0.1:0", Seed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+	admin := server.AdminHandler()
+
+	conn, client := dial(t, server)
+	defer conn.Close()
+	if _, err := client.Update(false); err != nil {
+		t.Fatal(err)
+	}
+	players := server.Game().Standings()
+	if len(players) != 1 {
+		t.Fatalf("got %d players, want 1", len(players))
+	}
+
+	rec := httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/screenshot?player="+strconv.FormatInt(int64(players[0].PlayerId), 10), nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("screenshot failed: %d %s", rec.Code, rec.Body)
+	}
+	img, err := png.Decode(rec.Body)
+	if err != nil {
+		t.Fatalf("decode screenshot: %v", err)
+	}
+	if img.Bounds() != client.Framebuffer.Bounds() {
+		t.Errorf("screenshot is %v, want the viewer's %v", img.Bounds(), client.Framebuffer.Bounds())
+	}
+	if got, want := color.RGBAModel.Convert(img.At(10, 10)), client.Framebuffer.At(10, 10); got != want {
+		t.Errorf("screenshot has %v at (10, 10), but the viewer shows %v", got, want)
+	}
+
+	rec = httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/screenshot?player=12345", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("screenshot of a nonexistent player returned %d, want 404", rec.Code)
+	}
+}
+
+func TestAdminTunnels(t *testing.T) {
+	server, err := NewServer(Config{Addr: "127.0.0.1:5900", Tunnel: &TunnelHelper{ListenAddr: "127.0.0.1:5900", SSHHost: "rps.example.com", Players: []string{"alice", "bob"}}, Seed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	server.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/tunnels", nil))
+	var commands []string
+	if err := json.NewDecoder(rec.Body).Decode(&commands); err != nil {
+		t.Fatalf("decode tunnel commands: %v", err)
+	}
+	want := []string{"ssh -N -L 5900:127.0.0.1:5900 alice@rps.example.com", "ssh -N -L 5900:127.0.0.1:5900 bob@rps.example.com"}
+	if !reflect.DeepEqual(commands, want) {
+		t.Errorf("tunnel commands are %q, want %q", commands, want)
+	}
+}
+
+func TestAdminRooms(t *testing.T) {
+	server, err := NewServer(Config{Addr: "127.0.0.1:0", Rooms: true, Seed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin := server.AdminHandler()
+	room, err := server.Lobby().create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := server.Lobby().join(room); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/start", nil))
+	if rec.Code != http.StatusConflict {
+		t.Errorf("starting a round in an empty Main returned %d, want 409", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/start?room=Room+2", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("starting a round in Room 2 failed: %d %s", rec.Code, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/players?room=Room+2", nil))
+	var players []AdminPlayer
+	if err := json.NewDecoder(rec.Body).Decode(&players); err != nil {
+		t.Fatalf("decode players: %v", err)
+	}
+	if len(players) != 2 {
+		t.Errorf("got %d players in Room 2, want 2", len(players))
+	}
+	rec = httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/kick?room=Room+3&player=1", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("kicking a player from a nonexistent room returned %d, want 404", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/rooms", nil))
+	var rooms []AdminRoom
+	if err := json.NewDecoder(rec.Body).Decode(&rooms); err != nil {
+		t.Fatalf("decode rooms: %v", err)
+	}
+	want := []AdminRoom{{"Main", 0, "waiting", 0}, {"Room 2", 2, "picking", 2}}
+	if !reflect.DeepEqual(rooms, want) {
+		t.Errorf("rooms are %+v, want %+v", rooms, want)
+	}
+}
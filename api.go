@@ -0,0 +1,362 @@
+package vncrps
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/alltom/vncrps/game"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// APIPlayer is how the game API describes a player.
+type APIPlayer struct {
+	Id           int64  `json:"id"`
+	Name         string `json:"name"`
+	Rank         int    `json:"rank"`
+	Disconnected bool   `json:"disconnected"`
+	Bot          bool   `json:"bot,omitempty"`
+	Rating       int    `json:"rating"`
+	Wins         int    `json:"wins"`
+	Losses       int    `json:"losses"`
+	Draws        int    `json:"draws"`
+	Forfeits     int    `json:"forfeits"` // Losses without picking a move.
+	Played       int    `json:"played"`   // Matches, including ones nobody picked in.
+	Streak       int    `json:"streak"`   // Matches won in a row.
+	BestStreak   int    `json:"best_streak"`
+}
+
+// APIRanking is a player's place in the rankings. Players with the same rank, or rating when the rankings are by
+// rating, share a place.
+type APIRanking struct {
+	Place int `json:"place"`
+	APIPlayer
+}
+
+// APIMatchup is two players facing each other in a round. Their moves are left out until the round is judged.
+type APIMatchup struct {
+	Players [2]APIPlayer `json:"players"`
+	Picked  [2]bool      `json:"picked"`
+	Moves   *[2]string   `json:"moves,omitempty"`  // Such as "ROCK", or "" for no move.
+	Winner  *int64       `json:"winner,omitempty"` // The game winner's ID, if there was one.
+
+	// Which game of the match this is, how many games it's best of, how many each player has won, and whether the
+	// match is over. Matches are one game unless the server was started with -best-of.
+	Game   int    `json:"game"`
+	BestOf int    `json:"best_of"`
+	Score  [2]int `json:"score"`
+	Over   bool   `json:"over"`
+}
+
+// APIState is the game as the game API's /state describes it.
+type APIState struct {
+	Phase        string       `json:"phase"` // "waiting", "picking", or "review".
+	Round        int          `json:"round"` // The round being played or reviewed, or the last one while waiting.
+	TimeLeftMs   int64        `json:"time_left_ms"`
+	TimeLeft     string       `json:"time_left"` // Formatted like players' countdowns, such as "0:09"; see apiCountdownStyle.
+	Announcement string       `json:"announcement,omitempty"`
+	Matchups     []APIMatchup `json:"matchups"` // This round's, while it's being picked or reviewed.
+	Rankings     []APIRanking `json:"rankings"`
+	Moves        []string     `json:"moves"` // What players pick from, such as "ROCK", "PAPER", and "SCISSORS".
+}
+
+// APIPlayedMatchup is a game from the game API's /history, with its moves and the round it was in.
+type APIPlayedMatchup struct {
+	Round int       `json:"round"`
+	Time  time.Time `json:"time"` // When the round was judged.
+	APIMatchup
+}
+
+// APIHeadToHead is how a player's matches against an opponent have gone, from the player's side.
+type APIHeadToHead struct {
+	Wins   int `json:"wins"`
+	Losses int `json:"losses"`
+	Draws  int `json:"draws"`
+}
+
+// APIEvent is one of the game API's /events, a game.Event.
+type APIEvent struct {
+	Type     string       `json:"type"` // A game.EventType, such as "round_judged".
+	Round    int          `json:"round"`
+	Player   *APIPlayer   `json:"player,omitempty"`
+	Matchups []APIMatchup `json:"matchups,omitempty"` // Who plays whom when a round starts, and the results when it's judged.
+	Summary  string       `json:"summary,omitempty"`  // The results in a line when a round is judged, like players' clipboards get.
+
+	// How long was left in the phase just after it happened, as in APIState.
+	TimeLeftMs int64  `json:"time_left_ms"`
+	TimeLeft   string `json:"time_left"`
+}
+
+// Events that don't fit in this many a client hasn't been sent yet are dropped; see game.GameServer.Subscribe.
+const apiEventBuffer = 64
+
+// /events sends a comment this often, so proxies don't close it while the game is quiet.
+const apiEventKeepAlive = 15 * time.Second
+
+// APIHandler serves the game API, a read-only view of the game Game returns for scoreboards and stream overlays:
+//
+//	GET /state     the phase, time left, this round's matchups, the rankings, and the moves players pick from
+//	GET /players   every player, by ID
+//	GET /matchups  this round's matchups, with moves once it's judged
+//	GET /rankings  every player, highest rank first, with their place; ?by=rating for highest rating first
+//	GET /history   the latest games, newest first; ?player=ID for just theirs, ?limit=N for up to N (default 20)
+//	GET /head-to-head?player=ID&opponent=ID  how the player's matches against the opponent have gone
+//	GET /events    server-sent events as players come and go and pick, rounds start and are judged, and draws are replayed
+//
+// Everything is JSON. Moves aren't shown until players can see them too, and any web page may read the API. The time
+// left in /state and /events is formatted the way Config.CountdownStyle has it, or as ?countdown=clock or
+// ?countdown=seconds asks.
+func (s *Server) APIHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/state", s.apiHandler(func(r *http.Request) (interface{}, error) {
+		style, err := s.apiCountdownStyle(r)
+		if err != nil {
+			return nil, err
+		}
+		return s.apiState(style), nil
+	}))
+	mux.HandleFunc("/players", s.apiHandler(func(r *http.Request) (interface{}, error) {
+		players := apiPlayers(s.game.Standings())
+		sort.Slice(players, func(i, j int) bool { return players[i].Id < players[j].Id })
+		return players, nil
+	}))
+	mux.HandleFunc("/matchups", s.apiHandler(func(r *http.Request) (interface{}, error) {
+		return s.apiState(s.config.CountdownStyle).Matchups, nil
+	}))
+	mux.HandleFunc("/rankings", s.apiHandler(func(r *http.Request) (interface{}, error) {
+		switch by := r.URL.Query().Get("by"); by {
+		case "", "rank":
+			return apiRankings(s.game.Standings(), false), nil
+		case "rating":
+			standings := s.game.Standings()
+			game.SortByRating(standings)
+			return apiRankings(standings, true), nil
+		default:
+			return nil, fmt.Errorf("unrecognized ranking %q; want rank or rating", by)
+		}
+	}))
+	mux.HandleFunc("/history", s.apiHandler(func(r *http.Request) (interface{}, error) {
+		query := r.URL.Query()
+		var playerId game.PlayerId
+		if query.Get("player") != "" {
+			id, err := apiPlayerId(query.Get("player"))
+			if err != nil {
+				return nil, err
+			}
+			playerId = id
+		}
+		limit := apiHistoryLimit
+		if query.Get("limit") != "" {
+			n, err := strconv.Atoi(query.Get("limit"))
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid limit %q", query.Get("limit"))
+			}
+			limit = n
+		}
+		history := []APIPlayedMatchup{}
+		for _, m := range s.game.History(limit, playerId) {
+			history = append(history, APIPlayedMatchup{m.Round, m.Time, apiMatchup(m.MatchupSummary, true)})
+		}
+		return history, nil
+	}))
+	mux.HandleFunc("/head-to-head", s.apiHandler(func(r *http.Request) (interface{}, error) {
+		playerId, err := apiPlayerId(r.URL.Query().Get("player"))
+		if err != nil {
+			return nil, err
+		}
+		opponentId, err := apiPlayerId(r.URL.Query().Get("opponent"))
+		if err != nil {
+			return nil, err
+		}
+		record := s.game.HeadToHead(playerId, opponentId)
+		return APIHeadToHead{record.Wins, record.Losses, record.Draws}, nil
+	}))
+	mux.HandleFunc("/events", s.handleEvents)
+	return mux
+}
+
+// How many games /history returns without a limit.
+const apiHistoryLimit = 20
+
+// apiCountdownStyle returns how a request wants the time left formatted: as its countdown parameter says, or the way
+// players see it by default.
+func (s *Server) apiCountdownStyle(r *http.Request) (CountdownStyle, error) {
+	if style := r.URL.Query().Get("countdown"); style != "" {
+		return ParseCountdownStyle(style)
+	}
+	return s.config.CountdownStyle, nil
+}
+
+func apiPlayerId(s string) (game.PlayerId, error) {
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid player ID: %v", err)
+	}
+	return game.PlayerId(id), nil
+}
+
+// apiHandler serves what get returns as JSON. Errors are the client's fault.
+func (s *Server) apiHandler(get func(r *http.Request) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", "*") // For overlays served from elsewhere.
+		v, err := get(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(v)
+	}
+}
+
+func (s *Server) apiState(style CountdownStyle) *APIState {
+	overview := s.game.Overview()
+	state := &APIState{
+		Phase:        overview.Phase.String(),
+		Round:        s.game.Counters().Rounds,
+		TimeLeftMs:   int64(overview.TimeLeftInPhase / time.Millisecond),
+		TimeLeft:     style.Format(overview.TimeLeftInPhase),
+		Announcement: overview.Announcement,
+		Matchups:     []APIMatchup{},
+		Rankings:     apiRankings(overview.Rankings, false),
+	}
+	for _, move := range overview.Moves {
+		state.Moves = append(state.Moves, move.String())
+	}
+	matchups := overview.Matchups
+	if overview.Phase == game.PhaseReview && overview.LastRound != nil {
+		matchups = overview.LastRound.Matchups
+	}
+	for _, m := range matchups {
+		state.Matchups = append(state.Matchups, apiMatchup(m, overview.Phase == game.PhaseReview))
+	}
+	return state
+}
+
+// handleEvents streams the game's events until the client goes away or the server stops.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming isn't supported", http.StatusInternalServerError)
+		return
+	}
+	style, err := s.apiCountdownStyle(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	events, stop := s.game.Subscribe(apiEventBuffer)
+	defer stop()
+	keepAlive := time.NewTicker(apiEventKeepAlive)
+	defer keepAlive.Stop()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e := <-events:
+			data, err := json.Marshal(apiEvent(e, style))
+			if err != nil {
+				return
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		}
+		flusher.Flush()
+	}
+}
+
+func apiEvent(e game.Event, style CountdownStyle) APIEvent {
+	event := APIEvent{Type: string(e.Type), Round: e.Round, Summary: e.Summary,
+		TimeLeftMs: int64(e.TimeLeft / time.Millisecond), TimeLeft: style.Format(e.TimeLeft)}
+	if e.Player != nil {
+		player := apiPlayer(*e.Player)
+		event.Player = &player
+	}
+	for _, m := range e.Matchups {
+		event.Matchups = append(event.Matchups, apiMatchup(m, e.Type == game.EventRoundJudged || e.Type == game.EventRematch))
+	}
+	return event
+}
+
+// apiMatchup describes m, with its moves if showMoves is set.
+func apiMatchup(m game.MatchupSummary, showMoves bool) APIMatchup {
+	matchup := APIMatchup{Picked: m.Picked, Game: m.Game, BestOf: m.BestOf, Score: m.Score, Over: m.Over}
+	for i, player := range m.Players {
+		matchup.Players[i] = apiPlayer(player)
+	}
+	if showMoves {
+		var moves [2]string
+		for i, move := range m.Moves {
+			if move != nil {
+				moves[i] = move.String()
+			}
+		}
+		matchup.Moves = &moves
+	}
+	if m.Winner != nil {
+		winner := int64(*m.Winner)
+		matchup.Winner = &winner
+	}
+	return matchup
+}
+
+func apiPlayer(player game.PlayerInfo) APIPlayer {
+	return APIPlayer{
+		Id:           int64(player.PlayerId),
+		Name:         player.Name,
+		Rank:         player.Rank,
+		Disconnected: player.Disconnected,
+		Bot:          player.Bot,
+		Rating:       player.Rating,
+		Wins:         player.Wins,
+		Losses:       player.Losses,
+		Draws:        player.Draws,
+		Forfeits:     player.Forfeits,
+		Played:       player.Played,
+		Streak:       player.Streak,
+		BestStreak:   player.BestStreak,
+	}
+}
+
+func apiPlayers(players []game.PlayerInfo) []APIPlayer {
+	result := []APIPlayer{}
+	for _, player := range players {
+		result = append(result, apiPlayer(player))
+	}
+	return result
+}
+
+// apiRankings places standings, which are highest rank first, or highest rating first if byRating is set.
+func apiRankings(standings []game.PlayerInfo, byRating bool) []APIRanking {
+	score := func(player game.PlayerInfo) int {
+		if byRating {
+			return player.Rating
+		}
+		return player.Rank
+	}
+	rankings := []APIRanking{}
+	for i, player := range standings {
+		place := i + 1
+		if i > 0 && score(player) == score(standings[i-1]) {
+			place = rankings[i-1].Place
+		}
+		rankings = append(rankings, APIRanking{place, apiPlayer(player)})
+	}
+	return rankings
+}
@@ -0,0 +1,174 @@
+package vncrps
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/alltom/vncrps/game"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAPI(t *testing.T) {
+	now := time.Now()
+	server, err := NewServer(Config{Addr: "127.0.0.1:0", Seed: 1, Now: func() time.Time { return now }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := server.APIHandler()
+	get := func(path string, v interface{}) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		api.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s: %d %s", path, rec.Code, rec.Body)
+		}
+		if err := json.NewDecoder(rec.Body).Decode(v); err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+	}
+
+	g := server.Game()
+	p1 := g.AddPlayer()
+	p2 := g.AddPlayer()
+	g.Pick(p1, game.MoveRock)
+	var state APIState
+	get("/state", &state)
+	if state.Phase != "picking" || state.Round != 1 || len(state.Matchups) != 1 {
+		t.Fatalf("got state %+v, want round 1 being picked with one matchup", state)
+	}
+	if state.TimeLeftMs != 10000 || state.TimeLeft != "0:10" {
+		t.Errorf("time left is %d ms, %q; want 10000 ms, 0:10", state.TimeLeftMs, state.TimeLeft)
+	}
+	get("/state?countdown=seconds", &state)
+	if state.TimeLeft != "10s" {
+		t.Errorf("time left in seconds is %q, want 10s", state.TimeLeft)
+	}
+	if m := state.Matchups[0]; m.Moves != nil || m.Picked[0] == m.Picked[1] {
+		t.Errorf("got matchup %+v while picking, want one player picked and no moves", m)
+	}
+
+	g.Pick(p2, game.MoveScissors)
+	now = now.Add(11 * time.Second)
+	var matchups []APIMatchup
+	get("/matchups", &matchups)
+	if len(matchups) != 1 || matchups[0].Moves == nil || matchups[0].Winner == nil || *matchups[0].Winner != int64(p1) {
+		t.Errorf("got matchups %+v during review, want P1's win with both moves", matchups)
+	}
+	var rankings []APIRanking
+	get("/rankings", &rankings)
+	want := []APIRanking{
+		{1, APIPlayer{Id: int64(p1), Name: "P1", Rank: 1, Rating: 1016, Wins: 1, Played: 1, Streak: 1, BestStreak: 1}},
+		{2, APIPlayer{Id: int64(p2), Name: "P2", Rating: 984, Losses: 1, Played: 1}},
+	}
+	if !reflect.DeepEqual(rankings, want) {
+		t.Errorf("got rankings %+v, want %+v", rankings, want)
+	}
+	get("/rankings?by=rating", &rankings)
+	if !reflect.DeepEqual(rankings, want) {
+		t.Errorf("got rankings by rating %+v, want %+v", rankings, want)
+	}
+
+	rec := httptest.NewRecorder()
+	api.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/state?countdown=sundial", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("GET /state?countdown=sundial: got status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	rec = httptest.NewRecorder()
+	api.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/state", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST /state: got status %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestServerAPIAddr(t *testing.T) {
+	server, err := NewServer(Config{Addr: "127.0.0.1:0", APIAddr: "127.0.0.1:0", Seed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+	server.Game().AddPlayer()
+
+	resp, err := http.Get(fmt.Sprintf("http://%v/players", server.APIAddr()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var players []APIPlayer
+	if err := json.NewDecoder(resp.Body).Decode(&players); err != nil {
+		t.Fatal(err)
+	}
+	if len(players) != 1 || resp.Header.Get("Access-Control-Allow-Origin") != "*" {
+		t.Errorf("got players %+v with headers %v, want one player readable from any page", players, resp.Header)
+	}
+}
+
+func TestServerAPIEvents(t *testing.T) {
+	var now atomic.Int64
+	now.Store(time.Now().Unix())
+	server, err := NewServer(Config{Addr: "127.0.0.1:0", APIAddr: "127.0.0.1:0", Seed: 1, Now: func() time.Time { return time.Unix(now.Load(), 0) }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	resp, err := http.Get(fmt.Sprintf("http://%v/events", server.APIAddr()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if contentType := resp.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Errorf("got Content-Type %q, want text/event-stream", contentType)
+	}
+	server.Game().AddPlayer() // Subscribed once the headers were sent.
+
+	lines := bufio.NewScanner(resp.Body)
+	var got []string
+	for len(got) < 2 && lines.Scan() {
+		got = append(got, lines.Text())
+	}
+	want := []string{"event: player_joined", `data: {"type":"player_joined","round":0,"player":{"id":1,"name":"P1","rank":0,"disconnected":false,"rating":1000,"wins":0,"losses":0,"draws":0,"forfeits":0,"played":0,"streak":0,"best_streak":0},"time_left_ms":0,"time_left":"0:00"}`}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	g := server.Game()
+	p2 := g.AddPlayer()
+	g.Pick(1, game.MoveRock)
+	g.Pick(p2, game.MoveScissors)
+	now.Add(11)
+	g.Tick()
+	var started, judged APIEvent
+	for lines.Scan() {
+		event := map[string]*APIEvent{"event: round_started": &started, "event: round_judged": &judged}[lines.Text()]
+		if event != nil && lines.Scan() {
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(lines.Text(), "data: ")), event); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if event == &judged {
+			break
+		}
+	}
+	if started.TimeLeftMs != 10000 || started.TimeLeft != "0:10" {
+		t.Errorf("round_started time left is %d ms, %q; want 10000 ms, 0:10", started.TimeLeftMs, started.TimeLeft)
+	}
+	if want := "R1: P1 ROCK beats P2 SCISSORS | leader: P1 1"; judged.Summary != want {
+		t.Errorf("round_judged summary is %q, want %q", judged.Summary, want)
+	}
+
+	server.Stop()
+	for lines.Scan() { // Until Stop ends the stream, or the test times out.
+	}
+}
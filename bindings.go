@@ -0,0 +1,116 @@
+package vncrps
+
+import (
+	"fmt"
+	"github.com/alltom/vncrps/game"
+	"github.com/alltom/vncrps/rfb"
+	"github.com/alltom/vncrps/rfb/keysym"
+	"unicode"
+)
+
+// InputBindings are one player's keyboard shortcuts and mouse preference. Players change them on the settings screen,
+// which Tab opens, and the game keeps them as game.Bindings, so they're the same after rejoining.
+type InputBindings struct {
+	// X11 keysyms that pick each move. Letters match regardless of case.
+	Keys [game.NumMoves]uint32 // Indexed by game.Move, for every move set.
+
+	// If true, the right mouse button clicks buttons instead of the left, for left-handed mice.
+	SwapMouseButtons bool
+}
+
+var DefaultInputBindings = InputBindings{
+	Keys: [game.NumMoves]uint32{
+		game.MoveRock: 'r', game.MovePaper: 'p', game.MoveScissors: 's',
+		game.MoveLizard: 'l', game.MoveSpock: 'k',
+		game.MoveFire: 'f', game.MoveSponge: 'o', game.MoveAir: 'a', game.MoveWater: 'w',
+	},
+}
+
+// Move returns the move bound to keySym, if any.
+func (b *InputBindings) Move(keySym uint32) (game.Move, bool) {
+	for move, key := range b.Keys {
+		if foldKeySym(key) == foldKeySym(keySym) {
+			return game.Move(move), true
+		}
+	}
+	return 0, false
+}
+
+// MoveForKey is like Move, but if keySym isn't bound and keyCode is set, it tries the keysym that the key with that XT
+// scan code has on a US keyboard. That way the usual R, P, and S keys work on layouts without Latin letters, for
+// clients that send QEMU extended key events.
+func (b *InputBindings) MoveForKey(keySym, keyCode uint32) (game.Move, bool) {
+	if move, ok := b.Move(keySym); ok {
+		return move, true
+	}
+	if usKeySym, ok := usKeySyms[keyCode]; ok {
+		return b.Move(usKeySym)
+	}
+	return 0, false
+}
+
+// Bind makes keySym pick move, unbinding it from any other move.
+func (b *InputBindings) Bind(move game.Move, keySym uint32) {
+	for m := range b.Keys {
+		if foldKeySym(b.Keys[m]) == foldKeySym(keySym) {
+			b.Keys[m] = 0
+		}
+	}
+	b.Keys[move] = keySym
+}
+
+// ButtonMask applies SwapMouseButtons to a pointer event's button mask.
+func (b *InputBindings) ButtonMask(mask uint8) uint8 {
+	if !b.SwapMouseButtons {
+		return mask
+	}
+	left, right := mask&rfb.ButtonLeft, mask&rfb.ButtonRight
+	return mask&^(rfb.ButtonLeft|rfb.ButtonRight) | right>>2 | left<<2
+}
+
+// saveBindings has the game keep the player's bindings, for when they rejoin.
+func (ui *UI) saveBindings() {
+	if ui.server != nil {
+		ui.server.SetBindings(ui.playerId, game.Bindings(ui.bindings))
+	}
+}
+
+// loadBindings gives the player back the bindings the game kept for them, if it kept any.
+func (ui *UI) loadBindings() {
+	if bindings, ok := ui.server.Bindings(ui.playerId); ok {
+		ui.bindings = InputBindings(bindings)
+	}
+}
+
+// usKeySyms maps the XT scan codes of a US keyboard's digit and letter keys to their keysyms.
+var usKeySyms = map[uint32]uint32{}
+
+func init() {
+	// Each row's scan codes are consecutive.
+	for _, row := range []struct {
+		first uint32
+		keys  string
+	}{{0x02, "1234567890"}, {0x10, "qwertyuiop"}, {0x1e, "asdfghjkl"}, {0x2c, "zxcvbnm"}} {
+		for i, key := range row.keys {
+			usKeySyms[row.first+uint32(i)] = uint32(key)
+		}
+	}
+}
+
+// Latin-1 keysyms are the same as their code points.
+func foldKeySym(keySym uint32) uint32 {
+	if keySym < 0x100 {
+		return uint32(unicode.ToLower(rune(keySym)))
+	}
+	return keySym
+}
+
+func keySymName(keySym uint32) string {
+	if keySym == 0 {
+		return "(none)"
+	}
+	if r, ok := keysym.ToRune(keySym); ok && r >= 0x7f {
+		return fmt.Sprintf("0x%x", keySym) // The UI's font only has ASCII.
+	}
+	return keysym.Name(keySym)
+}
@@ -0,0 +1,39 @@
+package vncrps
+
+import (
+	"github.com/alltom/vncrps/game"
+	"testing"
+)
+
+func TestInputBindings(t *testing.T) {
+	b := DefaultInputBindings
+	if move, ok := b.Move('R'); !ok || move != game.MoveRock {
+		t.Errorf("R picks %v, %v; want ROCK", move, ok)
+	}
+
+	const keySymCyrillicKa, keyCodeR, keyCodeP = 0x6cb, 0x13, 0x19
+	if move, ok := b.MoveForKey(keySymCyrillicKa, keyCodeR); !ok || move != game.MoveRock {
+		t.Errorf("the R key picks %v, %v on a Russian layout; want ROCK", move, ok)
+	}
+	if move, ok := b.MoveForKey('r', keyCodeP); !ok || move != game.MoveRock {
+		t.Errorf("r on the P key picks %v, %v; want ROCK", move, ok)
+	}
+
+	b.Bind(game.MovePaper, 'r')
+	if move, ok := b.Move('r'); !ok || move != game.MovePaper {
+		t.Errorf("r picks %v, %v after rebinding; want PAPER", move, ok)
+	}
+	if b.Keys[game.MoveRock] != 0 {
+		t.Errorf("rock is still bound to %s", keySymName(b.Keys[game.MoveRock]))
+	}
+
+	if got := b.ButtonMask(1); got != 1 {
+		t.Errorf("unswapped left button became %d", got)
+	}
+	b.SwapMouseButtons = true
+	for mask, want := range map[uint8]uint8{1: 4, 4: 1, 2: 2, 5: 5, 8: 8} {
+		if got := b.ButtonMask(mask); got != want {
+			t.Errorf("swapped mask %d became %d, want %d", mask, got, want)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+// Command fbsplay replays an FBS recording, such as one the server's -record option wrote, to viewers that connect to
+// it, or saves each frame as a PNG. Each viewer sees the recording from the start, at the speed it was recorded.
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/alltom/vncrps/rfb"
+	"github.com/alltom/vncrps/rfb/fbs"
+	"image"
+	"image/draw"
+	"image/png"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var (
+	path   = flag.String("file", "", "The FBS recording to play.")
+	addr   = flag.String("addr", "127.0.0.1:5900", "Address to serve the recording to viewers on.")
+	speed  = flag.Float64("speed", 1, "How many times faster than real time to play.")
+	loop   = flag.Bool("loop", false, "If set, the recording starts over when it ends, rather than stopping on its last frame.")
+	pngDir = flag.String("png", "", "If set, each frame is saved as a PNG in this directory instead, and no viewers are served.")
+)
+
+func main() {
+	flag.Parse()
+	if *path == "" {
+		log.Fatalf("-file is required")
+	}
+	if *speed <= 0 {
+		log.Fatalf("-speed must be positive")
+	}
+
+	if *pngDir != "" {
+		if err := saveFrames(*path, *pngDir); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	size, err := firstFrameSize(*path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	server := &rfb.Server{
+		Name:   filepath.Base(*path),
+		Width:  size.X,
+		Height: size.Y,
+		MaxFPS: 30,
+		NewHandler: func(conn io.ReadWriter) (rfb.Handler, error) {
+			return newPlayer(*path, size), nil
+		},
+	}
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatalf("listen: %v", err)
+	}
+	log.Printf("playing %v on %v…", *path, ln.Addr())
+	log.Fatal(server.Serve(ln))
+}
+
+// errStop stops decoding early.
+var errStop = errors.New("stop")
+
+// decode calls f with each frame of the recording at path.
+func decode(path string, f func(frame *image.RGBA, timestamp time.Duration) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	r, err := fbs.NewReader(bufio.NewReader(file))
+	if err != nil {
+		return fmt.Errorf("read %v: %v", path, err)
+	}
+	return fbs.DecodeFramesFunc(r, func(frame *image.RGBA) error {
+		return f(frame, r.Timestamp())
+	})
+}
+
+func firstFrameSize(path string) (image.Point, error) {
+	var size image.Point
+	err := decode(path, func(frame *image.RGBA, timestamp time.Duration) error {
+		size = frame.Bounds().Size()
+		return errStop
+	})
+	if err != nil && err != errStop {
+		return image.Point{}, err
+	}
+	if size == (image.Point{}) {
+		return image.Point{}, fmt.Errorf("%v has no frames", path)
+	}
+	return size, nil
+}
+
+func saveFrames(path, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	n := 0
+	err := decode(path, func(frame *image.RGBA, timestamp time.Duration) error {
+		name := filepath.Join(dir, fmt.Sprintf("frame%06d-%dms.png", n, timestamp.Milliseconds()))
+		n++
+		f, err := os.Create(name)
+		if err != nil {
+			return err
+		}
+		if err := png.Encode(f, frame); err != nil {
+			f.Close()
+			return fmt.Errorf("encode %v: %v", name, err)
+		}
+		return f.Close()
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("saved %d frames in %v", n, dir)
+	return nil
+}
+
+// player is one viewer's replay. It implements rfb.Handler, rfb.DesktopSizer, and io.Closer.
+type player struct {
+	lock  sync.Mutex
+	frame *image.RGBA
+	done  chan struct{}
+}
+
+// newPlayer starts playing the recording at path, showing a blank frame of the given size until its first frame.
+func newPlayer(path string, size image.Point) *player {
+	p := &player{frame: image.NewRGBA(image.Rectangle{Max: size}), done: make(chan struct{})}
+	go func() {
+		for {
+			err := p.play(path)
+			if err == errStop {
+				return
+			} else if err != nil {
+				log.Printf("couldn't play %v: %v", path, err)
+				return
+			}
+			if !*loop {
+				return
+			}
+		}
+	}()
+	return p
+}
+
+// play shows each frame of the recording when its time comes, or returns errStop once the viewer disconnects.
+func (p *player) play(path string) error {
+	start := time.Now()
+	return decode(path, func(frame *image.RGBA, timestamp time.Duration) error {
+		select {
+		case <-time.After(time.Until(start.Add(time.Duration(float64(timestamp) / *speed)))):
+		case <-p.done:
+			return errStop
+		}
+		p.lock.Lock()
+		defer p.lock.Unlock()
+		if p.frame.Bounds() != frame.Bounds() {
+			p.frame = image.NewRGBA(frame.Bounds())
+		}
+		copy(p.frame.Pix, frame.Pix)
+		return nil
+	})
+}
+
+func (p *player) Resize(width, height int) {}
+
+func (p *player) Render(img draw.Image, rect image.Rectangle) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	draw.Draw(img, rect, p.frame, rect.Min, draw.Src)
+}
+
+func (p *player) DesktopSize() image.Point {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.frame.Bounds().Size()
+}
+
+func (p *player) KeyEvent(m *rfb.KeyEventMessage)         {}
+func (p *player) PointerEvent(m *rfb.PointerEventMessage) {}
+func (p *player) CutText(text string)                     {}
+
+func (p *player) Close() error {
+	close(p.done)
+	return nil
+}
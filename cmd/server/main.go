@@ -1,190 +1,222 @@
 package main
 
 import (
-	"bufio"
-	"encoding/binary"
+	"context"
+	"crypto/tls"
 	"flag"
-	"fmt"
+	"github.com/alltom/vncrps"
+	"github.com/alltom/vncrps/game"
 	"github.com/alltom/vncrps/rfb"
-	"image"
-	"io"
 	"log"
-	"net"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 )
 
-const maxFPS = 20
+// How long players get to see the goodbye screen on Ctrl-C or SIGTERM before their connections are closed anyway.
+const shutdownTimeout = 5 * time.Second
 
-var addr = flag.String("addr", "127.0.0.1:5900", "Address to listen for connections on.")
+var (
+	addr = flag.String("addr", "127.0.0.1:5900", "Address to listen for connections on, as host:port. Use :5900 or 0.0.0.0:5900 to accept players from other machines, which needs -username and -password.")
+
+	listen = flag.String("listen", "", "Comma-separated addresses to listen for players on too, each host:port or unix:/path for a UNIX socket, such as one a proxy like sslh, websockify, or nginx forwards to.")
+
+	inputLogPath = flag.String("input-log", "", "If set, every player's input is written to this file so sessions can be replayed. See replay_test.go.")
+
+	stateFile = flag.String("state-file", "", "If set, the game's players, ranks, and history are kept in this JSON file, so restarting the server doesn't wipe the rankings.")
+
+	username = flag.String("username", "", "If set with -password, clients must log in: with both using Apple Remote Desktop authentication (macOS Screen Sharing), or with the first 8 characters of the password using VNC authentication (other viewers).")
+	password = flag.String("password", "", "See -username.")
+
+	tlsCert = flag.String("tls-cert", "", "If set with -tls-key, connections are wrapped in TLS using this PEM certificate, for viewers that support it or tunnels that terminate it.")
+	tlsKey  = flag.String("tls-key", "", "See -tls-cert.")
+
+	allowInsecure = flag.Bool("allow-insecure", false, "Start even if the configuration is insecure, such as listening on a public address without -password.")
+
+	roundSummaries = flag.Bool("round-summaries", false, "If set, a summary of each round is copied to every player's clipboard.")
+
+	countdownStyle = flag.String("countdown", "clock", "How time left is shown until players pick another style on the settings screen: clock (0:09) or seconds (9s).")
+
+	exclusive = flag.String("exclusive", "ignore", "What to do when a viewer asks for exclusive access rather than sharing: ignore the request, disconnect everyone else, or refuse the viewer while others are playing.")
+
+	adminSocket = flag.String("admin-socket", "", "If set, the admin API is served on a UNIX socket at this path, for use with vncrpsctl.")
+
+	webSocketAddr = flag.String("websocket", "", "If set, browser viewers such as noVNC can connect over WebSocket on this address too, such as 127.0.0.1:5800.")
+
+	webSocketOrigins = flag.String("websocket-origins", "", "Comma-separated origins of pages besides the server's own that can connect over WebSocket, such as https://novnc.example.com, or * for any.")
+
+	httpAddr = flag.String("http", "", "If set, a page for playing in the browser with noVNC is served on this address, such as 127.0.0.1:8080, so players only need a URL.")
+
+	sharePort = flag.Bool("share-port", false, "If set, the browser viewer is served on -addr instead of -http, for when only one port can be exposed. Viewers connect a fraction of a second slower.")
+
+	connect = flag.String("connect", "", "Comma-separated viewers listening for reverse connections (host or host:port, port 5500 by default) to connect to on startup, such as viewers behind firewalls.")
+
+	snapshotFile = flag.String("snapshot-file", "", "If set, a spectator's view of the game is kept up to date in a memory-mapped file at this path, for local tools like streaming software. See vncrpssnap.")
+
+	recordDir = flag.String("record", "", "If set, everything each player is sent is recorded in this directory as an FBS file, for replaying in tools like rfbproxy.")
+
+	keepAlive = flag.Duration("keepalive", 30*time.Second, "How long a player's viewer may go quiet before it's checked on, and then has to answer, so players who close their laptops don't keep their places.")
+
+	spectatorAddr = flag.String("spectator-addr", "", "If set, viewers that connect on this address, such as 127.0.0.1:5901, watch the game instead of playing: every matchup, who has picked, and all the rankings.")
+	consoleAddr   = flag.String("console-addr", "", "If set, viewers that connect on this address, such as 127.0.0.1:5902, get an admin console for kicking and renaming players and starting rounds. Only loopback addresses are allowed unless -allow-insecure is set.")
+
+	rooms = flag.Bool("rooms", false, "If set, players start in a lobby where they can make rooms and join them, each its own game with its own rankings.")
+
+	fps = flag.Int("fps", 20, "How many frames a second players are sent at most. Players on links too slow to keep up are sent fewer, down to one a second.")
+
+	maxConnsPerIP    = flag.Int("max-conns-per-ip", 0, "If set, connections from an address that already has this many open are closed right away.")
+	handshakeBackoff = flag.Duration("handshake-backoff", 0, "If set, connections from an address whose login failed are closed right away for this long, doubling with each failure in a row up to an hour.")
+	banFile          = flag.String("ban-file", "", "If set, connections from the addresses and networks listed in this file (one per line, such as 203.0.113.7 or 203.0.113.0/24) are closed right away. vncrpsctl ban and the admin console add to it.")
+
+	maxPlayers = flag.Int("max-players", 0, "If set, viewers that connect while this many people are playing are shown that the game is full instead of joining.")
+
+	pickDuration   = flag.Duration("pick-duration", game.DefaultPickDuration, "How long players have to pick their moves each round.")
+	reviewDuration = flag.Duration("review-duration", game.DefaultReviewDuration, "How long each round's results are shown before the next round starts.")
+
+	bestOf = flag.Int("best-of", 1, "How many games each matchup plays, such as 3 or 5, won by whoever wins most of them. Must be odd.")
+	swiss  = flag.Bool("swiss", false, "If set, players face others with similar ranks who they haven't played yet, instead of whoever they're paired with at random.")
+	moves  = flag.String("moves", "rps", "The moves players pick from: \"rps\" for rock, paper, and scissors, \"rpsls\" to add lizard and Spock, or \"rps7\" to add fire, sponge, air, and water.")
+
+	suddenDeath = flag.Duration("sudden-death", 0, "If set, players who pick the same move pick again straight away, with at least this long to, such as 5s, until someone wins or the round ends.")
+
+	bots = flag.String("bots", "", "If set, a bot plays whenever an odd number of people are, so nobody sits out: \"random\" picks moves at random, and \"counter\" beats each opponent's favorite move.")
+
+	metricsAddr = flag.String("metrics-addr", "", "If set, Prometheus metrics about the game and players' connections are served at /metrics on this address, such as 127.0.0.1:9100, and a health check at /healthz.")
+	apiAddr     = flag.String("api-addr", "", "If set, a read-only JSON API for scoreboards (/state, /players, /matchups, /rankings) and a feed of game events (/events) are served on this address, such as 127.0.0.1:8081.")
+
+	debugAddr = flag.String("debug-addr", "", "If set, Go's profiler is served at /debug/pprof/ on this address, such as 127.0.0.1:6060, for capturing CPU and heap profiles with go tool pprof.")
+
+	logJSON = flag.Bool("log-json", false, "If set, logs are written as JSON, one object per line, for log collectors. Lines about a player's connection are tagged with conn, remote, and player either way.")
+
+	trace = flag.Bool("trace", false, "If set, every message to and from players is logged, for debugging viewers that don't work.")
+
+	sshHost     = flag.String("ssh-host", "", "Enables SSH tunnel mode: -addr must be a loopback address, and players are told to forward a port through this SSH host (host or host:port).")
+	sshPlayers  = flag.String("ssh-players", "", "Comma-separated SSH logins to print tunnel commands for in SSH tunnel mode.")
+	sshJumpHost = flag.String("ssh-jump-host", "", "Enables SSH tunnel mode with an automatic reverse tunnel to this jump host (user@host or user@host:port).")
+	sshJumpPort = flag.Int("ssh-jump-port", 5900, "Port on the jump host's loopback interface that the reverse tunnel listens on.")
+)
 
 func main() {
 	flag.Parse()
+	if *logJSON {
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, nil)))
+	}
 
-	gameServer := NewGameServer(time.Now)
+	countdowns, err := vncrps.ParseCountdownStyle(*countdownStyle)
+	if err != nil {
+		log.Fatalf("invalid -countdown: %v", err)
+	}
 
-	ln, err := net.Listen("tcp", *addr)
+	sharePolicy, err := rfb.ParseSharePolicy(*exclusive)
 	if err != nil {
-		log.Fatalf("couldn't listen: %v", err)
+		log.Fatalf("invalid -exclusive: %v", err)
 	}
-	log.Print("listening…")
-	for {
-		conn, err := ln.Accept()
+
+	config := vncrps.Config{
+		Addr:     *addr,
+		Username: *username,
+		Password: *password,
+
+		AllowInsecure: *allowInsecure,
+
+		MaxConnsPerIP:    *maxConnsPerIP,
+		HandshakeBackoff: *handshakeBackoff,
+		BanFile:          *banFile,
+
+		RoundSummaries: *roundSummaries,
+		CountdownStyle: countdowns,
+		SharePolicy:    sharePolicy,
+		AdminSocket:    *adminSocket,
+		SnapshotFile:   *snapshotFile,
+		RecordDir:      *recordDir,
+		Trace:          *trace,
+		KeepAlive:      *keepAlive,
+		MaxPlayers:     *maxPlayers,
+		Bots:           *bots,
+		PickDuration:   *pickDuration,
+		ReviewDuration: *reviewDuration,
+		BestOf:         *bestOf,
+		Swiss:          *swiss,
+		Moves:          *moves,
+		SuddenDeath:    *suddenDeath,
+		FPS:            *fps,
+		Rooms:          *rooms,
+		SpectatorAddr:  *spectatorAddr,
+		ConsoleAddr:    *consoleAddr,
+		MetricsAddr:    *metricsAddr,
+		APIAddr:        *apiAddr,
+		DebugAddr:      *debugAddr,
+		WebSocketAddr:  *webSocketAddr,
+		HTTPAddr:       *httpAddr,
+		SharePort:      *sharePort,
+	}
+	if *tlsCert != "" || *tlsKey != "" {
+		cert, err := tls.LoadX509KeyPair(*tlsCert, *tlsKey)
 		if err != nil {
-			log.Fatalf("couldn't accept connection: %v", err)
+			log.Fatalf("couldn't load TLS certificate: %v", err)
 		}
-		log.Print("accepted connection")
-		go func(conn net.Conn) {
-			if err := rfbServe(conn, gameServer); err != nil {
-				log.Printf("serve failed: %v", err)
-			}
-			if err := conn.Close(); err != nil {
-				log.Printf("couldn't close connection: %v", err)
-			}
-		}(conn)
+		config.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
 	}
-}
-
-func rfbServe(conn io.ReadWriter, gameServer *GameServer) error {
-	var bo = binary.BigEndian
-	var pixelFormat = rfb.PixelFormat{
-		BitsPerPixel: 32,
-		BitDepth:     24,
-		BigEndian:    true,
-		TrueColor:    true,
-
-		RedMax:     255,
-		GreenMax:   255,
-		BlueMax:    255,
-		RedShift:   24,
-		GreenShift: 16,
-		BlueShift:  8,
+	if *listen != "" {
+		config.Listen = strings.Split(*listen, ",")
 	}
-	protocolVersion := rfb.ProtocolVersionMessage{3, 3}
-	authScheme := rfb.AuthenticationSchemeMessageRFB33{rfb.AuthenticationSchemeVNC}
-	var authChallenge rfb.VNCAuthenticationChallengeMessage
-	var authResponse rfb.VNCAuthenticationResponseMessage
-	authResult := rfb.VNCAuthenticationResultMessage{rfb.VNCAuthenticationResultOK}
-	var clientInit rfb.ClientInitialisationMessage
-	var serverInit rfb.ServerInitialisationMessage
-	var keyEvent rfb.KeyEventMessage
-	var pointerEvent rfb.PointerEventMessage
-
-	if err := protocolVersion.Write(conn); err != nil {
-		return fmt.Errorf("write ProtocolVersion: %v", err)
+	if *connect != "" {
+		config.Connect = strings.Split(*connect, ",")
 	}
-	if err := protocolVersion.Read(conn); err != nil {
-		return fmt.Errorf("read ProtocolVersion: %v", err)
-	}
-	if protocolVersion.Major != 3 || protocolVersion.Minor != 3 {
-		return fmt.Errorf("only version 3.3 is supported, but client requested %d.%d", protocolVersion.Major, protocolVersion.Minor)
+	if *webSocketOrigins != "" {
+		config.WebSocketOrigins = strings.Split(*webSocketOrigins, ",")
 	}
 
-	// Using VNC authentication because the built-in macOS client won't connect otherwise. Accepts any password.
-	if err := authScheme.Write(conn, bo); err != nil {
-		return fmt.Errorf("write VNC auth scheme: %v", err)
-	}
-	// Send empty challenge
-	if err := authChallenge.Write(conn); err != nil {
-		return fmt.Errorf("write VNC auth challenge: %v", err)
-	}
-	if err := authResponse.Read(conn); err != nil {
-		return fmt.Errorf("read VNC auth response: %v", err)
+	if *sshHost != "" || *sshJumpHost != "" {
+		config.Tunnel = &vncrps.TunnelHelper{
+			ListenAddr:     *addr,
+			SSHHost:        *sshHost,
+			JumpHost:       *sshJumpHost,
+			JumpRemotePort: *sshJumpPort,
+		}
+		if *sshPlayers != "" {
+			config.Tunnel.Players = strings.Split(*sshPlayers, ",")
+		}
 	}
-	// Always OK
-	if err := authResult.Write(conn, bo); err != nil {
-		return fmt.Errorf("write VNC auth result: %v", err)
+
+	if *inputLogPath != "" {
+		f, err := os.OpenFile(*inputLogPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			log.Fatalf("couldn't open input log: %v", err)
+		}
+		defer f.Close()
+		config.InputLog = f
 	}
 
-	if err := clientInit.Read(conn); err != nil {
-		return fmt.Errorf("read ClientInitialisation: %v", err)
+	if *stateFile != "" {
+		config.Store = &vncrps.FileStore{Path: *stateFile}
 	}
 
-	serverInit = rfb.ServerInitialisationMessage{
-		FramebufferWidth:  uint16(UIWidth),
-		FramebufferHeight: uint16(UIHeight),
-		PixelFormat:       pixelFormat,
-		Name:              "RPS",
+	server, err := vncrps.NewServer(config)
+	if err != nil {
+		log.Fatal(err)
 	}
-	if err := serverInit.Write(conn, bo); err != nil {
-		return fmt.Errorf("write ServerInitialisation: %v", err)
+	if err := server.Start(); err != nil {
+		log.Fatal(err)
 	}
-
-	ui := NewUI(gameServer)
-	defer ui.Close()
-
-	var nextFrameTime time.Time
-
-	r := bufio.NewReader(conn)
-	w := bufio.NewWriter(conn)
-
-	for {
-		messageType, err := r.Peek(1)
-		if err != nil {
-			return fmt.Errorf("read message type: %v", err)
-		}
-		switch messageType[0] {
-		case 0: // SetPixelFormat
-			var m rfb.SetPixelFormatMessage
-			if err := m.Read(r, bo); err != nil {
-				return fmt.Errorf("read SetPixelFormat: %v", err)
-			}
-			pixelFormat = m.PixelFormat
-
-		case 2: // SetEncodings
-			var m rfb.SetEncodingsMessage
-			if err := m.Read(r, bo); err != nil {
-				return fmt.Errorf("read SetEncodings: %v", err)
-			}
-			// Nothing to do.
-
-		case 3: // FramebufferUpdateRequest
-			var m rfb.FramebufferUpdateRequestMessage
-			if err := m.Read(r, bo); err != nil {
-				return fmt.Errorf("read FramebufferUpdateRequest: %v", err)
-			}
-
-			var update rfb.FramebufferUpdateMessage
-			img := rfb.NewPixelFormatImage(pixelFormat, image.Rect(int(m.X), int(m.Y), int(m.X)+int(m.Width), int(m.Y)+int(m.Height)))
-			ui.Update(img, &keyEvent, &pointerEvent)
-			update.Rectangles = []*rfb.FramebufferUpdateRect{
-				&rfb.FramebufferUpdateRect{
-					X: m.X, Y: m.Y, Width: m.Width, Height: m.Height,
-					EncodingType: 0, PixelData: img.Pix,
-				},
-			}
-
-			<-time.After(nextFrameTime.Sub(time.Now()))
-			if err := update.Write(w, bo); err != nil {
-				return fmt.Errorf("write FramebufferUpdate: %v", err)
-			}
-			if err := w.Flush(); err != nil {
-				return fmt.Errorf("flush FramebufferUpdate: %v", err)
-			}
-			nextFrameTime = time.Now().Add(time.Second / maxFPS)
-
-		case 4: // KeyEvent
-			if err := keyEvent.Read(r, bo); err != nil {
-				return fmt.Errorf("read KeyEvent: %v", err)
-			}
-			ui.Update(image.NewNRGBA(image.ZR), &keyEvent, &pointerEvent)
-
-		case 5: // PointerEvent
-			if err := pointerEvent.Read(r, bo); err != nil {
-				return fmt.Errorf("read PointerEvent: %v", err)
-			}
-			ui.Update(image.NewNRGBA(image.ZR), &keyEvent, &pointerEvent)
-
-		case 6: // ClientCutText
-			var m rfb.ClientCutTextMessage
-			if err := m.Read(r, bo); err != nil {
-				return fmt.Errorf("read ClientCutText: %v", err)
-			}
-			// Ignore.
-
-		default:
-			return fmt.Errorf("received unrecognized message type %d", messageType[0])
-		}
+	stopped := make(chan error, 1)
+	go func() { stopped <- server.Wait() }()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	select {
+	case err := <-stopped:
+		log.Fatalf("server stopped: %v", err)
+	case <-signals:
 	}
+	signal.Stop(signals) // So a second Ctrl-C kills the server right away.
 
-	return nil
+	slog.Info("shutting down")
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Warn("couldn't shut down cleanly", "err", err)
+	}
 }
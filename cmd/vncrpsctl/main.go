@@ -0,0 +1,248 @@
+// Command vncrpsctl controls a running server through its admin API. Start the server with -admin-socket, then:
+//
+//	vncrpsctl -socket PATH players
+//	vncrpsctl -socket PATH standings [json|csv]
+//	vncrpsctl -socket PATH kick PLAYER_ID
+//	vncrpsctl -socket PATH announce MESSAGE...
+//	vncrpsctl -socket PATH security
+//	vncrpsctl -socket PATH screenshot PLAYER_ID > screen.png
+//	vncrpsctl -socket PATH bans
+//	vncrpsctl -socket PATH ban ADDRESS|NETWORK
+//	vncrpsctl -socket PATH unban ADDRESS|NETWORK
+//	vncrpsctl -socket PATH reload
+//	vncrpsctl -socket PATH tunnels
+//	vncrpsctl -socket PATH rooms
+//	vncrpsctl -socket PATH start [ROOM]
+//
+// With -room NAME, players, standings, kick, and screenshot act on that room instead of Main.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/alltom/vncrps"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+var (
+	socket = flag.String("socket", "", "Path to the server's admin socket (its -admin-socket).")
+	room   = flag.String("room", "", "The room players, standings, kick, and screenshot act on, such as \"Room 2\". Defaults to Main.")
+)
+
+func main() {
+	log.SetFlags(0)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s -socket PATH [-room NAME] players | standings [json|csv] | kick PLAYER_ID | announce MESSAGE... | security | screenshot PLAYER_ID | bans | ban ADDRESS | unban ADDRESS | reload | tunnels | rooms | start [ROOM]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *socket == "" || flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", *socket)
+		},
+	}}
+
+	args := flag.Args()
+	var err error
+	switch args[0] {
+	case "players":
+		err = players(client)
+	case "standings":
+		format := "csv"
+		if len(args) > 1 {
+			format = args[1]
+		}
+		err = get(client, "/standings?format="+url.QueryEscape(format)+inRoom(), os.Stdout)
+	case "kick":
+		if len(args) != 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		err = post(client, "/kick?player="+url.QueryEscape(args[1])+inRoom(), "")
+	case "announce":
+		if len(args) < 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		err = post(client, "/announce", strings.Join(args[1:], " "))
+	case "security":
+		err = security(client)
+	case "screenshot":
+		if len(args) != 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		err = get(client, "/screenshot?player="+url.QueryEscape(args[1])+inRoom(), os.Stdout)
+	case "bans":
+		err = bans(client)
+	case "ban", "unban":
+		if len(args) != 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		err = post(client, "/"+args[0]+"?addr="+url.QueryEscape(args[1]), "")
+	case "reload":
+		err = post(client, "/reload", "")
+	case "tunnels":
+		err = tunnels(client)
+	case "rooms":
+		err = rooms(client)
+	case "start":
+		if len(args) > 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		path := "/start"
+		if len(args) == 2 {
+			path += "?room=" + url.QueryEscape(args[1])
+		}
+		err = post(client, path, "")
+	default:
+		log.Printf("unrecognized command %q", args[0])
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func players(client *http.Client) error {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(get(client, "/players?"+strings.TrimPrefix(inRoom(), "&"), pw))
+	}()
+	var players []vncrps.AdminPlayer
+	if err := json.NewDecoder(pr).Decode(&players); err != nil {
+		return fmt.Errorf("decode players: %v", err)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tRANK\tRATING\tW-L-D\tFORFEITS\tSTATUS\tSENT\tRECEIVED\tFPS\tENCODING\tLATENCY")
+	for _, p := range players {
+		if p.Disconnected {
+			fmt.Fprintf(tw, "%d\t%s\t%d\t%d\t%d-%d-%d\t%d\tdisconnected\t\t\t\t\t\n", p.Id, p.Name, p.Rank, p.Rating, p.Wins, p.Losses, p.Draws, p.Forfeits)
+			continue
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%d\t%d\t%d-%d-%d\t%d\tconnected\t%d KiB\t%d KiB\t%.1f\t%s\t%.0fms\n", p.Id, p.Name, p.Rank, p.Rating, p.Wins, p.Losses, p.Draws, p.Forfeits,
+			p.BytesSent/1024, p.BytesReceived/1024, p.FPS, p.Encoding, p.UpdateLatencyMs)
+	}
+	return tw.Flush()
+}
+
+// inRoom returns the query parameter for -room to add to a path that already has one, or "" without -room.
+func inRoom() string {
+	if *room == "" {
+		return ""
+	}
+	return "&room=" + url.QueryEscape(*room)
+}
+
+func rooms(client *http.Client) error {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(get(client, "/rooms", pw))
+	}()
+	var rooms []vncrps.AdminRoom
+	if err := json.NewDecoder(pr).Decode(&rooms); err != nil {
+		return fmt.Errorf("decode rooms: %v", err)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tPLAYERS\tPHASE\tROUND")
+	for _, room := range rooms {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%d\n", room.Name, room.Players, room.Phase, room.Round)
+	}
+	return tw.Flush()
+}
+
+func security(client *http.Client) error {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(get(client, "/security", pw))
+	}()
+	var findings []vncrps.SecurityFinding
+	if err := json.NewDecoder(pr).Decode(&findings); err != nil {
+		return fmt.Errorf("decode security findings: %v", err)
+	}
+	for _, f := range findings {
+		fmt.Println(f)
+	}
+	return nil
+}
+
+func bans(client *http.Client) error {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(get(client, "/bans", pw))
+	}()
+	var bans []string
+	if err := json.NewDecoder(pr).Decode(&bans); err != nil {
+		return fmt.Errorf("decode bans: %v", err)
+	}
+	for _, ban := range bans {
+		fmt.Println(ban)
+	}
+	return nil
+}
+
+func tunnels(client *http.Client) error {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(get(client, "/tunnels", pw))
+	}()
+	var commands []string
+	if err := json.NewDecoder(pr).Decode(&commands); err != nil {
+		return fmt.Errorf("decode tunnel commands: %v", err)
+	}
+	for _, command := range commands {
+		fmt.Println(command)
+	}
+	return nil
+}
+
+func get(client *http.Client, path string, w io.Writer) error {
+	resp, err := client.Get("http://vncrps" + path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return err
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+func post(client *http.Client, path, body string) error {
+	resp, err := client.Post("http://vncrps"+path, "text/plain; charset=utf-8", strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkResponse(resp)
+}
+
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	message, _ := ioutil.ReadAll(resp.Body)
+	return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(message)))
+}
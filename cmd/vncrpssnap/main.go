@@ -0,0 +1,92 @@
+// Command vncrpssnap saves the latest frame from a server's -snapshot-file as a PNG. It's also an example of reading
+// the file from another program; see vncrps.SnapshotFile for the layout.
+package main
+
+import (
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"image"
+	"image/png"
+	"log"
+	"os"
+	"time"
+)
+
+var (
+	snapshotPath = flag.String("file", "", "The server's -snapshot-file.")
+	outPath      = flag.String("o", "", "Where to write the PNG. Defaults to stdout.")
+)
+
+func main() {
+	flag.Parse()
+	if *snapshotPath == "" {
+		log.Fatalf("-file is required")
+	}
+
+	f, err := os.Open(*snapshotPath)
+	if err != nil {
+		log.Fatalf("open snapshot: %v", err)
+	}
+	defer f.Close()
+
+	var img *image.RGBA
+	for attempt := 0; img == nil; attempt++ {
+		if attempt == 100 {
+			log.Fatalf("couldn't read a complete frame; is the file being written?")
+		} else if attempt > 0 {
+			time.Sleep(time.Millisecond)
+		}
+		if img, err = readFrame(f); err != nil {
+			log.Fatalf("read snapshot: %v", err)
+		}
+	}
+
+	out := os.Stdout
+	if *outPath != "" {
+		if out, err = os.Create(*outPath); err != nil {
+			log.Fatalf("create %v: %v", *outPath, err)
+		}
+	}
+	if err := png.Encode(out, img); err != nil {
+		log.Fatalf("encode PNG: %v", err)
+	}
+	if err := out.Close(); err != nil {
+		log.Fatalf("write PNG: %v", err)
+	}
+}
+
+// readFrame returns the current frame, or nil if it was being written and should be read again.
+func readFrame(f *os.File) (*image.RGBA, error) {
+	var header [32]byte
+	if _, err := f.ReadAt(header[:], 0); err != nil {
+		return nil, fmt.Errorf("read header: %v", err)
+	}
+	if string(header[0:8]) != "VRPSNAP1" {
+		return nil, fmt.Errorf("not a snapshot file")
+	}
+	width := int(binary.LittleEndian.Uint32(header[8:]))
+	height := int(binary.LittleEndian.Uint32(header[12:]))
+	stride := int(binary.LittleEndian.Uint32(header[16:]))
+	if format := binary.LittleEndian.Uint32(header[20:]); format != 0 {
+		return nil, fmt.Errorf("unsupported pixel format %d", format)
+	}
+	sequence := binary.LittleEndian.Uint64(header[24:])
+	if sequence%2 != 0 {
+		return nil, nil
+	}
+
+	img := &image.RGBA{Pix: make([]uint8, stride*height), Stride: stride, Rect: image.Rect(0, 0, width, height)}
+	if _, err := f.ReadAt(img.Pix, 32); err != nil {
+		return nil, fmt.Errorf("read pixels: %v", err)
+	}
+
+	var after [8]byte
+	if _, err := f.ReadAt(after[:], 24); err != nil {
+		return nil, fmt.Errorf("read header: %v", err)
+	}
+	if binary.LittleEndian.Uint64(after[:]) != sequence {
+		return nil, nil
+	}
+	return img, nil
+}
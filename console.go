@@ -0,0 +1,245 @@
+package vncrps
+
+import (
+	"fmt"
+	"github.com/alltom/vncrps/game"
+	"github.com/alltom/vncrps/rfb"
+	"github.com/alltom/vncrps/rfb/keysym"
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// The console is wider than a player's UI to fit each player's connection and move on one row with their buttons.
+const (
+	consoleWidth      = 608
+	consoleRowHeight  = 28
+	consoleTop        = 40 // Where the first player's row starts, below the phase and the start round button.
+	consoleLineHeight = 20 // For lines of text without buttons, such as tunnel commands.
+)
+
+// console is the admin console a viewer that connected on Config.ConsoleAddr sees: every player in the game Game
+// returns, whether they're connected, and what they've picked this round, with buttons to kick, ban, or rename them
+// and to start a round, and the commands players run to tunnel in with Config.Tunnel. It implements rfb.Handler.
+type console struct {
+	server *Server
+	size   image.Point
+
+	pointer  rfb.PointerEventMessage
+	buttons  []consoleButton // Where Render last drew buttons.
+	pressing string          // The button the pointer went down on, until it comes up.
+
+	renaming  game.PlayerId // The player whose name is being typed, or zero.
+	typedName string
+	message   string // The result of the last action.
+
+	closing bool
+}
+
+// consoleButton is a button on the console and what clicking it does.
+type consoleButton struct {
+	id    string // Tells buttons apart between frames, such as "kick 3".
+	rect  image.Rectangle
+	click func()
+}
+
+func newConsole(s *Server) *console {
+	return &console{server: s, size: image.Pt(consoleWidth, UIHeight)}
+}
+
+func (c *console) Resize(width, height int) {
+	c.size = image.Pt(width, height)
+}
+
+// DesktopSize grows the framebuffer to fit a row for every player and a line for every tunnel command.
+func (c *console) DesktopSize() image.Point {
+	size := image.Pt(consoleWidth, UIHeight)
+	height := consoleTop + len(c.server.game.Standings())*consoleRowHeight + 40
+	if commands := c.server.TunnelCommands(); len(commands) > 0 {
+		height += consoleRowHeight + len(commands)*consoleLineHeight
+	}
+	if height > size.Y {
+		size.Y = height
+	}
+	if size.Y > maxUISize {
+		size.Y = maxUISize
+	}
+	return size
+}
+
+func (c *console) Render(img draw.Image, rect image.Rectangle) {
+	draw.Draw(img, img.Bounds(), image.White, image.ZP, draw.Src)
+	c.buttons = c.buttons[:0]
+	if c.closing {
+		label("THE SERVER IS SHUTTING DOWN", image.Rect(8, 8, c.size.X-8, 24), img)
+		return
+	}
+
+	g := c.server.game
+	state := g.Overview()
+	heading := "ADMIN CONSOLE: waiting for players"
+	if state.Phase != game.PhaseWaiting {
+		heading = fmt.Sprintf("ADMIN CONSOLE: round %d, %s, %s left", g.Counters().Rounds, state.Phase, CountdownClock.Format(state.TimeLeftInPhase))
+	}
+	label(heading, image.Rect(8, 8, c.size.X-124, 24), img)
+	c.button(img, "start", "start round", image.Rect(c.size.X-108, 4, c.size.X-8, 28), func() {
+		if err := g.StartRound(); err != nil {
+			c.message = fmt.Sprintf("Couldn't start a round: %v.", err)
+			return
+		}
+		c.message = "Started a round."
+	})
+
+	moves := map[game.PlayerId]string{}
+	for _, m := range g.Matchups() {
+		for i, player := range m.Players {
+			moves[player.PlayerId] = fmt.Sprintf("%s vs %s", consoleMove(m.Moves[i]), m.Players[1-i].Name)
+		}
+	}
+
+	y := consoleTop
+	for _, player := range state.Rankings {
+		if y+consoleRowHeight > c.size.Y-32 {
+			break
+		}
+		player := player
+		name := player.Name
+		if c.renaming == player.PlayerId {
+			name = c.typedName + "_"
+		}
+		label(name, image.Rect(8, y+8, 136, y+24), img)
+		label(fmt.Sprintf("%d", player.Rank), image.Rect(144, y+8, 176, y+24), img)
+		label(c.connection(player), image.Rect(184, y+8, 304, y+24), img)
+		label(moves[player.PlayerId], image.Rect(312, y+8, c.size.X-184, y+24), img)
+		c.button(img, fmt.Sprint("ban ", player.PlayerId), "ban", image.Rect(c.size.X-176, y, c.size.X-136, y+24), func() {
+			addr, err := c.server.PlayerAddr(player.PlayerId)
+			if err == nil {
+				err = c.server.Ban(addr.String())
+			}
+			if err != nil {
+				c.message = fmt.Sprintf("Couldn't ban %s: %v.", player.Name, err)
+				return
+			}
+			c.message = fmt.Sprintf("Banned %s at %v.", player.Name, addr)
+		})
+		c.button(img, fmt.Sprint("kick ", player.PlayerId), "kick", image.Rect(c.size.X-128, y, c.size.X-80, y+24), func() {
+			if err := c.server.Kick(c.server.game, player.PlayerId); err != nil {
+				c.message = fmt.Sprintf("Couldn't kick %s: %v.", player.Name, err)
+				return
+			}
+			c.message = fmt.Sprintf("Kicked %s.", player.Name)
+		})
+		c.button(img, fmt.Sprint("rename ", player.PlayerId), "rename", image.Rect(c.size.X-72, y, c.size.X-8, y+24), func() {
+			c.renaming, c.typedName = player.PlayerId, player.Name
+			c.message = "Type a name and press Return, or Escape to leave it."
+		})
+		y += consoleRowHeight
+	}
+	if len(state.Rankings) == 0 {
+		label("Nobody is playing.", image.Rect(8, y+8, c.size.X-8, y+24), img)
+		y += consoleRowHeight
+	}
+
+	if commands := c.server.TunnelCommands(); len(commands) > 0 && y+consoleRowHeight <= c.size.Y-32 {
+		label("PLAYERS TUNNEL IN WITH:", image.Rect(8, y+8, c.size.X-8, y+24), img)
+		y += consoleRowHeight
+		for _, command := range commands {
+			if y+consoleLineHeight > c.size.Y-32 {
+				break
+			}
+			label(command, image.Rect(8, y, c.size.X-8, y+16), img)
+			y += consoleLineHeight
+		}
+	}
+
+	if c.message != "" {
+		label(c.message, image.Rect(8, c.size.Y-24, c.size.X-8, c.size.Y-8), img)
+	}
+}
+
+// connection describes a player's connection, such as "connected, 12ms" with how long their last update took.
+func (c *console) connection(player game.PlayerInfo) string {
+	if player.Disconnected {
+		return "left"
+	}
+	stats, err := c.server.PlayerStats(c.server.game, player.PlayerId)
+	if err != nil {
+		return "no viewer"
+	}
+	return fmt.Sprintf("connected, %dms", stats.UpdateLatency.Milliseconds())
+}
+
+// consoleMove names a move picked this round, or shows that none has been.
+func consoleMove(move *game.Move) string {
+	if move == nil {
+		return "..."
+	}
+	return move.String()
+}
+
+// button draws a button, which does click when the pointer goes down and comes back up on it.
+func (c *console) button(img draw.Image, id, text string, rect image.Rectangle, click func()) {
+	c.buttons = append(c.buttons, consoleButton{id, rect, click})
+	var fill color.Color = primaryColor
+	if image.Pt(int(c.pointer.X), int(c.pointer.Y)).In(rect) {
+		fill = primaryLightColor
+		if c.pressing == id {
+			fill = color.Black
+		}
+	}
+	drawButton(text, rect, fill, img)
+}
+
+// Changed reports when the game changes. The console also changes when the admin sends input.
+func (c *console) Changed() <-chan struct{} {
+	return c.server.game.Changed()
+}
+
+// ServerClosing makes the last frame say the server is going away.
+func (c *console) ServerClosing() {
+	c.closing = true
+}
+
+func (c *console) KeyEvent(m *rfb.KeyEventMessage) {
+	if !m.Pressed || c.renaming == 0 {
+		return
+	}
+	switch {
+	case m.KeySym == keysym.Escape:
+		c.renaming, c.message = 0, ""
+	case m.KeySym == keysym.BackSpace:
+		if c.typedName != "" {
+			c.typedName = c.typedName[:len(c.typedName)-1]
+		}
+	case m.KeySym == keysym.Return:
+		if err := c.server.game.Rename(c.renaming, c.typedName); err != nil {
+			c.message = fmt.Sprintf("Couldn't rename: %v.", err)
+			return
+		}
+		c.renaming, c.message = 0, fmt.Sprintf("Renamed to %s.", c.typedName)
+	case ' ' <= m.KeySym && m.KeySym <= '~' && len(c.typedName) < game.MaxNameLength:
+		c.typedName += string(rune(m.KeySym))
+	}
+}
+
+func (c *console) PointerEvent(m *rfb.PointerEventMessage) {
+	down := m.ButtonMask&rfb.ButtonLeft != 0
+	wasDown := c.pointer.ButtonMask&rfb.ButtonLeft != 0
+	c.pointer = *m
+	at := image.Pt(int(m.X), int(m.Y))
+	for _, b := range c.buttons {
+		if !at.In(b.rect) {
+			continue
+		}
+		if down && !wasDown {
+			c.pressing = b.id
+		} else if !down && wasDown && c.pressing == b.id {
+			b.click()
+		}
+	}
+	if !down {
+		c.pressing = ""
+	}
+}
+
+func (c *console) CutText(text string) {}
@@ -0,0 +1,63 @@
+package vncrps
+
+import (
+	"github.com/alltom/vncrps/rfb"
+	"github.com/alltom/vncrps/rfb/keysym"
+	"image"
+	"testing"
+)
+
+func TestConsoleRenameAndStartRound(t *testing.T) {
+	server, err := NewServer(Config{Addr: "127.0.0.1:0", Seed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Game().AddPlayer()
+	c := newConsole(server)
+	c.Render(image.NewRGBA(image.Rect(0, 0, consoleWidth, UIHeight)), image.Rect(0, 0, consoleWidth, UIHeight))
+	click := func(x, y int) {
+		c.PointerEvent(&rfb.PointerEventMessage{ButtonMask: rfb.ButtonLeft, X: uint16(x), Y: uint16(y)})
+		c.PointerEvent(&rfb.PointerEventMessage{X: uint16(x), Y: uint16(y)})
+	}
+	typeKey := func(keySym uint32) {
+		c.KeyEvent(&rfb.KeyEventMessage{Pressed: true, KeySym: keySym})
+	}
+
+	click(consoleWidth-40, consoleTop+8) // The first player's rename button.
+	typeKey(keysym.BackSpace)
+	typeKey('!')
+	typeKey(keysym.Return)
+	if name := server.Game().Standings()[0].Name; name != "P!" {
+		t.Errorf("renamed P1 to %q, want P!", name)
+	}
+
+	click(consoleWidth-50, 16) // The start round button.
+	if c.message == "Started a round." {
+		t.Error("started a round with one player")
+	}
+	server.Game().AddPlayer() // Starts round 1.
+	click(consoleWidth-50, 16)
+	if rounds := server.Game().Counters().Rounds; rounds != 2 {
+		t.Errorf("%d rounds started after clicking start round during round 1, want 2", rounds)
+	}
+}
+
+func TestConsoleTunnelCommands(t *testing.T) {
+	server, err := NewServer(Config{
+		Addr:   "127.0.0.1:0",
+		Tunnel: &TunnelHelper{ListenAddr: "127.0.0.1:5900", SSHHost: "rps.example.com", Players: []string{"alice", "bob"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newConsole(server)
+	size := c.DesktopSize()
+	img := image.NewRGBA(image.Rect(0, 0, size.X, size.Y))
+	c.Render(img, img.Rect)
+	y := consoleTop + consoleRowHeight + consoleRowHeight // Below "Nobody is playing." and the heading.
+	for i := 0; i < 2; i++ {
+		if line := image.Rect(8, y+i*consoleLineHeight, size.X-8, y+i*consoleLineHeight+16); !hasText(img, line) {
+			t.Errorf("nothing drawn in %v, where tunnel command %d goes", line, i+1)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package vncrps
+
+import (
+	"bytes"
+	"github.com/alltom/vncrps/rfb"
+	"image"
+	"image/draw"
+)
+
+// Each row of the rankings panel is drawn entirely within this band, relative to the row's top.
+const (
+	rankingRowTop    = 4
+	rankingRowHeight = 16
+)
+
+// rankingsHeight returns how tall the framebuffer must be to show every row of the rankings panel above the
+// announcement.
+func rankingsHeight(rows int) int {
+	return rows*rankingRowHeight + 32
+}
+
+// rankingsFramebufferHeight is rankingsHeight, but at least minHeight and no more than maxUISize. Rankings that don't
+// fit are scrolled to with the wheel.
+func rankingsFramebufferHeight(rows, minHeight int) int {
+	return min(max(rankingsHeight(rows), minHeight), maxUISize)
+}
+
+// clampScroll keeps the number of rankings rows scrolled past in range, scrolling no further than it takes to show the
+// last row in a framebuffer height tall.
+func clampScroll(scrollRows, rows, height int) int {
+	return max(0, min(scrollRows, rows-(height-32)/rankingRowHeight))
+}
+
+// trackFrame records what Render just drew, after finding rankings rows that only moved since the last frame so they
+// can be sent as copies.
+func (ui *UI) trackFrame(img draw.Image, rect image.Rectangle) {
+	ui.frameLock.Lock()
+	defer ui.frameLock.Unlock()
+	rgba, ok := img.(*image.RGBA)
+	if !ok {
+		ui.frame = nil
+		return
+	}
+	if ui.frame == nil {
+		if rect != (image.Rectangle{Max: ui.size}) {
+			return // The client's framebuffer is unknown until it's seen a whole frame.
+		}
+		ui.frame = image.NewRGBA(rect)
+	} else {
+		ui.copies = rankingCopies(ui.rankingRows, ui.drawnRows, ui.frame, rgba)
+	}
+	draw.Draw(ui.frame, rect, rgba, rect.Min, draw.Src)
+	ui.rankingRows = append(ui.rankingRows[:0], ui.drawnRows...)
+}
+
+// rankingCopies finds runs of rankings rows that moved by the same number of places and whose pixels didn't change,
+// so they can be copied from the client's own framebuffer.
+func rankingCopies(oldRows, newRows []string, oldFrame, newFrame *image.RGBA) []rfb.CopyRegion {
+	oldIndex := map[string]int{}
+	for i, row := range oldRows {
+		oldIndex[row] = i
+	}
+
+	var copies []rfb.CopyRegion
+	for start := 0; start < len(newRows); {
+		i, ok := oldIndex[newRows[start]]
+		offset := start - i
+		end := start + 1
+		for ok && end < len(newRows) {
+			if j, found := oldIndex[newRows[end]]; !found || end-j != offset {
+				break
+			}
+			end++
+		}
+		if ok && offset != 0 {
+			dst := image.Rect(RankingsSplitX, start*rankingRowHeight+rankingRowTop, oldFrame.Rect.Max.X, end*rankingRowHeight+rankingRowTop)
+			src := image.Pt(RankingsSplitX, i*rankingRowHeight+rankingRowTop)
+			if samePixels(oldFrame, src, newFrame, dst) {
+				copies = append(copies, rfb.CopyRegion{Rect: dst, Src: src})
+			}
+		}
+		start = end
+	}
+	return copies
+}
+
+func samePixels(a *image.RGBA, aMin image.Point, b *image.RGBA, bRect image.Rectangle) bool {
+	aRect := bRect.Add(aMin.Sub(bRect.Min))
+	if !aRect.In(a.Bounds()) || !bRect.In(b.Bounds()) {
+		return false
+	}
+	for y := 0; y < bRect.Dy(); y++ {
+		ai := a.PixOffset(aRect.Min.X, aRect.Min.Y+y)
+		bi := b.PixOffset(bRect.Min.X, bRect.Min.Y+y)
+		n := 4 * bRect.Dx()
+		if !bytes.Equal(a.Pix[ai:ai+n], b.Pix[bi:bi+n]) {
+			return false
+		}
+	}
+	return true
+}
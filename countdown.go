@@ -0,0 +1,52 @@
+package vncrps
+
+import (
+	"fmt"
+	"time"
+)
+
+// CountdownStyle is how time remaining in a phase is shown. Players can switch styles on the settings screen.
+type CountdownStyle int
+
+const (
+	// Minutes and seconds, like "0:09".
+	CountdownClock CountdownStyle = iota
+
+	// Whole seconds, like "9s".
+	CountdownSeconds
+)
+
+// ParseCountdownStyle parses "clock" or "seconds".
+func ParseCountdownStyle(s string) (CountdownStyle, error) {
+	switch s {
+	case "clock":
+		return CountdownClock, nil
+	case "seconds":
+		return CountdownSeconds, nil
+	default:
+		return 0, fmt.Errorf("unrecognized countdown style %q; use clock or seconds", s)
+	}
+}
+
+func (s CountdownStyle) String() string {
+	switch s {
+	case CountdownClock:
+		return "clock"
+	case CountdownSeconds:
+		return "seconds"
+	default:
+		return fmt.Sprintf("CountdownStyle(%d)", int(s))
+	}
+}
+
+// Format formats the time left. Partial seconds round up, so "0:00" means time is up.
+func (s CountdownStyle) Format(d time.Duration) string {
+	if d < 0 {
+		d = 0
+	}
+	seconds := int((d + time.Second - 1) / time.Second)
+	if s == CountdownSeconds {
+		return fmt.Sprintf("%ds", seconds)
+	}
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
@@ -0,0 +1,26 @@
+package vncrps
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCountdownStyle(t *testing.T) {
+	for _, test := range []struct {
+		style CountdownStyle
+		d     time.Duration
+		want  string
+	}{
+		{CountdownClock, 9870 * time.Millisecond, "0:10"},
+		{CountdownClock, 9 * time.Second, "0:09"},
+		{CountdownClock, 75 * time.Second, "1:15"},
+		{CountdownClock, -time.Second, "0:00"},
+		{CountdownSeconds, 9870 * time.Millisecond, "10s"},
+		{CountdownSeconds, 75 * time.Second, "75s"},
+		{CountdownSeconds, 0, "0s"},
+	} {
+		if got := test.style.Format(test.d); got != test.want {
+			t.Errorf("%v.Format(%v) = %q, want %q", test.style, test.d, got, test.want)
+		}
+	}
+}
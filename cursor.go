@@ -0,0 +1,48 @@
+package vncrps
+
+import (
+	"github.com/alltom/vncrps/rfb"
+	"image"
+	"image/color"
+)
+
+var (
+	arrowCursor = rfb.NewBitmapCursor(color.Black, color.White, image.Pt(0, 0),
+		"X",
+		"XX",
+		"X.X",
+		"X..X",
+		"X...X",
+		"X....X",
+		"X.....X",
+		"X......X",
+		"X.......X",
+		"X........X",
+		"X.....XXXXX",
+		"X..X..X",
+		"X.X X..X",
+		"XX  X..X",
+		"X    X..X",
+		"     X..X",
+		"      XX",
+	)
+	handCursor = rfb.NewBitmapCursor(color.Black, color.White, image.Pt(5, 0),
+		"    XX",
+		"   X..X",
+		"   X..X",
+		"   X..X",
+		"   X..XXX",
+		"   X..X..XXX",
+		"   X..X..X..XX",
+		"XX X..X..X..X.X",
+		"X..X..........X",
+		"X..X..........X",
+		" X............X",
+		"  X...........X",
+		"  X..........X",
+		"   X.........X",
+		"   X........X",
+		"    X.......X",
+		"    XXXXXXXXX",
+	)
+)
@@ -0,0 +1,18 @@
+package vncrps
+
+import (
+	"net/http"
+	"net/http/pprof"
+)
+
+// DebugHandler serves Go's profiler under /debug/pprof/, for capturing CPU and heap profiles of a running server with
+// `go tool pprof`. It reveals the server's command line, which may include the password.
+func DebugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
@@ -0,0 +1,50 @@
+package vncrps
+
+import (
+	"fmt"
+	"github.com/alltom/vncrps/rfb"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"time"
+)
+
+// How long viewers turned away by Config.MaxPlayers are shown why before they're disconnected.
+const fullScreenTime = 10 * time.Second
+
+// fullScreen tells a viewer that the game is full instead of adding a player, and disconnects it after
+// fullScreenTime. It implements rfb.Handler.
+type fullScreen struct {
+	players int
+	size    image.Point
+	timer   *time.Timer
+}
+
+func newFullScreen(players int, conn io.Closer) *fullScreen {
+	return &fullScreen{
+		players: players,
+		size:    image.Pt(UIWidth, UIHeight),
+		timer:   time.AfterFunc(fullScreenTime, func() { conn.Close() }),
+	}
+}
+
+func (f *fullScreen) Resize(width, height int) {
+	f.size = image.Pt(width, height)
+}
+
+func (f *fullScreen) Render(img draw.Image, rect image.Rectangle) {
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.White), image.ZP, draw.Src)
+	label("THE GAME IS FULL", image.Rect(8, 8, f.size.X-8, 24), img)
+	label(fmt.Sprintf("%d people are playing.", f.players), image.Rect(8, 32, f.size.X-8, 48), img)
+	label("Try again in a few minutes!", image.Rect(8, 56, f.size.X-8, 72), img)
+}
+
+func (f *fullScreen) KeyEvent(m *rfb.KeyEventMessage)         {}
+func (f *fullScreen) PointerEvent(m *rfb.PointerEventMessage) {}
+func (f *fullScreen) CutText(text string)                     {}
+
+func (f *fullScreen) Close() error {
+	f.timer.Stop()
+	return nil
+}
@@ -0,0 +1,25 @@
+package game
+
+// Bindings are a player's keyboard shortcuts and mouse preference, which the game keeps with the rest of the player so
+// they're the same after rejoining or a restart. The game doesn't use them itself; see vncrps.InputBindings.
+type Bindings struct {
+	Keys             [NumMoves]uint32 `json:"keys"` // X11 keysyms, indexed by Move.
+	SwapMouseButtons bool             `json:"swap_mouse_buttons,omitempty"`
+}
+
+// SetBindings keeps a player's bindings for as long as the game remembers them.
+func (s *GameServer) SetBindings(playerId PlayerId, bindings Bindings) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.rejoinCodes[playerId]; ok {
+		s.bindings[playerId] = bindings
+	}
+}
+
+// Bindings returns the bindings SetBindings was last given for a player, if it was given any.
+func (s *GameServer) Bindings(playerId PlayerId) (Bindings, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	bindings, ok := s.bindings[playerId]
+	return bindings, ok
+}
@@ -0,0 +1,151 @@
+package game
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// BotStrategy picks moves for the bot a game adds when an odd number of people are playing. See GameServer.Bots.
+type BotStrategy interface {
+	// Name says how the bot plays, such as "random". The bot is named after it.
+	Name() string
+
+	// Pick chooses one of moves, the game's move set, against an opponent who played opponentMoves in earlier rounds,
+	// oldest first.
+	Pick(r *rand.Rand, moves, opponentMoves []Move) Move
+}
+
+// RandomBot picks any move, with equal chances, which nobody can do better than even against.
+type RandomBot struct{}
+
+func (RandomBot) Name() string { return "random" }
+
+func (RandomBot) Pick(r *rand.Rand, moves, opponentMoves []Move) Move {
+	return moves[r.Intn(len(moves))]
+}
+
+// CounterBot picks a move that beats the one its opponent has played most, which beats players who favor one. Against
+// players it hasn't seen, it picks randomly.
+type CounterBot struct{}
+
+func (CounterBot) Name() string { return "counter" }
+
+func (CounterBot) Pick(r *rand.Rand, moves, opponentMoves []Move) Move {
+	if len(opponentMoves) == 0 {
+		return RandomBot{}.Pick(r, moves, opponentMoves)
+	}
+	counts := map[Move]int{}
+	favorite := opponentMoves[len(opponentMoves)-1] // Ties go to the latest.
+	for _, m := range opponentMoves {
+		counts[m]++
+		if counts[m] > counts[favorite] {
+			favorite = m
+		}
+	}
+	var counters []Move
+	for _, m := range moves {
+		if m.Beats(favorite) {
+			counters = append(counters, m)
+		}
+	}
+	switch len(counters) {
+	case 0: // The favorite isn't one of moves, say from a game restored with another move set.
+		return RandomBot{}.Pick(r, moves, opponentMoves)
+	case 1:
+		return counters[0]
+	default:
+		return counters[r.Intn(len(counters))]
+	}
+}
+
+// ParseBotStrategy returns the strategy with the given name: "random" or "counter".
+func ParseBotStrategy(name string) (BotStrategy, error) {
+	for _, strategy := range []BotStrategy{RandomBot{}, CounterBot{}} {
+		if strategy.Name() == name {
+			return strategy, nil
+		}
+	}
+	return nil, fmt.Errorf("unrecognized bot strategy %q", name)
+}
+
+// How many of their latest rounds a bot looks at to learn an opponent's habits.
+const botMemory = 100
+
+// enoughPlayers reports whether this many people are enough for a round, counting the bot there'd be.
+func (s *GameServer) enoughPlayers(people int) bool {
+	return people >= 2 || people == 1 && s.Bots != nil
+}
+
+// balance adds the bot when an odd number of people are playing and takes it out when an even number are, so nobody
+// sits out. The bot keeps its ID and rank while it's out.
+//
+// Assumes s.lock has been obtained.
+func (s *GameServer) balance() {
+	if s.Bots == nil {
+		return
+	}
+	_, people := s.playerCount()
+	_, in := s.players[s.botId()]
+	switch {
+	case people%2 == 1 && !in:
+		if s.bot == nil {
+			s.bot = &PlayerInfo{PlayerId: PlayerId(s.nextPlayerId), Name: fmt.Sprintf("Bot (%s)", s.Bots.Name()), Bot: true, Rating: InitialRating}
+			s.nextPlayerId++
+		}
+		s.players[s.bot.PlayerId] = s.bot
+		s.emit(EventPlayerJoined, s.bot, nil)
+	case people%2 == 0 && in:
+		delete(s.players, s.bot.PlayerId)
+		s.emit(EventPlayerLeft, s.bot, nil)
+	}
+}
+
+// botId returns the bot's ID, or zero if there's never been one.
+//
+// Assumes s.lock has been obtained.
+func (s *GameServer) botId() PlayerId {
+	if s.bot == nil {
+		return 0
+	}
+	return s.bot.PlayerId
+}
+
+// pickForBot picks the bot's move in its matchup this round, if it's in one and hasn't picked yet.
+//
+// Assumes s.lock has been obtained.
+func (s *GameServer) pickForBot() {
+	for _, m := range s.matchups {
+		if m.Over {
+			continue
+		}
+		for i, id := range m.Players {
+			if id != s.botId() || m.Moves[i] != nil {
+				continue
+			}
+			move := s.Bots.Pick(s.rand, s.moveSet().Moves, s.movesBy(m.Players[1-i]))
+			m.Moves[i] = &move
+			s.emit(EventMovePicked, s.bot, nil)
+		}
+	}
+}
+
+// movesBy returns the moves a player made in their latest rounds, oldest first.
+//
+// Assumes s.lock has been obtained.
+func (s *GameServer) movesBy(playerId PlayerId) []Move {
+	history := s.history
+	if len(history) > botMemory {
+		history = history[len(history)-botMemory:]
+	}
+	var moves []Move
+	for _, round := range history {
+		for _, m := range round.Matchups {
+			for i, player := range m.Players {
+				if player.PlayerId == playerId && m.Moves[i] != nil {
+					moves = append(moves, *m.Moves[i])
+				}
+			}
+		}
+	}
+	return moves
+}
@@ -0,0 +1,82 @@
+package game
+
+import "time"
+
+// EventType is what kind of thing an Event says happened.
+type EventType string
+
+const (
+	EventPlayerJoined   EventType = "player_joined"
+	EventPlayerLeft     EventType = "player_left"
+	EventPlayerRejoined EventType = "player_rejoined"
+	EventPlayerRenamed  EventType = "player_renamed"
+	EventRoundStarted   EventType = "round_started"
+	EventMovePicked     EventType = "move_picked" // Which move is left out until the round is judged.
+	EventRematch        EventType = "rematch"     // Both players in a matchup picked the same move. See GameServer.SuddenDeath.
+	EventRoundJudged    EventType = "round_judged"
+)
+
+// Event is something that happened in the game, for feeds outside it such as stream overlays and chat bots.
+type Event struct {
+	Type  EventType
+	Round int // The round being played, or the last one between rounds.
+
+	// The player it happened to, as of just after, for events about a player.
+	Player *PlayerInfo
+
+	// For EventRoundStarted, who plays whom, without moves. For EventRoundJudged, the results. For EventRematch, the
+	// matchup that was drawn, with the moves both players picked.
+	Matchups []MatchupSummary
+
+	// For EventRoundJudged, the results in a line, as RoundSummary.String has them for players' clipboards.
+	Summary string
+
+	// How long was left in the phase just after it happened, such as the whole pick duration for EventRoundStarted.
+	// Zero while waiting for players.
+	TimeLeft time.Duration
+}
+
+// Subscribe returns a channel that receives the game's events from now on, and a function that stops them. Events
+// that don't fit in the channel's buffer of size are dropped rather than holding up the game, so subscribers should
+// keep up.
+func (s *GameServer) Subscribe(size int) (<-chan Event, func()) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	events := make(chan Event, size)
+	s.subscribers[events] = true
+	return events, func() {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		delete(s.subscribers, events)
+	}
+}
+
+// emit sends an event to every subscriber with room for it.
+//
+// Assumes s.lock has been obtained.
+func (s *GameServer) emit(eventType EventType, player *PlayerInfo, matchups []MatchupSummary) {
+	if len(s.subscribers) == 0 {
+		return
+	}
+	e := Event{Type: eventType, Round: s.round, Matchups: matchups}
+	if player != nil {
+		p := *player
+		e.Player = &p
+	}
+	s.publish(e)
+}
+
+// publish sends an event to every subscriber.
+//
+// Assumes s.lock has been obtained.
+func (s *GameServer) publish(e Event) {
+	if s.phase != PhaseWaiting {
+		e.TimeLeft = max(0, s.phaseDeadline.Sub(s.getNow()))
+	}
+	for events := range s.subscribers {
+		select {
+		case events <- e:
+		default:
+		}
+	}
+}
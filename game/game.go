@@ -0,0 +1,918 @@
+package game
+
+import (
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type GameServer struct {
+	lock   sync.Mutex
+	getNow func() time.Time
+	rand   *rand.Rand
+
+	nextPlayerId int
+	players      map[PlayerId]*PlayerInfo
+	matchups     []*Matchup
+
+	phase         Phase
+	phaseDeadline time.Time
+	round         int
+	lastRound     *RoundSummary
+	history       []RoundSummary             // Judged rounds, oldest first, up to maxHistory.
+	records       map[[2]PlayerId]HeadToHead // Every pairing's matches, even those history forgot, from the lower ID's side.
+	phaseChanges  map[Phase]int              // How many times the game has entered each phase.
+
+	announcement         string
+	announcementDeadline time.Time
+
+	rejoinCodes map[PlayerId]string    // Every player's, including those who left within rejoinWindow.
+	departed    map[PlayerId]departure // Players who left within rejoinWindow, some of them still in this round.
+	bindings    map[PlayerId]Bindings  // See SetBindings. Kept as long as rejoin codes are.
+
+	subscribers map[chan Event]bool // See Subscribe.
+	bot         *PlayerInfo         // The bot, if there's been one, whether or not it's in the game now.
+
+	changed     chan struct{} // Closed and replaced whenever the game changes.
+	secondsLeft int           // Whole seconds left in the phase, rounded up, as of the last Tick.
+	announcing  bool          // Whether the announcement was showing as of the last Tick.
+
+	// Logs players coming and going and the results of each round. If nil, slog.Default() is used.
+	Logger *slog.Logger
+
+	// If set, a bot that picks moves this way plays whenever an odd number of people are, including one alone, so
+	// nobody sits out. It's named after the strategy, such as "Bot (random)", and marked as a bot in the rankings.
+	// Set it before anyone joins.
+	Bots BotStrategy
+
+	// How long players have to pick their moves each round, and how long the results are shown before the next one.
+	// Zero means DefaultPickDuration and DefaultReviewDuration. Set them before anyone joins.
+	PickDuration   time.Duration
+	ReviewDuration time.Duration
+
+	// If more than 1, each matchup is a match of up to this many games, each picked and reviewed like a round, which
+	// ends once a player has won most of them. Ranks and ratings change when matches end. It should be odd. Set it
+	// before anyone joins.
+	BestOf int
+
+	// If set, rounds are paired Swiss-style, players with similar ranks facing each other and avoiding players they've
+	// already faced, instead of at random, so the rankings sort out skill in a few rounds. Set it before anyone joins.
+	Swiss bool
+
+	// The moves players pick from. If it has none, it's ClassicMoves. Set it before anyone joins.
+	Moves MoveSet
+
+	// If set, when both players in a matchup pick the same move, their moves are cleared straight away so they pick
+	// again, until someone wins or the round ends. The round is pushed back so each rematch has at least this long.
+	// Set it before anyone joins.
+	SuddenDeath time.Duration
+}
+
+// How long phases last unless GameServer.PickDuration and GameServer.ReviewDuration say otherwise.
+const (
+	DefaultPickDuration   = 10 * time.Second
+	DefaultReviewDuration = 5 * time.Second
+)
+
+type Matchup struct {
+	Players [2]PlayerId
+	Moves   [2]*Move
+	Winner  *PlayerId // Of the game last judged.
+
+	// The game of the match being played or last judged, from 1, how many each player has won, and whether the match
+	// is over. Without GameServer.BestOf, every match is one game.
+	Game   int
+	Score  [2]int
+	Over   bool
+	played bool    // Whether both players have picked in any game, so a tied match is a draw rather than a no-show.
+	picked [2]bool // Whether each player has picked in any game, so a loss without picking is a forfeit.
+
+	Draws []Move // The moves both players picked in this game's draws that SuddenDeath replayed, oldest first.
+}
+
+type Move int
+
+const (
+	MoveRock Move = iota
+	MovePaper
+	MoveScissors
+	MoveLizard
+	MoveSpock
+	MoveFire
+	MoveSponge
+	MoveAir
+	MoveWater
+)
+
+// How many moves there are across every move set, for tables indexed by Move.
+const NumMoves = int(MoveWater) + 1
+
+var allMoves = []Move{MoveRock, MovePaper, MoveScissors, MoveLizard, MoveSpock, MoveFire, MoveSponge, MoveAir, MoveWater}
+
+var moveNames = [NumMoves]string{"ROCK", "PAPER", "SCISSORS", "LIZARD", "SPOCK", "FIRE", "SPONGE", "AIR", "WATER"}
+
+// What each move beats. The move sets agree on the moves they share, so one graph covers them all.
+var beats = [NumMoves][]Move{
+	MoveRock:     {MoveScissors, MoveLizard, MoveFire, MoveSponge},
+	MovePaper:    {MoveRock, MoveSpock, MoveAir, MoveWater},
+	MoveScissors: {MovePaper, MoveLizard, MoveSponge, MoveAir},
+	MoveLizard:   {MovePaper, MoveSpock},
+	MoveSpock:    {MoveRock, MoveScissors},
+	MoveFire:     {MovePaper, MoveScissors, MoveSponge},
+	MoveSponge:   {MovePaper, MoveAir, MoveWater},
+	MoveAir:      {MoveRock, MoveFire, MoveWater},
+	MoveWater:    {MoveRock, MoveFire, MoveScissors},
+}
+
+func (m Move) Beats(m2 Move) bool {
+	if m < 0 || int(m) >= NumMoves {
+		panic(fmt.Sprintf("unrecognized move: %d", int(m)))
+	}
+	for _, beaten := range beats[m] {
+		if beaten == m2 {
+			return true
+		}
+	}
+	return false
+}
+
+// MarshalText names the move, as String does, so saved games say which moves were played.
+func (m Move) MarshalText() ([]byte, error) {
+	for _, move := range allMoves {
+		if m == move {
+			return []byte(m.String()), nil
+		}
+	}
+	return nil, fmt.Errorf("unrecognized move: %d", int(m))
+}
+
+// UnmarshalText reads a move MarshalText named.
+func (m *Move) UnmarshalText(text []byte) error {
+	for _, move := range allMoves {
+		if string(text) == move.String() {
+			*m = move
+			return nil
+		}
+	}
+	return fmt.Errorf("unrecognized move: %q", text)
+}
+
+func (m Move) String() string {
+	if m < 0 || int(m) >= NumMoves {
+		panic(fmt.Sprintf("unrecognized move: %d", int(m)))
+	}
+	return moveNames[m]
+}
+
+// MoveSet is the moves players pick from. In each, every move beats half of the others. See GameServer.Moves.
+type MoveSet struct {
+	Name  string // What ParseMoveSet takes, such as "rps".
+	Moves []Move // In the order they're shown.
+}
+
+var (
+	ClassicMoves     = MoveSet{"rps", []Move{MoveRock, MovePaper, MoveScissors}}
+	LizardSpockMoves = MoveSet{"rpsls", []Move{MoveRock, MovePaper, MoveScissors, MoveLizard, MoveSpock}}
+	SevenMoves       = MoveSet{"rps7", []Move{MoveRock, MovePaper, MoveScissors, MoveFire, MoveSponge, MoveAir, MoveWater}}
+)
+
+// ParseMoveSet returns the move set with the given name: "rps", "rpsls" (Rock-Paper-Scissors-Lizard-Spock), or "rps7"
+// (Rock-Paper-Scissors-Fire-Sponge-Air-Water).
+func ParseMoveSet(name string) (MoveSet, error) {
+	for _, set := range []MoveSet{ClassicMoves, LizardSpockMoves, SevenMoves} {
+		if set.Name == name {
+			return set, nil
+		}
+	}
+	return MoveSet{}, fmt.Errorf("unrecognized move set %q", name)
+}
+
+// Has reports whether m is one of the set's moves.
+func (set MoveSet) Has(m Move) bool {
+	for _, move := range set.Moves {
+		if move == m {
+			return true
+		}
+	}
+	return false
+}
+
+type PlayerId int64
+
+type PlayerInfo struct {
+	PlayerId     PlayerId
+	Disconnected bool
+	Name         string
+	Rank         int
+	Bot          bool // Whether it's the bot that plays when an odd number of people are. See GameServer.Bots.
+
+	Rating int // Elo rating, starting at InitialRating.
+
+	// Matches won, lost, and drawn, matches lost without picking a move (which count as losses too), and matches
+	// played, including ones where nobody picked.
+	Wins, Losses, Draws int
+	Forfeits            int
+	Played              int
+
+	// Matches won in a row, up to now and at most. Losses and draws end a streak; matches nobody picked in don't.
+	Streak, BestStreak int
+}
+
+type Phase int
+
+const (
+	PhaseWaiting Phase = iota
+	PhasePicking
+	PhaseReview
+)
+
+func (p Phase) String() string {
+	switch p {
+	case PhaseWaiting:
+		return "waiting"
+	case PhasePicking:
+		return "picking"
+	case PhaseReview:
+		return "review"
+	default:
+		return fmt.Sprintf("Phase(%d)", int(p))
+	}
+}
+
+// Counters counts what has happened since the game started, for monitoring.
+type Counters struct {
+	Rounds       int           // Rounds started.
+	PhaseChanges map[Phase]int // How many times the game has entered each phase.
+}
+
+type GameState struct {
+	Player          PlayerInfo
+	Phase           Phase
+	TimeLeftInPhase time.Duration
+
+	PlayerMove   *Move
+	Opponent     *PlayerInfo
+	OpponentMove *Move
+	Winner       *PlayerId
+
+	Rankings []PlayerInfo
+
+	// Set by Announce, or empty.
+	Announcement string
+
+	// The most recently judged round, or nil. Shared between players, so don't modify it.
+	LastRound *RoundSummary
+
+	// While players are picking, who plays whom this round and who has picked, but not what. Only set by Overview.
+	Matchups []MatchupSummary
+
+	// What the player can give Rejoin to get their place back if they're disconnected. Not set by Overview.
+	RejoinCode string
+
+	// The game of the player's match being played or last judged, from 1, how many games it's best of, the games the
+	// player and then their opponent have won, and whether it's over. Not set by Overview.
+	Game, BestOf int
+	Score        [2]int
+	MatchOver    bool
+
+	// The moves players pick from this game, in the order they're shown.
+	Moves []Move
+
+	// The moves the player and their opponent both picked in draws this game, oldest first, which GameServer.SuddenDeath
+	// had them pick again after. Not set by Overview.
+	Draws []Move
+
+	// How the player's matches against their opponent have gone over the whole game, counting this one once it's judged.
+	// Not set by Overview.
+	HeadToHead HeadToHead
+}
+
+// The seed determines matchmaking, so a game can be replayed exactly given the same seed and inputs.
+func NewGameServer(getNow func() time.Time, seed int64) *GameServer {
+	s := &GameServer{getNow: getNow, rand: rand.New(rand.NewSource(seed)), nextPlayerId: 1}
+	s.players = make(map[PlayerId]*PlayerInfo)
+	s.phaseChanges = make(map[Phase]int)
+	s.rejoinCodes = make(map[PlayerId]string)
+	s.departed = make(map[PlayerId]departure)
+	s.bindings = make(map[PlayerId]Bindings)
+	s.records = make(map[[2]PlayerId]HeadToHead)
+	s.subscribers = make(map[chan Event]bool)
+	s.changed = make(chan struct{})
+	return s
+}
+
+// Changed returns a channel that's closed the next time the game changes in a way players could see: when a player
+// joins, leaves, or picks a move, an announcement starts or ends, the phase changes, or another second of the phase
+// passes. Changes that come with time passing are made lazily, when the game is next used, so something must call
+// Tick regularly for them to be noticed promptly.
+func (s *GameServer) Changed() <-chan struct{} {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.changed
+}
+
+// Tick makes the changes due by now, such as ending a phase whose time is up, and notices seconds of the phase
+// passing and announcements ending. See Changed.
+func (s *GameServer) Tick() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := s.getNow()
+	s.advance(now)
+
+	secondsLeft := 0
+	if s.phase != PhaseWaiting {
+		secondsLeft = int((s.phaseDeadline.Sub(now) + time.Second - 1) / time.Second)
+	}
+	announcing := now.Before(s.announcementDeadline)
+	if secondsLeft != s.secondsLeft || announcing != s.announcing {
+		s.secondsLeft, s.announcing = secondsLeft, announcing
+		s.notify()
+	}
+}
+
+// Assumes s.lock has been obtained.
+func (s *GameServer) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func (s *GameServer) AddPlayer() PlayerId {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	player := &PlayerInfo{
+		PlayerId: PlayerId(s.nextPlayerId),
+		Name:     fmt.Sprintf("P%d", s.nextPlayerId),
+		Rating:   InitialRating,
+	}
+	s.nextPlayerId++
+	s.players[player.PlayerId] = player
+	s.rejoinCodes[player.PlayerId] = s.newRejoinCode()
+	s.emit(EventPlayerJoined, player, nil)
+
+	if _, people := s.playerCount(); s.phase == PhaseWaiting && s.enoughPlayers(people) {
+		s.startRound(s.getNow())
+	}
+	s.notify()
+
+	active, total := s.playerCount()
+	s.logger().Info("player connected", "player", player.PlayerId, "active", active, "total", total)
+
+	return player.PlayerId
+}
+
+func (s *GameServer) RemovePlayer(playerId PlayerId) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	active, total := s.playerCount()
+	s.logger().Info("player disconnected", "player", playerId, "active", active, "total", total)
+
+	if player, ok := s.players[playerId]; ok {
+		s.depart(player, s.getNow())
+	}
+	s.notify()
+}
+
+func (s *GameServer) GetState(playerId PlayerId) (*GameState, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := s.getNow()
+	s.advance(now)
+
+	player, ok := s.players[playerId]
+	if !ok {
+		return nil, fmt.Errorf("could not find player with id %v", playerId)
+	}
+
+	timeLeft := time.Duration(0)
+	if s.phase != PhaseWaiting {
+		timeLeft = s.phaseDeadline.Sub(now)
+	}
+
+	var playerMove *Move
+	var opponent *PlayerInfo
+	var opponentMove *Move
+	var winner *PlayerId
+	for _, m := range s.matchups {
+		// For cloning.
+		var opp PlayerInfo
+		var pmove, oppmove Move
+		var w PlayerId
+
+		if m.Players[0] == playerId {
+			if m.Moves[0] != nil {
+				pmove = *m.Moves[0]
+				playerMove = &pmove
+			}
+
+			if o, ok := s.players[m.Players[1]]; ok {
+				opp = *o
+				opponent = &opp
+
+				if m.Moves[1] != nil {
+					oppmove = *m.Moves[1]
+					opponentMove = &oppmove
+				}
+			} else {
+				s.logger().Error("player is in a matchup but not the player map", "player", m.Players[1])
+			}
+
+			if m.Winner != nil {
+				w = *m.Winner
+				winner = &w
+			}
+			break
+		} else if m.Players[1] == playerId {
+			if m.Moves[1] != nil {
+				pmove = *m.Moves[1]
+				playerMove = &pmove
+			}
+
+			if o, ok := s.players[m.Players[0]]; ok {
+				opp = *o
+				opponent = &opp
+
+				if m.Moves[0] != nil {
+					oppmove = *m.Moves[0]
+					opponentMove = &oppmove
+				}
+			} else {
+				s.logger().Error("player is in a matchup but not the player map", "player", m.Players[0])
+			}
+
+			if m.Winner != nil {
+				w = *m.Winner
+				winner = &w
+			}
+			break
+		}
+	}
+
+	var announcement string
+	if now.Before(s.announcementDeadline) {
+		announcement = s.announcement
+	}
+
+	var game int
+	var score [2]int
+	var matchOver bool
+	var draws []Move
+	for _, m := range s.matchups {
+		for i, id := range m.Players {
+			if id == playerId {
+				game, score, matchOver = m.Game, [2]int{m.Score[i], m.Score[1-i]}, m.Over
+				draws = append(draws, m.Draws...)
+			}
+		}
+	}
+
+	state := &GameState{
+		Player:          *player,
+		Phase:           s.phase,
+		TimeLeftInPhase: timeLeft,
+		PlayerMove:      playerMove,
+		Opponent:        opponent,
+		OpponentMove:    opponentMove,
+		Winner:          winner,
+		Rankings:        s.rankings(),
+		Announcement:    announcement,
+		LastRound:       s.lastRound,
+		RejoinCode:      s.rejoinCodes[playerId],
+		Game:            game,
+		BestOf:          s.bestOf(),
+		Score:           score,
+		MatchOver:       matchOver,
+		Draws:           draws,
+		Moves:           s.moveSet().Moves,
+	}
+	if opponent != nil {
+		state.HeadToHead = s.headToHead(playerId, opponent.PlayerId)
+	}
+
+	return state, nil
+}
+
+// Overview returns the state of the game as seen by someone who isn't playing, such as a scoreboard. The
+// player-specific fields are left empty.
+func (s *GameServer) Overview() *GameState {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := s.getNow()
+	s.advance(now)
+
+	timeLeft := time.Duration(0)
+	if s.phase != PhaseWaiting {
+		timeLeft = s.phaseDeadline.Sub(now)
+	}
+	var announcement string
+	if now.Before(s.announcementDeadline) {
+		announcement = s.announcement
+	}
+	var matchups []MatchupSummary
+	if s.phase == PhasePicking {
+		matchups = s.matchupSummaries(false)
+	}
+	return &GameState{
+		Phase:           s.phase,
+		TimeLeftInPhase: timeLeft,
+		Rankings:        s.rankings(),
+		Announcement:    announcement,
+		LastRound:       s.lastRound,
+		Matchups:        matchups,
+		Moves:           s.moveSet().Moves,
+	}
+}
+
+// moveSet returns the moves players pick from. See Moves.
+func (s *GameServer) moveSet() MoveSet {
+	if len(s.Moves.Moves) == 0 {
+		return ClassicMoves
+	}
+	return s.Moves
+}
+
+// Counters returns what has happened in the game so far.
+func (s *GameServer) Counters() Counters {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.advance(s.getNow())
+	c := Counters{Rounds: s.round, PhaseChanges: make(map[Phase]int)}
+	for phase, n := range s.phaseChanges {
+		c.PhaseChanges[phase] = n
+	}
+	return c
+}
+
+// Standings returns every player, highest rank first.
+func (s *GameServer) Standings() []PlayerInfo {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.rankings()
+}
+
+// Playing returns how many people are connected to the game. The bot and players who have left aren't counted.
+func (s *GameServer) Playing() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	active, _ := s.playerCount()
+	return active
+}
+
+// Announce shows a message to every player for the given duration, replacing any previous announcement.
+func (s *GameServer) Announce(message string, duration time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.announcement = message
+	s.announcementDeadline = s.getNow().Add(duration)
+	s.notify()
+	s.logger().Info("announced", "message", message)
+}
+
+// Longest name Rename accepts, which fits in the rankings.
+const MaxNameLength = 16
+
+// Rename changes the name a player is shown by. Names are printable ASCII, which the UI's font has, and nobody else in
+// the game, including players who may rejoin and the bot, may have the same one, ignoring case. Names like "P7" are
+// left for the players the game names.
+func (s *GameServer) Rename(playerId PlayerId, name string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > MaxNameLength {
+		return fmt.Errorf("names must be 1 to %d characters", MaxNameLength)
+	}
+	player, ok := s.players[playerId]
+	if !ok {
+		return fmt.Errorf("could not find player with id %v", playerId)
+	}
+	if err := s.checkName(playerId, name); err != nil {
+		return err
+	}
+	s.logger().Info("player renamed", "player", playerId, "old", player.Name, "new", name)
+	player.Name = name
+	s.emit(EventPlayerRenamed, player, nil)
+	s.notify()
+	return nil
+}
+
+// checkName returns why a player can't be called name, or nil if they can.
+//
+// Assumes s.lock has been obtained.
+func (s *GameServer) checkName(playerId PlayerId, name string) error {
+	for _, r := range name {
+		if r < ' ' || r > '~' {
+			return fmt.Errorf("names must be printable ASCII")
+		}
+	}
+	if id, err := strconv.Atoi(name[1:]); (name[0] == 'P' || name[0] == 'p') && err == nil && id > 0 && PlayerId(id) != playerId {
+		return fmt.Errorf("names like %s are for new players", name)
+	}
+	others := []*PlayerInfo{s.bot}
+	for _, player := range s.players {
+		others = append(others, player)
+	}
+	for _, d := range s.departed {
+		others = append(others, d.player)
+	}
+	for _, other := range others {
+		if other != nil && other.PlayerId != playerId && strings.EqualFold(other.Name, name) {
+			return fmt.Errorf("%s is taken", other.Name)
+		}
+	}
+	return nil
+}
+
+// StartRound starts a round now rather than when the last one's results have been shown. A round still being picked
+// is judged first, so moves already picked count. There must be at least two connected players.
+func (s *GameServer) StartRound() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := s.getNow()
+	s.advance(now)
+	if active, _ := s.playerCount(); !s.enoughPlayers(active) {
+		return fmt.Errorf("a round needs two players, and there are %d", active)
+	}
+	if s.phase == PhasePicking {
+		s.judge()
+	}
+	s.endMatches()
+	s.resetPlayers()
+	s.startRound(now)
+	s.logger().Info("started round early", "round", s.round)
+	return nil
+}
+
+// Matchups returns this round's matchups with the moves picked so far, which players only see once the round is
+// judged, for whoever runs the game. It's nil between rounds.
+func (s *GameServer) Matchups() []MatchupSummary {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.advance(s.getNow())
+	if s.phase == PhaseWaiting {
+		return nil
+	}
+	return s.matchupSummaries(true)
+}
+
+// Pick chooses the player's move in their matchup. It's ignored unless the game is being picked and the move is in the
+// move set.
+func (s *GameServer) Pick(playerId PlayerId, move Move) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.advance(s.getNow())
+	if s.phase != PhasePicking || !s.moveSet().Has(move) {
+		return
+	}
+	for _, m := range s.matchups {
+		if m.Over {
+			continue
+		} else if m.Players[0] == playerId {
+			m.Moves[0] = &move
+			s.emit(EventMovePicked, s.players[playerId], nil)
+			s.rematchIfDrawn(m)
+			s.notify()
+			return
+		} else if m.Players[1] == playerId {
+			m.Moves[1] = &move
+			s.emit(EventMovePicked, s.players[playerId], nil)
+			s.rematchIfDrawn(m)
+			s.notify()
+			return
+		}
+	}
+}
+
+// Makes time-based state transitions.
+//
+// Assumes s.lock has been obtained.
+func (s *GameServer) advance(now time.Time) {
+	switch s.phase {
+	case PhaseWaiting:
+	case PhasePicking:
+		if now.After(s.phaseDeadline) {
+			s.judge()
+			s.setPhase(PhaseReview)
+			s.phaseDeadline = now.Add(s.reviewDuration())
+		}
+	case PhaseReview:
+		if now.After(s.phaseDeadline) {
+			if !s.matchesOver() {
+				s.startGame(now)
+				return
+			}
+			s.resetPlayers()
+			if _, people := s.playerCount(); s.enoughPlayers(people) {
+				s.startRound(now)
+			} else {
+				s.balance() // Takes the bot out if everyone has left.
+				s.matchups = nil
+				s.setPhase(PhaseWaiting)
+			}
+		}
+	}
+}
+
+func (s *GameServer) pickDuration() time.Duration {
+	if s.PickDuration == 0 {
+		return DefaultPickDuration
+	}
+	return s.PickDuration
+}
+
+func (s *GameServer) reviewDuration() time.Duration {
+	if s.ReviewDuration == 0 {
+		return DefaultReviewDuration
+	}
+	return s.ReviewDuration
+}
+
+// Assumes s.lock has been obtained.
+func (s *GameServer) setPhase(phase Phase) {
+	s.phase = phase
+	s.phaseChanges[phase]++
+	s.notify()
+}
+
+// recordWin credits a win and a loss, including to players who left and can still rejoin.
+//
+// Assumes s.lock has been obtained.
+func (s *GameServer) recordWin(winnerId, loserId PlayerId) {
+	winner, loser := s.record(winnerId), s.record(loserId)
+	if winner != nil {
+		winner.Rank++
+		winner.Wins++
+		winner.Streak++
+		if winner.Streak > winner.BestStreak {
+			winner.BestStreak = winner.Streak
+		}
+	}
+	if loser != nil {
+		loser.Losses++
+		loser.Streak = 0
+	}
+	if winner != nil && loser != nil {
+		rate(winner, loser, 1)
+	}
+}
+
+// Assumes s.lock has been obtained.
+func (s *GameServer) recordDraw(playerId1, playerId2 PlayerId) {
+	player1, player2 := s.record(playerId1), s.record(playerId2)
+	for _, player := range []*PlayerInfo{player1, player2} {
+		if player != nil {
+			player.Draws++
+			player.Streak = 0
+		}
+	}
+	if player1 != nil && player2 != nil {
+		rate(player1, player2, 0.5)
+	}
+}
+
+// record returns the player a result counts toward: one in the game, the bot while it's out, or one who left and can
+// still rejoin. It returns nil for players who can't come back, whose results are lost.
+//
+// Assumes s.lock has been obtained.
+func (s *GameServer) record(playerId PlayerId) *PlayerInfo {
+	if player, ok := s.players[playerId]; ok {
+		return player
+	}
+	if playerId == s.botId() {
+		return s.bot
+	}
+	if d, ok := s.departed[playerId]; ok {
+		return d.player
+	}
+	return nil
+}
+
+func (s *GameServer) resetPlayers() {
+	for id, player := range s.players {
+		if player.Disconnected {
+			d := s.departed[id]
+			d.player = player // With the rank they left the round with.
+			s.departed[id] = d
+			delete(s.players, id)
+		}
+	}
+}
+
+// Assumes s.lock has been obtained.
+func (s *GameServer) startRound(now time.Time) {
+	s.balance()
+	var ids []PlayerId
+	for id := range s.players {
+		ids = append(ids, id)
+	}
+	// Map iteration order is random, so sort before shuffling to make matchmaking depend only on the seed.
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	s.rand.Shuffle(len(ids), func(i, j int) {
+		ids[i], ids[j] = ids[j], ids[i]
+	})
+	if s.Swiss {
+		ids = s.swissOrder(ids) // Keeping the shuffled order among players with the same rank.
+	}
+
+	s.matchups = nil
+	for i := 0; i < len(ids)-1; i += 2 {
+		s.matchups = append(s.matchups, &Matchup{
+			Players: [2]PlayerId{ids[i], ids[i+1]},
+			Game:    1,
+		})
+	}
+
+	s.round++
+	s.setPhase(PhasePicking)
+	s.phaseDeadline = now.Add(s.pickDuration())
+	s.emit(EventRoundStarted, nil, s.matchupSummaries(false))
+	s.pickForBot()
+}
+
+// judge judges the game being played in each match that isn't over, ending the matches a player has won.
+//
+// Assumes s.lock has been obtained.
+func (s *GameServer) judge() {
+	var judged []*Matchup
+	for _, m := range s.matchups {
+		if m.Over {
+			continue
+		}
+		judged = append(judged, m)
+		winner := -1 // Which player won the game, if either did.
+		switch {
+		case m.Moves[0] != nil && m.Moves[1] != nil:
+			if m.Moves[0].Beats(*m.Moves[1]) {
+				winner = 0
+			} else if m.Moves[1].Beats(*m.Moves[0]) {
+				winner = 1
+			}
+		case m.Moves[0] != nil:
+			winner = 0 // By forfeit, whether or not either player is still connected.
+		case m.Moves[1] != nil:
+			winner = 1
+		}
+		m.Winner = nil
+		if winner >= 0 {
+			id := m.Players[winner]
+			m.Winner = &id
+			m.Score[winner]++
+		}
+		m.played = m.played || m.Moves[0] != nil && m.Moves[1] != nil
+		for i, move := range m.Moves {
+			m.picked[i] = m.picked[i] || move != nil
+		}
+		if m.Game >= s.bestOf() || m.Score[0]*2 > s.bestOf() || m.Score[1]*2 > s.bestOf() {
+			s.endMatch(m)
+		}
+	}
+
+	s.lastRound = s.summarize(judged)
+	s.remember(s.lastRound)
+	if len(s.subscribers) > 0 {
+		s.publish(Event{Type: EventRoundJudged, Round: s.round, Matchups: s.lastRound.Matchups, Summary: s.lastRound.String()})
+	}
+	s.logger().Info("round over", "round", s.lastRound.Round, "summary", s.lastRound.String())
+}
+
+func (s *GameServer) logger() *slog.Logger {
+	if s.Logger != nil {
+		return s.Logger
+	}
+	return slog.Default()
+}
+
+// Assumes s.lock has been obtained.
+func (s *GameServer) rankings() []PlayerInfo {
+	var rankings []PlayerInfo
+	for _, player := range s.players {
+		rankings = append(rankings, *player)
+	}
+	sort.Slice(rankings, func(i, j int) bool { return rankings[i].PlayerId < rankings[j].PlayerId })
+	sort.SliceStable(rankings, func(i, j int) bool { return rankings[j].Rank < rankings[i].Rank })
+	return rankings
+}
+
+// playerCount returns how many people are connected, and how many are in the game, leaving out the bot.
+//
+// Assumes s.lock has been obtained.
+func (s *GameServer) playerCount() (int, int) {
+	var active, total int
+	for _, player := range s.players {
+		if player.Bot {
+			continue
+		}
+		if !player.Disconnected {
+			active++
+		}
+		total++
+	}
+	return active, total
+}
@@ -0,0 +1,807 @@
+package game
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func getState(s *GameServer, playerId PlayerId, t *testing.T) *GameState {
+	state, err := s.GetState(playerId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return state
+}
+
+func TestBasic(t *testing.T) {
+	now := time.Now()
+	s := NewGameServer(func() time.Time { return now }, 1)
+
+	p1 := s.AddPlayer()
+	state := getState(s, p1, t)
+	if state.Phase != PhaseWaiting {
+		t.Fatalf("phase should be PhaseWaiting, but is %d", state.Phase)
+	}
+
+	p2 := s.AddPlayer()
+	state = getState(s, p2, t)
+	if state.Phase != PhasePicking {
+		t.Fatalf("phase should be PhasePicking, but is %d", state.Phase)
+	}
+	if state.TimeLeftInPhase != time.Second*10 {
+		t.Fatalf("time left should be 10 seconds, bu tit's %v", state.TimeLeftInPhase)
+	}
+	if state.Opponent.PlayerId != p1 {
+		t.Fatalf("opponent should be %d, but it's %d", p1, state.Opponent.PlayerId)
+	}
+	if state.OpponentMove != nil {
+		t.Fatalf("opponent move should be nil, but it's %v", state.OpponentMove)
+	}
+
+	s.Pick(p1, MoveRock)
+	s.Pick(p2, MoveScissors)
+	now = now.Add(time.Second * 11)
+
+	state = getState(s, p1, t)
+	if state.Player.PlayerId != p1 {
+		t.Fatalf("player ID should be %d, but it's %d", p1, state.Player.PlayerId)
+	}
+	if state.Phase != PhaseReview {
+		t.Fatalf("phase should be PhaseReview, but is %d", state.Phase)
+	}
+	if state.TimeLeftInPhase != time.Second*5 {
+		t.Fatalf("time left should be 5 seconds, but it's %v", state.TimeLeftInPhase)
+	}
+	if state.Opponent.PlayerId != p2 {
+		t.Fatalf("opponent should be %d, but it's %d", p2, state.Opponent.PlayerId)
+	}
+	if *state.OpponentMove != MoveScissors {
+		t.Fatalf("opponent picked scissors, but recorded move is %v", *state.OpponentMove)
+	}
+
+	now = now.Add(time.Second)
+	state = getState(s, p2, t)
+	if state.Player.PlayerId != p2 {
+		t.Fatalf("player ID should be %d, but it's %d", p2, state.Player.PlayerId)
+	}
+	if state.Phase != PhaseReview {
+		t.Fatalf("phase should be PhaseReview, but is %d", state.Phase)
+	}
+	if state.TimeLeftInPhase != time.Second*4 {
+		t.Fatalf("time left should be 4 seconds, but it's %v", state.TimeLeftInPhase)
+	}
+	if state.Opponent.PlayerId != p1 {
+		t.Fatalf("opponent should be %d, but it's %d", p1, state.Opponent.PlayerId)
+	}
+	if *state.OpponentMove != MoveRock {
+		t.Fatalf("opponent picked scissors, but recorded move is %v", *state.OpponentMove)
+	}
+
+	s.RemovePlayer(p2)
+	state = getState(s, p1, t)
+	if state.Phase != PhaseReview {
+		t.Fatalf("phase should be PhaseReview, but is %d", state.Phase)
+	}
+
+	now = now.Add(time.Second * 5)
+	state = getState(s, p1, t)
+	if state.Phase != PhaseWaiting {
+		t.Fatalf("phase should be PhaseWaiting, but is %d", state.Phase)
+	}
+}
+
+func TestRoundSummary(t *testing.T) {
+	now := time.Now()
+	s := NewGameServer(func() time.Time { return now }, 1)
+	p1 := s.AddPlayer()
+	p2 := s.AddPlayer()
+	s.Pick(p1, MoveRock)
+	s.Pick(p2, MoveScissors)
+	now = now.Add(time.Second * 11)
+
+	state := getState(s, p1, t)
+	if state.LastRound == nil {
+		t.Fatal("no summary after the round was judged")
+	}
+	if got, want := state.LastRound.String(), "R1: P1 ROCK beats P2 SCISSORS | leader: P1 1"; got != want {
+		t.Errorf("got summary %q, want %q", got, want)
+	}
+}
+
+func TestOverviewMatchups(t *testing.T) {
+	now := time.Now()
+	s := NewGameServer(func() time.Time { return now }, 1)
+	p1 := s.AddPlayer()
+	s.AddPlayer()
+	s.Pick(p1, MoveRock)
+
+	matchups := s.Overview().Matchups
+	if len(matchups) != 1 {
+		t.Fatalf("got %d matchups while picking, want 1", len(matchups))
+	}
+	m := matchups[0]
+	picked := m.Picked[0]
+	if m.Players[1].PlayerId == p1 {
+		picked = m.Picked[1]
+	}
+	if !picked || m.Picked[0] == m.Picked[1] {
+		t.Errorf("got picked %v, want only P1 to have picked", m.Picked)
+	}
+	if m.Moves[0] != nil || m.Moves[1] != nil {
+		t.Errorf("moves %v were shown before the round was judged", m.Moves)
+	}
+
+	now = now.Add(time.Second * 11)
+	if matchups := s.Overview().Matchups; matchups != nil {
+		t.Errorf("got matchups %v during review, want them in LastRound instead", matchups)
+	}
+}
+
+func TestStartRound(t *testing.T) {
+	now := time.Now()
+	s := NewGameServer(func() time.Time { return now }, 1)
+	p1 := s.AddPlayer()
+	if err := s.StartRound(); err == nil {
+		t.Error("started a round with one player")
+	}
+	p2 := s.AddPlayer()
+	s.Pick(p1, MoveRock)
+	s.Pick(p2, MoveScissors)
+	if matchups := s.Matchups(); len(matchups) != 1 || matchups[0].Moves[0] == nil || matchups[0].Moves[1] == nil {
+		t.Errorf("got matchups %v, want one with both moves", matchups)
+	}
+
+	if err := s.StartRound(); err != nil {
+		t.Fatal(err)
+	}
+	state := getState(s, p1, t)
+	if state.Phase != PhasePicking || state.PlayerMove != nil {
+		t.Errorf("in phase %v with move %v after starting a round, want picking with no move", state.Phase, state.PlayerMove)
+	}
+	if state.LastRound == nil || state.LastRound.Round != 1 || state.Player.Rank != 1 {
+		t.Errorf("last round is %v and P1's rank is %d, want round 1 judged with P1 winning", state.LastRound, state.Player.Rank)
+	}
+}
+
+func TestRename(t *testing.T) {
+	s := NewGameServer(time.Now, 1)
+	p1 := s.AddPlayer()
+	if err := s.Rename(p1, " Ada "); err != nil {
+		t.Fatal(err)
+	}
+	if name := getState(s, p1, t).Player.Name; name != "Ada" {
+		t.Errorf("renamed to %q, want Ada", name)
+	}
+	p2 := s.AddPlayer()
+	for _, name := range []string{"", "   ", strings.Repeat("x", MaxNameLength+1), "Zoë", "ADA", fmt.Sprintf("p%d", p1)} {
+		if err := s.Rename(p2, name); err == nil {
+			t.Errorf("renamed to %q", name)
+		}
+	}
+	if err := s.Rename(p1, fmt.Sprintf("P%d", p1)); err != nil {
+		t.Errorf("couldn't go back to the name the game gave: %v", err)
+	}
+	if err := s.Rename(p2+1, "Bob"); err == nil {
+		t.Error("renamed a player who isn't in the game")
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	now := time.Now()
+	s := NewGameServer(func() time.Time { return now }, 1)
+	events, stop := s.Subscribe(10)
+	p1 := s.AddPlayer()
+	p2 := s.AddPlayer()
+	s.Pick(p1, MoveRock)
+	now = now.Add(time.Second * 11)
+	s.Tick()
+	s.RemovePlayer(p2)
+	stop()
+	s.AddPlayer()
+
+	var got []EventType
+	var judged Event
+	for len(events) > 0 {
+		e := <-events
+		got = append(got, e.Type)
+		if e.Type == EventRoundJudged {
+			judged = e
+		}
+	}
+	want := []EventType{EventPlayerJoined, EventPlayerJoined, EventRoundStarted, EventMovePicked, EventRoundJudged, EventPlayerLeft}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got events %v, want %v", got, want)
+	}
+	if judged.Round != 1 || len(judged.Matchups) != 1 || judged.Matchups[0].Winner == nil || *judged.Matchups[0].Winner != p1 {
+		t.Errorf("got %+v, want round 1 judged with P1 winning", judged)
+	}
+}
+
+func TestBots(t *testing.T) {
+	now := time.Now()
+	s := NewGameServer(func() time.Time { return now }, 1)
+	s.Bots = CounterBot{}
+	p1 := s.AddPlayer()
+	state := getState(s, p1, t)
+	if state.Phase != PhasePicking || state.Opponent == nil || !state.Opponent.Bot || state.Opponent.Name != "Bot (counter)" {
+		t.Fatalf("lone player is in phase %v against %+v, want to be playing the bot", state.Phase, state.Opponent)
+	}
+	if n := s.Playing(); n != 1 {
+		t.Errorf("%d playing with one person and the bot, want 1", n)
+	}
+	s.Pick(p1, MoveRock)
+	for _, d := range []time.Duration{11, 6} { // Through the results to the next round.
+		now = now.Add(time.Second * d)
+		s.Tick()
+	}
+	if state := getState(s, p1, t); state.OpponentMove == nil || *state.OpponentMove != MovePaper {
+		t.Errorf("bot picked %v against a player who played rock, want paper", state.OpponentMove)
+	}
+
+	s.AddPlayer()
+	for _, d := range []time.Duration{11, 6} {
+		now = now.Add(time.Second * d)
+		s.Tick()
+	}
+	for _, player := range s.Standings() {
+		if player.Bot {
+			t.Errorf("bot is still in the game with two people: %+v", s.Standings())
+		}
+	}
+	if _, err := ParseBotStrategy("psychic"); err == nil {
+		t.Error("parsed an unknown strategy")
+	}
+}
+
+func TestRating(t *testing.T) {
+	now := time.Now()
+	s := NewGameServer(func() time.Time { return now }, 1)
+	p1 := s.AddPlayer()
+	p2 := s.AddPlayer()
+	play := func(m1, m2 Move) {
+		s.Pick(p1, m1)
+		s.Pick(p2, m2)
+		for _, d := range []time.Duration{11, 6} {
+			now = now.Add(time.Second * d)
+			s.Tick()
+		}
+	}
+	record := func() [2][3]int {
+		var r [2][3]int
+		for _, p := range s.Standings() {
+			r[p.PlayerId-p1] = [3]int{p.Rating, p.Wins, p.Losses}
+		}
+		return r
+	}
+
+	play(MoveRock, MoveScissors)
+	if got, want := record(), [2][3]int{{1016, 1, 0}, {984, 0, 1}}; got != want {
+		t.Errorf("after P1 won, ratings, wins, and losses are %v, want %v", got, want)
+	}
+	play(MovePaper, MovePaper)
+	if got, want := record(), [2][3]int{{1015, 1, 0}, {985, 0, 1}}; got != want {
+		t.Errorf("after a draw, ratings, wins, and losses are %v, want %v", got, want)
+	}
+
+	players := []PlayerInfo{{PlayerId: 1, Rating: 990}, {PlayerId: 2, Rating: 1010}, {PlayerId: 3, Rating: 990}}
+	SortByRating(players)
+	if got := []PlayerId{players[0].PlayerId, players[1].PlayerId, players[2].PlayerId}; !reflect.DeepEqual(got, []PlayerId{2, 1, 3}) {
+		t.Errorf("sorted by rating: %v, want [2 1 3]", got)
+	}
+}
+
+func TestStreaks(t *testing.T) {
+	now := time.Now()
+	s := NewGameServer(func() time.Time { return now }, 1)
+	p1 := s.AddPlayer()
+	p2 := s.AddPlayer()
+	var streaks [][4]int
+	for _, moves := range [][2]Move{{MoveRock, MoveScissors}, {MovePaper, MoveRock}, {MoveRock, MoveRock}, {MoveRock, MovePaper}} {
+		s.Pick(p1, moves[0])
+		s.Pick(p2, moves[1])
+		now = now.Add(11 * time.Second)
+		s1, s2 := getState(s, p1, t).Player, getState(s, p2, t).Player
+		streaks = append(streaks, [4]int{s1.Streak, s1.BestStreak, s2.Streak, s2.BestStreak})
+		now = now.Add(6 * time.Second)
+		s.Tick()
+	}
+	want := [][4]int{{1, 1, 0, 0}, {2, 2, 0, 0}, {0, 2, 0, 0}, {0, 2, 1, 1}}
+	if !reflect.DeepEqual(streaks, want) {
+		t.Errorf("after win, win, draw, loss, streaks are %v, want %v", streaks, want)
+	}
+}
+
+func TestPickDuringReview(t *testing.T) {
+	now := time.Now()
+	s := NewGameServer(func() time.Time { return now }, 1)
+	s.BestOf = 3
+	p1 := s.AddPlayer()
+	p2 := s.AddPlayer()
+	s.Pick(p1, MoveRock)
+	s.Pick(p2, MoveScissors)
+	now = now.Add(11 * time.Second)
+	s.Tick()
+
+	s.Pick(p1, MovePaper) // During game 1's review.
+	if state := getState(s, p1, t); state.Phase != PhaseReview || state.PlayerMove == nil || *state.PlayerMove != MoveRock {
+		t.Errorf("after picking paper during the review, state is %+v, want rock still shown", state)
+	}
+	now = now.Add(6 * time.Second)
+	s.Tick()
+	if state := getState(s, p1, t); state.Game != 2 || state.PlayerMove != nil {
+		t.Errorf("picking during the review picked %v in game %d, want no move in game 2", state.PlayerMove, state.Game)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	now := time.Now()
+	s := NewGameServer(func() time.Time { return now }, 1)
+	p1 := s.AddPlayer()
+	p2 := s.AddPlayer()
+	for _, moves := range [][2]Move{{MoveRock, MoveScissors}, {MovePaper, MoveRock}, {MoveRock, MoveRock}, {MoveRock, MovePaper}} {
+		s.Pick(p1, moves[0])
+		s.Pick(p2, moves[1])
+		now = now.Add(11 * time.Second)
+		s.Tick()
+		now = now.Add(6 * time.Second)
+		s.Tick()
+	}
+	now = now.Add(11 * time.Second) // Nobody picks in round 5, which doesn't count.
+	s.Tick()
+
+	if record, want := s.HeadToHead(p1, p2), (HeadToHead{Wins: 2, Losses: 1, Draws: 1}); record != want {
+		t.Errorf("P1's record against P2 is %+v, want %+v", record, want)
+	}
+	if record, want := getState(s, p2, t).HeadToHead, (HeadToHead{Wins: 1, Losses: 2, Draws: 1}); record != want {
+		t.Errorf("P2's record against P1 is %+v, want %+v", record, want)
+	}
+	var rounds []int
+	for _, m := range s.History(3, p1) {
+		rounds = append(rounds, m.Round)
+	}
+	if want := []int{5, 4, 3}; !reflect.DeepEqual(rounds, want) {
+		t.Errorf("P1's latest games are from rounds %v, want %v", rounds, want)
+	}
+	if history := s.History(10, p1+p2); len(history) != 0 {
+		t.Errorf("history of a player who never played is %+v, want none", history)
+	}
+
+	saved := s.Save()
+	saved.History = saved.History[3:] // As if the history had forgotten the first rounds.
+	restored := NewGameServer(func() time.Time { return now }, 1)
+	if err := restored.Restore(saved); err != nil {
+		t.Fatal(err)
+	}
+	if record, want := restored.HeadToHead(p2, p1), (HeadToHead{Wins: 1, Losses: 2, Draws: 1}); record != want {
+		t.Errorf("P2's record against P1 after restoring is %+v, want %+v", record, want)
+	}
+
+	saved.HeadToHeads = nil // As if saved before head-to-heads were.
+	restored = NewGameServer(func() time.Time { return now }, 1)
+	if err := restored.Restore(saved); err != nil {
+		t.Fatal(err)
+	}
+	if record, want := restored.HeadToHead(p2, p1), (HeadToHead{Wins: 1}); record != want {
+		t.Errorf("P2's record against P1 after restoring an older save is %+v, want %+v from its history", record, want)
+	}
+}
+
+func TestBestOf(t *testing.T) {
+	now := time.Now()
+	s := NewGameServer(func() time.Time { return now }, 1)
+	s.BestOf = 3
+	p1 := s.AddPlayer()
+	p2 := s.AddPlayer()
+	play := func(m1, m2 Move) *GameState { // Returns the state during the game's review.
+		s.Pick(p1, m1)
+		s.Pick(p2, m2)
+		now = now.Add(11 * time.Second)
+		s.Tick()
+		state := getState(s, p1, t)
+		now = now.Add(6 * time.Second)
+		s.Tick()
+		return state
+	}
+
+	state := play(MoveRock, MoveScissors)
+	if state.Phase != PhaseReview || state.Game != 1 || state.Score != [2]int{1, 0} || state.MatchOver || state.Player.Rank != 0 {
+		t.Fatalf("after winning game 1, state is %+v, want to lead 1-0 with no rank yet", state)
+	}
+	if state := getState(s, p1, t); state.Phase != PhasePicking || state.Game != 2 || state.PlayerMove != nil || s.Counters().Rounds != 1 {
+		t.Fatalf("after game 1's review, state is %+v, want game 2 of round 1 being picked", state)
+	}
+
+	play(MovePaper, MovePaper)
+	state = play(MovePaper, MoveRock)
+	if state.Game != 3 || state.Score != [2]int{2, 0} || !state.MatchOver || state.Player.Rank != 1 || state.Player.Wins != 1 {
+		t.Errorf("after winning game 3, state is %+v, want to have won the match 2-0", state)
+	}
+	if got, want := state.LastRound.String(), "R1: P1 PAPER beats P2 ROCK (game 3 of 3, 2-0) | leader: P1 1"; got != want {
+		t.Errorf("summary is %q, want %q", got, want)
+	}
+}
+
+func TestMoveSets(t *testing.T) {
+	for _, set := range []MoveSet{ClassicMoves, LizardSpockMoves, SevenMoves} {
+		for _, m := range set.Moves {
+			beaten := 0
+			for _, m2 := range set.Moves {
+				if m.Beats(m2) {
+					beaten++
+					if m2.Beats(m) {
+						t.Errorf("in %s, %v and %v beat each other", set.Name, m, m2)
+					}
+				} else if m != m2 && !m2.Beats(m) {
+					t.Errorf("in %s, neither %v nor %v beats the other", set.Name, m, m2)
+				}
+			}
+			if beaten != len(set.Moves)/2 {
+				t.Errorf("in %s, %v beats %d moves, want %d", set.Name, m, beaten, len(set.Moves)/2)
+			}
+		}
+	}
+
+	now := time.Now()
+	s := NewGameServer(func() time.Time { return now }, 1)
+	p1 := s.AddPlayer()
+	p2 := s.AddPlayer()
+	s.Pick(p1, MoveSpock)
+	if state := getState(s, p1, t); state.PlayerMove != nil || !reflect.DeepEqual(state.Moves, ClassicMoves.Moves) {
+		t.Errorf("after picking SPOCK in a classic game, state is %+v, want no move", state)
+	}
+
+	s = NewGameServer(func() time.Time { return now }, 1)
+	s.Moves = LizardSpockMoves
+	p1 = s.AddPlayer()
+	p2 = s.AddPlayer()
+	s.Pick(p1, MoveSpock)
+	s.Pick(p2, MoveScissors)
+	now = now.Add(11 * time.Second)
+	if state := getState(s, p1, t); state.Winner == nil || *state.Winner != p1 {
+		t.Errorf("SPOCK didn't beat SCISSORS; state is %+v", state)
+	}
+}
+
+func TestSuddenDeath(t *testing.T) {
+	now := time.Now()
+	s := NewGameServer(func() time.Time { return now }, 1)
+	s.SuddenDeath = 5 * time.Second
+	p1 := s.AddPlayer()
+	p2 := s.AddPlayer()
+	events, stop := s.Subscribe(16)
+	defer stop()
+
+	now = now.Add(8 * time.Second)
+	s.Pick(p1, MoveRock)
+	s.Pick(p2, MoveRock)
+	state := getState(s, p1, t)
+	if state.PlayerMove != nil || !reflect.DeepEqual(state.Draws, []Move{MoveRock}) || state.TimeLeftInPhase != 5*time.Second {
+		t.Errorf("after a draw, state is %+v, want to pick again with 5s left", state)
+	}
+	var rematches []string
+	for len(events) > 0 {
+		if e := <-events; e.Type == EventRematch {
+			rematches = append(rematches, e.Matchups[0].String())
+		}
+	}
+	if want := []string{"P1 ROCK ties P2 ROCK"}; !reflect.DeepEqual(rematches, want) {
+		t.Errorf("rematch events are %q, want %q", rematches, want)
+	}
+
+	s.Pick(p1, MovePaper)
+	s.Pick(p2, MoveRock)
+	now = now.Add(6 * time.Second)
+	if state := getState(s, p1, t); state.Phase != PhaseReview || state.Winner == nil || *state.Winner != p1 {
+		t.Errorf("after the rematch, state is %+v, want P1 to have won", state)
+	}
+}
+
+func TestSwiss(t *testing.T) {
+	now := time.Now()
+	s := NewGameServer(func() time.Time { return now }, 1)
+	s.Swiss = true
+	for i := 0; i < 4; i++ {
+		s.AddPlayer()
+	}
+	if err := s.StartRound(); err != nil { // With everyone, since it started when the second joined.
+		t.Fatal(err)
+	}
+	met := map[[2]PlayerId]bool{}
+	for i := 0; i < 3; i++ {
+		s.Tick() // Starts the next round after the last one's review.
+		round := s.Counters().Rounds
+		for _, m := range s.matchups {
+			key := pairing(m.Players[0], m.Players[1])
+			if met[key] {
+				t.Errorf("round %d paired %v again", round, m.Players)
+			}
+			met[key] = true
+			if i == 1 && s.players[m.Players[0]].Rank != s.players[m.Players[1]].Rank {
+				t.Errorf("round %d paired %v, who have different ranks", round, m.Players)
+			}
+			s.Pick(m.Players[0], MoveRock)
+			s.Pick(m.Players[1], MoveScissors)
+		}
+		now = now.Add(11 * time.Second)
+		s.Tick()
+		now = now.Add(6 * time.Second)
+	}
+}
+
+func TestPhaseDurations(t *testing.T) {
+	now := time.Now()
+	s := NewGameServer(func() time.Time { return now }, 1)
+	s.PickDuration = 3 * time.Second
+	s.ReviewDuration = 2 * time.Second
+	p1 := s.AddPlayer()
+	s.AddPlayer()
+	if state := getState(s, p1, t); state.TimeLeftInPhase != 3*time.Second {
+		t.Errorf("picking with %v left, want 3s", state.TimeLeftInPhase)
+	}
+	var phases []Phase
+	for i := 0; i < 3; i++ {
+		now = now.Add(time.Second*2 + time.Millisecond)
+		s.Tick()
+		phases = append(phases, getState(s, p1, t).Phase)
+	}
+	if want := []Phase{PhasePicking, PhaseReview, PhasePicking}; !reflect.DeepEqual(phases, want) {
+		t.Errorf("phases every 2s = %v, want %v", phases, want)
+	}
+}
+
+func TestRejoin(t *testing.T) {
+	now := time.Now()
+	s := NewGameServer(func() time.Time { return now }, 1)
+	p1 := s.AddPlayer()
+	p2 := s.AddPlayer()
+	code := getState(s, p1, t).RejoinCode
+	if len(code) != rejoinCodeLength {
+		t.Fatalf("got rejoin code %q", code)
+	}
+
+	// Mid-round, the player keeps their move.
+	s.Pick(p1, MoveRock)
+	s.RemovePlayer(p1)
+	if id, err := s.Rejoin(strings.ToLower(code), 0); err != nil || id != p1 {
+		t.Fatalf("Rejoin(%q) = %v, %v; want %v", code, id, err, p1)
+	}
+	if state := getState(s, p1, t); state.Player.Disconnected || state.PlayerMove == nil {
+		t.Errorf("rejoined player is %+v with move %v, want them connected with their move", state.Player, state.PlayerMove)
+	}
+
+	// Between rounds, the player keeps their rank.
+	s.Pick(p2, MoveScissors)
+	now = now.Add(time.Second * 11)
+	s.RemovePlayer(p2)
+	now = now.Add(time.Second * 6)
+	s.RemovePlayer(p1)
+	if id, err := s.Rejoin(code, 0); err != nil || id != p1 {
+		t.Fatalf("Rejoin(%q) between rounds = %v, %v; want %v", code, id, err, p1)
+	}
+	if rank := getState(s, p1, t).Player.Rank; rank != 1 {
+		t.Errorf("rejoined with rank %d, want 1", rank)
+	}
+
+	s.RemovePlayer(p1)
+	now = now.Add(rejoinWindow)
+	if _, err := s.Rejoin(code, 0); err == nil {
+		t.Errorf("rejoined %v after leaving", rejoinWindow)
+	}
+	if _, err := s.Rejoin("NOPE", 0); err == nil {
+		t.Error("rejoined with a code nobody had")
+	}
+}
+
+func TestForfeits(t *testing.T) {
+	type record struct{ Rank, Wins, Losses, Draws, Forfeits, Played int }
+	for _, test := range []struct {
+		name   string
+		play   func(s *GameServer, p1, p2 PlayerId, rejoin func(PlayerId))
+		p1, p2 record
+	}{
+		{"opponent leaves without picking", func(s *GameServer, p1, p2 PlayerId, rejoin func(PlayerId)) {
+			s.Pick(p1, MoveRock)
+			s.RemovePlayer(p2)
+		}, record{1, 1, 0, 0, 0, 1}, record{0, 0, 1, 0, 1, 1}},
+		{"opponent leaves before the winner picks", func(s *GameServer, p1, p2 PlayerId, rejoin func(PlayerId)) {
+			s.RemovePlayer(p2)
+			s.Pick(p1, MoveRock)
+		}, record{1, 1, 0, 0, 0, 1}, record{0, 0, 1, 0, 1, 1}},
+		{"opponent picks, then leaves", func(s *GameServer, p1, p2 PlayerId, rejoin func(PlayerId)) {
+			s.Pick(p1, MoveRock)
+			s.Pick(p2, MoveScissors)
+			s.RemovePlayer(p2)
+		}, record{1, 1, 0, 0, 0, 1}, record{0, 0, 1, 0, 0, 1}},
+		{"winner picks, then leaves", func(s *GameServer, p1, p2 PlayerId, rejoin func(PlayerId)) {
+			s.Pick(p1, MoveRock)
+			s.RemovePlayer(p1)
+			s.Pick(p2, MoveScissors)
+		}, record{1, 1, 0, 0, 0, 1}, record{0, 0, 1, 0, 0, 1}},
+		{"winner picks, then leaves, and opponent doesn't pick", func(s *GameServer, p1, p2 PlayerId, rejoin func(PlayerId)) {
+			s.Pick(p1, MoveRock)
+			s.RemovePlayer(p1)
+		}, record{1, 1, 0, 0, 0, 1}, record{0, 0, 1, 0, 1, 1}},
+		{"both pick, then leave", func(s *GameServer, p1, p2 PlayerId, rejoin func(PlayerId)) {
+			s.Pick(p1, MoveRock)
+			s.Pick(p2, MoveScissors)
+			s.RemovePlayer(p1)
+			s.RemovePlayer(p2)
+		}, record{1, 1, 0, 0, 0, 1}, record{0, 0, 1, 0, 0, 1}},
+		{"opponent leaves and rejoins, then both pick", func(s *GameServer, p1, p2 PlayerId, rejoin func(PlayerId)) {
+			s.RemovePlayer(p2)
+			rejoin(p2)
+			s.Pick(p1, MoveRock)
+			s.Pick(p2, MoveScissors)
+		}, record{1, 1, 0, 0, 0, 1}, record{0, 0, 1, 0, 0, 1}},
+		{"opponent leaves and rejoins without picking", func(s *GameServer, p1, p2 PlayerId, rejoin func(PlayerId)) {
+			s.Pick(p1, MoveRock)
+			s.RemovePlayer(p2)
+			rejoin(p2)
+		}, record{1, 1, 0, 0, 0, 1}, record{0, 0, 1, 0, 1, 1}},
+		{"neither picks, and one leaves", func(s *GameServer, p1, p2 PlayerId, rejoin func(PlayerId)) {
+			s.RemovePlayer(p2)
+		}, record{0, 0, 0, 0, 0, 1}, record{0, 0, 0, 0, 0, 1}},
+		{"both leave without picking", func(s *GameServer, p1, p2 PlayerId, rejoin func(PlayerId)) {
+			s.RemovePlayer(p1)
+			s.RemovePlayer(p2)
+		}, record{0, 0, 0, 0, 0, 1}, record{0, 0, 0, 0, 0, 1}},
+		{"draw, then one leaves", func(s *GameServer, p1, p2 PlayerId, rejoin func(PlayerId)) {
+			s.Pick(p1, MoveRock)
+			s.Pick(p2, MoveRock)
+			s.RemovePlayer(p2)
+		}, record{0, 0, 0, 1, 0, 1}, record{0, 0, 0, 1, 0, 1}},
+	} {
+		now := time.Now()
+		s := NewGameServer(func() time.Time { return now }, 1)
+		p1 := s.AddPlayer()
+		p2 := s.AddPlayer()
+		codes := map[PlayerId]string{p1: getState(s, p1, t).RejoinCode, p2: getState(s, p2, t).RejoinCode}
+		rejoin := func(id PlayerId) {
+			if _, err := s.Rejoin(codes[id], 0); err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+		}
+		test.play(s, p1, p2, rejoin)
+		now = now.Add(11 * time.Second)
+		s.Tick()
+		now = now.Add(6 * time.Second)
+		s.Tick()
+
+		// Once the round is over, everyone who left comes back to see their records.
+		for _, id := range []PlayerId{p1, p2} {
+			if state, err := s.GetState(id); err != nil || state.Player.Disconnected {
+				rejoin(id)
+			}
+		}
+		for i, want := range []record{test.p1, test.p2} {
+			player := getState(s, []PlayerId{p1, p2}[i], t).Player
+			if got := (record{player.Rank, player.Wins, player.Losses, player.Draws, player.Forfeits, player.Played}); got != want {
+				t.Errorf("%s: P%d ended with %+v, want %+v", test.name, i+1, got, want)
+			}
+			if wantRated := want.Wins+want.Losses > 0; wantRated != (player.Rating != InitialRating) {
+				t.Errorf("%s: P%d ended with rating %d", test.name, i+1, player.Rating)
+			}
+		}
+	}
+
+	// Results still count for players who have left the game entirely, such as at the end of a match started early.
+	now := time.Now()
+	s := NewGameServer(func() time.Time { return now }, 1)
+	p1 := s.AddPlayer()
+	p2 := s.AddPlayer()
+	code := getState(s, p2, t).RejoinCode
+	s.RemovePlayer(p2)
+	now = now.Add(17 * time.Second)
+	s.Tick()
+	s.lock.Lock()
+	s.recordWin(p1, p2)
+	s.lock.Unlock()
+	if _, err := s.Rejoin(code, 0); err != nil {
+		t.Fatal(err)
+	}
+	if player := getState(s, p2, t).Player; player.Losses != 1 || player.Rating >= InitialRating {
+		t.Errorf("after losing while gone, P2 rejoined as %+v, want a loss", player)
+	}
+}
+
+func TestSaveAndRestore(t *testing.T) {
+	now := time.Now()
+	s := NewGameServer(func() time.Time { return now }, 1)
+	p1 := s.AddPlayer()
+	p2 := s.AddPlayer()
+	s.Pick(p1, MoveRock)
+	bindings := Bindings{Keys: [NumMoves]uint32{MoveRock: 'j'}, SwapMouseButtons: true}
+	s.SetBindings(p1, bindings)
+	now = now.Add(time.Second * 11)
+	code := getState(s, p1, t).RejoinCode
+
+	data, err := json.Marshal(s.Save())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved SavedGame
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+	restored := NewGameServer(func() time.Time { return now }, 1)
+	if err := restored.Restore(&saved); err != nil {
+		t.Fatal(err)
+	}
+	if p3 := restored.AddPlayer(); p3 == p1 || p3 == p2 {
+		t.Errorf("new player got ID %d, which was already taken", p3)
+	}
+	if id, err := restored.Rejoin(code, 0); err != nil || id != p1 {
+		t.Fatalf("Rejoin(%q) after restoring = %v, %v; want %v", code, id, err, p1)
+	}
+	state := getState(restored, p1, t)
+	if state.Player.Rank != 1 {
+		t.Errorf("rejoined with rank %d after restoring, want 1", state.Player.Rank)
+	}
+	if state.LastRound == nil || state.LastRound.Round != 1 || *state.LastRound.Matchups[0].Moves[0] != MoveRock {
+		t.Errorf("last round after restoring is %+v, want round 1 with P1's rock", state.LastRound)
+	}
+	if got, ok := restored.Bindings(p1); !ok || got != bindings {
+		t.Errorf("P1's bindings after restoring are %+v, %v; want %+v", got, ok, bindings)
+	}
+	if _, ok := restored.Bindings(p2); ok {
+		t.Error("P2 has bindings after restoring, but never changed them")
+	}
+	if err := restored.Restore(&saved); err == nil {
+		t.Error("restored a game players had joined")
+	}
+}
+
+func TestCounters(t *testing.T) {
+	now := time.Now()
+	s := NewGameServer(func() time.Time { return now }, 1)
+	s.AddPlayer()
+	s.AddPlayer()
+	now = now.Add(time.Second * 11)
+	s.Counters() // Into review.
+	now = now.Add(time.Second * 6)
+	got := s.Counters() // And into the next round.
+	want := Counters{Rounds: 2, PhaseChanges: map[Phase]int{PhasePicking: 2, PhaseReview: 1}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestChanged(t *testing.T) {
+	now := time.Unix(0, 0)
+	s := NewGameServer(func() time.Time { return now }, 1)
+	closed := func(c <-chan struct{}) bool {
+		select {
+		case <-c:
+			return true
+		default:
+			return false
+		}
+	}
+
+	changed := s.Changed()
+	p1 := s.AddPlayer()
+	if !closed(changed) {
+		t.Error("adding a player wasn't a change")
+	}
+	s.AddPlayer()
+	s.Tick()
+	changed = s.Changed()
+	s.Tick()
+	if closed(changed) {
+		t.Error("ticking without time passing was a change")
+	}
+	now = now.Add(time.Second)
+	s.Tick()
+	if !closed(changed) {
+		t.Error("a second of the round passing wasn't a change")
+	}
+	changed = s.Changed()
+	s.Pick(p1, MoveRock)
+	if !closed(changed) {
+		t.Error("picking a move wasn't a change")
+	}
+}
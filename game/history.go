@@ -0,0 +1,89 @@
+package game
+
+import "time"
+
+// PlayedMatchup is one game of a matchup from the history, with the round it was in and when it was judged.
+type PlayedMatchup struct {
+	Round int
+	Time  time.Time // Zero for games saved before the time was kept.
+	MatchupSummary
+}
+
+// HeadToHead is how two players' matches against each other have gone, from the first player's side.
+type HeadToHead struct {
+	Wins, Losses, Draws int
+}
+
+// History returns up to n of the latest games in the rounds the game remembers, newest first. If playerId is set, it
+// only returns the games that player played in.
+func (s *GameServer) History(n int, playerId PlayerId) []PlayedMatchup {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	played := []PlayedMatchup{}
+	for i := len(s.history) - 1; i >= 0 && len(played) < n; i-- {
+		round := s.history[i]
+		for _, m := range round.Matchups {
+			if len(played) < n && (playerId == 0 || m.Players[0].PlayerId == playerId || m.Players[1].PlayerId == playerId) {
+				played = append(played, PlayedMatchup{round.Round, round.Time, m})
+			}
+		}
+	}
+	return played
+}
+
+// HeadToHead returns how a player's matches against another have gone, from the first player's side, over the whole game,
+// including rounds too old for History.
+func (s *GameServer) HeadToHead(playerId, opponentId PlayerId) HeadToHead {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.headToHead(playerId, opponentId)
+}
+
+// Assumes s.lock has been obtained.
+func (s *GameServer) headToHead(playerId, opponentId PlayerId) HeadToHead {
+	record := s.records[pairing(playerId, opponentId)]
+	if playerId > opponentId {
+		record.Wins, record.Losses = record.Losses, record.Wins
+	}
+	return record
+}
+
+// tally adds the matches that ended in a round to the players' head-to-head records.
+//
+// Assumes s.lock has been obtained.
+func (s *GameServer) tally(round *RoundSummary) {
+	for _, m := range round.Matchups {
+		if m.BestOf > 1 && !m.Over {
+			continue // The match goes on.
+		}
+		winner := -1 // Which player won the match, if either did.
+		if m.BestOf > 1 {
+			if m.Score[0] > m.Score[1] {
+				winner = 0
+			} else if m.Score[1] > m.Score[0] {
+				winner = 1
+			}
+		} else if m.Winner != nil {
+			winner = 0
+			if *m.Winner == m.Players[1].PlayerId {
+				winner = 1
+			}
+		}
+		drawn := winner < 0 && (m.Score[0] > 0 || m.Moves[0] != nil && m.Moves[1] != nil)
+		if winner < 0 && !drawn {
+			continue // Nobody played.
+		}
+
+		key := pairing(m.Players[0].PlayerId, m.Players[1].PlayerId)
+		record := s.records[key]
+		switch {
+		case drawn:
+			record.Draws++
+		case m.Players[winner].PlayerId == key[0]:
+			record.Wins++
+		default:
+			record.Losses++
+		}
+		s.records[key] = record
+	}
+}
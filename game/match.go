@@ -0,0 +1,80 @@
+package game
+
+import "time"
+
+// bestOf returns how many games matches are best of. See BestOf.
+func (s *GameServer) bestOf() int {
+	if s.BestOf < 1 {
+		return 1
+	}
+	return s.BestOf
+}
+
+// endMatch ends a match, crediting whoever won more of its games.
+//
+// Assumes s.lock has been obtained.
+func (s *GameServer) endMatch(m *Matchup) {
+	m.Over = true
+	loser := -1
+	switch {
+	case m.Score[0] > m.Score[1]:
+		s.recordWin(m.Players[0], m.Players[1])
+		loser = 1
+	case m.Score[1] > m.Score[0]:
+		s.recordWin(m.Players[1], m.Players[0])
+		loser = 0
+	case m.played:
+		s.recordDraw(m.Players[0], m.Players[1])
+	}
+	for i, id := range m.Players {
+		if player := s.record(id); player != nil {
+			player.Played++
+			if i == loser && !m.picked[i] {
+				player.Forfeits++
+			}
+		}
+	}
+}
+
+// endMatches ends the matches that aren't over yet, as they stand, for starting a new round early.
+//
+// Assumes s.lock has been obtained.
+func (s *GameServer) endMatches() {
+	for _, m := range s.matchups {
+		if !m.Over {
+			s.endMatch(m)
+		}
+	}
+}
+
+// matchesOver reports whether every match this round is over, so the next round can start.
+//
+// Assumes s.lock has been obtained.
+func (s *GameServer) matchesOver() bool {
+	for _, m := range s.matchups {
+		if !m.Over {
+			return false
+		}
+	}
+	return true
+}
+
+// startGame starts the next game of the matches that aren't over, with the same players.
+//
+// Assumes s.lock has been obtained.
+func (s *GameServer) startGame(now time.Time) {
+	var playing []*Matchup
+	for _, m := range s.matchups {
+		if !m.Over {
+			m.Game++
+			m.Moves = [2]*Move{}
+			m.Winner = nil
+			m.Draws = nil
+			playing = append(playing, m)
+		}
+	}
+	s.setPhase(PhasePicking)
+	s.phaseDeadline = now.Add(s.pickDuration())
+	s.emit(EventRoundStarted, nil, s.summarizeMatchups(playing, false))
+	s.pickForBot()
+}
@@ -0,0 +1,54 @@
+package game
+
+import "sort"
+
+// swissOrder reorders ids, the players in the next round, so that pairing them off two at a time pairs each with the
+// highest-ranked player left who they haven't faced in the rounds the game remembers, or the next highest-ranked if
+// they've faced everyone left. With an odd number of players, the lowest-ranked sits out. Players with the same rank
+// keep the order they came in, which should be shuffled so they meet at random.
+//
+// Assumes s.lock has been obtained.
+func (s *GameServer) swissOrder(ids []PlayerId) []PlayerId {
+	left := append([]PlayerId(nil), ids...)
+	sort.SliceStable(left, func(i, j int) bool { return s.players[left[i]].Rank > s.players[left[j]].Rank })
+
+	met := s.opponents()
+	var order []PlayerId
+	for len(left) > 1 {
+		player := left[0]
+		opponent := 1
+		for i := 1; i < len(left); i++ {
+			if !met[pairing(player, left[i])] {
+				opponent = i
+				break
+			}
+		}
+		order = append(order, player, left[opponent])
+		left = append(left[1:opponent], left[opponent+1:]...)
+	}
+	return append(order, left...)
+}
+
+// pairing identifies two players who faced each other, whichever order they were in.
+func pairing(a, b PlayerId) [2]PlayerId {
+	if a > b {
+		a, b = b, a
+	}
+	return [2]PlayerId{a, b}
+}
+
+// opponents returns the pairings of players who've played each other in the rounds the game remembers.
+//
+// Assumes s.lock has been obtained.
+func (s *GameServer) opponents() map[[2]PlayerId]bool {
+	met := map[[2]PlayerId]bool{}
+	for _, round := range s.history {
+		for _, m := range round.Matchups {
+			if m.Moves[0] == nil && m.Moves[1] == nil {
+				continue // Nobody played.
+			}
+			met[pairing(m.Players[0].PlayerId, m.Players[1].PlayerId)] = true
+		}
+	}
+	return met
+}
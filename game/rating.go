@@ -0,0 +1,32 @@
+package game
+
+import (
+	"math"
+	"sort"
+)
+
+// Every player's Elo rating starts here. Unlike Rank, which only goes up, it rises more for beating players rated
+// higher than for beating ones rated lower, and falls with losses, so an early streak can be caught.
+const InitialRating = 1000
+
+// The most a rating can move in one matchup.
+const ratingK = 32
+
+// rate moves two players' ratings after they played each other. score is 1 if a won, 0 if b won, or 0.5 for a draw.
+func rate(a, b *PlayerInfo, score float64) {
+	expected := 1 / (1 + math.Pow(10, float64(b.Rating-a.Rating)/400))
+	change := int(math.Round(ratingK * (score - expected)))
+	a.Rating += change
+	b.Rating -= change
+}
+
+// SortByRating sorts players highest rating first, by ID where ratings are the same, for showing the rankings that
+// way instead of by rank as Standings does.
+func SortByRating(players []PlayerInfo) {
+	sort.Slice(players, func(i, j int) bool {
+		if players[i].Rating != players[j].Rating {
+			return players[i].Rating > players[j].Rating
+		}
+		return players[i].PlayerId < players[j].PlayerId
+	})
+}
@@ -0,0 +1,122 @@
+package game
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// How long a player who left can rejoin as themselves, keeping their rank. Players who leave mid-round can rejoin
+// before it ends without losing their place in it.
+const rejoinWindow = 5 * time.Minute
+
+// Rejoin codes are this many characters from rejoinCodeAlphabet, which leaves out ones that are easily mistaken for
+// others, like O and 0.
+const (
+	rejoinCodeLength   = 6
+	rejoinCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+)
+
+// departure is a player who left, and until when they can rejoin.
+type departure struct {
+	player *PlayerInfo // As of when they left, or the end of the round they left in.
+	until  time.Time
+}
+
+// Rejoin gives a player back their place in the game, with their rank and any matchup they're still in, given the
+// code from their GameState. The player must have disconnected less than five minutes ago, or still be connected,
+// such as when their viewer dropped without the server noticing yet. The code isn't case-sensitive.
+//
+// If replacing is a player, such as the one a new connection was given before its player typed their code, they leave
+// the game first, so a round doesn't start with them in it.
+func (s *GameServer) Rejoin(code string, replacing PlayerId) (PlayerId, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := s.getNow()
+	s.advance(now)
+	s.forgetDeparted(now)
+
+	code = strings.ToUpper(strings.TrimSpace(code))
+	for id, c := range s.rejoinCodes {
+		if c != code {
+			continue
+		}
+		if id == replacing {
+			return id, nil
+		}
+		if player, ok := s.players[replacing]; ok {
+			s.depart(player, now)
+		}
+		if player, ok := s.players[id]; ok {
+			player.Disconnected = false
+			s.emit(EventPlayerRejoined, player, nil)
+		} else {
+			player := *s.departed[id].player
+			player.Disconnected = false
+			s.players[id] = &player
+			s.emit(EventPlayerRejoined, &player, nil)
+			if _, people := s.playerCount(); s.phase == PhaseWaiting && s.enoughPlayers(people) {
+				s.startRound(now)
+			}
+		}
+		delete(s.departed, id)
+		s.notify()
+		active, total := s.playerCount()
+		s.logger().Info("player rejoined", "player", id, "active", active, "total", total)
+		return id, nil
+	}
+	return 0, fmt.Errorf("no player has rejoin code %q", code)
+}
+
+// depart records that a player left, keeping them for rejoinWindow in case they come back. Between rounds they're
+// removed from the game; mid-round they're marked disconnected, and removed when the round is over.
+//
+// Assumes s.lock has been obtained.
+func (s *GameServer) depart(player *PlayerInfo, now time.Time) {
+	departed := *player
+	s.departed[player.PlayerId] = departure{&departed, now.Add(rejoinWindow)}
+	if s.phase == PhaseWaiting {
+		delete(s.players, player.PlayerId)
+	} else {
+		player.Disconnected = true
+	}
+	s.emit(EventPlayerLeft, player, nil)
+	s.forgetDeparted(now)
+}
+
+// forgetDeparted forgets players who can no longer rejoin, and their codes.
+//
+// Assumes s.lock has been obtained.
+func (s *GameServer) forgetDeparted(now time.Time) {
+	for id, d := range s.departed {
+		if _, playing := s.players[id]; !playing && !now.Before(d.until) {
+			delete(s.departed, id)
+			delete(s.rejoinCodes, id)
+			delete(s.bindings, id)
+		}
+	}
+}
+
+// newRejoinCode returns a code no player has. It's random so players can't guess each other's.
+//
+// Assumes s.lock has been obtained.
+func (s *GameServer) newRejoinCode() string {
+	for {
+		buf := make([]byte, rejoinCodeLength)
+		if _, err := rand.Read(buf); err != nil {
+			panic(fmt.Sprintf("generate rejoin code: %v", err))
+		}
+		for i, b := range buf {
+			buf[i] = rejoinCodeAlphabet[int(b)%len(rejoinCodeAlphabet)]
+		}
+		code := string(buf)
+		taken := false
+		for _, c := range s.rejoinCodes {
+			taken = taken || c == code
+		}
+		if !taken {
+			return code
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package game
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// How long players restored by Restore have to rejoin, longer than rejoinWindow so they can find their codes again
+// after the server comes back.
+const restoredRejoinWindow = time.Hour
+
+// Rounds kept in SavedGame.History. Older rounds are forgotten.
+const maxHistory = 1000
+
+// SavedGame is what a game needs to pick up where it left off after a restart: every player who's still playing or
+// could rejoin, the rounds played so far, and how every pair of players' matches have gone. See Save and Restore.
+type SavedGame struct {
+	NextPlayerId int               `json:"next_player_id"`
+	Round        int               `json:"round"` // The last round started.
+	Players      []SavedPlayer     `json:"players"`
+	History      []RoundSummary    `json:"history"`                 // Judged rounds, oldest first.
+	HeadToHeads  []SavedHeadToHead `json:"head_to_heads,omitempty"` // Missing from games saved before they were kept.
+}
+
+// SavedHeadToHead is how two players' matches against each other have gone in a SavedGame, from the first player's
+// side.
+type SavedHeadToHead struct {
+	Players [2]PlayerId `json:"players"` // Lowest ID first.
+	Wins    int         `json:"wins"`
+	Losses  int         `json:"losses"`
+	Draws   int         `json:"draws"`
+}
+
+// SavedPlayer is a player in a SavedGame.
+type SavedPlayer struct {
+	PlayerId   PlayerId  `json:"id"`
+	Name       string    `json:"name"`
+	Rank       int       `json:"rank"`
+	Rating     int       `json:"rating,omitempty"` // Missing from games saved before there were ratings.
+	Wins       int       `json:"wins"`
+	Losses     int       `json:"losses"`
+	Draws      int       `json:"draws"`
+	Forfeits   int       `json:"forfeits"`
+	Played     int       `json:"played"`
+	Streak     int       `json:"streak"`
+	BestStreak int       `json:"best_streak"`
+	RejoinCode string    `json:"rejoin_code"`
+	Bindings   *Bindings `json:"bindings,omitempty"` // Missing for players who never changed them.
+}
+
+// Save returns the game's players, history, and head-to-head records for Restore.
+func (s *GameServer) Save() *SavedGame {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := s.getNow()
+	s.advance(now)
+	s.forgetDeparted(now)
+	saved := &SavedGame{NextPlayerId: s.nextPlayerId, Round: s.round, Players: []SavedPlayer{}, History: append([]RoundSummary{}, s.history...)}
+	for id, code := range s.rejoinCodes {
+		player, ok := s.players[id]
+		if !ok {
+			player = s.departed[id].player
+		}
+		saved.Players = append(saved.Players, SavedPlayer{id, player.Name, player.Rank, player.Rating, player.Wins, player.Losses,
+			player.Draws, player.Forfeits, player.Played, player.Streak, player.BestStreak, code, nil})
+		if bindings, ok := s.bindings[id]; ok {
+			saved.Players[len(saved.Players)-1].Bindings = &bindings
+		}
+	}
+	sort.Slice(saved.Players, func(i, j int) bool { return saved.Players[i].PlayerId < saved.Players[j].PlayerId })
+	for players, record := range s.records {
+		saved.HeadToHeads = append(saved.HeadToHeads, SavedHeadToHead{players, record.Wins, record.Losses, record.Draws})
+	}
+	sort.Slice(saved.HeadToHeads, func(i, j int) bool {
+		a, b := saved.HeadToHeads[i].Players, saved.HeadToHeads[j].Players
+		return a[0] < b[0] || a[0] == b[0] && a[1] < b[1]
+	})
+	return saved
+}
+
+// Restore picks up the game where saved left off. Players keep their ranks and rejoin codes but start out
+// disconnected; they have an hour to come back with Rejoin. It should be called before anyone joins.
+func (s *GameServer) Restore(saved *SavedGame) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(s.players) > 0 {
+		return fmt.Errorf("can't restore a game players have joined")
+	}
+	for _, p := range saved.Players {
+		if p.PlayerId >= PlayerId(saved.NextPlayerId) {
+			return fmt.Errorf("player %d has an ID past the next one, %d", p.PlayerId, saved.NextPlayerId)
+		}
+	}
+	until := s.getNow().Add(restoredRejoinWindow)
+	for _, p := range saved.Players {
+		s.rejoinCodes[p.PlayerId] = p.RejoinCode
+		if p.Bindings != nil {
+			s.bindings[p.PlayerId] = *p.Bindings
+		}
+		player := PlayerInfo{PlayerId: p.PlayerId, Name: p.Name, Rank: p.Rank, Rating: p.Rating, Wins: p.Wins, Losses: p.Losses,
+			Draws: p.Draws, Forfeits: p.Forfeits, Played: p.Played, Streak: p.Streak, BestStreak: p.BestStreak}
+		if player.Rating == 0 {
+			player.Rating = InitialRating
+		}
+		s.departed[p.PlayerId] = departure{&player, until}
+	}
+	s.nextPlayerId = saved.NextPlayerId
+	s.round = saved.Round
+	s.history = append([]RoundSummary{}, saved.History...)
+	for _, h := range saved.HeadToHeads {
+		s.records[pairing(h.Players[0], h.Players[1])] = HeadToHead{h.Wins, h.Losses, h.Draws}
+	}
+	if saved.HeadToHeads == nil {
+		for i := range s.history { // The best that can be done for games saved before head-to-heads were.
+			s.tally(&s.history[i])
+		}
+	}
+	if len(s.history) > 0 {
+		last := s.history[len(s.history)-1]
+		s.lastRound = &last
+	}
+	s.notify()
+	s.logger().Info("restored game", "round", s.round, "players", len(saved.Players))
+	return nil
+}
+
+// remember adds the round just judged to the history and the head-to-head records, which keep counting after the
+// history forgets it.
+//
+// Assumes s.lock has been obtained.
+func (s *GameServer) remember(round *RoundSummary) {
+	s.tally(round)
+	s.history = append(s.history, *round)
+	if len(s.history) > maxHistory {
+		s.history = append(s.history[:0], s.history[len(s.history)-maxHistory:]...)
+	}
+}
@@ -0,0 +1,22 @@
+package game
+
+// rematchIfDrawn clears m's moves if SuddenDeath is set and both players picked the same one, so they pick again,
+// pushing the round back if it's about to end.
+//
+// Assumes s.lock has been obtained.
+func (s *GameServer) rematchIfDrawn(m *Matchup) {
+	if s.SuddenDeath <= 0 || s.phase != PhasePicking || m.Moves[0] == nil || m.Moves[1] == nil {
+		return
+	}
+	if m.Moves[0].Beats(*m.Moves[1]) || m.Moves[1].Beats(*m.Moves[0]) {
+		return
+	}
+	s.emit(EventRematch, nil, s.summarizeMatchups([]*Matchup{m}, true))
+	m.Draws = append(m.Draws, *m.Moves[0])
+	m.Moves = [2]*Move{}
+	if deadline := s.getNow().Add(s.SuddenDeath); s.phaseDeadline.Before(deadline) {
+		s.phaseDeadline = deadline
+	}
+	s.logger().Info("rematch", "round", s.round, "players", m.Players, "move", m.Draws[len(m.Draws)-1])
+	s.pickForBot()
+}
@@ -0,0 +1,114 @@
+package game
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// RoundSummary is the outcome of one round, for sharing outside the game.
+type RoundSummary struct {
+	Round    int
+	Matchups []MatchupSummary
+	Leader   *PlayerInfo // Nil if nobody has played.
+	Time     time.Time   // When it was judged. Zero in games saved before it was kept.
+}
+
+type MatchupSummary struct {
+	Players [2]PlayerInfo
+	Moves   [2]*Move
+	Picked  [2]bool   // Whether each player has picked a move, which is known before the moves are shown.
+	Winner  *PlayerId // Of the game.
+
+	// With GameServer.BestOf, which game of the match this is, how many it's best of, how many games each player has
+	// won including this one once it's judged, and whether the match is over.
+	Game, BestOf int
+	Score        [2]int
+	Over         bool
+}
+
+// String formats the summary compactly enough to paste into a chat, like
+// "R12: P3 ROCK beats P7 SCISSORS | leader: P3 9".
+func (r *RoundSummary) String() string {
+	var results []string
+	for _, m := range r.Matchups {
+		results = append(results, m.String())
+	}
+	if len(results) == 0 {
+		results = append(results, "no matches")
+	}
+
+	summary := fmt.Sprintf("R%d: %s", r.Round, strings.Join(results, ", "))
+	if r.Leader != nil {
+		summary += fmt.Sprintf(" | leader: %s %d", r.Leader.Name, r.Leader.Rank)
+	}
+	return summary
+}
+
+// String formats the matchup winner first, like "P3 ROCK beats P7 SCISSORS".
+func (m MatchupSummary) String() string {
+	a, b := 0, 1
+	if m.Winner != nil && *m.Winner == m.Players[1].PlayerId {
+		a, b = 1, 0
+	}
+	verb := "ties"
+	if m.Winner != nil {
+		verb = "beats"
+	}
+	result := fmt.Sprintf("%s %s %s %s %s", m.Players[a].Name, moveName(m.Moves[a]), verb, m.Players[b].Name, moveName(m.Moves[b]))
+	if m.BestOf > 1 {
+		result += fmt.Sprintf(" (game %d of %d, %d-%d)", m.Game, m.BestOf, m.Score[a], m.Score[b])
+	}
+	return result
+}
+
+func moveName(m *Move) string {
+	if m == nil {
+		return "(no move)"
+	}
+	return m.String()
+}
+
+// summarize summarizes the game just judged in the given matchups.
+//
+// Assumes s.lock has been obtained.
+func (s *GameServer) summarize(judged []*Matchup) *RoundSummary {
+	summary := &RoundSummary{Round: s.round, Matchups: s.summarizeMatchups(judged, true), Time: s.getNow()}
+	if rankings := s.rankings(); len(rankings) > 0 {
+		summary.Leader = &rankings[0]
+	}
+	return summary
+}
+
+// matchupSummaries summarizes the round's matchups, leaving the moves out unless showMoves is set.
+//
+// Assumes s.lock has been obtained.
+func (s *GameServer) matchupSummaries(showMoves bool) []MatchupSummary {
+	return s.summarizeMatchups(s.matchups, showMoves)
+}
+
+// Assumes s.lock has been obtained.
+func (s *GameServer) summarizeMatchups(matchups []*Matchup, showMoves bool) []MatchupSummary {
+	var summaries []MatchupSummary
+	for _, m := range matchups {
+		ms := MatchupSummary{Game: m.Game, BestOf: s.bestOf(), Score: m.Score, Over: m.Over}
+		for i, id := range m.Players {
+			if player, ok := s.players[id]; ok {
+				ms.Players[i] = *player
+			} else {
+				ms.Players[i] = PlayerInfo{PlayerId: id, Name: fmt.Sprintf("P%d", id), Disconnected: true}
+			}
+			ms.Picked[i] = m.Moves[i] != nil
+			if m.Moves[i] != nil && showMoves {
+				move := *m.Moves[i]
+				ms.Moves[i] = &move
+			}
+		}
+		if m.Winner != nil {
+			winner := *m.Winner
+			ms.Winner = &winner
+		}
+		summaries = append(summaries, ms)
+	}
+	return summaries
+}
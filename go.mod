@@ -1,6 +1,6 @@
 module github.com/alltom/vncrps
 
-go 1.15
+go 1.21
 
 require (
 	golang.org/x/image v0.0.0-20201208152932-35266b937fa6
@@ -0,0 +1,368 @@
+package vncrps
+
+import (
+	"fmt"
+	"github.com/alltom/vncrps/game"
+	"net"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The longest Config.HandshakeBackoff doubles to for an address whose handshakes keep failing. Addresses that have
+// been quiet this long past their backoff start over.
+const maxHandshakeBackoff = time.Hour
+
+// guard decides which connections to turn away by where they come from: addresses on the ban list, and addresses
+// whose handshakes keep failing, until they've waited out their backoff. Config.MaxConnsPerIP is left to admit, which
+// knows what's open.
+type guard struct {
+	backoff time.Duration // See Config.HandshakeBackoff.
+	banFile string        // See Config.BanFile.
+	now     func() time.Time
+
+	lock     sync.Mutex
+	bans     map[netip.Prefix]bool
+	failures map[netip.Addr]handshakeFailures
+}
+
+// handshakeFailures is how many handshakes in a row from an address have failed, and until when it's turned away.
+type handshakeFailures struct {
+	count int
+	until time.Time
+}
+
+// newGuard returns a guard with the bans in config.BanFile, if it exists.
+func newGuard(config Config) (*guard, error) {
+	g := &guard{
+		backoff:  config.HandshakeBackoff,
+		banFile:  config.BanFile,
+		now:      time.Now,
+		bans:     map[netip.Prefix]bool{},
+		failures: map[netip.Addr]handshakeFailures{},
+	}
+	bans, err := g.readBanFile()
+	if err != nil {
+		return nil, err
+	}
+	g.bans = bans
+	return g, nil
+}
+
+// readBanFile returns the bans in the ban file, or none if there isn't one.
+func (g *guard) readBanFile() (map[netip.Prefix]bool, error) {
+	bans := map[netip.Prefix]bool{}
+	if g.banFile == "" {
+		return bans, nil
+	}
+	data, err := os.ReadFile(g.banFile)
+	if os.IsNotExist(err) {
+		return bans, nil
+	} else if err != nil {
+		return nil, err
+	}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		ban, err := parseBan(line)
+		if err != nil {
+			return nil, fmt.Errorf("%v:%d: %v", g.banFile, i+1, err)
+		}
+		bans[ban] = true
+	}
+	return bans, nil
+}
+
+// reload replaces the bans with the ones in the ban file, such as after it was edited by hand, and returns them. If the
+// file can't be read, the bans are left as they were.
+func (g *guard) reload() ([]netip.Prefix, error) {
+	bans, err := g.readBanFile()
+	if err != nil {
+		return nil, err
+	}
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.bans = bans
+	var prefixes []netip.Prefix
+	for ban := range bans {
+		prefixes = append(prefixes, ban)
+	}
+	return prefixes, nil
+}
+
+// parseBan parses an address, such as 203.0.113.7, or a network in CIDR notation, such as 203.0.113.0/24.
+func parseBan(s string) (netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.WithZone("").Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// formatBan writes a ban the way parseBan reads it, without the prefix length for single addresses.
+func formatBan(ban netip.Prefix) string {
+	if ban.IsSingleIP() {
+		return ban.Addr().String()
+	}
+	return ban.String()
+}
+
+// check returns why a connection from addr should be turned away, or "" if it shouldn't be.
+func (g *guard) check(addr netip.Addr) string {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	for ban := range g.bans {
+		if ban.Contains(addr) {
+			return "banned"
+		}
+	}
+	if g.backingOff(addr) {
+		return "failed handshakes"
+	}
+	return ""
+}
+
+// backingOff reports whether addr is turned away for failed handshakes.
+//
+// Assumes g.lock has been obtained.
+func (g *guard) backingOff(addr netip.Addr) bool {
+	f, ok := g.failures[addr]
+	return ok && g.now().Before(f.until)
+}
+
+// checkConsole returns why an admin console connection from addr should be turned away, or "" if it shouldn't be.
+// Bans don't apply to the console, but failed logins do, so its password can't be guessed quickly either.
+func (g *guard) checkConsole(addr netip.Addr) string {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.backingOff(addr) {
+		return "failed handshakes"
+	}
+	return ""
+}
+
+// handshakeFailed turns addr away for Config.HandshakeBackoff, doubled for each failure before this one in a row.
+func (g *guard) handshakeFailed(addr netip.Addr) {
+	if g.backoff == 0 {
+		return
+	}
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	now := g.now()
+	for a, f := range g.failures {
+		if now.After(f.until.Add(maxHandshakeBackoff)) {
+			delete(g.failures, a)
+		}
+	}
+	f := g.failures[addr]
+	f.count++
+	wait := g.backoff
+	for i := 1; i < f.count && wait < maxHandshakeBackoff; i++ {
+		wait *= 2
+	}
+	if wait > maxHandshakeBackoff {
+		wait = maxHandshakeBackoff
+	}
+	f.until = now.Add(wait)
+	g.failures[addr] = f
+}
+
+// handshook forgets addr's failed handshakes.
+func (g *guard) handshook(addr netip.Addr) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	delete(g.failures, addr)
+}
+
+// ban adds a ban and saves the list. If saving fails, the ban still holds until the server stops.
+func (g *guard) ban(s string) (netip.Prefix, error) {
+	ban, err := parseBan(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.bans[ban] = true
+	return ban, g.save()
+}
+
+func (g *guard) unban(s string) error {
+	ban, err := parseBan(s)
+	if err != nil {
+		return err
+	}
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if !g.bans[ban] {
+		return fmt.Errorf("%v isn't banned", formatBan(ban))
+	}
+	delete(g.bans, ban)
+	return g.save()
+}
+
+func (g *guard) banned(ban netip.Prefix) bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.bans[ban]
+}
+
+func (g *guard) list() []string {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	bans := []string{}
+	for ban := range g.bans {
+		bans = append(bans, formatBan(ban))
+	}
+	sort.Strings(bans)
+	return bans
+}
+
+// save writes the bans to the ban file, if there is one.
+//
+// Assumes g.lock has been obtained.
+func (g *guard) save() error {
+	if g.banFile == "" {
+		return nil
+	}
+	var b strings.Builder
+	b.WriteString("# Addresses and networks vncrps turns away, one per line.\n")
+	var bans []string
+	for ban := range g.bans {
+		bans = append(bans, formatBan(ban))
+	}
+	sort.Strings(bans)
+	for _, ban := range bans {
+		b.WriteString(ban + "\n")
+	}
+	if err := writeFileAtomically(g.banFile, []byte(b.String())); err != nil {
+		return fmt.Errorf("save bans: %v", err)
+	}
+	return nil
+}
+
+// remoteAddr returns the address conn comes from, or the zero Addr if it isn't an IP address, such as a UNIX socket's.
+func remoteAddr(conn net.Conn) netip.Addr {
+	addrPort, err := netip.ParseAddrPort(conn.RemoteAddr().String())
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addrPort.Addr().WithZone("").Unmap()
+}
+
+// admit tracks a connection a listener accepted, as track does, or returns why it should be turned away instead:
+// because its address is banned, has too many connections open, or is backing off after failed handshakes. Admin
+// console connections are only turned away for backing off, so admins can't lock themselves out.
+func (s *Server) admit(conn net.Conn, role connRole) (*trackedConn, string) {
+	addr := remoteAddr(conn)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if role == roleConsole && addr.IsValid() {
+		if reason := s.guard.checkConsole(addr); reason != "" {
+			return nil, reason
+		}
+	} else if addr.IsValid() {
+		if reason := s.guard.check(addr); reason != "" {
+			return nil, reason
+		}
+		if max := s.config.MaxConnsPerIP; max > 0 {
+			open := 0
+			for c := range s.conns {
+				if c.addr == addr {
+					open++
+				}
+			}
+			if open >= max {
+				return nil, "too many connections"
+			}
+		}
+	}
+	return s.trackLocked(conn, role), ""
+}
+
+// Ban turns away connections from an address, such as 203.0.113.7, or a network, such as 203.0.113.0/24, and closes
+// the ones already open, except admin console connections. The ban is saved in Config.BanFile, if it's set.
+func (s *Server) Ban(ban string) error {
+	prefix, err := s.guard.ban(ban)
+	if !prefix.IsValid() {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.log.Info("banned", "ban", formatBan(prefix))
+	s.closeBanned(prefix)
+	return err // From saving it.
+}
+
+// ReloadBans replaces the bans with the ones in Config.BanFile, such as after it was edited by hand, and closes the
+// connections they turn away, as Ban does. Nothing else in Config can change while the server runs.
+func (s *Server) ReloadBans() error {
+	if s.config.BanFile == "" {
+		return fmt.Errorf("no ban file to reload")
+	}
+	bans, err := s.guard.reload()
+	if err != nil {
+		return fmt.Errorf("reload bans: %v", err)
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.log.Info("reloaded bans", "bans", len(bans))
+	for _, ban := range bans {
+		s.closeBanned(ban)
+	}
+	return nil
+}
+
+// closeBanned closes the connections from a banned address or network, except admin console connections.
+//
+// Assumes s.lock has been obtained.
+func (s *Server) closeBanned(ban netip.Prefix) {
+	for conn := range s.conns {
+		if conn.role != roleConsole && conn.addr.IsValid() && ban.Contains(conn.addr) {
+			conn.log.Info("closing banned connection")
+			conn.Conn.Close() // See Stop.
+		}
+	}
+}
+
+// Unban lifts a ban Ban made. It has to be given the same way, such as 203.0.113.0/24 for a network.
+func (s *Server) Unban(ban string) error {
+	if err := s.guard.unban(ban); err != nil {
+		return err
+	}
+	s.log.Info("unbanned", "ban", strings.TrimSpace(ban))
+	return nil
+}
+
+// Bans returns the addresses and networks connections are turned away from, in order.
+func (s *Server) Bans() []string {
+	return s.guard.list()
+}
+
+// PlayerAddr returns the address a connected player is connecting from, for Ban.
+func (s *Server) PlayerAddr(playerId game.PlayerId) (netip.Addr, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for conn := range s.conns {
+		if conn.playing(s.game, playerId) {
+			if !conn.addr.IsValid() {
+				return netip.Addr{}, fmt.Errorf("player %d isn't connecting from an IP address", playerId)
+			}
+			return conn.addr, nil
+		}
+	}
+	return netip.Addr{}, fmt.Errorf("player %d isn't connected", playerId)
+}
@@ -0,0 +1,190 @@
+package vncrps
+
+import (
+	"errors"
+	"github.com/alltom/vncrps/rfb"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseBan(t *testing.T) {
+	for _, test := range []struct {
+		in, want string
+	}{
+		{"203.0.113.7", "203.0.113.7"},
+		{" 203.0.113.7 ", "203.0.113.7"},
+		{"::ffff:203.0.113.7", "203.0.113.7"},
+		{"203.0.113.9/24", "203.0.113.0/24"},
+		{"2001:db8::1", "2001:db8::1"},
+		{"2001:db8::/32", "2001:db8::/32"},
+		{"nonsense", ""},
+		{"203.0.113.0/33", ""},
+	} {
+		ban, err := parseBan(test.in)
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("parseBan(%q) = %v, want an error", test.in, ban)
+			}
+			continue
+		}
+		if err != nil || formatBan(ban) != test.want {
+			t.Errorf("parseBan(%q) = %v, %v, want %v", test.in, ban, err, test.want)
+		}
+	}
+}
+
+func TestGuardBackoff(t *testing.T) {
+	now := time.Now()
+	g, err := newGuard(Config{HandshakeBackoff: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.now = func() time.Time { return now }
+	addr := netip.MustParseAddr("203.0.113.7")
+
+	g.handshakeFailed(addr)
+	g.handshakeFailed(addr) // Turned away for 2s.
+	var reasons []string
+	for _, d := range []time.Duration{1500, 1000} {
+		now = now.Add(d * time.Millisecond)
+		reasons = append(reasons, g.check(addr))
+	}
+	if want := []string{"failed handshakes", ""}; !reflect.DeepEqual(reasons, want) {
+		t.Errorf("after two failures, turned away %q, want %q", reasons, want)
+	}
+
+	g.handshakeFailed(addr)
+	g.handshook(addr)
+	if reason := g.check(addr); reason != "" {
+		t.Errorf("turned away for %q after a handshake succeeded", reason)
+	}
+}
+
+func TestServerBans(t *testing.T) {
+	banFile := filepath.Join(t.TempDir(), "bans")
+	server, err := NewServer(Config{Addr: "127.0.0.1:0", MaxConnsPerIP: 1, BanFile: banFile, Seed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+	admin := server.AdminHandler()
+
+	conn, client := dial(t, server)
+	defer conn.Close()
+	if _, err := client.Update(false); err != nil {
+		t.Fatal(err)
+	}
+	assertTurnedAway := func(why string) {
+		t.Helper()
+		conn, err := net.Dial("tcp", server.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+		if _, err := conn.Read(make([]byte, 12)); err != io.EOF {
+			t.Errorf("connection %s wasn't closed right away: %v", why, err)
+		}
+	}
+	assertTurnedAway("past MaxConnsPerIP")
+
+	rec := httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/ban?addr=127.0.0.0/8", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("ban failed: %d %s", rec.Code, rec.Body)
+	}
+	for {
+		if _, err := client.Update(true); err != nil {
+			break // Closed for being banned.
+		}
+	}
+	assertTurnedAway("from a banned address")
+	if data, err := os.ReadFile(banFile); err != nil || !strings.Contains(string(data), "\n127.0.0.0/8\n") {
+		t.Errorf("ban file is %q, %v, want it to list 127.0.0.0/8", data, err)
+	}
+
+	restarted, err := NewServer(Config{Addr: "127.0.0.1:0", BanFile: banFile, Seed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bans := restarted.Bans(); !reflect.DeepEqual(bans, []string{"127.0.0.0/8"}) {
+		t.Errorf("bans after restarting = %q, want 127.0.0.0/8", bans)
+	}
+
+	rec = httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/unban?addr=127.0.0.1", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unbanning an address only a network ban covers returned %d, want 404", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/unban?addr=127.0.0.0/8", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("unban failed: %d %s", rec.Code, rec.Body)
+	}
+	if bans := server.Bans(); len(bans) != 0 {
+		t.Errorf("bans after unbanning = %q, want none", bans)
+	}
+
+	if err := os.WriteFile(banFile, []byte("# Edited by hand.\n203.0.113.7\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	rec = httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/reload", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("reload failed: %d %s", rec.Code, rec.Body)
+	}
+	if bans := server.Bans(); !reflect.DeepEqual(bans, []string{"203.0.113.7"}) {
+		t.Errorf("bans after reloading = %q, want 203.0.113.7", bans)
+	}
+}
+
+func TestServerLoginBackoff(t *testing.T) {
+	server, err := NewServer(Config{Addr: "127.0.0.1:0", ConsoleAddr: "127.0.0.1:0", Username: "admin", Password: "secret", HandshakeBackoff: time.Minute, Seed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+	login := func(addr net.Addr, password string) error {
+		conn, err := net.Dial("tcp", addr.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+		_, err = rfb.NewClient(conn, rfb.ClientConfig{Username: "admin", Password: password, Shared: true})
+		return err
+	}
+
+	conn, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close() // Dropped before logging in.
+	time.Sleep(100 * time.Millisecond)
+	if err := login(server.Addr(), "secret"); err != nil {
+		t.Errorf("logging in after a dropped connection failed: %v", err)
+	}
+
+	var failed *rfb.AuthenticationFailedError
+	if err := login(server.ConsoleAddr(), "guess"); !errors.As(err, &failed) {
+		t.Fatalf("logging in to the console with the wrong password returned %v, want it refused", err)
+	}
+	if err := login(server.ConsoleAddr(), "secret"); err == nil {
+		t.Error("logged in to the console right after a wrong password, want it to back off")
+	}
+}
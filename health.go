@@ -0,0 +1,47 @@
+package vncrps
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+)
+
+// Health is how the server is doing, as HealthHandler reports it.
+type Health struct {
+	Listening   bool   `json:"listening"` // Whether the server is accepting players on Config.Addr.
+	Goroutines  int    `json:"goroutines"`
+	Connections int    `json:"connections"` // Open connections, including viewers still logging in.
+	Players     int    `json:"players"`     // Players in the game, including any who left mid-round.
+	Phase       string `json:"phase"`       // "waiting", "picking", or "review".
+
+	Security []SecurityFinding `json:"security"` // What CheckSecurity found when the server was created.
+}
+
+// Health reports how the server is doing.
+func (s *Server) Health() Health {
+	s.lock.Lock()
+	conns := len(s.conns)
+	s.lock.Unlock()
+	return Health{
+		Listening:   s.serving.Load(),
+		Goroutines:  runtime.NumGoroutine(),
+		Connections: conns,
+		Players:     len(s.game.Standings()),
+		Phase:       s.game.Overview().Phase.String(),
+		Security:    append([]SecurityFinding{}, s.security...),
+	}
+}
+
+// HealthHandler serves the server's Health as JSON, for orchestrators and uptime monitors. The status is 200 while the
+// server is accepting players and 503 otherwise. It's cheap enough to check every second.
+func (s *Server) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		health := s.Health()
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-cache")
+		if !health.Listening {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(health)
+	})
+}
@@ -0,0 +1,180 @@
+package vncrps
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/alltom/vncrps/rfb"
+	"image"
+	"image/draw"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// InputLog records every connection's input with enough detail to replay a game exactly. Together with an FBS
+// recording of one connection, it can be turned into a regression test (see replay_test.go).
+//
+// The format is line-based text:
+//
+//	vncrps-input-log 1
+//	seed <game server seed>
+//	<ns since start> <conn> connect
+//	<ns since start> <conn> key <pressed 0|1> <keysym> [<XT scan code>]
+//	<ns since start> <conn> pointer <button mask> <x> <y>
+//	<ns since start> <conn> update <x> <y> <width> <height>
+//	<ns since start> <conn> disconnect
+//
+// A nil *InputLog records nothing.
+type InputLog struct {
+	lock     sync.Mutex
+	w        io.Writer
+	getNow   func() time.Time
+	start    time.Time
+	nextConn int
+}
+
+type InputEvent struct {
+	Time time.Duration
+	Conn int
+	Kind string // "connect", "key", "pointer", "update", or "disconnect"
+
+	Key     rfb.KeyEventMessage
+	Pointer rfb.PointerEventMessage
+	Update  image.Rectangle
+}
+
+const inputLogHeader = "vncrps-input-log 1"
+
+func NewInputLog(w io.Writer, getNow func() time.Time, seed int64) (*InputLog, error) {
+	if _, err := fmt.Fprintf(w, "%s\nseed %d\n", inputLogHeader, seed); err != nil {
+		return nil, err
+	}
+	return &InputLog{w: w, getNow: getNow, start: getNow(), nextConn: 1}, nil
+}
+
+// Wrap returns a handler that records a new connection's input before passing it on to h.
+func (l *InputLog) Wrap(h rfb.Handler) rfb.Handler {
+	if l == nil {
+		return h
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	conn := l.nextConn
+	l.nextConn++
+	l.printf(conn, "connect")
+	return &loggingHandler{h, l, conn}
+}
+
+type loggingHandler struct {
+	rfb.Handler
+	log  *InputLog
+	conn int
+}
+
+func (h *loggingHandler) Render(img draw.Image, rect image.Rectangle) {
+	h.log.record(h.conn, "update %d %d %d %d", rect.Min.X, rect.Min.Y, rect.Dx(), rect.Dy())
+	h.Handler.Render(img, rect)
+}
+
+func (h *loggingHandler) KeyEvent(m *rfb.KeyEventMessage) {
+	if m.KeyCode != 0 {
+		h.log.record(h.conn, "key %d %d %d", boolInt(m.Pressed), m.KeySym, m.KeyCode)
+	} else {
+		h.log.record(h.conn, "key %d %d", boolInt(m.Pressed), m.KeySym)
+	}
+	h.Handler.KeyEvent(m)
+}
+
+func (h *loggingHandler) PointerEvent(m *rfb.PointerEventMessage) {
+	h.log.record(h.conn, "pointer %d %d %d", m.ButtonMask, m.X, m.Y)
+	h.Handler.PointerEvent(m)
+}
+
+func (h *loggingHandler) Close() error {
+	h.log.record(h.conn, "disconnect")
+	if closer, ok := h.Handler.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (l *InputLog) record(conn int, format string, args ...interface{}) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.printf(conn, format, args...)
+}
+
+// Assumes l.lock has been obtained.
+func (l *InputLog) printf(conn int, format string, args ...interface{}) {
+	prefix := fmt.Sprintf("%d %d ", l.getNow().Sub(l.start).Nanoseconds(), conn)
+	if _, err := fmt.Fprintf(l.w, prefix+format+"\n", args...); err != nil {
+		slog.Warn("couldn't write input log", "err", err)
+	}
+}
+
+func ReadInputLog(r io.Reader) (seed int64, events []InputEvent, err error) {
+	scanner := bufio.NewScanner(r)
+	if !scanner.Scan() || scanner.Text() != inputLogHeader {
+		return 0, nil, fmt.Errorf("expected header %q", inputLogHeader)
+	}
+	if !scanner.Scan() {
+		return 0, nil, fmt.Errorf("missing seed")
+	}
+	if _, err := fmt.Sscanf(scanner.Text(), "seed %d", &seed); err != nil {
+		return 0, nil, fmt.Errorf("parse seed: %v", err)
+	}
+
+	for lineNo := 3; scanner.Scan(); lineNo++ {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		var e InputEvent
+		var ns int64
+		var rest string
+		if n, _ := fmt.Sscanf(line, "%d %d %s", &ns, &e.Conn, &e.Kind); n != 3 {
+			return 0, nil, fmt.Errorf("line %d: expected time, connection, and kind", lineNo)
+		}
+		e.Time = time.Duration(ns)
+		if fields := strings.SplitN(line, " ", 4); len(fields) == 4 {
+			rest = fields[3]
+		}
+
+		var pressed, x, y, width, height int
+		var n int
+		var wantN int
+		switch e.Kind {
+		case "connect", "disconnect":
+		case "key":
+			n, err = fmt.Sscanf(rest, "%d %d %d", &pressed, &e.Key.KeySym, &e.Key.KeyCode)
+			e.Key.Pressed = pressed != 0
+			wantN = 2 // The scan code is optional.
+		case "pointer":
+			n, err = fmt.Sscanf(rest, "%d %d %d", &e.Pointer.ButtonMask, &e.Pointer.X, &e.Pointer.Y)
+			wantN = 3
+		case "update":
+			n, err = fmt.Sscanf(rest, "%d %d %d %d", &x, &y, &width, &height)
+			e.Update = image.Rect(x, y, x+width, y+height)
+			wantN = 4
+		default:
+			return 0, nil, fmt.Errorf("line %d: unrecognized event %q", lineNo, e.Kind)
+		}
+		if n < wantN {
+			return 0, nil, fmt.Errorf("line %d: parse %s: %v", lineNo, e.Kind, err)
+		}
+		events = append(events, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, nil, err
+	}
+	return seed, events, nil
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
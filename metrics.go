@@ -0,0 +1,117 @@
+package vncrps
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/alltom/vncrps/game"
+	"github.com/alltom/vncrps/rfb"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MetricsHandler serves metrics about the game and players' connections in Prometheus's text format, for dashboards.
+// Connection metrics are labelled with the connection and player IDs that log lines are tagged with.
+func (s *Server) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		m := &metricsWriter{w: bufio.NewWriter(w)}
+		s.writeMetrics(m)
+		m.w.Flush()
+	})
+}
+
+// connMetrics is one player's connection, as MetricsHandler reports it.
+type connMetrics struct {
+	id     uint64
+	player game.PlayerId
+	stats  rfb.ConnStats
+}
+
+func (s *Server) writeMetrics(m *metricsWriter) {
+	counters := s.game.Counters()
+
+	s.lock.Lock()
+	open := len(s.conns)
+	var joined []*trackedConn
+	for conn := range s.conns {
+		if conn.ui != nil {
+			joined = append(joined, conn)
+		}
+	}
+	s.lock.Unlock()
+	var conns []connMetrics
+	for _, conn := range joined {
+		if stats, ok := s.rfb.Stats(conn); ok {
+			conns = append(conns, connMetrics{conn.id, conn.player, stats})
+		}
+	}
+	sort.Slice(conns, func(i, j int) bool { return conns[i].id < conns[j].id })
+
+	m.family("vncrps_players", "gauge", "Players in the game, including any who left mid-round.")
+	m.sample("vncrps_players", nil, float64(len(s.game.Standings())))
+	m.family("vncrps_connections", "gauge", "Open connections, including viewers still logging in.")
+	m.sample("vncrps_connections", nil, float64(open))
+	m.family("vncrps_rounds_total", "counter", "Rounds started.")
+	m.sample("vncrps_rounds_total", nil, float64(counters.Rounds))
+	m.family("vncrps_phase_changes_total", "counter", "Times the game entered each phase.")
+	for _, phase := range []game.Phase{game.PhaseWaiting, game.PhasePicking, game.PhaseReview} {
+		m.sample("vncrps_phase_changes_total", []string{"phase", phase.String()}, float64(counters.PhaseChanges[phase]))
+	}
+	m.family("vncrps_handshake_failures_total", "counter", "Viewers that disconnected or were refused before joining.")
+	m.sample("vncrps_handshake_failures_total", nil, float64(s.handshakeFailures.Load()))
+	m.family("vncrps_turned_away_total", "counter", "Connections closed right away for being banned, from an address with too many open, or backing off after failed handshakes.")
+	m.sample("vncrps_turned_away_total", nil, float64(s.turnedAway.Load()))
+	m.family("vncrps_sent_bytes_total", "counter", "Bytes sent to viewers, including ones that have disconnected.")
+	m.sample("vncrps_sent_bytes_total", nil, float64(s.bytesSent.Load()))
+	m.family("vncrps_received_bytes_total", "counter", "Bytes received from viewers, including ones that have disconnected.")
+	m.sample("vncrps_received_bytes_total", nil, float64(s.bytesReceived.Load()))
+
+	m.family("vncrps_connection_fps", "gauge", "Framebuffer updates sent to each player a second, averaged since they connected.")
+	for _, c := range conns {
+		m.sample("vncrps_connection_fps", c.labels(), float64(c.stats.FramebufferUpdates)/time.Since(c.stats.Connected).Seconds())
+	}
+	m.family("vncrps_connection_sent_bytes_total", "counter", "Bytes sent to each player.")
+	for _, c := range conns {
+		m.sample("vncrps_connection_sent_bytes_total", c.labels(), float64(c.stats.BytesWritten))
+	}
+	m.family("vncrps_connection_received_bytes_total", "counter", "Bytes received from each player.")
+	for _, c := range conns {
+		m.sample("vncrps_connection_received_bytes_total", c.labels(), float64(c.stats.BytesRead))
+	}
+	m.family("vncrps_connection_write_latency_seconds", "gauge", "How long each player's recent framebuffer updates took to write, on average.")
+	for _, c := range conns {
+		m.sample("vncrps_connection_write_latency_seconds", c.labels(), c.stats.WriteLatency.Seconds())
+	}
+	m.family("vncrps_connection_update_latency_seconds", "gauge", "How long each player's last framebuffer update took, from request to sent.")
+	for _, c := range conns {
+		m.sample("vncrps_connection_update_latency_seconds", c.labels(), c.stats.UpdateLatency.Seconds())
+	}
+}
+
+func (c connMetrics) labels() []string {
+	return []string{"conn", fmt.Sprint(c.id), "player", fmt.Sprint(c.player)}
+}
+
+// metricsWriter writes Prometheus's text exposition format.
+type metricsWriter struct {
+	w *bufio.Writer
+}
+
+func (m *metricsWriter) family(name, kind, help string) {
+	fmt.Fprintf(m.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// sample writes one value, with labels given as alternating names and values.
+func (m *metricsWriter) sample(name string, labels []string, value float64) {
+	m.w.WriteString(name)
+	if len(labels) > 0 {
+		var pairs []string
+		for i := 0; i+1 < len(labels); i += 2 {
+			pairs = append(pairs, fmt.Sprintf("%s=%q", labels[i], labels[i+1]))
+		}
+		fmt.Fprintf(m.w, "{%s}", strings.Join(pairs, ","))
+	}
+	fmt.Fprintf(m.w, " %g\n", value)
+}
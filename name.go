@@ -0,0 +1,38 @@
+package vncrps
+
+import (
+	"github.com/alltom/vncrps/game"
+	"github.com/alltom/vncrps/rfb/keysym"
+	"strings"
+)
+
+// typeName handles a key pressed while the player types the name they want to be shown by: Return saves it, Escape
+// keeps the one they have, and BackSpace takes back the last character.
+func (ui *UI) typeName(keySym uint32) {
+	ui.nameError = ""
+	switch {
+	case keySym == keysym.Escape:
+		ui.typingName = false
+	case keySym == keysym.BackSpace:
+		if ui.typedName != "" {
+			ui.typedName = ui.typedName[:len(ui.typedName)-1]
+		}
+	case keySym == keysym.Return:
+		ui.typingName = false
+		if strings.TrimSpace(ui.typedName) != "" {
+			ui.rename(ui.typedName)
+		}
+	case ' ' <= keySym && keySym <= '~' && len(ui.typedName) < game.MaxNameLength:
+		ui.typedName += string(rune(keySym))
+	}
+}
+
+// rename shows the player as name, or has them keep typing if they can't be called that.
+func (ui *UI) rename(name string) {
+	if err := ui.server.Rename(ui.playerId, name); err != nil {
+		message := err.Error()
+		ui.typingName, ui.nameError = true, strings.ToUpper(message[:1])+message[1:]+"."
+		return
+	}
+	ui.name = strings.TrimSpace(name)
+}
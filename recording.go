@@ -0,0 +1,35 @@
+package vncrps
+
+import (
+	"fmt"
+	"github.com/alltom/vncrps/rfb"
+	"github.com/alltom/vncrps/rfb/fbs"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// record starts recording what a player is sent in a new FBS file in Config.RecordDir. It's the rfb.Server's Record
+// hook.
+func (s *Server) record(conn io.ReadWriter, serverInit *rfb.ServerInitialisationMessage) io.Writer {
+	name := time.Now().Format("20060102-150405.000")
+	if tc, ok := conn.(*trackedConn); ok {
+		s.lock.Lock()
+		name += fmt.Sprintf("-player%d", tc.player)
+		s.lock.Unlock()
+	}
+	path := filepath.Join(s.config.RecordDir, name+".fbs")
+	f, err := os.Create(path)
+	if err != nil {
+		s.connLog(conn).Warn("couldn't record session", "err", err)
+		return nil
+	}
+	w, err := fbs.NewSessionWriter(f, serverInit)
+	if err != nil {
+		f.Close()
+		s.connLog(conn).Warn("couldn't record session", "path", path, "err", err)
+		return nil
+	}
+	return w
+}
@@ -0,0 +1,48 @@
+package vncrps
+
+import (
+	"github.com/alltom/vncrps/rfb/keysym"
+	"strings"
+)
+
+// Longer than any rejoin code, so players can see they've typed too much.
+const maxTypedCode = 12
+
+// typeCode handles a key pressed while the player types the rejoin code they had before: Return rejoins as that player,
+// Escape gives up, and BackSpace takes back the last character.
+func (ui *UI) typeCode(keySym uint32) {
+	switch {
+	case keySym == keysym.Escape:
+		ui.typingCode = false
+	case keySym == keysym.BackSpace:
+		if ui.typedCode != "" {
+			ui.typedCode = ui.typedCode[:len(ui.typedCode)-1]
+		}
+	case keySym == keysym.Return:
+		ui.typingCode = false
+		ui.rejoin(ui.typedCode)
+	case len(ui.typedCode) < maxTypedCode && ('a' <= keySym && keySym <= 'z' || 'A' <= keySym && keySym <= 'Z' || '0' <= keySym && keySym <= '9'):
+		ui.typedCode += strings.ToUpper(string(rune(keySym)))
+	}
+}
+
+// rejoin makes the player who they were when they had code, with that player's rank, place in the round, and bindings,
+// leaving the player they were given on connecting.
+func (ui *UI) rejoin(code string) {
+	playerId, err := ui.server.Rejoin(code, ui.playerId)
+	switch {
+	case err != nil:
+		ui.rejoinError = "No such code."
+		return
+	case playerId == ui.playerId:
+		ui.rejoinError = "That's your code."
+		return
+	}
+	ui.playerId = playerId
+	ui.loadBindings()
+	ui.settingsOpen = false
+	ui.typingName = false // They have the name they had.
+	if ui.rejoined != nil {
+		ui.rejoined(playerId)
+	}
+}
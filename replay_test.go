@@ -0,0 +1,235 @@
+package vncrps
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"flag"
+	"fmt"
+	"github.com/alltom/vncrps/game"
+	"github.com/alltom/vncrps/rfb/fbs"
+	"image"
+	"image/draw"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var updateReplays = flag.Bool("update-replays", false, "Regenerate testdata/replays/*/conn*.golden from the input logs, after checking them against any FBS recordings.")
+
+// Replayed frames may differ slightly from recorded ones because the countdown may tick over at a slightly different
+// time and the client's pixel format may be lossy.
+const maxRecordingDifference = 0.05
+
+// TestReplays replays each capture in testdata/replays. A capture is a directory containing:
+//
+//	input.log        the server's -input-log
+//	connN.fbs[.gz]   optional FBS recording of connection N's session, in the usual RFB 3.3 form
+//	connN.golden     SHA-256 of connection N's framebuffer after each update, one per line
+//
+// To turn a bug report into a test, drop the input log and recording into a new directory and run
+//
+//	go test . -run TestReplays -update-replays
+//
+// which checks that replaying the input log reproduces the recording and then writes the golden file.
+func TestReplays(t *testing.T) {
+	dirs, err := filepath.Glob(filepath.Join("testdata", "replays", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range dirs {
+		dir := dir
+		t.Run(filepath.Base(dir), func(t *testing.T) {
+			f, err := os.Open(filepath.Join(dir, "input.log"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			seed, events, err := ReadInputLog(f)
+			if err != nil {
+				t.Fatalf("read input log: %v", err)
+			}
+
+			for _, conn := range capturedConns(t, dir) {
+				frames := replay(t, seed, events, conn)
+				goldenPath := filepath.Join(dir, fmt.Sprintf("conn%d.golden", conn))
+				if *updateReplays {
+					checkRecording(t, dir, conn, frames)
+					writeGolden(t, goldenPath, frames)
+					continue
+				}
+				checkGolden(t, goldenPath, frames)
+			}
+		})
+	}
+}
+
+var capturePattern = regexp.MustCompile(`^conn(\d+)\.(fbs|fbs\.gz|golden)$`)
+
+func capturedConns(t *testing.T, dir string) []int {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[int]bool{}
+	var conns []int
+	for _, file := range files {
+		if match := capturePattern.FindStringSubmatch(file.Name()); match != nil {
+			conn, _ := strconv.Atoi(match[1])
+			if !seen[conn] {
+				seen[conn] = true
+				conns = append(conns, conn)
+			}
+		}
+	}
+	return conns
+}
+
+// Plays the events against a fake clock and returns connection conn's framebuffer after each of its update requests.
+func replay(t *testing.T, seed int64, events []InputEvent, conn int) []*image.RGBA {
+	epoch := time.Unix(0, 0)
+	now := epoch
+	s := game.NewGameServer(func() time.Time { return now }, seed)
+
+	uis := map[int]*UI{}
+	framebuffer := image.NewRGBA(image.Rect(0, 0, UIWidth, UIHeight))
+	var frames []*image.RGBA
+
+	for _, e := range events {
+		now = epoch.Add(e.Time)
+		if e.Kind == "connect" {
+			uis[e.Conn] = NewUI(s)
+			uis[e.Conn].Resize(UIWidth, UIHeight)
+			continue
+		}
+		ui, ok := uis[e.Conn]
+		if !ok {
+			t.Fatalf("%s event at %v for connection %d, which isn't connected", e.Kind, e.Time, e.Conn)
+		}
+
+		switch e.Kind {
+		case "disconnect":
+			ui.Close()
+			delete(uis, e.Conn)
+		case "key":
+			ui.KeyEvent(&e.Key)
+		case "pointer":
+			ui.PointerEvent(&e.Pointer)
+		case "update":
+			img := image.NewRGBA(e.Update)
+			ui.Render(img, e.Update)
+			if e.Conn == conn {
+				draw.Draw(framebuffer, img.Bounds(), img, img.Bounds().Min, draw.Src)
+				frame := image.NewRGBA(framebuffer.Bounds())
+				draw.Draw(frame, frame.Bounds(), framebuffer, image.ZP, draw.Src)
+				frames = append(frames, frame)
+			}
+		}
+	}
+	return frames
+}
+
+func checkRecording(t *testing.T, dir string, conn int, frames []*image.RGBA) {
+	var r io.Reader
+	path := filepath.Join(dir, fmt.Sprintf("conn%d.fbs", conn))
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		f, err = os.Open(path + ".gz")
+		if os.IsNotExist(err) {
+			return
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatalf("decompress %s.gz: %v", path, err)
+		}
+		r = gz
+	} else if err != nil {
+		t.Fatal(err)
+	} else {
+		defer f.Close()
+		r = f
+	}
+
+	fr, err := fbs.NewReader(r)
+	if err != nil {
+		t.Fatalf("read %s: %v", path, err)
+	}
+	recorded, err := fbs.DecodeFrames(fr)
+	if err != nil {
+		t.Fatalf("decode %s: %v", path, err)
+	}
+	if len(recorded) != len(frames) {
+		t.Fatalf("connection %d: recording has %d frames, but replay produced %d", conn, len(recorded), len(frames))
+	}
+	for i := range frames {
+		if d := difference(recorded[i], frames[i]); d > maxRecordingDifference {
+			t.Fatalf("connection %d frame %d: %.1f%% of pixels differ from the recording", conn, i, d*100)
+		}
+	}
+}
+
+// Returns the fraction of pixels that differ noticeably.
+func difference(a, b *image.RGBA) float64 {
+	if a.Bounds() != b.Bounds() {
+		return 1
+	}
+	var differing int
+	for i := 0; i < len(a.Pix); i += 4 {
+		for c := 0; c < 3; c++ {
+			d := int(a.Pix[i+c]) - int(b.Pix[i+c])
+			if d > 8 || d < -8 {
+				differing++
+				break
+			}
+		}
+	}
+	return float64(differing) / float64(len(a.Pix)/4)
+}
+
+func frameHash(frame *image.RGBA) string {
+	return fmt.Sprintf("%x", sha256.Sum256(frame.Pix))
+}
+
+func writeGolden(t *testing.T, path string, frames []*image.RGBA) {
+	var lines []string
+	for _, frame := range frames {
+		lines = append(lines, frameHash(frame))
+	}
+	if err := ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkGolden(t *testing.T, path string, frames []*image.RGBA) {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("%v (run with -update-replays to create it)", err)
+	}
+	defer f.Close()
+	var want []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		want = append(want, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(want) != len(frames) {
+		t.Fatalf("%s has %d frames, but replay produced %d", path, len(want), len(frames))
+	}
+	for i, frame := range frames {
+		if got := frameHash(frame); got != want[i] {
+			t.Errorf("%s: frame %d differs", path, i)
+		}
+	}
+}
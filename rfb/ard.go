@@ -0,0 +1,206 @@
+package rfb
+
+import (
+	"crypto/aes"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/big"
+)
+
+// SecurityTypeARD is Apple Remote Desktop authentication, which lets macOS Screen Sharing log in with a username and
+// password. The credentials are encrypted with a key derived from a Diffie-Hellman exchange:
+//
+//	server sends ARDChallengeMessage
+//	client sends ARDResponseMessage
+//	server sends SecurityResultMessageRFB38
+const SecurityTypeARD = SecurityType(30)
+
+// ARDGenerator and ARDPrime are the 1024-bit MODP group from RFC 2409, section 6.2.
+var (
+	ARDGenerator = uint16(2)
+	ARDPrime, _  = new(big.Int).SetString(""+
+		"FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD1"+
+		"29024E088A67CC74020BBEA63B139B22514A08798E3404DD"+
+		"EF9519B3CD3A431B302B0A6DF25F14374FE1356D6D51C245"+
+		"E485B576625E7EC6F44C42E9A637ED6B0BFF5CB6F406B7ED"+
+		"EE386BFB5A899FA5AE9F24117C4B1FE649286651ECE65381"+
+		"FFFFFFFFFFFFFFFF", 16)
+)
+
+const ardCredentialLength = 64
+
+type ARDChallengeMessage struct {
+	Generator uint16
+	Prime     []byte // Big-endian. Its length is the key length.
+	PublicKey []byte // Big-endian, same length as Prime.
+}
+
+func (m *ARDChallengeMessage) Read(r io.Reader, bo binary.ByteOrder) error {
+	var buf [4]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return err
+	}
+	m.Generator = bo.Uint16(buf[0:])
+	keyLength := int(bo.Uint16(buf[2:]))
+	if keyLength > 1024 {
+		return fmt.Errorf("key is too long: %d > %d bytes", keyLength, 1024)
+	}
+	m.Prime = make([]byte, keyLength)
+	if _, err := io.ReadFull(r, m.Prime); err != nil {
+		return err
+	}
+	m.PublicKey = make([]byte, keyLength)
+	if _, err := io.ReadFull(r, m.PublicKey); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (m *ARDChallengeMessage) Write(w io.Writer, bo binary.ByteOrder) error {
+	if len(m.Prime) != len(m.PublicKey) {
+		return fmt.Errorf("prime and public key must be the same length, but are %d and %d bytes", len(m.Prime), len(m.PublicKey))
+	}
+	var buf [4]byte
+	bo.PutUint16(buf[0:], m.Generator)
+	bo.PutUint16(buf[2:], uint16(len(m.Prime)))
+	if _, err := w.Write(buf[:]); err != nil {
+		return err
+	}
+	if _, err := w.Write(m.Prime); err != nil {
+		return err
+	}
+	if _, err := w.Write(m.PublicKey); err != nil {
+		return err
+	}
+	return nil
+}
+
+type ARDResponseMessage struct {
+	Ciphertext [2 * ardCredentialLength]byte // AES-128-ECB encrypted username and password, each NUL-terminated in 64 bytes.
+	PublicKey  []byte                        // Must be the same length as the challenge's prime.
+}
+
+func (m *ARDResponseMessage) Read(r io.Reader, keyLength int) error {
+	if _, err := io.ReadFull(r, m.Ciphertext[:]); err != nil {
+		return err
+	}
+	m.PublicKey = make([]byte, keyLength)
+	if _, err := io.ReadFull(r, m.PublicKey); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (m *ARDResponseMessage) Write(w io.Writer) error {
+	if _, err := w.Write(m.Ciphertext[:]); err != nil {
+		return err
+	}
+	if _, err := w.Write(m.PublicKey); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ARDAuthenticate performs the server side of SecurityTypeARD and returns the username and password the client sent.
+// The caller is responsible for checking them (see ARDCredentialsMatch) and sending the security result.
+func ARDAuthenticate(rw io.ReadWriter, bo binary.ByteOrder) (username, password string, err error) {
+	keyLength := (ARDPrime.BitLen() + 7) / 8
+	privateKey, err := rand.Int(rand.Reader, ARDPrime)
+	if err != nil {
+		return "", "", fmt.Errorf("generate private key: %v", err)
+	}
+	publicKey := new(big.Int).Exp(big.NewInt(int64(ARDGenerator)), privateKey, ARDPrime)
+
+	challenge := ARDChallengeMessage{
+		Generator: ARDGenerator,
+		Prime:     ARDPrime.FillBytes(make([]byte, keyLength)),
+		PublicKey: publicKey.FillBytes(make([]byte, keyLength)),
+	}
+	if err := challenge.Write(rw, bo); err != nil {
+		return "", "", fmt.Errorf("write ARD challenge: %v", err)
+	}
+
+	var response ARDResponseMessage
+	if err := response.Read(rw, keyLength); err != nil {
+		return "", "", fmt.Errorf("read ARD response: %v", err)
+	}
+	clientKey := new(big.Int).SetBytes(response.PublicKey)
+	if clientKey.Cmp(big.NewInt(1)) <= 0 || clientKey.Cmp(new(big.Int).Sub(ARDPrime, big.NewInt(1))) >= 0 {
+		return "", "", fmt.Errorf("client public key is out of range")
+	}
+	shared := new(big.Int).Exp(clientKey, privateKey, ARDPrime)
+
+	plaintext, err := ardCrypt(shared.FillBytes(make([]byte, keyLength)), response.Ciphertext[:], false)
+	if err != nil {
+		return "", "", err
+	}
+	return cString(plaintext[:ardCredentialLength]), cString(plaintext[ardCredentialLength:]), nil
+}
+
+// ARDRespond computes the client's response to an ARD challenge.
+func ARDRespond(challenge *ARDChallengeMessage, username, password string) (*ARDResponseMessage, error) {
+	if len(username) >= ardCredentialLength || len(password) >= ardCredentialLength {
+		return nil, fmt.Errorf("username and password must be shorter than %d bytes", ardCredentialLength)
+	}
+	prime := new(big.Int).SetBytes(challenge.Prime)
+	privateKey, err := rand.Int(rand.Reader, prime)
+	if err != nil {
+		return nil, fmt.Errorf("generate private key: %v", err)
+	}
+	publicKey := new(big.Int).Exp(big.NewInt(int64(challenge.Generator)), privateKey, prime)
+	shared := new(big.Int).Exp(new(big.Int).SetBytes(challenge.PublicKey), privateKey, prime)
+
+	// Unused bytes after each NUL terminator are random so the ciphertext doesn't leak the lengths.
+	var plaintext [2 * ardCredentialLength]byte
+	if _, err := rand.Read(plaintext[:]); err != nil {
+		return nil, err
+	}
+	copy(plaintext[:], username+"\x00")
+	copy(plaintext[ardCredentialLength:], password+"\x00")
+
+	ciphertext, err := ardCrypt(shared.FillBytes(make([]byte, len(challenge.Prime))), plaintext[:], true)
+	if err != nil {
+		return nil, err
+	}
+	response := &ARDResponseMessage{PublicKey: publicKey.FillBytes(make([]byte, len(challenge.Prime)))}
+	copy(response.Ciphertext[:], ciphertext)
+	return response, nil
+}
+
+// ARDCredentialsMatch compares credentials in constant time.
+func ARDCredentialsMatch(username, password, wantUsername, wantPassword string) bool {
+	u := subtle.ConstantTimeCompare([]byte(username), []byte(wantUsername))
+	p := subtle.ConstantTimeCompare([]byte(password), []byte(wantPassword))
+	return u&p == 1
+}
+
+// AES-128-ECB with the MD5 of the shared secret as the key.
+func ardCrypt(sharedSecret, in []byte, encrypt bool) ([]byte, error) {
+	key := md5.Sum(sharedSecret)
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, fmt.Errorf("create cipher: %v", err)
+	}
+	out := make([]byte, len(in))
+	for i := 0; i < len(in); i += block.BlockSize() {
+		if encrypt {
+			block.Encrypt(out[i:], in[i:])
+		} else {
+			block.Decrypt(out[i:], in[i:])
+		}
+	}
+	return out, nil
+}
+
+func cString(buf []byte) string {
+	for i, b := range buf {
+		if b == 0 {
+			return string(buf[:i])
+		}
+	}
+	return string(buf)
+}
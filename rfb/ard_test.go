@@ -0,0 +1,46 @@
+package rfb
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+func TestARDRoundTrip(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+	bo := binary.BigEndian
+
+	errc := make(chan error, 1)
+	go func() {
+		var challenge ARDChallengeMessage
+		if err := challenge.Read(client, bo); err != nil {
+			errc <- err
+			return
+		}
+		response, err := ARDRespond(&challenge, "alice", "hunter2")
+		if err != nil {
+			errc <- err
+			return
+		}
+		errc <- response.Write(client)
+	}()
+
+	username, password, err := ARDAuthenticate(server, bo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if username != "alice" || password != "hunter2" {
+		t.Fatalf("credentials should be alice/hunter2, but are %s/%s", username, password)
+	}
+	if !ARDCredentialsMatch(username, password, "alice", "hunter2") {
+		t.Fatalf("credentials should match")
+	}
+	if ARDCredentialsMatch(username, password, "alice", "hunter3") {
+		t.Fatalf("credentials shouldn't match")
+	}
+}
@@ -0,0 +1,417 @@
+package rfb
+
+import (
+	"bufio"
+	"crypto/des"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// ClientConfig describes how a Client should log in.
+type ClientConfig struct {
+	// Used for VNC authentication, and with Username for Apple Remote Desktop authentication.
+	Username, Password string
+
+	// If false, the server is asked to disconnect all other clients.
+	Shared bool
+
+	// If set, the server is asked to send pixels in this format instead of its own.
+	PixelFormat *PixelFormat
+}
+
+// Client is the viewer side of an RFB connection. It keeps a copy of the server's framebuffer up to date as updates
+// arrive. Only the Raw, CopyRect, RRE, CoRRE, Hextile, Zlib, Tight, TightPNG, TRLE, and ZRLE encodings are supported so
+// far.
+//
+// Methods that send messages may be called concurrently with ReadMessage, but not with each other.
+type Client struct {
+	r  *bufio.Reader
+	w  *bufio.Writer
+	bo binary.ByteOrder
+
+	Version     ProtocolVersionMessage // The version both sides agreed on.
+	Name        string
+	PixelFormat PixelFormat
+	Framebuffer *image.RGBA   // Updated by ReadMessage, which replaces it if the server resizes it.
+	Cursor      *Cursor       // The pointer shape the server last sent, if any. Updated by ReadMessage.
+	ColourMap   color.Palette // The colors of pixel values if PixelFormat isn't true color. Updated by ReadMessage.
+
+	// Whether the server has acknowledged QEMU extended key events, so ExtendedKeyEvent may be used. Updated by
+	// ReadMessage.
+	ExtendedKeyEvents bool
+
+	decoders Decoders
+
+	writeLock sync.Mutex // Held while sending a message, since ReadMessage answers fences.
+
+	serverClipboard   atomic.Value // The server's Extended Clipboard capabilities, once it sends them.
+	sentClipboardCaps bool
+}
+
+// NewClient performs the handshake as a viewer over conn, which may speak RFB 3.3, 3.7, or 3.8.
+func NewClient(conn io.ReadWriter, config ClientConfig) (*Client, error) {
+	c := &Client{r: bufio.NewReader(conn), w: bufio.NewWriter(conn), bo: binary.BigEndian}
+	rw := &clientHandshake{c}
+
+	var serverVersion ProtocolVersionMessage
+	if err := serverVersion.Read(rw); err != nil {
+		return nil, fmt.Errorf("read ProtocolVersion: %v", err)
+	}
+	if serverVersion.Major != 3 {
+		return nil, fmt.Errorf("only version 3.x is supported, but server offered %d.%d", serverVersion.Major, serverVersion.Minor)
+	}
+	c.Version = serverVersion.Handshake()
+	if err := c.Version.Write(rw); err != nil {
+		return nil, fmt.Errorf("write ProtocolVersion: %v", err)
+	}
+
+	if c.Version.AtLeast(3, 7) {
+		if err := c.negotiateSecurity(rw, config); err != nil {
+			return nil, err
+		}
+	} else {
+		if err := c.negotiateSecurityRFB33(rw, config); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := (&ClientInitialisationMessage{Shared: config.Shared}).Write(rw); err != nil {
+		return nil, fmt.Errorf("write ClientInitialisation: %v", err)
+	}
+	var serverInit ServerInitialisationMessage
+	if err := serverInit.Read(rw, c.bo); err != nil {
+		return nil, fmt.Errorf("read ServerInitialisation: %v", err)
+	}
+	c.Name = serverInit.Name
+	c.PixelFormat = serverInit.PixelFormat
+	c.Framebuffer = image.NewRGBA(image.Rect(0, 0, int(serverInit.FramebufferWidth), int(serverInit.FramebufferHeight)))
+
+	if config.PixelFormat != nil {
+		if err := c.SendMessage(&SetPixelFormatMessage{PixelFormat: *config.PixelFormat}); err != nil {
+			return nil, err
+		}
+		c.PixelFormat = *config.PixelFormat
+	}
+	if err := c.SendMessage(&SetEncodingsMessage{EncodingTypes: []int32{EncodingTypeCopyRectangle, EncodingTypeTight, EncodingTypeZRLE, EncodingTypeTRLE, EncodingTypeZlib, EncodingTypeHextile, EncodingTypeCoRRE, EncodingTypeRRE, EncodingTypeRaw, EncodingTypeExtendedDesktopSize, EncodingTypeDesktopSize, EncodingTypeCursorWithAlpha, EncodingTypeCursor, EncodingTypeXCursor, EncodingTypeQEMUExtendedKeyEvent, EncodingTypeExtendedClipboard, EncodingTypeFence}}); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Client) negotiateSecurityRFB33(rw io.ReadWriter, config ClientConfig) error {
+	var scheme AuthenticationSchemeMessageRFB33
+	if err := scheme.Read(rw, c.bo); err != nil {
+		return fmt.Errorf("read auth scheme: %v", err)
+	}
+	switch scheme.Scheme {
+	case AuthenticationSchemeInvalid:
+		var reason ReasonMessage
+		if err := reason.Read(rw, c.bo); err != nil {
+			return fmt.Errorf("read failure reason: %v", err)
+		}
+		return fmt.Errorf("server refused connection: %s", reason.Reason)
+	case AuthenticationSchemeNone:
+		return nil
+	case AuthenticationSchemeVNC:
+		if err := c.vncAuthenticate(rw, config.Password); err != nil {
+			return err
+		}
+		return c.readVNCAuthenticationResult(rw)
+	default:
+		return fmt.Errorf("unsupported auth scheme %d", scheme.Scheme)
+	}
+}
+
+func (c *Client) negotiateSecurity(rw io.ReadWriter, config ClientConfig) error {
+	var securityTypes SecurityTypesMessageRFB37
+	if err := securityTypes.Read(rw, c.bo); err != nil {
+		return fmt.Errorf("read security types: %v", err)
+	}
+	offered := map[SecurityType]bool{}
+	for _, t := range securityTypes.Types {
+		offered[t] = true
+	}
+
+	var chosen SecurityType
+	switch {
+	case offered[SecurityTypeARD] && config.Username != "" && c.Version.AtLeast(3, 8):
+		chosen = SecurityTypeARD
+	case offered[SecurityTypeVNC] && config.Password != "":
+		chosen = SecurityTypeVNC
+	case offered[SecurityTypeNone]:
+		chosen = SecurityTypeNone
+	case offered[SecurityTypeVNC]:
+		chosen = SecurityTypeVNC
+	default:
+		return fmt.Errorf("no supported security type among %v", securityTypes.Types)
+	}
+	if err := (&SecurityTypeSelectionMessageRFB37{Type: chosen}).Write(rw); err != nil {
+		return fmt.Errorf("write security type: %v", err)
+	}
+
+	switch chosen {
+	case SecurityTypeVNC:
+		if err := c.vncAuthenticate(rw, config.Password); err != nil {
+			return err
+		}
+	case SecurityTypeARD:
+		var challenge ARDChallengeMessage
+		if err := challenge.Read(rw, c.bo); err != nil {
+			return fmt.Errorf("read ARD challenge: %v", err)
+		}
+		response, err := ARDRespond(&challenge, config.Username, config.Password)
+		if err != nil {
+			return fmt.Errorf("respond to ARD challenge: %v", err)
+		}
+		if err := response.Write(rw); err != nil {
+			return fmt.Errorf("write ARD response: %v", err)
+		}
+	}
+
+	if c.Version.AtLeast(3, 8) {
+		var result SecurityResultMessageRFB38
+		if err := result.Read(rw, c.bo); err != nil {
+			return fmt.Errorf("read security result: %v", err)
+		}
+		if result.Result != VNCAuthenticationResultOK {
+			return &AuthenticationFailedError{result.Reason}
+		}
+	} else if chosen == SecurityTypeVNC {
+		return c.readVNCAuthenticationResult(rw)
+	}
+	return nil
+}
+
+func (c *Client) vncAuthenticate(rw io.ReadWriter, password string) error {
+	var challenge VNCAuthenticationChallengeMessage
+	if err := challenge.Read(rw); err != nil {
+		return fmt.Errorf("read VNC auth challenge: %v", err)
+	}
+	response := VNCAuthenticationResponse(challenge, password)
+	if err := response.Write(rw); err != nil {
+		return fmt.Errorf("write VNC auth response: %v", err)
+	}
+	return nil
+}
+
+func (c *Client) readVNCAuthenticationResult(rw io.ReadWriter) error {
+	var result VNCAuthenticationResultMessage
+	if err := result.Read(rw, c.bo); err != nil {
+		return fmt.Errorf("read VNC auth result: %v", err)
+	}
+	if result.Result != VNCAuthenticationResultOK {
+		return &AuthenticationFailedError{"Incorrect password."}
+	}
+	return nil
+}
+
+// VNCAuthenticationResponse encrypts the challenge with DES, keyed by the first 8 bytes of the password with each
+// byte's bits reversed, as VNC authentication requires.
+func VNCAuthenticationResponse(challenge VNCAuthenticationChallengeMessage, password string) VNCAuthenticationResponseMessage {
+	var key [8]byte
+	copy(key[:], password)
+	for i, b := range key {
+		var reversed byte
+		for bit := 0; bit < 8; bit++ {
+			reversed |= ((b >> bit) & 1) << (7 - bit)
+		}
+		key[i] = reversed
+	}
+	cipher, err := des.NewCipher(key[:])
+	if err != nil {
+		panic(err) // Only fails if the key isn't 8 bytes.
+	}
+	var response VNCAuthenticationResponseMessage
+	cipher.Encrypt(response[0:8], challenge[0:8])
+	cipher.Encrypt(response[8:16], challenge[8:16])
+	return response
+}
+
+// SendMessage sends any client message, including extension messages.
+func (c *Client) SendMessage(m ClientMessage) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	if err := m.Write(c.w, c.bo); err != nil {
+		return fmt.Errorf("write %T: %v", m, err)
+	}
+	if err := c.w.Flush(); err != nil {
+		return fmt.Errorf("flush %T: %v", m, err)
+	}
+	return nil
+}
+
+// RequestUpdate asks for the given region of the framebuffer. If incremental is true, the server may wait until
+// something changes and only send what did.
+func (c *Client) RequestUpdate(incremental bool, rect image.Rectangle) error {
+	if err := c.sendClipboardCaps(); err != nil {
+		return err
+	}
+	return c.SendMessage(&FramebufferUpdateRequestMessage{
+		Incremental: incremental,
+		X:           uint16(rect.Min.X),
+		Y:           uint16(rect.Min.Y),
+		Width:       uint16(rect.Dx()),
+		Height:      uint16(rect.Dy()),
+	})
+}
+
+func (c *Client) KeyEvent(keySym uint32, pressed bool) error {
+	return c.SendMessage(&KeyEventMessage{Pressed: pressed, KeySym: keySym})
+}
+
+// ExtendedKeyEvent sends a key's XT scan code along with its keysym. Only use it if ExtendedKeyEvents is true.
+func (c *Client) ExtendedKeyEvent(keySym, keyCode uint32, pressed bool) error {
+	return c.SendMessage(&QEMUExtendedKeyEventMessage{Pressed: pressed, KeySym: keySym, KeyCode: keyCode})
+}
+
+func (c *Client) PointerEvent(buttonMask uint8, x, y int) error {
+	return c.SendMessage(&PointerEventMessage{ButtonMask: buttonMask, X: uint16(x), Y: uint16(y)})
+}
+
+// SetDesktopSize asks the server to resize the framebuffer. If the server supports it, a later update includes an
+// ExtendedDesktopSize rectangle with the result.
+func (c *Client) SetDesktopSize(width, height int) error {
+	return c.SendMessage(&SetDesktopSizeMessage{
+		Width: uint16(width), Height: uint16(height),
+		Screens: []Screen{{Width: uint16(width), Height: uint16(height)}},
+	})
+}
+
+// CutText sets the server's clipboard. Once the server has said it supports the Extended Clipboard extension, the text
+// is sent as UTF-8; before then, it must be Latin-1.
+func (c *Client) CutText(text string) error {
+	if err := c.sendClipboardCaps(); err != nil {
+		return err
+	}
+	if caps, ok := c.serverClipboard.Load().(*ExtendedClipboard); ok && caps.accepts(text) {
+		return c.SendMessage(&ClientCutTextMessage{Extended: &ExtendedClipboard{Flags: ClipboardActionProvide | ClipboardFormatText, Text: text}})
+	}
+	return c.SendMessage(&ClientCutTextMessage{Text: text})
+}
+
+// sendClipboardCaps replies to the server's Extended Clipboard capabilities with the client's, so the server sends
+// clipboard text as UTF-8. Only provides are accepted; other actions are left to whoever calls ReadMessage.
+func (c *Client) sendClipboardCaps() error {
+	if c.sentClipboardCaps || c.serverClipboard.Load() == nil {
+		return nil
+	}
+	c.sentClipboardCaps = true
+	return c.SendMessage(&ClientCutTextMessage{Extended: &ExtendedClipboard{
+		Flags:    ClipboardActionCaps | ClipboardActionProvide | ClipboardFormatText,
+		MaxSizes: []uint32{maxStringLength},
+	}})
+}
+
+// ReadMessage reads the next server message. FramebufferUpdate messages are drawn into Framebuffer before returning,
+// and fence requests are answered.
+func (c *Client) ReadMessage() (ServerMessage, error) {
+	messageType, err := c.r.Peek(1)
+	if err != nil {
+		return nil, fmt.Errorf("read message type: %v", err)
+	}
+	switch messageType[0] {
+	case 0:
+		update := FramebufferUpdateMessage{Decoders: &c.decoders}
+		if err := update.Read(c.r, c.bo, c.PixelFormat); err != nil {
+			return nil, fmt.Errorf("read FramebufferUpdate: %v", err)
+		}
+		for _, rect := range update.Rectangles {
+			bounds := image.Rect(int(rect.X), int(rect.Y), int(rect.X)+int(rect.Width), int(rect.Y)+int(rect.Height))
+			if copyRect, ok := rect.Encoding.(*CopyRectEncoder); ok {
+				draw.Draw(c.Framebuffer, bounds, c.Framebuffer, copyRect.Src, draw.Src)
+				continue
+			}
+			if cursor, ok := rect.Encoding.(*CursorEncoder); ok {
+				c.Cursor = cursor.Cursor
+				continue
+			}
+			if rect.EncodingType == EncodingTypeQEMUExtendedKeyEvent {
+				c.ExtendedKeyEvents = true
+				continue
+			}
+			if rect.EncodingType == EncodingTypeDesktopSize || rect.EncodingType == EncodingTypeExtendedDesktopSize {
+				framebuffer := image.NewRGBA(image.Rect(0, 0, int(rect.Width), int(rect.Height)))
+				draw.Draw(framebuffer, framebuffer.Bounds(), c.Framebuffer, image.ZP, draw.Src)
+				c.Framebuffer = framebuffer
+				continue
+			}
+			src := &PixelFormatImage{Pix: rect.PixelData, Rect: bounds, PixelFormat: c.PixelFormat, ColourMap: c.ColourMap}
+			draw.Draw(c.Framebuffer, bounds, src, bounds.Min, draw.Src)
+		}
+		return &update, nil
+	case 1:
+		var colourMap SetColourMapEntriesMessage
+		if err := colourMap.Read(c.r, c.bo); err != nil {
+			return nil, fmt.Errorf("read SetColourMapEntries: %v", err)
+		}
+		c.ColourMap = colourMap.Apply(c.ColourMap)
+		return &colourMap, nil
+	case 2:
+		var bell BellMessage
+		if err := bell.Read(c.r, c.bo); err != nil {
+			return nil, fmt.Errorf("read Bell: %v", err)
+		}
+		return &bell, nil
+	case 3:
+		var cutText ServerCutTextMessage
+		if err := cutText.Read(c.r, c.bo); err != nil {
+			return nil, fmt.Errorf("read ServerCutText: %v", err)
+		}
+		if cutText.Extended != nil && cutText.Extended.action() == ClipboardActionCaps {
+			c.serverClipboard.Store(cutText.Extended)
+		}
+		return &cutText, nil
+	case 248:
+		var fence FenceMessage
+		if err := fence.Read(c.r, c.bo); err != nil {
+			return nil, fmt.Errorf("read Fence: %v", err)
+		}
+		if fence.Flags&FenceRequest != 0 {
+			if err := c.SendMessage(fence.Reply()); err != nil {
+				return nil, err
+			}
+		}
+		return &fence, nil
+	default:
+		return nil, fmt.Errorf("received unrecognized message type %d", messageType[0])
+	}
+}
+
+// Update requests the whole framebuffer and reads messages until the update arrives.
+func (c *Client) Update(incremental bool) (*FramebufferUpdateMessage, error) {
+	if err := c.RequestUpdate(incremental, c.Framebuffer.Bounds()); err != nil {
+		return nil, err
+	}
+	for {
+		m, err := c.ReadMessage()
+		if err != nil {
+			return nil, err
+		}
+		if update, ok := m.(*FramebufferUpdateMessage); ok {
+			return update, nil
+		}
+	}
+}
+
+// Flushes writes before each read during the handshake, so the client never waits for a reply to a message it hasn't
+// sent.
+type clientHandshake struct {
+	c *Client
+}
+
+func (h *clientHandshake) Read(p []byte) (int, error) {
+	if err := h.c.w.Flush(); err != nil {
+		return 0, err
+	}
+	return h.c.r.Read(p)
+}
+
+func (h *clientHandshake) Write(p []byte) (int, error) {
+	return h.c.w.Write(p)
+}
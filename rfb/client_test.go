@@ -0,0 +1,422 @@
+package rfb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type fillHandler struct {
+	color color.Color
+	keys  chan uint32
+}
+
+func (h *fillHandler) Resize(width, height int) {}
+
+func (h *fillHandler) Render(img draw.Image, rect image.Rectangle) {
+	draw.Draw(img, rect, image.NewUniform(h.color), image.ZP, draw.Src)
+}
+
+func (h *fillHandler) KeyEvent(m *KeyEventMessage) {
+	h.keys <- m.KeySym
+}
+
+func (h *fillHandler) PointerEvent(m *PointerEventMessage) {}
+func (h *fillHandler) CutText(text string)                 {}
+
+func TestClient(t *testing.T) {
+	for _, password := range []string{"", "hunter2"} {
+		handler := &fillHandler{color: color.RGBA{0xff, 0, 0, 0xff}, keys: make(chan uint32, 1)}
+		security := &SecurityRegistry{}
+		if password == "" {
+			security.Register(&NoneSecurityHandler{})
+		} else {
+			security.Register(&VNCSecurityHandler{Verify: func(challenge VNCAuthenticationChallengeMessage, response VNCAuthenticationResponseMessage) bool {
+				return VNCAuthenticationResponse(challenge, password) == response
+			}})
+		}
+		server := &Server{
+			Name: "test", Width: 4, Height: 3, Security: security,
+			NewHandler: func(conn io.ReadWriter) (Handler, error) { return handler, nil },
+		}
+		serverConn, clientConn := net.Pipe()
+		go server.ServeConn(serverConn)
+
+		client, err := NewClient(clientConn, ClientConfig{Password: password})
+		if err != nil {
+			t.Fatalf("password %q: %v", password, err)
+		}
+		if client.Name != "test" || client.Framebuffer.Bounds() != image.Rect(0, 0, 4, 3) {
+			t.Errorf("got name %q and bounds %v", client.Name, client.Framebuffer.Bounds())
+		}
+		if _, err := client.Update(false); err != nil {
+			t.Fatal(err)
+		}
+		if got := client.Framebuffer.RGBAAt(3, 2); got != (color.RGBA{0xff, 0, 0, 0xff}) {
+			t.Errorf("got pixel %v, want red", got)
+		}
+		if err := client.KeyEvent('x', true); err != nil {
+			t.Fatal(err)
+		}
+		if got := <-handler.keys; got != 'x' {
+			t.Errorf("handler got key %d, want x", got)
+		}
+		clientConn.Close()
+	}
+}
+
+func TestClientWrongPassword(t *testing.T) {
+	security := &SecurityRegistry{}
+	security.Register(&VNCSecurityHandler{Verify: func(challenge VNCAuthenticationChallengeMessage, response VNCAuthenticationResponseMessage) bool {
+		return VNCAuthenticationResponse(challenge, "right") == response
+	}})
+	server := &Server{Width: 1, Height: 1, Security: security}
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	go server.ServeConn(serverConn)
+
+	_, err := NewClient(clientConn, ClientConfig{Password: "wrong"})
+	if _, ok := err.(*AuthenticationFailedError); !ok {
+		t.Fatalf("got error %v, want AuthenticationFailedError", err)
+	}
+}
+
+func TestClientRefused(t *testing.T) {
+	server := &Server{Width: 1, Height: 1, Admit: func(conn io.ReadWriter) error { return errors.New("The game is full.") }}
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	go server.ServeConn(serverConn)
+
+	_, err := NewClient(clientConn, ClientConfig{})
+	if err == nil || !strings.Contains(err.Error(), "The game is full.") {
+		t.Fatalf("got error %v, want the server's reason", err)
+	}
+}
+
+func TestServerRefusesUnsupportedVersion(t *testing.T) {
+	server := &Server{Width: 1, Height: 1}
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	go server.ServeConn(serverConn)
+
+	var version ProtocolVersionMessage
+	if err := version.Read(clientConn); err != nil {
+		t.Fatal(err)
+	}
+	go (&ProtocolVersionMessage{Major: 4, Minor: 0}).Write(clientConn)
+	var types SecurityTypesMessageRFB37
+	err := types.Read(clientConn, binary.BigEndian)
+	if err == nil || !strings.Contains(err.Error(), "Only RFB 3.x is supported") {
+		t.Fatalf("got error %v, want the server's reason", err)
+	}
+}
+
+type resizingHandler struct {
+	fillHandler
+	size, resized image.Point
+}
+
+func (h *resizingHandler) DesktopSize() image.Point {
+	return h.size
+}
+
+func (h *resizingHandler) Resize(width, height int) {
+	h.resized = image.Pt(width, height)
+}
+
+func TestClientDesktopSize(t *testing.T) {
+	handler := &resizingHandler{fillHandler: fillHandler{color: color.RGBA{0, 0, 0xff, 0xff}}, size: image.Pt(4, 3)}
+	server := &Server{
+		Width: 4, Height: 3,
+		NewHandler: func(conn io.ReadWriter) (Handler, error) { return handler, nil },
+	}
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	go server.ServeConn(serverConn)
+
+	client, err := NewClient(clientConn, ClientConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Update(false); err != nil {
+		t.Fatal(err)
+	}
+
+	handler.size = image.Pt(6, 5)
+	update, err := client.Update(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(update.Rectangles) != 1 || update.Rectangles[0].EncodingType != EncodingTypeExtendedDesktopSize {
+		t.Fatalf("got %d rectangles, want just an ExtendedDesktopSize one", len(update.Rectangles))
+	}
+	if reason := update.Rectangles[0].X; reason != DesktopSizeReasonServer {
+		t.Errorf("got reason %d, want the server", reason)
+	}
+	if got := client.Framebuffer.Bounds(); got != image.Rect(0, 0, 6, 5) {
+		t.Errorf("client's framebuffer is %v after resizing", got)
+	}
+	if handler.resized != handler.size {
+		t.Errorf("handler was resized to %v, want %v", handler.resized, handler.size)
+	}
+	if _, err := client.Update(false); err != nil {
+		t.Fatal(err)
+	}
+	if got := client.Framebuffer.RGBAAt(5, 4); got != (color.RGBA{0, 0, 0xff, 0xff}) {
+		t.Errorf("got pixel %v in the new area, want blue", got)
+	}
+}
+
+type clientResizingHandler struct {
+	resizingHandler
+	max image.Point
+}
+
+func (h *clientResizingHandler) SetDesktopSize(width, height int) bool {
+	if width > h.max.X || height > h.max.Y {
+		return false
+	}
+	h.size = image.Pt(width, height)
+	return true
+}
+
+func TestClientSetDesktopSize(t *testing.T) {
+	handler := &clientResizingHandler{resizingHandler{size: image.Pt(4, 3)}, image.Pt(10, 10)}
+	handler.color = color.White
+	server := &Server{
+		Width: 4, Height: 3,
+		NewHandler: func(conn io.ReadWriter) (Handler, error) { return handler, nil },
+	}
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	go server.ServeConn(serverConn)
+
+	client, err := NewClient(clientConn, ClientConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Update(false); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		size   image.Point
+		status uint16
+		want   image.Point
+	}{
+		{image.Pt(8, 6), DesktopSizeStatusOK, image.Pt(8, 6)},
+		{image.Pt(20, 6), DesktopSizeStatusProhibited, image.Pt(8, 6)},
+		{image.Pt(0, 6), DesktopSizeStatusInvalidLayout, image.Pt(8, 6)},
+	} {
+		if err := client.SetDesktopSize(test.size.X, test.size.Y); err != nil {
+			t.Fatal(err)
+		}
+		update, err := client.Update(true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rect := update.Rectangles[0]
+		if rect.EncodingType != EncodingTypeExtendedDesktopSize || rect.X != DesktopSizeReasonClient || rect.Y != test.status {
+			t.Errorf("%v: got %s rectangle with reason %d and status %d, want status %d", test.size, EncodingName(rect.EncodingType), rect.X, rect.Y, test.status)
+		}
+		if got := client.Framebuffer.Bounds().Size(); got != test.want {
+			t.Errorf("%v: framebuffer is %v, want %v", test.size, got, test.want)
+		}
+	}
+}
+
+type keyCodeHandler struct {
+	fillHandler
+	events chan KeyEventMessage
+}
+
+func (h *keyCodeHandler) KeyEvent(m *KeyEventMessage) {
+	h.events <- *m
+}
+
+func TestClientExtendedKeyEvent(t *testing.T) {
+	handler := &keyCodeHandler{fillHandler{color: color.White}, make(chan KeyEventMessage, 1)}
+	server := &Server{
+		Width: 4, Height: 3,
+		NewHandler: func(conn io.ReadWriter) (Handler, error) { return handler, nil },
+	}
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	go server.ServeConn(serverConn)
+
+	client, err := NewClient(clientConn, ClientConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.ExtendedKeyEvents {
+		t.Error("extended key events were acknowledged before the first update")
+	}
+	if _, err := client.Update(false); err != nil {
+		t.Fatal(err)
+	}
+	if !client.ExtendedKeyEvents {
+		t.Fatal("server didn't acknowledge extended key events")
+	}
+
+	if err := client.ExtendedKeyEvent(0x6cb, 0x13, true); err != nil {
+		t.Fatal(err)
+	}
+	want := KeyEventMessage{Pressed: true, KeySym: 0x6cb, KeyCode: 0x13}
+	if got := <-handler.events; got != want {
+		t.Errorf("handler got %+v, want %+v", got, want)
+	}
+}
+
+type clipboardHandler struct {
+	fillHandler
+	text string
+	cuts chan string
+}
+
+func (h *clipboardHandler) CutText(text string) {
+	h.cuts <- text
+}
+
+func (h *clipboardHandler) PendingMessages() []ServerMessage {
+	if h.text == "" {
+		return nil
+	}
+	m := &ServerCutTextMessage{Text: h.text}
+	h.text = ""
+	return []ServerMessage{m}
+}
+
+func TestClientServerCutText(t *testing.T) {
+	handler := &clipboardHandler{fillHandler{color: color.White}, "You are P3 in the café", nil}
+	server := &Server{
+		Width: 4, Height: 3,
+		NewHandler: func(conn io.ReadWriter) (Handler, error) { return handler, nil },
+	}
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	go server.ServeConn(serverConn)
+
+	client, err := NewClient(clientConn, ClientConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.RequestUpdate(false, client.Framebuffer.Bounds()); err != nil {
+		t.Fatal(err)
+	}
+	m, err := client.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cutText, ok := m.(*ServerCutTextMessage); !ok || cutText.Extended == nil || cutText.Extended.Flags&ClipboardActionCaps == 0 {
+		t.Fatalf("got %#v, want Extended Clipboard caps", m)
+	}
+	// The client hasn't replied with its own caps, so the server can't use UTF-8 yet.
+	if m, err = client.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+	// ServerCutText is Latin-1 on the wire, so this checks that it's converted both ways.
+	if cutText, ok := m.(*ServerCutTextMessage); !ok || cutText.Text != "You are P3 in the café" {
+		t.Errorf("got %#v, want ServerCutText", m)
+	}
+	if m, err := client.ReadMessage(); err != nil {
+		t.Fatal(err)
+	} else if _, ok := m.(*FramebufferUpdateMessage); !ok {
+		t.Errorf("got %T after the clipboard text, want the update", m)
+	}
+}
+
+func TestClientExtendedClipboard(t *testing.T) {
+	handler := &clipboardHandler{fillHandler{color: color.White}, "", make(chan string, 1)}
+	server := &Server{
+		Width: 4, Height: 3,
+		NewHandler: func(conn io.ReadWriter) (Handler, error) { return handler, nil },
+	}
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	go server.ServeConn(serverConn)
+
+	client, err := NewClient(clientConn, ClientConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Update(false); err != nil { // Reads the server's caps.
+		t.Fatal(err)
+	}
+
+	const clientText = "Ничья!\nΤι κάνεις;"
+	if err := client.CutText(clientText); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-handler.cuts; got != clientText {
+		t.Errorf("server got %q, want %q", got, clientText)
+	}
+
+	handler.text = "You are P3 ♥"
+	if err := client.RequestUpdate(true, client.Framebuffer.Bounds()); err != nil {
+		t.Fatal(err)
+	}
+	m, err := client.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cutText, ok := m.(*ServerCutTextMessage)
+	if !ok || cutText.Extended == nil || cutText.Text != "You are P3 ♥" {
+		t.Errorf("got %#v, want an Extended Clipboard provide", m)
+	}
+}
+
+func TestExtendedClipboardRoundTrip(t *testing.T) {
+	for _, e := range []ExtendedClipboard{
+		{Flags: ClipboardActionCaps | ClipboardFormatText | ClipboardFormatHTML, MaxSizes: []uint32{1000, 2000}},
+		{Flags: ClipboardActionProvide | ClipboardFormatText, Text: "one\ntwo\r\nthree"},
+		{Flags: ClipboardActionNotify},
+	} {
+		var buf bytes.Buffer
+		if err := (&ClientCutTextMessage{Extended: &e}).Write(&buf, binary.BigEndian); err != nil {
+			t.Fatal(err)
+		}
+		var m ClientCutTextMessage
+		if err := m.Read(&buf, binary.BigEndian); err != nil {
+			t.Fatalf("%x: %v", e.Flags, err)
+		}
+		want := e
+		want.Text = strings.Replace(e.Text, "\r\n", "\n", -1)
+		if !reflect.DeepEqual(m.Extended, &want) || buf.Len() != 0 {
+			t.Errorf("got %+v with %d bytes left, want %+v", m.Extended, buf.Len(), want)
+		}
+	}
+}
+
+func TestClientColourMap(t *testing.T) {
+	handler := &fillHandler{color: color.RGBA{0xff, 0x80, 0, 0xff}}
+	server := &Server{
+		Width: 4, Height: 3,
+		NewHandler: func(conn io.ReadWriter) (Handler, error) { return handler, nil },
+	}
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	go server.ServeConn(serverConn)
+
+	palette := PixelFormat{BitsPerPixel: 8, BitDepth: 8}
+	client, err := NewClient(clientConn, ClientConfig{PixelFormat: &palette})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Update(false); err != nil {
+		t.Fatal(err)
+	}
+	if len(client.ColourMap) != 256 {
+		t.Fatalf("got %d colours, want 256", len(client.ColourMap))
+	}
+	// The colour map has 3 bits of red and green, so orange is approximated.
+	if got, want := client.Framebuffer.RGBAAt(3, 2), (color.RGBA{0xff, 0x92, 0, 0xff}); got != want {
+		t.Errorf("got pixel %v, want %v", got, want)
+	}
+}
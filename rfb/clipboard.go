@@ -0,0 +1,244 @@
+package rfb
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+func init() {
+	RegisterPseudoEncoding(EncodingTypeExtendedClipboard, "ExtendedClipboard")
+}
+
+// Clipboard formats, in the low 16 bits of ExtendedClipboard.Flags. Only text is supported; the rest are dropped.
+const (
+	ClipboardFormatText  = uint32(1 << 0)
+	ClipboardFormatRTF   = uint32(1 << 1)
+	ClipboardFormatHTML  = uint32(1 << 2)
+	ClipboardFormatDIB   = uint32(1 << 3)
+	ClipboardFormatFiles = uint32(1 << 4)
+)
+
+// Clipboard actions, in the high 8 bits of ExtendedClipboard.Flags. Each message has one, but caps also list the
+// actions the sender supports.
+const (
+	ClipboardActionCaps    = uint32(1 << 24) // The formats and actions the sender understands.
+	ClipboardActionRequest = uint32(1 << 25) // Asks for the listed formats with a provide.
+	ClipboardActionPeek    = uint32(1 << 26) // Asks which formats are available with a notify.
+	ClipboardActionNotify  = uint32(1 << 27) // Lists the formats the sender has, which the receiver may request.
+	ClipboardActionProvide = uint32(1 << 28) // Carries the clipboard's contents in the listed formats.
+)
+
+// ExtendedClipboard is the payload of a cut text message sent with the Extended Clipboard extension, which replaces
+// Latin-1 text with UTF-8 and lets each side ask for the other's clipboard. It's sent in place of the text, with the
+// length negated. Either side may use it once the other has sent its capabilities, which servers do when clients
+// support the ExtendedClipboard pseudo-encoding and clients do in reply.
+type ExtendedClipboard struct {
+	Flags uint32 // One action and the formats it's about.
+
+	// For caps, the size of each listed format, in order, that the sender accepts without being asked.
+	MaxSizes []uint32
+
+	// For provide, the text, if Flags includes ClipboardFormatText. Line endings are converted to and from CRLF.
+	Text string
+}
+
+// action returns the message's action. Caps messages list the actions the sender supports too, so caps come first.
+func (e *ExtendedClipboard) action() uint32 {
+	for _, action := range []uint32{ClipboardActionCaps, ClipboardActionRequest, ClipboardActionPeek, ClipboardActionNotify, ClipboardActionProvide} {
+		if e.Flags&action != 0 {
+			return action
+		}
+	}
+	return 0
+}
+
+// accepts reports whether the sender of these capabilities takes provides of text this long without asking.
+func (e *ExtendedClipboard) accepts(text string) bool {
+	if e.Flags&ClipboardActionProvide == 0 || e.Flags&ClipboardFormatText == 0 || len(e.MaxSizes) == 0 {
+		return false
+	}
+	return uint64(len(text)) < uint64(e.MaxSizes[0]) // Leave room for the terminator.
+}
+
+// Reads the payload of an extended cut text message of the given length.
+func readExtendedClipboard(r io.Reader, bo binary.ByteOrder, length uint32) (*ExtendedClipboard, error) {
+	if length < 4 {
+		return nil, fmt.Errorf("extended clipboard message too short: %d bytes", length)
+	}
+	limits := readLimits(r)
+	if length > limits.CutText {
+		return nil, &TooLongError{What: "extended clipboard data", Length: uint64(length), Limit: uint64(limits.CutText)}
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	e := &ExtendedClipboard{Flags: bo.Uint32(data)}
+	data = data[4:]
+
+	switch e.action() {
+	case ClipboardActionCaps:
+		for format := uint(0); format < 16; format++ {
+			if e.Flags&(1<<format) == 0 {
+				continue
+			}
+			if len(data) < 4 {
+				return nil, fmt.Errorf("extended clipboard caps missing sizes")
+			}
+			e.MaxSizes = append(e.MaxSizes, bo.Uint32(data))
+			data = data[4:]
+		}
+	case ClipboardActionProvide:
+		if e.Flags&ClipboardFormatText == 0 {
+			return e, nil // Other formats aren't supported.
+		}
+		zr, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("extended clipboard data: %v", err)
+		}
+		var size [4]byte
+		if _, err := io.ReadFull(zr, size[:]); err != nil {
+			return nil, fmt.Errorf("extended clipboard text size: %v", err)
+		}
+		textLength := bo.Uint32(size[:])
+		if textLength > limits.CutText {
+			return nil, &TooLongError{What: "extended clipboard text", Length: uint64(textLength), Limit: uint64(limits.CutText)}
+		}
+		// Stop decompressing once the text would be truncated anyway, since the rest is still in data.
+		if textLength > maxStringLength {
+			textLength = maxStringLength
+		}
+		text := make([]byte, textLength)
+		if _, err := io.ReadFull(zr, text); err != nil {
+			return nil, fmt.Errorf("extended clipboard text: %v", err)
+		}
+		e.Text = strings.Replace(strings.TrimRight(string(text), "\x00"), "\r\n", "\n", -1)
+	}
+	return e, nil
+}
+
+// Writes an extended cut text message's length and payload.
+func (e *ExtendedClipboard) write(w io.Writer, bo binary.ByteOrder, messageType uint8) error {
+	buf := make([]byte, 12+4*len(e.MaxSizes))
+	buf[0] = messageType
+	bo.PutUint32(buf[8:], e.Flags)
+	for i, size := range e.MaxSizes {
+		bo.PutUint32(buf[12+4*i:], size)
+	}
+	if e.action() == ClipboardActionProvide && e.Flags&ClipboardFormatText != 0 {
+		text := strings.Replace(strings.Replace(e.Text, "\r\n", "\n", -1), "\n", "\r\n", -1) + "\x00"
+		var compressed bytes.Buffer
+		zw := zlib.NewWriter(&compressed)
+		var size [4]byte
+		bo.PutUint32(size[:], uint32(len(text)))
+		zw.Write(size[:])
+		zw.Write([]byte(text))
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		buf = append(buf, compressed.Bytes()...)
+	}
+	if int64(len(buf)-8) > math.MaxInt32 {
+		return fmt.Errorf("extended clipboard message too long: %d bytes", len(buf)-8)
+	}
+	bo.PutUint32(buf[4:], uint32(-int32(len(buf)-8)))
+	_, err := w.Write(buf)
+	return err
+}
+
+// Reads a cut text message's payload after its header, whose length is negative for extended messages.
+func readCutText(r io.Reader, bo binary.ByteOrder, length uint32) (string, *ExtendedClipboard, error) {
+	if int32(length) < 0 {
+		e, err := readExtendedClipboard(r, bo, uint32(-int64(int32(length))))
+		if err != nil {
+			return "", nil, err
+		}
+		return e.Text, e, nil
+	}
+	if limit := readLimits(r).CutText; length > limit {
+		return "", nil, &TooLongError{What: "cut text", Length: uint64(length), Limit: uint64(limit)}
+	}
+	text, err := readTruncated(r, length, maxStringLength)
+	if err != nil {
+		return "", nil, err
+	}
+	return string(text), nil, nil
+}
+
+// clipboard tracks one client's Extended Clipboard state.
+type clipboard struct {
+	announced bool               // Whether the server's capabilities have been sent.
+	caps      *ExtendedClipboard // The client's capabilities, once it has sent them.
+	text      string             // The server's clipboard, for clients that ask for it.
+	outgoing  []ServerMessage    // Replies to send before the next update.
+}
+
+// pending returns messages to send before the next update.
+func (cb *clipboard) pending(c *Conn) []ServerMessage {
+	messages := cb.outgoing
+	cb.outgoing = nil
+	if !cb.announced && c.supportsEncoding(EncodingTypeExtendedClipboard) {
+		cb.announced = true
+		caps := &ServerCutTextMessage{Extended: &ExtendedClipboard{
+			Flags:    ClipboardActionCaps | ClipboardActionRequest | ClipboardActionPeek | ClipboardActionNotify | ClipboardActionProvide | ClipboardFormatText,
+			MaxSizes: []uint32{maxStringLength},
+		}}
+		messages = append([]ServerMessage{caps}, messages...)
+	}
+	return messages
+}
+
+// send converts a handler's message to the Extended Clipboard format if it's cut text and the client can take it.
+func (cb *clipboard) send(m ServerMessage) ServerMessage {
+	cutText, ok := m.(*ServerCutTextMessage)
+	if !ok || cutText.Extended != nil || cb.caps == nil {
+		return m
+	}
+	cb.text = cutText.Text
+	if cb.caps.accepts(cb.text) {
+		return &ServerCutTextMessage{Extended: &ExtendedClipboard{Flags: ClipboardActionProvide | ClipboardFormatText, Text: cb.text}}
+	}
+	if cb.caps.Flags&ClipboardActionNotify != 0 {
+		return &ServerCutTextMessage{Extended: &ExtendedClipboard{Flags: ClipboardActionNotify | ClipboardFormatText}}
+	}
+	return m
+}
+
+// receive handles a ClientCutText message, passing any text the client provides to h.
+func (cb *clipboard) receive(m *ClientCutTextMessage, h Handler) {
+	e := m.Extended
+	if e == nil {
+		h.CutText(m.Text)
+		return
+	}
+	reply := func(flags uint32) {
+		cb.outgoing = append(cb.outgoing, &ServerCutTextMessage{Extended: &ExtendedClipboard{Flags: flags, Text: cb.text}})
+	}
+	switch e.action() {
+	case ClipboardActionCaps:
+		cb.caps = e
+	case ClipboardActionProvide:
+		if e.Flags&ClipboardFormatText != 0 {
+			h.CutText(e.Text)
+		}
+	case ClipboardActionNotify:
+		if e.Flags&ClipboardFormatText != 0 {
+			reply(ClipboardActionRequest | ClipboardFormatText)
+		}
+	case ClipboardActionRequest:
+		if e.Flags&ClipboardFormatText != 0 {
+			reply(ClipboardActionProvide | ClipboardFormatText)
+		}
+	case ClipboardActionPeek:
+		if cb.text != "" {
+			reply(ClipboardActionNotify | ClipboardFormatText)
+		} else {
+			reply(ClipboardActionNotify)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package rfb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image/color"
+	"io"
+)
+
+// SetColourMapEntriesMessage tells a client whose pixel format isn't true color which colors its pixel values stand
+// for, starting with FirstColour.
+type SetColourMapEntriesMessage struct {
+	FirstColour uint16
+	Colours     []color.RGBA64 // Alpha is ignored.
+}
+
+func (m *SetColourMapEntriesMessage) Read(r io.Reader, bo binary.ByteOrder) error {
+	buf := readBuffer(r, 6)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return err
+	}
+	if buf[0] != 1 {
+		return fmt.Errorf("expected message type 1, but found %d", buf[0])
+	}
+	m.FirstColour = bo.Uint16(buf[2:])
+	colours := make([]byte, 6*int(bo.Uint16(buf[4:])))
+	if _, err := io.ReadFull(r, colours); err != nil {
+		return err
+	}
+	m.Colours = make([]color.RGBA64, len(colours)/6)
+	for i := range m.Colours {
+		b := colours[6*i:]
+		m.Colours[i] = color.RGBA64{bo.Uint16(b[0:]), bo.Uint16(b[2:]), bo.Uint16(b[4:]), 0xffff}
+	}
+	return nil
+}
+
+func (m *SetColourMapEntriesMessage) Write(w io.Writer, bo binary.ByteOrder) error {
+	if len(m.Colours) > 0xffff {
+		return fmt.Errorf("too many colours: %d > %d", len(m.Colours), 0xffff)
+	}
+	buf := make([]byte, 6+6*len(m.Colours))
+	buf[0] = 1
+	bo.PutUint16(buf[2:], m.FirstColour)
+	bo.PutUint16(buf[4:], uint16(len(m.Colours)))
+	for i, c := range m.Colours {
+		b := buf[6+6*i:]
+		bo.PutUint16(b[0:], c.R)
+		bo.PutUint16(b[2:], c.G)
+		bo.PutUint16(b[4:], c.B)
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+// Apply returns colourMap with the message's entries set, growing it if needed. Colors it didn't have are black.
+func (m *SetColourMapEntriesMessage) Apply(colourMap color.Palette) color.Palette {
+	for i, colour := range m.Colours {
+		j := int(m.FirstColour) + i
+		for len(colourMap) <= j {
+			colourMap = append(colourMap, color.Black)
+		}
+		colourMap[j] = colour
+	}
+	return colourMap
+}
+
+// colourMapFormat lays out the server's colour map, which is the same for every client whose pixel format isn't true
+// color: 3 bits of red, 3 of green, and 2 of blue, so pixel values are computed like any true color format's.
+var colourMapFormat = PixelFormat{
+	BitsPerPixel: 8, BitDepth: 8, TrueColor: true,
+	RedMax: 7, GreenMax: 7, BlueMax: 3, RedShift: 0, GreenShift: 3, BlueShift: 6,
+}
+
+// colourMap returns the message that sets a client's colour map to the one described by colourMapFormat.
+func colourMap() *SetColourMapEntriesMessage {
+	m := &SetColourMapEntriesMessage{Colours: make([]color.RGBA64, 256)}
+	for i := range m.Colours {
+		r, g, b, _ := PixelFormatColor{uint32(i), colourMapFormat}.RGBA()
+		m.Colours[i] = color.RGBA64{uint16(r), uint16(g), uint16(b), 0xffff}
+	}
+	return m
+}
+
+// DefaultColourMap returns the message that sets a client's colour map to the one Server uses, which encoders assume
+// when writing in a pixel format that isn't true color. Servers and proxies of their own should send it before the
+// first update in such a format.
+func DefaultColourMap() *SetColourMapEntriesMessage {
+	return colourMap()
+}
@@ -0,0 +1,347 @@
+package rfb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/color"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// The conformance tests play a viewer's side of the protocol byte by byte against a Server, and check that every byte it
+// sends back is where RFC 6143 (or, for 3.3 and 3.7, the earlier specs it describes) puts it. Unlike tests that use
+// Client, they can't be fooled by a mistake both sides share.
+
+// viewerScript is the viewer's side of a connection to a Server.
+type viewerScript struct {
+	t    *testing.T
+	conn net.Conn
+}
+
+// startConformance serves one connection with a 4x3 yellow framebuffer named "test".
+func startConformance(t *testing.T, security *SecurityRegistry) *viewerScript {
+	server := &Server{
+		Name: "test", Width: 4, Height: 3, Security: security,
+		NewHandler: func(conn io.ReadWriter) (Handler, error) {
+			return &fillHandler{color: color.RGBA{0xff, 0xff, 0, 0xff}, keys: make(chan uint32, 1)}, nil
+		},
+	}
+	serverConn, viewerConn := net.Pipe()
+	go func() {
+		server.ServeConn(serverConn)
+		serverConn.Close()
+	}()
+	t.Cleanup(func() { viewerConn.Close() })
+	return &viewerScript{t, viewerConn}
+}
+
+func (v *viewerScript) send(what string, b ...[]byte) {
+	v.t.Helper()
+	v.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	if _, err := v.conn.Write(bytes.Join(b, nil)); err != nil {
+		v.t.Fatalf("send %s: %v", what, err)
+	}
+}
+
+// take reads the next n bytes the server sends.
+func (v *viewerScript) take(what string, n int) []byte {
+	v.t.Helper()
+	v.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(v.conn, buf); err != nil {
+		v.t.Fatalf("read %s: %v", what, err)
+	}
+	return buf
+}
+
+// expect checks that the server sends exactly want next.
+func (v *viewerScript) expect(what string, want ...[]byte) {
+	v.t.Helper()
+	w := bytes.Join(want, nil)
+	if got := v.take(what, len(w)); !bytes.Equal(got, w) {
+		v.t.Fatalf("%s:\n got % x\nwant % x", what, got, w)
+	}
+}
+
+// expectClosed checks that the server hangs up without sending anything more.
+func (v *viewerScript) expectClosed(what string) {
+	v.t.Helper()
+	v.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var buf [1]byte
+	if n, err := v.conn.Read(buf[:]); err != io.EOF {
+		v.t.Fatalf("%s: read %d bytes and %v, want the connection closed", what, n, err)
+	}
+}
+
+// initialise finishes the handshake after the security phase, with the shared flag set.
+func (v *viewerScript) initialise() {
+	v.t.Helper()
+	v.send("ClientInit", []byte{1})
+	v.expect("ServerInit",
+		u16(4), u16(3),
+		[]byte{32, 24, 1, 1, 0, 255, 0, 255, 0, 255, 24, 16, 8, 0, 0, 0},
+		str32("test"))
+}
+
+func u16(v uint16) []byte {
+	var buf [2]byte
+	binary.BigEndian.PutUint16(buf[:], v)
+	return buf[:]
+}
+
+func u32(v uint32) []byte {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], v)
+	return buf[:]
+}
+
+// str32 is a string preceded by its length, as in reasons and the desktop name.
+func str32(s string) []byte {
+	return append(u32(uint32(len(s))), s...)
+}
+
+// echoVNCSecurity accepts responses that repeat the challenge, so scripts can authenticate without DES.
+func echoVNCSecurity() SecurityHandler {
+	return &VNCSecurityHandler{Verify: func(challenge VNCAuthenticationChallengeMessage, response VNCAuthenticationResponseMessage) bool {
+		return challenge == VNCAuthenticationChallengeMessage(response)
+	}}
+}
+
+func registry(handlers ...SecurityHandler) *SecurityRegistry {
+	r := &SecurityRegistry{}
+	for _, h := range handlers {
+		r.Register(h)
+	}
+	return r
+}
+
+func TestConformanceHandshake(t *testing.T) {
+	tests := []struct {
+		name     string
+		security *SecurityRegistry
+		version  string
+		script   func(v *viewerScript)
+	}{
+		{"3.3 None", registry(&NoneSecurityHandler{}), "RFB 003.003\n", func(v *viewerScript) {
+			v.expect("authentication scheme", u32(1))
+			v.initialise()
+		}},
+		{"3.3 VNC", registry(echoVNCSecurity()), "RFB 003.003\n", func(v *viewerScript) {
+			v.expect("authentication scheme", u32(2))
+			challenge := v.take("challenge", 16)
+			v.send("response", challenge)
+			v.expect("VNC authentication result", u32(0))
+			v.initialise()
+		}},
+		{"3.3 VNC with the wrong password", registry(echoVNCSecurity()), "RFB 003.003\n", func(v *viewerScript) {
+			v.expect("authentication scheme", u32(2))
+			v.take("challenge", 16)
+			v.send("response", make([]byte, 16))
+			v.expect("VNC authentication result", u32(1))
+			v.expectClosed("after failure")
+		}},
+		{"3.3 without a 3.3 security type", registry(&TightSecurityHandler{}), "RFB 003.003\n", func(v *viewerScript) {
+			v.expect("refusal", u32(0), str32("No security types supported by RFB 3.3 are available."))
+			v.expectClosed("after refusal")
+		}},
+		{"3.5 is treated as 3.3", registry(&NoneSecurityHandler{}), "RFB 003.005\n", func(v *viewerScript) {
+			v.expect("authentication scheme", u32(1))
+			v.initialise()
+		}},
+		{"3.7 None", registry(&NoneSecurityHandler{}), "RFB 003.007\n", func(v *viewerScript) {
+			v.expect("security types", []byte{1, 1})
+			v.send("security type", []byte{1})
+			// 3.7 has no security result for None.
+			v.initialise()
+		}},
+		{"3.7 VNC", registry(&NoneSecurityHandler{}, echoVNCSecurity()), "RFB 003.007\n", func(v *viewerScript) {
+			v.expect("security types", []byte{2, 1, 2})
+			v.send("security type", []byte{2})
+			challenge := v.take("challenge", 16)
+			v.send("response", challenge)
+			v.expect("security result", u32(0))
+			v.initialise()
+		}},
+		{"3.7 VNC with the wrong password", registry(echoVNCSecurity()), "RFB 003.007\n", func(v *viewerScript) {
+			v.expect("security types", []byte{1, 2})
+			v.send("security type", []byte{2})
+			v.take("challenge", 16)
+			v.send("response", make([]byte, 16))
+			v.expect("security result without a reason", u32(1))
+			v.expectClosed("after failure")
+		}},
+		{"3.8 None", registry(&NoneSecurityHandler{}), "RFB 003.008\n", func(v *viewerScript) {
+			v.expect("security types", []byte{1, 1})
+			v.send("security type", []byte{1})
+			v.expect("security result", u32(0))
+			v.initialise()
+		}},
+		{"3.8 VNC", registry(echoVNCSecurity()), "RFB 003.008\n", func(v *viewerScript) {
+			v.expect("security types", []byte{1, 2})
+			v.send("security type", []byte{2})
+			challenge := v.take("challenge", 16)
+			v.send("response", challenge)
+			v.expect("security result", u32(0))
+			v.initialise()
+		}},
+		{"3.8 VNC with the wrong password", registry(echoVNCSecurity()), "RFB 003.008\n", func(v *viewerScript) {
+			v.expect("security types", []byte{1, 2})
+			v.send("security type", []byte{2})
+			v.take("challenge", 16)
+			v.send("response", make([]byte, 16))
+			v.expect("security result with a reason", u32(1), str32("Incorrect password."))
+			v.expectClosed("after failure")
+		}},
+		{"3.8 Tight with VNC", registry(&TightSecurityHandler{Auth: []SecurityHandler{echoVNCSecurity()}}), "RFB 003.008\n", func(v *viewerScript) {
+			v.expect("security types", []byte{1, 16})
+			v.send("security type", []byte{16})
+			v.expect("tunnel capabilities", u32(0))
+			v.expect("authentication capabilities", u32(1), u32(2), []byte("STDVVNCAUTH_"))
+			v.send("authentication choice", u32(2))
+			challenge := v.take("challenge", 16)
+			v.send("response", challenge)
+			v.expect("security result", u32(0))
+			v.initialise()
+			v.expect("interaction capabilities",
+				u16(0), u16(0), u16(8), u16(0),
+				u32(0), []byte("STDVRAW_____"),
+				u32(1), []byte("STDVCOPYRECT"),
+				u32(2), []byte("STDVRRE_____"),
+				u32(4), []byte("STDVCORRE___"),
+				u32(5), []byte("STDVHEXTILE_"),
+				u32(6), []byte("TRDVZLIB____"),
+				u32(7), []byte("TGHTTIGHT___"),
+				u32(16), []byte("TRDVZRLE____"))
+		}},
+		{"3.8 Tight without authentication", registry(&TightSecurityHandler{}), "RFB 003.008\n", func(v *viewerScript) {
+			v.expect("security types", []byte{1, 16})
+			v.send("security type", []byte{16})
+			v.expect("tunnel capabilities", u32(0))
+			v.expect("authentication capabilities", u32(0))
+			v.expect("security result", u32(0))
+			v.initialise()
+			v.take("interaction capabilities", 8+8*16)
+		}},
+		{"3.8 ARD", registry(&ARDSecurityHandler{Verify: func(username, password string) bool {
+			return username == "user" && password == "pass"
+		}}), "RFB 003.008\n", func(v *viewerScript) {
+			v.expect("security types", []byte{1, 30})
+			v.send("security type", []byte{30})
+			v.expect("generator and key length", u16(2), u16(128))
+			v.expect("prime", ARDPrime.Bytes())
+			challenge := &ARDChallengeMessage{Generator: 2, Prime: ARDPrime.Bytes(), PublicKey: v.take("public key", 128)}
+			response, err := ARDRespond(challenge, "user", "pass")
+			if err != nil {
+				v.t.Fatal(err)
+			}
+			v.send("response", response.Ciphertext[:], response.PublicKey)
+			v.expect("security result", u32(0))
+			v.initialise()
+		}},
+		{"3.8 with a security type that wasn't offered", registry(&NoneSecurityHandler{}), "RFB 003.008\n", func(v *viewerScript) {
+			v.expect("security types", []byte{1, 1})
+			v.send("security type", []byte{2})
+			v.expectClosed("after the wrong choice")
+		}},
+		{"3.889 is treated as 3.8", registry(&NoneSecurityHandler{}), "RFB 003.889\n", func(v *viewerScript) {
+			v.expect("security types", []byte{1, 1})
+			v.send("security type", []byte{1})
+			v.expect("security result", u32(0))
+			v.initialise()
+		}},
+		{"4.0 is refused", registry(&NoneSecurityHandler{}), "RFB 004.000\n", func(v *viewerScript) {
+			v.expect("refusal", []byte{0}, str32("Only RFB 3.x is supported, but the client requested 4.0."))
+			v.expectClosed("after refusal")
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			v := startConformance(t, test.security)
+			v.expect("ProtocolVersion", []byte("RFB 003.008\n"))
+			v.send("ProtocolVersion", []byte(test.version))
+			test.script(v)
+		})
+	}
+}
+
+// connectRFB38 connects to a server without authentication, through ServerInit.
+func connectRFB38(t *testing.T) *viewerScript {
+	v := startConformance(t, nil)
+	v.expect("ProtocolVersion", []byte("RFB 003.008\n"))
+	v.send("ProtocolVersion", []byte("RFB 003.008\n"))
+	v.expect("security types", []byte{1, 1})
+	v.send("security type", []byte{1})
+	v.expect("security result", u32(0))
+	v.initialise()
+	return v
+}
+
+// updateRequest is a FramebufferUpdateRequest.
+func updateRequest(incremental bool, x, y, width, height uint16) []byte {
+	var flag byte
+	if incremental {
+		flag = 1
+	}
+	return bytes.Join([][]byte{{3, flag}, u16(x), u16(y), u16(width), u16(height)}, nil)
+}
+
+// rawUpdate is a FramebufferUpdate of one Raw rectangle.
+func rawUpdate(x, y, width, height uint16, pixels []byte) []byte {
+	return bytes.Join([][]byte{{0, 0}, u16(1), u16(x), u16(y), u16(width), u16(height), u32(0), pixels}, nil)
+}
+
+func TestConformancePixelFormats(t *testing.T) {
+	// Each format is what follows SetPixelFormat's type and padding, and pixel is how it writes yellow.
+	tests := []struct {
+		name        string
+		pixelFormat []byte
+		colourMap   bool
+		pixel       []byte
+	}{
+		{"8-bit true color", []byte{8, 8, 0, 1, 0, 7, 0, 7, 0, 3, 0, 3, 6, 0, 0, 0}, false, []byte{0x3f}},
+		{"16-bit little-endian RGB565", []byte{16, 16, 0, 1, 0, 31, 0, 63, 0, 31, 11, 5, 0, 0, 0, 0}, false, []byte{0xe0, 0xff}},
+		{"16-bit big-endian RGB555", []byte{16, 15, 1, 1, 0, 31, 0, 31, 0, 31, 10, 5, 0, 0, 0, 0}, false, []byte{0x7f, 0xe0}},
+		{"32-bit little-endian BGR", []byte{32, 24, 0, 1, 0, 255, 0, 255, 0, 255, 0, 8, 16, 0, 0, 0}, false, []byte{0xff, 0xff, 0, 0}},
+		{"32-bit big-endian 10 bits per channel", []byte{32, 30, 1, 1, 3, 255, 3, 255, 3, 255, 20, 10, 0, 0, 0, 0}, false, []byte{0x3f, 0xff, 0xfc, 0}},
+		{"8-bit colour mapped", []byte{8, 8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, true, []byte{0x3f}},
+		{"8-bit colour mapped with junk maxes", []byte{8, 8, 0, 0, 0, 1, 0, 2, 0, 3, 4, 5, 6, 0, 0, 0}, true, []byte{0x3f}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			v := connectRFB38(t)
+			v.send("SetPixelFormat", []byte{0, 0, 0, 0}, test.pixelFormat)
+			v.send("FramebufferUpdateRequest", updateRequest(false, 3, 2, 1, 1))
+			if test.colourMap {
+				v.expect("SetColourMapEntries", []byte{1, 0}, u16(0), u16(256))
+				v.take("colours", 256*6)
+			}
+			v.expect("FramebufferUpdate", rawUpdate(3, 2, 1, 1, test.pixel))
+		})
+	}
+
+	t.Run("24 bits per pixel", func(t *testing.T) {
+		v := connectRFB38(t)
+		v.send("SetPixelFormat", []byte{0, 0, 0, 0}, []byte{24, 24, 0, 1, 0, 255, 0, 255, 0, 255, 16, 8, 0, 0, 0, 0})
+		v.expectClosed("after an unsupported pixel format")
+	})
+}
+
+func TestConformanceUpdateRequests(t *testing.T) {
+	v := connectRFB38(t)
+	v.send("zero-size FramebufferUpdateRequest", updateRequest(false, 0, 0, 0, 0))
+	v.expect("empty FramebufferUpdate", []byte{0, 0}, u16(0))
+	v.send("incremental zero-size FramebufferUpdateRequest", updateRequest(true, 2, 1, 0, 0))
+	v.expect("empty FramebufferUpdate", []byte{0, 0}, u16(0))
+	v.send("FramebufferUpdateRequest outside the framebuffer", updateRequest(false, 100, 100, 10, 10))
+	v.expect("empty FramebufferUpdate", []byte{0, 0}, u16(0))
+	v.send("FramebufferUpdateRequest past the edge", updateRequest(false, 3, 2, 10, 10))
+	v.expect("clipped FramebufferUpdate", rawUpdate(3, 2, 1, 1, []byte{0xff, 0xff, 0, 0}))
+
+	// Once the viewer has the whole frame, incremental updates only carry what changed, which here is nothing.
+	v.send("FramebufferUpdateRequest", updateRequest(false, 0, 0, 4, 3))
+	v.expect("FramebufferUpdate", rawUpdate(0, 0, 4, 3, bytes.Repeat([]byte{0xff, 0xff, 0, 0}, 12)))
+	v.send("incremental FramebufferUpdateRequest", updateRequest(true, 0, 0, 4, 3))
+	v.expect("empty FramebufferUpdate", []byte{0, 0}, u16(0))
+}
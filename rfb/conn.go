@@ -0,0 +1,455 @@
+package rfb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// ServerMessage is a message the server sends after initialisation. Write must include the message type byte.
+type ServerMessage interface {
+	Write(w io.Writer, bo binary.ByteOrder) error
+}
+
+// Conn is the server side of one RFB connection. It owns the connection's buffering so that callers deal in typed
+// messages instead of bytes.
+//
+// Conn is also an io.ReadWriter for the handshake, which isn't message-oriented. Writes are buffered until Flush, or
+// until the next Read, so a handshake step's reply is never waited on before its request is sent.
+//
+// ReadMessage and WriteMessage may be called concurrently with each other, but not with themselves.
+type Conn struct {
+	r  messageReader
+	w  messageWriter
+	bo binary.ByteOrder
+
+	// The pixel format framebuffer updates should be encoded with. ReadMessage updates it when the client sends
+	// SetPixelFormat.
+	PixelFormat PixelFormat
+
+	// The encoding types the client supports, in order of preference. ReadMessage updates it when the client sends
+	// SetEncodings.
+	EncodingTypes []int32
+
+	// The version the client asked for, which may not be one this package knows. Set during the handshake.
+	Version ProtocolVersionMessage
+
+	// Workarounds for this client's bugs. See QuirkRule.
+	Quirks Quirks
+
+	// Bound the lengths ReadMessage accepts. Zero fields use DefaultLimits.
+	Limits Limits
+
+	// If set, ReadMessage fails if the next message doesn't arrive in time, and WriteMessage fails if the client
+	// doesn't take the message in time, so dead or stalled clients don't hold their connections forever. They only
+	// work if the connection has deadlines, like a net.Conn.
+	ReadTimeout, WriteTimeout time.Duration
+
+	// If set, ReadMessage checks on clients that haven't sent anything for this long by sending a FenceMessage request,
+	// if they support fences, and fails if they don't answer within another KeepAlive. Unlike ReadTimeout, it doesn't
+	// disconnect clients that are idle but still there. It only works if the connection has deadlines.
+	KeepAlive time.Duration
+
+	// If set, given every message ReadMessage and WriteMessage read and write.
+	Tracer Tracer
+
+	deadlines deadliner // The connection, if it has deadlines.
+
+	writeLock sync.Mutex // Held while writing a message, since ReadMessage may write fences for KeepAlive.
+	out       io.Writer  // The connection, which w buffers writes to.
+	recorder  *recorder  // Set while what's sent to the client is being recorded.
+
+	statsLock       sync.Mutex
+	stats           ConnStats
+	updateRequested time.Time // When the oldest unanswered FramebufferUpdateRequest arrived, if there is one.
+}
+
+// ConnStats is what a Conn has sent and received so far. See Conn.Stats.
+type ConnStats struct {
+	Connected time.Time // When the Conn was created.
+
+	// Bytes read from and written to the connection, including the handshake.
+	BytesRead, BytesWritten int64
+
+	FramebufferUpdates int64 // FramebufferUpdate messages sent.
+	Rectangles         int64 // Rectangles in those updates, including pseudo-encodings.
+
+	// The encoding of the last update's pixels, or Raw if none have been sent.
+	Encoding int32
+
+	// How long the last update took to send after the client requested it, including any time spent waiting for
+	// something to change or for the frame rate limit.
+	UpdateLatency time.Duration
+
+	// How long recent updates took to write to the connection, on average, which grows as the link's bandwidth runs
+	// out. See Server.AdaptiveFPS.
+	WriteLatency time.Duration
+}
+
+// countingConn counts the bytes read from and written to a Conn's connection.
+type countingConn struct {
+	rw io.ReadWriter
+	c  *Conn
+}
+
+func (cc *countingConn) Read(p []byte) (int, error) {
+	n, err := cc.rw.Read(p)
+	cc.c.statsLock.Lock()
+	cc.c.stats.BytesRead += int64(n)
+	cc.c.statsLock.Unlock()
+	return n, err
+}
+
+func (cc *countingConn) Write(p []byte) (int, error) {
+	n, err := cc.rw.Write(p)
+	cc.c.statsLock.Lock()
+	cc.c.stats.BytesWritten += int64(n)
+	cc.c.statsLock.Unlock()
+	return n, err
+}
+
+// deadliner is implemented by connections with deadlines, like net.Conn.
+type deadliner interface {
+	SetDeadline(t time.Time) error
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+}
+
+func NewConn(rw io.ReadWriter, pixelFormat PixelFormat) *Conn {
+	deadlines, _ := rw.(deadliner)
+	c := &Conn{
+		bo:          binary.BigEndian,
+		PixelFormat: pixelFormat,
+		deadlines:   deadlines,
+		stats:       ConnStats{Connected: time.Now()},
+	}
+	counting := &countingConn{rw, c}
+	c.r = messageReader{Reader: bufio.NewReader(counting)}
+	c.w = messageWriter{Writer: bufio.NewWriter(counting)}
+	c.out = counting
+	return c
+}
+
+// Stats returns what the Conn has sent and received so far. It may be called concurrently with everything else.
+func (c *Conn) Stats() ConnStats {
+	c.statsLock.Lock()
+	defer c.statsLock.Unlock()
+	return c.stats
+}
+
+// SetDeadline sets the connection's deadline for the reads and writes of the handshake, if it has deadlines. The zero
+// time clears it.
+func (c *Conn) SetDeadline(t time.Time) error {
+	if c.deadlines == nil {
+		return nil
+	}
+	return c.deadlines.SetDeadline(t)
+}
+
+// ByteOrder returns the byte order of multi-byte protocol fields, which is always big-endian.
+func (c *Conn) ByteOrder() binary.ByteOrder {
+	return c.bo
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	if err := c.w.Flush(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+func (c *Conn) Write(p []byte) (int, error) {
+	return c.w.Write(p)
+}
+
+func (c *Conn) Flush() error {
+	return c.w.Flush()
+}
+
+// ReadMessage reads the next client message. See ReadClientMessage.
+func (c *Conn) ReadMessage() (ClientMessage, error) {
+	if err := c.awaitMessage(); err != nil {
+		return nil, err
+	}
+	c.r.limits = c.Limits.withDefaults()
+	start := time.Now() // The message has started arriving.
+	m, err := readClientMessage(&c.r, c.bo)
+	if err != nil {
+		return nil, err
+	}
+	if c.Tracer != nil {
+		now := time.Now()
+		c.Tracer.Trace(&TraceEvent{Direction: FromClient, Message: m, Time: now, Duration: now.Sub(start)})
+	}
+	switch m := m.(type) {
+	case *SetPixelFormatMessage:
+		c.PixelFormat = m.PixelFormat
+	case *SetEncodingsMessage:
+		c.EncodingTypes = m.EncodingTypes
+	case *FramebufferUpdateRequestMessage:
+		c.statsLock.Lock()
+		if c.updateRequested.IsZero() {
+			c.updateRequested = time.Now()
+		}
+		c.statsLock.Unlock()
+	}
+	return m, nil
+}
+
+// awaitMessage waits for the next message to start arriving, checking on quiet clients if KeepAlive is set, and leaves
+// the read deadline as ReadTimeout would have it for reading the rest. It may be called from a goroutine other than the
+// one writing messages, so long as ReadMessage isn't called until it returns.
+func (c *Conn) awaitMessage() error {
+	if c.ReadTimeout > 0 && c.deadlines != nil {
+		if err := c.deadlines.SetReadDeadline(time.Now().Add(c.ReadTimeout)); err != nil {
+			return fmt.Errorf("set read deadline: %v", err)
+		}
+	}
+	if c.KeepAlive > 0 && c.deadlines != nil {
+		if err := c.waitForMessage(); err != nil {
+			return err
+		}
+	}
+	if _, err := c.r.Peek(1); err != nil {
+		return fmt.Errorf("read message type: %v", err)
+	}
+	return nil
+}
+
+// waitForMessage waits for the next message to start arriving, sending fence requests while the client is quiet. It
+// leaves the read deadline as ReadTimeout would have it.
+func (c *Conn) waitForMessage() error {
+	var idleDeadline time.Time
+	if c.ReadTimeout > 0 {
+		idleDeadline = time.Now().Add(c.ReadTimeout)
+	}
+	probed := false
+	for c.r.Buffered() == 0 {
+		deadline := time.Now().Add(c.KeepAlive)
+		if !idleDeadline.IsZero() && idleDeadline.Before(deadline) {
+			deadline = idleDeadline
+		}
+		if err := c.deadlines.SetReadDeadline(deadline); err != nil {
+			return fmt.Errorf("set read deadline: %v", err)
+		}
+		_, err := c.r.Peek(1)
+		if err == nil {
+			break
+		}
+		if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() || deadline == idleDeadline {
+			return fmt.Errorf("read message type: %v", err)
+		}
+		if probed {
+			return fmt.Errorf("client didn't answer a keepalive within %v", c.KeepAlive)
+		}
+		if c.supportsEncoding(EncodingTypeFence) {
+			if err := c.WriteMessage(&FenceMessage{Flags: FenceRequest}); err != nil {
+				return fmt.Errorf("send keepalive: %v", err)
+			}
+			probed = true
+		}
+	}
+	// Whatever's left of the message must arrive as quickly as it would have without keepalives.
+	if err := c.deadlines.SetReadDeadline(idleDeadline); err != nil {
+		return fmt.Errorf("set read deadline: %v", err)
+	}
+	return nil
+}
+
+func (c *Conn) supportsEncoding(encodingType int32) bool {
+	return containsEncoding(c.EncodingTypes, encodingType)
+}
+
+// cursorEncoding returns whichever cursor pseudo-encoding the client prefers, if it supports any.
+func (c *Conn) cursorEncoding() (int32, bool) {
+	for _, t := range c.EncodingTypes {
+		if t == EncodingTypeCursor || t == EncodingTypeXCursor || t == EncodingTypeCursorWithAlpha {
+			return t, true
+		}
+	}
+	return 0, false
+}
+
+// WriteMessage sends a message to the client immediately.
+func (c *Conn) WriteMessage(m ServerMessage) error {
+	if update, ok := m.(*FramebufferUpdateMessage); ok && len(update.Rectangles) == 0 && c.Quirks&QuirkNonEmptyUpdates != 0 {
+		m = &FramebufferUpdateMessage{Rectangles: []*FramebufferUpdateRect{{EncodingType: EncodingTypeRaw}}}
+	}
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	if c.WriteTimeout > 0 && c.deadlines != nil {
+		if err := c.deadlines.SetWriteDeadline(time.Now().Add(c.WriteTimeout)); err != nil {
+			return fmt.Errorf("set write deadline: %v", err)
+		}
+	}
+	var start time.Time
+	if c.Tracer != nil {
+		start = time.Now()
+	}
+	if err := m.Write(&c.w, c.bo); err != nil {
+		return fmt.Errorf("write %T: %v", m, err)
+	}
+	if err := c.w.Flush(); err != nil {
+		return fmt.Errorf("flush %T: %v", m, err)
+	}
+	if update, ok := m.(*FramebufferUpdateMessage); ok {
+		c.countUpdate(update)
+	}
+	if c.Tracer != nil {
+		now := time.Now()
+		c.Tracer.Trace(&TraceEvent{Direction: ToClient, Message: m, Time: now, Duration: now.Sub(start)})
+	}
+	return nil
+}
+
+// countWriteLatency adds how long an update took to write to the stats, and returns the new average.
+func (c *Conn) countWriteLatency(d time.Duration) time.Duration {
+	c.statsLock.Lock()
+	defer c.statsLock.Unlock()
+	if c.stats.WriteLatency == 0 {
+		c.stats.WriteLatency = d
+	} else {
+		c.stats.WriteLatency += (d - c.stats.WriteLatency) / writeLatencyWeight
+	}
+	return c.stats.WriteLatency
+}
+
+// ConnStats.WriteLatency moves 1/writeLatencyWeight of the way to each update's write latency, so one slow write
+// doesn't slow the frame rate much, but a link that has become slow does within several updates.
+const writeLatencyWeight = 4
+
+// countUpdate adds an update that has been sent to the stats.
+func (c *Conn) countUpdate(update *FramebufferUpdateMessage) {
+	c.statsLock.Lock()
+	defer c.statsLock.Unlock()
+	c.stats.FramebufferUpdates++
+	c.stats.Rectangles += int64(len(update.Rectangles))
+	for _, rect := range update.Rectangles {
+		encodingType := rect.EncodingType
+		if rect.Encoding != nil {
+			encodingType = rect.Encoding.Type()
+		}
+		if encodingType >= 0 && encodingType != EncodingTypeCopyRectangle { // Pseudo-encodings are negative.
+			c.stats.Encoding = encodingType
+		}
+	}
+	if !c.updateRequested.IsZero() {
+		c.stats.UpdateLatency = time.Since(c.updateRequested)
+		c.updateRequested = time.Time{}
+	}
+}
+
+// recorder copies what's sent to the client to a recording, until writing the recording fails.
+type recorder struct {
+	conn, recording io.Writer
+	err             error // The error that stopped the recording.
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	n, err := r.conn.Write(p)
+	if n > 0 && r.err == nil {
+		_, r.err = r.recording.Write(p[:n])
+	}
+	return n, err
+}
+
+// record copies everything sent to the client from now on to w, until stopRecording. Errors writing w stop the
+// copying but don't affect the connection.
+func (c *Conn) record(w io.Writer) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	if err := c.w.Flush(); err != nil {
+		return err
+	}
+	c.recorder = &recorder{conn: c.out, recording: w}
+	c.w.Reset(c.recorder)
+	return nil
+}
+
+// stopRecording stops copying to the recording and closes it if it's an io.Closer. It returns the error that stopped
+// the recording early, if any, or the error closing it.
+func (c *Conn) stopRecording() error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	r := c.recorder
+	if r == nil {
+		return nil
+	}
+	c.w.Flush() // Buffered writes belong in the recording. If they can't be sent, the connection is done anyway.
+	c.w.Reset(c.out)
+	c.recorder = nil
+	err := r.err
+	if closer, ok := r.recording.(io.Closer); ok {
+		if closeErr := closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// Hooks are called by Conn.Serve for each message of their type. Nil hooks are skipped. If a hook returns an error,
+// Serve stops and returns it.
+type Hooks struct {
+	SetPixelFormat           func(m *SetPixelFormatMessage) error
+	SetEncodings             func(m *SetEncodingsMessage) error
+	FramebufferUpdateRequest func(m *FramebufferUpdateRequestMessage) error
+	KeyEvent                 func(m *KeyEventMessage) error
+	PointerEvent             func(m *PointerEventMessage) error
+	ClientCutText            func(m *ClientCutTextMessage) error
+
+	// Called for every other message, such as those added with RegisterClientMessage.
+	Other func(m ClientMessage) error
+}
+
+// Serve reads messages and calls the matching hooks until reading fails or a hook returns an error.
+func (c *Conn) Serve(hooks Hooks) error {
+	for {
+		message, err := c.ReadMessage()
+		if err != nil {
+			return err
+		}
+		if err := hooks.dispatch(message); err != nil {
+			return err
+		}
+	}
+}
+
+// dispatch calls the hook for message's type, if it's set.
+func (hooks *Hooks) dispatch(message ClientMessage) error {
+	var err error
+	switch m := message.(type) {
+	case *SetPixelFormatMessage:
+		if hooks.SetPixelFormat != nil {
+			err = hooks.SetPixelFormat(m)
+		}
+	case *SetEncodingsMessage:
+		if hooks.SetEncodings != nil {
+			err = hooks.SetEncodings(m)
+		}
+	case *FramebufferUpdateRequestMessage:
+		if hooks.FramebufferUpdateRequest != nil {
+			err = hooks.FramebufferUpdateRequest(m)
+		}
+	case *KeyEventMessage:
+		if hooks.KeyEvent != nil {
+			err = hooks.KeyEvent(m)
+		}
+	case *PointerEventMessage:
+		if hooks.PointerEvent != nil {
+			err = hooks.PointerEvent(m)
+		}
+	case *ClientCutTextMessage:
+		if hooks.ClientCutText != nil {
+			err = hooks.ClientCutText(m)
+		}
+	default:
+		if hooks.Other != nil {
+			err = hooks.Other(m)
+		}
+	}
+	return err
+}
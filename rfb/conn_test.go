@@ -0,0 +1,168 @@
+package rfb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestConnServe(t *testing.T) {
+	var in bytes.Buffer
+	bo := binary.BigEndian
+	pixelFormat := DefaultPixelFormat
+	pixelFormat.BitsPerPixel = 8
+	(&SetPixelFormatMessage{PixelFormat: pixelFormat}).Write(&in, bo)
+	(&KeyEventMessage{Pressed: true, KeySym: 'a'}).Write(&in, bo)
+	(&FramebufferUpdateRequestMessage{Width: 1, Height: 1}).Write(&in, bo)
+
+	var out bytes.Buffer
+	c := NewConn(&readWriter{&in, &out}, DefaultPixelFormat)
+	var keys []uint32
+	stop := errors.New("stop")
+	err := c.Serve(Hooks{
+		KeyEvent: func(m *KeyEventMessage) error {
+			keys = append(keys, m.KeySym)
+			return nil
+		},
+		FramebufferUpdateRequest: func(m *FramebufferUpdateRequestMessage) error {
+			if err := c.WriteMessage(&BellMessage{}); err != nil {
+				return err
+			}
+			return stop
+		},
+	})
+	if err != stop {
+		t.Fatalf("Serve returned %v, want the hook's error", err)
+	}
+	if c.PixelFormat.BitsPerPixel != 8 {
+		t.Errorf("pixel format wasn't updated")
+	}
+	if len(keys) != 1 || keys[0] != 'a' {
+		t.Errorf("got keys %v, want [a]", keys)
+	}
+	if !bytes.Equal(out.Bytes(), []byte{2}) {
+		t.Errorf("wrote %v, want Bell", out.Bytes())
+	}
+}
+
+type readWriter struct {
+	*bytes.Buffer
+	w *bytes.Buffer
+}
+
+func (rw *readWriter) Write(p []byte) (int, error) {
+	return rw.w.Write(p)
+}
+
+// loopConn replays the same bytes forever and discards writes, for benchmarking message traffic.
+type loopConn struct {
+	data []byte
+	off  int
+}
+
+func (c *loopConn) Read(p []byte) (int, error) {
+	n := copy(p, c.data[c.off:])
+	c.off = (c.off + n) % len(c.data)
+	return n, nil
+}
+
+func (c *loopConn) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+// Reading a message only allocates the message, and writing one allocates nothing, so busy connections don't churn
+// the garbage collector.
+func TestConnAllocations(t *testing.T) {
+	var buf bytes.Buffer
+	(&PointerEventMessage{ButtonMask: ButtonLeft, X: 10, Y: 20}).Write(&buf, binary.BigEndian)
+	c := NewConn(&loopConn{data: buf.Bytes()}, DefaultPixelFormat)
+	if n := testing.AllocsPerRun(100, func() { c.ReadMessage() }); n > 1 {
+		t.Errorf("ReadMessage made %v allocations, want 1", n)
+	}
+	update := &FramebufferUpdateMessage{PixelFormat: DefaultPixelFormat, Rectangles: []*FramebufferUpdateRect{
+		{Width: 8, Height: 8, Encoding: &CopyRectEncoder{Src: image.Pt(0, 8)}},
+		{Width: 1, Height: 1, EncodingType: EncodingTypeRaw, PixelData: make([]byte, 4)},
+	}}
+	for _, m := range []ServerMessage{update, &BellMessage{}} {
+		if n := testing.AllocsPerRun(100, func() { c.WriteMessage(m) }); n > 0 {
+			t.Errorf("WriteMessage(%T) made %v allocations, want 0", m, n)
+		}
+	}
+}
+
+func TestConnStats(t *testing.T) {
+	var in, out bytes.Buffer
+	(&FramebufferUpdateRequestMessage{Width: 4, Height: 3}).Write(&in, binary.BigEndian)
+	c := NewConn(&struct {
+		io.Reader
+		io.Writer
+	}{&in, &out}, DefaultPixelFormat)
+	if _, err := c.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+	update := &FramebufferUpdateMessage{PixelFormat: DefaultPixelFormat, Rectangles: []*FramebufferUpdateRect{
+		{Width: 1, Height: 1, EncodingType: EncodingTypeRaw, PixelData: make([]byte, 4)},
+		{Width: 4, Height: 3, EncodingType: EncodingTypeDesktopSize},
+	}}
+	if err := c.WriteMessage(update); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.WriteMessage(&BellMessage{}); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := c.Stats()
+	if stats.BytesRead != 10 || stats.BytesWritten != int64(out.Len()) {
+		t.Errorf("read %d bytes and wrote %d, want 10 and %d", stats.BytesRead, stats.BytesWritten, out.Len())
+	}
+	if stats.FramebufferUpdates != 1 || stats.Rectangles != 2 || stats.Encoding != EncodingTypeRaw {
+		t.Errorf("stats are %+v, want 1 update of 2 rectangles in Raw", stats)
+	}
+	if stats.UpdateLatency < time.Millisecond {
+		t.Errorf("update latency is %v, want at least 1ms", stats.UpdateLatency)
+	}
+}
+
+func BenchmarkConnReadMessage(b *testing.B) {
+	var buf bytes.Buffer
+	for _, m := range []ClientMessage{
+		&FramebufferUpdateRequestMessage{Incremental: true, Width: 640, Height: 480},
+		&PointerEventMessage{ButtonMask: ButtonLeft, X: 10, Y: 20},
+		&KeyEventMessage{Pressed: true, KeySym: 'x'},
+		&KeyEventMessage{KeySym: 'x'},
+	} {
+		if err := m.Write(&buf, binary.BigEndian); err != nil {
+			b.Fatal(err)
+		}
+	}
+	c := NewConn(&loopConn{data: buf.Bytes()}, DefaultPixelFormat)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := c.ReadMessage(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkConnWriteMessage(b *testing.B) {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	c := NewConn(&loopConn{}, DefaultPixelFormat)
+	m := &FramebufferUpdateMessage{PixelFormat: DefaultPixelFormat, Rectangles: []*FramebufferUpdateRect{
+		{Width: 64, Height: 64, Encoding: &CopyRectEncoder{Src: image.Pt(0, 64)}},
+		{Width: 64, Height: 64, EncodingType: EncodingTypeRaw, PixelData: make([]byte, 64*64*4)},
+		{Width: 64, Height: 64, Encoding: &RawEncoder{}, Image: img},
+	}}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := c.WriteMessage(m); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package rfb
+
+import (
+	"encoding/binary"
+	"image"
+	"io"
+)
+
+// CopyRectEncoder tells the client to fill a rectangle by copying another part of its own framebuffer, so no pixels
+// are sent. Since the source depends on the rectangle, a new CopyRectEncoder is used for each one, and none is
+// registered with RegisterEncoding.
+type CopyRectEncoder struct {
+	Src image.Point
+}
+
+func (e *CopyRectEncoder) Type() int32 {
+	return EncodingTypeCopyRectangle
+}
+
+func (e *CopyRectEncoder) Encode(w io.Writer, pixelFormat PixelFormat, img image.Image, rect image.Rectangle) error {
+	buf := writeBuffer(w, 4)
+	binary.BigEndian.PutUint16(buf[0:], uint16(e.Src.X))
+	binary.BigEndian.PutUint16(buf[2:], uint16(e.Src.Y))
+	_, err := w.Write(buf)
+	return err
+}
+
+// CopyRegion says that Rect's new contents are the same as the contents of the same-sized region at Src before the
+// update, as when content scrolls.
+type CopyRegion struct {
+	Rect image.Rectangle
+	Src  image.Point
+}
+
+// Copier is implemented by Handlers that know when content has moved. Clients that support CopyRect are sent those
+// regions as CopyRect rectangles instead of pixels.
+type Copier interface {
+	// Copies is called after each Render and returns which parts of the frame just rendered are copies of the frame
+	// rendered before it.
+	Copies() []CopyRegion
+}
+
+// copyRectangles turns copies into CopyRect rectangles within rect, skipping any that would copy from a region an
+// earlier copy changed, and returns them with the parts of rect that still need pixels.
+func copyRectangles(copies []CopyRegion, rect, framebuffer image.Rectangle) ([]*FramebufferUpdateRect, []image.Rectangle) {
+	var rects []*FramebufferUpdateRect
+	var dsts []image.Rectangle
+	for _, cp := range copies {
+		dst := cp.Rect.Intersect(rect)
+		if dst.Empty() {
+			continue
+		}
+		src := dst.Add(cp.Src.Sub(cp.Rect.Min))
+		if !src.In(framebuffer) {
+			continue
+		}
+		clobbered := false
+		for _, d := range dsts {
+			if d.Overlaps(src) || d.Overlaps(dst) {
+				clobbered = true
+			}
+		}
+		if clobbered {
+			continue
+		}
+		dsts = append(dsts, dst)
+		rects = append(rects, &FramebufferUpdateRect{
+			X: uint16(dst.Min.X), Y: uint16(dst.Min.Y), Width: uint16(dst.Dx()), Height: uint16(dst.Dy()),
+			Encoding: &CopyRectEncoder{Src: src.Min},
+		})
+	}
+	return rects, subtractRectangles(rect, dsts)
+}
+
+// subtractRectangles returns rectangles covering the parts of r outside every cut.
+func subtractRectangles(r image.Rectangle, cuts []image.Rectangle) []image.Rectangle {
+	remaining := []image.Rectangle{r}
+	for _, cut := range cuts {
+		var next []image.Rectangle
+		for _, piece := range remaining {
+			next = append(next, subtractRectangle(piece, cut)...)
+		}
+		remaining = next
+	}
+	return remaining
+}
+
+// subtractRectangle returns up to four rectangles covering the parts of r outside cut: full-width bands above and
+// below it, and pieces to its left and right.
+func subtractRectangle(r, cut image.Rectangle) []image.Rectangle {
+	cut = cut.Intersect(r)
+	if cut.Empty() {
+		return []image.Rectangle{r}
+	}
+	var pieces []image.Rectangle
+	for _, piece := range []image.Rectangle{
+		image.Rect(r.Min.X, r.Min.Y, r.Max.X, cut.Min.Y),
+		image.Rect(r.Min.X, cut.Max.Y, r.Max.X, r.Max.Y),
+		image.Rect(r.Min.X, cut.Min.Y, cut.Min.X, cut.Max.Y),
+		image.Rect(cut.Max.X, cut.Min.Y, r.Max.X, cut.Max.Y),
+	} {
+		if !piece.Empty() {
+			pieces = append(pieces, piece)
+		}
+	}
+	return pieces
+}
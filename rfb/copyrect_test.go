@@ -0,0 +1,63 @@
+package rfb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestCopyRectangles(t *testing.T) {
+	framebuffer := image.Rect(0, 0, 100, 100)
+	copies := []CopyRegion{
+		{Rect: image.Rect(0, 10, 100, 20), Src: image.Pt(0, 0)},
+		{Rect: image.Rect(0, 20, 100, 30), Src: image.Pt(0, 10)},  // Its source was just overwritten.
+		{Rect: image.Rect(0, 90, 100, 110), Src: image.Pt(0, 50)}, // Clipped to the request.
+	}
+	rects, remaining := copyRectangles(copies, framebuffer, framebuffer)
+	if len(rects) != 2 {
+		t.Fatalf("got %d CopyRect rectangles, want 2", len(rects))
+	}
+	if got := rects[1].Encoding.(*CopyRectEncoder).Src; got != image.Pt(0, 50) {
+		t.Errorf("second copy's source is %v, want (0,50)", got)
+	}
+	if rects[1].Height != 10 {
+		t.Errorf("second copy's height is %d, want 10", rects[1].Height)
+	}
+
+	area := 0
+	for _, r := range remaining {
+		area += r.Dx() * r.Dy()
+		for _, rect := range rects {
+			copied := image.Rect(int(rect.X), int(rect.Y), int(rect.X)+int(rect.Width), int(rect.Y)+int(rect.Height))
+			if r.Overlaps(copied) {
+				t.Errorf("remaining rectangle %v overlaps copied %v", r, copied)
+			}
+		}
+	}
+	if want := 100*100 - 2*100*10; area != want {
+		t.Errorf("remaining area is %d, want %d", area, want)
+	}
+}
+
+func TestClientCopyRect(t *testing.T) {
+	framebuffer := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	framebuffer.Set(1, 1, color.RGBA{0xff, 0, 0, 0xff})
+	update := &FramebufferUpdateMessage{Rectangles: []*FramebufferUpdateRect{
+		{X: 2, Y: 2, Width: 2, Height: 2, Encoding: &CopyRectEncoder{Src: image.Pt(0, 0)}},
+	}, PixelFormat: DefaultPixelFormat}
+	var buf bytes.Buffer
+	if err := update.Write(&buf, binary.BigEndian); err != nil {
+		t.Fatal(err)
+	}
+
+	client := &Client{r: bufio.NewReader(&buf), bo: binary.BigEndian, PixelFormat: DefaultPixelFormat, Framebuffer: framebuffer}
+	if _, err := client.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+	if got := framebuffer.RGBAAt(3, 3); got != (color.RGBA{0xff, 0, 0, 0xff}) {
+		t.Errorf("pixel at (3,3) is %v after the copy, want red", got)
+	}
+}
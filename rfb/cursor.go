@@ -0,0 +1,260 @@
+package rfb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+)
+
+func init() {
+	RegisterPseudoEncoding(EncodingTypeCursor, "Cursor")
+	RegisterPseudoEncoding(EncodingTypeXCursor, "XCursor")
+	RegisterPseudoEncoding(EncodingTypeCursorWithAlpha, "CursorWithAlpha")
+}
+
+// Cursor is a pointer shape that the client draws itself, so it follows the mouse without waiting for the server.
+type Cursor struct {
+	// Clients that support CursorWithAlpha blend the image with what's under it. For the rest, pixels less than half
+	// opaque are transparent, and the rest are opaque.
+	Image image.Image
+
+	// The point in Image that's at the pointer's position.
+	Hotspot image.Point
+}
+
+// NewBitmapCursor makes a two-color cursor from rows of text, where X is the foreground, . is the background, and
+// anything else is transparent. Clients that only support X cursors show these exactly.
+func NewBitmapCursor(foreground, background color.Color, hotspot image.Point, rows ...string) *Cursor {
+	width := 0
+	for _, row := range rows {
+		if len(row) > width {
+			width = len(row)
+		}
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, width, len(rows)))
+	for y, row := range rows {
+		for x, c := range row {
+			switch c {
+			case 'X':
+				img.Set(x, y, foreground)
+			case '.':
+				img.Set(x, y, background)
+			}
+		}
+	}
+	return &Cursor{Image: img, Hotspot: hotspot}
+}
+
+// CursorSource is implemented by Handlers that choose the pointer's shape. Cursor is called after each Render, and if
+// it returns a different *Cursor than last time, the update carries the new shape to clients that support it. If it
+// returns nil, the client keeps its current cursor.
+type CursorSource interface {
+	Cursor() *Cursor
+}
+
+// CursorEncoder sends a cursor with one of the cursor pseudo-encodings. The rectangle's position is the hotspot and its
+// size is the cursor's, so like CopyRectEncoder, it ignores the image and rectangle it's given and isn't registered
+// with RegisterEncoding. Read sets it for cursor rectangles.
+//
+// EncodingType chooses the pseudo-encoding:
+//   - EncodingTypeCursor, the default, sends pixels in the client's format followed by a bitmask of which are opaque.
+//   - EncodingTypeXCursor sends two colors and a bitmap choosing between them. Each pixel is sent as whichever of the
+//     cursor's two most common colors it's closer to.
+//   - EncodingTypeCursorWithAlpha sends premultiplied RGBA pixels with the Raw encoding.
+type CursorEncoder struct {
+	Cursor       *Cursor
+	EncodingType int32
+}
+
+func (e *CursorEncoder) Type() int32 {
+	if e.EncodingType == 0 {
+		return EncodingTypeCursor
+	}
+	return e.EncodingType
+}
+
+func (e *CursorEncoder) Encode(w io.Writer, pixelFormat PixelFormat, img image.Image, rect image.Rectangle) error {
+	switch e.Type() {
+	case EncodingTypeXCursor:
+		return e.encodeX(w)
+	case EncodingTypeCursorWithAlpha:
+		return e.encodeAlpha(w)
+	}
+	bounds := e.Cursor.Image.Bounds()
+	rowBytes := (bounds.Dx() + 7) / 8
+	buf := make([]byte, 0, bounds.Dx()*bounds.Dy()*int(pixelFormat.BitsPerPixel/8))
+	mask := make([]byte, rowBytes*bounds.Dy())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(e.Cursor.Image.At(x, y)).(color.NRGBA)
+			buf = pixelFormat.appendPixel(buf, pixelFormat.Pixel(color.RGBA{c.R, c.G, c.B, 0xff}))
+			if c.A >= 0x80 {
+				i, j := x-bounds.Min.X, y-bounds.Min.Y
+				mask[j*rowBytes+i/8] |= 0x80 >> uint(i%8)
+			}
+		}
+	}
+	if _, err := w.Write(buf); err != nil {
+		return err
+	}
+	_, err := w.Write(mask)
+	return err
+}
+
+func (e *CursorEncoder) encodeX(w io.Writer) error {
+	bounds := e.Cursor.Image.Bounds()
+	if bounds.Empty() {
+		return nil
+	}
+	primary, secondary := cursorColors(e.Cursor.Image)
+	rowBytes := (bounds.Dx() + 7) / 8
+	buf := make([]byte, 6+2*rowBytes*bounds.Dy())
+	copy(buf, []byte{primary.R, primary.G, primary.B, secondary.R, secondary.G, secondary.B})
+	bitmap := buf[6 : 6+rowBytes*bounds.Dy()]
+	mask := buf[6+rowBytes*bounds.Dy():]
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(e.Cursor.Image.At(x, y)).(color.NRGBA)
+			i, j := x-bounds.Min.X, y-bounds.Min.Y
+			bit := uint8(0x80) >> uint(i%8)
+			if colorDistance(c, primary) <= colorDistance(c, secondary) {
+				bitmap[j*rowBytes+i/8] |= bit
+			}
+			if c.A >= 0x80 {
+				mask[j*rowBytes+i/8] |= bit
+			}
+		}
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+func (e *CursorEncoder) encodeAlpha(w io.Writer) error {
+	bounds := e.Cursor.Image.Bounds()
+	buf := make([]byte, 4, 4+4*bounds.Dx()*bounds.Dy())
+	binary.BigEndian.PutUint32(buf, uint32(EncodingTypeRaw))
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.RGBAModel.Convert(e.Cursor.Image.At(x, y)).(color.RGBA)
+			buf = append(buf, c.R, c.G, c.B, c.A)
+		}
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+// cursorColors returns the two most common colors among img's opaque pixels, ignoring alpha. If there's only one, it's
+// returned twice.
+func cursorColors(img image.Image) (primary, secondary color.NRGBA) {
+	counts := map[color.NRGBA]int{}
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if c.A >= 0x80 {
+				counts[color.NRGBA{c.R, c.G, c.B, 0xff}]++
+			}
+		}
+	}
+	var first, second int
+	for c, n := range counts {
+		// Break ties by value so the choice doesn't depend on map order.
+		switch {
+		case n > first || (n == first && colorKey(c) < colorKey(primary)):
+			secondary, second = primary, first
+			primary, first = c, n
+		case n > second || (n == second && colorKey(c) < colorKey(secondary)):
+			secondary, second = c, n
+		}
+	}
+	if second == 0 {
+		secondary = primary
+	}
+	return primary, secondary
+}
+
+func colorKey(c color.NRGBA) uint32 {
+	return uint32(c.R)<<16 | uint32(c.G)<<8 | uint32(c.B)
+}
+
+func colorDistance(a, b color.NRGBA) int {
+	dr, dg, db := int(a.R)-int(b.R), int(a.G)-int(b.G), int(a.B)-int(b.B)
+	return dr*dr + dg*dg + db*db
+}
+
+// cursorRectangle returns a rectangle that sets the client's cursor with the given pseudo-encoding.
+func cursorRectangle(cursor *Cursor, encodingType int32) *FramebufferUpdateRect {
+	bounds := cursor.Image.Bounds()
+	hotspot := cursor.Hotspot.Sub(bounds.Min)
+	return &FramebufferUpdateRect{
+		X: uint16(hotspot.X), Y: uint16(hotspot.Y), Width: uint16(bounds.Dx()), Height: uint16(bounds.Dy()),
+		Encoding: &CursorEncoder{Cursor: cursor, EncodingType: encodingType},
+	}
+}
+
+// Reads a Cursor rectangle's payload into a Cursor whose transparent pixels have zero alpha.
+func readCursor(r io.Reader, pixelFormat PixelFormat, hotspot image.Point, width, height int) (*Cursor, error) {
+	bytesPerPixel := int(pixelFormat.BitsPerPixel / 8)
+	rowBytes := (width + 7) / 8
+	buf := make([]byte, bytesPerPixel*width*height+rowBytes*height)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	bounds := image.Rect(0, 0, width, height)
+	pixels := &PixelFormatImage{Pix: buf, Rect: bounds, PixelFormat: pixelFormat}
+	mask := buf[bytesPerPixel*width*height:]
+
+	img := image.NewNRGBA(bounds)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if mask[y*rowBytes+x/8]&(0x80>>uint(x%8)) != 0 {
+				img.Set(x, y, pixels.At(x, y))
+			}
+		}
+	}
+	return &Cursor{Image: img, Hotspot: hotspot}, nil
+}
+
+// Reads an XCursor rectangle's payload into a Cursor whose transparent pixels have zero alpha.
+func readXCursor(r io.Reader, hotspot image.Point, width, height int) (*Cursor, error) {
+	bounds := image.Rect(0, 0, width, height)
+	img := image.NewNRGBA(bounds)
+	if bounds.Empty() {
+		return &Cursor{Image: img, Hotspot: hotspot}, nil
+	}
+	rowBytes := (width + 7) / 8
+	buf := make([]byte, 6+2*rowBytes*height)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	colors := [2]color.NRGBA{{buf[3], buf[4], buf[5], 0xff}, {buf[0], buf[1], buf[2], 0xff}}
+	bitmap := buf[6 : 6+rowBytes*height]
+	mask := buf[6+rowBytes*height:]
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			bit := uint8(0x80) >> uint(x%8)
+			if mask[y*rowBytes+x/8]&bit != 0 {
+				img.SetNRGBA(x, y, colors[(bitmap[y*rowBytes+x/8]&bit)>>uint(7-x%8)])
+			}
+		}
+	}
+	return &Cursor{Image: img, Hotspot: hotspot}, nil
+}
+
+// Reads a CursorWithAlpha rectangle's payload into a Cursor. Only the Raw encoding is supported within it.
+func readCursorWithAlpha(r io.Reader, bo binary.ByteOrder, hotspot image.Point, width, height int) (*Cursor, error) {
+	var head [4]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return nil, err
+	}
+	if encodingType := int32(bo.Uint32(head[:])); encodingType != EncodingTypeRaw {
+		return nil, fmt.Errorf("only Raw cursors are supported, but found %s", EncodingName(encodingType))
+	}
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	if _, err := io.ReadFull(r, img.Pix); err != nil {
+		return nil, err
+	}
+	return &Cursor{Image: img, Hotspot: hotspot}, nil
+}
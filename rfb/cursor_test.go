@@ -0,0 +1,122 @@
+package rfb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(10, 20, 19, 22))
+	img.Set(10, 20, color.NRGBA{0xff, 0, 0, 0xff})
+	img.Set(18, 21, color.NRGBA{0, 0, 0xff, 0xc0})
+	img.Set(11, 20, color.NRGBA{0, 0xff, 0, 0x40})
+	cursor := &Cursor{Image: img, Hotspot: image.Pt(12, 21)}
+
+	var buf bytes.Buffer
+	update := FramebufferUpdateMessage{Rectangles: []*FramebufferUpdateRect{cursorRectangle(cursor, EncodingTypeCursor)}, PixelFormat: DefaultPixelFormat}
+	if err := update.Write(&buf, binary.BigEndian); err != nil {
+		t.Fatal(err)
+	}
+	if err := update.Read(&buf, binary.BigEndian, DefaultPixelFormat); err != nil {
+		t.Fatal(err)
+	}
+	decoded, ok := update.Rectangles[0].Encoding.(*CursorEncoder)
+	if !ok {
+		t.Fatalf("read %T, want a CursorEncoder", update.Rectangles[0].Encoding)
+	}
+	if got, want := decoded.Cursor.Hotspot, image.Pt(2, 1); got != want {
+		t.Errorf("got hotspot %v, want %v", got, want)
+	}
+	if got, want := decoded.Cursor.Image.Bounds(), image.Rect(0, 0, 9, 2); got != want {
+		t.Errorf("got bounds %v, want %v", got, want)
+	}
+	for _, test := range []struct {
+		x, y int
+		want color.Color
+	}{
+		{0, 0, color.NRGBA{0xff, 0, 0, 0xff}},
+		{8, 1, color.NRGBA{0, 0, 0xff, 0xff}},
+		{1, 0, color.NRGBA{}},
+		{4, 1, color.NRGBA{}},
+	} {
+		if got := decoded.Cursor.Image.At(test.x, test.y); got != test.want {
+			t.Errorf("pixel (%d, %d) is %v, want %v", test.x, test.y, got, test.want)
+		}
+	}
+}
+
+func TestXCursorRoundTrip(t *testing.T) {
+	red, blue := color.NRGBA{0xff, 0, 0, 0xff}, color.NRGBA{0, 0, 0xff, 0xff}
+	cursor := NewBitmapCursor(red, blue, image.Pt(1, 0),
+		" X",
+		"X.X  .....",
+		" X",
+	)
+	// A pixel closer to blue than red is sent as blue.
+	cursor.Image.(*image.NRGBA).Set(3, 2, color.NRGBA{0x20, 0, 0xe0, 0xff})
+
+	var buf bytes.Buffer
+	update := FramebufferUpdateMessage{Rectangles: []*FramebufferUpdateRect{cursorRectangle(cursor, EncodingTypeXCursor)}, PixelFormat: DefaultPixelFormat}
+	if err := update.Write(&buf, binary.BigEndian); err != nil {
+		t.Fatal(err)
+	}
+	if err := update.Read(&buf, binary.BigEndian, DefaultPixelFormat); err != nil {
+		t.Fatal(err)
+	}
+	decoded, ok := update.Rectangles[0].Encoding.(*CursorEncoder)
+	if !ok || decoded.Type() != EncodingTypeXCursor {
+		t.Fatalf("read %#v, want an X CursorEncoder", update.Rectangles[0].Encoding)
+	}
+	if got, want := decoded.Cursor.Image.Bounds(), image.Rect(0, 0, 10, 3); got != want {
+		t.Errorf("got bounds %v, want %v", got, want)
+	}
+	for _, test := range []struct {
+		x, y int
+		want color.Color
+	}{
+		{1, 0, red},
+		{1, 1, blue},
+		{9, 1, blue},
+		{3, 2, blue},
+		{0, 0, color.NRGBA{}},
+	} {
+		if got := decoded.Cursor.Image.At(test.x, test.y); got != test.want {
+			t.Errorf("pixel (%d, %d) is %v, want %v", test.x, test.y, got, test.want)
+		}
+	}
+}
+
+func TestCursorWithAlphaRoundTrip(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	img.Set(0, 0, color.NRGBA{0xff, 0, 0, 0xff})
+	img.Set(1, 0, color.NRGBA{0, 0xff, 0, 0x80})
+	cursor := &Cursor{Image: img, Hotspot: image.Pt(1, 1)}
+
+	var buf bytes.Buffer
+	update := FramebufferUpdateMessage{Rectangles: []*FramebufferUpdateRect{cursorRectangle(cursor, EncodingTypeCursorWithAlpha)}, PixelFormat: DefaultPixelFormat}
+	if err := update.Write(&buf, binary.BigEndian); err != nil {
+		t.Fatal(err)
+	}
+	if err := update.Read(&buf, binary.BigEndian, DefaultPixelFormat); err != nil {
+		t.Fatal(err)
+	}
+	decoded := update.Rectangles[0].Encoding.(*CursorEncoder).Cursor
+	if decoded.Hotspot != cursor.Hotspot {
+		t.Errorf("got hotspot %v, want %v", decoded.Hotspot, cursor.Hotspot)
+	}
+	for _, test := range []struct {
+		x, y int
+		want color.RGBA
+	}{
+		{0, 0, color.RGBA{0xff, 0, 0, 0xff}},
+		{1, 0, color.RGBA{0, 0x80, 0, 0x80}},
+		{2, 1, color.RGBA{}},
+	} {
+		if got := decoded.Image.At(test.x, test.y); got != test.want {
+			t.Errorf("pixel (%d, %d) is %v, want %v", test.x, test.y, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package rfb
+
+import (
+	"bytes"
+	"image"
+	"image/draw"
+)
+
+// Damager is implemented by Handlers that know which parts of the frame they change, so incremental updates only
+// compare those with what the client has. Damage is called after each Render and returns the regions that may differ
+// from the frame rendered before it.
+type Damager interface {
+	Damage() []image.Rectangle
+}
+
+// Frames are compared in tiles this size, which is small enough that a changing countdown doesn't resend much around
+// it and large enough that a busy frame isn't sent as thousands of rectangles.
+const damageTileSize = 16
+
+// shadowFramebuffer is what a client's framebuffer holds, as of the last update it was sent, so incremental updates
+// can skip what hasn't changed. It's only known once a whole frame has been sent, and is forgotten when the framebuffer
+// changes size or the client changes pixel format.
+type shadowFramebuffer struct {
+	img *image.RGBA // Nil while unknown.
+}
+
+// damaged returns the parts of rects where frame, whose bounds cover them, differs from what the client has, as runs
+// of changed tiles, with runs in consecutive rows merged where they line up. If the client's framebuffer isn't known,
+// that's all of rects. If hints isn't nil, tiles outside it are taken to be unchanged without comparing them.
+func (s *shadowFramebuffer) damaged(frame *image.RGBA, rects, hints []image.Rectangle) []image.Rectangle {
+	if s.img == nil || !frame.Rect.In(s.img.Rect) {
+		return rects
+	}
+	var damage []image.Rectangle
+	add := func(run image.Rectangle) {
+		for i := len(damage) - 1; i >= 0 && damage[i].Max.Y >= run.Min.Y; i-- {
+			if d := &damage[i]; d.Max.Y == run.Min.Y && d.Min.X == run.Min.X && d.Max.X == run.Max.X {
+				d.Max.Y = run.Max.Y
+				return
+			}
+		}
+		damage = append(damage, run)
+	}
+	for _, r := range rects {
+		for y := r.Min.Y; y < r.Max.Y; y += damageTileSize {
+			run := image.Rectangle{}
+			for x := r.Min.X; x < r.Max.X; x += damageTileSize {
+				tile := image.Rect(x, y, x+damageTileSize, y+damageTileSize).Intersect(r)
+				if (hints != nil && !overlapsAny(tile, hints)) || !s.differs(frame, tile) {
+					continue
+				}
+				if !run.Empty() && run.Max.X == tile.Min.X {
+					run.Max.X = tile.Max.X
+					continue
+				}
+				if !run.Empty() {
+					add(run)
+				}
+				run = tile
+			}
+			if !run.Empty() {
+				add(run)
+			}
+		}
+	}
+	return damage
+}
+
+func overlapsAny(r image.Rectangle, rects []image.Rectangle) bool {
+	for _, other := range rects {
+		if r.Overlaps(other) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *shadowFramebuffer) differs(frame *image.RGBA, tile image.Rectangle) bool {
+	for y := tile.Min.Y; y < tile.Max.Y; y++ {
+		i, j := frame.PixOffset(tile.Min.X, y), s.img.PixOffset(tile.Min.X, y)
+		if !bytes.Equal(frame.Pix[i:i+4*tile.Dx()], s.img.Pix[j:j+4*tile.Dx()]) {
+			return true
+		}
+	}
+	return false
+}
+
+// sent records that the client's framebuffer now shows frame within its bounds. Once a frame covering the whole
+// framebuffer has been sent, the client's framebuffer is known.
+func (s *shadowFramebuffer) sent(frame *image.RGBA, framebuffer image.Rectangle) {
+	if s.img == nil || s.img.Rect != framebuffer {
+		if !framebuffer.In(frame.Rect) {
+			s.img = nil
+			return
+		}
+		s.img = image.NewRGBA(framebuffer)
+	}
+	draw.Draw(s.img, frame.Rect.Intersect(framebuffer), frame, frame.Rect.Intersect(framebuffer).Min, draw.Src)
+}
+
+// pendingDamage collects what a Damager reports until it's been sent, since a client may ask for less than all of it.
+type pendingDamage struct {
+	rects []image.Rectangle
+}
+
+func (p *pendingDamage) add(damage []image.Rectangle) {
+	p.rects = append(p.rects, damage...)
+	if len(p.rects) > maxPendingDamage {
+		var all image.Rectangle
+		for _, r := range p.rects {
+			all = all.Union(r)
+		}
+		p.rects = append(p.rects[:0], all)
+	}
+}
+
+// sent removes rect, whose current contents the client has been sent.
+func (p *pendingDamage) sent(rect image.Rectangle) {
+	var remaining []image.Rectangle
+	for _, r := range p.rects {
+		remaining = append(remaining, subtractRectangle(r, rect)...)
+	}
+	p.rects = remaining
+}
+
+// Past this many rectangles, pending damage is merged into one around all of it.
+const maxPendingDamage = 32
+
+// forget marks the client's framebuffer unknown, so the next update resends everything asked for.
+func (s *shadowFramebuffer) forget() {
+	s.img = nil
+}
@@ -0,0 +1,80 @@
+package rfb
+
+import (
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+func TestShadowFramebuffer(t *testing.T) {
+	framebuffer := image.Rect(0, 0, 64, 40)
+	var shadow shadowFramebuffer
+	frame := image.NewRGBA(framebuffer)
+	if got := shadow.damaged(frame, []image.Rectangle{framebuffer}, nil); !reflect.DeepEqual(got, []image.Rectangle{framebuffer}) {
+		t.Errorf("before anything was sent, damage is %v, want the whole framebuffer", got)
+	}
+	shadow.sent(frame, framebuffer)
+	if got := shadow.damaged(frame, []image.Rectangle{framebuffer}, nil); len(got) != 0 {
+		t.Errorf("unchanged frame has damage %v", got)
+	}
+
+	frame.Set(20, 3, color.White)  // Second tile of the first row.
+	frame.Set(40, 3, color.White)  // And the third, which joins it.
+	frame.Set(63, 39, color.White) // The clipped tile at the bottom right.
+	want := []image.Rectangle{image.Rect(16, 0, 48, 16), image.Rect(48, 32, 64, 40)}
+	if got := shadow.damaged(frame, []image.Rectangle{framebuffer}, nil); !reflect.DeepEqual(got, want) {
+		t.Errorf("damage is %v, want %v", got, want)
+	}
+	if got := shadow.damaged(frame, []image.Rectangle{image.Rect(0, 16, 64, 32)}, nil); len(got) != 0 {
+		t.Errorf("damage within an unchanged band is %v", got)
+	}
+
+	shadow.sent(frame, framebuffer)
+	if got := shadow.damaged(frame, []image.Rectangle{framebuffer}, nil); len(got) != 0 {
+		t.Errorf("damage after sending the changes is %v", got)
+	}
+	shadow.forget()
+	if got := shadow.damaged(frame, []image.Rectangle{framebuffer}, nil); len(got) != 1 {
+		t.Errorf("after forgetting, damage is %v, want the whole framebuffer", got)
+	}
+}
+
+func TestShadowFramebufferHints(t *testing.T) {
+	framebuffer := image.Rect(0, 0, 64, 64)
+	var shadow shadowFramebuffer
+	frame := image.NewRGBA(framebuffer)
+	shadow.sent(frame, framebuffer)
+
+	frame.Set(0, 0, color.White)
+	frame.Set(20, 20, color.White)
+	frame.Set(20, 40, color.White)
+	// The changes in the second column line up, so they're one rectangle.
+	want := []image.Rectangle{image.Rect(0, 0, 16, 16), image.Rect(16, 16, 32, 48)}
+	if got := shadow.damaged(frame, []image.Rectangle{framebuffer}, nil); !reflect.DeepEqual(got, want) {
+		t.Errorf("damage is %v, want %v", got, want)
+	}
+	hints := []image.Rectangle{image.Rect(18, 18, 22, 22)}
+	want = []image.Rectangle{image.Rect(16, 16, 32, 32)}
+	if got := shadow.damaged(frame, []image.Rectangle{framebuffer}, hints); !reflect.DeepEqual(got, want) {
+		t.Errorf("damage hinted at %v is %v, want %v", hints, got, want)
+	}
+	if got := shadow.damaged(frame, []image.Rectangle{framebuffer}, []image.Rectangle{}); len(got) != 0 {
+		t.Errorf("damage with no hints is %v", got)
+	}
+}
+
+func TestPendingDamage(t *testing.T) {
+	var p pendingDamage
+	p.add([]image.Rectangle{image.Rect(0, 0, 10, 10)})
+	p.sent(image.Rect(0, 0, 10, 5))
+	if want := []image.Rectangle{image.Rect(0, 5, 10, 10)}; !reflect.DeepEqual(p.rects, want) {
+		t.Errorf("after sending the top half, pending damage is %v, want %v", p.rects, want)
+	}
+	for i := 0; i < maxPendingDamage; i++ {
+		p.add([]image.Rectangle{image.Rect(i, 20, i+1, 21)})
+	}
+	if want := []image.Rectangle{image.Rect(0, 5, 32, 21)}; !reflect.DeepEqual(p.rects, want) {
+		t.Errorf("too much pending damage became %v, want %v", p.rects, want)
+	}
+}
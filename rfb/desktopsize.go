@@ -0,0 +1,219 @@
+package rfb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+)
+
+func init() {
+	RegisterPseudoEncoding(EncodingTypeDesktopSize, "DesktopSize")
+	RegisterPseudoEncoding(EncodingTypeExtendedDesktopSize, "ExtendedDesktopSize")
+	RegisterClientMessage(251, func() ClientMessage { return &SetDesktopSizeMessage{} })
+}
+
+// DesktopSizer is implemented by Handlers whose framebuffer size can change. DesktopSize is called before each Render,
+// and if it returns a new size and the client supports DesktopSize or ExtendedDesktopSize, the client is told and
+// Resize is called with the new size. Clients that support neither keep the size they started with.
+type DesktopSizer interface {
+	DesktopSize() image.Point
+}
+
+// DesktopResizer is implemented by DesktopSizers that let clients choose the framebuffer size with SetDesktopSize.
+// SetDesktopSize reports whether the size is allowed; if it is, DesktopSize should return it, or something close,
+// from then on.
+type DesktopResizer interface {
+	DesktopSizer
+	SetDesktopSize(width, height int) bool
+}
+
+// Why an ExtendedDesktopSize rectangle was sent, in its X field.
+const (
+	DesktopSizeReasonServer      = uint16(0)
+	DesktopSizeReasonClient      = uint16(1) // The receiving client asked with SetDesktopSize.
+	DesktopSizeReasonOtherClient = uint16(2)
+)
+
+// The result of a SetDesktopSize request, in the Y field of the ExtendedDesktopSize rectangle that answers it.
+const (
+	DesktopSizeStatusOK             = uint16(0)
+	DesktopSizeStatusProhibited     = uint16(1)
+	DesktopSizeStatusOutOfResources = uint16(2)
+	DesktopSizeStatusInvalidLayout  = uint16(3)
+)
+
+// Screen is one monitor's part of the framebuffer.
+type Screen struct {
+	ID                  uint32
+	X, Y, Width, Height uint16
+	Flags               uint32
+}
+
+// SetDesktopSizeMessage asks the server to resize the framebuffer, such as when the viewer's window is resized. The
+// server answers with an ExtendedDesktopSize rectangle.
+type SetDesktopSizeMessage struct {
+	Width, Height uint16
+	Screens       []Screen
+}
+
+func (m *SetDesktopSizeMessage) Read(r io.Reader, bo binary.ByteOrder) error {
+	buf := readBuffer(r, 8)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return err
+	}
+	if buf[0] != 251 {
+		return fmt.Errorf("expected message type 251, but found %d", buf[0])
+	}
+	m.Width = bo.Uint16(buf[2:])
+	m.Height = bo.Uint16(buf[4:])
+	screens, err := readScreens(r, bo, int(buf[6]))
+	if err != nil {
+		return err
+	}
+	m.Screens = screens
+	return nil
+}
+
+func (m *SetDesktopSizeMessage) Write(w io.Writer, bo binary.ByteOrder) error {
+	if len(m.Screens) > 255 {
+		return fmt.Errorf("too many screens: %d > 255", len(m.Screens))
+	}
+	buf := writeBuffer(w, 8)
+	buf[0] = 251
+	bo.PutUint16(buf[2:], m.Width)
+	bo.PutUint16(buf[4:], m.Height)
+	buf[6] = uint8(len(m.Screens))
+	if _, err := w.Write(buf); err != nil {
+		return err
+	}
+	return writeScreens(w, bo, m.Screens)
+}
+
+func readScreens(r io.Reader, bo binary.ByteOrder, count int) ([]Screen, error) {
+	buf := make([]byte, 16*count)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	var screens []Screen
+	for i := 0; i < count; i++ {
+		b := buf[16*i:]
+		screens = append(screens, Screen{
+			ID: bo.Uint32(b[0:]),
+			X:  bo.Uint16(b[4:]), Y: bo.Uint16(b[6:]), Width: bo.Uint16(b[8:]), Height: bo.Uint16(b[10:]),
+			Flags: bo.Uint32(b[12:]),
+		})
+	}
+	return screens, nil
+}
+
+func writeScreens(w io.Writer, bo binary.ByteOrder, screens []Screen) error {
+	buf := make([]byte, 16*len(screens))
+	for i, screen := range screens {
+		b := buf[16*i:]
+		bo.PutUint32(b[0:], screen.ID)
+		bo.PutUint16(b[4:], screen.X)
+		bo.PutUint16(b[6:], screen.Y)
+		bo.PutUint16(b[8:], screen.Width)
+		bo.PutUint16(b[10:], screen.Height)
+		bo.PutUint32(b[12:], screen.Flags)
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+// ExtendedDesktopSizeEncoder sends the screen layout of an ExtendedDesktopSize rectangle, whose size is the
+// framebuffer's and whose X and Y are the reason and status. Like CopyRectEncoder, it ignores the image and rectangle
+// it's given and isn't registered with RegisterEncoding. Read sets it for ExtendedDesktopSize rectangles.
+type ExtendedDesktopSizeEncoder struct {
+	Screens []Screen
+}
+
+func (e *ExtendedDesktopSizeEncoder) Type() int32 {
+	return EncodingTypeExtendedDesktopSize
+}
+
+func (e *ExtendedDesktopSizeEncoder) Encode(w io.Writer, pixelFormat PixelFormat, img image.Image, rect image.Rectangle) error {
+	if len(e.Screens) > 255 {
+		return fmt.Errorf("too many screens: %d > 255", len(e.Screens))
+	}
+	if _, err := w.Write([]byte{uint8(len(e.Screens)), 0, 0, 0}); err != nil {
+		return err
+	}
+	return writeScreens(w, binary.BigEndian, e.Screens)
+}
+
+func readExtendedDesktopSize(r io.Reader, bo binary.ByteOrder) (*ExtendedDesktopSizeEncoder, error) {
+	buf := readBuffer(r, 4)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	screens, err := readScreens(r, bo, int(buf[0]))
+	if err != nil {
+		return nil, err
+	}
+	return &ExtendedDesktopSizeEncoder{Screens: screens}, nil
+}
+
+// desktopSize tracks what one client knows about the framebuffer's size.
+type desktopSize struct {
+	framebuffer image.Rectangle
+	screenID    uint32 // The ID of the only screen, which the client may choose.
+
+	announced bool    // Whether an ExtendedDesktopSize client has been sent the size at all.
+	status    *uint16 // The result of the client's last SetDesktopSize, if it hasn't been sent yet.
+}
+
+// update returns rectangles telling the client about any change in the framebuffer's size, and whether there was one.
+// Since clients ask for the new framebuffer's contents once they know its size, updates with a change shouldn't carry
+// pixels.
+func (d *desktopSize) update(c *Conn, h Handler) ([]*FramebufferUpdateRect, bool) {
+	extended := c.supportsEncoding(EncodingTypeExtendedDesktopSize)
+	resized := false
+	if sizer, ok := h.(DesktopSizer); ok && (extended || c.supportsEncoding(EncodingTypeDesktopSize)) {
+		if size := sizer.DesktopSize(); size != d.framebuffer.Size() && validDesktopSize(size) {
+			d.framebuffer = image.Rectangle{Max: size}
+			h.Resize(size.X, size.Y)
+			resized = true
+		}
+	}
+
+	size := d.framebuffer.Size()
+	switch {
+	case extended && (resized || d.status != nil || !d.announced):
+		reason, status := DesktopSizeReasonServer, DesktopSizeStatusOK
+		if d.status != nil {
+			reason, status = DesktopSizeReasonClient, *d.status
+		}
+		d.announced = true
+		d.status = nil
+		return []*FramebufferUpdateRect{{
+			X: reason, Y: status, Width: uint16(size.X), Height: uint16(size.Y),
+			Encoding: &ExtendedDesktopSizeEncoder{Screens: []Screen{{ID: d.screenID, Width: uint16(size.X), Height: uint16(size.Y)}}},
+		}}, resized
+	case resized:
+		return []*FramebufferUpdateRect{{Width: uint16(size.X), Height: uint16(size.Y), EncodingType: EncodingTypeDesktopSize}}, true
+	}
+	return nil, false
+}
+
+// request passes a SetDesktopSize request to the handler, if it takes them. The result is sent with the next update.
+func (d *desktopSize) request(m *SetDesktopSizeMessage, h Handler) {
+	status := DesktopSizeStatusProhibited
+	size := image.Pt(int(m.Width), int(m.Height))
+	if resizer, ok := h.(DesktopResizer); ok {
+		switch {
+		case len(m.Screens) != 1 || !validDesktopSize(size):
+			status = DesktopSizeStatusInvalidLayout
+		case resizer.SetDesktopSize(size.X, size.Y):
+			status = DesktopSizeStatusOK
+			d.screenID = m.Screens[0].ID
+		}
+	}
+	d.status = &status
+}
+
+// validDesktopSize reports whether a framebuffer of the given size can be described to clients.
+func validDesktopSize(size image.Point) bool {
+	return size.X > 0 && size.Y > 0 && size.X <= 0xffff && size.Y <= 0xffff
+}
@@ -0,0 +1,84 @@
+package rfb
+
+import (
+	"compress/zlib"
+	"fmt"
+	"image"
+	"io"
+)
+
+// Encoding writes the payload of a FramebufferUpdate rectangle, which follows the rectangle's header.
+//
+// A new Encoding is created for each connection (see RegisterEncoding), so implementations may keep per-connection
+// state such as compression streams. They are never used concurrently.
+type Encoding interface {
+	Type() int32
+
+	// Encode writes the rect portion of img, which contains rect, in the client's pixel format.
+	Encode(w io.Writer, pixelFormat PixelFormat, img image.Image, rect image.Rectangle) error
+}
+
+var encodings = map[int32]func() Encoding{}
+
+// RegisterEncoding makes an encoding available to servers. It panics if the encoding type already has an Encoding.
+func RegisterEncoding(encodingType int32, newEncoding func() Encoding) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	if _, ok := encodings[encodingType]; ok {
+		panic(fmt.Sprintf("encoding type %d already has an Encoding", encodingType))
+	}
+	encodings[encodingType] = newEncoding
+}
+
+// NewEncoding returns a new instance of the Encoding registered for encodingType.
+func NewEncoding(encodingType int32) (Encoding, bool) {
+	registryLock.RLock()
+	newEncoding, ok := encodings[encodingType]
+	registryLock.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	return newEncoding(), true
+}
+
+// EncodingOptions are the settings a client requests with pseudo-encodings in SetEncodings.
+type EncodingOptions struct {
+	// Whether the client accepts lossy JPEG compression, and if so, at which level from 0 (smallest) to 9 (best).
+	JPEG             bool
+	JPEGQualityLevel int
+
+	// Whether the client asked for a compression level, and if so, which from 0 (fastest) to 9 (smallest).
+	Compression      bool
+	CompressionLevel int
+}
+
+// ParseEncodingOptions finds the options in a client's list of encoding types. If a setting is requested more than
+// once, the first request wins.
+func ParseEncodingOptions(encodingTypes []int32) EncodingOptions {
+	var o EncodingOptions
+	for _, t := range encodingTypes {
+		if t >= EncodingTypeJPEGQualityLevel0 && t <= EncodingTypeJPEGQualityLevel9 && !o.JPEG {
+			o.JPEG = true
+			o.JPEGQualityLevel = int(t - EncodingTypeJPEGQualityLevel0)
+		}
+		if t >= EncodingTypeCompressionLevel0 && t <= EncodingTypeCompressionLevel9 && !o.Compression {
+			o.Compression = true
+			o.CompressionLevel = int(t - EncodingTypeCompressionLevel0)
+		}
+	}
+	return o
+}
+
+// zlibLevel returns the compress/zlib level for the requested compression level, which match.
+func (o EncodingOptions) zlibLevel() int {
+	if !o.Compression {
+		return zlib.DefaultCompression
+	}
+	return o.CompressionLevel
+}
+
+// Configurable is implemented by Encodings that honor EncodingOptions. Servers call SetOptions whenever the client
+// sends SetEncodings.
+type Configurable interface {
+	SetOptions(o EncodingOptions)
+}
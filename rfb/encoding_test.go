@@ -0,0 +1,68 @@
+package rfb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io"
+	"testing"
+)
+
+const testEncodingType = -0x7654321
+
+// Writes the bits per pixel and the color at rect.Min's red component.
+type testEncoding struct{}
+
+func (e *testEncoding) Type() int32 {
+	return testEncodingType
+}
+
+func (e *testEncoding) Encode(w io.Writer, pixelFormat PixelFormat, img image.Image, rect image.Rectangle) error {
+	r, _, _, _ := img.At(rect.Min.X, rect.Min.Y).RGBA()
+	_, err := w.Write([]byte{pixelFormat.BitsPerPixel, uint8(r >> 8)})
+	return err
+}
+
+func TestEncodingRegistry(t *testing.T) {
+	RegisterEncoding(testEncodingType, func() Encoding { return &testEncoding{} })
+	encoding, ok := NewEncoding(testEncodingType)
+	if !ok {
+		t.Fatal("registered encoding wasn't found")
+	}
+	if _, ok := NewEncoding(testEncodingType + 1); ok {
+		t.Error("found an encoding that wasn't registered")
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	img.Set(2, 1, color.RGBA{0x42, 0, 0, 0xff})
+	update := FramebufferUpdateMessage{
+		Rectangles:  []*FramebufferUpdateRect{{X: 2, Y: 1, Width: 2, Height: 3, Encoding: encoding, Image: img}},
+		PixelFormat: DefaultPixelFormat,
+	}
+	var buf bytes.Buffer
+	if err := update.Write(&buf, binary.BigEndian); err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{0, 0, 0, 1, 0, 2, 0, 1, 0, 2, 0, 3, 0xf8, 0x9a, 0xbc, 0xdf, 32, 0x42}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("wrote %x, want %x", buf.Bytes(), want)
+	}
+}
+
+func TestParseEncodingOptions(t *testing.T) {
+	for _, test := range []struct {
+		encodingTypes []int32
+		want          EncodingOptions
+	}{
+		{nil, EncodingOptions{}},
+		{[]int32{EncodingTypeTight, EncodingTypeRaw}, EncodingOptions{}},
+		{[]int32{EncodingTypeTight, EncodingTypeJPEGQualityLevel0}, EncodingOptions{JPEG: true, JPEGQualityLevel: 0}},
+		{[]int32{EncodingTypeJPEGQualityLevel9, EncodingTypeJPEGQualityLevel0 + 3}, EncodingOptions{JPEG: true, JPEGQualityLevel: 9}},
+		{[]int32{EncodingTypeCompressionLevel0 + 1, EncodingTypeJPEGQualityLevel0 + 5}, EncodingOptions{JPEG: true, JPEGQualityLevel: 5, Compression: true, CompressionLevel: 1}},
+	} {
+		if got := ParseEncodingOptions(test.encodingTypes); got != test.want {
+			t.Errorf("ParseEncodingOptions(%v) = %+v, want %+v", test.encodingTypes, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package rfb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// ClientMessage is a message the client sends after initialisation. Read must consume the message type byte.
+type ClientMessage interface {
+	Read(r io.Reader, bo binary.ByteOrder) error
+	Write(w io.Writer, bo binary.ByteOrder) error
+}
+
+var (
+	registryLock    sync.RWMutex
+	clientMessages  = map[uint8]func() ClientMessage{}
+	pseudoEncodings = map[int32]string{}
+	encodingNames   = map[int32]string{}
+)
+
+func init() {
+	RegisterClientMessage(0, func() ClientMessage { return &SetPixelFormatMessage{} })
+	RegisterClientMessage(2, func() ClientMessage { return &SetEncodingsMessage{} })
+	RegisterClientMessage(3, func() ClientMessage { return &FramebufferUpdateRequestMessage{} })
+	RegisterClientMessage(4, func() ClientMessage { return &KeyEventMessage{} })
+	RegisterClientMessage(5, func() ClientMessage { return &PointerEventMessage{} })
+	RegisterClientMessage(6, func() ClientMessage { return &ClientCutTextMessage{} })
+
+	RegisterEncodingName(EncodingTypeRaw, "Raw")
+	RegisterEncodingName(EncodingTypeCopyRectangle, "CopyRect")
+	RegisterEncodingName(EncodingTypeRRE, "RRE")
+	RegisterEncodingName(EncodingTypeCoRRE, "CoRRE")
+	RegisterEncodingName(EncodingTypeHextile, "Hextile")
+	RegisterEncodingName(EncodingTypeZlib, "Zlib")
+	RegisterEncodingName(EncodingTypeTight, "Tight")
+	RegisterEncodingName(EncodingTypeTightPNG, "TightPNG")
+	RegisterEncodingName(EncodingTypeTRLE, "TRLE")
+	RegisterEncodingName(EncodingTypeZRLE, "ZRLE")
+	for t := EncodingTypeJPEGQualityLevel0; t <= EncodingTypeJPEGQualityLevel9; t++ {
+		RegisterPseudoEncoding(t, fmt.Sprintf("JPEGQualityLevel%d", t-EncodingTypeJPEGQualityLevel0))
+	}
+	for t := EncodingTypeCompressionLevel0; t <= EncodingTypeCompressionLevel9; t++ {
+		RegisterPseudoEncoding(t, fmt.Sprintf("CompressionLevel%d", t-EncodingTypeCompressionLevel0))
+	}
+}
+
+// RegisterClientMessage teaches ReadClientMessage how to parse a client message type, so applications can support
+// protocol extensions without forking this package. It panics if the message type is already registered.
+func RegisterClientMessage(messageType uint8, newMessage func() ClientMessage) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	if _, ok := clientMessages[messageType]; ok {
+		panic(fmt.Sprintf("client message type %d is already registered", messageType))
+	}
+	clientMessages[messageType] = newMessage
+}
+
+// ReadClientMessage reads the next client message, whose concrete type depends on the message type byte. Since the
+// length of a message depends on its type, the connection can't continue after an unregistered type is received.
+// Messages over DefaultLimits are rejected; see ReadLimitedClientMessage.
+func ReadClientMessage(r *bufio.Reader, bo binary.ByteOrder) (ClientMessage, error) {
+	return ReadLimitedClientMessage(r, bo, DefaultLimits)
+}
+
+// RegisterEncodingName gives an encoding type a human-readable name for logging.
+// It panics if the encoding type is already registered.
+func RegisterEncodingName(encodingType int32, name string) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	if _, ok := encodingNames[encodingType]; ok {
+		panic(fmt.Sprintf("encoding type %d is already registered", encodingType))
+	}
+	encodingNames[encodingType] = name
+}
+
+// RegisterPseudoEncoding records that an encoding type advertises a protocol extension rather than a pixel encoding,
+// so it's never chosen to encode framebuffer updates. It panics if the encoding type is already registered.
+func RegisterPseudoEncoding(encodingType int32, name string) {
+	RegisterEncodingName(encodingType, name)
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	pseudoEncodings[encodingType] = name
+}
+
+// IsPseudoEncoding reports whether encodingType was registered with RegisterPseudoEncoding.
+func IsPseudoEncoding(encodingType int32) bool {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	_, ok := pseudoEncodings[encodingType]
+	return ok
+}
+
+// EncodingName returns the registered name of an encoding type, or its number if it has none.
+func EncodingName(encodingType int32) string {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	if name, ok := encodingNames[encodingType]; ok {
+		return name
+	}
+	return fmt.Sprintf("%d", encodingType)
+}
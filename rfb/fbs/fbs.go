@@ -0,0 +1,168 @@
+/*
+Package fbs reads and writes FrameBuffer Stream recordings of RFB sessions, as written by rfbproxy and vncrec.
+
+An FBS file is the 12-byte header "FBS 001.000\n" followed by blocks of the server-to-client byte stream:
+
+	U32	length
+	U8[]	data, padded with zeros to a multiple of 4 bytes
+	U32	milliseconds since the recording started
+
+By convention, the recorded stream is rewritten to look like an RFB 3.3 session without authentication, so it can be
+replayed to any viewer. NewSessionWriter writes recordings that way, and rfb.Server.Record can feed it.
+*/
+package fbs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/alltom/vncrps/rfb"
+	"io"
+	"time"
+)
+
+const Header = "FBS 001.000\n"
+
+// Block is a chunk of the server-to-client stream and when it was sent.
+type Block struct {
+	Data      []byte
+	Timestamp time.Duration
+}
+
+type Reader struct {
+	r         io.Reader
+	pending   []byte        // Unread data from the current block, for Read.
+	timestamp time.Duration // The current block's.
+}
+
+// NewReader checks the FBS header and returns a Reader positioned at the first block.
+func NewReader(r io.Reader) (*Reader, error) {
+	var buf [len(Header)]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return nil, fmt.Errorf("read header: %v", err)
+	}
+	if string(buf[:]) != Header {
+		return nil, fmt.Errorf("expected header %q, but found %q", Header, string(buf[:]))
+	}
+	return &Reader{r: r}, nil
+}
+
+// Next returns the next block, or io.EOF after the last one.
+func (r *Reader) Next() (*Block, error) {
+	var buf [4]byte
+	if _, err := io.ReadFull(r.r, buf[:]); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(buf[:])
+	const maxLength = 64 << 20
+	if length > maxLength {
+		return nil, fmt.Errorf("block is too long: %d > %d", length, maxLength)
+	}
+	data := make([]byte, (length+3)&^3)
+	if _, err := io.ReadFull(r.r, data); err != nil {
+		return nil, unexpected(err)
+	}
+	if _, err := io.ReadFull(r.r, buf[:]); err != nil {
+		return nil, unexpected(err)
+	}
+	return &Block{
+		Data:      data[:length],
+		Timestamp: time.Duration(binary.BigEndian.Uint32(buf[:])) * time.Millisecond,
+	}, nil
+}
+
+// Read reads the recorded stream with block boundaries and timestamps removed.
+func (r *Reader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		block, err := r.Next()
+		if err != nil {
+			return 0, err
+		}
+		r.pending = block.Data
+		r.timestamp = block.Timestamp
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// Timestamp returns when the block Read last read from was recorded.
+func (r *Reader) Timestamp() time.Duration {
+	return r.timestamp
+}
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// Writer writes an FBS recording.
+type Writer struct {
+	w     io.Writer
+	start time.Time
+}
+
+// NewWriter writes the FBS header. Blocks written with Write are timestamped with the time since then.
+func NewWriter(w io.Writer) (*Writer, error) {
+	if _, err := io.WriteString(w, Header); err != nil {
+		return nil, fmt.Errorf("write header: %v", err)
+	}
+	return &Writer{w: w, start: time.Now()}, nil
+}
+
+// NewSessionWriter starts a recording of a session that's already past initialisation, writing a block with an RFB
+// 3.3 handshake that leads to serverInit, so the recording can be replayed to any viewer. serverInit's pixel format
+// must be the one the session's updates are encoded in.
+func NewSessionWriter(w io.Writer, serverInit *rfb.ServerInitialisationMessage) (*Writer, error) {
+	fw, err := NewWriter(w)
+	if err != nil {
+		return nil, err
+	}
+	var handshake bytes.Buffer
+	bo := binary.BigEndian
+	(&rfb.ProtocolVersionMessage{Major: 3, Minor: 3}).Write(&handshake)
+	(&rfb.AuthenticationSchemeMessageRFB33{Scheme: rfb.AuthenticationSchemeNone}).Write(&handshake, bo)
+	if err := serverInit.Write(&handshake, bo); err != nil {
+		return nil, fmt.Errorf("write ServerInitialisation: %v", err)
+	}
+	if err := fw.WriteBlock(&Block{Data: handshake.Bytes()}); err != nil {
+		return nil, err
+	}
+	return fw, nil
+}
+
+// WriteBlock writes a block with its own timestamp, which is rounded down to the millisecond.
+func (w *Writer) WriteBlock(block *Block) error {
+	if uint64(len(block.Data)) > 0xffffffff {
+		return fmt.Errorf("block is too long: %d bytes", len(block.Data))
+	}
+	buf := make([]byte, 4, 8+len(block.Data)+3)
+	binary.BigEndian.PutUint32(buf, uint32(len(block.Data)))
+	buf = append(buf, block.Data...)
+	for len(buf)%4 != 0 {
+		buf = append(buf, 0)
+	}
+	var timestamp [4]byte
+	binary.BigEndian.PutUint32(timestamp[:], uint32(block.Timestamp/time.Millisecond))
+	buf = append(buf, timestamp[:]...)
+	_, err := w.w.Write(buf)
+	return err
+}
+
+// Write writes p as one block, timestamped with the time since the Writer was created.
+func (w *Writer) Write(p []byte) (int, error) {
+	if err := w.WriteBlock(&Block{Data: p, Timestamp: time.Since(w.start)}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close closes the underlying writer, if it's an io.Closer.
+func (w *Writer) Close() error {
+	if closer, ok := w.w.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
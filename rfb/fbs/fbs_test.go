@@ -0,0 +1,107 @@
+package fbs
+
+import (
+	"bytes"
+	"github.com/alltom/vncrps/rfb"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+type fillHandler struct {
+	color color.Color
+}
+
+func (h *fillHandler) Resize(width, height int) {}
+
+func (h *fillHandler) Render(img draw.Image, rect image.Rectangle) {
+	draw.Draw(img, rect, image.NewUniform(h.color), image.ZP, draw.Src)
+}
+
+func (h *fillHandler) KeyEvent(m *rfb.KeyEventMessage)         {}
+func (h *fillHandler) PointerEvent(m *rfb.PointerEventMessage) {}
+func (h *fillHandler) CutText(text string)                     {}
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks := []*Block{{Data: []byte("hello"), Timestamp: 0}, {Data: []byte("1234"), Timestamp: 1500 * time.Millisecond}}
+	for _, block := range blocks {
+		if err := w.WriteBlock(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if buf.Len() != len(Header)+4+8+4+4+4+4 {
+		t.Errorf("wrote %d bytes, want blocks padded to 4 bytes", buf.Len())
+	}
+
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range blocks {
+		got, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got.Data) != string(want.Data) || got.Timestamp != want.Timestamp {
+			t.Errorf("read %q at %v, want %q at %v", got.Data, got.Timestamp, want.Data, want.Timestamp)
+		}
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("read past the last block: %v", err)
+	}
+}
+
+func TestRecordServer(t *testing.T) {
+	red := color.RGBA{0xff, 0, 0, 0xff}
+	var recording bytes.Buffer
+	server := &rfb.Server{
+		Name: "test", Width: 4, Height: 3,
+		NewHandler: func(conn io.ReadWriter) (rfb.Handler, error) { return &fillHandler{color: red}, nil },
+		Record: func(conn io.ReadWriter, serverInit *rfb.ServerInitialisationMessage) io.Writer {
+			w, err := NewSessionWriter(&recording, serverInit)
+			if err != nil {
+				t.Error(err)
+			}
+			return w
+		},
+	}
+	serverConn, clientConn := net.Pipe()
+	done := make(chan error)
+	go func() { done <- server.ServeConn(serverConn) }()
+
+	client, err := rfb.NewClient(clientConn, rfb.ClientConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := client.Update(i > 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	clientConn.Close()
+	<-done
+
+	r, err := NewReader(&recording)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames, err := DecodeFrames(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != 2 {
+		t.Fatalf("decoded %d frames, want 2", len(frames))
+	}
+	if frames[0].Bounds() != image.Rect(0, 0, 4, 3) || frames[0].RGBAAt(3, 2) != red {
+		t.Errorf("first frame is %v with %v in the corner, want 4x3 and red", frames[0].Bounds(), frames[0].RGBAAt(3, 2))
+	}
+}
@@ -0,0 +1,134 @@
+package fbs
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"github.com/alltom/vncrps/rfb"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+)
+
+// DecodeFrames parses a recorded server stream and returns a snapshot of the framebuffer after each FramebufferUpdate.
+// The session must have followed RFB 3.3, though the server may have advertised a later version that the client
+// declined. Only the Raw, CopyRect, RRE, CoRRE, Hextile, Zlib, Tight, TightPNG, TRLE, and ZRLE encodings are supported.
+func DecodeFrames(rd io.Reader) ([]*image.RGBA, error) {
+	var frames []*image.RGBA
+	err := DecodeFramesFunc(rd, func(framebuffer *image.RGBA) error {
+		frame := image.NewRGBA(framebuffer.Bounds())
+		draw.Draw(frame, frame.Bounds(), framebuffer, image.ZP, draw.Src)
+		frames = append(frames, frame)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return frames, nil
+}
+
+// DecodeFramesFunc is like DecodeFrames, but calls f with the framebuffer after each FramebufferUpdate instead of
+// keeping a copy of each, so long recordings can be played without holding them in memory. The framebuffer is only
+// valid until f returns. If rd is a *Reader, its Timestamp during the call is when the update finished arriving. If f
+// returns an error, decoding stops and it's returned.
+func DecodeFramesFunc(rd io.Reader, f func(framebuffer *image.RGBA) error) error {
+	bo := binary.BigEndian
+	r := bufio.NewReader(rd)
+
+	var version rfb.ProtocolVersionMessage
+	if err := version.Read(r); err != nil {
+		return fmt.Errorf("read ProtocolVersion: %v", err)
+	}
+	var scheme rfb.AuthenticationSchemeMessageRFB33
+	if err := scheme.Read(r, bo); err != nil {
+		return fmt.Errorf("read auth scheme: %v", err)
+	}
+	switch scheme.Scheme {
+	case rfb.AuthenticationSchemeNone:
+	case rfb.AuthenticationSchemeVNC:
+		var challenge rfb.VNCAuthenticationChallengeMessage
+		var result rfb.VNCAuthenticationResultMessage
+		if err := challenge.Read(r); err != nil {
+			return fmt.Errorf("read VNC auth challenge: %v", err)
+		}
+		if err := result.Read(r, bo); err != nil {
+			return fmt.Errorf("read VNC auth result: %v", err)
+		}
+	default:
+		return fmt.Errorf("unsupported auth scheme %d", scheme.Scheme)
+	}
+
+	var serverInit rfb.ServerInitialisationMessage
+	if err := serverInit.Read(r, bo); err != nil {
+		return fmt.Errorf("read ServerInitialisation: %v", err)
+	}
+	pixelFormat := serverInit.PixelFormat
+	framebuffer := image.NewRGBA(image.Rect(0, 0, int(serverInit.FramebufferWidth), int(serverInit.FramebufferHeight)))
+
+	var decoders rfb.Decoders
+	var colourMap color.Palette
+	for {
+		messageType, err := r.Peek(1)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("read message type: %v", err)
+		}
+
+		switch messageType[0] {
+		case 0: // FramebufferUpdate
+			update := rfb.FramebufferUpdateMessage{Decoders: &decoders}
+			if err := update.Read(r, bo, pixelFormat); err != nil {
+				return fmt.Errorf("read FramebufferUpdate: %v", err)
+			}
+			for _, rect := range update.Rectangles {
+				bounds := image.Rect(int(rect.X), int(rect.Y), int(rect.X)+int(rect.Width), int(rect.Y)+int(rect.Height))
+				if copyRect, ok := rect.Encoding.(*rfb.CopyRectEncoder); ok {
+					draw.Draw(framebuffer, bounds, framebuffer, copyRect.Src, draw.Src)
+					continue
+				}
+				if _, ok := rect.Encoding.(*rfb.CursorEncoder); ok {
+					continue
+				}
+				if rect.EncodingType == rfb.EncodingTypeDesktopSize || rect.EncodingType == rfb.EncodingTypeExtendedDesktopSize {
+					resized := image.NewRGBA(image.Rect(0, 0, int(rect.Width), int(rect.Height)))
+					draw.Draw(resized, resized.Bounds(), framebuffer, image.ZP, draw.Src)
+					framebuffer = resized
+					continue
+				}
+				src := &rfb.PixelFormatImage{Pix: rect.PixelData, Rect: bounds, PixelFormat: pixelFormat, ColourMap: colourMap}
+				for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+					for x := bounds.Min.X; x < bounds.Max.X; x++ {
+						framebuffer.Set(x, y, src.At(x, y))
+					}
+				}
+			}
+			if err := f(framebuffer); err != nil {
+				return err
+			}
+
+		case 1: // SetColourMapEntries
+			var entries rfb.SetColourMapEntriesMessage
+			if err := entries.Read(r, bo); err != nil {
+				return fmt.Errorf("read SetColourMapEntries: %v", err)
+			}
+			colourMap = entries.Apply(colourMap)
+
+		case 2: // Bell
+			var bell rfb.BellMessage
+			if err := bell.Read(r, bo); err != nil {
+				return fmt.Errorf("read Bell: %v", err)
+			}
+
+		case 3: // ServerCutText
+			var cutText rfb.ServerCutTextMessage
+			if err := cutText.Read(r, bo); err != nil {
+				return fmt.Errorf("read ServerCutText: %v", err)
+			}
+
+		default:
+			return fmt.Errorf("unsupported server message type %d", messageType[0])
+		}
+	}
+}
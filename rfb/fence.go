@@ -0,0 +1,73 @@
+package rfb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+func init() {
+	RegisterPseudoEncoding(EncodingTypeFence, "Fence")
+	RegisterClientMessage(248, func() ClientMessage { return &FenceMessage{} })
+}
+
+// A pseudo-encoding for clients that understand FenceMessage. Servers mustn't send fences to other clients.
+const EncodingTypeFence = int32(-312)
+
+// Fence flags. Only FenceRequest matters to this package, which never blocks or reorders messages.
+const (
+	FenceBlockBefore = uint32(1 << 0)
+	FenceBlockAfter  = uint32(1 << 1)
+	FenceSyncNext    = uint32(1 << 2)
+	FenceRequest     = uint32(1 << 31)
+)
+
+// The longest payload a fence may carry.
+const maxFencePayload = 64
+
+// FenceMessage synchronizes the two sides of a connection. Either side may send one with FenceRequest set, and the
+// other must answer with the same payload and FenceRequest cleared once it has handled everything sent before it.
+// Server sends them to check that idle clients are still there; see Server.KeepAlive. It's both a client and server
+// message, with the same type.
+type FenceMessage struct {
+	Flags   uint32
+	Payload []byte // At most 64 bytes.
+}
+
+// Reply returns the answer to a fence request, keeping only the flags this package understands.
+func (m *FenceMessage) Reply() *FenceMessage {
+	return &FenceMessage{Flags: m.Flags &^ FenceRequest & (FenceBlockBefore | FenceBlockAfter | FenceSyncNext), Payload: m.Payload}
+}
+
+func (m *FenceMessage) Read(r io.Reader, bo binary.ByteOrder) error {
+	buf := readBuffer(r, 9)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return err
+	}
+	if buf[0] != 248 {
+		return fmt.Errorf("expected message type 248, but found %d", buf[0])
+	}
+	m.Flags = bo.Uint32(buf[4:])
+	length := int(buf[8])
+	if length > maxFencePayload {
+		return fmt.Errorf("fence payload too long: %d > %d bytes", length, maxFencePayload)
+	}
+	m.Payload = make([]byte, length)
+	_, err := io.ReadFull(r, m.Payload)
+	return err
+}
+
+func (m *FenceMessage) Write(w io.Writer, bo binary.ByteOrder) error {
+	if len(m.Payload) > maxFencePayload {
+		return fmt.Errorf("fence payload too long: %d > %d bytes", len(m.Payload), maxFencePayload)
+	}
+	buf := writeBuffer(w, 9)
+	buf[0] = 248
+	bo.PutUint32(buf[4:], m.Flags)
+	buf[8] = uint8(len(m.Payload))
+	if _, err := w.Write(buf); err != nil {
+		return err
+	}
+	_, err := w.Write(m.Payload)
+	return err
+}
@@ -0,0 +1,106 @@
+//go:build go1.18
+// +build go1.18
+
+package rfb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"testing"
+)
+
+// Each fuzz target feeds arbitrary bytes to readers of untrusted data. They only check that reading returns, with or
+// without an error, rather than panicking, hanging, or allocating without bound.
+
+func FuzzHandshakeMessages(f *testing.F) {
+	f.Add([]byte("RFB 003.008\n"))
+	f.Add([]byte{1, 2})
+	f.Add([]byte{0, 0, 0, 1, 0, 0, 0, 6, 'N', 'o', ' ', 'w', 'a', 'y'})
+	f.Add([]byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		bo := binary.BigEndian
+		(&ProtocolVersionMessage{}).Read(bytes.NewReader(data))
+		(&AuthenticationSchemeMessageRFB33{}).Read(bytes.NewReader(data), bo)
+		(&SecurityTypesMessageRFB37{}).Read(bytes.NewReader(data), bo)
+		(&SecurityTypeSelectionMessageRFB37{}).Read(bytes.NewReader(data))
+		(&SecurityResultMessageRFB38{}).Read(bytes.NewReader(data), bo)
+		(&ReasonMessage{}).Read(bytes.NewReader(data), bo)
+		(&VNCAuthenticationChallengeMessage{}).Read(bytes.NewReader(data))
+		(&VNCAuthenticationResponseMessage{}).Read(bytes.NewReader(data))
+		(&VNCAuthenticationResultMessage{}).Read(bytes.NewReader(data), bo)
+		(&ClientInitialisationMessage{}).Read(bytes.NewReader(data))
+		(&ServerInitialisationMessage{}).Read(bytes.NewReader(data), bo)
+		(&ARDChallengeMessage{}).Read(bytes.NewReader(data), bo)
+		(&ARDResponseMessage{}).Read(bytes.NewReader(data), 256)
+		(&TightCapabilitiesMessage{}).Read(bytes.NewReader(data), bo)
+		(&TightCapabilityChoiceMessage{}).Read(bytes.NewReader(data), bo)
+		(&TightInteractionCapabilitiesMessage{}).Read(bytes.NewReader(data), bo)
+	})
+}
+
+func FuzzClientMessages(f *testing.F) {
+	bo := binary.BigEndian
+	for _, m := range []ClientMessage{
+		&SetPixelFormatMessage{PixelFormat: DefaultPixelFormat},
+		&SetEncodingsMessage{EncodingTypes: []int32{EncodingTypeRaw, EncodingTypeExtendedClipboard}},
+		&FramebufferUpdateRequestMessage{Incremental: true, Width: 10, Height: 10},
+		&KeyEventMessage{Pressed: true, KeySym: 'x'},
+		&PointerEventMessage{ButtonMask: 1, X: 5, Y: 5},
+		&ClientCutTextMessage{Text: "hello"},
+		&ClientCutTextMessage{Extended: &ExtendedClipboard{Flags: ClipboardActionProvide | ClipboardFormatText, Text: "hello"}},
+		&SetDesktopSizeMessage{Width: 100, Height: 100, Screens: []Screen{{Width: 100, Height: 100}}},
+		&QEMUExtendedKeyEventMessage{Pressed: true, KeySym: 'x', KeyCode: 0x2d},
+		&FenceMessage{Flags: FenceRequest | FenceBlockBefore, Payload: []byte("ping")},
+	} {
+		var buf bytes.Buffer
+		if err := m.Write(&buf, bo); err != nil {
+			f.Fatal(err)
+		}
+		f.Add(buf.Bytes())
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		r := bufio.NewReader(bytes.NewReader(data))
+		for {
+			m, err := ReadClientMessage(r, bo)
+			if err != nil {
+				return
+			}
+			// Servers render in whatever format clients ask for.
+			if m, ok := m.(*SetPixelFormatMessage); ok {
+				img := image.NewRGBA(image.Rect(0, 0, 3, 2))
+				img.Set(1, 1, color.RGBA{0x12, 0x34, 0x56, 0xff})
+				translatorFor(&m.PixelFormat).Translate(img, img.Bounds())
+				for _, t := range []int32{EncodingTypeRaw, EncodingTypeRRE, EncodingTypeCoRRE, EncodingTypeHextile, EncodingTypeZlib, EncodingTypeTight, EncodingTypeTRLE, EncodingTypeZRLE} {
+					if e, ok := NewEncoding(t); ok {
+						e.Encode(ioutil.Discard, m.PixelFormat, img, img.Bounds())
+					}
+				}
+			}
+		}
+	})
+}
+
+func FuzzServerMessages(f *testing.F) {
+	bo := binary.BigEndian
+	// Framebuffer updates aren't fuzzed, since clients allocate whatever size of rectangle servers declare.
+	for _, m := range []ServerMessage{
+		&BellMessage{},
+		&ServerCutTextMessage{Text: "hello"},
+		colourMap(),
+	} {
+		var buf bytes.Buffer
+		if err := m.Write(&buf, bo); err != nil {
+			f.Fatal(err)
+		}
+		f.Add(buf.Bytes())
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		(&BellMessage{}).Read(bytes.NewReader(data), bo)
+		(&ServerCutTextMessage{}).Read(bytes.NewReader(data), bo)
+		(&SetColourMapEntriesMessage{}).Read(bytes.NewReader(data), bo)
+	})
+}
@@ -0,0 +1,207 @@
+package rfb
+
+import (
+	"fmt"
+	"image"
+	"io"
+)
+
+func init() {
+	RegisterEncoding(EncodingTypeHextile, func() Encoding { return &HextileEncoder{} })
+}
+
+// Hextile subencoding flags, which start each tile.
+const (
+	hextileRaw                 = 1
+	hextileBackgroundSpecified = 2
+	hextileForegroundSpecified = 4
+	hextileAnySubrects         = 8
+	hextileSubrectsColoured    = 16
+)
+
+// HextileEncoder splits rectangles into 16×16 tiles, each sent as a background color with solid subrectangles over it,
+// or raw if that's smaller. Colors carry over from one tile to the next, so flat areas cost a byte per tile.
+type HextileEncoder struct {
+	pixels  []uint32
+	covered []bool
+	tile    []byte
+	buf     []byte
+}
+
+func (e *HextileEncoder) Type() int32 {
+	return EncodingTypeHextile
+}
+
+func (e *HextileEncoder) Encode(w io.Writer, pixelFormat PixelFormat, img image.Image, rect image.Rectangle) error {
+	bytesPerPixel := int(pixelFormat.BitsPerPixel / 8)
+	var background, foreground uint32
+	validBackground, validForeground := false, false
+
+	e.buf = e.buf[:0]
+	for ty := rect.Min.Y; ty < rect.Max.Y; ty += 16 {
+		for tx := rect.Min.X; tx < rect.Max.X; tx += 16 {
+			tile := image.Rect(tx, ty, tx+16, ty+16).Intersect(rect)
+			width, height := tile.Dx(), tile.Dy()
+			e.pixels = pixelValues(e.pixels[:0], &pixelFormat, img, tile)
+
+			tileBackground, tileForeground, colors := tileColors(e.pixels)
+			var flags uint8
+			e.tile = append(e.tile[:0], 0)
+			if !validBackground || tileBackground != background {
+				flags |= hextileBackgroundSpecified
+				e.tile = pixelFormat.appendPixel(e.tile, tileBackground)
+			}
+
+			if colors > 1 {
+				flags |= hextileAnySubrects
+				if colors == 2 {
+					if !validForeground || tileForeground != foreground {
+						flags |= hextileForegroundSpecified
+						e.tile = pixelFormat.appendPixel(e.tile, tileForeground)
+					}
+				} else {
+					flags |= hextileSubrectsColoured
+				}
+				countOffset := len(e.tile)
+				e.tile = append(e.tile, 0)
+
+				if cap(e.covered) < len(e.pixels) {
+					e.covered = make([]bool, len(e.pixels))
+				}
+				e.covered = e.covered[:len(e.pixels)]
+				for i, p := range e.pixels {
+					e.covered[i] = p == tileBackground
+				}
+				count := 0
+				subrectangles(e.pixels, e.covered, width, height, func(x, y, w, h int, color uint32) {
+					if flags&hextileSubrectsColoured != 0 {
+						e.tile = pixelFormat.appendPixel(e.tile, color)
+					}
+					e.tile = append(e.tile, uint8(x<<4|y), uint8((w-1)<<4|(h-1)))
+					count++
+				})
+				e.tile[countOffset] = uint8(count) // At most 256 pixels, so at most 255 subrectangles besides the background.
+			}
+
+			if len(e.tile)-1 >= width*height*bytesPerPixel {
+				// Raw tiles leave the colors undefined for the next tile.
+				e.buf = append(e.buf, hextileRaw)
+				e.buf = appendPixels(e.buf, &pixelFormat, img, tile)
+				validBackground, validForeground = false, false
+				continue
+			}
+			e.tile[0] = flags
+			e.buf = append(e.buf, e.tile...)
+			background, validBackground = tileBackground, true
+			if colors == 2 {
+				foreground, validForeground = tileForeground, true
+			} else if colors > 2 {
+				validForeground = false
+			}
+		}
+	}
+
+	_, err := w.Write(e.buf)
+	return err
+}
+
+// tileColors returns a tile's most common color, its second most common, and how many colors it has, stopping
+// counting at 3.
+func tileColors(pixels []uint32) (background, foreground uint32, colors int) {
+	var counts [3]int
+	var values [3]uint32
+	for _, p := range pixels {
+		i := 0
+		for i < colors && values[i] != p {
+			i++
+		}
+		if i == colors {
+			if colors == 2 {
+				// Three or more colors, so subrectangles are colored individually and the background is all that matters.
+				return mostCommonPixel(pixels), 0, 3
+			}
+			values[i] = p
+			colors++
+		}
+		counts[i]++
+	}
+	if colors == 2 && counts[1] > counts[0] {
+		return values[1], values[0], 2
+	}
+	return values[0], values[1], colors
+}
+
+// readHextile reads a Hextile rectangle's payload and returns it as raw pixels.
+func readHextile(r io.Reader, pixelFormat PixelFormat, width, height int) ([]byte, error) {
+	bytesPerPixel := int(pixelFormat.BitsPerPixel / 8)
+	pixels := make([]byte, bytesPerPixel*width*height)
+	background := make([]byte, bytesPerPixel)
+	foreground := make([]byte, bytesPerPixel)
+	buf := make([]byte, bytesPerPixel+2)
+
+	fill := func(x, y, w, h int, color []byte) {
+		for sy := y; sy < y+h; sy++ {
+			for sx := x; sx < x+w; sx++ {
+				copy(pixels[(sy*width+sx)*bytesPerPixel:], color)
+			}
+		}
+	}
+
+	for ty := 0; ty < height; ty += 16 {
+		for tx := 0; tx < width; tx += 16 {
+			tile := image.Rect(tx, ty, tx+16, ty+16).Intersect(image.Rect(0, 0, width, height))
+			if _, err := io.ReadFull(r, buf[:1]); err != nil {
+				return nil, err
+			}
+			flags := buf[0]
+
+			if flags&hextileRaw != 0 {
+				for y := tile.Min.Y; y < tile.Max.Y; y++ {
+					row := pixels[(y*width+tile.Min.X)*bytesPerPixel : (y*width+tile.Max.X)*bytesPerPixel]
+					if _, err := io.ReadFull(r, row); err != nil {
+						return nil, err
+					}
+				}
+				continue
+			}
+			if flags&hextileBackgroundSpecified != 0 {
+				if _, err := io.ReadFull(r, background); err != nil {
+					return nil, err
+				}
+			}
+			if flags&hextileForegroundSpecified != 0 {
+				if _, err := io.ReadFull(r, foreground); err != nil {
+					return nil, err
+				}
+			}
+			fill(tile.Min.X, tile.Min.Y, tile.Dx(), tile.Dy(), background)
+			if flags&hextileAnySubrects == 0 {
+				continue
+			}
+
+			if _, err := io.ReadFull(r, buf[:1]); err != nil {
+				return nil, err
+			}
+			count := int(buf[0])
+			for i := 0; i < count; i++ {
+				subrect := buf[:2]
+				color := foreground
+				if flags&hextileSubrectsColoured != 0 {
+					subrect = buf[:bytesPerPixel+2]
+					color = buf[:bytesPerPixel]
+				}
+				if _, err := io.ReadFull(r, subrect); err != nil {
+					return nil, err
+				}
+				xy, wh := subrect[len(subrect)-2], subrect[len(subrect)-1]
+				x, y := int(xy>>4), int(xy&0xf)
+				w, h := int(wh>>4)+1, int(wh&0xf)+1
+				if x+w > tile.Dx() || y+h > tile.Dy() {
+					return nil, fmt.Errorf("subrectangle %dx%d at (%d, %d) is outside its %dx%d tile", w, h, x, y, tile.Dx(), tile.Dy())
+				}
+				fill(tile.Min.X+x, tile.Min.Y+y, w, h, color)
+			}
+		}
+	}
+	return pixels, nil
+}
@@ -0,0 +1,53 @@
+package rfb
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"math/rand"
+	"testing"
+)
+
+func TestHextileRoundTrip(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 70, 40))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.White), image.ZP, draw.Src)
+	draw.Draw(img, image.Rect(5, 5, 60, 12), image.NewUniform(color.Black), image.ZP, draw.Src) // Two-color tiles.
+	draw.Draw(img, image.Rect(20, 8, 30, 30), image.NewUniform(color.RGBA{0xff, 0, 0, 0xff}), image.ZP, draw.Src)
+	rng := rand.New(rand.NewSource(1))
+	for y := 32; y < 40; y++ { // Noise that's cheaper to send raw.
+		for x := 48; x < 64; x++ {
+			img.Set(x, y, color.RGBA{uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256)), 0xff})
+		}
+	}
+
+	rect := image.Rect(1, 2, 70, 40)
+	encoder := &HextileEncoder{}
+	var buf bytes.Buffer
+	// Twice, to check that no state leaks between rectangles.
+	for i := 0; i < 2; i++ {
+		buf.Reset()
+		if err := encoder.Encode(&buf, DefaultPixelFormat, img, rect); err != nil {
+			t.Fatal(err)
+		}
+		if raw := rect.Dx() * rect.Dy() * 4; buf.Len() >= raw/2 {
+			t.Errorf("encoded %d bytes, which is hardly smaller than %d raw", buf.Len(), raw)
+		}
+
+		pixels, err := readHextile(&buf, DefaultPixelFormat, rect.Dx(), rect.Dy())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if buf.Len() != 0 {
+			t.Errorf("%d bytes left over after decoding", buf.Len())
+		}
+		decoded := &PixelFormatImage{Pix: pixels, Rect: rect, PixelFormat: DefaultPixelFormat}
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			for x := rect.Min.X; x < rect.Max.X; x++ {
+				if got, want := decoded.At(x, y).(PixelFormatColor).Pixel, DefaultPixelFormat.Pixel(img.At(x, y)); got != want {
+					t.Fatalf("pixel (%d, %d) is %x, want %x", x, y, got, want)
+				}
+			}
+		}
+	}
+}
@@ -11,33 +11,49 @@ type PixelFormatImage struct {
 	Pix         []uint8
 	Rect        image.Rectangle
 	PixelFormat PixelFormat
+
+	// The colors of pixel values if PixelFormat isn't true color. If nil, or a value is out of range, this package's
+	// server's colour map is assumed.
+	ColourMap color.Palette
 }
 
+// PixelFormatColor is a pixel value in some format. Values in formats that aren't true color are assumed to index
+// this package's server's colour map.
 type PixelFormatColor struct {
 	Pixel       uint32
 	PixelFormat PixelFormat
 }
 
 func (c PixelFormatColor) RGBA() (r, g, b, a uint32) {
-	rb := (c.Pixel >> c.PixelFormat.RedShift) & uint32(c.PixelFormat.RedMax)
-	gb := (c.Pixel >> c.PixelFormat.GreenShift) & uint32(c.PixelFormat.GreenMax)
-	bb := (c.Pixel >> c.PixelFormat.BlueShift) & uint32(c.PixelFormat.BlueMax)
+	if !c.PixelFormat.TrueColor {
+		c.PixelFormat = colourMapFormat
+	}
+	r = expandComponent(c.Pixel>>c.PixelFormat.RedShift, c.PixelFormat.RedMax)
+	g = expandComponent(c.Pixel>>c.PixelFormat.GreenShift, c.PixelFormat.GreenMax)
+	b = expandComponent(c.Pixel>>c.PixelFormat.BlueShift, c.PixelFormat.BlueMax)
+	return r, g, b, 0xffff
+}
 
-	// TODO: Integer math.
-	r = uint32(float64(rb) / float64(c.PixelFormat.RedMax) * 0xffff)
-	g = uint32(float64(gb) / float64(c.PixelFormat.GreenMax) * 0xffff)
-	b = uint32(float64(bb) / float64(c.PixelFormat.BlueMax) * 0xffff)
-	a = 0xffff
-	return
+// Scales a color component from 0..max to 16 bits, rounding to nearest, so that it's the inverse of scaleComponent.
+// Maxes are one less than a power of two, so masking with max extracts the component.
+func expandComponent(v uint32, max uint16) uint32 {
+	if max == 0 {
+		return 0
+	}
+	return ((v&uint32(max))*0xffff + uint32(max)/2) / uint32(max)
 }
 
 func NewPixelFormatImage(pixelFormat PixelFormat, bounds image.Rectangle) *PixelFormatImage {
 	bytesPerPixel := int(pixelFormat.BitsPerPixel / 8)
-	return &PixelFormatImage{make([]uint8, bytesPerPixel*bounds.Dx()*bounds.Dy()), bounds, pixelFormat}
+	return &PixelFormatImage{Pix: make([]uint8, bytesPerPixel*bounds.Dx()*bounds.Dy()), Rect: bounds, PixelFormat: pixelFormat}
 }
 
+// ColorModel converts colors to the nearest PixelFormatColor, ignoring ColourMap.
 func (img *PixelFormatImage) ColorModel() color.Model {
-	panic("not implemented")
+	pf := img.PixelFormat
+	return color.ModelFunc(func(c color.Color) color.Color {
+		return PixelFormatColor{pf.Pixel(c), pf}
+	})
 }
 
 func (img *PixelFormatImage) Bounds() image.Rectangle {
@@ -59,27 +75,14 @@ func (img *PixelFormatImage) At(x, y int) color.Color {
 		panic(fmt.Sprintf("BitsPerPixel must be 8, 16, or 32, but it's %d", img.PixelFormat.BitsPerPixel))
 	}
 
-	return PixelFormatColor{pixel, img.PixelFormat}
-
-	r := (pixel >> img.PixelFormat.RedShift) & uint32(img.PixelFormat.RedMax)
-	g := (pixel >> img.PixelFormat.GreenShift) & uint32(img.PixelFormat.GreenMax)
-	b := (pixel >> img.PixelFormat.BlueShift) & uint32(img.PixelFormat.BlueMax)
-	if img.PixelFormat.RedMax != 255 || img.PixelFormat.GreenMax != 255 || img.PixelFormat.BlueMax != 255 {
-		panic(fmt.Sprintf("max red, green, and blue must be 255, but are %d, %d, and %d", img.PixelFormat.RedMax, img.PixelFormat.GreenMax, img.PixelFormat.BlueMax))
+	if !img.PixelFormat.TrueColor && int(pixel) < len(img.ColourMap) {
+		return img.ColourMap[pixel]
 	}
-	return color.NRGBA{uint8(r), uint8(g), uint8(b), 0xff}
+	return PixelFormatColor{pixel, img.PixelFormat}
 }
 
 func (img *PixelFormatImage) Set(x, y int, c color.Color) {
-	nrgba := color.NRGBAModel.Convert(c).(color.NRGBA)
-
-	if img.PixelFormat.RedMax > 255 || img.PixelFormat.GreenMax > 255 || img.PixelFormat.BlueMax > 255 {
-		panic(fmt.Sprintf("max red, green, and blue must be <= 255, but are %d, %d, and %d", img.PixelFormat.RedMax, img.PixelFormat.GreenMax, img.PixelFormat.BlueMax))
-	}
-	var pixel uint32
-	pixel |= (uint32(nrgba.R) & uint32(img.PixelFormat.RedMax)) << img.PixelFormat.RedShift
-	pixel |= (uint32(nrgba.G) & uint32(img.PixelFormat.GreenMax)) << img.PixelFormat.GreenShift
-	pixel |= (uint32(nrgba.B) & uint32(img.PixelFormat.BlueMax)) << img.PixelFormat.BlueShift
+	pixel := img.PixelFormat.Pixel(c)
 
 	idx := img.idx(x, y)
 	bo := img.bo()
@@ -0,0 +1,97 @@
+package rfb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func TestPixelFormatImageDepths(t *testing.T) {
+	rgb565 := PixelFormat{
+		BitsPerPixel: 16, BitDepth: 16, BigEndian: true, TrueColor: true,
+		RedMax: 31, GreenMax: 63, BlueMax: 31, RedShift: 11, GreenShift: 5,
+	}
+	bgr233 := PixelFormat{
+		BitsPerPixel: 8, BitDepth: 8, TrueColor: true,
+		RedMax: 7, GreenMax: 7, BlueMax: 3, GreenShift: 3, BlueShift: 6,
+	}
+	for _, test := range []struct {
+		name        string
+		pixelFormat PixelFormat
+		red         []byte // The packed pixel for pure red.
+	}{
+		{"RGB565", rgb565, []byte{0xf8, 0x00}},
+		{"BGR233", bgr233, []byte{0x07}},
+		{"RGB888", DefaultPixelFormat, []byte{0xff, 0, 0, 0}},
+	} {
+		img := NewPixelFormatImage(test.pixelFormat, image.Rect(1, 1, 4, 3))
+		if got, want := len(img.Pix), 6*len(test.red); got != want {
+			t.Fatalf("%s: image has %d bytes, want %d", test.name, got, want)
+		}
+		draw.Draw(img, img.Bounds(), image.NewUniform(color.White), image.ZP, draw.Src)
+		img.Set(3, 2, color.RGBA{0xff, 0, 0, 0xff})
+		if got := img.Pix[len(img.Pix)-len(test.red):]; string(got) != string(test.red) {
+			t.Errorf("%s: red is packed as %x, want %x", test.name, got, test.red)
+		}
+		if r, g, b, _ := img.At(1, 1).RGBA(); r != 0xffff || g != 0xffff || b != 0xffff {
+			t.Errorf("%s: white reads back as %x, %x, %x", test.name, r, g, b)
+		}
+		if r, g, b, _ := img.At(3, 2).RGBA(); r != 0xffff || g != 0 || b != 0 {
+			t.Errorf("%s: red reads back as %x, %x, %x", test.name, r, g, b)
+		}
+		for _, c := range []color.Color{color.RGBA{0x12, 0x34, 0x56, 0xff}, color.Gray{0x80}} {
+			converted := img.ColorModel().Convert(c)
+			if got, want := test.pixelFormat.Pixel(converted), test.pixelFormat.Pixel(c); got != want {
+				t.Errorf("%s: %v converts to pixel %x, which packs as %x", test.name, c, want, got)
+			}
+		}
+	}
+}
+
+func TestByteOrders(t *testing.T) {
+	for _, test := range []struct {
+		pixelFormat PixelFormat
+		want        []byte // The packed pixel for #123456.
+	}{
+		{PixelFormat{BitsPerPixel: 8, BitDepth: 8, TrueColor: true, RedMax: 7, GreenMax: 7, BlueMax: 3, GreenShift: 3, BlueShift: 6}, []byte{0x48}},
+		{PixelFormat{BitsPerPixel: 8, BitDepth: 8, BigEndian: true, TrueColor: true, RedMax: 7, GreenMax: 7, BlueMax: 3, GreenShift: 3, BlueShift: 6}, []byte{0x48}},
+		{PixelFormat{BitsPerPixel: 16, BitDepth: 16, TrueColor: true, RedMax: 31, GreenMax: 63, BlueMax: 31, RedShift: 11, GreenShift: 5}, []byte{0xaa, 0x11}},
+		{PixelFormat{BitsPerPixel: 16, BitDepth: 16, BigEndian: true, TrueColor: true, RedMax: 31, GreenMax: 63, BlueMax: 31, RedShift: 11, GreenShift: 5}, []byte{0x11, 0xaa}},
+		{PixelFormat{BitsPerPixel: 32, BitDepth: 24, TrueColor: true, RedMax: 255, GreenMax: 255, BlueMax: 255, RedShift: 16, GreenShift: 8}, []byte{0x56, 0x34, 0x12, 0}},
+		{PixelFormat{BitsPerPixel: 32, BitDepth: 24, BigEndian: true, TrueColor: true, RedMax: 255, GreenMax: 255, BlueMax: 255, RedShift: 16, GreenShift: 8}, []byte{0, 0x12, 0x34, 0x56}},
+	} {
+		pf := test.pixelFormat
+		name := fmt.Sprintf("%d bpp, big endian %v", pf.BitsPerPixel, pf.BigEndian)
+		img := NewPixelFormatImage(pf, image.Rect(0, 0, 1, 1))
+		img.Set(0, 0, color.RGBA{0x12, 0x34, 0x56, 0xff})
+		if !bytes.Equal(img.Pix, test.want) {
+			t.Errorf("%s: packed %x, want %x", name, img.Pix, test.want)
+		}
+		if got := pf.appendPixel(nil, pf.Pixel(color.RGBA{0x12, 0x34, 0x56, 0xff})); !bytes.Equal(got, test.want) {
+			t.Errorf("%s: appendPixel packed %x, want %x", name, got, test.want)
+		}
+
+		src := rleTestImage()
+		rect := src.Bounds()
+		for _, encodingType := range []int32{
+			EncodingTypeRaw, EncodingTypeRRE, EncodingTypeCoRRE, EncodingTypeHextile, EncodingTypeZlib, EncodingTypeTight,
+			EncodingTypeTightPNG, EncodingTypeTRLE, EncodingTypeZRLE,
+		} {
+			encoder, _ := NewEncoding(encodingType)
+			var buf bytes.Buffer
+			in := &FramebufferUpdateRect{Width: uint16(rect.Dx()), Height: uint16(rect.Dy()), Encoding: encoder, Image: src}
+			if err := in.Write(&buf, binary.BigEndian, pf); err != nil {
+				t.Fatalf("%s, %s: %v", name, EncodingName(encodingType), err)
+			}
+			var out FramebufferUpdateRect
+			if err := out.read(&buf, binary.BigEndian, pf, &Decoders{}); err != nil {
+				t.Fatalf("%s, %s: %v", name, EncodingName(encodingType), err)
+			}
+			checkDecoded(t, name+", "+EncodingName(encodingType), out.PixelData, src, rect, pf)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package rfb
+
+import (
+	"time"
+)
+
+// InputLimit bounds how fast a client's key and pointer events reach its Handler, so a client flooding the server with
+// input can't make it do unbounded work. It's a token bucket: events are passed on while they average at most Rate a
+// second, with bursts of up to Burst. Past that, pointer movements are coalesced and passed on before the next update,
+// key presses are dropped, and button changes and releases of passed-on presses always get through, so clicks aren't
+// lost and keys don't stick. A zero Rate means no limit.
+type InputLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// inputLimiter applies an InputLimit to one client's events.
+type inputLimiter struct {
+	limit   InputLimit
+	tokens  float64
+	last    time.Time // When tokens was last topped up.
+	pressed map[uint32]bool
+
+	buttons      uint8                // The button mask last passed on.
+	pendingMove  *PointerEventMessage // The latest movement held back, if any.
+	limited      bool                 // Whether any event has been held back or dropped.
+	onFirstLimit func()               // Called the first time an event is held back or dropped.
+}
+
+func newInputLimiter(limit InputLimit, onFirstLimit func()) *inputLimiter {
+	l := &inputLimiter{limit: limit, pressed: map[uint32]bool{}, onFirstLimit: onFirstLimit}
+	l.tokens = l.capacity()
+	return l
+}
+
+// allow takes a token if there's one left at now.
+func (l *inputLimiter) allow(now time.Time) bool {
+	if l.limit.Rate <= 0 {
+		return true
+	}
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.limit.Rate
+	}
+	l.last = now
+	if full := l.capacity(); l.tokens > full {
+		l.tokens = full
+	}
+	if l.tokens < 1 {
+		l.held()
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// capacity is how many tokens the bucket holds, which is at least one so events can get through at all.
+func (l *inputLimiter) capacity() float64 {
+	if l.limit.Burst < 1 {
+		return 1
+	}
+	return float64(l.limit.Burst)
+}
+
+func (l *inputLimiter) held() {
+	if !l.limited && l.onFirstLimit != nil {
+		l.onFirstLimit()
+	}
+	l.limited = true
+}
+
+// keyEvent passes m to h unless it's over the limit. Releases of keys whose presses were passed on always are.
+func (l *inputLimiter) keyEvent(m *KeyEventMessage, h Handler, now time.Time) {
+	if !m.Pressed && l.pressed[m.KeySym] {
+		delete(l.pressed, m.KeySym)
+	} else if !l.allow(now) {
+		return
+	} else if m.Pressed {
+		l.pressed[m.KeySym] = true
+	}
+	l.flush(h)
+	h.KeyEvent(m)
+}
+
+// pointerEvent passes m to h unless it only moves the pointer and is over the limit, in which case it's held until
+// flush.
+func (l *inputLimiter) pointerEvent(m *PointerEventMessage, h Handler, now time.Time) {
+	if m.ButtonMask == l.buttons && !l.allow(now) {
+		move := *m
+		l.pendingMove = &move
+		return
+	}
+	l.pendingMove = nil // Superseded by m.
+	l.buttons = m.ButtonMask
+	h.PointerEvent(m)
+}
+
+// flush passes on the movement being held back, if any.
+func (l *inputLimiter) flush(h Handler) {
+	if l.pendingMove != nil {
+		h.PointerEvent(l.pendingMove)
+		l.pendingMove = nil
+	}
+}
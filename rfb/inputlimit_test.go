@@ -0,0 +1,78 @@
+package rfb
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// inputHandler records the input it's given.
+type inputHandler struct {
+	events []string
+}
+
+func (h *inputHandler) Resize(width, height int)                    {}
+func (h *inputHandler) Render(img draw.Image, rect image.Rectangle) {}
+func (h *inputHandler) CutText(text string)                         {}
+
+func (h *inputHandler) KeyEvent(m *KeyEventMessage) {
+	h.events = append(h.events, fmt.Sprintf("key %c %v", rune(m.KeySym), m.Pressed))
+}
+
+func (h *inputHandler) PointerEvent(m *PointerEventMessage) {
+	h.events = append(h.events, fmt.Sprintf("pointer %d at %d", m.ButtonMask, m.X))
+}
+
+func TestInputLimiter(t *testing.T) {
+	h := &inputHandler{}
+	var limitedCalls int
+	l := newInputLimiter(InputLimit{Rate: 10, Burst: 2}, func() { limitedCalls++ })
+	now := time.Unix(0, 0)
+
+	l.pointerEvent(&PointerEventMessage{X: 1}, h, now)
+	l.keyEvent(&KeyEventMessage{KeySym: 'a', Pressed: true}, h, now)
+	// Out of tokens: movement is held, presses are dropped, and clicks and releases still get through.
+	l.pointerEvent(&PointerEventMessage{X: 2}, h, now)
+	l.pointerEvent(&PointerEventMessage{X: 3}, h, now)
+	l.keyEvent(&KeyEventMessage{KeySym: 'b', Pressed: true}, h, now)
+	l.keyEvent(&KeyEventMessage{KeySym: 'b', Pressed: false}, h, now)
+	l.keyEvent(&KeyEventMessage{KeySym: 'a', Pressed: false}, h, now)
+	l.pointerEvent(&PointerEventMessage{X: 4}, h, now)
+	l.flush(h)
+	l.pointerEvent(&PointerEventMessage{ButtonMask: ButtonLeft, X: 4}, h, now)
+	l.pointerEvent(&PointerEventMessage{X: 4}, h, now)
+	// A tenth of a second later, there's a token again.
+	now = now.Add(100 * time.Millisecond)
+	l.keyEvent(&KeyEventMessage{KeySym: 'c', Pressed: true}, h, now)
+
+	want := []string{
+		"pointer 0 at 1",
+		"key a true",
+		"pointer 0 at 3", // Passed on first so the release happens where the pointer was.
+		"key a false",
+		"pointer 0 at 4",
+		"pointer 1 at 4",
+		"pointer 0 at 4",
+		"key c true",
+	}
+	if !reflect.DeepEqual(h.events, want) {
+		t.Errorf("handler got %q, want %q", h.events, want)
+	}
+	if limitedCalls != 1 {
+		t.Errorf("onFirstLimit was called %d times, want 1", limitedCalls)
+	}
+}
+
+func TestInputLimiterUnlimited(t *testing.T) {
+	h := &inputHandler{}
+	l := newInputLimiter(InputLimit{}, nil)
+	for i := 0; i < 1000; i++ {
+		l.pointerEvent(&PointerEventMessage{X: uint16(i)}, h, time.Unix(0, 0))
+	}
+	if len(h.events) != 1000 {
+		t.Errorf("handler got %d of 1000 events", len(h.events))
+	}
+}
@@ -0,0 +1,183 @@
+/*
+Package keysym names the X11 keysyms that RFB key events identify keys with, and converts between keysyms and runes.
+
+Keysyms for Latin-1 characters are the same as their code points, so letters and digits have no constants of their
+own: use 'r' or FromRune('r'). Other characters have Unicode keysyms, which are their code points plus 0x01000000.
+*/
+package keysym
+
+import (
+	"fmt"
+)
+
+// Function keys and modifiers, named after their XK_ constants in X11's keysymdef.h.
+const (
+	BackSpace = 0xff08
+	Tab       = 0xff09
+	Linefeed  = 0xff0a
+	Clear     = 0xff0b
+	Return    = 0xff0d
+	Pause     = 0xff13
+	ScrLock   = 0xff14
+	SysReq    = 0xff15
+	Escape    = 0xff1b
+	Delete    = 0xffff
+	Space     = 0x20
+
+	ISOLeftTab = 0xfe20 // Shift+Tab on many clients.
+
+	Home     = 0xff50
+	Left     = 0xff51
+	Up       = 0xff52
+	Right    = 0xff53
+	Down     = 0xff54
+	PageUp   = 0xff55
+	PageDown = 0xff56
+	End      = 0xff57
+	Begin    = 0xff58
+
+	Select  = 0xff60
+	Print   = 0xff61
+	Execute = 0xff62
+	Insert  = 0xff63
+	Menu    = 0xff67
+	Find    = 0xff68
+	Cancel  = 0xff69
+	Help    = 0xff6a
+	Break   = 0xff6b
+	NumLock = 0xff7f
+
+	KPSpace     = 0xff80
+	KPTab       = 0xff89
+	KPEnter     = 0xff8d
+	KPHome      = 0xff95
+	KPLeft      = 0xff96
+	KPUp        = 0xff97
+	KPRight     = 0xff98
+	KPDown      = 0xff99
+	KPPageUp    = 0xff9a
+	KPPageDown  = 0xff9b
+	KPEnd       = 0xff9c
+	KPBegin     = 0xff9d
+	KPInsert    = 0xff9e
+	KPDelete    = 0xff9f
+	KPMultiply  = 0xffaa
+	KPAdd       = 0xffab
+	KPSeparator = 0xffac
+	KPSubtract  = 0xffad
+	KPDecimal   = 0xffae
+	KPDivide    = 0xffaf
+	KP0         = 0xffb0 // Through KP9, 0xffb9.
+	KPEqual     = 0xffbd
+
+	F1  = 0xffbe // Through F35, 0xffe0.
+	F2  = 0xffbf
+	F3  = 0xffc0
+	F4  = 0xffc1
+	F5  = 0xffc2
+	F6  = 0xffc3
+	F7  = 0xffc4
+	F8  = 0xffc5
+	F9  = 0xffc6
+	F10 = 0xffc7
+	F11 = 0xffc8
+	F12 = 0xffc9
+
+	ShiftL    = 0xffe1
+	ShiftR    = 0xffe2
+	ControlL  = 0xffe3
+	ControlR  = 0xffe4
+	CapsLock  = 0xffe5
+	ShiftLock = 0xffe6
+	MetaL     = 0xffe7
+	MetaR     = 0xffe8
+	AltL      = 0xffe9
+	AltR      = 0xffea
+	SuperL    = 0xffeb
+	SuperR    = 0xffec
+	HyperL    = 0xffed
+	HyperR    = 0xffee
+)
+
+// Unicode keysyms are code points with this bit set.
+const unicodeOffset = 0x01000000
+
+var names = map[uint32]string{
+	BackSpace: "BackSpace", Tab: "Tab", Linefeed: "Linefeed", Clear: "Clear", Return: "Return", Pause: "Pause",
+	ScrLock: "Scroll_Lock", SysReq: "Sys_Req", Escape: "Escape", Delete: "Delete", Space: "space",
+	ISOLeftTab: "ISO_Left_Tab", Home: "Home", Left: "Left", Up: "Up", Right: "Right", Down: "Down", PageUp: "Page_Up", PageDown: "Page_Down",
+	End: "End", Begin: "Begin",
+	Select: "Select", Print: "Print", Execute: "Execute", Insert: "Insert", Menu: "Menu", Find: "Find",
+	Cancel: "Cancel", Help: "Help", Break: "Break", NumLock: "Num_Lock",
+	KPSpace: "KP_Space", KPTab: "KP_Tab", KPEnter: "KP_Enter", KPHome: "KP_Home", KPLeft: "KP_Left", KPUp: "KP_Up",
+	KPRight: "KP_Right", KPDown: "KP_Down", KPPageUp: "KP_Page_Up", KPPageDown: "KP_Page_Down", KPEnd: "KP_End",
+	KPBegin: "KP_Begin", KPInsert: "KP_Insert", KPDelete: "KP_Delete", KPMultiply: "KP_Multiply", KPAdd: "KP_Add",
+	KPSeparator: "KP_Separator", KPSubtract: "KP_Subtract", KPDecimal: "KP_Decimal", KPDivide: "KP_Divide",
+	KPEqual: "KP_Equal", ShiftL: "Shift_L", ShiftR: "Shift_R", ControlL: "Control_L", ControlR: "Control_R", CapsLock: "Caps_Lock",
+	ShiftLock: "Shift_Lock", MetaL: "Meta_L", MetaR: "Meta_R", AltL: "Alt_L", AltR: "Alt_R", SuperL: "Super_L",
+	SuperR: "Super_R", HyperL: "Hyper_L", HyperR: "Hyper_R",
+}
+
+// The characters keypad keys type, other than digits.
+var keypadRunes = map[uint32]rune{
+	KPSpace: ' ', KPMultiply: '*', KPAdd: '+', KPSeparator: ',', KPSubtract: '-', KPDecimal: '.', KPDivide: '/',
+	KPEqual: '=',
+}
+
+// ToRune returns the character a keysym types, if it's printable: Latin-1 and Unicode keysyms, and the keypad's
+// digits and operators. Legacy keysyms for other scripts, such as 0x6cb for Cyrillic К, aren't recognized.
+func ToRune(keySym uint32) (rune, bool) {
+	switch {
+	case keySym >= 0x20 && keySym < 0x7f, keySym >= 0xa0 && keySym <= 0xff:
+		return rune(keySym), true
+	case keySym >= unicodeOffset+0x100 && keySym <= unicodeOffset+0x10ffff:
+		return rune(keySym - unicodeOffset), true
+	case keySym >= KP0 && keySym <= KP0+9:
+		return rune('0' + keySym - KP0), true
+	}
+	r, ok := keypadRunes[keySym]
+	return r, ok
+}
+
+// FromRune returns the keysym that types r. Tab, newlines, backspace, escape, and delete are their function keys.
+func FromRune(r rune) uint32 {
+	switch r {
+	case '\t':
+		return Tab
+	case '\r', '\n':
+		return Return
+	case '\b':
+		return BackSpace
+	case 0x1b:
+		return Escape
+	case 0x7f:
+		return Delete
+	}
+	if r < 0x100 {
+		return uint32(r)
+	}
+	return unicodeOffset + uint32(r)
+}
+
+// IsModifier reports whether a keysym is a modifier key, such as Shift or Control.
+func IsModifier(keySym uint32) bool {
+	return keySym >= ShiftL && keySym <= HyperR
+}
+
+// Name returns a keysym's name in keysymdef.h without the XK_ prefix, such as "Return", or the character it types.
+// Unknown keysyms are formatted in hex.
+func Name(keySym uint32) string {
+	if name, ok := names[keySym]; ok {
+		return name
+	}
+	switch {
+	case keySym >= KP0 && keySym <= KP0+9:
+		return fmt.Sprintf("KP_%d", keySym-KP0)
+	case keySym >= F1 && keySym <= F1+34:
+		return fmt.Sprintf("F%d", keySym-F1+1)
+	}
+	if r, ok := ToRune(keySym); ok {
+		return string(r)
+	}
+	return fmt.Sprintf("0x%x", keySym)
+}
@@ -0,0 +1,52 @@
+package keysym
+
+import (
+	"testing"
+)
+
+func TestRunes(t *testing.T) {
+	for _, test := range []struct {
+		keySym uint32
+		r      rune
+	}{
+		{'r', 'r'},
+		{'R', 'R'},
+		{0xe9, 'é'},
+		{0x010003ba, 'κ'},
+		{0x0101f600, '😀'},
+		{KP0 + 7, '7'},
+		{KPAdd, '+'},
+	} {
+		if r, ok := ToRune(test.keySym); !ok || r != test.r {
+			t.Errorf("ToRune(0x%x) = %q, %v, want %q", test.keySym, r, ok, test.r)
+		}
+		if test.keySym < KPSpace || test.keySym > KPEqual {
+			if keySym := FromRune(test.r); keySym != test.keySym {
+				t.Errorf("FromRune(%q) = 0x%x, want 0x%x", test.r, keySym, test.keySym)
+			}
+		}
+	}
+
+	for _, keySym := range []uint32{Return, Left, ShiftL, 0x6cb, 0x01000000} {
+		if r, ok := ToRune(keySym); ok {
+			t.Errorf("ToRune(0x%x) = %q, want no rune", keySym, r)
+		}
+	}
+	if keySym := FromRune('\n'); keySym != Return {
+		t.Errorf("FromRune('\\n') = 0x%x, want Return", keySym)
+	}
+}
+
+func TestName(t *testing.T) {
+	for keySym, want := range map[uint32]string{
+		'r': "r", Space: "space", Return: "Return", PageUp: "Page_Up", KP0 + 3: "KP_3", F1 + 11: "F12", ShiftL: "Shift_L",
+		0x6cb: "0x6cb",
+	} {
+		if got := Name(keySym); got != want {
+			t.Errorf("Name(0x%x) = %q, want %q", keySym, got, want)
+		}
+	}
+	if !IsModifier(ControlL) || IsModifier(Return) {
+		t.Error("IsModifier is wrong about Control_L or Return")
+	}
+}
@@ -0,0 +1,77 @@
+package rfb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Limits bounds the lengths a peer may declare in messages, so a malicious client can't make the server allocate or
+// read gigabytes by sending a large length. Messages over a limit are rejected with a *TooLongError before anything is
+// allocated for them. Zero fields use DefaultLimits' values.
+type Limits struct {
+	// Bytes in a cut text message, and in the text of an Extended Clipboard message after decompression. Text within
+	// the limit may still be truncated; see ClientCutTextMessage.
+	CutText uint32
+
+	// Encoding types in a SetEncodings message.
+	Encodings int
+}
+
+// DefaultLimits are used when reading messages without limits of their own. They're far above what real viewers send.
+var DefaultLimits = Limits{CutText: 1 << 20, Encodings: 1024}
+
+// TooLongError is returned when a message declares a length over its Limits. The rest of the message isn't read, so
+// the connection can't continue.
+type TooLongError struct {
+	What          string // What the length is of, such as "cut text".
+	Length, Limit uint64
+}
+
+func (e *TooLongError) Error() string {
+	return fmt.Sprintf("%s too long: %d > %d", e.What, e.Length, e.Limit)
+}
+
+// withDefaults returns l with zero fields set from DefaultLimits.
+func (l Limits) withDefaults() Limits {
+	if l.CutText == 0 {
+		l.CutText = DefaultLimits.CutText
+	}
+	if l.Encodings == 0 {
+		l.Encodings = DefaultLimits.Encodings
+	}
+	return l
+}
+
+// readLimits returns the limits to read a message from r with, which are DefaultLimits unless r came from
+// ReadLimitedClientMessage.
+func readLimits(r io.Reader) Limits {
+	if mr, ok := r.(*messageReader); ok {
+		return mr.limits
+	}
+	return DefaultLimits
+}
+
+// ReadLimitedClientMessage is like ReadClientMessage, but rejects messages over limits rather than DefaultLimits.
+func ReadLimitedClientMessage(r *bufio.Reader, bo binary.ByteOrder, limits Limits) (ClientMessage, error) {
+	return readClientMessage(&messageReader{Reader: r, limits: limits.withDefaults()}, bo)
+}
+
+func readClientMessage(r *messageReader, bo binary.ByteOrder) (ClientMessage, error) {
+	messageType, err := r.Peek(1)
+	if err != nil {
+		return nil, fmt.Errorf("read message type: %v", err)
+	}
+	registryLock.RLock()
+	newMessage, ok := clientMessages[messageType[0]]
+	registryLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("received unrecognized message type %d", messageType[0])
+	}
+	m := newMessage()
+	if err := m.Read(r, bo); err != nil {
+		return nil, fmt.Errorf("read %T: %w", m, err) // Wrapped, so callers can tell a *TooLongError apart.
+	}
+	return m, nil
+}
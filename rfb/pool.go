@@ -0,0 +1,61 @@
+package rfb
+
+import (
+	"image"
+	"sync"
+)
+
+// The most sizes of image that are recycled. Clients usually ask for the same few regions, such as the whole
+// framebuffer, but one that asks for a different region every time shouldn't grow the pools without bound, so images
+// of any other size are left to the garbage collector.
+const maxImagePools = 16
+
+// imagePools holds a *sync.Pool for each size of image that's been put back, so images are recycled across frames and
+// connections.
+var (
+	imagePoolsLock sync.Mutex
+	imagePools     = map[image.Point]*sync.Pool{}
+)
+
+// imagePool returns the pool for images of the given size, or nil if there are already maxImagePools of other sizes.
+func imagePool(size image.Point) *sync.Pool {
+	imagePoolsLock.Lock()
+	defer imagePoolsLock.Unlock()
+	p, ok := imagePools[size]
+	if !ok && len(imagePools) < maxImagePools {
+		p = &sync.Pool{}
+		imagePools[size] = p
+	}
+	return p
+}
+
+// GetRGBA returns a blank image with the given bounds, reusing one returned to PutRGBA if it has the same size.
+func GetRGBA(rect image.Rectangle) *image.RGBA {
+	p := imagePool(rect.Size())
+	if p == nil {
+		return image.NewRGBA(rect)
+	}
+	img, ok := p.Get().(*image.RGBA)
+	if !ok {
+		return image.NewRGBA(rect)
+	}
+	clearPix(img.Pix)
+	img.Rect = rect
+	return img
+}
+
+// PutRGBA recycles img, which must not be used afterward.
+func PutRGBA(img *image.RGBA) {
+	if img.Stride != 4*img.Rect.Dx() {
+		return // A subimage, which shares its pixels.
+	}
+	if p := imagePool(img.Rect.Size()); p != nil {
+		p.Put(img)
+	}
+}
+
+func clearPix(pix []uint8) {
+	for i := range pix {
+		pix[i] = 0
+	}
+}
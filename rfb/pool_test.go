@@ -0,0 +1,36 @@
+package rfb
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestImagePools(t *testing.T) {
+	img := GetRGBA(image.Rect(0, 0, 4, 3))
+	img.Set(1, 1, color.White)
+	PutRGBA(img)
+	img = GetRGBA(image.Rect(10, 20, 14, 23))
+	if img.Rect != image.Rect(10, 20, 14, 23) || len(img.Pix) != 4*4*3 {
+		t.Errorf("GetRGBA returned %v with %d bytes, want (10,20)-(14,23) with 48", img.Rect, len(img.Pix))
+	}
+	for i, v := range img.Pix {
+		if v != 0 {
+			t.Fatalf("GetRGBA returned a recycled image with byte %d set to %d", i, v)
+		}
+	}
+
+	// A client asking for a different region every time.
+	for w := 1; w <= 2*maxImagePools; w++ {
+		PutRGBA(GetRGBA(image.Rect(0, 0, w, 1)))
+	}
+	imagePoolsLock.Lock()
+	pools := len(imagePools)
+	imagePoolsLock.Unlock()
+	if pools > maxImagePools {
+		t.Errorf("%d image pools after recycling %d sizes, want at most %d", pools, 2*maxImagePools, maxImagePools)
+	}
+	if img := GetRGBA(image.Rect(0, 0, 3*maxImagePools, 1)); img.Rect != image.Rect(0, 0, 3*maxImagePools, 1) {
+		t.Errorf("GetRGBA returned %v past the last pool, want (0,0)-(%d,1)", img.Rect, 3*maxImagePools)
+	}
+}
@@ -0,0 +1,318 @@
+/*
+Package proxy forwards viewers' RFB sessions to an upstream server, decoding every message in both directions so they
+can be inspected or logged on the way.
+
+The proxy logs in to the upstream server itself and does its own handshake with each viewer, so the two sides may
+speak different protocol versions and security types. Framebuffer updates are decoded into the proxy's copy of the
+framebuffer and re-encoded in the viewer's pixel format, with the encoding the upstream server chose if the proxy can
+encode it and Raw otherwise, so the viewer only ever receives encodings it asked for.
+*/
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"github.com/alltom/vncrps/rfb"
+	"image"
+	"log/slog"
+	"net"
+	"sync"
+)
+
+// Proxy accepts viewer connections and forwards each to its own connection to the upstream server.
+type Proxy struct {
+	// Connects to the upstream server for each viewer.
+	Dial func() (net.Conn, error)
+
+	// How the proxy logs in to the upstream server. PixelFormat and Shared are ignored: the proxy asks for
+	// rfb.DefaultPixelFormat, and passes on whether the viewer asked to share.
+	Upstream rfb.ClientConfig
+
+	// Offered to viewers. If nil, viewers are let in without authentication.
+	Security *rfb.SecurityRegistry
+
+	// If set, called with each message a viewer sends before it's forwarded, and with each message the upstream server
+	// sends before it's re-encoded for the viewer. conn is the viewer's connection. Messages may be kept, but not
+	// modified.
+	FromViewer func(conn net.Conn, m rfb.ClientMessage)
+	FromServer func(conn net.Conn, m rfb.ServerMessage)
+
+	// Where errors serving connections are logged. If nil, slog.Default() is used.
+	Logger *slog.Logger
+}
+
+// Serve accepts viewers on l and forwards each in a new goroutine, until Accept fails.
+func (p *Proxy) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			if err := p.ServeConn(conn); err != nil {
+				p.logger().Warn("proxy failed", "remote", conn.RemoteAddr().String(), "err", err)
+			}
+			conn.Close()
+		}()
+	}
+}
+
+// ServeConn forwards one viewer's session until either side disconnects. It doesn't close viewer.
+func (p *Proxy) ServeConn(viewer net.Conn) error {
+	c := rfb.NewConn(viewer, rfb.DefaultPixelFormat)
+	bo := c.ByteOrder()
+
+	requested, version, err := rfb.NegotiateVersion(c)
+	c.Version = requested
+	var unsupported *rfb.UnsupportedVersionError
+	if errors.As(err, &unsupported) {
+		reason := fmt.Sprintf("Only RFB 3.x is supported, but the viewer requested %d.%d.", requested.Major, requested.Minor)
+		rfb.RefuseClient(c, bo, requested, reason)
+		c.Flush()
+		return err
+	} else if err != nil {
+		return err
+	}
+	security := p.Security
+	if security == nil {
+		security = &rfb.SecurityRegistry{}
+		security.Register(&rfb.NoneSecurityHandler{})
+	}
+	securityType, err := security.Negotiate(c, bo, version)
+	if err != nil {
+		c.Flush() // Deliver the failure reason, if any.
+		return fmt.Errorf("security handshake: %v", err)
+	}
+	var clientInit rfb.ClientInitialisationMessage
+	if err := clientInit.Read(c); err != nil {
+		return fmt.Errorf("read ClientInitialisation: %v", err)
+	}
+
+	upstreamConn, err := p.Dial()
+	if err != nil {
+		return fmt.Errorf("connect to upstream server: %v", err)
+	}
+	defer upstreamConn.Close()
+	config := p.Upstream
+	config.Shared = clientInit.Shared
+	config.PixelFormat = &rfb.DefaultPixelFormat
+	upstream, err := rfb.NewClient(upstreamConn, config)
+	if err != nil {
+		return fmt.Errorf("log in to upstream server: %v", err)
+	}
+
+	serverInit := rfb.ServerInitialisationMessage{
+		FramebufferWidth:  uint16(upstream.Framebuffer.Bounds().Dx()),
+		FramebufferHeight: uint16(upstream.Framebuffer.Bounds().Dy()),
+		PixelFormat:       upstream.PixelFormat,
+		Name:              upstream.Name,
+	}
+	if err := serverInit.Write(c, bo); err != nil {
+		return fmt.Errorf("write ServerInitialisation: %v", err)
+	}
+	if securityType == rfb.SecurityTypeTight {
+		caps := rfb.TightInteractionCapabilitiesMessage{Encodings: []rfb.TightCapability{
+			rfb.TightCapabilityRaw, rfb.TightCapabilityCopyRect, rfb.TightCapabilityRRE, rfb.TightCapabilityCoRRE,
+			rfb.TightCapabilityHextile, rfb.TightCapabilityZlib, rfb.TightCapabilityTight, rfb.TightCapabilityZRLE,
+		}}
+		if err := caps.Write(c, bo); err != nil {
+			return fmt.Errorf("write Tight interaction capabilities: %v", err)
+		}
+	}
+	if err := c.Flush(); err != nil {
+		return fmt.Errorf("flush ServerInitialisation: %v", err)
+	}
+
+	s := &session{
+		proxy: p, viewer: viewer, c: c, upstream: upstream,
+		pixelFormat: c.PixelFormat, encoders: map[int32]rfb.Encoding{},
+	}
+	errs := make(chan error, 2)
+	go func() { errs <- s.forwardViewer() }()
+	go func() { errs <- s.forwardServer() }()
+	err = <-errs
+	// Unblock the other direction.
+	upstreamConn.Close()
+	viewer.Close()
+	<-errs
+	return err
+}
+
+func (p *Proxy) logger() *slog.Logger {
+	if p.Logger != nil {
+		return p.Logger
+	}
+	return slog.Default()
+}
+
+// session is one viewer's connection and the upstream connection it's forwarded to.
+type session struct {
+	proxy    *Proxy
+	viewer   net.Conn
+	c        *rfb.Conn
+	upstream *rfb.Client
+
+	// What the viewer last asked for, which forwardViewer sets for forwardServer.
+	lock          sync.Mutex
+	pixelFormat   rfb.PixelFormat
+	encodingTypes []int32
+	sentColourMap bool
+
+	encoders map[int32]rfb.Encoding // Only used by forwardServer.
+}
+
+// forwardViewer reads the viewer's messages and sends them upstream, except those about how updates are encoded,
+// which the proxy handles itself.
+func (s *session) forwardViewer() error {
+	for {
+		m, err := s.c.ReadMessage()
+		if err != nil {
+			return fmt.Errorf("read from viewer: %v", err)
+		}
+		if s.proxy.FromViewer != nil {
+			s.proxy.FromViewer(s.viewer, m)
+		}
+		switch m := m.(type) {
+		case *rfb.SetPixelFormatMessage:
+			s.lock.Lock()
+			s.pixelFormat = m.PixelFormat
+			s.sentColourMap = false
+			s.lock.Unlock()
+			continue
+		case *rfb.SetEncodingsMessage:
+			s.lock.Lock()
+			s.encodingTypes = m.EncodingTypes
+			s.lock.Unlock()
+			// Whatever the viewer supports, the proxy decodes the same encodings, and re-encodes as the viewer asked.
+			var forwarded []int32
+			for _, t := range m.EncodingTypes {
+				if decodable(t) {
+					forwarded = append(forwarded, t)
+				}
+			}
+			m = &rfb.SetEncodingsMessage{EncodingTypes: forwarded}
+			if err := s.upstream.SendMessage(m); err != nil {
+				return fmt.Errorf("write to upstream server: %v", err)
+			}
+			continue
+		}
+		if err := s.upstream.SendMessage(m); err != nil {
+			return fmt.Errorf("write to upstream server: %v", err)
+		}
+	}
+}
+
+// decodable reports whether rfb.Client understands what the upstream server sends after being asked for an encoding.
+func decodable(encodingType int32) bool {
+	switch encodingType {
+	case rfb.EncodingTypeRaw, rfb.EncodingTypeCopyRectangle, rfb.EncodingTypeRRE, rfb.EncodingTypeCoRRE,
+		rfb.EncodingTypeHextile, rfb.EncodingTypeZlib, rfb.EncodingTypeTight, rfb.EncodingTypeTightPNG,
+		rfb.EncodingTypeTRLE, rfb.EncodingTypeZRLE,
+		rfb.EncodingTypeDesktopSize, rfb.EncodingTypeExtendedDesktopSize,
+		rfb.EncodingTypeCursor, rfb.EncodingTypeXCursor, rfb.EncodingTypeCursorWithAlpha,
+		rfb.EncodingTypeQEMUExtendedKeyEvent, rfb.EncodingTypeExtendedClipboard:
+		return true
+	}
+	// Quality and compression levels only change how the server encodes.
+	return encodingType >= rfb.EncodingTypeJPEGQualityLevel0 && encodingType <= rfb.EncodingTypeJPEGQualityLevel9 ||
+		encodingType >= rfb.EncodingTypeCompressionLevel0 && encodingType <= rfb.EncodingTypeCompressionLevel9
+}
+
+// forwardServer reads the upstream server's messages and sends them to the viewer, re-encoding updates.
+func (s *session) forwardServer() error {
+	for {
+		m, err := s.upstream.ReadMessage()
+		if err != nil {
+			return fmt.Errorf("read from upstream server: %v", err)
+		}
+		if s.proxy.FromServer != nil {
+			s.proxy.FromServer(s.viewer, m)
+		}
+
+		s.lock.Lock()
+		pixelFormat, encodingTypes := s.pixelFormat, s.encodingTypes
+		var messages []rfb.ServerMessage
+		if !pixelFormat.TrueColor && !s.sentColourMap {
+			messages = append(messages, rfb.DefaultColourMap())
+			s.sentColourMap = true
+		}
+		s.lock.Unlock()
+
+		switch m := m.(type) {
+		case *rfb.FramebufferUpdateMessage:
+			messages = append(messages, s.reencode(m, pixelFormat, encodingTypes))
+		case *rfb.FenceMessage:
+			// The proxy's client has answered it.
+		case *rfb.SetColourMapEntriesMessage:
+			// The proxy asks for true color, and the viewer's colour map is the proxy's.
+		case *rfb.ServerCutTextMessage:
+			if m.Extended == nil || contains(encodingTypes, rfb.EncodingTypeExtendedClipboard) {
+				messages = append(messages, m)
+			}
+		default:
+			messages = append(messages, m)
+		}
+		for _, m := range messages {
+			if err := s.c.WriteMessage(m); err != nil {
+				return fmt.Errorf("write to viewer: %v", err)
+			}
+		}
+	}
+}
+
+// reencode returns an update for the viewer with the same rectangles as one from the upstream server, leaving out
+// pseudo-encodings the viewer didn't ask for. Pixels are taken from the upstream client's framebuffer, which the update
+// has already been applied to.
+func (s *session) reencode(m *rfb.FramebufferUpdateMessage, pixelFormat rfb.PixelFormat, encodingTypes []int32) *rfb.FramebufferUpdateMessage {
+	update := &rfb.FramebufferUpdateMessage{PixelFormat: pixelFormat}
+	framebuffer := s.upstream.Framebuffer
+	for _, rect := range m.Rectangles {
+		bounds := image.Rect(int(rect.X), int(rect.Y), int(rect.X)+int(rect.Width), int(rect.Y)+int(rect.Height))
+		encodingType := rect.EncodingType
+		if rect.Encoding != nil {
+			encodingType = rect.Encoding.Type()
+		}
+		switch {
+		case encodingType == rfb.EncodingTypeCopyRectangle && !contains(encodingTypes, encodingType):
+			// Send the pixels that were copied instead.
+		case encodingType < 0 || encodingType == rfb.EncodingTypeCopyRectangle: // Pseudo-encodings are negative.
+			if contains(encodingTypes, encodingType) {
+				update.Rectangles = append(update.Rectangles, rect)
+			}
+			continue
+		}
+		update.Rectangles = append(update.Rectangles, &rfb.FramebufferUpdateRect{
+			X: rect.X, Y: rect.Y, Width: rect.Width, Height: rect.Height,
+			Encoding: s.encoder(encodingType, encodingTypes), Image: framebuffer.SubImage(bounds),
+		})
+	}
+	return update
+}
+
+// encoder returns the viewer's encoder for encodingType, or Raw if the proxy can't encode it.
+func (s *session) encoder(encodingType int32, encodingTypes []int32) rfb.Encoding {
+	if !contains(encodingTypes, encodingType) {
+		encodingType = rfb.EncodingTypeRaw
+	}
+	if e, ok := s.encoders[encodingType]; ok {
+		return e
+	}
+	e, ok := rfb.NewEncoding(encodingType)
+	if !ok {
+		return s.encoder(rfb.EncodingTypeRaw, encodingTypes)
+	}
+	if configurable, ok := e.(rfb.Configurable); ok {
+		configurable.SetOptions(rfb.ParseEncodingOptions(encodingTypes))
+	}
+	s.encoders[encodingType] = e
+	return e
+}
+
+func contains(encodingTypes []int32, encodingType int32) bool {
+	for _, t := range encodingTypes {
+		if t == encodingType {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,125 @@
+package proxy
+
+import (
+	"github.com/alltom/vncrps/rfb"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"net"
+	"sync"
+	"testing"
+)
+
+type fillHandler struct {
+	color color.Color
+	keys  chan uint32
+}
+
+func (h *fillHandler) Resize(width, height int) {}
+
+func (h *fillHandler) Render(img draw.Image, rect image.Rectangle) {
+	draw.Draw(img, rect, image.NewUniform(h.color), image.ZP, draw.Src)
+}
+
+func (h *fillHandler) KeyEvent(m *rfb.KeyEventMessage)         { h.keys <- m.KeySym }
+func (h *fillHandler) PointerEvent(m *rfb.PointerEventMessage) {}
+func (h *fillHandler) CutText(text string)                     {}
+
+// startProxy serves a red framebuffer behind a proxy and returns a viewer connection to the proxy.
+func startProxy(t *testing.T, p *Proxy) (viewer net.Conn, keys chan uint32) {
+	keys = make(chan uint32, 1)
+	server := &rfb.Server{
+		Name: "test", Width: 4, Height: 3,
+		NewHandler: func(conn io.ReadWriter) (rfb.Handler, error) {
+			return &fillHandler{color: color.RGBA{0xff, 0, 0, 0xff}, keys: keys}, nil
+		},
+	}
+	p.Dial = func() (net.Conn, error) {
+		serverConn, upstreamConn := net.Pipe()
+		go server.ServeConn(serverConn)
+		return upstreamConn, nil
+	}
+	proxyConn, viewer := net.Pipe()
+	go func() {
+		p.ServeConn(proxyConn)
+		proxyConn.Close()
+	}()
+	t.Cleanup(func() { viewer.Close() })
+	return viewer, keys
+}
+
+func TestProxy(t *testing.T) {
+	var lock sync.Mutex
+	var fromViewer []rfb.ClientMessage
+	var fromServer []rfb.ServerMessage
+	p := &Proxy{
+		FromViewer: func(conn net.Conn, m rfb.ClientMessage) {
+			lock.Lock()
+			defer lock.Unlock()
+			fromViewer = append(fromViewer, m)
+		},
+		FromServer: func(conn net.Conn, m rfb.ServerMessage) {
+			lock.Lock()
+			defer lock.Unlock()
+			fromServer = append(fromServer, m)
+		},
+	}
+	viewer, keys := startProxy(t, p)
+
+	client, err := rfb.NewClient(viewer, rfb.ClientConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.Name != "test" || client.Framebuffer.Bounds() != image.Rect(0, 0, 4, 3) {
+		t.Errorf("got %q at %v, want the upstream server's name and size", client.Name, client.Framebuffer.Bounds())
+	}
+	if _, err := client.Update(false); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := client.Framebuffer.RGBAAt(3, 2), (color.RGBA{0xff, 0, 0, 0xff}); got != want {
+		t.Errorf("pixel is %v, want %v", got, want)
+	}
+	if err := client.KeyEvent('x', true); err != nil {
+		t.Fatal(err)
+	}
+	if key := <-keys; key != 'x' {
+		t.Errorf("upstream server got key %q, want 'x'", key)
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if len(fromViewer) < 3 {
+		t.Errorf("FromViewer saw %d messages, want SetEncodings, FramebufferUpdateRequest, and KeyEvent", len(fromViewer))
+	}
+	var updates int
+	for _, m := range fromServer {
+		if _, ok := m.(*rfb.FramebufferUpdateMessage); ok {
+			updates++
+		}
+	}
+	if updates != 1 {
+		t.Errorf("FromServer saw %d updates, want 1", updates)
+	}
+}
+
+func TestProxyPixelFormat(t *testing.T) {
+	pixelFormats := []rfb.PixelFormat{
+		{BitsPerPixel: 16, BitDepth: 16, TrueColor: true, RedMax: 31, GreenMax: 63, BlueMax: 31, RedShift: 11, GreenShift: 5},
+		{BitsPerPixel: 8, BitDepth: 8}, // Colour mapped.
+	}
+	for _, pixelFormat := range pixelFormats {
+		pixelFormat := pixelFormat
+		viewer, _ := startProxy(t, &Proxy{})
+		client, err := rfb.NewClient(viewer, rfb.ClientConfig{PixelFormat: &pixelFormat})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.Update(false); err != nil {
+			t.Fatal(err)
+		}
+		if r, g, b, _ := client.Framebuffer.At(0, 0).RGBA(); r < 0xe000 || g > 0x2000 || b > 0x2000 {
+			t.Errorf("%+v: pixel is %v, want red", pixelFormat, client.Framebuffer.At(0, 0))
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package rfb
+
+import (
+	"time"
+)
+
+// ChangeNotifier is implemented by Handlers that know when their frame changes, so an incremental update request that
+// finds nothing new waits until there is something, and clients watching the same thing see it change together. While
+// such a request waits, Changed is called and the channel it returns should be closed, or sent to, once the frame may
+// look different than it did at the last Render. Input from the client is taken to change the frame too.
+type ChangeNotifier interface {
+	Changed() <-chan struct{}
+}
+
+// How often a waiting update request is checked anyway, in case a ChangeNotifier missed a change.
+const waitingRecheckInterval = time.Second
+
+// waitingUpdate is an incremental update request that found nothing to send and waits for something to change.
+type waitingUpdate struct {
+	request *FramebufferUpdateRequestMessage // Nil if none is waiting.
+	changed <-chan struct{}                  // From ChangeNotifier.Changed when the request started waiting.
+	retry   func() error                     // Tries to answer request again, leaving it waiting if nothing changed.
+	writer  *updateWriter                    // A request also waits while the last update is being sent.
+}
+
+// serveMessages is like Conn.Serve, except that a waiting update request is retried as soon as the Handler's frame may
+// have changed, the client has sent input, or the server starts closing, rather than waiting for the client's next
+// message. Messages are still read and handled one at a time in this goroutine; another only waits for them to arrive,
+// and waiting.writer sends updates, so input is handled while an update is paced or on its way to a slow client.
+func serveMessages(c *Conn, hooks Hooks, waiting *waitingUpdate, closing <-chan struct{}) error {
+	defer waiting.writer.stop()
+	arrived := make(chan error, 1)
+	next := make(chan bool)
+	defer close(next)
+	go func() {
+		for {
+			arrived <- c.awaitMessage()
+			if _, ok := <-next; !ok {
+				return
+			}
+		}
+	}()
+
+	for {
+		var changed, closed <-chan struct{}
+		var recheck *time.Timer
+		var rechecked <-chan time.Time
+		var written <-chan error
+		if waiting.writer.busy {
+			written = waiting.writer.done
+		} else if waiting.request != nil {
+			changed, closed = waiting.changed, closing
+			recheck = time.NewTimer(waitingRecheckInterval)
+			rechecked = recheck.C
+		}
+
+		var err error
+		select {
+		case err = <-arrived:
+			if err == nil {
+				err = serveMessage(c, hooks, waiting)
+			}
+			if err == nil {
+				next <- true
+			}
+		case err = <-written:
+			if err = waiting.writer.sent(err); err == nil && waiting.request != nil {
+				err = waiting.retry()
+			}
+		case <-changed:
+			err = waiting.retry()
+		case <-rechecked:
+			err = waiting.retry()
+		case <-closed:
+			err = waiting.retry()
+		}
+		if recheck != nil {
+			recheck.Stop()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// serveMessage reads and handles a message that has started arriving. Any message but an update request, which
+// replaces a waiting one, retries the waiting request, since input may have changed what the client should see.
+func serveMessage(c *Conn, hooks Hooks, waiting *waitingUpdate) error {
+	message, err := c.ReadMessage()
+	if err != nil {
+		return err
+	}
+	if err := hooks.dispatch(message); err != nil {
+		return err
+	}
+	if _, ok := message.(*FramebufferUpdateRequestMessage); !ok && waiting.request != nil {
+		return waiting.retry()
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package rfb
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// changingHandler fills the framebuffer with a color that can be changed from another goroutine, saying when it has.
+type changingHandler struct {
+	fillHandler
+	colors  chan color.Color
+	changed chan struct{}
+}
+
+func (h *changingHandler) Render(img draw.Image, rect image.Rectangle) {
+	select {
+	case h.color = <-h.colors:
+	default:
+	}
+	h.fillHandler.Render(img, rect)
+}
+
+func (h *changingHandler) Changed() <-chan struct{} {
+	return h.changed
+}
+
+func TestServerPushesChanges(t *testing.T) {
+	handler := &changingHandler{
+		fillHandler: fillHandler{color: color.White, keys: make(chan uint32, 1)},
+		colors:      make(chan color.Color, 1),
+		changed:     make(chan struct{}, 1),
+	}
+	server := &Server{
+		Width: 4, Height: 3,
+		NewHandler: func(conn io.ReadWriter) (Handler, error) { return handler, nil },
+	}
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	go func() {
+		server.ServeConn(serverConn)
+		serverConn.Close()
+	}()
+	clientConn.SetDeadline(time.Now().Add(5 * time.Second))
+	client, err := NewClient(clientConn, ClientConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Update(false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing has changed, so the request waits for something to.
+	if err := client.RequestUpdate(true, client.Framebuffer.Bounds()); err != nil {
+		t.Fatal(err)
+	}
+	updates := make(chan *FramebufferUpdateMessage, 1)
+	go func() {
+		m, err := client.ReadMessage()
+		if err != nil {
+			t.Error(err)
+		}
+		update, _ := m.(*FramebufferUpdateMessage)
+		updates <- update
+	}()
+	select {
+	case <-updates:
+		t.Fatal("incremental update was answered before anything changed")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	handler.colors <- color.Black
+	handler.changed <- struct{}{}
+	select {
+	case update := <-updates:
+		if update == nil || len(update.Rectangles) == 0 {
+			t.Errorf("got update %v after the frame changed, want the change", update)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("incremental update wasn't sent after the frame changed")
+	}
+}
@@ -0,0 +1,55 @@
+package rfb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+func init() {
+	RegisterPseudoEncoding(EncodingTypeQEMUExtendedKeyEvent, "QEMUExtendedKeyEvent")
+	RegisterClientMessage(255, func() ClientMessage { return &QEMUExtendedKeyEventMessage{} })
+}
+
+// QEMU client messages share type 255 and are told apart by a subtype. Only key events are supported.
+const qemuSubtypeExtendedKeyEvent = 0
+
+// QEMUExtendedKeyEventMessage is a key event that carries the key's position on the keyboard as well as its keysym, so
+// servers can recognize keys whatever layout the client has. Clients only send it once the server has acknowledged the
+// QEMUExtendedKeyEvent pseudo-encoding with an empty rectangle of that type. Server passes it to the Handler as a
+// KeyEventMessage with KeyCode set.
+type QEMUExtendedKeyEventMessage struct {
+	Pressed bool
+	KeySym  uint32 // May be 0 if the client doesn't know it.
+	KeyCode uint32 // An XT scan code. See KeyEventMessage.KeyCode.
+}
+
+func (m *QEMUExtendedKeyEventMessage) Read(r io.Reader, bo binary.ByteOrder) error {
+	buf := readBuffer(r, 12)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return err
+	}
+	if buf[0] != 255 {
+		return fmt.Errorf("expected message type 255, but found %d", buf[0])
+	}
+	if buf[1] != qemuSubtypeExtendedKeyEvent {
+		return fmt.Errorf("unsupported QEMU message subtype %d", buf[1])
+	}
+	m.Pressed = bo.Uint16(buf[2:]) != 0
+	m.KeySym = bo.Uint32(buf[4:])
+	m.KeyCode = bo.Uint32(buf[8:])
+	return nil
+}
+
+func (m *QEMUExtendedKeyEventMessage) Write(w io.Writer, bo binary.ByteOrder) error {
+	buf := writeBuffer(w, 12)
+	buf[0] = 255
+	buf[1] = qemuSubtypeExtendedKeyEvent
+	if m.Pressed {
+		bo.PutUint16(buf[2:], 1)
+	}
+	bo.PutUint32(buf[4:], m.KeySym)
+	bo.PutUint32(buf[8:], m.KeyCode)
+	_, err := w.Write(buf)
+	return err
+}
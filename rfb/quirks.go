@@ -0,0 +1,76 @@
+package rfb
+
+import (
+	"strings"
+)
+
+// Quirks is a set of workarounds for viewer bugs, applied per connection.
+type Quirks uint32
+
+const (
+	// The client claims RFB 3.8 but misreads the reason string after a failed security result, so it's omitted.
+	QuirkNoFailureReason Quirks = 1 << iota
+
+	// The client mishandles FramebufferUpdate messages without rectangles, so empty updates carry one zero-sized Raw
+	// rectangle instead.
+	QuirkNonEmptyUpdates
+)
+
+var quirkNames = []string{"NoFailureReason", "NonEmptyUpdates"}
+
+func (q Quirks) String() string {
+	var names []string
+	for i, name := range quirkNames {
+		if q&(1<<uint(i)) != 0 {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, "+")
+}
+
+// QuirkRule decides which clients need which workarounds.
+type QuirkRule struct {
+	Quirks Quirks
+
+	// Logged when the rule first matches a connection.
+	Description string
+
+	// Reports whether the client needs the workarounds. It's called once the client's protocol version is known, and
+	// again after each SetEncodings message, so it can consider c.Version and c.EncodingTypes.
+	Match func(c *Conn) bool
+}
+
+// DefaultQuirkRules are the rules a Server uses unless it's given its own.
+var DefaultQuirkRules = []QuirkRule{
+	{
+		Quirks:      QuirkNoFailureReason,
+		Description: "unofficial minor version above 3.8",
+		Match: func(c *Conn) bool {
+			// macOS Screen Sharing's 3.889 is the only unofficial version known to follow 3.8 faithfully.
+			return c.Version.Major == 3 && c.Version.Minor > 8 && c.Version.Minor != 889
+		},
+	},
+	{
+		Quirks:      QuirkNonEmptyUpdates,
+		Description: "RFB 3.3 viewer",
+		Match: func(c *Conn) bool {
+			// Many 3.3-era viewers wait for a rectangle after every FramebufferUpdate header.
+			return !c.Version.AtLeast(3, 7)
+		},
+	},
+}
+
+// applyQuirkRules adds the quirks of every matching rule to c.Quirks, and returns the rules that added any.
+func applyQuirkRules(c *Conn, rules []QuirkRule) []QuirkRule {
+	var fired []QuirkRule
+	for _, rule := range rules {
+		if c.Quirks&rule.Quirks != rule.Quirks && rule.Match(c) {
+			c.Quirks |= rule.Quirks
+			fired = append(fired, rule)
+		}
+	}
+	return fired
+}
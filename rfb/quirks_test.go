@@ -0,0 +1,38 @@
+package rfb
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestQuirkRules(t *testing.T) {
+	for _, test := range []struct {
+		version ProtocolVersionMessage
+		want    Quirks
+	}{
+		{ProtocolVersionMessage{3, 3}, QuirkNonEmptyUpdates},
+		{ProtocolVersionMessage{3, 8}, 0},
+		{ProtocolVersionMessage{3, 889}, 0},
+		{ProtocolVersionMessage{3, 14}, QuirkNoFailureReason},
+	} {
+		c := NewConn(&bytes.Buffer{}, DefaultPixelFormat)
+		c.Version = test.version
+		applyQuirkRules(c, DefaultQuirkRules)
+		if c.Quirks != test.want {
+			t.Errorf("RFB %d.%d got quirks %v, want %v", test.version.Major, test.version.Minor, c.Quirks, test.want)
+		}
+	}
+}
+
+func TestQuirkNonEmptyUpdates(t *testing.T) {
+	var out bytes.Buffer
+	c := NewConn(&readWriter{&bytes.Buffer{}, &out}, DefaultPixelFormat)
+	c.Quirks = QuirkNonEmptyUpdates
+	if err := c.WriteMessage(&FramebufferUpdateMessage{}); err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	if !bytes.Equal(out.Bytes(), want) {
+		t.Errorf("wrote %v, want %v", out.Bytes(), want)
+	}
+}
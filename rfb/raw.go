@@ -0,0 +1,93 @@
+package rfb
+
+import (
+	"image"
+	"image/color"
+	"io"
+)
+
+func init() {
+	RegisterEncoding(EncodingTypeRaw, func() Encoding { return &RawEncoder{} })
+}
+
+// RawEncoder sends pixels uncompressed, row by row. Every client supports it.
+type RawEncoder struct {
+	buf []byte
+}
+
+func (e *RawEncoder) Type() int32 {
+	return EncodingTypeRaw
+}
+
+func (e *RawEncoder) Encode(w io.Writer, pixelFormat PixelFormat, img image.Image, rect image.Rectangle) error {
+	e.buf = appendPixels(e.buf[:0], &pixelFormat, img, rect)
+	_, err := w.Write(e.buf)
+	return err
+}
+
+// Pixel returns the pixel value that best represents c. For formats that aren't true color, it's an index into the
+// colour map the server sends.
+func (pf *PixelFormat) Pixel(c color.Color) uint32 {
+	r, g, b, _ := c.RGBA()
+	return pf.pixel(r, g, b)
+}
+
+// r, g, and b are 16-bit.
+func (pf *PixelFormat) pixel(r, g, b uint32) uint32 {
+	if !pf.TrueColor {
+		return colourMapFormat.pixel(r, g, b)
+	}
+	return scaleComponent(r, pf.RedMax)<<pf.RedShift |
+		scaleComponent(g, pf.GreenMax)<<pf.GreenShift |
+		scaleComponent(b, pf.BlueMax)<<pf.BlueShift
+}
+
+// Scales a 16-bit color component to 0..max, rounding to nearest.
+func scaleComponent(v uint32, max uint16) uint32 {
+	return (v*uint32(max) + 0x7fff) / 0xffff
+}
+
+// appendPixel appends a pixel value using the format's size and byte order.
+func (pf *PixelFormat) appendPixel(buf []byte, pixel uint32) []byte {
+	switch pf.BitsPerPixel {
+	case 8:
+		return append(buf, uint8(pixel))
+	case 16:
+		if pf.BigEndian {
+			return append(buf, uint8(pixel>>8), uint8(pixel))
+		}
+		return append(buf, uint8(pixel), uint8(pixel>>8))
+	default:
+		if pf.BigEndian {
+			return append(buf, uint8(pixel>>24), uint8(pixel>>16), uint8(pixel>>8), uint8(pixel))
+		}
+		return append(buf, uint8(pixel), uint8(pixel>>8), uint8(pixel>>16), uint8(pixel>>24))
+	}
+}
+
+// appendPixels appends the rect portion of img in raw order: left to right, top to bottom.
+func appendPixels(buf []byte, pf *PixelFormat, img image.Image, rect image.Rectangle) []byte {
+	if rgba, ok := img.(*image.RGBA); ok {
+		return translatorFor(pf).AppendPixels(buf, rgba, rect)
+	}
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			buf = pf.appendPixel(buf, pf.Pixel(img.At(x, y)))
+		}
+	}
+	return buf
+}
+
+// pixelValues appends the rect portion of img's pixel values in raw order, for encodings that analyze them before
+// writing.
+func pixelValues(values []uint32, pf *PixelFormat, img image.Image, rect image.Rectangle) []uint32 {
+	if rgba, ok := img.(*image.RGBA); ok {
+		return translatorFor(pf).PixelValues(values, rgba, rect)
+	}
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			values = append(values, pf.Pixel(img.At(x, y)))
+		}
+	}
+	return values
+}
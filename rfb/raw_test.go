@@ -0,0 +1,57 @@
+package rfb
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestRawEncoderSubRectangle(t *testing.T) {
+	rgb565 := PixelFormat{
+		BitsPerPixel: 16, BitDepth: 16, TrueColor: true,
+		RedMax: 31, GreenMax: 63, BlueMax: 31, RedShift: 11, GreenShift: 5,
+	}
+
+	rgba := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	nrgba := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			c := color.RGBA{uint8(x * 80), uint8(y * 80), 0xff, 0xff}
+			rgba.Set(x, y, c)
+			nrgba.Set(x, y, c)
+		}
+	}
+	rect := image.Rect(1, 2, 4, 4)
+
+	for _, pixelFormat := range []PixelFormat{DefaultPixelFormat, rgb565} {
+		for _, img := range []image.Image{rgba, nrgba} {
+			var buf bytes.Buffer
+			if err := (&RawEncoder{}).Encode(&buf, pixelFormat, img, rect); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := buf.Len(), rect.Dx()*rect.Dy()*int(pixelFormat.BitsPerPixel)/8; got != want {
+				t.Fatalf("%d bpp %T: wrote %d bytes, want %d", pixelFormat.BitsPerPixel, img, got, want)
+			}
+			decoded := &PixelFormatImage{Pix: buf.Bytes(), Rect: rect, PixelFormat: pixelFormat}
+			for y := rect.Min.Y; y < rect.Max.Y; y++ {
+				for x := rect.Min.X; x < rect.Max.X; x++ {
+					want := pixelFormat.Pixel(img.At(x, y))
+					if got := decoded.At(x, y).(PixelFormatColor).Pixel; got != want {
+						t.Errorf("%d bpp %T: pixel (%d, %d) is %x, want %x", pixelFormat.BitsPerPixel, img, x, y, got, want)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestPixelScaling(t *testing.T) {
+	pf := PixelFormat{BitsPerPixel: 8, BitDepth: 8, TrueColor: true, RedMax: 7, GreenMax: 7, BlueMax: 3, GreenShift: 3, BlueShift: 6}
+	if got := pf.Pixel(color.White); got != 0xff {
+		t.Errorf("white is %x, want ff", got)
+	}
+	if got := pf.Pixel(color.RGBA{0xff, 0, 0, 0xff}); got != 0x07 {
+		t.Errorf("red is %x, want 07", got)
+	}
+}
@@ -15,7 +15,18 @@ See the RFCs for details, but the initial handshake goes like this:
 	client sends ClientInitialisationMessage
 	server sends ServerInitialisationMessage
 
-Thereafter, client and server enter message processing loops. The first byte identifies the message type, which dictates the length of the payload, so all clients and servers must process all event types.
+RFB 3.7 and 3.8 let the client choose the security type instead:
+
+	server sends SecurityTypesMessageRFB37
+	client sends SecurityTypeSelectionMessageRFB37
+		If SecurityTypeVNC:
+			server sends VNCAuthenticationChallengeMessage
+			client sends VNCAuthenticationResponseMessage
+		If SecurityTypeTight:
+			see TightSecurityHandshake
+	server sends SecurityResultMessageRFB38 (3.8), or VNCAuthenticationResultMessage (3.7, VNC authentication only)
+
+Thereafter, client and server enter message processing loops. The first byte identifies the message type, which dictates the length of the payload, so all clients and servers must process all event types. ReadClientMessage reads any client message, including extension messages added with RegisterClientMessage. Conn wraps a server's connection to read and write whole messages, and Conn.Serve dispatches each client message to a hook. Client implements the viewer side.
 
 Clients may send:
 
@@ -30,7 +41,7 @@ Clients may send:
 Servers may send:
 
 	Type 0	FramebufferUpdate
-	Type 1	SetColourMapEntries — uncommon, not implemented by this library
+	Type 1	SetColourMapEntriesMessage
 	Type 2	BellMessage
 	Type 3	ServerCutTextMessage
 */
@@ -40,7 +51,9 @@ import (
 	"encoding/binary"
 	"fmt"
 	"golang.org/x/text/encoding/charmap"
+	"image"
 	"io"
+	"io/ioutil"
 )
 
 type ProtocolVersionMessage struct {
@@ -69,6 +82,11 @@ func (m *ProtocolVersionMessage) Write(w io.Writer) error {
 	return nil
 }
 
+// AtLeast returns whether the version is major.minor or later. Use it to decide which variant of a message to send.
+func (m *ProtocolVersionMessage) AtLeast(major, minor int) bool {
+	return m.Major > major || (m.Major == major && m.Minor >= minor)
+}
+
 type AuthenticationSchemeMessageRFB33 struct {
 	Scheme AuthenticationScheme
 }
@@ -99,6 +117,146 @@ func (m *AuthenticationSchemeMessageRFB33) Write(w io.Writer, bo binary.ByteOrde
 	return nil
 }
 
+// SecurityTypesMessageRFB37 lists the security types the server supports. It replaces AuthenticationSchemeMessageRFB33
+// in RFB 3.7 and later.
+type SecurityTypesMessageRFB37 struct {
+	Types []SecurityType
+}
+
+type SecurityType uint8
+
+const (
+	SecurityTypeInvalid = SecurityType(0)
+	SecurityTypeNone    = SecurityType(1)
+	SecurityTypeVNC     = SecurityType(2)
+	SecurityTypeTight   = SecurityType(16)
+)
+
+func (m *SecurityTypesMessageRFB37) Read(r io.Reader, bo binary.ByteOrder) error {
+	var buf [255]byte
+	if _, err := io.ReadFull(r, buf[:1]); err != nil {
+		return err
+	}
+	count := int(buf[0])
+	if count == 0 {
+		var reason ReasonMessage
+		if err := reason.Read(r, bo); err != nil {
+			return fmt.Errorf("read failure reason: %v", err)
+		}
+		return fmt.Errorf("server sent no security types: %s", reason.Reason)
+	}
+	if _, err := io.ReadFull(r, buf[:count]); err != nil {
+		return err
+	}
+	m.Types = nil
+	for _, t := range buf[:count] {
+		m.Types = append(m.Types, SecurityType(t))
+	}
+	return nil
+}
+
+func (m *SecurityTypesMessageRFB37) Write(w io.Writer) error {
+	if len(m.Types) == 0 || len(m.Types) > 255 {
+		return fmt.Errorf("must send 1-255 security types, but have %d", len(m.Types))
+	}
+	buf := []byte{uint8(len(m.Types))}
+	for _, t := range m.Types {
+		buf = append(buf, uint8(t))
+	}
+	if _, err := w.Write(buf); err != nil {
+		return err
+	}
+	return nil
+}
+
+// SecurityTypeSelectionMessageRFB37 is the client's choice from SecurityTypesMessageRFB37.
+type SecurityTypeSelectionMessageRFB37 struct {
+	Type SecurityType
+}
+
+func (m *SecurityTypeSelectionMessageRFB37) Read(r io.Reader) error {
+	var buf [1]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return err
+	}
+	m.Type = SecurityType(buf[0])
+	return nil
+}
+
+func (m *SecurityTypeSelectionMessageRFB37) Write(w io.Writer) error {
+	_, err := w.Write([]byte{uint8(m.Type)})
+	return err
+}
+
+// SecurityResultMessageRFB38 ends the security handshake for every security type in RFB 3.8.
+// In RFB 3.3 and 3.7, use VNCAuthenticationResultMessage, which has no reason and is only sent after VNC authentication.
+type SecurityResultMessageRFB38 struct {
+	Result VNCAuthenticationResult
+	Reason string // Only sent if Result is not VNCAuthenticationResultOK.
+}
+
+func (m *SecurityResultMessageRFB38) Read(r io.Reader, bo binary.ByteOrder) error {
+	var buf [4]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return err
+	}
+	m.Result = VNCAuthenticationResult(bo.Uint32(buf[:]))
+	m.Reason = ""
+	if m.Result != VNCAuthenticationResultOK {
+		var reason ReasonMessage
+		if err := reason.Read(r, bo); err != nil {
+			return fmt.Errorf("read failure reason: %v", err)
+		}
+		m.Reason = reason.Reason
+	}
+	return nil
+}
+
+func (m *SecurityResultMessageRFB38) Write(w io.Writer, bo binary.ByteOrder) error {
+	var buf [4]byte
+	bo.PutUint32(buf[:], uint32(m.Result))
+	if _, err := w.Write(buf[:]); err != nil {
+		return err
+	}
+	if m.Result != VNCAuthenticationResultOK {
+		reason := ReasonMessage{m.Reason}
+		if err := reason.Write(w, bo); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReasonMessage is a length-prefixed string explaining why the server is closing the connection.
+type ReasonMessage struct {
+	Reason string
+}
+
+func (m *ReasonMessage) Read(r io.Reader, bo binary.ByteOrder) error {
+	var buf [4]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return err
+	}
+	reason, err := readTruncated(r, bo.Uint32(buf[:]), maxStringLength)
+	if err != nil {
+		return err
+	}
+	m.Reason = string(reason)
+	return nil
+}
+
+func (m *ReasonMessage) Write(w io.Writer, bo binary.ByteOrder) error {
+	var buf [4]byte
+	bo.PutUint32(buf[:], uint32(len(m.Reason)))
+	if _, err := w.Write(buf[:]); err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte(m.Reason)); err != nil {
+		return err
+	}
+	return nil
+}
+
 type VNCAuthenticationChallengeMessage [16]byte
 
 func (m *VNCAuthenticationChallengeMessage) Read(r io.Reader) error {
@@ -185,21 +343,18 @@ type ServerInitialisationMessage struct {
 }
 
 func (m *ServerInitialisationMessage) Read(r io.Reader, bo binary.ByteOrder) error {
-	var buf [255]byte
-	if _, err := io.ReadFull(r, buf[:24]); err != nil {
+	var buf [24]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
 		return err
 	}
 	m.FramebufferWidth = bo.Uint16(buf[0:])
 	m.FramebufferHeight = bo.Uint16(buf[2:])
 	m.PixelFormat.Read(buf[4:], bo)
-	nameLength := bo.Uint32(buf[20:])
-	if int(nameLength) > len(buf) {
-		return fmt.Errorf("name is too long: %d > %d", nameLength, len(buf))
-	}
-	if _, err := io.ReadFull(r, buf[:nameLength]); err != nil {
+	name, err := readTruncated(r, bo.Uint32(buf[20:]), maxStringLength)
+	if err != nil {
 		return err
 	}
-	m.Name = string(buf[:nameLength])
+	m.Name = string(name)
 	return nil
 }
 
@@ -223,74 +378,117 @@ type SetPixelFormatMessage struct {
 }
 
 func (m *SetPixelFormatMessage) Read(r io.Reader, bo binary.ByteOrder) error {
-	var buf [20]byte
-	if _, err := io.ReadFull(r, buf[:]); err != nil {
+	buf := readBuffer(r, 20)
+	if _, err := io.ReadFull(r, buf); err != nil {
 		return err
 	}
 	if buf[0] != 0 {
 		return fmt.Errorf("expected message type 0, but found %d", buf[0])
 	}
 	m.PixelFormat.Read(buf[4:], bo)
-	return nil
+	return m.PixelFormat.validate()
 }
 
 func (m *SetPixelFormatMessage) Write(w io.Writer, bo binary.ByteOrder) error {
-	var buf [16]byte
+	buf := writeBuffer(w, 20)
 	m.PixelFormat.Write(buf[4:], bo)
-	if _, err := w.Write(buf[:]); err != nil {
+	if _, err := w.Write(buf); err != nil {
 		return err
 	}
 	return nil
 }
 
 type SetEncodingsMessage struct {
-	EncodingTypes []uint32
+	// In order of the client's preference. May include pseudo-encodings and encodings this package doesn't know about.
+	EncodingTypes []int32
 }
 
+// Encoding types are signed. Negative values are generally pseudo-encodings, which the client uses to advertise support
+// for protocol extensions rather than pixel encodings.
 const (
-	EncodingTypeRaw           = uint32(0)
-	EncodingTypeCopyRectangle = uint32(1)
-	EncodingTypeRRE           = uint32(2)
-	EncodingTypeCoRRE         = uint32(4)
-	EncodingTypeHextile       = uint32(5)
+	EncodingTypeRaw           = int32(0)
+	EncodingTypeCopyRectangle = int32(1)
+	EncodingTypeRRE           = int32(2)
+	EncodingTypeCoRRE         = int32(4)
+	EncodingTypeHextile       = int32(5)
+	EncodingTypeZlib          = int32(6)
+	EncodingTypeTight         = int32(7)
+	EncodingTypeTRLE          = int32(15)
+	EncodingTypeZRLE          = int32(16)
+
+	// A variant of Tight for web clients, which replaces zlib-compressed data with PNG. Despite its number, it's a pixel
+	// encoding.
+	EncodingTypeTightPNG = int32(-260)
+
+	// A pseudo-encoding for changes to the framebuffer's size. See DesktopSizer.
+	EncodingTypeDesktopSize = int32(-223)
+
+	// A pseudo-encoding for changes to the framebuffer's size and screen layout, which clients can ask for with
+	// SetDesktopSizeMessage.
+	EncodingTypeExtendedDesktopSize = int32(-308)
+
+	// A pseudo-encoding for pointer shapes the client draws itself. See CursorSource.
+	EncodingTypeCursor = int32(-239)
+
+	// A pseudo-encoding for two-color pointer shapes, for clients that don't support EncodingTypeCursor.
+	EncodingTypeXCursor = int32(-240)
+
+	// A pseudo-encoding for pointer shapes with partial transparency.
+	EncodingTypeCursorWithAlpha = int32(-314)
+
+	// A pseudo-encoding for clients that support UTF-8 clipboard text. See ExtendedClipboard.
+	EncodingTypeExtendedClipboard = int32(-1063131698) // 0xc0a1e5ce
+
+	// A pseudo-encoding for clients that can send QEMUExtendedKeyEventMessage. The server acknowledges it with an empty
+	// rectangle of this type.
+	EncodingTypeQEMUExtendedKeyEvent = int32(-258)
+
+	// Pseudo-encodings for the JPEG quality a client accepts, from level 0 (smallest) to level 9 (best).
+	EncodingTypeJPEGQualityLevel0 = int32(-32)
+	EncodingTypeJPEGQualityLevel9 = int32(-23)
+
+	// Pseudo-encodings for how hard a client would like the server to compress, from level 0 (fastest) to level 9
+	// (smallest).
+	EncodingTypeCompressionLevel0 = int32(-256)
+	EncodingTypeCompressionLevel9 = int32(-247)
 )
 
 func (m *SetEncodingsMessage) Read(r io.Reader, bo binary.ByteOrder) error {
-	var buf [255]byte
-	if _, err := io.ReadFull(r, buf[:4]); err != nil {
+	buf := readBuffer(r, 4)
+	if _, err := io.ReadFull(r, buf); err != nil {
 		return err
 	}
 	if buf[0] != 2 {
 		return fmt.Errorf("expected message type 2, but found %d", buf[0])
 	}
-	encodingCount := bo.Uint16(buf[2:])
-	if int(encodingCount) > len(buf)/4 {
-		return fmt.Errorf("too many encodings: %d > %d", encodingCount, len(buf)/4)
+	encodingCount := int(bo.Uint16(buf[2:]))
+	if limit := readLimits(r).Encodings; encodingCount > limit {
+		return &TooLongError{What: "encoding types", Length: uint64(encodingCount), Limit: uint64(limit)}
 	}
-	if _, err := io.ReadFull(r, buf[:encodingCount*4]); err != nil {
+	encodings := make([]byte, encodingCount*4)
+	if _, err := io.ReadFull(r, encodings); err != nil {
 		return err
 	}
 	m.EncodingTypes = nil
-	for i := uint16(0); i < encodingCount; i++ {
-		m.EncodingTypes = append(m.EncodingTypes, bo.Uint32(buf[i*4:]))
+	for i := 0; i < encodingCount; i++ {
+		m.EncodingTypes = append(m.EncodingTypes, int32(bo.Uint32(encodings[i*4:])))
 	}
 	return nil
 }
 
 func (m *SetEncodingsMessage) Write(w io.Writer, bo binary.ByteOrder) error {
-	var buf [255]byte
-
-	maxCount := len(buf[4:]) / 4
+	maxCount := int(^uint16(0))
 	if len(m.EncodingTypes) > maxCount {
 		return fmt.Errorf("too many encoding types: %d > %d", len(m.EncodingTypes), maxCount)
 	}
 
+	buf := make([]byte, 4+4*len(m.EncodingTypes))
 	buf[0] = 2
 	bo.PutUint16(buf[2:], uint16(len(m.EncodingTypes)))
 	for idx, encodingType := range m.EncodingTypes {
-		bo.PutUint32(buf[4+idx*4:], encodingType)
+		bo.PutUint32(buf[4+idx*4:], uint32(encodingType))
 	}
-	if _, err := w.Write(buf[:4+4*len(m.EncodingTypes)]); err != nil {
+	if _, err := w.Write(buf); err != nil {
 		return err
 	}
 	return nil
@@ -308,8 +506,8 @@ type FramebufferUpdateRequestMessage struct {
 }
 
 func (m *FramebufferUpdateRequestMessage) Read(r io.Reader, bo binary.ByteOrder) error {
-	var buf [10]byte
-	if _, err := io.ReadFull(r, buf[:]); err != nil {
+	buf := readBuffer(r, 10)
+	if _, err := io.ReadFull(r, buf); err != nil {
 		return err
 	}
 	if buf[0] != 3 {
@@ -326,7 +524,7 @@ func (m *FramebufferUpdateRequestMessage) Read(r io.Reader, bo binary.ByteOrder)
 }
 
 func (m *FramebufferUpdateRequestMessage) Write(w io.Writer, bo binary.ByteOrder) error {
-	var buf [10]byte
+	buf := writeBuffer(w, 10)
 	buf[0] = 3 // Message type
 	if m.Incremental {
 		buf[1] = 1
@@ -337,7 +535,7 @@ func (m *FramebufferUpdateRequestMessage) Write(w io.Writer, bo binary.ByteOrder
 	bo.PutUint16(buf[4:], m.Y)
 	bo.PutUint16(buf[6:], m.Width)
 	bo.PutUint16(buf[8:], m.Height)
-	if _, err := w.Write(buf[:]); err != nil {
+	if _, err := w.Write(buf); err != nil {
 		return err
 	}
 	return nil
@@ -346,11 +544,16 @@ func (m *FramebufferUpdateRequestMessage) Write(w io.Writer, bo binary.ByteOrder
 type KeyEventMessage struct {
 	Pressed bool
 	KeySym  uint32 // Defined in Xlib Reference Manual and <X11/keysymdef.h>
+
+	// The XT scan code of the key, which names its position rather than its symbol, or 0 if unknown. Only set for keys
+	// that arrived as QEMUExtendedKeyEventMessage; it isn't part of KeyEvent messages, so Write ignores it. Extended keys
+	// have their 0xe0 prefix folded into the high bit, so right Control is 0x9d.
+	KeyCode uint32
 }
 
 func (m *KeyEventMessage) Read(r io.Reader, bo binary.ByteOrder) error {
-	var buf [8]byte
-	if _, err := io.ReadFull(r, buf[:]); err != nil {
+	buf := readBuffer(r, 8)
+	if _, err := io.ReadFull(r, buf); err != nil {
 		return err
 	}
 	if buf[0] != 4 {
@@ -362,27 +565,63 @@ func (m *KeyEventMessage) Read(r io.Reader, bo binary.ByteOrder) error {
 }
 
 func (m *KeyEventMessage) Write(w io.Writer, bo binary.ByteOrder) error {
-	var buf [8]byte
+	buf := writeBuffer(w, 8)
 	buf[0] = 4
 	if m.Pressed {
 		buf[1] = 1
 	}
 	bo.PutUint32(buf[4:], m.KeySym)
-	if _, err := w.Write(buf[:]); err != nil {
+	if _, err := w.Write(buf); err != nil {
 		return err
 	}
 	return nil
 }
 
 type PointerEventMessage struct {
-	ButtonMask uint8
+	ButtonMask uint8 // Buttons held down, as Button bits.
 	X          uint16
 	Y          uint16
 }
 
+// Bits of PointerEventMessage.ButtonMask. Viewers report each notch of a scroll wheel as a press and release of one of
+// the wheel buttons, which are buttons 4 through 7 to X.
+const (
+	ButtonLeft       = uint8(1 << 0)
+	ButtonMiddle     = uint8(1 << 1)
+	ButtonRight      = uint8(1 << 2)
+	ButtonWheelUp    = uint8(1 << 3)
+	ButtonWheelDown  = uint8(1 << 4)
+	ButtonWheelLeft  = uint8(1 << 5)
+	ButtonWheelRight = uint8(1 << 6)
+)
+
+// Pressed returns the buttons that are down in m but weren't in the previous event's mask.
+func (m *PointerEventMessage) Pressed(previous uint8) uint8 {
+	return m.ButtonMask &^ previous
+}
+
+// ScrollDelta returns the notches the wheel turned since the previous event's mask, counting wheel buttons that were
+// pressed. Positive dx is to the right and positive dy is down, toward the end of a list.
+func (m *PointerEventMessage) ScrollDelta(previous uint8) (dx, dy int) {
+	pressed := m.Pressed(previous)
+	if pressed&ButtonWheelUp != 0 {
+		dy--
+	}
+	if pressed&ButtonWheelDown != 0 {
+		dy++
+	}
+	if pressed&ButtonWheelLeft != 0 {
+		dx--
+	}
+	if pressed&ButtonWheelRight != 0 {
+		dx++
+	}
+	return dx, dy
+}
+
 func (m *PointerEventMessage) Read(r io.Reader, bo binary.ByteOrder) error {
-	var buf [6]byte
-	if _, err := io.ReadFull(r, buf[:]); err != nil {
+	buf := readBuffer(r, 6)
+	if _, err := io.ReadFull(r, buf); err != nil {
 		return err
 	}
 	if buf[0] != 5 {
@@ -395,12 +634,12 @@ func (m *PointerEventMessage) Read(r io.Reader, bo binary.ByteOrder) error {
 }
 
 func (m *PointerEventMessage) Write(w io.Writer, bo binary.ByteOrder) error {
-	var buf [6]byte
+	buf := writeBuffer(w, 6)
 	buf[0] = 5
 	buf[1] = m.ButtonMask
 	bo.PutUint16(buf[2:], m.X)
 	bo.PutUint16(buf[4:], m.Y)
-	if _, err := w.Write(buf[:]); err != nil {
+	if _, err := w.Write(buf); err != nil {
 		return err
 	}
 	return nil
@@ -408,24 +647,30 @@ func (m *PointerEventMessage) Write(w io.Writer, bo binary.ByteOrder) error {
 
 type ClientCutTextMessage struct {
 	Text string
+
+	// Set for messages sent with the Extended Clipboard extension, in which case it's written instead of Text. When
+	// reading a provide, Text is set too.
+	Extended *ExtendedClipboard
 }
 
 func (m *ClientCutTextMessage) Read(r io.Reader, bo binary.ByteOrder) error {
-	var buf [255]byte
-	if _, err := io.ReadFull(r, buf[:8]); err != nil {
+	buf := readBuffer(r, 8)
+	if _, err := io.ReadFull(r, buf); err != nil {
 		return err
 	}
 	if buf[0] != 6 {
 		return fmt.Errorf("expected message type 6, but found %d", buf[0])
 	}
-	textLength := bo.Uint32(buf[4:])
-	if int(textLength) > len(buf) {
-		return fmt.Errorf("text length too long: %d > %d", textLength, len(buf))
-	}
-	if _, err := io.ReadFull(r, buf[:textLength]); err != nil {
+	text, extended, err := readCutText(r, bo, bo.Uint32(buf[4:]))
+	if err != nil {
 		return err
 	}
-	converted, err := charmap.ISO8859_1.NewDecoder().Bytes(buf[:textLength])
+	m.Extended = extended
+	if extended != nil {
+		m.Text = text
+		return nil
+	}
+	converted, err := charmap.ISO8859_1.NewDecoder().Bytes([]byte(text))
 	if err != nil {
 		return fmt.Errorf("couldn't convert text to UTF-8 in ClientCutText: %v", err)
 	}
@@ -434,6 +679,9 @@ func (m *ClientCutTextMessage) Read(r io.Reader, bo binary.ByteOrder) error {
 }
 
 func (m *ClientCutTextMessage) Write(w io.Writer, bo binary.ByteOrder) error {
+	if m.Extended != nil {
+		return m.Extended.write(w, bo, 6)
+	}
 	converted, err := charmap.ISO8859_1.NewEncoder().Bytes([]byte(m.Text))
 	if err != nil {
 		return fmt.Errorf("encode text: %v", err)
@@ -442,10 +690,10 @@ func (m *ClientCutTextMessage) Write(w io.Writer, bo binary.ByteOrder) error {
 		return fmt.Errorf("text too long: %d bytes > %d bytes", len(converted), ^uint32(0))
 	}
 
-	var buf [8]byte
+	buf := writeBuffer(w, 8)
 	buf[0] = 6
 	bo.PutUint32(buf[4:], uint32(len(converted)))
-	if _, err := w.Write(buf[:]); err != nil {
+	if _, err := w.Write(buf); err != nil {
 		return err
 	}
 	if _, err := w.Write(converted); err != nil {
@@ -456,6 +704,13 @@ func (m *ClientCutTextMessage) Write(w io.Writer, bo binary.ByteOrder) error {
 
 type FramebufferUpdateMessage struct {
 	Rectangles []*FramebufferUpdateRect
+
+	// The client's pixel format, which rectangles with an Encoding are encoded in.
+	PixelFormat PixelFormat
+
+	// Needed to read encodings with state that lasts the whole connection, such as Zlib. Use the same Decoders for
+	// every message on a connection.
+	Decoders *Decoders
 }
 
 type FramebufferUpdateRect struct {
@@ -463,13 +718,20 @@ type FramebufferUpdateRect struct {
 	Y            uint16
 	Width        uint16
 	Height       uint16
-	EncodingType uint32 // Unsigned per spec, but often interpreted signed
-	PixelData    []byte
+	EncodingType int32
+
+	// Raw pixels. Read decodes RRE, CoRRE, Hextile, Zlib, Tight, TightPNG, TRLE, and ZRLE rectangles into raw pixels too. Ignored when writing if Encoding is set.
+	PixelData []byte
+
+	// If set, Write ignores EncodingType and PixelData and has Encoding encode this rectangle of Image instead.
+	// Read sets it for CopyRect, cursor, and ExtendedDesktopSize rectangles.
+	Encoding Encoding
+	Image    image.Image
 }
 
 func (m *FramebufferUpdateMessage) Read(r io.Reader, bo binary.ByteOrder, pixelFormat PixelFormat) error {
-	var buf [4]byte
-	if _, err := io.ReadFull(r, buf[:]); err != nil {
+	buf := readBuffer(r, 4)
+	if _, err := io.ReadFull(r, buf); err != nil {
 		return err
 	}
 	if buf[0] != 0 {
@@ -479,7 +741,7 @@ func (m *FramebufferUpdateMessage) Read(r io.Reader, bo binary.ByteOrder, pixelF
 	m.Rectangles = nil
 	for i := uint16(0); i < count; i++ {
 		rect := &FramebufferUpdateRect{}
-		if err := rect.Read(r, bo, pixelFormat); err != nil {
+		if err := rect.read(r, bo, pixelFormat, m.Decoders); err != nil {
 			return err
 		}
 		m.Rectangles = append(m.Rectangles, rect)
@@ -488,33 +750,130 @@ func (m *FramebufferUpdateMessage) Read(r io.Reader, bo binary.ByteOrder, pixelF
 }
 
 func (m *FramebufferUpdateMessage) Write(w io.Writer, bo binary.ByteOrder) error {
-	var buf [4]byte
+	buf := writeBuffer(w, 4)
 	buf[0] = 0
 	bo.PutUint16(buf[2:], uint16(len(m.Rectangles)))
-	if _, err := w.Write(buf[:]); err != nil {
+	if _, err := w.Write(buf); err != nil {
 		return err
 	}
 	for _, rect := range m.Rectangles {
-		if err := rect.Write(w, bo); err != nil {
+		if err := rect.Write(w, bo, m.PixelFormat); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// Read reads a rectangle that doesn't depend on earlier ones. See FramebufferUpdateMessage.Decoders.
 func (rect *FramebufferUpdateRect) Read(r io.Reader, bo binary.ByteOrder, pixelFormat PixelFormat) error {
-	var buf [12]byte
-	if _, err := io.ReadFull(r, buf[:]); err != nil {
+	return rect.read(r, bo, pixelFormat, nil)
+}
+
+func (rect *FramebufferUpdateRect) read(r io.Reader, bo binary.ByteOrder, pixelFormat PixelFormat, decoders *Decoders) error {
+	buf := readBuffer(r, 12)
+	if _, err := io.ReadFull(r, buf); err != nil {
 		return err
 	}
 	rect.X = bo.Uint16(buf[0:])
 	rect.Y = bo.Uint16(buf[2:])
 	rect.Width = bo.Uint16(buf[4:])
 	rect.Height = bo.Uint16(buf[6:])
-	rect.EncodingType = bo.Uint32(buf[8:])
-	if rect.EncodingType != 0 {
+	rect.EncodingType = int32(bo.Uint32(buf[8:]))
+	rect.PixelData = nil
+	rect.Encoding = nil
+	switch rect.EncodingType {
+	case EncodingTypeRaw:
+	case EncodingTypeRRE, EncodingTypeCoRRE:
+		pixels, err := readRRE(r, bo, pixelFormat, int(rect.Width), int(rect.Height), rect.EncodingType == EncodingTypeCoRRE)
+		if err != nil {
+			return fmt.Errorf("read %s rectangle: %v", EncodingName(rect.EncodingType), err)
+		}
+		rect.PixelData = pixels
+		return nil
+	case EncodingTypeHextile:
+		pixels, err := readHextile(r, pixelFormat, int(rect.Width), int(rect.Height))
+		if err != nil {
+			return fmt.Errorf("read Hextile rectangle: %v", err)
+		}
+		rect.PixelData = pixels
+		return nil
+	case EncodingTypeZlib:
+		if decoders == nil {
+			return fmt.Errorf("can't read Zlib rectangle without Decoders")
+		}
+		pixels, err := decoders.readZlib(r, bo, pixelFormat, int(rect.Width), int(rect.Height))
+		if err != nil {
+			return fmt.Errorf("read Zlib rectangle: %v", err)
+		}
+		rect.PixelData = pixels
+		return nil
+	case EncodingTypeTight, EncodingTypeTightPNG:
+		if decoders == nil {
+			return fmt.Errorf("can't read %s rectangle without Decoders", EncodingName(rect.EncodingType))
+		}
+		pixels, err := decoders.readTight(r, pixelFormat, int(rect.Width), int(rect.Height), rect.EncodingType == EncodingTypeTightPNG)
+		if err != nil {
+			return fmt.Errorf("read %s rectangle: %v", EncodingName(rect.EncodingType), err)
+		}
+		rect.PixelData = pixels
+		return nil
+	case EncodingTypeTRLE:
+		tiles := &rleTileReader{r: r, pixelFormat: pixelFormat}
+		pixels, err := tiles.readTiles(int(rect.Width), int(rect.Height), 16)
+		if err != nil {
+			return fmt.Errorf("read TRLE rectangle: %v", err)
+		}
+		rect.PixelData = pixels
+		return nil
+	case EncodingTypeZRLE:
+		if decoders == nil {
+			return fmt.Errorf("can't read ZRLE rectangle without Decoders")
+		}
+		pixels, err := decoders.readZRLE(r, bo, pixelFormat, int(rect.Width), int(rect.Height))
+		if err != nil {
+			return fmt.Errorf("read ZRLE rectangle: %v", err)
+		}
+		rect.PixelData = pixels
+		return nil
+	case EncodingTypeCopyRectangle:
+		if _, err := io.ReadFull(r, buf[:4]); err != nil {
+			return err
+		}
+		rect.Encoding = &CopyRectEncoder{Src: image.Pt(int(bo.Uint16(buf[0:])), int(bo.Uint16(buf[2:])))}
+		return nil
+	case EncodingTypeDesktopSize, EncodingTypeQEMUExtendedKeyEvent:
+		return nil
+	case EncodingTypeExtendedDesktopSize:
+		encoder, err := readExtendedDesktopSize(r, bo)
+		if err != nil {
+			return fmt.Errorf("read ExtendedDesktopSize rectangle: %v", err)
+		}
+		rect.Encoding = encoder
+		return nil
+	case EncodingTypeCursor:
+		cursor, err := readCursor(r, pixelFormat, image.Pt(int(rect.X), int(rect.Y)), int(rect.Width), int(rect.Height))
+		if err != nil {
+			return fmt.Errorf("read Cursor rectangle: %v", err)
+		}
+		rect.Encoding = &CursorEncoder{Cursor: cursor, EncodingType: EncodingTypeCursor}
+		return nil
+	case EncodingTypeXCursor:
+		cursor, err := readXCursor(r, image.Pt(int(rect.X), int(rect.Y)), int(rect.Width), int(rect.Height))
+		if err != nil {
+			return fmt.Errorf("read XCursor rectangle: %v", err)
+		}
+		rect.Encoding = &CursorEncoder{Cursor: cursor, EncodingType: EncodingTypeXCursor}
+		return nil
+	case EncodingTypeCursorWithAlpha:
+		cursor, err := readCursorWithAlpha(r, bo, image.Pt(int(rect.X), int(rect.Y)), int(rect.Width), int(rect.Height))
+		if err != nil {
+			return fmt.Errorf("read CursorWithAlpha rectangle: %v", err)
+		}
+		rect.Encoding = &CursorEncoder{Cursor: cursor, EncodingType: EncodingTypeCursorWithAlpha}
+		return nil
+	default:
 		// TODO: Allow caller to provide additional decoders.
-		return fmt.Errorf("only raw encoding is supported, but found %d", rect.EncodingType)
+		return fmt.Errorf("only Raw, CopyRect, RRE, CoRRE, Hextile, Zlib, Tight, TightPNG, TRLE, and ZRLE encodings are supported, but found %d", rect.EncodingType)
 	}
 	rect.PixelData = make([]byte, int(pixelFormat.BitsPerPixel/8)*int(rect.Width)*int(rect.Height))
 	if _, err := io.ReadFull(r, rect.PixelData); err != nil {
@@ -523,16 +882,28 @@ func (rect *FramebufferUpdateRect) Read(r io.Reader, bo binary.ByteOrder, pixelF
 	return nil
 }
 
-func (rect *FramebufferUpdateRect) Write(w io.Writer, bo binary.ByteOrder) error {
-	var buf [12]byte
+func (rect *FramebufferUpdateRect) Write(w io.Writer, bo binary.ByteOrder, pixelFormat PixelFormat) error {
+	encodingType := rect.EncodingType
+	if rect.Encoding != nil {
+		encodingType = rect.Encoding.Type()
+	}
+
+	buf := writeBuffer(w, 12)
 	bo.PutUint16(buf[0:], rect.X)
 	bo.PutUint16(buf[2:], rect.Y)
 	bo.PutUint16(buf[4:], rect.Width)
 	bo.PutUint16(buf[6:], rect.Height)
-	bo.PutUint32(buf[8:], uint32(rect.EncodingType))
-	if _, err := w.Write(buf[:]); err != nil {
+	bo.PutUint32(buf[8:], uint32(encodingType))
+	if _, err := w.Write(buf); err != nil {
 		return err
 	}
+	if rect.Encoding != nil {
+		bounds := image.Rect(int(rect.X), int(rect.Y), int(rect.X)+int(rect.Width), int(rect.Y)+int(rect.Height))
+		if err := rect.Encoding.Encode(w, pixelFormat, rect.Image, bounds); err != nil {
+			return fmt.Errorf("encode %s rectangle: %v", EncodingName(encodingType), err)
+		}
+		return nil
+	}
 	if _, err := w.Write(rect.PixelData); err != nil {
 		return err
 	}
@@ -541,9 +912,9 @@ func (rect *FramebufferUpdateRect) Write(w io.Writer, bo binary.ByteOrder) error
 
 type BellMessage struct{}
 
-func (m *BellMessage) Read(r io.Reader) error {
-	var buf [1]byte
-	if _, err := io.ReadFull(r, buf[:]); err != nil {
+func (m *BellMessage) Read(r io.Reader, bo binary.ByteOrder) error {
+	buf := readBuffer(r, 1)
+	if _, err := io.ReadFull(r, buf); err != nil {
 		return err
 	}
 	if buf[0] != 2 {
@@ -552,39 +923,50 @@ func (m *BellMessage) Read(r io.Reader) error {
 	return nil
 }
 
-func (m *BellMessage) Write(w io.Writer) error {
-	_, err := w.Write([]byte{2})
+func (m *BellMessage) Write(w io.Writer, bo binary.ByteOrder) error {
+	buf := writeBuffer(w, 1)
+	buf[0] = 2
+	_, err := w.Write(buf)
 	return err
 }
 
 type ServerCutTextMessage struct {
 	Text string
+
+	// Set for messages sent with the Extended Clipboard extension, in which case it's written instead of Text. When
+	// reading a provide, Text is set too.
+	Extended *ExtendedClipboard
 }
 
 func (m *ServerCutTextMessage) Read(r io.Reader, bo binary.ByteOrder) error {
-	var buf [255]byte
-	if _, err := io.ReadFull(r, buf[:8]); err != nil {
+	buf := readBuffer(r, 8)
+	if _, err := io.ReadFull(r, buf); err != nil {
 		return err
 	}
 	if buf[0] != 3 {
-		return fmt.Errorf("expected message type 6, but found %d", buf[0])
-	}
-	textLength := bo.Uint32(buf[4:])
-	if int(textLength) > len(buf) {
-		return fmt.Errorf("text length too long: %d > %d", textLength, len(buf))
+		return fmt.Errorf("expected message type 3, but found %d", buf[0])
 	}
-	if _, err := io.ReadFull(r, buf[:textLength]); err != nil {
+	text, extended, err := readCutText(r, bo, bo.Uint32(buf[4:]))
+	if err != nil {
 		return err
 	}
-	converted, err := charmap.ISO8859_1.NewDecoder().Bytes(buf[:textLength])
+	m.Extended = extended
+	if extended != nil {
+		m.Text = text
+		return nil
+	}
+	converted, err := charmap.ISO8859_1.NewDecoder().Bytes([]byte(text))
 	if err != nil {
-		return fmt.Errorf("couldn't convert text to UTF-8 in ClientCutText: %v", err)
+		return fmt.Errorf("couldn't convert text to UTF-8 in ServerCutText: %v", err)
 	}
 	m.Text = string(converted)
 	return nil
 }
 
 func (m *ServerCutTextMessage) Write(w io.Writer, bo binary.ByteOrder) error {
+	if m.Extended != nil {
+		return m.Extended.write(w, bo, 3)
+	}
 	converted, err := charmap.ISO8859_1.NewEncoder().Bytes([]byte(m.Text))
 	if err != nil {
 		return fmt.Errorf("encode text: %v", err)
@@ -593,10 +975,10 @@ func (m *ServerCutTextMessage) Write(w io.Writer, bo binary.ByteOrder) error {
 		return fmt.Errorf("text too long: %d bytes > %d bytes", len(converted), ^uint32(0))
 	}
 
-	var buf [8]byte
+	buf := writeBuffer(w, 8)
 	buf[0] = 3
 	bo.PutUint32(buf[4:], uint32(len(converted)))
-	if _, err := w.Write(buf[:]); err != nil {
+	if _, err := w.Write(buf); err != nil {
 		return err
 	}
 	if _, err := w.Write(converted); err != nil {
@@ -619,6 +1001,14 @@ type PixelFormat struct {
 	BlueShift  uint8
 }
 
+// validate returns an error if pixels can't be encoded in the format, which clients mustn't ask for.
+func (pf *PixelFormat) validate() error {
+	if pf.BitsPerPixel != 8 && pf.BitsPerPixel != 16 && pf.BitsPerPixel != 32 {
+		return fmt.Errorf("unsupported pixel format: %d bits per pixel, but only 8, 16, and 32 are allowed", pf.BitsPerPixel)
+	}
+	return nil
+}
+
 // buf must contain at least 16 bytes.
 func (pf *PixelFormat) Read(buf []byte, bo binary.ByteOrder) {
 	pf.BitsPerPixel = buf[0]
@@ -655,3 +1045,22 @@ func (pf *PixelFormat) Write(buf []byte, bo binary.ByteOrder) {
 	buf[11] = pf.GreenShift
 	buf[12] = pf.BlueShift
 }
+
+// Strings longer than this are truncated when read.
+const maxStringLength = 1 << 16
+
+// Reads a length-prefixed field, keeping at most max bytes and discarding the rest so the stream stays in sync.
+func readTruncated(r io.Reader, length uint32, max int) ([]byte, error) {
+	keep := int64(length)
+	if keep > int64(max) {
+		keep = int64(max)
+	}
+	buf := make([]byte, keep)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	if _, err := io.CopyN(ioutil.Discard, r, int64(length)-keep); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
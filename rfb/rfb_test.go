@@ -0,0 +1,132 @@
+package rfb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestSetEncodingsManyTypes(t *testing.T) {
+	var buf bytes.Buffer
+	bo := binary.BigEndian
+	sent := SetEncodingsMessage{}
+	for i := int32(0); i < 300; i++ {
+		sent.EncodingTypes = append(sent.EncodingTypes, -i)
+	}
+	if err := sent.Write(&buf, bo); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := ReadClientMessage(bufio.NewReader(&buf), bo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := m.(*SetEncodingsMessage)
+	if len(received.EncodingTypes) != 300 || received.EncodingTypes[299] != -299 {
+		t.Fatalf("expected 300 encodings ending with -299, but got %v", received.EncodingTypes)
+	}
+}
+
+func TestClientCutTextTruncatesLongText(t *testing.T) {
+	var buf bytes.Buffer
+	bo := binary.BigEndian
+	long := ClientCutTextMessage{Text: strings.Repeat("x", maxStringLength+10)}
+	short := ClientCutTextMessage{Text: "after"}
+	if err := long.Write(&buf, bo); err != nil {
+		t.Fatal(err)
+	}
+	if err := short.Write(&buf, bo); err != nil {
+		t.Fatal(err)
+	}
+
+	r := bufio.NewReader(&buf)
+	m, err := ReadClientMessage(r, bo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(m.(*ClientCutTextMessage).Text); n != maxStringLength {
+		t.Fatalf("text should be truncated to %d bytes, but is %d", maxStringLength, n)
+	}
+	m, err = ReadClientMessage(r, bo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text := m.(*ClientCutTextMessage).Text; text != "after" {
+		t.Fatalf("next message should be intact, but text is %q", text)
+	}
+}
+
+func TestScrollDelta(t *testing.T) {
+	for _, test := range []struct {
+		previous, mask uint8
+		dx, dy         int
+	}{
+		{0, ButtonWheelDown, 0, 1},
+		{0, ButtonWheelUp | ButtonLeft, 0, -1},
+		{ButtonWheelUp, ButtonWheelUp, 0, 0}, // Still held, so not another notch.
+		{0, ButtonWheelLeft, -1, 0},
+		{ButtonLeft, ButtonWheelRight, 1, 0},
+		{ButtonWheelDown, 0, 0, 0},
+	} {
+		m := &PointerEventMessage{ButtonMask: test.mask}
+		if dx, dy := m.ScrollDelta(test.previous); dx != test.dx || dy != test.dy {
+			t.Errorf("ScrollDelta(%08b) from %08b = %d, %d, want %d, %d", test.previous, test.mask, dx, dy, test.dx, test.dy)
+		}
+	}
+}
+
+func TestTooLongMessages(t *testing.T) {
+	bo := binary.BigEndian
+	for _, test := range []struct {
+		header []byte // Declares a length without the data, which mustn't be waited for.
+		limits Limits
+	}{
+		{[]byte{6, 0, 0, 0, 0x7f, 0xff, 0xff, 0xff}, Limits{}},
+		{[]byte{6, 0, 0, 0, 0, 0, 0, 11}, Limits{CutText: 10}},
+		{[]byte{6, 0, 0, 0, 0x80, 0, 0, 0}, Limits{}}, // Extended Clipboard, 2 GiB.
+		{[]byte{2, 0, 0xff, 0xff}, Limits{}},
+		{[]byte{2, 0, 0, 3}, Limits{Encodings: 2}},
+	} {
+		_, err := ReadLimitedClientMessage(bufio.NewReader(bytes.NewReader(test.header)), bo, test.limits)
+		var tooLong *TooLongError
+		if !errors.As(err, &tooLong) {
+			t.Errorf("%v with limits %+v: got error %v, want TooLongError", test.header, test.limits, err)
+		}
+	}
+}
+
+func TestNegotiateVersion(t *testing.T) {
+	tests := []struct {
+		requested string
+		handshake ProtocolVersionMessage
+		err       bool
+	}{
+		{"RFB 003.003\n", ProtocolVersionMessage{3, 3}, false},
+		{"RFB 003.005\n", ProtocolVersionMessage{3, 3}, false},
+		{"RFB 003.007\n", ProtocolVersionMessage{3, 7}, false},
+		{"RFB 003.008\n", ProtocolVersionMessage{3, 8}, false},
+		{"RFB 003.889\n", ProtocolVersionMessage{3, 8}, false},
+		{"RFB 004.001\n", ProtocolVersionMessage{4, 1}, true},
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		_, handshake, err := NegotiateVersion(&struct {
+			io.Reader
+			io.Writer
+		}{strings.NewReader(test.requested), &out})
+		if out.String() != "RFB 003.008\n" {
+			t.Errorf("%q: offered %q, want 3.8", test.requested, out.String())
+		}
+		var unsupported *UnsupportedVersionError
+		if errors.As(err, &unsupported) != test.err || (err != nil && !test.err) {
+			t.Errorf("%q: got error %v, want an *UnsupportedVersionError: %v", test.requested, err, test.err)
+		}
+		if handshake != test.handshake {
+			t.Errorf("%q: follow the %v handshake, want %v", test.requested, handshake, test.handshake)
+		}
+	}
+}
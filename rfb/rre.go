@@ -0,0 +1,177 @@
+package rfb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+)
+
+func init() {
+	RegisterEncoding(EncodingTypeRRE, func() Encoding { return &RREEncoder{} })
+	RegisterEncoding(EncodingTypeCoRRE, func() Encoding { return &RREEncoder{Compact: true} })
+}
+
+// RREEncoder sends a background color and a list of solid subrectangles drawn over it, which suits flat UIs like this
+// one's.
+//
+// If Compact is set, it's CoRRE instead, whose subrectangle coordinates are single bytes. CoRRE rectangles can be at
+// most 255×255, so servers split larger ones (see SizeLimiter).
+type RREEncoder struct {
+	Compact bool
+
+	pixels  []uint32
+	covered []bool
+	buf     []byte
+}
+
+// SizeLimiter is implemented by Encodings that can't encode rectangles past a certain size. Servers tile larger
+// rectangles.
+type SizeLimiter interface {
+	MaxSize() image.Point
+}
+
+func (e *RREEncoder) Type() int32 {
+	if e.Compact {
+		return EncodingTypeCoRRE
+	}
+	return EncodingTypeRRE
+}
+
+func (e *RREEncoder) MaxSize() image.Point {
+	if e.Compact {
+		return image.Pt(255, 255)
+	}
+	return image.Pt(0xffff, 0xffff)
+}
+
+func (e *RREEncoder) Encode(w io.Writer, pixelFormat PixelFormat, img image.Image, rect image.Rectangle) error {
+	if max := e.MaxSize(); rect.Dx() > max.X || rect.Dy() > max.Y {
+		return fmt.Errorf("%dx%d rectangle is larger than %dx%d", rect.Dx(), rect.Dy(), max.X, max.Y)
+	}
+
+	width, height := rect.Dx(), rect.Dy()
+	e.pixels = pixelValues(e.pixels[:0], &pixelFormat, img, rect)
+	background := mostCommonPixel(e.pixels)
+
+	if cap(e.covered) < len(e.pixels) {
+		e.covered = make([]bool, len(e.pixels))
+	}
+	e.covered = e.covered[:len(e.pixels)]
+	for i, p := range e.pixels {
+		e.covered[i] = p == background
+	}
+
+	e.buf = append(e.buf[:0], 0, 0, 0, 0)
+	e.buf = pixelFormat.appendPixel(e.buf, background)
+	count := 0
+	subrectangles(e.pixels, e.covered, width, height, func(x, y, w, h int, color uint32) {
+		e.buf = pixelFormat.appendPixel(e.buf, color)
+		if e.Compact {
+			e.buf = append(e.buf, uint8(x), uint8(y), uint8(w), uint8(h))
+		} else {
+			e.buf = append(e.buf, uint8(x>>8), uint8(x), uint8(y>>8), uint8(y), uint8(w>>8), uint8(w), uint8(h>>8), uint8(h))
+		}
+		count++
+	})
+	binary.BigEndian.PutUint32(e.buf, uint32(count))
+
+	_, err := w.Write(e.buf)
+	return err
+}
+
+// subrectangles covers every uncovered pixel with solid rectangles, left to right and top to bottom, calling visit for
+// each. pixels and covered are width×height, row by row.
+func subrectangles(pixels []uint32, covered []bool, width, height int, visit func(x, y, w, h int, color uint32)) {
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if covered[y*width+x] {
+				continue
+			}
+			color := pixels[y*width+x]
+
+			// Grow right as far as the color goes, then down as far as whole rows of it go.
+			w := 1
+			for x+w < width && !covered[y*width+x+w] && pixels[y*width+x+w] == color {
+				w++
+			}
+			h := 1
+		grow:
+			for y+h < height {
+				row := (y + h) * width
+				for i := row + x; i < row+x+w; i++ {
+					if covered[i] || pixels[i] != color {
+						break grow
+					}
+				}
+				h++
+			}
+			for sy := y; sy < y+h; sy++ {
+				for sx := x; sx < x+w; sx++ {
+					covered[sy*width+sx] = true
+				}
+			}
+			visit(x, y, w, h, color)
+		}
+	}
+}
+
+func mostCommonPixel(pixels []uint32) uint32 {
+	counts := map[uint32]int{}
+	var best uint32
+	for _, p := range pixels {
+		counts[p]++
+		if counts[p] > counts[best] {
+			best = p
+		}
+	}
+	return best
+}
+
+// readRRE reads an RRE or CoRRE rectangle's payload and returns it as raw pixels.
+func readRRE(r io.Reader, bo binary.ByteOrder, pixelFormat PixelFormat, width, height int, compact bool) ([]byte, error) {
+	bytesPerPixel := int(pixelFormat.BitsPerPixel / 8)
+	header := make([]byte, 4+bytesPerPixel)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	count := int(bo.Uint32(header))
+	if count > width*height {
+		return nil, fmt.Errorf("%d subrectangles is more than a %dx%d rectangle has pixels", count, width, height)
+	}
+
+	pixels := make([]byte, bytesPerPixel*width*height)
+	for i := 0; i < len(pixels); i += bytesPerPixel {
+		copy(pixels[i:], header[4:])
+	}
+
+	coordSize := 2
+	if compact {
+		coordSize = 1
+	}
+	subrect := make([]byte, bytesPerPixel+4*coordSize)
+	for i := 0; i < count; i++ {
+		if _, err := io.ReadFull(r, subrect); err != nil {
+			return nil, err
+		}
+		var coords [4]int
+		for j := range coords {
+			field := subrect[bytesPerPixel+j*coordSize:]
+			if compact {
+				coords[j] = int(field[0])
+			} else {
+				coords[j] = int(bo.Uint16(field))
+			}
+		}
+		x, y, w, h := coords[0], coords[1], coords[2], coords[3]
+		if x+w > width || y+h > height {
+			return nil, fmt.Errorf("subrectangle %dx%d at (%d, %d) is outside the %dx%d rectangle", w, h, x, y, width, height)
+		}
+		for sy := y; sy < y+h; sy++ {
+			for sx := x; sx < x+w; sx++ {
+				copy(pixels[(sy*width+sx)*bytesPerPixel:], subrect[:bytesPerPixel])
+			}
+		}
+	}
+	return pixels, nil
+}
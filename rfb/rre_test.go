@@ -0,0 +1,50 @@
+package rfb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func TestRRERoundTrip(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 300, 40))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.White), image.ZP, draw.Src)
+	draw.Draw(img, image.Rect(10, 5, 280, 20), image.NewUniform(color.RGBA{0x60, 0x02, 0xee, 0xff}), image.ZP, draw.Src)
+	img.Set(3, 30, color.Black)
+
+	for _, compact := range []bool{false, true} {
+		encoder := &RREEncoder{Compact: compact}
+		for _, rect := range tileRectangles([]image.Rectangle{image.Rect(2, 1, 300, 40)}, encoder.MaxSize()) {
+			var buf bytes.Buffer
+			if err := encoder.Encode(&buf, DefaultPixelFormat, img, rect); err != nil {
+				t.Fatal(err)
+			}
+			if subrects := binary.BigEndian.Uint32(buf.Bytes()); subrects > 2 {
+				t.Errorf("compact=%v: %v took %d subrectangles, want at most 2", compact, rect, subrects)
+			}
+
+			pixels, err := readRRE(&buf, binary.BigEndian, DefaultPixelFormat, rect.Dx(), rect.Dy(), compact)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded := &PixelFormatImage{Pix: pixels, Rect: rect, PixelFormat: DefaultPixelFormat}
+			for y := rect.Min.Y; y < rect.Max.Y; y++ {
+				for x := rect.Min.X; x < rect.Max.X; x++ {
+					if got, want := decoded.At(x, y).(PixelFormatColor).Pixel, DefaultPixelFormat.Pixel(img.At(x, y)); got != want {
+						t.Fatalf("compact=%v: pixel (%d, %d) is %x, want %x", compact, x, y, got, want)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestCoRRERejectsLargeRectangles(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 300, 10))
+	if err := (&RREEncoder{Compact: true}).Encode(&bytes.Buffer{}, DefaultPixelFormat, img, img.Bounds()); err == nil {
+		t.Error("encoded a 300-pixel-wide CoRRE rectangle")
+	}
+}
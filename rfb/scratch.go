@@ -0,0 +1,46 @@
+package rfb
+
+import (
+	"bufio"
+	"io"
+)
+
+// Big enough for the fixed-size part of every message and rectangle header.
+const scratchSize = 32
+
+// messageReader is what ReadLimitedClientMessage passes to a message's Read method. It carries the limits to read with
+// and scratch space for the message's fields, so reading a message doesn't allocate buffers. Conn keeps one for all
+// of its messages.
+type messageReader struct {
+	*bufio.Reader
+	limits  Limits
+	scratch [scratchSize]byte
+}
+
+// messageWriter is what Conn passes to a message's Write method, with scratch space for the message's fields.
+type messageWriter struct {
+	*bufio.Writer
+	scratch [scratchSize]byte
+}
+
+// readBuffer returns n bytes to read fields into, from r's scratch space if it has any. The buffer may be reused by
+// the next call for r, so fields must be decoded out of it before then.
+func readBuffer(r io.Reader, n int) []byte {
+	if mr, ok := r.(*messageReader); ok && n <= len(mr.scratch) {
+		return mr.scratch[:n]
+	}
+	return make([]byte, n)
+}
+
+// writeBuffer returns n zeroed bytes to encode fields into before writing them to w, from w's scratch space if it has
+// any. The buffer may be reused by the next call for w, so it must be written before then.
+func writeBuffer(w io.Writer, n int) []byte {
+	if mw, ok := w.(*messageWriter); ok && n <= len(mw.scratch) {
+		buf := mw.scratch[:n]
+		for i := range buf {
+			buf[i] = 0
+		}
+		return buf
+	}
+	return make([]byte, n)
+}
@@ -0,0 +1,277 @@
+package rfb
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// SecurityHandler performs the server side of one security type's handshake, after the client has selected it and
+// before the security result is sent.
+type SecurityHandler interface {
+	Type() SecurityType
+	Handshake(rw io.ReadWriter, bo binary.ByteOrder) error
+}
+
+// AuthenticationFailedError is returned by SecurityHandler.Handshake when the client's credentials are rejected.
+// Its Reason is sent to RFB 3.8 clients.
+type AuthenticationFailedError struct {
+	Reason string
+}
+
+func (e *AuthenticationFailedError) Error() string {
+	return fmt.Sprintf("authentication failed: %s", e.Reason)
+}
+
+// RefuseClient tells a client that has sent its ProtocolVersion why it won't be served, in place of the security types
+// or RFB 3.3 authentication scheme. Close the connection afterwards.
+func RefuseClient(w io.Writer, bo binary.ByteOrder, version ProtocolVersionMessage, reason string) error {
+	if version.AtLeast(3, 7) {
+		if _, err := w.Write([]byte{0}); err != nil { // No security types.
+			return err
+		}
+	} else {
+		scheme := AuthenticationSchemeMessageRFB33{Scheme: AuthenticationSchemeInvalid}
+		if err := scheme.Write(w, bo); err != nil {
+			return err
+		}
+	}
+	return (&ReasonMessage{reason}).Write(w, bo)
+}
+
+// FailSecurity tells a client that its security handshake failed, in place of the security result. Only RFB 3.8
+// clients are told why. Close the connection afterwards.
+func FailSecurity(w io.Writer, bo binary.ByteOrder, version ProtocolVersionMessage, reason string) error {
+	if version.AtLeast(3, 8) {
+		return (&SecurityResultMessageRFB38{Result: VNCAuthenticationResultFailed, Reason: reason}).Write(w, bo)
+	}
+	return (&VNCAuthenticationResultMessage{Result: VNCAuthenticationResultFailed}).Write(w, bo)
+}
+
+// SecurityRegistry is the set of security types a server offers, in order of preference.
+type SecurityRegistry struct {
+	handlers []SecurityHandler
+}
+
+// Register adds a handler. It panics if a handler for the same type is already registered.
+func (r *SecurityRegistry) Register(h SecurityHandler) {
+	if _, ok := r.Handler(h.Type()); ok {
+		panic(fmt.Sprintf("security type %d is already registered", h.Type()))
+	}
+	r.handlers = append(r.handlers, h)
+}
+
+// Handler returns the handler registered for a security type.
+func (r *SecurityRegistry) Handler(t SecurityType) (SecurityHandler, bool) {
+	for _, h := range r.handlers {
+		if h.Type() == t {
+			return h, true
+		}
+	}
+	return nil, false
+}
+
+// Types returns the registered security types in order of preference.
+func (r *SecurityRegistry) Types() []SecurityType {
+	var types []SecurityType
+	for _, h := range r.handlers {
+		types = append(types, h.Type())
+	}
+	return types
+}
+
+// Negotiate performs the security phase of the handshake for a client speaking the given protocol version, from
+// offering security types through sending the security result. It returns the security type the client used.
+//
+// RFB 3.3 clients can't choose, so they're given the first registered handler for SecurityTypeNone or SecurityTypeVNC.
+func (r *SecurityRegistry) Negotiate(rw io.ReadWriter, bo binary.ByteOrder, version ProtocolVersionMessage) (SecurityType, error) {
+	return r.negotiate(rw, bo, version, 0)
+}
+
+func (r *SecurityRegistry) negotiate(rw io.ReadWriter, bo binary.ByteOrder, version ProtocolVersionMessage, quirks Quirks) (SecurityType, error) {
+	if !version.AtLeast(3, 7) {
+		return r.negotiateRFB33(rw, bo)
+	}
+
+	securityTypes := SecurityTypesMessageRFB37{Types: r.Types()}
+	if err := securityTypes.Write(rw); err != nil {
+		return SecurityTypeInvalid, fmt.Errorf("write security types: %v", err)
+	}
+	var selection SecurityTypeSelectionMessageRFB37
+	if err := selection.Read(rw); err != nil {
+		return SecurityTypeInvalid, fmt.Errorf("read security type: %v", err)
+	}
+	h, ok := r.Handler(selection.Type)
+	if !ok {
+		return SecurityTypeInvalid, fmt.Errorf("client chose security type %d, which wasn't offered", selection.Type)
+	}
+
+	err := h.Handshake(rw, bo)
+	var failed *AuthenticationFailedError
+	if err != nil && !errors.As(err, &failed) {
+		return SecurityTypeInvalid, err
+	}
+
+	if failed != nil {
+		if quirks&QuirkNoFailureReason != 0 {
+			version = ProtocolVersionMessage{Major: 3, Minor: 7} // Its result has no reason.
+		}
+		if err := FailSecurity(rw, bo, version, failed.Reason); err != nil {
+			return SecurityTypeInvalid, fmt.Errorf("write security result: %v", err)
+		}
+	} else if version.AtLeast(3, 8) {
+		result := SecurityResultMessageRFB38{Result: VNCAuthenticationResultOK}
+		if err := result.Write(rw, bo); err != nil {
+			return SecurityTypeInvalid, fmt.Errorf("write security result: %v", err)
+		}
+	} else if selection.Type != SecurityTypeNone {
+		result := VNCAuthenticationResultMessage{Result: VNCAuthenticationResultOK}
+		if err := result.Write(rw, bo); err != nil {
+			return SecurityTypeInvalid, fmt.Errorf("write VNC auth result: %v", err)
+		}
+	}
+	if err != nil {
+		return SecurityTypeInvalid, err
+	}
+	return selection.Type, nil
+}
+
+func (r *SecurityRegistry) negotiateRFB33(rw io.ReadWriter, bo binary.ByteOrder) (SecurityType, error) {
+	var h SecurityHandler
+	for _, candidate := range r.handlers {
+		if candidate.Type() == SecurityTypeNone || candidate.Type() == SecurityTypeVNC {
+			h = candidate
+			break
+		}
+	}
+	if h == nil {
+		reason := "No security types supported by RFB 3.3 are available."
+		if err := RefuseClient(rw, bo, ProtocolVersionMessage{Major: 3, Minor: 3}, reason); err != nil {
+			return SecurityTypeInvalid, fmt.Errorf("write failure reason: %v", err)
+		}
+		return SecurityTypeInvalid, errors.New("no registered security type supports RFB 3.3")
+	}
+
+	scheme := AuthenticationSchemeMessageRFB33{Scheme: AuthenticationScheme(h.Type())}
+	if err := scheme.Write(rw, bo); err != nil {
+		return SecurityTypeInvalid, fmt.Errorf("write auth scheme: %v", err)
+	}
+	err := h.Handshake(rw, bo)
+	var failed *AuthenticationFailedError
+	if err != nil && !errors.As(err, &failed) {
+		return SecurityTypeInvalid, err
+	}
+	if h.Type() == SecurityTypeVNC {
+		result := VNCAuthenticationResultMessage{Result: VNCAuthenticationResultOK}
+		if failed != nil {
+			result.Result = VNCAuthenticationResultFailed
+		}
+		if err := result.Write(rw, bo); err != nil {
+			return SecurityTypeInvalid, fmt.Errorf("write VNC auth result: %v", err)
+		}
+	}
+	if err != nil {
+		return SecurityTypeInvalid, err
+	}
+	return h.Type(), nil
+}
+
+// NoneSecurityHandler lets every client in without authentication.
+type NoneSecurityHandler struct{}
+
+func (h *NoneSecurityHandler) Type() SecurityType {
+	return SecurityTypeNone
+}
+
+func (h *NoneSecurityHandler) Handshake(rw io.ReadWriter, bo binary.ByteOrder) error {
+	return nil
+}
+
+// VNCSecurityHandler implements VNC authentication's challenge and response.
+type VNCSecurityHandler struct {
+	// Returns whether the response is correct for the challenge. If nil, any response is accepted.
+	Verify func(challenge VNCAuthenticationChallengeMessage, response VNCAuthenticationResponseMessage) bool
+}
+
+func (h *VNCSecurityHandler) Type() SecurityType {
+	return SecurityTypeVNC
+}
+
+func (h *VNCSecurityHandler) Handshake(rw io.ReadWriter, bo binary.ByteOrder) error {
+	var challenge VNCAuthenticationChallengeMessage
+	var response VNCAuthenticationResponseMessage
+	if _, err := rand.Read(challenge[:]); err != nil {
+		return fmt.Errorf("generate VNC auth challenge: %v", err)
+	}
+	if err := challenge.Write(rw); err != nil {
+		return fmt.Errorf("write VNC auth challenge: %v", err)
+	}
+	if err := response.Read(rw); err != nil {
+		return fmt.Errorf("read VNC auth response: %v", err)
+	}
+	if h.Verify != nil && !h.Verify(challenge, response) {
+		return &AuthenticationFailedError{"Incorrect password."}
+	}
+	return nil
+}
+
+// ARDSecurityHandler implements Apple Remote Desktop authentication. See SecurityTypeARD.
+type ARDSecurityHandler struct {
+	// Returns whether the credentials are valid. Must not be nil.
+	Verify func(username, password string) bool
+}
+
+func (h *ARDSecurityHandler) Type() SecurityType {
+	return SecurityTypeARD
+}
+
+func (h *ARDSecurityHandler) Handshake(rw io.ReadWriter, bo binary.ByteOrder) error {
+	username, password, err := ARDAuthenticate(rw, bo)
+	if err != nil {
+		return err
+	}
+	if !h.Verify(username, password) {
+		return &AuthenticationFailedError{"Incorrect username or password."}
+	}
+	return nil
+}
+
+// TightSecurityHandler implements the Tight security type, which offers its own list of authentication types.
+// Only SecurityTypeNone and SecurityTypeVNC handlers can be offered this way.
+//
+// A server that negotiates the Tight security type must send a TightInteractionCapabilitiesMessage after
+// ServerInitialisationMessage.
+type TightSecurityHandler struct {
+	Auth []SecurityHandler
+}
+
+func (h *TightSecurityHandler) Type() SecurityType {
+	return SecurityTypeTight
+}
+
+func (h *TightSecurityHandler) Handshake(rw io.ReadWriter, bo binary.ByteOrder) error {
+	var caps []TightCapability
+	for _, auth := range h.Auth {
+		switch auth.Type() {
+		case SecurityTypeNone:
+			caps = append(caps, TightCapabilityNoAuth)
+		case SecurityTypeVNC:
+			caps = append(caps, TightCapabilityVNCAuth)
+		default:
+			return fmt.Errorf("security type %d can't be used with Tight", auth.Type())
+		}
+	}
+
+	chosen, err := TightSecurityHandshake(rw, bo, caps)
+	if err != nil {
+		return fmt.Errorf("Tight security handshake: %v", err)
+	}
+	for _, auth := range h.Auth {
+		if auth.Type() == chosen {
+			return auth.Handshake(rw, bo)
+		}
+	}
+	return nil // No authentication was offered.
+}
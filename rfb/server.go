@@ -0,0 +1,864 @@
+package rfb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+// Handler is the application side of one client connection. Its methods are never called concurrently.
+//
+// If a Handler also implements io.Closer, Close is called when the connection ends. If it implements MessageHandler,
+// it receives client messages this package doesn't handle itself, such as those added with RegisterClientMessage. If
+// it implements MessageSource, it can send messages to the client.
+type Handler interface {
+	// Resize is called with the framebuffer size before the first call to Render.
+	Resize(width, height int)
+
+	// Render draws the region of the framebuffer the client asked for. img's bounds are rect. It starts out blank and
+	// is recycled once the update is sent, so Render must not keep it. Incremental updates only carry the parts that
+	// differ from what the client was last sent.
+	Render(img draw.Image, rect image.Rectangle)
+
+	KeyEvent(m *KeyEventMessage)
+	PointerEvent(m *PointerEventMessage)
+	CutText(text string)
+}
+
+type MessageHandler interface {
+	HandleMessage(m ClientMessage)
+}
+
+// MessageSource is implemented by Handlers that send messages of their own, such as ServerCutTextMessage. They're
+// collected and sent before each framebuffer update.
+type MessageSource interface {
+	PendingMessages() []ServerMessage
+}
+
+// ShutdownNotifier is implemented by Handlers that want to show something before Server.Shutdown disconnects their
+// client, such as a message saying the server is going away. ServerClosing is called once, just before the client's
+// last framebuffer update is rendered.
+type ShutdownNotifier interface {
+	ServerClosing()
+}
+
+// ErrServerClosed is returned by Serve and ListenAndServe once Shutdown has been called.
+var ErrServerClosed = errors.New("rfb: server closed")
+
+// How often Shutdown checks whether every connection has ended.
+const shutdownPollInterval = 50 * time.Millisecond
+
+// DefaultPixelFormat is 32-bit big-endian true color, which is cheap to render into.
+var DefaultPixelFormat = PixelFormat{
+	BitsPerPixel: 32,
+	BitDepth:     24,
+	BigEndian:    true,
+	TrueColor:    true,
+
+	RedMax:     255,
+	GreenMax:   255,
+	BlueMax:    255,
+	RedShift:   24,
+	GreenShift: 16,
+	BlueShift:  8,
+}
+
+// SharePolicy is what a Server does when a client clears the shared flag in ClientInitialisation, asking for exclusive
+// access to the desktop.
+type SharePolicy int
+
+const (
+	// Every client shares the desktop, whatever it asks for.
+	ShareIgnoreFlag SharePolicy = iota
+
+	// A client asking for exclusive access disconnects every other client, as the protocol intends.
+	ShareDisconnectOthers
+
+	// A client asking for exclusive access is refused while any other client is connected. The protocol has no way to
+	// tell it why at that stage, so it's simply disconnected.
+	ShareRefuseExclusive
+)
+
+// ParseSharePolicy parses "ignore", "disconnect", or "refuse".
+func ParseSharePolicy(s string) (SharePolicy, error) {
+	switch s {
+	case "ignore":
+		return ShareIgnoreFlag, nil
+	case "disconnect":
+		return ShareDisconnectOthers, nil
+	case "refuse":
+		return ShareRefuseExclusive, nil
+	default:
+		return 0, fmt.Errorf("unrecognized share policy %q; use ignore, disconnect, or refuse", s)
+	}
+}
+
+func (p SharePolicy) String() string {
+	switch p {
+	case ShareIgnoreFlag:
+		return "ignore"
+	case ShareDisconnectOthers:
+		return "disconnect"
+	case ShareRefuseExclusive:
+		return "refuse"
+	default:
+		return fmt.Sprintf("SharePolicy(%d)", int(p))
+	}
+}
+
+// Server handles version negotiation, security, initialisation, and the message loop for every client, delegating
+// everything else to a Handler per connection.
+type Server struct {
+	Name          string
+	Width, Height int
+
+	// The TCP address ListenAndServe listens on. Defaults to ":5900".
+	Addr string
+
+	// Offered to clients during initialisation. Defaults to DefaultPixelFormat.
+	PixelFormat *PixelFormat
+
+	// If nil, clients are let in without authentication.
+	Security *SecurityRegistry
+
+	// The encoding types the server may use. Each update is encoded with the client's most preferred of these that's
+	// registered, or Raw if there's none. If nil, any registered encoding may be used.
+	Encodings []int32
+
+	// Framebuffer updates are sent at most this often. If zero, updates are sent as fast as clients request them.
+	MaxFPS int
+
+	// If set, clients whose updates are slow to write, such as ones on slow links, are sent them less often than
+	// MaxFPS, down to once a second, so updates don't crowd out what they're sent in response to the client's input.
+	AdaptiveFPS bool
+
+	// Called for each client once its protocol version is known. If it returns an error, such as when the server is
+	// full, the client is refused with the error's message as the reason.
+	Admit func(conn io.ReadWriter) error
+
+	// Called for each client after the handshake with the connection being served, which the application may close to
+	// end the session. If it returns an error, the connection is closed.
+	NewHandler func(conn io.ReadWriter) (Handler, error)
+
+	// If set, Record is called before each client's first framebuffer update, and everything sent to the client from
+	// then on is copied to the writer it returns, such as an fbs.Writer, unless it's nil. serverInit describes the
+	// session as the client sees it at that point, with its own pixel format. Recording stops if the client changes its
+	// pixel format, since recordings can only have one, or if writing the recording fails; neither affects the client.
+	// The writer is closed when the client disconnects if it's an io.Closer.
+	Record func(conn io.ReadWriter, serverInit *ServerInitialisationMessage) io.Writer
+
+	// If set, NewTracer is called for each client after NewHandler, and every message to and from the client is given
+	// to the tracer it returns, unless it's nil. See LogTracer.
+	NewTracer func(conn io.ReadWriter) Tracer
+
+	// If set, called with why each client that disconnects or is refused before finishing initialisation didn't, such
+	// as for counting failed logins, which are a *AuthenticationFailedError.
+	HandshakeFailed func(conn io.ReadWriter, err error)
+
+	// Decide which workarounds each client needs. If nil, DefaultQuirkRules is used.
+	QuirkRules []QuirkRule
+
+	// Logs errors and notable events, such as clients needing workarounds. If nil, slog.Default() is used.
+	Logger *slog.Logger
+
+	// If set, lines about a client are logged to the logger it returns for the connection being served, such as one
+	// that tags them with a connection ID. Otherwise, they're logged to Logger, tagged with the remote address.
+	ConnLogger func(conn io.ReadWriter) *slog.Logger
+
+	// Bound the lengths of client messages. Zero fields use DefaultLimits.
+	Limits Limits
+
+	// If set, clients that haven't finished the handshake in time are disconnected, as are clients that don't send a
+	// message within IdleTimeout or take WriteTimeout to receive one. They only work if connections have deadlines,
+	// like a net.Conn. Viewers usually request updates continuously, but may stop while minimized.
+	HandshakeTimeout, IdleTimeout, WriteTimeout time.Duration
+
+	// If set, clients that haven't sent anything for this long are sent a fence to check they're still there, and are
+	// disconnected if they don't answer within another KeepAlive. Only clients that support fences can be checked this
+	// way, so set TCP keepalives on connections too to catch the others, as net.Listen does by default. See
+	// Conn.KeepAlive.
+	KeepAlive time.Duration
+
+	// Bounds how fast each client's key and pointer events reach its Handler. The zero value means no limit.
+	InputLimit InputLimit
+
+	// What to do when a client asks for exclusive access. Clients are only disconnected if their connections
+	// implement io.Closer.
+	SharePolicy SharePolicy
+
+	lock      sync.Mutex
+	sessions  map[*session]bool     // Clients that have finished initialisation.
+	listeners map[net.Listener]bool // Being served by Serve.
+	conns     map[net.Conn]bool     // Being served by Handle.
+	closing   chan struct{}         // Closed by Shutdown.
+}
+
+// session is one client that has finished initialisation.
+type session struct {
+	conn io.ReadWriter
+	c    *Conn
+}
+
+// ListenAndServe listens on Addr and calls Serve.
+func (s *Server) ListenAndServe() error {
+	addr := s.Addr
+	if addr == "" {
+		addr = ":5900"
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	return s.Serve(l)
+}
+
+// Serve accepts connections from l and serves each in its own goroutine. It returns when Accept fails, or with
+// ErrServerClosed after Shutdown, which closes l.
+func (s *Server) Serve(l net.Listener) error {
+	s.lock.Lock()
+	if s.closed() {
+		s.lock.Unlock()
+		return ErrServerClosed
+	}
+	if s.listeners == nil {
+		s.listeners = map[net.Listener]bool{}
+	}
+	s.listeners[l] = true
+	s.lock.Unlock()
+	defer func() {
+		s.lock.Lock()
+		delete(s.listeners, l)
+		s.lock.Unlock()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.lock.Lock()
+			closed := s.closed()
+			s.lock.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		go s.Handle(conn)
+	}
+}
+
+// Shutdown stops the server gracefully. It closes the listeners being served by Serve and disconnects clients that
+// haven't finished the handshake. Every other client is sent one last framebuffer update when it next asks for one,
+// after its Handler's ServerClosing method is called if it's a ShutdownNotifier, and is then disconnected. Shutdown
+// waits for the connections being served by Handle to end, and if ctx is done first, closes them and returns ctx's
+// error. Connections served by calling ServeConn directly end after their last update too, but aren't waited for.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.lock.Lock()
+	if !s.closed() {
+		close(s.closing)
+	}
+	var err error
+	for l := range s.listeners {
+		if closeErr := l.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	joined := map[io.ReadWriter]bool{}
+	for sess := range s.sessions {
+		joined[sess.conn] = true
+	}
+	var handshaking []net.Conn
+	for conn := range s.conns {
+		if !joined[conn] {
+			handshaking = append(handshaking, conn)
+		}
+	}
+	s.lock.Unlock()
+	for _, conn := range handshaking {
+		conn.Close()
+	}
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		s.lock.Lock()
+		var open []net.Conn
+		for conn := range s.conns {
+			open = append(open, conn)
+		}
+		s.lock.Unlock()
+		if len(open) == 0 {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			for _, conn := range open {
+				conn.Close()
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// closingLocked returns the channel Shutdown closes. s.lock must be held.
+func (s *Server) closingLocked() chan struct{} {
+	if s.closing == nil {
+		s.closing = make(chan struct{})
+	}
+	return s.closing
+}
+
+// closed reports whether Shutdown has been called. s.lock must be held.
+func (s *Server) closed() bool {
+	select {
+	case <-s.closingLocked():
+		return true
+	default:
+		return false
+	}
+}
+
+// Dial connects to a viewer that's listening for reverse connections, usually on port 5500, and serves it in the
+// background. Once connected, the session is the same as if the viewer had connected to the server.
+func (s *Server) Dial(network, address string) error {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return err
+	}
+	go s.Handle(conn)
+	return nil
+}
+
+// Handle serves conn until the session ends, logging any error, and closes it. Serve and Dial use it for each
+// connection; call it directly to serve connections made some other way. After Shutdown, it closes conn right away.
+func (s *Server) Handle(conn net.Conn) {
+	s.lock.Lock()
+	closed := s.closed()
+	if !closed {
+		if s.conns == nil {
+			s.conns = map[net.Conn]bool{}
+		}
+		s.conns[conn] = true
+	}
+	s.lock.Unlock()
+
+	if !closed {
+		if err := s.ServeConn(conn); err != nil && !errors.Is(err, ErrServerClosed) {
+			s.connLogger(conn).Warn("serve failed", "err", err)
+		}
+	}
+	if err := conn.Close(); err != nil {
+		s.connLogger(conn).Warn("couldn't close connection", "err", err)
+	}
+
+	s.lock.Lock()
+	delete(s.conns, conn)
+	s.lock.Unlock()
+}
+
+// ServeConn serves one client until the connection fails. It doesn't close conn.
+func (s *Server) ServeConn(conn io.ReadWriter) error {
+	return s.ServeConnContext(context.Background(), conn)
+}
+
+// ServeConnContext is like ServeConn, but gives up once ctx is done, closing conn if it's an io.Closer so that blocked
+// reads and writes return.
+func (s *Server) ServeConnContext(ctx context.Context, conn io.ReadWriter) error {
+	if closer, ok := conn.(io.Closer); ok && ctx.Done() != nil {
+		served := make(chan struct{})
+		defer close(served)
+		go func() {
+			select {
+			case <-ctx.Done():
+				closer.Close()
+			case <-served:
+			}
+		}()
+	}
+	err := s.serveConn(conn)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+func (s *Server) serveConn(conn io.ReadWriter) (err error) {
+	var initialised bool
+	defer func() {
+		if !initialised && err != nil && s.HandshakeFailed != nil {
+			s.HandshakeFailed(conn, err)
+		}
+	}()
+	pixelFormat := DefaultPixelFormat
+	if s.PixelFormat != nil {
+		pixelFormat = *s.PixelFormat
+	}
+	security := s.Security
+	if security == nil {
+		security = &SecurityRegistry{}
+		security.Register(&NoneSecurityHandler{})
+	}
+	c := NewConn(conn, pixelFormat)
+	c.Limits = s.Limits
+	c.ReadTimeout = s.IdleTimeout
+	c.KeepAlive = s.KeepAlive
+	c.WriteTimeout = s.WriteTimeout
+	if s.HandshakeTimeout > 0 {
+		if err := c.SetDeadline(time.Now().Add(s.HandshakeTimeout)); err != nil {
+			return fmt.Errorf("set handshake deadline: %v", err)
+		}
+	}
+	bo := c.ByteOrder()
+
+	requested, handshake, err := NegotiateVersion(c)
+	c.Version = requested
+	var unsupported *UnsupportedVersionError
+	if errors.As(err, &unsupported) {
+		reason := fmt.Sprintf("Only RFB 3.x is supported, but the client requested %d.%d.", requested.Major, requested.Minor)
+		return s.refuse(c, reason, err)
+	} else if err != nil {
+		return err
+	}
+	s.applyQuirkRules(conn, c)
+	if s.Admit != nil {
+		if err := s.Admit(conn); err != nil {
+			return s.refuse(c, err.Error(), fmt.Errorf("refused: %v", err))
+		}
+	}
+
+	securityType, err := security.negotiate(c, bo, handshake, c.Quirks)
+	if err != nil {
+		c.Flush() // Deliver the failure reason, if any.
+		// Wrapped, so HandshakeFailed can tell failed logins apart.
+		return fmt.Errorf("security handshake: %w", err)
+	}
+
+	var clientInit ClientInitialisationMessage
+	if err := clientInit.Read(c); err != nil {
+		return fmt.Errorf("read ClientInitialisation: %v", err)
+	}
+	leave, err := s.join(conn, c, clientInit.Shared)
+	if err != nil {
+		return err
+	}
+	defer leave()
+	serverInit := ServerInitialisationMessage{
+		FramebufferWidth:  uint16(s.Width),
+		FramebufferHeight: uint16(s.Height),
+		PixelFormat:       pixelFormat,
+		Name:              s.Name,
+	}
+	if err := serverInit.Write(c, bo); err != nil {
+		return fmt.Errorf("write ServerInitialisation: %v", err)
+	}
+	if securityType == SecurityTypeTight {
+		caps := TightInteractionCapabilitiesMessage{Encodings: []TightCapability{
+			TightCapabilityRaw, TightCapabilityCopyRect, TightCapabilityRRE, TightCapabilityCoRRE, TightCapabilityHextile,
+			TightCapabilityZlib, TightCapabilityTight, TightCapabilityZRLE,
+		}}
+		if err := caps.Write(c, bo); err != nil {
+			return fmt.Errorf("write Tight interaction capabilities: %v", err)
+		}
+	}
+	if err := c.Flush(); err != nil {
+		return fmt.Errorf("flush ServerInitialisation: %v", err)
+	}
+	if err := c.SetDeadline(time.Time{}); err != nil {
+		return fmt.Errorf("clear handshake deadline: %v", err)
+	}
+	initialised = true
+
+	h, err := s.NewHandler(conn)
+	if err != nil {
+		return fmt.Errorf("create handler: %v", err)
+	}
+	if closer, ok := h.(io.Closer); ok {
+		defer func() {
+			if err := closer.Close(); err != nil {
+				s.connLogger(conn).Warn("couldn't close handler", "err", err)
+			}
+		}()
+	}
+	h.Resize(s.Width, s.Height)
+	if s.NewTracer != nil {
+		c.Tracer = s.NewTracer(conn)
+	}
+	defer func() {
+		if err := c.stopRecording(); err != nil {
+			s.connLogger(conn).Warn("recording failed", "err", err)
+		}
+	}()
+
+	hooks, waiting := s.hooks(conn, c, h)
+	s.lock.Lock()
+	closing := s.closingLocked()
+	s.lock.Unlock()
+	return serveMessages(c, hooks, waiting, closing)
+}
+
+// Stats returns the stats of a client's connection, given the conn passed to NewHandler, if it has finished
+// initialisation and is still connected.
+func (s *Server) Stats(conn io.ReadWriter) (ConnStats, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for sess := range s.sessions {
+		if sess.conn == conn {
+			return sess.c.Stats(), true
+		}
+	}
+	return ConnStats{}, false
+}
+
+// refuse sends reason to a client in place of the security types and returns err.
+func (s *Server) refuse(c *Conn, reason string, err error) error {
+	if writeErr := RefuseClient(c, c.ByteOrder(), c.Version, reason); writeErr != nil {
+		return fmt.Errorf("%v (couldn't send reason: %v)", err, writeErr)
+	}
+	if flushErr := c.Flush(); flushErr != nil {
+		return fmt.Errorf("%v (couldn't send reason: %v)", err, flushErr)
+	}
+	return err
+}
+
+// join records a client that has sent ClientInitialisation, applying SharePolicy if it asked for exclusive access. The
+// returned function forgets it.
+func (s *Server) join(conn io.ReadWriter, c *Conn, shared bool) (func(), error) {
+	s.lock.Lock()
+	if !shared && s.SharePolicy == ShareRefuseExclusive && len(s.sessions) > 0 {
+		n := len(s.sessions)
+		s.lock.Unlock()
+		return nil, fmt.Errorf("client asked for exclusive access, but %d other clients are connected", n)
+	}
+	var others []io.ReadWriter
+	if !shared && s.SharePolicy == ShareDisconnectOthers {
+		for other := range s.sessions {
+			others = append(others, other.conn)
+		}
+	}
+	if s.sessions == nil {
+		s.sessions = map[*session]bool{}
+	}
+	sess := &session{conn: conn, c: c}
+	s.sessions[sess] = true
+	s.lock.Unlock()
+
+	for _, other := range others {
+		if closer, ok := other.(io.Closer); ok {
+			s.connLogger(conn).Info("asked for exclusive access; disconnecting another client", "other", remoteAddr(other))
+			closer.Close()
+		} else {
+			s.connLogger(conn).Warn("asked for exclusive access, but another client can't be disconnected", "other", remoteAddr(other))
+		}
+	}
+
+	return func() {
+		s.lock.Lock()
+		delete(s.sessions, sess)
+		s.lock.Unlock()
+	}, nil
+}
+
+func (s *Server) applyQuirkRules(conn io.ReadWriter, c *Conn) {
+	rules := s.QuirkRules
+	if rules == nil {
+		rules = DefaultQuirkRules
+	}
+	for _, rule := range applyQuirkRules(c, rules) {
+		s.connLogger(conn).Info("applying quirks", "version", fmt.Sprintf("%d.%d", c.Version.Major, c.Version.Minor),
+			"quirks", rule.Quirks.String(), "reason", rule.Description)
+	}
+}
+
+func (s *Server) hooks(conn io.ReadWriter, c *Conn, h Handler) (Hooks, *waitingUpdate) {
+	sizes := &desktopSize{framebuffer: image.Rect(0, 0, s.Width, s.Height)}
+	raw := &RawEncoder{}
+	encoders := map[int32]Encoding{EncodingTypeRaw: raw}
+	var encoder Encoding = raw
+	var cursor *Cursor // The last cursor sent.
+	var shadow shadowFramebuffer
+	var damage *pendingDamage // Nil unless h is a Damager.
+	damager, _ := h.(Damager)
+	if damager != nil {
+		damage = &pendingDamage{}
+	}
+	var acknowledgedKeys bool // Whether the client has been told it may send QEMU extended key events.
+	clip := &clipboard{}
+	var sentColourMap bool
+	var recordStarted bool
+	var recordedFormat PixelFormat
+	input := newInputLimiter(s.InputLimit, func() {
+		s.connLogger(conn).Warn("sending input too fast; dropping some", "limit", s.InputLimit.Rate)
+	})
+	s.lock.Lock()
+	closing := s.closingLocked()
+	s.lock.Unlock()
+
+	changes, _ := h.(ChangeNotifier)
+	waiting := &waitingUpdate{writer: newUpdateWriter(c, s.MaxFPS, s.AdaptiveFPS)}
+
+	respond := func(m *FramebufferUpdateRequestMessage) error {
+		waiting.request = nil
+		if waiting.writer.busy {
+			// The message loop answers once the last update has been sent.
+			waiting.request, waiting.changed = m, nil
+			return nil
+		}
+		input.flush(h) // So the update shows where the pointer is.
+		var last bool
+		select {
+		case <-closing:
+			last = true
+			if notifier, ok := h.(ShutdownNotifier); ok {
+				notifier.ServerClosing()
+			}
+		default:
+		}
+		if s.Record != nil && !recordStarted {
+			recordStarted = true
+			serverInit := &ServerInitialisationMessage{
+				FramebufferWidth:  uint16(sizes.framebuffer.Dx()),
+				FramebufferHeight: uint16(sizes.framebuffer.Dy()),
+				PixelFormat:       c.PixelFormat,
+				Name:              s.Name,
+			}
+			recordedFormat = c.PixelFormat
+			if w := s.Record(conn, serverInit); w != nil {
+				if err := c.record(w); err != nil {
+					return err
+				}
+			}
+		}
+
+		messages := clip.pending(c)
+		if !c.PixelFormat.TrueColor && !sentColourMap {
+			messages = append(messages, colourMap())
+			sentColourMap = true
+		}
+		if source, ok := h.(MessageSource); ok {
+			for _, message := range source.PendingMessages() {
+				messages = append(messages, clip.send(message))
+			}
+		}
+		update := FramebufferUpdateMessage{PixelFormat: c.PixelFormat}
+		var resized bool
+		update.Rectangles, resized = sizes.update(c, h)
+		if resized {
+			shadow.forget()
+		}
+		if !acknowledgedKeys && c.supportsEncoding(EncodingTypeQEMUExtendedKeyEvent) {
+			update.Rectangles = append(update.Rectangles, &FramebufferUpdateRect{EncodingType: EncodingTypeQEMUExtendedKeyEvent})
+			acknowledgedKeys = true
+		}
+
+		framebuffer := sizes.framebuffer
+		rect := image.Rect(int(m.X), int(m.Y), int(m.X)+int(m.Width), int(m.Y)+int(m.Height)).Intersect(framebuffer)
+		var img *image.RGBA
+		if !resized && !rect.Empty() {
+			img = GetRGBA(rect)
+			h.Render(img, rect)
+			if damager != nil {
+				damage.add(damager.Damage())
+			}
+
+			if source, ok := h.(CursorSource); ok {
+				if next := source.Cursor(); next != nil && next != cursor {
+					if t, ok := c.cursorEncoding(); ok {
+						update.Rectangles = append(update.Rectangles, cursorRectangle(next, t))
+						cursor = next
+					}
+				}
+			}
+
+			pixelRects := []image.Rectangle{rect}
+			if copier, ok := h.(Copier); ok {
+				copies := copier.Copies()
+				// Copies are relative to the client's framebuffer, which a non-incremental update can't rely on.
+				if m.Incremental && c.supportsEncoding(EncodingTypeCopyRectangle) {
+					var copyRects []*FramebufferUpdateRect
+					copyRects, pixelRects = copyRectangles(copies, rect, framebuffer)
+					update.Rectangles = append(update.Rectangles, copyRects...)
+				}
+			}
+			// Incremental updates only need what changed, which may be nothing.
+			if m.Incremental {
+				var hints []image.Rectangle
+				if damage != nil {
+					hints = append([]image.Rectangle{}, damage.rects...) // Empty, not nil, if nothing changed.
+				}
+				pixelRects = shadow.damaged(img, pixelRects, hints)
+			}
+			shadow.sent(img, framebuffer)
+			if damage != nil {
+				damage.sent(rect)
+			}
+			if limiter, ok := encoder.(SizeLimiter); ok {
+				pixelRects = tileRectangles(pixelRects, limiter.MaxSize())
+			}
+			for _, r := range pixelRects {
+				update.Rectangles = append(update.Rectangles, &FramebufferUpdateRect{
+					X: uint16(r.Min.X), Y: uint16(r.Min.Y), Width: uint16(r.Dx()), Height: uint16(r.Dy()),
+					Encoding: encoder, Image: img,
+				})
+			}
+		}
+
+		if m.Incremental && changes != nil && !last && len(messages) == 0 && len(update.Rectangles) == 0 {
+			// Nothing has changed, so the message loop answers once something does.
+			if img != nil {
+				PutRGBA(img)
+			}
+			waiting.request, waiting.changed = m, changes.Changed()
+			return nil
+		}
+		batch := &updateBatch{messages: append(messages, &update)}
+		if img != nil {
+			batch.release = func() { PutRGBA(img) }
+		}
+		waiting.writer.send(batch)
+		if last {
+			if err := waiting.writer.wait(); err != nil {
+				return err
+			}
+			return ErrServerClosed
+		}
+		return nil
+	}
+	waiting.retry = func() error { return respond(waiting.request) }
+
+	hooks := Hooks{
+		FramebufferUpdateRequest: respond,
+
+		SetPixelFormat: func(m *SetPixelFormatMessage) error {
+			if err := waiting.writer.wait(); err != nil { // Before the recording is touched.
+				return err
+			}
+			shadow.forget()
+			if c.recorder != nil && m.PixelFormat != recordedFormat {
+				s.connLogger(conn).Info("changed its pixel format; stopping recording")
+				if err := c.stopRecording(); err != nil {
+					s.connLogger(conn).Warn("recording failed", "err", err)
+				}
+			}
+			return nil
+		},
+
+		SetEncodings: func(m *SetEncodingsMessage) error {
+			if err := waiting.writer.wait(); err != nil { // Before the encoders it's using are touched.
+				return err
+			}
+			encoder = chooseEncoding(c.EncodingTypes, s.Encodings, encoders)
+			cursor = nil // The client may not have had the last one.
+			acknowledgedKeys = false
+			if configurable, ok := encoder.(Configurable); ok {
+				configurable.SetOptions(ParseEncodingOptions(c.EncodingTypes))
+			}
+			s.applyQuirkRules(conn, c)
+			return nil
+		},
+
+		KeyEvent: func(m *KeyEventMessage) error {
+			input.keyEvent(m, h, time.Now())
+			return nil
+		},
+		PointerEvent: func(m *PointerEventMessage) error {
+			input.pointerEvent(m, h, time.Now())
+			return nil
+		},
+		ClientCutText: func(m *ClientCutTextMessage) error {
+			clip.receive(m, h)
+			return nil
+		},
+	}
+	mh, _ := h.(MessageHandler)
+	hooks.Other = func(m ClientMessage) error {
+		switch m := m.(type) {
+		case *SetDesktopSizeMessage:
+			sizes.request(m, h)
+			return nil
+		case *QEMUExtendedKeyEventMessage:
+			input.keyEvent(&KeyEventMessage{Pressed: m.Pressed, KeySym: m.KeySym, KeyCode: m.KeyCode}, h, time.Now())
+			return nil
+		case *FenceMessage:
+			if m.Flags&FenceRequest != 0 {
+				return c.WriteMessage(m.Reply())
+			}
+			return nil // Answers a keepalive, which arriving was enough.
+		}
+		if mh != nil {
+			mh.HandleMessage(m)
+		}
+		return nil
+	}
+	return hooks, waiting
+}
+
+func remoteAddr(conn io.ReadWriter) string {
+	if nc, ok := conn.(net.Conn); ok {
+		return nc.RemoteAddr().String()
+	}
+	return "client"
+}
+
+// connLogger returns the logger for lines about the client on conn.
+func (s *Server) connLogger(conn io.ReadWriter) *slog.Logger {
+	if s.ConnLogger != nil {
+		if logger := s.ConnLogger(conn); logger != nil {
+			return logger
+		}
+	}
+	logger := s.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return logger.With("remote", remoteAddr(conn))
+}
+
+// chooseEncoding returns the client's most preferred encoding that's registered and allowed, falling back to Raw. If
+// allowed is nil, every encoding is. Encodings are created as needed and kept in encoders, so any state they have
+// carries over if the client switches back.
+func chooseEncoding(encodingTypes, allowed []int32, encoders map[int32]Encoding) Encoding {
+	for _, t := range encodingTypes {
+		if IsPseudoEncoding(t) || (allowed != nil && !containsEncoding(allowed, t)) {
+			continue
+		}
+		if e, ok := encoders[t]; ok {
+			return e
+		}
+		if e, ok := NewEncoding(t); ok {
+			encoders[t] = e
+			return e
+		}
+	}
+	return encoders[EncodingTypeRaw]
+}
+
+func containsEncoding(encodingTypes []int32, encodingType int32) bool {
+	for _, t := range encodingTypes {
+		if t == encodingType {
+			return true
+		}
+	}
+	return false
+}
+
+// tileRectangles splits rectangles into tiles no larger than max, in rows from the top left.
+func tileRectangles(rects []image.Rectangle, max image.Point) []image.Rectangle {
+	var tiles []image.Rectangle
+	for _, r := range rects {
+		for y := r.Min.Y; y < r.Max.Y; y += max.Y {
+			for x := r.Min.X; x < r.Max.X; x += max.X {
+				tiles = append(tiles, image.Rect(x, y, x+max.X, y+max.Y).Intersect(r))
+			}
+		}
+	}
+	return tiles
+}
@@ -0,0 +1,372 @@
+package rfb
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChooseEncoding(t *testing.T) {
+	for _, test := range []struct {
+		encodingTypes []int32
+		allowed       []int32
+		want          int32
+	}{
+		{nil, nil, EncodingTypeRaw},
+		{[]int32{EncodingTypeCopyRectangle, EncodingTypeJPEGQualityLevel0, EncodingTypeZRLE, EncodingTypeTight}, nil, EncodingTypeZRLE},
+		{[]int32{EncodingTypeZRLE, EncodingTypeTight}, []int32{EncodingTypeTight}, EncodingTypeTight},
+		{[]int32{EncodingTypeZRLE, EncodingTypeTight}, []int32{EncodingTypeHextile}, EncodingTypeRaw},
+		{[]int32{-12345, EncodingTypeHextile}, nil, EncodingTypeHextile},
+	} {
+		encoders := map[int32]Encoding{EncodingTypeRaw: &RawEncoder{}}
+		if got := chooseEncoding(test.encodingTypes, test.allowed, encoders).Type(); got != test.want {
+			t.Errorf("chooseEncoding(%v, %v) chose %s, want %s", test.encodingTypes, test.allowed, EncodingName(got), EncodingName(test.want))
+		}
+	}
+
+	// Switching back reuses the first instance, along with its state.
+	encoders := map[int32]Encoding{EncodingTypeRaw: &RawEncoder{}}
+	zrle := chooseEncoding([]int32{EncodingTypeZRLE}, nil, encoders)
+	chooseEncoding([]int32{EncodingTypeTight}, nil, encoders)
+	if again := chooseEncoding([]int32{EncodingTypeZRLE}, nil, encoders); again != zrle {
+		t.Errorf("switching back to ZRLE created a new encoder")
+	}
+}
+
+func TestTileRectangles(t *testing.T) {
+	got := tileRectangles([]image.Rectangle{image.Rect(0, 0, 300, 10)}, image.Pt(128, 128))
+	want := []image.Rectangle{image.Rect(0, 0, 128, 10), image.Rect(128, 0, 256, 10), image.Rect(256, 0, 300, 10)}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSharePolicy(t *testing.T) {
+	for _, policy := range []SharePolicy{ShareIgnoreFlag, ShareDisconnectOthers, ShareRefuseExclusive} {
+		server := &Server{
+			Name: "test", Width: 4, Height: 3, SharePolicy: policy,
+			NewHandler: func(conn io.ReadWriter) (Handler, error) {
+				return &fillHandler{color: color.White, keys: make(chan uint32, 1)}, nil
+			},
+		}
+		var conns []net.Conn
+		connect := func(shared bool) (*Client, error) {
+			serverConn, clientConn := net.Pipe()
+			conns = append(conns, clientConn)
+			go func() {
+				server.ServeConn(serverConn)
+				serverConn.Close()
+			}()
+			return NewClient(clientConn, ClientConfig{Shared: shared})
+		}
+
+		first, err := connect(true)
+		if err != nil {
+			t.Fatalf("%v: %v", policy, err)
+		}
+		if _, err := first.Update(false); err != nil {
+			t.Fatalf("%v: %v", policy, err)
+		}
+
+		second, err := connect(false)
+		if refused := err != nil; refused != (policy == ShareRefuseExclusive) {
+			t.Errorf("%v: exclusive client got error %v", policy, err)
+		}
+		if second != nil {
+			if _, err := second.Update(false); err != nil {
+				t.Errorf("%v: exclusive client couldn't update: %v", policy, err)
+			}
+		}
+
+		_, err = first.Update(false)
+		if disconnected := err != nil; disconnected != (policy == ShareDisconnectOthers) {
+			t.Errorf("%v: shared client got error %v", policy, err)
+		}
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}
+}
+
+func TestServerTimeouts(t *testing.T) {
+	newServer := func() *Server {
+		return &Server{
+			Width: 4, Height: 3, HandshakeTimeout: 50 * time.Millisecond, WriteTimeout: 50 * time.Millisecond,
+			NewHandler: func(conn io.ReadWriter) (Handler, error) {
+				return &fillHandler{color: color.White, keys: make(chan uint32, 1)}, nil
+			},
+		}
+	}
+	serve := func(ctx context.Context, server *Server, conn net.Conn) chan error {
+		done := make(chan error, 1)
+		go func() { done <- server.ServeConnContext(ctx, conn) }()
+		return done
+	}
+	wait := func(what string, done chan error) {
+		select {
+		case err := <-done:
+			if err == nil {
+				t.Errorf("%s: ServeConn returned nil", what)
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("%s: ServeConn didn't give up", what)
+		}
+	}
+
+	// A client that never answers the version.
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	done := serve(context.Background(), newServer(), serverConn)
+	var version ProtocolVersionMessage
+	if err := version.Read(clientConn); err != nil {
+		t.Fatal(err)
+	}
+	wait("stuck handshake", done)
+
+	// A client that requests an update but never reads it.
+	serverConn, clientConn = net.Pipe()
+	defer clientConn.Close()
+	done = serve(context.Background(), newServer(), serverConn)
+	client, err := NewClient(clientConn, ClientConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.RequestUpdate(false, image.Rect(0, 0, 4, 3)); err != nil {
+		t.Fatal(err)
+	}
+	wait("stalled client", done)
+
+	// A healthy client whose context is cancelled.
+	serverConn, clientConn = net.Pipe()
+	defer clientConn.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	done = serve(ctx, newServer(), serverConn)
+	client, err = NewClient(clientConn, ClientConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond) // Longer than the handshake timeout, which mustn't apply anymore.
+	if _, err := client.Update(false); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	wait("cancelled", done)
+}
+
+func TestServerKeepAlive(t *testing.T) {
+	server := &Server{
+		Width: 4, Height: 3, KeepAlive: 20 * time.Millisecond,
+		NewHandler: func(conn io.ReadWriter) (Handler, error) {
+			return &fillHandler{color: color.White, keys: make(chan uint32, 1)}, nil
+		},
+	}
+
+	// An idle client that answers fences stays connected.
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	done := make(chan error, 1)
+	go func() { done <- server.ServeConn(serverConn) }()
+	client, err := NewClient(clientConn, ClientConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fences int
+	read := make(chan error, 1)
+	go func() {
+		for {
+			m, err := client.ReadMessage()
+			if err != nil {
+				read <- err
+				return
+			}
+			if _, ok := m.(*FenceMessage); ok {
+				fences++
+			}
+		}
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("idle client was disconnected: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	clientConn.Close()
+	<-read
+	if fences < 2 {
+		t.Errorf("client was sent %d fences, want several", fences)
+	}
+
+	// A client that reads but never answers is disconnected.
+	serverConn, deafConn := net.Pipe()
+	defer deafConn.Close()
+	deafDone := make(chan error, 1)
+	go func() { deafDone <- server.ServeConn(serverConn) }()
+	if _, err := NewClient(deafConn, ClientConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	go io.Copy(ioutil.Discard, deafConn)
+	select {
+	case err := <-deafDone:
+		if err == nil || !strings.Contains(err.Error(), "keepalive") {
+			t.Errorf("ServeConn returned %v, want a keepalive error", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("unresponsive client wasn't disconnected")
+	}
+}
+
+// closingHandler records that it was told the server is closing.
+type closingHandler struct {
+	fillHandler
+	closing chan bool
+}
+
+func (h *closingHandler) ServerClosing() {
+	h.closing <- true
+}
+
+func TestServerShutdown(t *testing.T) {
+	handler := &closingHandler{fillHandler{color: color.White, keys: make(chan uint32, 1)}, make(chan bool, 1)}
+	server := &Server{
+		Width: 4, Height: 3,
+		NewHandler: func(conn io.ReadWriter) (Handler, error) { return handler, nil },
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(l) }()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client, err := NewClient(conn, ClientConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Update(false); err != nil {
+		t.Fatal(err)
+	}
+	// A client still in the handshake is disconnected right away.
+	handshaking, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer handshaking.Close()
+	if _, err := io.ReadFull(handshaking, make([]byte, 12)); err != nil {
+		t.Fatal(err)
+	}
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- server.Shutdown(context.Background()) }()
+	if _, err := ioutil.ReadAll(handshaking); err != nil {
+		t.Errorf("reading from client in the handshake: %v", err)
+	}
+	if err := <-served; err != ErrServerClosed {
+		t.Errorf("Serve returned %v, want ErrServerClosed", err)
+	}
+
+	// The other client gets one last update, and is then disconnected.
+	if _, err := client.Update(true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Update(true); err == nil {
+		t.Error("client still connected after its last update")
+	}
+	select {
+	case <-handler.closing:
+	default:
+		t.Error("handler wasn't told the server is closing")
+	}
+	if err := <-shutdown; err != nil {
+		t.Errorf("Shutdown returned %v", err)
+	}
+	if err := server.Serve(l); err != ErrServerClosed {
+		t.Errorf("Serve after Shutdown returned %v, want ErrServerClosed", err)
+	}
+}
+
+func TestServerShutdownTimeout(t *testing.T) {
+	server := &Server{
+		Width: 4, Height: 3,
+		NewHandler: func(conn io.ReadWriter) (Handler, error) {
+			return &fillHandler{color: color.White, keys: make(chan uint32, 1)}, nil
+		},
+	}
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	handled := make(chan bool)
+	go func() {
+		server.Handle(serverConn)
+		close(handled)
+	}()
+	if _, err := NewClient(clientConn, ClientConfig{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The client never asks for its last update, so it's disconnected once ctx is done.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Shutdown returned %v, want context.DeadlineExceeded", err)
+	}
+	<-handled
+}
+
+func TestServerHandshakeFailed(t *testing.T) {
+	failed := make(chan error, 1)
+	server := &Server{
+		Width: 4, Height: 3,
+		NewHandler: func(conn io.ReadWriter) (Handler, error) {
+			return &fillHandler{color: color.White, keys: make(chan uint32, 1)}, nil
+		},
+		HandshakeFailed: func(conn io.ReadWriter, err error) { failed <- err },
+	}
+
+	// A client that finishes initialisation hasn't failed, however the session ends.
+	serverConn, clientConn := net.Pipe()
+	done := make(chan error, 1)
+	go func() { done <- server.ServeConn(serverConn) }()
+	if _, err := NewClient(clientConn, ClientConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	clientConn.Close()
+	<-done
+
+	// One that asks for an unsupported version has.
+	serverConn, clientConn = net.Pipe()
+	defer clientConn.Close()
+	go func() { done <- server.ServeConn(serverConn) }()
+	if _, err := io.ReadFull(clientConn, make([]byte, 12)); err != nil {
+		t.Fatal(err)
+	}
+	go io.Copy(ioutil.Discard, clientConn)
+	if _, err := io.WriteString(clientConn, "RFB 004.000\n"); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	select {
+	case err := <-failed:
+		var unsupported *UnsupportedVersionError
+		if !errors.As(err, &unsupported) {
+			t.Errorf("HandshakeFailed got %v, want an UnsupportedVersionError", err)
+		}
+	default:
+		t.Error("HandshakeFailed wasn't called")
+	}
+	select {
+	case err := <-failed:
+		t.Errorf("HandshakeFailed called again with %v", err)
+	default:
+	}
+}
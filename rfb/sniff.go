@@ -0,0 +1,146 @@
+package rfb
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// SplitHTTP sorts the connections arriving on ln into RFB and HTTP, for serving both on one port, such as when only
+// one can be exposed. RFB servers speak first, so a client that sends something within wait of connecting, starting
+// with a letter as HTTP methods do, is taken to be an HTTP client, and every other client is taken to be an RFB viewer.
+// Each RFB connection is therefore delayed by wait, which should allow for the network's jitter but no more.
+// Connections arriving on a TLS listener are sorted once their TLS handshakes finish.
+//
+// Closing one of the returned listeners stops it accepting connections; ln is closed once both are. Pass the first to
+// Server.Serve and the second to http.Serve.
+func SplitHTTP(ln net.Listener, wait time.Duration) (rfbListener, httpListener net.Listener) {
+	s := &splitter{ln: ln, wait: wait, done: make(chan struct{}), open: 2}
+	rfbSide := &splitListener{splitter: s, conns: make(chan net.Conn), closed: make(chan struct{})}
+	httpSide := &splitListener{splitter: s, conns: make(chan net.Conn), closed: make(chan struct{})}
+	go s.accept(rfbSide, httpSide)
+	return rfbSide, httpSide
+}
+
+// How long a TLS client may take over its handshake before it's dropped, since it can't be sorted until then.
+const splitTLSHandshakeTimeout = 10 * time.Second
+
+// splitter accepts connections for a pair of splitListeners.
+type splitter struct {
+	ln   net.Listener
+	wait time.Duration
+
+	lock sync.Mutex
+	open int           // How many of the pair haven't been closed.
+	err  error         // Why Accept failed, once it has.
+	done chan struct{} // Closed when Accept fails.
+}
+
+func (s *splitter) accept(rfbSide, httpSide *splitListener) {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			s.lock.Lock()
+			s.err = err
+			s.lock.Unlock()
+			close(s.done)
+			return
+		}
+		go func() {
+			peeked, isHTTP, err := s.sniff(conn)
+			if err != nil {
+				conn.Close()
+				return
+			}
+			side := rfbSide
+			if isHTTP {
+				side = httpSide
+			}
+			select {
+			case side.conns <- peeked:
+			case <-side.closed:
+				conn.Close()
+			case <-s.done:
+				conn.Close()
+			}
+		}()
+	}
+}
+
+// sniff waits up to s.wait for conn to send something, and reports whether it looks like HTTP. The returned connection
+// reads what was sniffed before the rest.
+func (s *splitter) sniff(conn net.Conn) (net.Conn, bool, error) {
+	if handshaker, ok := conn.(interface{ Handshake() error }); ok {
+		conn.SetDeadline(time.Now().Add(splitTLSHandshakeTimeout))
+		if err := handshaker.Handshake(); err != nil {
+			return nil, false, fmt.Errorf("TLS handshake: %v", err)
+		}
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(s.wait)); err != nil {
+		return nil, false, err
+	}
+	r := bufio.NewReader(conn)
+	first, err := r.Peek(1)
+	if netErr, ok := err.(net.Error); err != nil && !(ok && netErr.Timeout()) {
+		return nil, false, err
+	}
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		return nil, false, err
+	}
+	if len(first) == 0 {
+		return conn, false, nil
+	}
+	return &peekedConn{conn, r}, 'A' <= first[0] && first[0] <= 'Z', nil
+}
+
+// splitListener is one side of SplitHTTP.
+type splitListener struct {
+	*splitter
+	conns  chan net.Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (l *splitListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, fmt.Errorf("accept on %v: listener closed", l.ln.Addr())
+	case <-l.done:
+		l.lock.Lock()
+		defer l.lock.Unlock()
+		return nil, l.err
+	}
+}
+
+func (l *splitListener) Close() error {
+	var err error
+	l.once.Do(func() {
+		close(l.closed)
+		l.lock.Lock()
+		l.open--
+		last := l.open == 0
+		l.lock.Unlock()
+		if last {
+			err = l.ln.Close()
+		}
+	})
+	return err
+}
+
+func (l *splitListener) Addr() net.Addr {
+	return l.ln.Addr()
+}
+
+// peekedConn reads from r, which has buffered the start of the connection.
+type peekedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *peekedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
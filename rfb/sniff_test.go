@@ -0,0 +1,67 @@
+package rfb
+
+import (
+	"image/color"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSplitHTTP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rfbListener, httpListener := SplitHTTP(ln, 100*time.Millisecond)
+	server := &Server{
+		Width: 4, Height: 3,
+		NewHandler: func(conn io.ReadWriter) (Handler, error) {
+			return &fillHandler{color: color.White, keys: make(chan uint32, 1)}, nil
+		},
+	}
+	go server.Serve(rfbListener)
+	go http.Serve(httpListener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello")
+	}))
+
+	for i := 0; i < 2; i++ {
+		resp, err := http.Get("http://" + ln.Addr().String() + "/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil || string(body) != "hello" {
+			t.Errorf("got %q, %v over HTTP, want hello", body, err)
+		}
+
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		client, err := NewClient(conn, ClientConfig{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.Update(false); err != nil {
+			t.Error(err)
+		}
+		conn.Close()
+	}
+
+	// The port stays open until both sides are closed.
+	rfbListener.Close()
+	if conn, err := net.Dial("tcp", ln.Addr().String()); err != nil {
+		t.Errorf("port closed with the HTTP side open: %v", err)
+	} else {
+		conn.Close()
+	}
+	httpListener.Close()
+	if conn, err := net.Dial("tcp", ln.Addr().String()); err == nil {
+		conn.Close()
+		t.Error("port still open after both sides were closed")
+	}
+}
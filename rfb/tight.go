@@ -0,0 +1,198 @@
+package rfb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// TightCapability identifies a tunnel, authentication scheme, message type, or encoding in the Tight security type's
+// capability negotiation.
+type TightCapability struct {
+	Code   uint32
+	Vendor string // Exactly 4 characters, e.g. "STDV" or "TGHT".
+	Name   string // Exactly 8 characters, e.g. "VNCAUTH_".
+}
+
+var (
+	TightCapabilityNoTunnel = TightCapability{0, "TGHT", "NOTUNNEL"}
+	TightCapabilityNoAuth   = TightCapability{uint32(SecurityTypeNone), "STDV", "NOAUTH__"}
+	TightCapabilityVNCAuth  = TightCapability{uint32(SecurityTypeVNC), "STDV", "VNCAUTH_"}
+
+	TightCapabilityRaw      = TightCapability{uint32(EncodingTypeRaw), "STDV", "RAW_____"}
+	TightCapabilityCopyRect = TightCapability{uint32(EncodingTypeCopyRectangle), "STDV", "COPYRECT"}
+	TightCapabilityRRE      = TightCapability{uint32(EncodingTypeRRE), "STDV", "RRE_____"}
+	TightCapabilityCoRRE    = TightCapability{uint32(EncodingTypeCoRRE), "STDV", "CORRE___"}
+	TightCapabilityHextile  = TightCapability{uint32(EncodingTypeHextile), "STDV", "HEXTILE_"}
+	TightCapabilityZlib     = TightCapability{uint32(EncodingTypeZlib), "TRDV", "ZLIB____"}
+	TightCapabilityTight    = TightCapability{uint32(EncodingTypeTight), "TGHT", "TIGHT___"}
+	TightCapabilityZRLE     = TightCapability{uint32(EncodingTypeZRLE), "TRDV", "ZRLE____"}
+)
+
+func (c *TightCapability) read(buf []byte, bo binary.ByteOrder) {
+	c.Code = bo.Uint32(buf[0:])
+	c.Vendor = string(buf[4:8])
+	c.Name = string(buf[8:16])
+}
+
+func (c *TightCapability) write(buf []byte, bo binary.ByteOrder) error {
+	if len(c.Vendor) != 4 || len(c.Name) != 8 {
+		return fmt.Errorf("capability vendor and name must be 4 and 8 bytes, but %q and %q are %d and %d", c.Vendor, c.Name, len(c.Vendor), len(c.Name))
+	}
+	bo.PutUint32(buf[0:], c.Code)
+	copy(buf[4:8], c.Vendor)
+	copy(buf[8:16], c.Name)
+	return nil
+}
+
+func readTightCapabilities(r io.Reader, bo binary.ByteOrder, count int) ([]TightCapability, error) {
+	const maxCount = 255
+	if count > maxCount {
+		return nil, fmt.Errorf("too many capabilities: %d > %d", count, maxCount)
+	}
+	var caps []TightCapability
+	var buf [16]byte
+	for i := 0; i < count; i++ {
+		if _, err := io.ReadFull(r, buf[:]); err != nil {
+			return nil, err
+		}
+		var c TightCapability
+		c.read(buf[:], bo)
+		caps = append(caps, c)
+	}
+	return caps, nil
+}
+
+func writeTightCapabilities(w io.Writer, bo binary.ByteOrder, caps []TightCapability) error {
+	var buf [16]byte
+	for _, c := range caps {
+		if err := c.write(buf[:], bo); err != nil {
+			return err
+		}
+		if _, err := w.Write(buf[:]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TightCapabilitiesMessage is the list of tunnel or authentication types the server offers after the client selects
+// SecurityTypeTight.
+type TightCapabilitiesMessage struct {
+	Capabilities []TightCapability
+}
+
+func (m *TightCapabilitiesMessage) Read(r io.Reader, bo binary.ByteOrder) error {
+	var buf [4]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return err
+	}
+	caps, err := readTightCapabilities(r, bo, int(bo.Uint32(buf[:])))
+	if err != nil {
+		return err
+	}
+	m.Capabilities = caps
+	return nil
+}
+
+func (m *TightCapabilitiesMessage) Write(w io.Writer, bo binary.ByteOrder) error {
+	var buf [4]byte
+	bo.PutUint32(buf[:], uint32(len(m.Capabilities)))
+	if _, err := w.Write(buf[:]); err != nil {
+		return err
+	}
+	return writeTightCapabilities(w, bo, m.Capabilities)
+}
+
+// TightCapabilityChoiceMessage is the client's choice from a non-empty TightCapabilitiesMessage.
+type TightCapabilityChoiceMessage struct {
+	Code uint32
+}
+
+func (m *TightCapabilityChoiceMessage) Read(r io.Reader, bo binary.ByteOrder) error {
+	var buf [4]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return err
+	}
+	m.Code = bo.Uint32(buf[:])
+	return nil
+}
+
+func (m *TightCapabilityChoiceMessage) Write(w io.Writer, bo binary.ByteOrder) error {
+	var buf [4]byte
+	bo.PutUint32(buf[:], m.Code)
+	_, err := w.Write(buf[:])
+	return err
+}
+
+// TightInteractionCapabilitiesMessage follows ServerInitialisationMessage when the Tight security type was used,
+// advertising the non-standard messages and encodings the server understands.
+type TightInteractionCapabilitiesMessage struct {
+	ServerMessages []TightCapability
+	ClientMessages []TightCapability
+	Encodings      []TightCapability
+}
+
+func (m *TightInteractionCapabilitiesMessage) Read(r io.Reader, bo binary.ByteOrder) error {
+	var buf [8]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return err
+	}
+	var err error
+	if m.ServerMessages, err = readTightCapabilities(r, bo, int(bo.Uint16(buf[0:]))); err != nil {
+		return err
+	}
+	if m.ClientMessages, err = readTightCapabilities(r, bo, int(bo.Uint16(buf[2:]))); err != nil {
+		return err
+	}
+	if m.Encodings, err = readTightCapabilities(r, bo, int(bo.Uint16(buf[4:]))); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (m *TightInteractionCapabilitiesMessage) Write(w io.Writer, bo binary.ByteOrder) error {
+	var buf [8]byte
+	bo.PutUint16(buf[0:], uint16(len(m.ServerMessages)))
+	bo.PutUint16(buf[2:], uint16(len(m.ClientMessages)))
+	bo.PutUint16(buf[4:], uint16(len(m.Encodings)))
+	if _, err := w.Write(buf[:]); err != nil {
+		return err
+	}
+	for _, caps := range [][]TightCapability{m.ServerMessages, m.ClientMessages, m.Encodings} {
+		if err := writeTightCapabilities(w, bo, caps); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TightSecurityHandshake performs the server side of the Tight security type's negotiation, which happens after the
+// client selects SecurityTypeTight. No tunnels are offered. The client's choice from authTypes is returned, and the
+// caller must then perform that authentication. If authTypes is empty, the client is told no authentication is
+// required and SecurityTypeNone is returned.
+func TightSecurityHandshake(rw io.ReadWriter, bo binary.ByteOrder, authTypes []TightCapability) (SecurityType, error) {
+	tunnels := TightCapabilitiesMessage{}
+	if err := tunnels.Write(rw, bo); err != nil {
+		return SecurityTypeInvalid, fmt.Errorf("write tunnel capabilities: %v", err)
+	}
+
+	auths := TightCapabilitiesMessage{Capabilities: authTypes}
+	if err := auths.Write(rw, bo); err != nil {
+		return SecurityTypeInvalid, fmt.Errorf("write authentication capabilities: %v", err)
+	}
+	if len(authTypes) == 0 {
+		return SecurityTypeNone, nil
+	}
+
+	var choice TightCapabilityChoiceMessage
+	if err := choice.Read(rw, bo); err != nil {
+		return SecurityTypeInvalid, fmt.Errorf("read authentication choice: %v", err)
+	}
+	for _, c := range authTypes {
+		if c.Code == choice.Code {
+			return SecurityType(c.Code), nil
+		}
+	}
+	return SecurityTypeInvalid, fmt.Errorf("client chose authentication type %d, which wasn't offered", choice.Code)
+}
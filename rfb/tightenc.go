@@ -0,0 +1,425 @@
+package rfb
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+)
+
+func init() {
+	RegisterEncoding(EncodingTypeTight, func() Encoding { return &TightEncoder{} })
+	RegisterEncoding(EncodingTypeTightPNG, func() Encoding { return &TightEncoder{PNG: true} })
+}
+
+// Tight compression control: the low four bits reset streams, and the high four say how the rectangle is sent.
+const (
+	tightExplicitFilter = 0x04 // Basic compression with a filter byte. The low two bits choose the zlib stream.
+	tightFill           = 0x08
+	tightJPEG           = 0x09
+	tightPNG            = 0x0a // TightPNG only.
+	tightMaxSubencoding = 0x0a
+
+	tightFilterCopy     = 0
+	tightFilterPalette  = 1
+	tightFilterGradient = 2
+
+	// Data shorter than this isn't compressed.
+	tightMinToCompress = 12
+)
+
+// The image/jpeg quality for each JPEG quality level, as TurboVNC maps them.
+var tightJPEGQualities = [10]int{15, 29, 41, 42, 62, 77, 79, 86, 92, 100}
+
+// TightEncoder sends each rectangle as a solid fill, as indexes into a palette of up to 256 colors, or as plain pixels,
+// compressing the last two with one of four zlib streams that last the whole connection. If the client accepts JPEG,
+// rectangles with too many colors for a palette are sent as JPEG instead of plain pixels. Rectangles can be at most
+// 65536 pixels, so servers tile larger ones.
+//
+// If PNG is set, it encodes TightPNG instead, which sends everything but fills and JPEG as PNG.
+type TightEncoder struct {
+	PNG bool
+
+	options EncodingOptions
+
+	streams [4]*zlib.Writer
+	resets  uint8 // Streams the client must reset before their next use.
+	outs    [4]bytes.Buffer
+	pixels  []uint32
+	data    []byte
+	buf     []byte
+	image   bytes.Buffer
+}
+
+func (e *TightEncoder) Type() int32 {
+	if e.PNG {
+		return EncodingTypeTightPNG
+	}
+	return EncodingTypeTight
+}
+
+// SetOptions sets the JPEG quality and compression level. Streams started at another level are replaced, and the client
+// is told to reset them.
+func (e *TightEncoder) SetOptions(o EncodingOptions) {
+	if o.zlibLevel() != e.options.zlibLevel() {
+		for i, stream := range e.streams {
+			if stream != nil {
+				e.streams[i] = nil
+				e.resets |= 1 << uint(i)
+			}
+		}
+	}
+	e.options = o
+}
+
+func (e *TightEncoder) MaxSize() image.Point {
+	return image.Pt(256, 256)
+}
+
+func (e *TightEncoder) Encode(w io.Writer, pixelFormat PixelFormat, img image.Image, rect image.Rectangle) error {
+	if rect.Dx()*rect.Dy() > 65536 || rect.Dx() > 2048 {
+		return fmt.Errorf("%dx%d rectangle is too large", rect.Dx(), rect.Dy())
+	}
+	pf := &pixelFormat
+	e.pixels = pixelValues(e.pixels[:0], pf, img, rect)
+
+	var palette []uint32
+	index := map[uint32]uint8{}
+	for _, p := range e.pixels {
+		if _, ok := index[p]; !ok {
+			if len(palette) == 256 {
+				palette = nil
+				break
+			}
+			index[p] = uint8(len(palette))
+			palette = append(palette, p)
+		}
+	}
+
+	e.buf = e.buf[:0]
+	switch {
+	case len(palette) == 1:
+		e.buf = pf.appendTPixel(append(e.buf, tightFill<<4), palette[0])
+
+	case e.PNG && (palette != nil || !e.jpegAllowed(pf)):
+		e.image.Reset()
+		if err := png.Encode(&e.image, subImage(img, rect)); err != nil {
+			return fmt.Errorf("encode PNG: %v", err)
+		}
+		e.buf = appendCompactLength(append(e.buf, tightPNG<<4), e.image.Len())
+		e.buf = append(e.buf, e.image.Bytes()...)
+
+	case len(palette) == 2 || (len(palette) > 2 && pf.tpixelSize() > 1):
+		stream := 2
+		if len(palette) == 2 {
+			stream = 1
+		}
+		e.buf = append(e.buf, uint8(tightExplicitFilter|stream)<<4, tightFilterPalette, uint8(len(palette)-1))
+		for _, p := range palette {
+			e.buf = pf.appendTPixel(e.buf, p)
+		}
+		e.data = e.data[:0]
+		if len(palette) == 2 {
+			width := rect.Dx()
+			for y := 0; y < rect.Dy(); y++ {
+				var b uint8
+				for x := 0; x < width; x++ {
+					b |= index[e.pixels[y*width+x]] << uint(7-x%8)
+					if x%8 == 7 || x == width-1 {
+						e.data = append(e.data, b)
+						b = 0
+					}
+				}
+			}
+		} else {
+			for _, p := range e.pixels {
+				e.data = append(e.data, index[p])
+			}
+		}
+		if err := e.appendData(stream, e.data); err != nil {
+			return err
+		}
+
+	case e.jpegAllowed(pf):
+		e.image.Reset()
+		quality := tightJPEGQualities[e.options.JPEGQualityLevel]
+		if err := jpeg.Encode(&e.image, subImage(img, rect), &jpeg.Options{Quality: quality}); err != nil {
+			return fmt.Errorf("encode JPEG: %v", err)
+		}
+		e.buf = appendCompactLength(append(e.buf, tightJPEG<<4), e.image.Len())
+		e.buf = append(e.buf, e.image.Bytes()...)
+
+	default:
+		e.buf = append(e.buf, 0) // Stream 0, with the copy filter implied.
+		e.data = e.data[:0]
+		for _, p := range e.pixels {
+			e.data = pf.appendTPixel(e.data, p)
+		}
+		if err := e.appendData(0, e.data); err != nil {
+			return err
+		}
+	}
+
+	_, err := w.Write(e.buf)
+	return err
+}
+
+func (e *TightEncoder) jpegAllowed(pf *PixelFormat) bool {
+	return e.options.JPEG && pf.TrueColor && pf.BitsPerPixel >= 16
+}
+
+// Appends data to e.buf, compressed with the given stream if it's long enough. e.buf must start with the compression
+// control byte.
+func (e *TightEncoder) appendData(stream int, data []byte) error {
+	if len(data) < tightMinToCompress {
+		e.buf = append(e.buf, data...)
+		return nil
+	}
+	if e.streams[stream] == nil {
+		zw, err := zlib.NewWriterLevel(&e.outs[stream], e.options.zlibLevel())
+		if err != nil {
+			return err
+		}
+		e.streams[stream] = zw
+	}
+	if e.resets&(1<<uint(stream)) != 0 {
+		e.buf[0] |= 1 << uint(stream)
+		e.resets &^= 1 << uint(stream)
+	}
+	out := &e.outs[stream]
+	out.Reset()
+	if _, err := e.streams[stream].Write(data); err != nil {
+		return err
+	}
+	if err := e.streams[stream].Flush(); err != nil {
+		return err
+	}
+	e.buf = appendCompactLength(e.buf, out.Len())
+	e.buf = append(e.buf, out.Bytes()...)
+	return nil
+}
+
+// subImage returns the rect portion of img, copying it if img can't share its pixels.
+func subImage(img image.Image, rect image.Rectangle) image.Image {
+	if img, ok := img.(interface {
+		SubImage(r image.Rectangle) image.Image
+	}); ok {
+		return img.SubImage(rect)
+	}
+	dst := image.NewRGBA(rect)
+	draw.Draw(dst, rect, img, rect.Min, draw.Src)
+	return dst
+}
+
+// Tight lengths take one to three bytes, seven bits at a time, least significant first.
+func appendCompactLength(buf []byte, length int) []byte {
+	if length < 0x80 {
+		return append(buf, uint8(length))
+	}
+	if length < 0x4000 {
+		return append(buf, uint8(length)|0x80, uint8(length>>7))
+	}
+	return append(buf, uint8(length)|0x80, uint8(length>>7)|0x80, uint8(length>>14))
+}
+
+func readCompactLength(r io.Reader) (int, error) {
+	var b [1]byte
+	length := 0
+	for i := uint(0); i < 3; i++ {
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return 0, err
+		}
+		if i == 2 {
+			return length | int(b[0])<<14, nil
+		}
+		length |= int(b[0]&0x7f) << (7 * i)
+		if b[0]&0x80 == 0 {
+			break
+		}
+	}
+	return length, nil
+}
+
+// tpixelSize returns the size of a Tight pixel: 3 bytes, red first, for 24-bit depth in 32-bit pixels with 8 bits
+// per color, and otherwise the same as a pixel.
+func (pf *PixelFormat) tpixelSize() int {
+	if pf.BitsPerPixel == 32 && pf.BitDepth == 24 && pf.TrueColor && pf.RedMax == 255 && pf.GreenMax == 255 && pf.BlueMax == 255 {
+		return 3
+	}
+	return int(pf.BitsPerPixel / 8)
+}
+
+func (pf *PixelFormat) appendTPixel(buf []byte, pixel uint32) []byte {
+	if pf.tpixelSize() != 3 {
+		return pf.appendPixel(buf, pixel)
+	}
+	return append(buf, uint8(pixel>>pf.RedShift), uint8(pixel>>pf.GreenShift), uint8(pixel>>pf.BlueShift))
+}
+
+// expandTPixel writes the pixel a Tight pixel represents to dst, in the pixel format's size and byte order.
+func (pf *PixelFormat) expandTPixel(dst, tpixel []byte) {
+	if len(tpixel) != 3 {
+		copy(dst, tpixel)
+		return
+	}
+	pixel := uint32(tpixel[0])<<pf.RedShift | uint32(tpixel[1])<<pf.GreenShift | uint32(tpixel[2])<<pf.BlueShift
+	pf.appendPixel(dst[:0], pixel)
+}
+
+// Reads a Tight or TightPNG rectangle's payload and returns it as raw pixels.
+func (d *Decoders) readTight(r io.Reader, pixelFormat PixelFormat, width, height int, isPNG bool) ([]byte, error) {
+	pf := &pixelFormat
+	bytesPerPixel := int(pf.BitsPerPixel / 8)
+	tpixel := pf.tpixelSize()
+	pixels := make([]byte, bytesPerPixel*width*height)
+
+	var control [1]byte
+	if _, err := io.ReadFull(r, control[:]); err != nil {
+		return nil, err
+	}
+	for i := range d.tight {
+		if control[0]&(1<<uint(i)) != 0 {
+			d.tight[i].reset()
+		}
+	}
+	subencoding := control[0] >> 4
+	if subencoding > tightMaxSubencoding || (subencoding == tightPNG && !isPNG) {
+		return nil, fmt.Errorf("invalid compression control %#x", control[0])
+	}
+
+	if subencoding == tightFill {
+		color := make([]byte, tpixel)
+		if _, err := io.ReadFull(r, color); err != nil {
+			return nil, err
+		}
+		pf.expandTPixel(pixels, color)
+		for i := bytesPerPixel; i < len(pixels); i += bytesPerPixel {
+			copy(pixels[i:], pixels[:bytesPerPixel])
+		}
+		return pixels, nil
+	}
+	if subencoding == tightJPEG || subencoding == tightPNG {
+		decode := jpeg.Decode
+		if subencoding == tightPNG {
+			decode = png.Decode
+		}
+		if err := readTightImage(r, pf, width, height, pixels, decode); err != nil {
+			return nil, err
+		}
+		return pixels, nil
+	}
+	if isPNG {
+		return nil, fmt.Errorf("TightPNG doesn't allow basic compression")
+	}
+
+	stream := &d.tight[subencoding&3]
+	filter := uint8(tightFilterCopy)
+	if subencoding&tightExplicitFilter != 0 {
+		var b [1]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return nil, err
+		}
+		filter = b[0]
+	}
+
+	var palette [][]byte
+	dataSize := width * height * tpixel
+	switch filter {
+	case tightFilterCopy:
+	case tightFilterPalette:
+		var b [1]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return nil, err
+		}
+		colors := make([]byte, (int(b[0])+1)*tpixel)
+		if _, err := io.ReadFull(r, colors); err != nil {
+			return nil, err
+		}
+		for i := 0; i < len(colors); i += tpixel {
+			color := make([]byte, bytesPerPixel)
+			pf.expandTPixel(color, colors[i:i+tpixel])
+			palette = append(palette, color)
+		}
+		if len(palette) == 2 {
+			dataSize = height * ((width + 7) / 8)
+		} else {
+			dataSize = width * height
+		}
+	case tightFilterGradient:
+		return nil, fmt.Errorf("the gradient filter isn't supported")
+	default:
+		return nil, fmt.Errorf("invalid filter %d", filter)
+	}
+
+	data := make([]byte, dataSize)
+	if dataSize < tightMinToCompress {
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+	} else {
+		length, err := readCompactLength(r)
+		if err != nil {
+			return nil, err
+		}
+		zr, err := stream.feedN(r, length, dataSize+1024)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.ReadFull(zr, data); err != nil {
+			return nil, fmt.Errorf("decompress: %v", err)
+		}
+	}
+
+	for i := 0; i < width*height; i++ {
+		dst := pixels[i*bytesPerPixel : (i+1)*bytesPerPixel]
+		switch {
+		case palette == nil:
+			pf.expandTPixel(dst, data[i*tpixel:(i+1)*tpixel])
+		case len(palette) == 2:
+			x, y := i%width, i/width
+			bit := data[y*((width+7)/8)+x/8] >> uint(7-x%8) & 1
+			copy(dst, palette[bit])
+		default:
+			if int(data[i]) >= len(palette) {
+				return nil, fmt.Errorf("palette index %d is past the palette's %d colors", data[i], len(palette))
+			}
+			copy(dst, palette[data[i]])
+		}
+	}
+	return pixels, nil
+}
+
+// Reads a JPEG or PNG Tight rectangle into pixels, converting it to the pixel format.
+func readTightImage(r io.Reader, pf *PixelFormat, width, height int, pixels []byte, decode func(io.Reader) (image.Image, error)) error {
+	length, err := readCompactLength(r)
+	if err != nil {
+		return err
+	}
+	if max := 2*width*height*4 + 1024; length > max {
+		return fmt.Errorf("image data is too long: %d > %d", length, max)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return err
+	}
+	img, err := decode(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("decode image: %v", err)
+	}
+	bounds := img.Bounds()
+	if bounds.Dx() != width || bounds.Dy() != height {
+		return fmt.Errorf("image is %dx%d, but the rectangle is %dx%d", bounds.Dx(), bounds.Dy(), width, height)
+	}
+	bytesPerPixel := int(pf.BitsPerPixel / 8)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := (y*width + x) * bytesPerPixel
+			pf.appendPixel(pixels[i:i], pf.Pixel(img.At(bounds.Min.X+x, bounds.Min.Y+y)))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package rfb
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestTightRoundTrip(t *testing.T) {
+	img := rleTestImage()
+	for y := 128; y < 140; y++ {
+		for x := 0; x < 200; x += 2 {
+			img.Set(x, y, color.Black)
+		}
+	}
+	rgb565 := PixelFormat{BitsPerPixel: 16, BitDepth: 16, TrueColor: true, RedMax: 31, GreenMax: 63, BlueMax: 31, RedShift: 11, GreenShift: 5}
+
+	for _, pixelFormat := range []PixelFormat{DefaultPixelFormat, rgb565} {
+		encoder := &TightEncoder{}
+		var decoders Decoders
+		for _, test := range []struct {
+			rect    image.Rectangle
+			control uint8
+		}{
+			{image.Rect(0, 0, 64, 64), tightFill << 4},
+			{image.Rect(0, 128, 200, 140), (tightExplicitFilter | 1) << 4},
+			{image.Rect(64, 0, 128, 64), (tightExplicitFilter | 2) << 4},
+			{image.Rect(128, 0, 192, 64), 0},
+			{image.Rect(128, 0, 130, 1), (tightExplicitFilter | 1) << 4}, // Too short to compress.
+			{image.Rect(130, 0, 192, 64), 0},
+		} {
+			var buf bytes.Buffer
+			if err := encoder.Encode(&buf, pixelFormat, img, test.rect); err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.Bytes()[0]; got != test.control {
+				t.Errorf("%d bpp: %v has compression control %#x, want %#x", pixelFormat.BitsPerPixel, test.rect, got, test.control)
+			}
+			pixels, err := decoders.readTight(&buf, pixelFormat, test.rect.Dx(), test.rect.Dy(), false)
+			if err != nil {
+				t.Fatalf("%d bpp: %v: %v", pixelFormat.BitsPerPixel, test.rect, err)
+			}
+			if buf.Len() != 0 {
+				t.Errorf("%d bpp: %v: %d bytes left over after decoding", pixelFormat.BitsPerPixel, test.rect, buf.Len())
+			}
+			checkDecoded(t, "Tight", pixels, img, test.rect, pixelFormat)
+		}
+	}
+}
+
+func TestTightCompressionLevel(t *testing.T) {
+	img := rleTestImage()
+	rect := image.Rect(128, 0, 192, 64)
+	encoder := &TightEncoder{}
+	var decoders Decoders
+	for i, level := range []int32{EncodingTypeCompressionLevel0 + 9, EncodingTypeCompressionLevel0 + 9, EncodingTypeCompressionLevel0 + 1} {
+		encoder.SetOptions(ParseEncodingOptions([]int32{EncodingTypeTight, level}))
+		var buf bytes.Buffer
+		if err := encoder.Encode(&buf, DefaultPixelFormat, img, rect); err != nil {
+			t.Fatal(err)
+		}
+		// Only a change of level after the stream started needs a reset.
+		want := uint8(0)
+		if i == 2 {
+			want = 1
+		}
+		if got := buf.Bytes()[0]; got != want {
+			t.Errorf("update %d has compression control %#x, want %#x", i, got, want)
+		}
+		pixels, err := decoders.readTight(&buf, DefaultPixelFormat, rect.Dx(), rect.Dy(), false)
+		if err != nil {
+			t.Fatalf("update %d: %v", i, err)
+		}
+		checkDecoded(t, "Tight", pixels, img, rect, DefaultPixelFormat)
+	}
+}
+
+func TestTightJPEG(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 4), uint8(y * 4), uint8(x + y), 0xff})
+		}
+	}
+	rect := image.Rect(8, 8, 64, 48)
+
+	sizes := map[int]int{}
+	for _, level := range []int{0, 9} {
+		encoder := &TightEncoder{}
+		encoder.SetOptions(ParseEncodingOptions([]int32{EncodingTypeTight, EncodingTypeJPEGQualityLevel0 + int32(level)}))
+		var buf bytes.Buffer
+		if err := encoder.Encode(&buf, DefaultPixelFormat, img, rect); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.Bytes()[0]; got != tightJPEG<<4 {
+			t.Errorf("level %d: compression control is %#x, want %#x", level, got, tightJPEG<<4)
+		}
+		sizes[level] = buf.Len()
+
+		var decoders Decoders
+		pixels, err := decoders.readTight(&buf, DefaultPixelFormat, rect.Dx(), rect.Dy(), false)
+		if err != nil {
+			t.Fatalf("level %d: %v", level, err)
+		}
+		if level != 9 {
+			continue
+		}
+		decoded := &PixelFormatImage{Pix: pixels, Rect: rect, PixelFormat: DefaultPixelFormat}
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			for x := rect.Min.X; x < rect.Max.X; x++ {
+				r0, g0, b0, _ := decoded.At(x, y).RGBA()
+				r1, g1, b1, _ := img.At(x, y).RGBA()
+				if absDiff(r0, r1) > 0x800 || absDiff(g0, g1) > 0x800 || absDiff(b0, b1) > 0x800 {
+					t.Fatalf("pixel (%d, %d) is %v, want about %v", x, y, decoded.At(x, y), img.At(x, y))
+				}
+			}
+		}
+	}
+	if sizes[0] >= sizes[9] {
+		t.Errorf("level 0 took %d bytes, which isn't less than level 9's %d", sizes[0], sizes[9])
+	}
+
+	// Without a quality level, the same rectangle is lossless.
+	var buf bytes.Buffer
+	if err := (&TightEncoder{}).Encode(&buf, DefaultPixelFormat, img, rect); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.Bytes()[0]; got != 0 {
+		t.Errorf("without JPEG, compression control is %#x, want 0", got)
+	}
+}
+
+func TestTightPNGRoundTrip(t *testing.T) {
+	img := rleTestImage()
+	encoder := &TightEncoder{PNG: true}
+	var decoders Decoders
+	for _, test := range []struct {
+		rect    image.Rectangle
+		control uint8
+	}{
+		{image.Rect(0, 0, 64, 64), tightFill << 4},
+		{image.Rect(64, 0, 128, 64), tightPNG << 4},
+		{image.Rect(128, 0, 192, 64), tightPNG << 4},
+	} {
+		var buf bytes.Buffer
+		if err := encoder.Encode(&buf, DefaultPixelFormat, img, test.rect); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.Bytes()[0]; got != test.control {
+			t.Errorf("%v has compression control %#x, want %#x", test.rect, got, test.control)
+		}
+		if test.control == tightPNG<<4 {
+			if _, err := decoders.readTight(bytes.NewReader(buf.Bytes()), DefaultPixelFormat, test.rect.Dx(), test.rect.Dy(), false); err == nil {
+				t.Errorf("%v: Tight accepted a PNG rectangle", test.rect)
+			}
+		}
+		pixels, err := decoders.readTight(&buf, DefaultPixelFormat, test.rect.Dx(), test.rect.Dy(), true)
+		if err != nil {
+			t.Fatalf("%v: %v", test.rect, err)
+		}
+		checkDecoded(t, "TightPNG", pixels, img, test.rect, DefaultPixelFormat)
+	}
+}
+
+func absDiff(a, b uint32) uint32 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+func TestCompactLength(t *testing.T) {
+	for _, length := range []int{0, 1, 0x7f, 0x80, 0x3fff, 0x4000, 0x3fffff} {
+		buf := appendCompactLength(nil, length)
+		got, err := readCompactLength(bytes.NewReader(buf))
+		if err != nil || got != length {
+			t.Errorf("%d encoded as %x decodes to %d, %v", length, buf, got, err)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package rfb
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Direction is which way a traced message went.
+type Direction int
+
+const (
+	FromClient Direction = iota
+	ToClient
+)
+
+func (d Direction) String() string {
+	switch d {
+	case FromClient:
+		return "<-"
+	case ToClient:
+		return "->"
+	default:
+		return fmt.Sprintf("Direction(%d)", int(d))
+	}
+}
+
+// TraceEvent describes one message a Conn read or wrote.
+type TraceEvent struct {
+	Direction Direction
+
+	// A ClientMessage or ServerMessage. Tracers may keep it, but not modify it.
+	Message interface{}
+
+	// When the message was read or written, and how long that took: for reads, from its first byte arriving, and for
+	// writes, including encoding it and waiting for the client to take it.
+	Time     time.Time
+	Duration time.Duration
+}
+
+// Summary describes the message in one line. See SummarizeMessage.
+func (e *TraceEvent) Summary() string {
+	return SummarizeMessage(e.Message)
+}
+
+// Tracer is given every message a Conn reads or writes after initialisation, for debugging. Trace is called from
+// whichever goroutine read or wrote the message, so it may be called concurrently.
+type Tracer interface {
+	Trace(e *TraceEvent)
+}
+
+// LogTracer logs each message's summary on one line, with an arrow showing which way it went.
+type LogTracer struct {
+	Logger *log.Logger // If nil, the log package's standard logger is used.
+	Prefix string      // Identifies the connection, such as its remote address, unless the logger already does.
+}
+
+func (t *LogTracer) Trace(e *TraceEvent) {
+	line := fmt.Sprintf("%s %s (%v)", e.Direction, e.Summary(), e.Duration.Round(time.Microsecond))
+	if t.Prefix != "" {
+		line = t.Prefix + " " + line
+	}
+	if t.Logger != nil {
+		t.Logger.Print(line)
+	} else {
+		log.Print(line)
+	}
+}
+
+// SummarizeMessage describes a message in one line: its type and the fields that matter for debugging, but not bulk
+// data like pixels or clipboard text. Messages of types this package doesn't know are described with %+v.
+func SummarizeMessage(m interface{}) string {
+	switch m := m.(type) {
+	case *SetPixelFormatMessage:
+		return "SetPixelFormat " + summarizePixelFormat(m.PixelFormat)
+	case *SetEncodingsMessage:
+		names := make([]string, len(m.EncodingTypes))
+		for i, t := range m.EncodingTypes {
+			names[i] = EncodingName(t)
+		}
+		return fmt.Sprintf("SetEncodings [%s]", strings.Join(names, " "))
+	case *FramebufferUpdateRequestMessage:
+		kind := "full"
+		if m.Incremental {
+			kind = "incremental"
+		}
+		return fmt.Sprintf("FramebufferUpdateRequest %s %dx%d+%d+%d", kind, m.Width, m.Height, m.X, m.Y)
+	case *KeyEventMessage:
+		return fmt.Sprintf("KeyEvent %s keysym %#x", upOrDown(m.Pressed), m.KeySym)
+	case *QEMUExtendedKeyEventMessage:
+		return fmt.Sprintf("QEMUExtendedKeyEvent %s keysym %#x keycode %#x", upOrDown(m.Pressed), m.KeySym, m.KeyCode)
+	case *PointerEventMessage:
+		return fmt.Sprintf("PointerEvent buttons %#02x at %d,%d", m.ButtonMask, m.X, m.Y)
+	case *ClientCutTextMessage:
+		return "ClientCutText " + summarizeCutText(m.Text, m.Extended)
+	case *SetDesktopSizeMessage:
+		return fmt.Sprintf("SetDesktopSize %dx%d with %d screens", m.Width, m.Height, len(m.Screens))
+
+	case *FramebufferUpdateMessage:
+		var rects []string
+		for _, rect := range m.Rectangles {
+			encodingType := rect.EncodingType
+			if rect.Encoding != nil {
+				encodingType = rect.Encoding.Type()
+			}
+			rects = append(rects, fmt.Sprintf("%s %dx%d+%d+%d", EncodingName(encodingType), rect.Width, rect.Height, rect.X, rect.Y))
+		}
+		return fmt.Sprintf("FramebufferUpdate %d rects [%s]", len(m.Rectangles), strings.Join(rects, ", "))
+	case *SetColourMapEntriesMessage:
+		return fmt.Sprintf("SetColourMapEntries %d colours from %d", len(m.Colours), m.FirstColour)
+	case *BellMessage:
+		return "Bell"
+	case *ServerCutTextMessage:
+		return "ServerCutText " + summarizeCutText(m.Text, m.Extended)
+	}
+
+	name := reflect.TypeOf(m).String()
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		name = name[i+1:]
+	}
+	return fmt.Sprintf("%s %+v", strings.TrimSuffix(name, "Message"), m)
+}
+
+func summarizePixelFormat(pf PixelFormat) string {
+	endianness := "little-endian"
+	if pf.BigEndian {
+		endianness = "big-endian"
+	}
+	if !pf.TrueColor {
+		return fmt.Sprintf("%dbpp depth %d %s colour mapped", pf.BitsPerPixel, pf.BitDepth, endianness)
+	}
+	return fmt.Sprintf("%dbpp depth %d %s true color max %d/%d/%d shift %d/%d/%d", pf.BitsPerPixel, pf.BitDepth,
+		endianness, pf.RedMax, pf.GreenMax, pf.BlueMax, pf.RedShift, pf.GreenShift, pf.BlueShift)
+}
+
+func summarizeCutText(text string, extended *ExtendedClipboard) string {
+	if extended != nil {
+		return fmt.Sprintf("extended flags %#08x, %d bytes of text", extended.Flags, len(extended.Text))
+	}
+	return fmt.Sprintf("%d bytes", len(text))
+}
+
+func upOrDown(pressed bool) string {
+	if pressed {
+		return "down"
+	}
+	return "up"
+}
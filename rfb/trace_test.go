@@ -0,0 +1,88 @@
+package rfb
+
+import (
+	"image/color"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type recordingTracer struct {
+	lock   sync.Mutex
+	events []TraceEvent
+}
+
+func (t *recordingTracer) Trace(e *TraceEvent) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.events = append(t.events, *e)
+}
+
+func TestServerTracer(t *testing.T) {
+	tracer := &recordingTracer{}
+	server := &Server{
+		Width: 4, Height: 3,
+		NewHandler: func(conn io.ReadWriter) (Handler, error) {
+			return &fillHandler{color: color.White, keys: make(chan uint32, 1)}, nil
+		},
+		NewTracer: func(conn io.ReadWriter) Tracer { return tracer },
+	}
+	serverConn, clientConn := net.Pipe()
+	done := make(chan error)
+	go func() { done <- server.ServeConn(serverConn) }()
+	client, err := NewClient(clientConn, ClientConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Update(false); err != nil {
+		t.Fatal(err)
+	}
+	clientConn.Close()
+	<-done
+
+	var got []string
+	for _, e := range tracer.events {
+		got = append(got, e.Direction.String()+" "+e.Summary())
+	}
+	// Clipboard capabilities are exchanged too.
+	want := []string{
+		"<- SetEncodings [",
+		"<- FramebufferUpdateRequest full 4x3+0+0",
+		"-> FramebufferUpdate ",
+	}
+	i := 0
+	for _, event := range got {
+		if i < len(want) && strings.HasPrefix(event, want[i]) {
+			i++
+		}
+	}
+	if i < len(want) {
+		t.Errorf("traced %q, want events starting with %q in order", got, want)
+	}
+}
+
+func TestSummarizeMessage(t *testing.T) {
+	tests := []struct {
+		m    interface{}
+		want string
+	}{
+		{&SetPixelFormatMessage{PixelFormat: DefaultPixelFormat}, "SetPixelFormat 32bpp depth 24 big-endian true color max 255/255/255 shift 24/16/8"},
+		{&SetPixelFormatMessage{PixelFormat: PixelFormat{BitsPerPixel: 8, BitDepth: 8}}, "SetPixelFormat 8bpp depth 8 little-endian colour mapped"},
+		{&SetEncodingsMessage{EncodingTypes: []int32{EncodingTypeZRLE, EncodingTypeRaw}}, "SetEncodings [ZRLE Raw]"},
+		{&KeyEventMessage{Pressed: true, KeySym: 'x'}, "KeyEvent down keysym 0x78"},
+		{&PointerEventMessage{ButtonMask: ButtonLeft, X: 3, Y: 4}, "PointerEvent buttons 0x01 at 3,4"},
+		{&ClientCutTextMessage{Text: "hello"}, "ClientCutText 5 bytes"},
+		{&FramebufferUpdateMessage{Rectangles: []*FramebufferUpdateRect{
+			{Width: 4, Height: 3, EncodingType: EncodingTypeRaw},
+			{X: 1, Y: 2, Width: 3, Height: 4, Encoding: &CopyRectEncoder{}},
+		}}, "FramebufferUpdate 2 rects [Raw 4x3+0+0, CopyRect 3x4+1+2]"},
+		{&BellMessage{}, "Bell"},
+	}
+	for _, test := range tests {
+		if got := SummarizeMessage(test.m); got != test.want {
+			t.Errorf("SummarizeMessage(%T) = %q, want %q", test.m, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package rfb
+
+import (
+	"image"
+	"sync"
+)
+
+// PixelTranslator converts *image.RGBA pixels to a pixel format with a lookup table per color component, so servers
+// can render in RGBA whatever format the client asked for. Pixels are premultiplied, and the framebuffer has no alpha,
+// so translucent pixels are treated as composited onto black.
+type PixelTranslator struct {
+	PixelFormat      PixelFormat
+	red, green, blue [256]uint32
+}
+
+func NewPixelTranslator(pixelFormat PixelFormat) *PixelTranslator {
+	t := &PixelTranslator{PixelFormat: pixelFormat}
+	for v := uint32(0); v < 256; v++ {
+		// Each component's bits are independent of the others', even in the colour map.
+		t.red[v] = pixelFormat.pixel(v*0x101, 0, 0)
+		t.green[v] = pixelFormat.pixel(0, v*0x101, 0)
+		t.blue[v] = pixelFormat.pixel(0, 0, v*0x101)
+	}
+	return t
+}
+
+var translators sync.Map // PixelFormat to *PixelTranslator
+
+// translatorFor returns a shared PixelTranslator for the pixel format, so the tables are only built once.
+func translatorFor(pixelFormat *PixelFormat) *PixelTranslator {
+	if t, ok := translators.Load(*pixelFormat); ok {
+		return t.(*PixelTranslator)
+	}
+	t, _ := translators.LoadOrStore(*pixelFormat, NewPixelTranslator(*pixelFormat))
+	return t.(*PixelTranslator)
+}
+
+// Pixel returns the pixel value for an 8-bit color.
+func (t *PixelTranslator) Pixel(r, g, b uint8) uint32 {
+	return t.red[r] | t.green[g] | t.blue[b]
+}
+
+// AppendPixels appends the rect portion of img, which contains rect, in raw order: left to right, top to bottom.
+func (t *PixelTranslator) AppendPixels(buf []byte, img *image.RGBA, rect image.Rectangle) []byte {
+	pf := &t.PixelFormat
+	bytesPerPixel := int(pf.BitsPerPixel / 8)
+	n := len(buf)
+	if need := n + bytesPerPixel*rect.Dx()*rect.Dy(); need > cap(buf) {
+		grown := make([]byte, n, need)
+		copy(grown, buf)
+		buf = grown
+	}
+	buf = buf[:n+bytesPerPixel*rect.Dx()*rect.Dy()]
+	out := buf[n:]
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		i := img.PixOffset(rect.Min.X, y)
+		row := img.Pix[i : i+4*rect.Dx()]
+		// Switch once per row rather than once per pixel.
+		switch {
+		case bytesPerPixel == 1:
+			for j := 0; j < len(row); j += 4 {
+				out[0] = uint8(t.red[row[j]] | t.green[row[j+1]] | t.blue[row[j+2]])
+				out = out[1:]
+			}
+		case bytesPerPixel == 2 && pf.BigEndian:
+			for j := 0; j < len(row); j += 4 {
+				p := t.red[row[j]] | t.green[row[j+1]] | t.blue[row[j+2]]
+				out[0], out[1] = uint8(p>>8), uint8(p)
+				out = out[2:]
+			}
+		case bytesPerPixel == 2:
+			for j := 0; j < len(row); j += 4 {
+				p := t.red[row[j]] | t.green[row[j+1]] | t.blue[row[j+2]]
+				out[0], out[1] = uint8(p), uint8(p>>8)
+				out = out[2:]
+			}
+		case pf.BigEndian:
+			for j := 0; j < len(row); j += 4 {
+				p := t.red[row[j]] | t.green[row[j+1]] | t.blue[row[j+2]]
+				out[0], out[1], out[2], out[3] = uint8(p>>24), uint8(p>>16), uint8(p>>8), uint8(p)
+				out = out[4:]
+			}
+		default:
+			for j := 0; j < len(row); j += 4 {
+				p := t.red[row[j]] | t.green[row[j+1]] | t.blue[row[j+2]]
+				out[0], out[1], out[2], out[3] = uint8(p), uint8(p>>8), uint8(p>>16), uint8(p>>24)
+				out = out[4:]
+			}
+		}
+	}
+	return buf
+}
+
+// PixelValues appends the pixel values of the rect portion of img in raw order, for encodings that analyze them
+// before writing.
+func (t *PixelTranslator) PixelValues(values []uint32, img *image.RGBA, rect image.Rectangle) []uint32 {
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		i := img.PixOffset(rect.Min.X, y)
+		row := img.Pix[i : i+4*rect.Dx()]
+		for j := 0; j < len(row); j += 4 {
+			values = append(values, t.red[row[j]]|t.green[row[j+1]]|t.blue[row[j+2]])
+		}
+	}
+	return values
+}
+
+// Translate returns the rect portion of img, which contains rect, as an image in the translator's pixel format.
+func (t *PixelTranslator) Translate(img *image.RGBA, rect image.Rectangle) *PixelFormatImage {
+	return &PixelFormatImage{Pix: t.AppendPixels(nil, img, rect), Rect: rect, PixelFormat: t.PixelFormat}
+}
@@ -0,0 +1,38 @@
+package rfb
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestPixelTranslator(t *testing.T) {
+	img := rleTestImage()
+	img.Set(3, 3, color.RGBA{0x40, 0x20, 0x10, 0x80}) // Premultiplied, so it's dark.
+	rect := image.Rect(1, 2, 150, 100)
+	for _, pf := range []PixelFormat{
+		DefaultPixelFormat,
+		{BitsPerPixel: 32, BitDepth: 30, TrueColor: true, RedMax: 1023, GreenMax: 1023, BlueMax: 1023, BlueShift: 20, GreenShift: 10},
+		{BitsPerPixel: 16, BitDepth: 15, TrueColor: true, RedMax: 31, GreenMax: 31, BlueMax: 31, RedShift: 10, GreenShift: 5},
+		{BitsPerPixel: 16, BitDepth: 12, BigEndian: true, TrueColor: true, RedMax: 15, GreenMax: 15, BlueMax: 15, RedShift: 8, GreenShift: 4},
+		{BitsPerPixel: 8, BitDepth: 6, TrueColor: true, RedMax: 3, GreenMax: 3, BlueMax: 3, RedShift: 4, GreenShift: 2},
+		{BitsPerPixel: 8, BitDepth: 8}, // The colour map.
+	} {
+		var want []byte
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			for x := rect.Min.X; x < rect.Max.X; x++ {
+				want = pf.appendPixel(want, pf.Pixel(img.At(x, y)))
+			}
+		}
+		translator := NewPixelTranslator(pf)
+		if got := translator.AppendPixels([]byte{0xaa}, img, rect); !bytes.Equal(got[1:], want) || got[0] != 0xaa {
+			t.Errorf("%+v: translated pixels differ from packing each one", pf)
+		}
+		translated := translator.Translate(img, rect)
+		checkDecoded(t, "Translate", translated.Pix, img, rect, pf)
+		if got := translator.PixelValues(nil, img, rect); len(got) != rect.Dx()*rect.Dy() || got[0] != pf.Pixel(img.At(1, 2)) {
+			t.Errorf("%+v: got %d pixel values starting with %x", pf, len(got), got[0])
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package rfb
+
+import (
+	"image"
+	"io"
+)
+
+func init() {
+	RegisterEncoding(EncodingTypeTRLE, func() Encoding { return &TRLEEncoder{} })
+}
+
+// TRLEEncoder sends the same kinds of tiles as ZRLEEncoder, but 16×16 and uncompressed, and a tile can reuse the
+// previous one's palette.
+type TRLEEncoder struct {
+	buf     []byte
+	pixels  []uint32
+	palette []uint32
+}
+
+func (e *TRLEEncoder) Type() int32 {
+	return EncodingTypeTRLE
+}
+
+func (e *TRLEEncoder) Encode(w io.Writer, pixelFormat PixelFormat, img image.Image, rect image.Rectangle) error {
+	e.buf = e.buf[:0]
+	e.palette = e.palette[:0]
+	for ty := rect.Min.Y; ty < rect.Max.Y; ty += 16 {
+		for tx := rect.Min.X; tx < rect.Max.X; tx += 16 {
+			tile := image.Rect(tx, ty, tx+16, ty+16).Intersect(rect)
+			e.pixels = pixelValues(e.pixels[:0], &pixelFormat, img, tile)
+			e.buf = appendRLETile(e.buf, &pixelFormat, e.pixels, tile.Dx(), tile.Dy(), &e.palette)
+		}
+	}
+	_, err := w.Write(e.buf)
+	return err
+}
@@ -0,0 +1,51 @@
+package rfb
+
+import (
+	"fmt"
+	"io"
+)
+
+// UnsupportedVersionError is returned by NegotiateVersion when a client asks for a major version other than 3.
+type UnsupportedVersionError struct {
+	Requested ProtocolVersionMessage
+}
+
+func (e *UnsupportedVersionError) Error() string {
+	return fmt.Sprintf("only version 3.x is supported, but client requested %d.%d", e.Requested.Major, e.Requested.Minor)
+}
+
+// Handshake returns the version whose handshake a peer asking for m follows: 3.3, 3.7, or 3.8. Unknown minor versions
+// below 3.7 must be treated as 3.3, and later ones follow 3.8, such as macOS Screen Sharing's 3.889. Versions other
+// than 3.x are returned as they are.
+func (m *ProtocolVersionMessage) Handshake() ProtocolVersionMessage {
+	switch {
+	case m.Major != 3:
+		return *m
+	case m.AtLeast(3, 8):
+		return ProtocolVersionMessage{Major: 3, Minor: 8}
+	case m.AtLeast(3, 7):
+		return ProtocolVersionMessage{Major: 3, Minor: 7}
+	default:
+		return ProtocolVersionMessage{Major: 3, Minor: 3}
+	}
+}
+
+// NegotiateVersion performs the server side of the version handshake: it offers RFB 3.8 and reads the version the
+// client asks for. It returns that version, which may be one this package doesn't know, and the version whose
+// handshake to follow (see Handshake). Clients that ask for a major version other than 3 get an
+// *UnsupportedVersionError, and should then be sent the reason with RefuseClient.
+//
+// The offer is written but not flushed if rw buffers writes, like Conn, whose Read flushes it.
+func NegotiateVersion(rw io.ReadWriter) (requested, handshake ProtocolVersionMessage, err error) {
+	offer := ProtocolVersionMessage{Major: 3, Minor: 8}
+	if err := offer.Write(rw); err != nil {
+		return requested, handshake, fmt.Errorf("write ProtocolVersion: %v", err)
+	}
+	if err := requested.Read(rw); err != nil {
+		return requested, handshake, fmt.Errorf("read ProtocolVersion: %v", err)
+	}
+	if requested.Major != 3 {
+		return requested, requested, &UnsupportedVersionError{requested}
+	}
+	return requested, requested.Handshake(), nil
+}
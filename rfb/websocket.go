@@ -0,0 +1,330 @@
+package rfb
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The GUID every WebSocket handshake hashes with the client's key. See RFC 6455.
+const webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket opcodes.
+const (
+	webSocketContinuation = 0x0
+	webSocketText         = 0x1
+	webSocketBinary       = 0x2
+	webSocketClose        = 0x8
+	webSocketPing         = 0x9
+	webSocketPong         = 0xa
+)
+
+// Frames larger than this are refused, since they'd have to be buffered whole.
+const maxWebSocketFrame = 1 << 20
+
+// WebSocket close status codes.
+const (
+	webSocketNormalClosure = 1000
+	webSocketGoingAway     = 1001 // The server is shutting down.
+)
+
+// How long closing a connection waits to send the close frame to a client that isn't reading.
+const webSocketCloseTimeout = time.Second
+
+// WebSocketListener accepts RFB connections carried in binary WebSocket messages, as browser viewers like noVNC send
+// them, so they can connect without a separate websockify proxy. It's an http.Handler that upgrades each request and a
+// net.Listener that returns the resulting connections, so it can be passed to Server.Serve.
+//
+// Browsers let any page open a WebSocket to any address, so only pages served from the host the request was sent to,
+// or from one of the allowed origins, can connect. Clients that aren't browsers don't send an Origin and aren't
+// checked.
+type WebSocketListener struct {
+	addr    net.Addr
+	origins []string     // Allowed besides the request's host. See allowedOrigin.
+	ln      net.Listener // Closed by Close, if ServeWebSocket was given it.
+	conns   chan net.Conn
+	closed  chan struct{}
+	once    sync.Once
+}
+
+// NewWebSocketListener returns a listener to mount in an HTTP server. Addr reports addr. Pages from origins, such as
+// "https://rps.example.com", can connect as well as ones from the same host; "*" allows any page.
+func NewWebSocketListener(addr net.Addr, origins []string) *WebSocketListener {
+	return &WebSocketListener{addr: addr, origins: origins, conns: make(chan net.Conn), closed: make(chan struct{})}
+}
+
+// ListenWebSocket listens for WebSocket connections on address, with any path, from pages on the same host or origins.
+func ListenWebSocket(network, address string, origins []string) (*WebSocketListener, error) {
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return ServeWebSocket(ln, origins), nil
+}
+
+// ServeWebSocket serves WebSocket connections arriving on ln, with any path, such as one wrapped in TLS, from pages on
+// the same host or origins. Closing the returned listener closes ln.
+func ServeWebSocket(ln net.Listener, origins []string) *WebSocketListener {
+	l := NewWebSocketListener(ln.Addr(), origins)
+	l.ln = ln
+	go http.Serve(ln, l)
+	return l
+}
+
+func (l *WebSocketListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, fmt.Errorf("accept on %v: listener closed", l.addr)
+	}
+}
+
+func (l *WebSocketListener) Close() error {
+	var err error
+	l.once.Do(func() {
+		close(l.closed)
+		if l.ln != nil {
+			err = l.ln.Close()
+		}
+	})
+	return err
+}
+
+func (l *WebSocketListener) Addr() net.Addr {
+	return l.addr
+}
+
+// ServeHTTP upgrades the request to a WebSocket and waits for Accept to take it.
+func (l *WebSocketListener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" || !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "Expected a WebSocket connection.", http.StatusBadRequest)
+		return
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "Unsupported WebSocket version.", http.StatusUpgradeRequired)
+		return
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "Missing Sec-WebSocket-Key.", http.StatusBadRequest)
+		return
+	}
+	if !l.allowedOrigin(r) {
+		http.Error(w, "Pages from this origin can't connect.", http.StatusForbidden)
+		return
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "Can't upgrade this connection.", http.StatusInternalServerError)
+		return
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	accept := sha1.Sum([]byte(key + webSocketGUID))
+	response := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(accept[:]) + "\r\n"
+	// Older noVNC versions ask for the binary subprotocol by name.
+	if headerContains(r.Header, "Sec-WebSocket-Protocol", "binary") {
+		response += "Sec-WebSocket-Protocol: binary\r\n"
+	}
+	if _, err := conn.Write([]byte(response + "\r\n")); err != nil {
+		conn.Close()
+		return
+	}
+
+	select {
+	case l.conns <- &webSocketConn{Conn: conn, r: rw.Reader, listenerClosed: l.closed}:
+	case <-l.closed:
+		conn.Close()
+	}
+}
+
+// allowedOrigin reports whether a request comes from a page that may connect: one served from the host the request
+// was sent to, or one of l.origins. Browsers always send an Origin, so requests without one are from other clients,
+// which are allowed.
+func (l *WebSocketListener) allowedOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, allowed := range l.origins {
+		if allowed == "*" || strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host != "" && strings.EqualFold(u.Host, r.Host)
+}
+
+// headerContains reports whether a comma-separated header has token, ignoring case.
+func headerContains(header http.Header, name, token string) bool {
+	for _, value := range header[http.CanonicalHeaderKey(name)] {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// webSocketConn reads the payloads of the client's messages as one stream and writes each Write as a binary message.
+type webSocketConn struct {
+	net.Conn
+	r *bufio.Reader
+
+	payload []byte // The unread part of the current frame.
+	closed  bool   // Whether the client has sent a close frame.
+
+	listenerClosed <-chan struct{} // Closed once the server is going away, for the close frame's status.
+
+	writeLock sync.Mutex // Held while writing a frame, since Read writes pongs.
+	sentClose bool       // Whether a close frame has been written. Guarded by writeLock.
+}
+
+func (c *webSocketConn) Read(p []byte) (int, error) {
+	for len(c.payload) == 0 {
+		if c.closed {
+			return 0, io.EOF
+		}
+		if err := c.readFrame(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, c.payload)
+	c.payload = c.payload[n:]
+	return n, nil
+}
+
+// readFrame reads the next frame, answering control frames, and keeps the payload of data frames.
+func (c *webSocketConn) readFrame() error {
+	var head [2]byte
+	if _, err := io.ReadFull(c.r, head[:]); err != nil {
+		return err
+	}
+	opcode := head[0] & 0x0f
+	if head[1]&0x80 == 0 {
+		return errors.New("WebSocket client sent an unmasked frame")
+	}
+	length := uint64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		var buf [2]byte
+		if _, err := io.ReadFull(c.r, buf[:]); err != nil {
+			return err
+		}
+		length = uint64(binary.BigEndian.Uint16(buf[:]))
+	case 127:
+		var buf [8]byte
+		if _, err := io.ReadFull(c.r, buf[:]); err != nil {
+			return err
+		}
+		length = binary.BigEndian.Uint64(buf[:])
+	}
+	if length > maxWebSocketFrame {
+		return fmt.Errorf("WebSocket frame too long: %d bytes > %d bytes", length, maxWebSocketFrame)
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(c.r, mask[:]); err != nil {
+		return err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+
+	switch opcode {
+	case webSocketBinary, webSocketContinuation:
+		c.payload = payload
+	case webSocketText:
+		return errors.New("WebSocket client sent text, but only binary messages carry RFB")
+	case webSocketClose:
+		c.closed = true
+		c.writeLock.Lock()
+		defer c.writeLock.Unlock()
+		if c.sentClose {
+			return nil // The client is answering ours.
+		}
+		c.sentClose = true
+		return c.writeFrameLocked(webSocketClose, payload)
+	case webSocketPing:
+		return c.writeFrame(webSocketPong, payload)
+	case webSocketPong:
+	default:
+		return fmt.Errorf("unknown WebSocket opcode %d", opcode)
+	}
+	return nil
+}
+
+func (c *webSocketConn) Write(p []byte) (int, error) {
+	if err := c.writeFrame(webSocketBinary, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close sends a close frame, unless one has been sent already, before closing the connection, so the browser knows the
+// server ended the session on purpose. If a write is stuck on a client that isn't reading, it closes without one.
+func (c *webSocketConn) Close() error {
+	if c.writeLock.TryLock() {
+		if !c.sentClose {
+			c.sentClose = true
+			status := webSocketNormalClosure
+			select {
+			case <-c.listenerClosed:
+				status = webSocketGoingAway
+			default:
+			}
+			c.Conn.SetWriteDeadline(time.Now().Add(webSocketCloseTimeout))
+			c.writeFrameLocked(webSocketClose, binary.BigEndian.AppendUint16(nil, uint16(status)))
+		}
+		c.writeLock.Unlock()
+	}
+	return c.Conn.Close()
+}
+
+// writeFrame writes one unmasked, unfragmented frame, as servers do.
+func (c *webSocketConn) writeFrame(opcode byte, payload []byte) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	return c.writeFrameLocked(opcode, payload)
+}
+
+// Assumes c.writeLock has been obtained.
+func (c *webSocketConn) writeFrameLocked(opcode byte, payload []byte) error {
+	head := make([]byte, 2, 10+len(payload))
+	head[0] = 0x80 | opcode
+	switch {
+	case len(payload) < 126:
+		head[1] = byte(len(payload))
+	case len(payload) <= 0xffff:
+		head[1] = 126
+		head = append(head, byte(len(payload)>>8), byte(len(payload)))
+	default:
+		head[1] = 127
+		var buf [8]byte
+		binary.BigEndian.PutUint64(buf[:], uint64(len(payload)))
+		head = append(head, buf[:]...)
+	}
+	_, err := c.Conn.Write(append(head, payload...))
+	return err
+}
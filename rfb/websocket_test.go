@@ -0,0 +1,201 @@
+package rfb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"image/color"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// webSocketClient frames a browser's side of a WebSocket connection.
+type webSocketClient struct {
+	net.Conn
+	r       *bufio.Reader
+	payload []byte
+}
+
+func dialWebSocket(t *testing.T, addr net.Addr) *webSocketClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	fmt.Fprintf(conn, "GET /websockify HTTP/1.1\r\nHost: %v\r\nUpgrade: websocket\r\nConnection: keep-alive, Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\nSec-WebSocket-Protocol: binary\r\n\r\n", addr)
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The example from RFC 6455.
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("got status %d and accept %q", resp.StatusCode, resp.Header.Get("Sec-WebSocket-Accept"))
+	}
+	if got := resp.Header.Get("Sec-WebSocket-Protocol"); got != "binary" {
+		t.Errorf("got subprotocol %q, want binary", got)
+	}
+	return &webSocketClient{Conn: conn, r: r}
+}
+
+func (c *webSocketClient) writeFrame(opcode byte, payload []byte) error {
+	frame := []byte{0x80 | opcode, 0x80 | 126, 0, 0, 1, 2, 3, 4}
+	binary.BigEndian.PutUint16(frame[2:], uint16(len(payload)))
+	for i, b := range payload {
+		frame = append(frame, b^frame[4+i%4])
+	}
+	_, err := c.Conn.Write(frame)
+	return err
+}
+
+func (c *webSocketClient) Write(p []byte) (int, error) {
+	if err := c.writeFrame(webSocketBinary, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *webSocketClient) readFrame() (byte, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.r, head[:]); err != nil {
+		return 0, nil, err
+	}
+	length := uint64(head[1])
+	switch length {
+	case 126:
+		var buf [2]byte
+		io.ReadFull(c.r, buf[:])
+		length = uint64(binary.BigEndian.Uint16(buf[:]))
+	case 127:
+		var buf [8]byte
+		io.ReadFull(c.r, buf[:])
+		length = binary.BigEndian.Uint64(buf[:])
+	}
+	payload := make([]byte, length)
+	_, err := io.ReadFull(c.r, payload)
+	return head[0] & 0x0f, payload, err
+}
+
+func (c *webSocketClient) Read(p []byte) (int, error) {
+	for len(c.payload) == 0 {
+		_, payload, err := c.readFrame()
+		if err != nil {
+			return 0, err
+		}
+		c.payload = payload
+	}
+	n := copy(p, c.payload)
+	c.payload = c.payload[n:]
+	return n, nil
+}
+
+func TestWebSocketListener(t *testing.T) {
+	l, err := ListenWebSocket("tcp", "127.0.0.1:0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	server := &Server{
+		Width: 4, Height: 3,
+		NewHandler: func(conn io.ReadWriter) (Handler, error) {
+			return &fillHandler{color: color.RGBA{0, 0, 0xff, 0xff}}, nil
+		},
+	}
+	go server.Serve(l)
+
+	conn := dialWebSocket(t, l.Addr())
+	defer conn.Close()
+
+	client, err := NewClient(conn, ClientConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Update(false); err != nil {
+		t.Fatal(err)
+	}
+	if got := client.Framebuffer.RGBAAt(3, 2); got != (color.RGBA{0, 0, 0xff, 0xff}) {
+		t.Errorf("got pixel %v, want blue", got)
+	}
+
+	// The server is idle after the update, so the next frame is the reply.
+	if err := conn.writeFrame(webSocketPing, []byte("hi")); err != nil {
+		t.Fatal(err)
+	}
+	if opcode, payload, err := conn.readFrame(); err != nil || opcode != webSocketPong || string(payload) != "hi" {
+		t.Fatalf("got opcode %d with %q, %v; want a pong", opcode, payload, err)
+	}
+}
+
+func TestWebSocketOrigin(t *testing.T) {
+	for _, test := range []struct {
+		origin  string
+		origins []string
+		want    int
+	}{
+		{"", nil, http.StatusSwitchingProtocols}, // Not a browser.
+		{"http://HOST", nil, http.StatusSwitchingProtocols},
+		{"https://HOST", nil, http.StatusSwitchingProtocols},
+		{"http://evil.example.com", nil, http.StatusForbidden},
+		{"null", nil, http.StatusForbidden},
+		{"https://novnc.example.com", []string{"https://novnc.example.com/"}, http.StatusSwitchingProtocols},
+		{"https://evil.example.com", []string{"https://novnc.example.com"}, http.StatusForbidden},
+		{"https://evil.example.com", []string{"*"}, http.StatusSwitchingProtocols},
+	} {
+		l, err := ListenWebSocket("tcp", "127.0.0.1:0", test.origins)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+		origin := strings.ReplaceAll(test.origin, "HOST", l.Addr().String())
+		request := fmt.Sprintf("GET / HTTP/1.1\r\nHost: %v\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+			"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n", l.Addr())
+		if origin != "" {
+			request += "Origin: " + origin + "\r\n"
+		}
+		fmt.Fprint(conn, request+"\r\n")
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != test.want {
+			t.Errorf("origin %q with %q allowed got status %d, want %d", origin, test.origins, resp.StatusCode, test.want)
+		}
+		conn.Close()
+		l.Close()
+	}
+}
+
+func TestWebSocketCloseFrame(t *testing.T) {
+	for _, test := range []struct {
+		listenerClosed bool
+		want           uint16
+	}{
+		{false, webSocketNormalClosure},
+		{true, webSocketGoingAway},
+	} {
+		serverConn, clientConn := net.Pipe()
+		listenerClosed := make(chan struct{})
+		if test.listenerClosed {
+			close(listenerClosed)
+		}
+		c := &webSocketConn{Conn: serverConn, r: bufio.NewReader(serverConn), listenerClosed: listenerClosed}
+		go c.Close()
+		client := &webSocketClient{Conn: clientConn, r: bufio.NewReader(clientConn)}
+		opcode, payload, err := client.readFrame()
+		if err != nil || opcode != webSocketClose || len(payload) != 2 || binary.BigEndian.Uint16(payload) != test.want {
+			t.Errorf("closing with the listener closed %v sent opcode %d with %v, %v; want a close frame with status %d",
+				test.listenerClosed, opcode, payload, err, test.want)
+		}
+		clientConn.Close()
+	}
+}
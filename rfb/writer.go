@@ -0,0 +1,109 @@
+package rfb
+
+import (
+	"time"
+)
+
+// updateWriter sends a connection's framebuffer updates from a goroutine of its own, so neither writing them to a slow
+// client nor waiting between them to keep to Server.MaxFPS holds up the client's input. It sends one batch at a time;
+// the serve goroutine doesn't render the next update until the last has been sent.
+type updateWriter struct {
+	c        *Conn
+	interval time.Duration // The least time between updates.
+	adaptive bool          // Whether to leave more time between updates that are slow to write.
+	batches  chan *updateBatch
+	done     chan error // Receives the result of each batch.
+	exited   chan struct{}
+	busy     bool // Whether a batch hasn't been sent yet. Only used by the serve goroutine.
+}
+
+// updateBatch is a framebuffer update and the messages that go before it.
+type updateBatch struct {
+	messages []ServerMessage
+	release  func() // If set, called once they've been sent, to recycle what they used.
+}
+
+// With AdaptiveFPS, a link is left idle after each update for this many times as long as updates have been taking to
+// write, so updates use at most about a third of its bandwidth...
+const adaptiveIdleFactor = 2
+
+// ...but updates are sent at least this often, so a client on a congested link still sees the game go on.
+const maxAdaptiveInterval = time.Second
+
+func newUpdateWriter(c *Conn, maxFPS int, adaptive bool) *updateWriter {
+	w := &updateWriter{
+		c:        c,
+		adaptive: adaptive,
+		batches:  make(chan *updateBatch),
+		done:     make(chan error, 1),
+		exited:   make(chan struct{}),
+	}
+	if maxFPS > 0 {
+		w.interval = time.Second / time.Duration(maxFPS)
+	}
+	go w.run()
+	return w
+}
+
+func (w *updateWriter) run() {
+	defer close(w.exited)
+	var nextFrameTime time.Time
+	for batch := range w.batches {
+		<-time.After(nextFrameTime.Sub(time.Now()))
+		start := time.Now()
+		var err error
+		for _, m := range batch.messages {
+			if err = w.c.WriteMessage(m); err != nil {
+				break
+			}
+		}
+		if batch.release != nil {
+			batch.release()
+		}
+		now := time.Now()
+		latency := w.c.countWriteLatency(now.Sub(start))
+		nextFrameTime = now.Add(w.nextInterval(latency))
+		w.done <- err
+	}
+}
+
+// nextInterval returns how long to wait before sending another update, given how long they've been taking to write.
+func (w *updateWriter) nextInterval(latency time.Duration) time.Duration {
+	interval := w.interval
+	if !w.adaptive || interval >= maxAdaptiveInterval {
+		return interval
+	}
+	if slow := adaptiveIdleFactor * latency; slow > interval {
+		interval = slow
+	}
+	if interval > maxAdaptiveInterval {
+		interval = maxAdaptiveInterval
+	}
+	return interval
+}
+
+// send starts sending batch. The writer must not be busy.
+func (w *updateWriter) send(batch *updateBatch) {
+	w.busy = true
+	w.batches <- batch
+}
+
+// sent is called with what the writer sent to done, and returns it.
+func (w *updateWriter) sent(err error) error {
+	w.busy = false
+	return err
+}
+
+// wait waits for the batch being sent, if any, and returns the error sending it.
+func (w *updateWriter) wait() error {
+	if !w.busy {
+		return nil
+	}
+	return w.sent(<-w.done)
+}
+
+// stop waits for the batch being sent, if any, and stops the writer's goroutine.
+func (w *updateWriter) stop() {
+	close(w.batches)
+	<-w.exited
+}
@@ -0,0 +1,79 @@
+package rfb
+
+import (
+	"image/color"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestServerHandlesInputWhileUpdatesArePaced(t *testing.T) {
+	handler := &fillHandler{color: color.White, keys: make(chan uint32, 1)}
+	server := &Server{
+		Width: 4, Height: 3,
+		MaxFPS:     1,
+		NewHandler: func(conn io.ReadWriter) (Handler, error) { return handler, nil },
+	}
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	go func() {
+		server.ServeConn(serverConn)
+		serverConn.Close()
+	}()
+	clientConn.SetDeadline(time.Now().Add(5 * time.Second))
+	client, err := NewClient(clientConn, ClientConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Update(false); err != nil {
+		t.Fatal(err)
+	}
+
+	// The next update isn't due for a second, and the client isn't reading it yet, but a key press gets through.
+	if err := client.RequestUpdate(true, client.Framebuffer.Bounds()); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.KeyEvent('a', true); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case key := <-handler.keys:
+		if key != 'a' {
+			t.Errorf("got key %q, want 'a'", key)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("key event waited for the paced update")
+	}
+
+	m, err := client.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.(*FramebufferUpdateMessage); !ok {
+		t.Errorf("got %T, want the paced *FramebufferUpdateMessage", m)
+	}
+}
+
+func TestUpdateWriterNextInterval(t *testing.T) {
+	for _, test := range []struct {
+		maxFPS   int
+		adaptive bool
+		latency  time.Duration
+		want     time.Duration
+	}{
+		{20, false, time.Second, 50 * time.Millisecond},
+		{20, true, time.Millisecond, 50 * time.Millisecond},
+		{20, true, 100 * time.Millisecond, 200 * time.Millisecond},
+		{20, true, 10 * time.Second, time.Second},
+		{0, true, 10 * time.Millisecond, 20 * time.Millisecond},
+	} {
+		w := &updateWriter{adaptive: test.adaptive}
+		if test.maxFPS > 0 {
+			w.interval = time.Second / time.Duration(test.maxFPS)
+		}
+		if got := w.nextInterval(test.latency); got != test.want {
+			t.Errorf("nextInterval(%v) with MaxFPS %d, AdaptiveFPS %v = %v, want %v", test.latency, test.maxFPS, test.adaptive, got, test.want)
+		}
+	}
+}
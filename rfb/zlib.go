@@ -0,0 +1,137 @@
+package rfb
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+)
+
+func init() {
+	RegisterEncoding(EncodingTypeZlib, func() Encoding { return &ZlibEncoder{} })
+}
+
+// ZlibEncoder sends raw pixels compressed with zlib. One stream spans the whole connection, flushed after each
+// rectangle, so later rectangles can refer back to earlier ones.
+type ZlibEncoder struct {
+	options EncodingOptions
+
+	zw   *zlib.Writer
+	out  bytes.Buffer
+	pix  []byte
+	head [4]byte
+}
+
+func (e *ZlibEncoder) Type() int32 {
+	return EncodingTypeZlib
+}
+
+// SetOptions sets the compression level. It has no effect once the first rectangle is sent, since the level can't
+// change mid-stream.
+func (e *ZlibEncoder) SetOptions(o EncodingOptions) {
+	e.options = o
+}
+
+func (e *ZlibEncoder) Encode(w io.Writer, pixelFormat PixelFormat, img image.Image, rect image.Rectangle) error {
+	if e.zw == nil {
+		zw, err := zlib.NewWriterLevel(&e.out, e.options.zlibLevel())
+		if err != nil {
+			return err
+		}
+		e.zw = zw
+	}
+	e.out.Reset()
+	e.pix = appendPixels(e.pix[:0], &pixelFormat, img, rect)
+	if _, err := e.zw.Write(e.pix); err != nil {
+		return err
+	}
+	if err := e.zw.Flush(); err != nil {
+		return err
+	}
+
+	binary.BigEndian.PutUint32(e.head[:], uint32(e.out.Len()))
+	if _, err := w.Write(e.head[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(e.out.Bytes())
+	return err
+}
+
+// Decoders holds the state some encodings keep for the whole connection, such as Zlib's stream, so they can be read.
+// Use one per connection.
+type Decoders struct {
+	zlib  zlibStream
+	zrle  zlibStream
+	tight [4]zlibStream
+}
+
+// One side of a zlib stream that's fed a chunk at a time.
+type zlibStream struct {
+	input bytes.Buffer
+	r     io.ReadCloser
+}
+
+// Appends a chunk of compressed data preceded by its 32-bit length to the stream, and returns the stream's reader.
+func (s *zlibStream) feed(r io.Reader, bo binary.ByteOrder, maxLength int) (io.Reader, error) {
+	head := readBuffer(r, 4)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, err
+	}
+	return s.feedN(r, int(bo.Uint32(head)), maxLength)
+}
+
+// Appends a chunk of compressed data of the given length to the stream, and returns the stream's reader.
+func (s *zlibStream) feedN(r io.Reader, length, maxLength int) (io.Reader, error) {
+	if length > maxLength {
+		return nil, fmt.Errorf("%d bytes of compressed data is more than %d bytes of pixels need", length, maxLength)
+	}
+	if _, err := io.CopyN(&s.input, r, int64(length)); err != nil {
+		return nil, err
+	}
+	if s.r == nil {
+		zr, err := zlib.NewReader(&s.input)
+		if err != nil {
+			return nil, fmt.Errorf("start zlib stream: %v", err)
+		}
+		s.r = zr
+	}
+	return s.r, nil
+}
+
+// Starts the stream over, for when the sender resets its compressor.
+func (s *zlibStream) reset() {
+	s.input.Reset()
+	s.r = nil
+}
+
+// Reads a Zlib rectangle's payload and returns it as raw pixels.
+func (d *Decoders) readZlib(r io.Reader, bo binary.ByteOrder, pixelFormat PixelFormat, width, height int) ([]byte, error) {
+	pixels := make([]byte, int(pixelFormat.BitsPerPixel/8)*width*height)
+	// Incompressible pixels grow by a few bytes per 64 KiB, so anything far past their size is garbage.
+	zr, err := d.zlib.feed(r, bo, len(pixels)+1024)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(zr, pixels); err != nil {
+		return nil, fmt.Errorf("decompress: %v", err)
+	}
+	return pixels, nil
+}
+
+// Reads a ZRLE rectangle's payload and returns it as raw pixels.
+func (d *Decoders) readZRLE(r io.Reader, bo binary.ByteOrder, pixelFormat PixelFormat, width, height int) ([]byte, error) {
+	// Raw tiles are the largest, at a byte per tile more than the pixels.
+	maxLength := int(pixelFormat.BitsPerPixel/8)*width*height + ((width+63)/64)*((height+63)/64) + 1024
+	zr, err := d.zrle.feed(r, bo, maxLength)
+	if err != nil {
+		return nil, err
+	}
+	tiles := &rleTileReader{r: zr, pixelFormat: pixelFormat}
+	pixels, err := tiles.readTiles(width, height, 64)
+	if err != nil {
+		return nil, fmt.Errorf("decompress: %v", err)
+	}
+	return pixels, nil
+}
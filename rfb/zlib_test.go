@@ -0,0 +1,46 @@
+package rfb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestZlibStreamSpansRectangles(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 4), uint8(y * 4), 0x80, 0xff})
+		}
+	}
+
+	encoder := &ZlibEncoder{}
+	var decoders Decoders
+	var sizes []int
+	// The same rectangle twice: the second should compress better, since the stream remembers the first.
+	for _, rect := range []image.Rectangle{image.Rect(0, 0, 64, 32), image.Rect(0, 32, 64, 64), image.Rect(0, 32, 64, 64)} {
+		var buf bytes.Buffer
+		if err := encoder.Encode(&buf, DefaultPixelFormat, img, rect); err != nil {
+			t.Fatal(err)
+		}
+		sizes = append(sizes, buf.Len())
+
+		pixels, err := decoders.readZlib(&buf, binary.BigEndian, DefaultPixelFormat, rect.Dx(), rect.Dy())
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded := &PixelFormatImage{Pix: pixels, Rect: rect, PixelFormat: DefaultPixelFormat}
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			for x := rect.Min.X; x < rect.Max.X; x++ {
+				if got, want := decoded.At(x, y).(PixelFormatColor).Pixel, DefaultPixelFormat.Pixel(img.At(x, y)); got != want {
+					t.Fatalf("%v: pixel (%d, %d) is %x, want %x", rect, x, y, got, want)
+				}
+			}
+		}
+	}
+	if sizes[2] >= sizes[1] {
+		t.Errorf("repeated rectangle took %d bytes after %d; is the stream being reset?", sizes[2], sizes[1])
+	}
+}
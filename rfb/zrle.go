@@ -0,0 +1,493 @@
+package rfb
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+	"sort"
+)
+
+func init() {
+	RegisterEncoding(EncodingTypeZRLE, func() Encoding { return &ZRLEEncoder{} })
+}
+
+// ZRLEEncoder splits rectangles into 64×64 tiles, each sent raw, as a solid color, as indexes into a small palette, or
+// run-length encoded, whichever is smallest, and compresses them all with one zlib stream for the whole connection.
+type ZRLEEncoder struct {
+	options EncodingOptions
+
+	zw     *zlib.Writer
+	out    bytes.Buffer
+	tiles  []byte
+	pixels []uint32
+	head   [4]byte
+}
+
+func (e *ZRLEEncoder) Type() int32 {
+	return EncodingTypeZRLE
+}
+
+// SetOptions sets the compression level. It has no effect once the first rectangle is sent, since the level can't
+// change mid-stream.
+func (e *ZRLEEncoder) SetOptions(o EncodingOptions) {
+	e.options = o
+}
+
+func (e *ZRLEEncoder) Encode(w io.Writer, pixelFormat PixelFormat, img image.Image, rect image.Rectangle) error {
+	if e.zw == nil {
+		zw, err := zlib.NewWriterLevel(&e.out, e.options.zlibLevel())
+		if err != nil {
+			return err
+		}
+		e.zw = zw
+	}
+	e.tiles = e.tiles[:0]
+	for ty := rect.Min.Y; ty < rect.Max.Y; ty += 64 {
+		for tx := rect.Min.X; tx < rect.Max.X; tx += 64 {
+			tile := image.Rect(tx, ty, tx+64, ty+64).Intersect(rect)
+			e.pixels = pixelValues(e.pixels[:0], &pixelFormat, img, tile)
+			e.tiles = appendRLETile(e.tiles, &pixelFormat, e.pixels, tile.Dx(), tile.Dy(), nil)
+		}
+	}
+
+	e.out.Reset()
+	if _, err := e.zw.Write(e.tiles); err != nil {
+		return err
+	}
+	if err := e.zw.Flush(); err != nil {
+		return err
+	}
+	binary.BigEndian.PutUint32(e.head[:], uint32(e.out.Len()))
+	if _, err := w.Write(e.head[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(e.out.Bytes())
+	return err
+}
+
+// Tile subencodings shared by ZRLE and TRLE. Packed palettes are 2 to 16, and palette RLE is 128 plus the palette size.
+// Only TRLE can reuse the previous tile's palette.
+const (
+	rleTileRaw             = 0
+	rleTileSolid           = 1
+	rleTileReusePacked     = 127
+	rleTilePlainRLE        = 128
+	rleTileReusePaletteRLE = 129
+	rleMaxPackedSize       = 16
+	rleMaxPaletteSize      = 127
+)
+
+// cpixelSize returns the size of a compressed pixel: 3 bytes when a 32-bit true color format only uses 24 bits, and
+// otherwise the same as a pixel.
+func (pf *PixelFormat) cpixelSize() int {
+	if pf.BitsPerPixel == 32 && pf.TrueColor && pf.BitDepth <= 24 && (pf.fitsLow24() || pf.fitsHigh24()) {
+		return 3
+	}
+	return int(pf.BitsPerPixel / 8)
+}
+
+func (pf *PixelFormat) colorBits() uint32 {
+	return uint32(pf.RedMax)<<pf.RedShift | uint32(pf.GreenMax)<<pf.GreenShift | uint32(pf.BlueMax)<<pf.BlueShift
+}
+
+func (pf *PixelFormat) fitsLow24() bool {
+	return pf.colorBits()&0xff000000 == 0
+}
+
+func (pf *PixelFormat) fitsHigh24() bool {
+	return pf.colorBits()&0xff == 0
+}
+
+func (pf *PixelFormat) appendCPixel(buf []byte, pixel uint32) []byte {
+	if pf.cpixelSize() != 3 {
+		return pf.appendPixel(buf, pixel)
+	}
+	if !pf.fitsLow24() {
+		pixel >>= 8
+	}
+	if pf.BigEndian {
+		return append(buf, uint8(pixel>>16), uint8(pixel>>8), uint8(pixel))
+	}
+	return append(buf, uint8(pixel), uint8(pixel>>8), uint8(pixel>>16))
+}
+
+// expandCPixel writes the pixel a compressed pixel represents to dst, in the pixel format's size and byte order.
+func (pf *PixelFormat) expandCPixel(dst, cpixel []byte) {
+	if len(cpixel) != 3 {
+		copy(dst, cpixel)
+		return
+	}
+	// The unused byte is the low one or the high one, which comes first or last depending on byte order.
+	if pf.fitsLow24() != pf.BigEndian {
+		copy(dst, cpixel)
+		dst[3] = 0
+	} else {
+		dst[0] = 0
+		copy(dst[1:], cpixel)
+	}
+}
+
+// appendRLETile appends a tile in whichever ZRLE/TRLE subencoding is smallest. pixels are width×height, row by row.
+//
+// If lastPalette is set, the tile may reuse the palette it holds, which is the previous tile's, and it's updated to
+// hold this tile's palette. Only TRLE can reuse palettes.
+func appendRLETile(buf []byte, pf *PixelFormat, pixels []uint32, width, height int, lastPalette *[]uint32) []byte {
+	var palette []uint32
+	index := map[uint32]uint8{}
+	for _, p := range pixels {
+		if _, ok := index[p]; !ok && len(palette) <= rleMaxPaletteSize {
+			index[p] = 0
+			palette = append(palette, p)
+		}
+	}
+	if len(palette) == 1 {
+		if lastPalette != nil {
+			*lastPalette = (*lastPalette)[:0]
+		}
+		return pf.appendCPixel(append(buf, rleTileSolid), palette[0])
+	}
+	// Sorted so tiles with the same colors have the same palette.
+	sort.Slice(palette, func(i, j int) bool { return palette[i] < palette[j] })
+	for i, p := range palette {
+		index[p] = uint8(i)
+	}
+	reuse := lastPalette != nil && len(palette) <= rleMaxPaletteSize && equalPalettes(palette, *lastPalette)
+	paletteSize := len(palette) * pf.cpixelSize()
+	if reuse {
+		paletteSize = 0
+	}
+
+	cpixel := pf.cpixelSize()
+	rawSize := len(pixels) * cpixel
+	plainSize, paletteRLESize := 0, 0
+	forEachRun(pixels, func(p uint32, length int) {
+		lengthSize := (length-1)/255 + 1
+		plainSize += cpixel + lengthSize
+		if length == 1 {
+			paletteRLESize++
+		} else {
+			paletteRLESize += 1 + lengthSize
+		}
+	})
+	packedSize := -1
+	bits := 0
+	if len(palette) <= rleMaxPackedSize {
+		switch {
+		case len(palette) == 2:
+			bits = 1
+		case len(palette) <= 4:
+			bits = 2
+		default:
+			bits = 4
+		}
+		packedSize = paletteSize + height*((width*bits+7)/8)
+	}
+	if len(palette) <= rleMaxPaletteSize {
+		paletteRLESize += paletteSize
+	} else {
+		paletteRLESize = -1
+	}
+
+	best := rawSize
+	for _, size := range []int{plainSize, packedSize, paletteRLESize} {
+		if size >= 0 && size < best {
+			best = size
+		}
+	}
+
+	if lastPalette != nil {
+		if best == packedSize || best == paletteRLESize {
+			*lastPalette = append((*lastPalette)[:0], palette...)
+		} else {
+			*lastPalette = (*lastPalette)[:0]
+		}
+	}
+
+	switch best {
+	case packedSize:
+		if reuse {
+			buf = append(buf, rleTileReusePacked)
+		} else {
+			buf = append(buf, uint8(len(palette)))
+			for _, p := range palette {
+				buf = pf.appendCPixel(buf, p)
+			}
+		}
+		for y := 0; y < height; y++ {
+			var b uint8
+			shift := 8
+			for x := 0; x < width; x++ {
+				shift -= bits
+				b |= index[pixels[y*width+x]] << uint(shift)
+				if shift == 0 {
+					buf = append(buf, b)
+					b, shift = 0, 8
+				}
+			}
+			if shift != 8 {
+				buf = append(buf, b)
+			}
+		}
+	case paletteRLESize:
+		if reuse {
+			buf = append(buf, rleTileReusePaletteRLE)
+		} else {
+			buf = append(buf, uint8(rleTilePlainRLE+len(palette)))
+			for _, p := range palette {
+				buf = pf.appendCPixel(buf, p)
+			}
+		}
+		forEachRun(pixels, func(p uint32, length int) {
+			if length == 1 {
+				buf = append(buf, index[p])
+			} else {
+				buf = appendRunLength(append(buf, index[p]|128), length)
+			}
+		})
+	case plainSize:
+		buf = append(buf, rleTilePlainRLE)
+		forEachRun(pixels, func(p uint32, length int) {
+			buf = appendRunLength(pf.appendCPixel(buf, p), length)
+		})
+	default:
+		buf = append(buf, rleTileRaw)
+		for _, p := range pixels {
+			buf = pf.appendCPixel(buf, p)
+		}
+	}
+	return buf
+}
+
+func equalPalettes(a, b []uint32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Calls visit for each run of identical pixels. Runs continue from one row to the next.
+func forEachRun(pixels []uint32, visit func(p uint32, length int)) {
+	for start := 0; start < len(pixels); {
+		end := start + 1
+		for end < len(pixels) && pixels[end] == pixels[start] {
+			end++
+		}
+		visit(pixels[start], end-start)
+		start = end
+	}
+}
+
+// A run's length, minus one, is the sum of its bytes, where every byte but the last is 255.
+func appendRunLength(buf []byte, length int) []byte {
+	for length -= 1; length >= 255; length -= 255 {
+		buf = append(buf, 255)
+	}
+	return append(buf, uint8(length))
+}
+
+// rleTileReader reads ZRLE and TRLE tiles into raw pixels. Use one per rectangle, since tiles can reuse the previous
+// tile's palette.
+type rleTileReader struct {
+	r           io.Reader
+	pixelFormat PixelFormat
+	buf         [4]byte
+	palette     [][]byte
+}
+
+func (t *rleTileReader) readByte() (uint8, error) {
+	if _, err := io.ReadFull(t.r, t.buf[:1]); err != nil {
+		return 0, err
+	}
+	return t.buf[0], nil
+}
+
+// Reads a compressed pixel into dst, which is one pixel long.
+func (t *rleTileReader) readCPixel(dst []byte) error {
+	cpixel := t.buf[:t.pixelFormat.cpixelSize()]
+	if _, err := io.ReadFull(t.r, cpixel); err != nil {
+		return err
+	}
+	t.pixelFormat.expandCPixel(dst, cpixel)
+	return nil
+}
+
+func (t *rleTileReader) readPalette(size int) error {
+	bytesPerPixel := int(t.pixelFormat.BitsPerPixel / 8)
+	t.palette = t.palette[:0]
+	for i := 0; i < size; i++ {
+		color := make([]byte, bytesPerPixel)
+		if err := t.readCPixel(color); err != nil {
+			return err
+		}
+		t.palette = append(t.palette, color)
+	}
+	return nil
+}
+
+func (t *rleTileReader) readRunLength() (int, error) {
+	length := 1
+	for {
+		b, err := t.readByte()
+		if err != nil {
+			return 0, err
+		}
+		length += int(b)
+		if b != 255 {
+			return length, nil
+		}
+	}
+}
+
+// readTiles reads a width×height rectangle's tiles and returns it as raw pixels.
+func (t *rleTileReader) readTiles(width, height, tileSize int) ([]byte, error) {
+	bytesPerPixel := int(t.pixelFormat.BitsPerPixel / 8)
+	pixels := make([]byte, bytesPerPixel*width*height)
+	for ty := 0; ty < height; ty += tileSize {
+		for tx := 0; tx < width; tx += tileSize {
+			tile := image.Rect(tx, ty, tx+tileSize, ty+tileSize).Intersect(image.Rect(0, 0, width, height))
+			if err := t.readTile(pixels, width, tile); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return pixels, nil
+}
+
+// Reads one tile into the tile portion of pixels, a raw rectangle with the given width.
+func (t *rleTileReader) readTile(pixels []byte, width int, tile image.Rectangle) error {
+	bytesPerPixel := int(t.pixelFormat.BitsPerPixel / 8)
+	count := tile.Dx() * tile.Dy()
+	// Returns the ith pixel of the tile, in raster order.
+	at := func(i int) []byte {
+		x, y := tile.Min.X+i%tile.Dx(), tile.Min.Y+i/tile.Dx()
+		offset := (y*width + x) * bytesPerPixel
+		return pixels[offset : offset+bytesPerPixel]
+	}
+
+	subencoding, err := t.readByte()
+	if err != nil {
+		return err
+	}
+	switch {
+	case subencoding == rleTileRaw:
+		for i := 0; i < count; i++ {
+			if err := t.readCPixel(at(i)); err != nil {
+				return err
+			}
+		}
+
+	case subencoding == rleTileSolid:
+		if err := t.readCPixel(at(0)); err != nil {
+			return err
+		}
+		for i := 1; i < count; i++ {
+			copy(at(i), at(0))
+		}
+
+	case subencoding <= rleMaxPackedSize:
+		if err := t.readPalette(int(subencoding)); err != nil {
+			return err
+		}
+		return t.readPacked(at, tile)
+
+	case subencoding == rleTileReusePacked:
+		if len(t.palette) < 2 || len(t.palette) > rleMaxPackedSize {
+			return fmt.Errorf("can't reuse a palette of %d colors for a packed tile", len(t.palette))
+		}
+		return t.readPacked(at, tile)
+
+	case subencoding == rleTilePlainRLE:
+		for i := 0; i < count; {
+			if err := t.readCPixel(at(i)); err != nil {
+				return err
+			}
+			length, err := t.readRunLength()
+			if err != nil {
+				return err
+			}
+			if i+length > count {
+				return fmt.Errorf("run of %d pixels overflows the tile", length)
+			}
+			for j := i + 1; j < i+length; j++ {
+				copy(at(j), at(i))
+			}
+			i += length
+		}
+
+	case subencoding == rleTileReusePaletteRLE:
+		if len(t.palette) == 0 {
+			return fmt.Errorf("can't reuse a palette before one is sent")
+		}
+		return t.readPaletteRLE(at, count)
+
+	case subencoding > rleTileReusePaletteRLE:
+		if err := t.readPalette(int(subencoding) - rleTilePlainRLE); err != nil {
+			return err
+		}
+		return t.readPaletteRLE(at, count)
+
+	default:
+		return fmt.Errorf("unsupported tile subencoding %d", subencoding)
+	}
+	return nil
+}
+
+// Reads a tile of packed palette indexes. at returns the ith pixel of the tile.
+func (t *rleTileReader) readPacked(at func(i int) []byte, tile image.Rectangle) error {
+	bits := 4
+	if len(t.palette) == 2 {
+		bits = 1
+	} else if len(t.palette) <= 4 {
+		bits = 2
+	}
+	row := make([]byte, (tile.Dx()*bits+7)/8)
+	for y := 0; y < tile.Dy(); y++ {
+		if _, err := io.ReadFull(t.r, row); err != nil {
+			return err
+		}
+		for x := 0; x < tile.Dx(); x++ {
+			bit := x * bits
+			index := int(row[bit/8]>>uint(8-bits-bit%8)) & (1<<uint(bits) - 1)
+			if index >= len(t.palette) {
+				return fmt.Errorf("palette index %d is past the palette's %d colors", index, len(t.palette))
+			}
+			copy(at(y*tile.Dx()+x), t.palette[index])
+		}
+	}
+	return nil
+}
+
+// Reads count pixels of palette RLE. at returns the ith pixel of the tile.
+func (t *rleTileReader) readPaletteRLE(at func(i int) []byte, count int) error {
+	for i := 0; i < count; {
+		b, err := t.readByte()
+		if err != nil {
+			return err
+		}
+		index, length := int(b&127), 1
+		if b&128 != 0 {
+			if length, err = t.readRunLength(); err != nil {
+				return err
+			}
+		}
+		if index >= len(t.palette) {
+			return fmt.Errorf("palette index %d is past the palette's %d colors", index, len(t.palette))
+		}
+		if i+length > count {
+			return fmt.Errorf("run of %d pixels overflows the tile", length)
+		}
+		for j := i; j < i+length; j++ {
+			copy(at(j), t.palette[index])
+		}
+		i += length
+	}
+	return nil
+}
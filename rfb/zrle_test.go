@@ -0,0 +1,141 @@
+package rfb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/draw"
+	"math/rand"
+	"testing"
+)
+
+// An image with a tile for each ZRLE/TRLE subencoding.
+func rleTestImage() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 200, 140))
+	// Solid.
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.White), image.ZP, draw.Src)
+
+	// Packed palette.
+	for y := 0; y < 64; y++ {
+		for x := 64; x < 128; x += 3 {
+			img.Set(x, y, color.RGBA{uint8(x % 5 * 50), 0, 0, 0xff})
+		}
+	}
+
+	// Raw.
+	rng := rand.New(rand.NewSource(1))
+	for y := 0; y < 64; y++ {
+		for x := 128; x < 192; x++ {
+			img.Set(x, y, color.RGBA{uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256)), 0xff})
+		}
+	}
+
+	// Plain RLE: too many colors for a palette, but in long runs.
+	for y := 64; y < 128; y++ {
+		for x := 0; x < 64; x++ {
+			img.Set(x, y, color.RGBA{uint8(y), uint8(x / 16), 0, 0xff})
+		}
+	}
+
+	// Palette RLE: too many colors to pack, in short runs.
+	for y := 64; y < 128; y++ {
+		for x := 64; x < 128; x++ {
+			img.Set(x, y, color.RGBA{0, uint8((x/3 + y) % 20 * 10), 0, 0xff})
+		}
+	}
+	return img
+}
+
+func checkDecoded(t *testing.T, name string, pixels []byte, img image.Image, rect image.Rectangle, pixelFormat PixelFormat) {
+	t.Helper()
+	decoded := &PixelFormatImage{Pix: pixels, Rect: rect, PixelFormat: pixelFormat}
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			if got, want := decoded.At(x, y).(PixelFormatColor).Pixel, pixelFormat.Pixel(img.At(x, y)); got != want {
+				t.Fatalf("%s: pixel (%d, %d) is %x, want %x", name, x, y, got, want)
+			}
+		}
+	}
+}
+
+func TestZRLERoundTrip(t *testing.T) {
+	img := rleTestImage()
+	bgr233 := PixelFormat{BitsPerPixel: 8, BitDepth: 8, TrueColor: true, RedMax: 7, GreenMax: 7, BlueMax: 3, GreenShift: 3, BlueShift: 6}
+	rgb565 := PixelFormat{BitsPerPixel: 16, BitDepth: 16, TrueColor: true, RedMax: 31, GreenMax: 63, BlueMax: 31, RedShift: 11, GreenShift: 5}
+	high24 := PixelFormat{BitsPerPixel: 32, BitDepth: 24, BigEndian: true, TrueColor: true, RedMax: 255, GreenMax: 255, BlueMax: 255, RedShift: 24, GreenShift: 16, BlueShift: 8}
+	bigEndian := DefaultPixelFormat
+	bigEndian.BigEndian = true
+
+	for _, pixelFormat := range []PixelFormat{DefaultPixelFormat, bigEndian, high24, rgb565, bgr233} {
+		encoder := &ZRLEEncoder{}
+		var decoders Decoders
+		for _, rect := range []image.Rectangle{img.Bounds(), image.Rect(3, 5, 150, 100)} {
+			var buf bytes.Buffer
+			if err := encoder.Encode(&buf, pixelFormat, img, rect); err != nil {
+				t.Fatal(err)
+			}
+			pixels, err := decoders.readZRLE(&buf, binary.BigEndian, pixelFormat, rect.Dx(), rect.Dy())
+			if err != nil {
+				t.Fatalf("%d bpp: %v", pixelFormat.BitsPerPixel, err)
+			}
+			checkDecoded(t, "ZRLE", pixels, img, rect, pixelFormat)
+		}
+	}
+}
+
+func TestRLETileSubencodings(t *testing.T) {
+	img := rleTestImage()
+	pf := DefaultPixelFormat
+	for _, test := range []struct {
+		tile image.Rectangle
+		want uint8
+	}{
+		{image.Rect(0, 0, 64, 64), rleTileSolid},
+		{image.Rect(64, 0, 128, 64), 6},
+		{image.Rect(128, 0, 192, 64), rleTileRaw},
+		{image.Rect(0, 64, 64, 128), rleTilePlainRLE},
+		{image.Rect(64, 64, 128, 128), rleTilePlainRLE + 20},
+	} {
+		pixels := pixelValues(nil, &pf, img, test.tile)
+		if got := appendRLETile(nil, &pf, pixels, 64, 64, nil)[0]; got != test.want {
+			t.Errorf("tile %v has subencoding %d, want %d", test.tile, got, test.want)
+		}
+	}
+}
+
+func TestTRLERoundTrip(t *testing.T) {
+	img := rleTestImage()
+	// Two-color tiles with the same colors, so later ones reuse the first one's palette.
+	for y := 128; y < 140; y++ {
+		for x := 0; x < 200; x += 2 {
+			img.Set(x, y, color.Black)
+		}
+	}
+	rect := image.Rect(1, 2, 200, 140)
+
+	var buf bytes.Buffer
+	if err := (&TRLEEncoder{}).Encode(&buf, DefaultPixelFormat, img, rect); err != nil {
+		t.Fatal(err)
+	}
+	withoutReuse := 0
+	pf := DefaultPixelFormat
+	for ty := rect.Min.Y; ty < rect.Max.Y; ty += 16 {
+		for tx := rect.Min.X; tx < rect.Max.X; tx += 16 {
+			tile := image.Rect(tx, ty, tx+16, ty+16).Intersect(rect)
+			withoutReuse += len(appendRLETile(nil, &pf, pixelValues(nil, &pf, img, tile), tile.Dx(), tile.Dy(), nil))
+		}
+	}
+	if buf.Len() >= withoutReuse {
+		t.Errorf("encoded %d bytes, but tiles take %d without reusing palettes", buf.Len(), withoutReuse)
+	}
+	tiles := &rleTileReader{r: &buf, pixelFormat: DefaultPixelFormat}
+	pixels, err := tiles.readTiles(rect.Dx(), rect.Dy(), 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("%d bytes left over after decoding", buf.Len())
+	}
+	checkDecoded(t, "TRLE", pixels, img, rect, DefaultPixelFormat)
+}
@@ -0,0 +1,251 @@
+package vncrps
+
+import (
+	"fmt"
+	"github.com/alltom/vncrps/game"
+	"github.com/alltom/vncrps/rfb"
+	"image"
+	"image/draw"
+	"io"
+	"sync"
+)
+
+// Past this many rooms, players can only join one that's there. They all fit in the lobby at its smallest.
+const maxRooms = 8
+
+// Room is one game players can join from the lobby, with its own players, rankings, and rounds.
+type Room struct {
+	Name string
+	Game *game.GameServer
+
+	players int // Players in the room, counted by the lobby, which removes rooms players made once they're empty.
+}
+
+// Lobby is the rooms players choose between when Config.Rooms is set. The first room is always there; rooms players
+// make are removed once everyone has left them. Rooms are joined and left through the lobby so it can count players.
+type Lobby struct {
+	lock     sync.Mutex
+	rooms    []*Room
+	nextRoom int           // Numbers the next room's name.
+	changed  chan struct{} // Closed and replaced whenever rooms come and go or players move between them.
+
+	newGame    func(room int) *game.GameServer
+	maxPlayers int // If set, how many players each room holds.
+}
+
+func newLobby(first *game.GameServer, newGame func(room int) *game.GameServer, maxPlayers int) *Lobby {
+	return &Lobby{
+		rooms:      []*Room{{Name: "Main", Game: first}},
+		nextRoom:   2,
+		changed:    make(chan struct{}),
+		newGame:    newGame,
+		maxPlayers: maxPlayers,
+	}
+}
+
+// Rooms returns the rooms, in the order they were made.
+func (l *Lobby) Rooms() []*Room {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return append([]*Room{}, l.rooms...)
+}
+
+// roomListing is a room as the lobby screen shows it.
+type roomListing struct {
+	room    *Room
+	players int
+	full    bool
+}
+
+func (l *Lobby) listings() []roomListing {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	var listings []roomListing
+	for _, room := range l.rooms {
+		listings = append(listings, roomListing{room, room.players, l.fullLocked(room)})
+	}
+	return listings
+}
+
+func (l *Lobby) fullLocked(room *Room) bool {
+	return l.maxPlayers > 0 && room.players >= l.maxPlayers
+}
+
+// Changed returns a channel that's closed the next time a room is made or removed, or a player joins or leaves one.
+func (l *Lobby) Changed() <-chan struct{} {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.changed
+}
+
+// Assumes l.lock has been obtained.
+func (l *Lobby) notify() {
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// create makes a room, unless there are already maxRooms.
+func (l *Lobby) create() (*Room, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if len(l.rooms) >= maxRooms {
+		return nil, fmt.Errorf("there are already %d rooms", len(l.rooms))
+	}
+	room := &Room{Name: fmt.Sprintf("Room %d", l.nextRoom), Game: l.newGame(l.nextRoom)}
+	l.nextRoom++
+	l.rooms = append(l.rooms, room)
+	l.notify()
+	return room, nil
+}
+
+// join adds a player to room, unless it's full or has been removed.
+func (l *Lobby) join(room *Room) (game.PlayerId, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if !l.hasLocked(room) {
+		return 0, fmt.Errorf("%s is gone", room.Name)
+	}
+	if l.fullLocked(room) {
+		return 0, fmt.Errorf("%s is full", room.Name)
+	}
+	room.players++
+	l.notify()
+	return room.Game.AddPlayer(), nil
+}
+
+// leave removes a player from room, and the room too if it's empty and isn't the first.
+func (l *Lobby) leave(room *Room, playerId game.PlayerId) {
+	room.Game.RemovePlayer(playerId)
+	l.left(room)
+}
+
+// left counts a player out of room, removing it if it's empty and isn't the first, without removing them from its
+// game, such as when they've rejoined it on another connection.
+func (l *Lobby) left(room *Room) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	room.players--
+	if room.players == 0 && room != l.rooms[0] {
+		for i, r := range l.rooms {
+			if r == room {
+				l.rooms = append(l.rooms[:i], l.rooms[i+1:]...)
+				break
+			}
+		}
+	}
+	l.notify()
+}
+
+func (l *Lobby) hasLocked(room *Room) bool {
+	for _, r := range l.rooms {
+		if r == room {
+			return true
+		}
+	}
+	return false
+}
+
+// drawLobby lists the rooms, with a button to join each and one to make another.
+func (ui *UI) drawLobby(img draw.Image, pointerEvent *rfb.PointerEventMessage) {
+	width, height := ui.size.X, ui.size.Y
+	if ui.closing {
+		ui.label("THE SERVER IS SHUTTING DOWN", image.Rect(8, 8, width-8, 24), img)
+		ui.label("Thanks for playing!", image.Rect(8, 32, width-8, 48), img)
+		return
+	}
+	ui.label("PICK A ROOM", image.Rect(8, 8, width-8, 24), img)
+
+	listings := ui.lobby.listings()
+	y := 32
+	for i, listing := range listings {
+		ui.label(fmt.Sprintf("%s: %d playing", listing.room.Name, listing.players), image.Rect(8, y+8, 154, y+24), img)
+		if listing.full {
+			ui.label("full", image.Rect(170, y+8, 231, y+24), img)
+		} else if ui.button(&ui.joinButtons[i], "join", image.Rect(162, y, 231, y+24), img, pointerEvent) {
+			ui.join(listing.room)
+			return
+		}
+		y += 28
+	}
+
+	if len(listings) < maxRooms && ui.button(&ui.newRoomButton, "new room", image.Rect(8, height-56, 108, height-32), img, pointerEvent) {
+		room, err := ui.lobby.create()
+		if err != nil {
+			ui.lobbyError = err.Error()
+			return
+		}
+		ui.join(room)
+		return
+	}
+	if ui.lobbyError != "" {
+		ui.label(ui.lobbyError, image.Rect(8, height-24, width-8, height-8), img)
+	}
+}
+
+// join moves the player from the lobby into room, unless it's full.
+func (ui *UI) join(room *Room) {
+	playerId, err := ui.lobby.join(room)
+	if err != nil {
+		ui.lobbyError = err.Error()
+		return
+	}
+	ui.server, ui.playerId, ui.room = room.Game, playerId, room
+	ui.lobbyError = ""
+	ui.scrollRows = 0
+	ui.bellPhase = game.PhaseWaiting
+	ui.summarizedRound = 0
+	if state, err := room.Game.GetState(playerId); err == nil && state.LastRound != nil {
+		ui.summarizedRound = state.LastRound.Round // Only summarize rounds the player saw.
+	}
+	if ui.bindings != DefaultInputBindings {
+		ui.saveBindings() // The ones they chose in the lobby.
+	}
+	if ui.name != "" {
+		ui.typedName = ui.name
+		ui.rename(ui.name) // Has them type another if someone in this room has it.
+	}
+	if ui.joined != nil {
+		ui.joined(room, playerId)
+	}
+}
+
+// leaveRoom takes the player out of their room and back to the lobby.
+func (ui *UI) leaveRoom() {
+	ui.lobby.leave(ui.room, ui.playerId)
+	ui.server, ui.playerId, ui.room = nil, 0, nil
+	ui.settingsOpen, ui.rebinding = false, nil
+	if ui.joined != nil {
+		ui.joined(nil, 0)
+	}
+}
+
+// newLobbyUI returns the UI for a viewer that connected while Config.Rooms is set, and keeps conn tagged with the
+// room and player they're in.
+func (s *Server) newLobbyUI(conn io.ReadWriter) *UI {
+	ui := NewLobbyUI(s.lobby)
+	ui.SendRoundSummaries = s.config.RoundSummaries
+	ui.CountdownStyle = s.config.CountdownStyle
+	tc, ok := conn.(*trackedConn)
+	if !ok {
+		return ui
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	tc.ui = ui
+	tc.log.Info("entered the lobby")
+	ui.joined = func(room *Room, playerId game.PlayerId) {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		if room == nil {
+			tc.game, tc.player, tc.room = nil, 0, ""
+			tc.retag()
+			tc.log.Info("went back to the lobby")
+			return
+		}
+		tc.game, tc.player, tc.room = room.Game, playerId, room.Name
+		tc.retag()
+		tc.log.Info("joined a room")
+	}
+	ui.rejoined = func(playerId game.PlayerId) { s.rejoined(tc, playerId) }
+	return ui
+}
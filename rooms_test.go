@@ -0,0 +1,101 @@
+package vncrps
+
+import (
+	"github.com/alltom/vncrps/game"
+	"github.com/alltom/vncrps/rfb"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestLobby(maxPlayers int) *Lobby {
+	return newLobby(game.NewGameServer(time.Now, 1), func(room int) *game.GameServer {
+		return game.NewGameServer(time.Now, int64(room))
+	}, maxPlayers)
+}
+
+func TestLobby(t *testing.T) {
+	lobby := newTestLobby(1)
+	main := lobby.Rooms()[0]
+	if _, err := lobby.join(main); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lobby.join(main); err == nil {
+		t.Error("joined a full room")
+	}
+
+	room, err := lobby.create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if room.Name != "Room 2" {
+		t.Errorf("made %q, want Room 2", room.Name)
+	}
+	changed := lobby.Changed()
+	playerId, err := lobby.join(room)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-changed:
+	default:
+		t.Error("lobby didn't change when a player joined a room")
+	}
+	if standings := room.Game.Standings(); len(standings) != 1 || standings[0].PlayerId != playerId {
+		t.Errorf("room's standings are %v, want just player %d", standings, playerId)
+	}
+
+	lobby.leave(room, playerId)
+	if rooms := lobby.Rooms(); len(rooms) != 1 || rooms[0] != main {
+		t.Errorf("rooms are %v after the last player left Room 2, want just Main", rooms)
+	}
+	if _, err := lobby.join(room); err == nil {
+		t.Error("joined a room that was removed")
+	}
+
+	for i := 1; i < maxRooms; i++ {
+		if _, err := lobby.create(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := lobby.create(); err == nil {
+		t.Errorf("made more than %d rooms", maxRooms)
+	}
+}
+
+func TestLobbyUI(t *testing.T) {
+	lobby := newTestLobby(0)
+	ui := NewLobbyUI(lobby)
+	var joined []string
+	ui.joined = func(room *Room, playerId game.PlayerId) {
+		if room == nil {
+			joined = append(joined, "lobby")
+		} else {
+			joined = append(joined, room.Name)
+		}
+	}
+	click := func(x, y uint16) {
+		ui.PointerEvent(&rfb.PointerEventMessage{ButtonMask: rfb.ButtonLeft, X: x, Y: y})
+		ui.PointerEvent(&rfb.PointerEventMessage{X: x, Y: y})
+	}
+
+	click(200, 40) // Main's join button.
+	if ui.room == nil || ui.room.Name != "Main" {
+		t.Fatalf("in room %v after clicking Main's join button, want Main", ui.room)
+	}
+	click(120, UIHeight-48) // The lobby button.
+	if ui.room != nil {
+		t.Fatalf("in room %v after clicking the lobby button, want the lobby", ui.room.Name)
+	}
+	click(50, UIHeight-48) // The new room button.
+	if ui.room == nil || ui.room.Name != "Room 2" {
+		t.Fatalf("in room %v after clicking the new room button, want Room 2", ui.room)
+	}
+	ui.Close()
+	if rooms := lobby.Rooms(); len(rooms) != 1 {
+		t.Errorf("%d rooms after the only player in Room 2 disconnected, want 1", len(rooms))
+	}
+	if want := []string{"Main", "lobby", "Room 2"}; !reflect.DeepEqual(joined, want) {
+		t.Errorf("joined %v, want %v", joined, want)
+	}
+}
@@ -0,0 +1,78 @@
+package vncrps
+
+import (
+	"fmt"
+	"github.com/alltom/vncrps/game"
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// DrawScene draws the game as seen by a spectator: the phase, this round's matchups while players are picking and the
+// last round's results otherwise, and the rankings. It's the same size as a player's UI. state should come from
+// GameServer.Overview.
+func DrawScene(img draw.Image, state *game.GameState, countdownStyle CountdownStyle) {
+	drawScene(img, image.Pt(UIWidth, UIHeight), state, countdownStyle, 0)
+}
+
+// drawScene is DrawScene for a framebuffer of any size at least a player's UI's, such as one tall enough for all the
+// rankings, with the rankings scrolled past scrollRows rows, which clampScroll should have kept in range.
+func drawScene(img draw.Image, size image.Point, state *game.GameState, countdownStyle CountdownStyle, scrollRows int) {
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.White), image.ZP, draw.Src)
+
+	y := 8
+	splitX := (size.X + RankingsSplitX) / 2
+	for _, player := range state.Rankings[scrollRows:] {
+		label(player.Name, image.Rect(RankingsSplitX+8, y, splitX-8, y+8), img)
+		label(fmt.Sprintf("%d", player.Rank), image.Rect(splitX, y, size.X-8, y+8), img)
+		y += 16
+	}
+
+	switch state.Phase {
+	case game.PhaseWaiting:
+		label("Waiting for players...", image.Rect(8, 8, RankingsSplitX-8, 24), img)
+	case game.PhasePicking:
+		draw.Draw(img, image.Rect(0, 0, RankingsSplitX, size.Y), image.NewUniform(color.RGBA{0xff, 0xff, 0, 0xff}), image.ZP, draw.Src)
+		label("PLAYERS ARE CHOOSING", image.Rect(8, 8, RankingsSplitX-8, 24), img)
+		label(fmt.Sprintf("%s left...", countdownStyle.Format(state.TimeLeftInPhase)), image.Rect(8, 32, RankingsSplitX-8, 48), img)
+	case game.PhaseReview:
+		label("RESULTS", image.Rect(8, 8, RankingsSplitX-8, 24), img)
+	}
+
+	var heading string
+	var lines []string
+	if state.Matchups != nil {
+		heading = "This round:"
+		for _, m := range state.Matchups {
+			lines = append(lines, fmt.Sprintf("%s%s vs %s%s", m.Players[0].Name, pickedMark(m.Picked[0]), m.Players[1].Name, pickedMark(m.Picked[1])))
+		}
+	} else if round := state.LastRound; round != nil {
+		heading = fmt.Sprintf("Round %d:", round.Round)
+		for _, m := range round.Matchups {
+			lines = append(lines, m.String())
+		}
+	}
+	if heading != "" {
+		label(heading, image.Rect(8, 64, RankingsSplitX-8, 80), img)
+		y := 88
+		for _, line := range lines {
+			if y > size.Y-48 {
+				break
+			}
+			label(line, image.Rect(8, y, RankingsSplitX-8, y+16), img)
+			y += 16
+		}
+	}
+
+	if state.Announcement != "" {
+		label(state.Announcement, image.Rect(8, size.Y-24, size.X-8, size.Y-8), img)
+	}
+}
+
+// pickedMark follows the name of a player who has picked a move, without saying which.
+func pickedMark(picked bool) string {
+	if picked {
+		return " (ready)"
+	}
+	return ""
+}
@@ -0,0 +1,230 @@
+package vncrps
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+)
+
+// Levels of SecurityFinding.
+const (
+	SecurityOK      = "ok"
+	SecurityWarning = "warning"
+	SecurityRefused = "refused" // NewServer fails unless Config.AllowInsecure is set.
+)
+
+// SecurityFinding is one observation about how exposed a configuration leaves the server.
+type SecurityFinding struct {
+	Level   string `json:"level"`
+	Message string `json:"message"`
+	Fix     string `json:"fix,omitempty"`
+}
+
+func (f SecurityFinding) String() string {
+	s := fmt.Sprintf("%s: %s", f.Level, f.Message)
+	if f.Fix != "" {
+		s += " Fix: " + f.Fix
+	}
+	return s
+}
+
+// CheckSecurity reviews a configuration for ways it exposes the server more than intended.
+func CheckSecurity(config Config) []SecurityFinding {
+	var findings []SecurityFinding
+
+	loopback, err := isLoopbackAddr(config.Addr)
+	if err != nil {
+		return []SecurityFinding{{Level: SecurityRefused, Message: fmt.Sprintf("Can't tell who can reach %q: %v.", config.Addr, err)}}
+	}
+	authenticated := config.Password != ""
+
+	switch {
+	case loopback && config.Tunnel != nil:
+		findings = append(findings, SecurityFinding{Level: SecurityOK, Message: "Only reachable through SSH tunnels and from this machine."})
+	case loopback:
+		findings = append(findings, SecurityFinding{Level: SecurityOK, Message: fmt.Sprintf("Only reachable from this machine, on %v.", config.Addr)})
+	case !authenticated:
+		findings = append(findings, SecurityFinding{
+			Level:   SecurityRefused,
+			Message: fmt.Sprintf("Anyone who can reach %v can connect and send input without a password.", config.Addr),
+			Fix:     "Set a username and password, listen on 127.0.0.1 and host over SSH, or explicitly allow insecure configurations.",
+		})
+	default:
+		findings = append(findings, SecurityFinding{Level: SecurityOK, Message: fmt.Sprintf("Reachable on %v, but players must log in.", config.Addr)})
+	}
+
+	for _, addr := range config.Listen {
+		if strings.HasPrefix(addr, "unix:") {
+			findings = append(findings, SecurityFinding{
+				Level:   SecurityWarning,
+				Message: fmt.Sprintf("Any local user who can write to %v can play, as can anyone a proxy in front of it lets through.", strings.TrimPrefix(addr, "unix:")),
+				Fix:     "Put the socket in a directory only the server's and proxy's users can access, and check who the proxy accepts.",
+			})
+			continue
+		}
+		extraLoopback, err := isLoopbackAddr(addr)
+		switch {
+		case err != nil:
+			findings = append(findings, SecurityFinding{Level: SecurityRefused, Message: fmt.Sprintf("Can't tell who can reach %q: %v.", addr, err)})
+		case !extraLoopback && !authenticated:
+			findings = append(findings, SecurityFinding{
+				Level:   SecurityRefused,
+				Message: fmt.Sprintf("Anyone who can reach %v can connect and send input without a password.", addr),
+				Fix:     "Set a username and password, listen on 127.0.0.1, or explicitly allow insecure configurations.",
+			})
+		default:
+			findings = append(findings, SecurityFinding{Level: SecurityOK, Message: fmt.Sprintf("Players can also connect on %v.", addr)})
+		}
+		loopback = loopback && extraLoopback
+	}
+
+	for _, addr := range []string{config.WebSocketAddr, config.HTTPAddr} {
+		if addr == "" {
+			continue
+		}
+		webLoopback, err := isLoopbackAddr(addr)
+		switch {
+		case err != nil:
+			findings = append(findings, SecurityFinding{Level: SecurityRefused, Message: fmt.Sprintf("Can't tell who can reach %q: %v.", addr, err)})
+		case !webLoopback && !authenticated:
+			findings = append(findings, SecurityFinding{
+				Level:   SecurityRefused,
+				Message: fmt.Sprintf("Anyone who can reach %v can connect from a browser and send input without a password.", addr),
+				Fix:     "Set a username and password, serve browsers on 127.0.0.1, or explicitly allow insecure configurations.",
+			})
+		default:
+			findings = append(findings, SecurityFinding{Level: SecurityOK, Message: fmt.Sprintf("Browsers can connect over WebSocket on %v.", addr)})
+		}
+		loopback = loopback && webLoopback
+	}
+
+	if addr := config.SpectatorAddr; addr != "" && !strings.HasPrefix(addr, "unix:") {
+		spectatorLoopback, err := isLoopbackAddr(addr)
+		switch {
+		case err != nil:
+			findings = append(findings, SecurityFinding{Level: SecurityRefused, Message: fmt.Sprintf("Can't tell who can reach %q: %v.", addr, err)})
+		case !spectatorLoopback && !authenticated:
+			findings = append(findings, SecurityFinding{
+				Level:   SecurityWarning,
+				Message: fmt.Sprintf("Anyone who can reach %v can watch the game without a password, though not play.", addr),
+				Fix:     "Set a username and password, or firewall the port so only the screens you're projecting on can reach it.",
+			})
+		default:
+			findings = append(findings, SecurityFinding{Level: SecurityOK, Message: fmt.Sprintf("Spectators can watch on %v.", addr)})
+		}
+		loopback = loopback && spectatorLoopback
+	}
+
+	if !loopback && config.TLS == nil {
+		// Apple Remote Desktop authentication encrypts the credentials, but nothing encrypts the session after it.
+		findings = append(findings, SecurityFinding{
+			Level:   SecurityWarning,
+			Message: "Connections aren't encrypted, so anyone on the network path can watch the game and players' input.",
+			Fix:     "Listen on 127.0.0.1 and host over SSH, or use TLS.",
+		})
+		if authenticated {
+			// Its DES challenge and response are enough to try passwords offline, and it only checks 8 characters.
+			findings = append(findings, SecurityFinding{
+				Level:   SecurityWarning,
+				Message: "Viewers that log in with VNC authentication can have the password cracked by anyone who sees their login.",
+				Fix:     "Listen on 127.0.0.1 and host over SSH, or use TLS.",
+			})
+		}
+	}
+
+	if config.AdminSocket != "" {
+		findings = append(findings, SecurityFinding{
+			Level:   SecurityWarning,
+			Message: fmt.Sprintf("Any local user who can write to %v can use the admin API.", config.AdminSocket),
+			Fix:     "Put the socket in a directory only the server's user can access.",
+		})
+	}
+
+	if addr := config.ConsoleAddr; strings.HasPrefix(addr, "unix:") {
+		findings = append(findings, SecurityFinding{
+			Level:   SecurityWarning,
+			Message: fmt.Sprintf("Any local user who can write to %v can run the game from the admin console.", strings.TrimPrefix(addr, "unix:")),
+			Fix:     "Put the socket in a directory only the server's user can access.",
+		})
+	} else if addr != "" {
+		if consoleLoopback, err := isLoopbackAddr(addr); err != nil || !consoleLoopback {
+			findings = append(findings, SecurityFinding{
+				Level:   SecurityRefused,
+				Message: fmt.Sprintf("Anyone who can reach %v and knows the players' password can kick and rename players from the admin console.", addr),
+				Fix:     "Serve the admin console on 127.0.0.1 and reach it over SSH, or explicitly allow insecure configurations.",
+			})
+		}
+	}
+
+	if config.MetricsAddr != "" {
+		if metricsLoopback, err := isLoopbackAddr(config.MetricsAddr); err != nil || !metricsLoopback {
+			findings = append(findings, SecurityFinding{
+				Level:   SecurityWarning,
+				Message: fmt.Sprintf("Anyone who can reach %v can see how many people are playing and how much they send.", config.MetricsAddr),
+				Fix:     "Serve metrics on 127.0.0.1, or firewall the port so only your metrics collector can reach it.",
+			})
+		}
+	}
+
+	if config.APIAddr != "" {
+		if apiLoopback, err := isLoopbackAddr(config.APIAddr); err != nil || !apiLoopback {
+			findings = append(findings, SecurityFinding{
+				Level:   SecurityWarning,
+				Message: fmt.Sprintf("Anyone who can reach %v can see who's playing and the rankings, though not players' moves before they're shown.", config.APIAddr),
+				Fix:     "Serve the game API on 127.0.0.1, or firewall the port so only your scoreboard can reach it.",
+			})
+		}
+	}
+
+	if config.DebugAddr != "" {
+		if debugLoopback, err := isLoopbackAddr(config.DebugAddr); err != nil || !debugLoopback {
+			findings = append(findings, SecurityFinding{
+				Level:   SecurityRefused,
+				Message: fmt.Sprintf("Anyone who can reach %v can profile the server and read its command line, which may include the password.", config.DebugAddr),
+				Fix:     "Serve the profiler on 127.0.0.1 and reach it over SSH, or explicitly allow insecure configurations.",
+			})
+		}
+	}
+
+	return findings
+}
+
+// logSecurity logs a summary of findings.
+func logSecurity(logger *slog.Logger, findings []SecurityFinding) {
+	for _, f := range findings {
+		args := []interface{}{"level", f.Level, "finding", f.Message}
+		if f.Fix != "" {
+			args = append(args, "fix", f.Fix)
+		}
+		logger.Info("security", args...)
+	}
+}
+
+// refusal returns an error describing every refused finding, or nil if there are none.
+func refusal(findings []SecurityFinding) error {
+	var refused []string
+	for _, f := range findings {
+		if f.Level == SecurityRefused {
+			refused = append(refused, f.String())
+		}
+	}
+	if len(refused) == 0 {
+		return nil
+	}
+	return fmt.Errorf("refusing insecure configuration: %s", strings.Join(refused, "; "))
+}
+
+// Reports whether addr only accepts connections from this machine. Hostnames other than localhost count as not,
+// since they may resolve differently later.
+func isLoopbackAddr(addr string) (bool, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false, fmt.Errorf("parse listen address: %v", err)
+	}
+	if host == "localhost" {
+		return true, nil
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback(), nil
+}
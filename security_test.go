@@ -0,0 +1,114 @@
+package vncrps
+
+import (
+	"crypto/tls"
+	"strings"
+	"testing"
+)
+
+func TestCheckSecurity(t *testing.T) {
+	for _, test := range []struct {
+		config  Config
+		refused bool
+		warned  bool
+	}{
+		{Config{Addr: "127.0.0.1:5900"}, false, false},
+		{Config{Addr: "localhost:5900"}, false, false},
+		{Config{Addr: "[::1]:5900"}, false, false},
+		{Config{Addr: ":5900"}, true, true},
+		{Config{Addr: "0.0.0.0:5900"}, true, true},
+		{Config{Addr: "rps.example.com:5900"}, true, true},
+		{Config{Addr: ":5900", Username: "u", Password: "p"}, false, true},
+		{Config{Addr: ":5900", Username: "u", Password: "p", TLS: &tls.Config{}}, false, false},
+		{Config{Addr: ":5900", TLS: &tls.Config{}}, true, false},
+		{Config{Addr: "127.0.0.1:5900", AdminSocket: "/tmp/vncrps.sock"}, false, true},
+		{Config{Addr: "no port"}, true, false},
+		{Config{Addr: "127.0.0.1:5900", WebSocketAddr: "127.0.0.1:5800"}, false, false},
+		{Config{Addr: "127.0.0.1:5900", WebSocketAddr: ":5800"}, true, true},
+		{Config{Addr: "127.0.0.1:5900", WebSocketAddr: ":5800", Username: "u", Password: "p"}, false, true},
+		{Config{Addr: "127.0.0.1:5900", HTTPAddr: "127.0.0.1:8080"}, false, false},
+		{Config{Addr: "127.0.0.1:5900", Listen: []string{"unix:/tmp/vncrps-rfb.sock"}}, false, true},
+		{Config{Addr: "127.0.0.1:5900", Listen: []string{"127.0.0.1:5901"}}, false, false},
+		{Config{Addr: "127.0.0.1:5900", Listen: []string{":5901"}}, true, true},
+		{Config{Addr: "127.0.0.1:5900", HTTPAddr: ":8080"}, true, true},
+		{Config{Addr: "127.0.0.1:5900", MetricsAddr: "127.0.0.1:9100"}, false, false},
+		{Config{Addr: "127.0.0.1:5900", MetricsAddr: ":9100"}, false, true},
+		{Config{Addr: "127.0.0.1:5900", APIAddr: "127.0.0.1:8081"}, false, false},
+		{Config{Addr: "127.0.0.1:5900", APIAddr: ":8081"}, false, true},
+		{Config{Addr: "127.0.0.1:5900", DebugAddr: "127.0.0.1:6060"}, false, false},
+		{Config{Addr: "127.0.0.1:5900", DebugAddr: ":6060"}, true, false},
+		{Config{Addr: "127.0.0.1:5900", SpectatorAddr: "127.0.0.1:5901"}, false, false},
+		{Config{Addr: "127.0.0.1:5900", SpectatorAddr: ":5901"}, false, true},
+		{Config{Addr: "127.0.0.1:5900", ConsoleAddr: "127.0.0.1:5902"}, false, false},
+		{Config{Addr: "127.0.0.1:5900", ConsoleAddr: ":5902", Username: "u", Password: "p"}, true, false},
+		{Config{Addr: "127.0.0.1:5900", ConsoleAddr: "unix:/tmp/vncrps-console.sock"}, false, true},
+	} {
+		var refused, warned bool
+		for _, f := range CheckSecurity(test.config) {
+			refused = refused || f.Level == SecurityRefused
+			warned = warned || f.Level == SecurityWarning
+		}
+		if refused != test.refused || warned != test.warned {
+			t.Errorf("%+v: refused=%v warned=%v, want refused=%v warned=%v", test.config, refused, warned, test.refused, test.warned)
+		}
+	}
+}
+
+func TestCheckSecurityVNCAuthentication(t *testing.T) {
+	for _, test := range []struct {
+		config Config
+		warned bool
+	}{
+		{Config{Addr: ":5900", Username: "u", Password: "p"}, true},
+		{Config{Addr: "127.0.0.1:5900", WebSocketAddr: ":5800", Username: "u", Password: "p"}, true},
+		{Config{Addr: ":5900", Username: "u", Password: "p", TLS: &tls.Config{}}, false},
+		{Config{Addr: "127.0.0.1:5900", Username: "u", Password: "p"}, false},
+		{Config{Addr: ":5900", AllowInsecure: true}, false},
+	} {
+		warned := false
+		for _, f := range CheckSecurity(test.config) {
+			warned = warned || f.Level == SecurityWarning && strings.Contains(f.Message, "VNC authentication")
+		}
+		if warned != test.warned {
+			t.Errorf("%+v: warned about VNC authentication %v, want %v", test.config, warned, test.warned)
+		}
+	}
+}
+
+func TestValidateListenAddr(t *testing.T) {
+	for _, test := range []struct {
+		addr string
+		ok   bool
+	}{
+		{"127.0.0.1:5900", true},
+		{":5900", true},
+		{"0.0.0.0:5901", true},
+		{"[::]:5900", true},
+		{"localhost:0", true},
+		{"rps.example.com:5900", true},
+		{"unix:/run/vncrps.sock", true},
+		{"", false},
+		{"5900", false},
+		{"127.0.0.1", false},
+		{"127.0.0.1:99999", false},
+		{"127.0.0.1:no-such-service", false},
+		{"::1:5900", false},
+		{"unix:", false},
+	} {
+		if err := validateListenAddr(test.addr); (err == nil) != test.ok {
+			t.Errorf("validateListenAddr(%q) = %v, want ok=%v", test.addr, err, test.ok)
+		}
+	}
+	if _, err := NewServer(Config{Addr: "5900", AllowInsecure: true}); err == nil {
+		t.Error("NewServer accepted an address without a port")
+	}
+}
+
+func TestNewServerRefusesInsecureConfig(t *testing.T) {
+	if _, err := NewServer(Config{Addr: ":0"}); err == nil {
+		t.Error("NewServer accepted a public address without a password")
+	}
+	if _, err := NewServer(Config{Addr: ":0", AllowInsecure: true}); err != nil {
+		t.Errorf("NewServer refused an insecure configuration despite AllowInsecure: %v", err)
+	}
+}
@@ -0,0 +1,1224 @@
+/*
+Package vncrps serves Rock/Paper/Scissors over VNC. Everyone connected at the same time plays one another every ~15
+seconds.
+
+To embed the game in another program:
+
+	server, err := vncrps.NewServer(vncrps.Config{Addr: "127.0.0.1:5900"})
+	if err != nil {
+		...
+	}
+	if err := server.Start(); err != nil {
+		...
+	}
+	defer server.Stop()
+*/
+package vncrps
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/alltom/vncrps/game"
+	"github.com/alltom/vncrps/rfb"
+	"image"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Players are sent at most this many frames a second unless Config.FPS says otherwise.
+const defaultFPS = 20
+
+// Viewers get this long to log in, which may include someone typing a password.
+const handshakeTimeout = time.Minute
+
+// Players whose viewers stop taking updates for this long are dropped, freeing their place in the game.
+const writeTimeout = 30 * time.Second
+
+// How long a client sharing a port with HTTP has to speak before it's taken to be a viewer, which has to wait for the
+// server to speak first. Browsers send their requests right away.
+const sniffWait = 300 * time.Millisecond
+
+// How long players may go quiet before their connections are checked, if Config.KeepAlive isn't set.
+const defaultKeepAlive = 30 * time.Second
+
+// Far more input than anyone playing the game sends, so only floods are slowed down.
+var inputLimit = rfb.InputLimit{Rate: 100, Burst: 50}
+
+type Config struct {
+	// Address to listen for connections on, such as "127.0.0.1:5900". Use port 0 to pick any free port.
+	Addr string
+
+	// If both are set, clients must log in, either with Apple Remote Desktop authentication (RFB 3.8 and macOS Screen
+	// Sharing only) using both, or with VNC authentication, which most other viewers support, using the password's
+	// first 8 bytes. Otherwise anyone can connect.
+	Username, Password string
+
+	// If set, every player's input is written here so sessions can be replayed. See InputLog.
+	InputLog io.Writer
+
+	// If set, the tunnel commands are logged on Start, and the reverse tunnel is maintained if it has a jump host.
+	Tunnel *TunnelHelper
+
+	// Determines matchmaking. If zero, a seed is chosen based on the time.
+	Seed int64
+
+	// The game's clock. Defaults to time.Now.
+	Now func() time.Time
+
+	// If true, a summary of each round is copied to every player's clipboard.
+	RoundSummaries bool
+
+	// How time left is shown until a player picks another style. Defaults to CountdownClock.
+	CountdownStyle CountdownStyle
+
+	// If set, the admin API (see AdminHandler) is served on a UNIX socket at this path. A stale socket left by a
+	// previous run is replaced.
+	AdminSocket string
+
+	// What happens when a viewer asks for exclusive access, as many do unless told to share. Since the game needs
+	// several players, the default ignores the request.
+	SharePolicy rfb.SharePolicy
+
+	// If set, NewServer accepts configurations CheckSecurity refuses, such as listening on a public address without a
+	// password.
+	AllowInsecure bool
+
+	// If set, a spectator's view of the game (see DrawScene) is kept up to date in a memory-mapped file at this path
+	// while the server runs. See SnapshotFile for the layout.
+	SnapshotFile string
+
+	// If set, everything each player is sent is recorded in this directory as an FBS file named after when they
+	// started playing, for replaying in tools like rfbproxy.
+	RecordDir string
+
+	// If set, every message to and from players is logged, for debugging viewers that don't work.
+	Trace bool
+
+	// More addresses to listen for players on, each host:port or unix:/path for a UNIX socket, such as one a proxy
+	// like sslh or nginx forwards to.
+	Listen []string
+
+	// If set, browser viewers such as noVNC can connect over WebSocket on this address too, such as "127.0.0.1:5800".
+	WebSocketAddr string
+
+	// If set, a page for playing in the browser with noVNC is served on this address, such as "127.0.0.1:8080", along
+	// with the WebSocket endpoint it connects to. See WebHandler.
+	HTTPAddr string
+
+	// Browser pages that can connect over WebSocket besides ones served from the same host, such as the browser viewer
+	// on HTTPAddr, given as origins like "https://rps.example.com". "*" allows pages from anywhere.
+	WebSocketOrigins []string
+
+	// If set, the browser viewer is served on Addr instead of HTTPAddr, for deployments that can only expose one port.
+	// Browsers and viewers are told apart by whether they speak first; see rfb.SplitHTTP.
+	SharePort bool
+
+	// If set, players' connections on Addr, WebSocketAddr, and HTTPAddr are wrapped in TLS before the RFB handshake,
+	// for viewers that support it or tunnels that terminate it. Reverse connections aren't.
+	TLS *tls.Config
+
+	// Viewers listening for reverse connections, as host or host:port, that Start connects to. See Server.Connect.
+	Connect []string
+
+	// If set, viewers that connect while this many people are playing are shown that the game is full, and then
+	// disconnected, rather than joining. With Rooms, it's how many can play in each room instead.
+	MaxPlayers int
+
+	// If set, viewers that connect on this address watch the game instead of playing, such as "127.0.0.1:5901" for a
+	// screen at a party. They see every matchup, who has picked but not what until the results, and all the rankings.
+	// They log in like players. With Rooms, they watch the first room.
+	SpectatorAddr string
+
+	// If set, viewers that connect on this address get an admin console instead of playing, such as "127.0.0.1:5902",
+	// showing every player, their connections, and this round's matchups and moves, with buttons to kick and rename
+	// players and start a round. They log in like players, so CheckSecurity refuses it on addresses other machines can
+	// reach. With Rooms, it runs the first room.
+	ConsoleAddr string
+
+	// If set, players start in a lobby where they can make rooms and join them, each its own game with its own
+	// rankings, and go back to the lobby to switch. The first room is the game Game returns, which the admin API,
+	// metrics, and snapshot file show. Rooms can't be used with InputLog, which can only replay one game.
+	Rooms bool
+
+	// If set, a bot plays whenever an odd number of people are, so nobody sits out, picking moves with this strategy:
+	// "random", or "counter", which plays against each opponent's favorite move. It can't be used with InputLog, which
+	// doesn't record it. See game.GameServer.Bots.
+	Bots string
+
+	// If set, the game's players, ranks, and history are loaded from here by NewServer and saved as players come and
+	// go, after each round, and when the server stops, so restarting it doesn't wipe the rankings. Players come back
+	// disconnected, and have an hour to rejoin with their codes. With Rooms, only the first room is kept. It can't be
+	// used with InputLog, which replays games from the start. See FileStore.
+	Store Store
+
+	// How long a player's viewer may send nothing before it's checked on, and how long it then has to answer, so
+	// players who close their laptops don't keep their places until TCP gives up. Viewers that support fences are
+	// sent one, and TCP keepalives catch the rest. Defaults to 30 seconds.
+	KeepAlive time.Duration
+
+	// If set, Prometheus metrics (see MetricsHandler) are served on this address at /metrics, such as
+	// "127.0.0.1:9100", along with a health check (see HealthHandler) at /healthz. They aren't protected by the
+	// password or TLS.
+	MetricsAddr string
+
+	// If set, the game API (see APIHandler), a read-only JSON view of the game for scoreboards and stream overlays, is
+	// served on this address, such as "127.0.0.1:8081". Like metrics, it isn't protected by the password or TLS.
+	APIAddr string
+
+	// If set, Go's profiler (see DebugHandler) is served on this address, such as "127.0.0.1:6060". Like metrics, it
+	// isn't protected by the password or TLS.
+	DebugAddr string
+
+	// How long players have to pick their moves each round, and how long the results are shown before the next one.
+	// Default to game.DefaultPickDuration and game.DefaultReviewDuration. Every room uses them. Others can't be used with
+	// InputLog, which doesn't record them.
+	PickDuration   time.Duration
+	ReviewDuration time.Duration
+
+	// If more than 1, each matchup is a match of up to this many games, such as 3 or 5, won by whoever wins most of
+	// them. It must be odd, and can't be used with InputLog, which doesn't record it. See game.GameServer.BestOf.
+	BestOf int
+
+	// If set, rounds are paired Swiss-style, players with similar ranks facing each other and avoiding rematches,
+	// instead of at random. It can't be used with InputLog, which doesn't record it. See game.GameServer.Swiss.
+	Swiss bool
+
+	// The moves players pick from: "rps", the default, "rpsls" for Rock-Paper-Scissors-Lizard-Spock, or "rps7" for
+	// Rock-Paper-Scissors-Fire-Sponge-Air-Water. Others can't be used with InputLog, which doesn't record it. See
+	// game.ParseMoveSet.
+	Moves string
+
+	// If set, when both players in a matchup pick the same move, they pick again straight away, with at least this long
+	// to, until someone wins or the round ends. It can't be used with InputLog, which doesn't record it. See
+	// game.GameServer.SuddenDeath.
+	SuddenDeath time.Duration
+
+	// If set, connections from an address that already has this many open are closed right away, before the handshake.
+	// Connections through a proxy or UNIX socket all count as one address or none, so leave it unset behind one.
+	MaxConnsPerIP int
+
+	// If set, connections from an address that failed to log in, such as with a wrong password, are closed right away
+	// for this long, doubling with each failure in a row up to an hour, so passwords can't be guessed quickly.
+	HandshakeBackoff time.Duration
+
+	// If set, connections from the addresses and networks in this file, one per line such as 203.0.113.7 or
+	// 203.0.113.0/24, are closed right away. Bans made through the admin API or console (see Ban) are saved to it. If
+	// it's unset, bans last until the server stops.
+	BanFile string
+
+	// How many frames a second players are sent at most, and how often the game checks whether a phase has ended.
+	// Players on links too slow to keep up are sent fewer. Defaults to 20.
+	FPS int
+
+	// Where the server logs. Lines about a connection are tagged with its ID (conn), remote address (remote), and
+	// player ID (player) once it has joined the game. If nil, slog.Default() is used.
+	Logger *slog.Logger
+}
+
+// Server is one game and the RFB server players connect to it through.
+type Server struct {
+	config   Config
+	security []SecurityFinding
+	game     *game.GameServer
+	guard    *guard
+	lobby    *Lobby // Nil unless Config.Rooms is set.
+	rfb      *rfb.Server
+	log      *slog.Logger
+
+	lock              sync.Mutex
+	listener          net.Listener
+	extraListeners    []net.Listener
+	spectatorListener net.Listener
+	consoleListener   net.Listener
+	webListener       net.Listener
+	httpListener      net.Listener
+	httpSockets       net.Listener // Accepts the WebSockets httpListener upgrades.
+	adminListener     net.Listener
+	metricsListener   net.Listener
+	apiListener       net.Listener
+	apiServer         *http.Server // Closing it ends /events streams too.
+	debugListener     net.Listener
+	snapshotDone      chan bool
+	saveDone          chan bool
+	saveExited        chan struct{} // Closed once the last save is done.
+	tickDone          chan bool
+	conns             map[*trackedConn]bool
+	nextConnId        uint64
+	done              chan error
+	serving           atomic.Bool // Whether the listener on Config.Addr is accepting players. See Health.
+
+	// Totals for MetricsHandler, including connections that have closed.
+	bytesSent, bytesReceived atomic.Uint64
+	handshakeFailures        atomic.Uint64
+	turnedAway               atomic.Uint64
+}
+
+func NewServer(config Config) (*Server, error) {
+	if (config.Username == "") != (config.Password == "") {
+		return nil, fmt.Errorf("username and password must be set together")
+	}
+	if err := validateListenAddr(config.Addr); err != nil {
+		return nil, err
+	}
+	for _, addr := range append([]string{config.WebSocketAddr, config.HTTPAddr, config.SpectatorAddr, config.ConsoleAddr, config.MetricsAddr, config.APIAddr, config.DebugAddr}, config.Listen...) {
+		if addr == "" {
+			continue
+		}
+		if err := validateListenAddr(addr); err != nil {
+			return nil, err
+		}
+	}
+	if config.Rooms && config.InputLog != nil {
+		return nil, fmt.Errorf("an input log can't be kept with rooms, since it can only replay one game")
+	}
+	if config.Store != nil && config.InputLog != nil {
+		return nil, fmt.Errorf("an input log can't be kept with a store, since it can only replay games from the start")
+	}
+	var bots game.BotStrategy
+	if config.Bots != "" {
+		var err error
+		if bots, err = game.ParseBotStrategy(config.Bots); err != nil {
+			return nil, err
+		}
+		if config.InputLog != nil {
+			return nil, fmt.Errorf("an input log can't be kept with bots, since it doesn't record them")
+		}
+	}
+	if config.PickDuration < 0 || config.ReviewDuration < 0 {
+		return nil, fmt.Errorf("invalid phase durations %v and %v", config.PickDuration, config.ReviewDuration)
+	}
+	if config.PickDuration == 0 {
+		config.PickDuration = game.DefaultPickDuration
+	}
+	if config.ReviewDuration == 0 {
+		config.ReviewDuration = game.DefaultReviewDuration
+	}
+	if config.InputLog != nil && (config.PickDuration != game.DefaultPickDuration || config.ReviewDuration != game.DefaultReviewDuration) {
+		return nil, fmt.Errorf("an input log can't be kept with other phase durations, since it doesn't record them")
+	}
+	if config.BestOf < 0 || config.BestOf > 0 && config.BestOf%2 == 0 {
+		return nil, fmt.Errorf("matches must be best of an odd number of games, not %d", config.BestOf)
+	}
+	if config.BestOf > 1 && config.InputLog != nil {
+		return nil, fmt.Errorf("an input log can't be kept with best-of matches, since it doesn't record them")
+	}
+	if config.Swiss && config.InputLog != nil {
+		return nil, fmt.Errorf("an input log can't be kept with Swiss pairing, since it doesn't record it")
+	}
+	if config.SuddenDeath < 0 {
+		return nil, fmt.Errorf("invalid sudden death time %v", config.SuddenDeath)
+	}
+	if config.SuddenDeath > 0 && config.InputLog != nil {
+		return nil, fmt.Errorf("an input log can't be kept with sudden death, since it doesn't record it")
+	}
+	moves := game.ClassicMoves
+	if config.Moves != "" {
+		var err error
+		if moves, err = game.ParseMoveSet(config.Moves); err != nil {
+			return nil, err
+		}
+		if moves.Name != game.ClassicMoves.Name && config.InputLog != nil {
+			return nil, fmt.Errorf("an input log can't be kept with other moves, since it doesn't record them")
+		}
+	}
+	if config.MaxConnsPerIP < 0 || config.HandshakeBackoff < 0 {
+		return nil, fmt.Errorf("invalid connection limits %d and %v", config.MaxConnsPerIP, config.HandshakeBackoff)
+	}
+	if config.FPS < 0 {
+		return nil, fmt.Errorf("invalid frame rate %d", config.FPS)
+	}
+	if config.SharePort && config.HTTPAddr != "" {
+		return nil, fmt.Errorf("the browser viewer can't be served on both a shared port and HTTPAddr")
+	}
+	if config.Tunnel != nil {
+		if err := config.Tunnel.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid SSH tunnel configuration: %v", err)
+		}
+	}
+	security := CheckSecurity(config)
+	if err := refusal(security); err != nil && !config.AllowInsecure {
+		return nil, err
+	}
+	if config.Seed == 0 {
+		config.Seed = time.Now().UnixNano()
+	}
+	if config.Now == nil {
+		config.Now = time.Now
+	}
+	if config.FPS == 0 {
+		config.FPS = defaultFPS
+	}
+
+	var inputLog *InputLog
+	if config.InputLog != nil {
+		var err error
+		if inputLog, err = NewInputLog(config.InputLog, config.Now, config.Seed); err != nil {
+			return nil, fmt.Errorf("write input log: %v", err)
+		}
+	}
+
+	if config.KeepAlive == 0 {
+		config.KeepAlive = defaultKeepAlive
+	}
+	if config.Logger == nil {
+		config.Logger = slog.Default()
+	}
+	bans, err := newGuard(config)
+	if err != nil {
+		return nil, fmt.Errorf("load bans: %v", err)
+	}
+	s := &Server{config: config, security: security, guard: bans, conns: map[*trackedConn]bool{}, log: config.Logger}
+	s.game = game.NewGameServer(config.Now, config.Seed)
+	s.game.Logger = s.log
+	s.game.Bots = bots
+	s.game.PickDuration = config.PickDuration
+	s.game.ReviewDuration = config.ReviewDuration
+	s.game.BestOf = config.BestOf
+	s.game.Swiss = config.Swiss
+	s.game.Moves = moves
+	s.game.SuddenDeath = config.SuddenDeath
+	if config.Store != nil {
+		saved, err := config.Store.Load()
+		if err != nil {
+			return nil, fmt.Errorf("load game: %v", err)
+		}
+		if saved != nil {
+			if err := s.game.Restore(saved); err != nil {
+				return nil, fmt.Errorf("restore game: %v", err)
+			}
+		}
+	}
+	if config.Rooms {
+		s.lobby = newLobby(s.game, func(room int) *game.GameServer {
+			g := game.NewGameServer(config.Now, config.Seed+int64(room))
+			g.Logger = s.log.With("room", fmt.Sprintf("Room %d", room))
+			g.Bots = bots
+			g.PickDuration = config.PickDuration
+			g.ReviewDuration = config.ReviewDuration
+			g.BestOf = config.BestOf
+			g.Swiss = config.Swiss
+			g.Moves = moves
+			g.SuddenDeath = config.SuddenDeath
+			return g
+		}, config.MaxPlayers)
+	}
+	s.rfb = &rfb.Server{
+		Name:        "RPS",
+		Width:       UIWidth,
+		Height:      UIHeight,
+		Security:    newSecurityRegistry(config.Username, config.Password),
+		MaxFPS:      config.FPS,
+		AdaptiveFPS: true,
+
+		HandshakeTimeout: handshakeTimeout,
+		WriteTimeout:     writeTimeout,
+		KeepAlive:        config.KeepAlive,
+		InputLimit:       inputLimit,
+		SharePolicy:      config.SharePolicy,
+		Logger:           s.log,
+		ConnLogger:       s.connLog,
+		HandshakeFailed: func(conn io.ReadWriter, err error) {
+			s.handshakeFailures.Add(1)
+			var failed *rfb.AuthenticationFailedError
+			if !errors.As(err, &failed) {
+				return // Only wrong passwords back off, not timeouts and dropped connections.
+			}
+			if tc, ok := conn.(*trackedConn); ok && tc.addr.IsValid() {
+				s.guard.handshakeFailed(tc.addr)
+			}
+		},
+		NewHandler: func(conn io.ReadWriter) (rfb.Handler, error) {
+			if tc, ok := conn.(*trackedConn); ok && tc.addr.IsValid() {
+				s.guard.handshook(tc.addr)
+			}
+			if tc, ok := conn.(*trackedConn); ok && tc.role == roleSpectator {
+				s.connLog(conn).Info("started watching")
+				return newSpectatorScreen(s.game, s.config.CountdownStyle), nil
+			} else if ok && tc.role == roleConsole {
+				s.connLog(conn).Info("opened the admin console")
+				return newConsole(s), nil
+			}
+			if s.lobby != nil {
+				return s.newLobbyUI(conn), nil
+			}
+			s.lock.Lock()
+			if players := s.game.Playing(); config.MaxPlayers > 0 && players >= config.MaxPlayers {
+				s.lock.Unlock()
+				closer, ok := conn.(io.Closer)
+				if !ok {
+					return nil, fmt.Errorf("game is full with %d players", players)
+				}
+				s.connLog(conn).Info("game is full; turning viewer away", "players", players)
+				return newFullScreen(players, closer), nil
+			}
+			ui := NewUI(s.game) // While s.lock keeps others from joining past MaxPlayers.
+			ui.SendRoundSummaries = s.config.RoundSummaries
+			ui.CountdownStyle = s.config.CountdownStyle
+			if tc, ok := conn.(*trackedConn); ok {
+				tc.game, tc.player = s.game, ui.playerId
+				tc.ui = ui
+				tc.retag()
+				tc.log.Info("joined the game")
+				ui.rejoined = func(playerId game.PlayerId) { s.rejoined(tc, playerId) }
+			}
+			s.lock.Unlock()
+			return inputLog.Wrap(ui), nil
+		},
+	}
+	if config.RecordDir != "" {
+		s.rfb.Record = s.record
+	}
+	if config.Trace {
+		s.rfb.NewTracer = s.tracer
+	}
+	return s, nil
+}
+
+// tracer logs the messages of one player's connection. It's the rfb.Server's NewTracer hook.
+func (s *Server) tracer(conn io.ReadWriter) rfb.Tracer {
+	return &rfb.LogTracer{Logger: slog.NewLogLogger(s.connLog(conn).Handler(), slog.LevelInfo)}
+}
+
+// connLog returns the logger for lines about a connection, which tags them with the connection's ID and remote
+// address, and its player once it has joined. It's the rfb.Server's ConnLogger hook.
+func (s *Server) connLog(conn io.ReadWriter) *slog.Logger {
+	if tc, ok := conn.(*trackedConn); ok {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		return tc.log
+	}
+	return nil
+}
+
+// Game returns the game being served, so it can be inspected or driven directly.
+func (s *Server) Game() *game.GameServer {
+	return s.game
+}
+
+// Lobby returns the rooms players choose between, or nil unless Config.Rooms is set.
+func (s *Server) Lobby() *Lobby {
+	return s.lobby
+}
+
+// Security returns what CheckSecurity found about the server's configuration.
+func (s *Server) Security() []SecurityFinding {
+	return s.security
+}
+
+// Start listens on the configured addresses and serves connections in the background.
+func (s *Server) Start() error {
+	// Closed if a later step fails.
+	var opened []net.Listener
+	fail := func(format string, err error) error {
+		for _, l := range opened {
+			l.Close()
+		}
+		return fmt.Errorf(format, err)
+	}
+
+	ln, err := s.listen(s.config.Addr)
+	if err != nil {
+		return fail("listen: %v", err)
+	}
+	opened = append(opened, ln)
+	s.log.Info("listening", "addr", ln.Addr().String())
+	var sharedHTTP net.Listener
+	if s.config.SharePort {
+		ln, sharedHTTP = rfb.SplitHTTP(ln, sniffWait)
+	}
+
+	var extraListeners []net.Listener
+	for _, addr := range s.config.Listen {
+		extra, err := s.listen(addr)
+		if err != nil {
+			return fail("listen: %v", err)
+		}
+		opened = append(opened, extra)
+		extraListeners = append(extraListeners, extra)
+		s.log.Info("listening", "addr", extra.Addr().String())
+	}
+	var spectatorListener net.Listener
+	if s.config.SpectatorAddr != "" {
+		spectatorListener, err = s.listen(s.config.SpectatorAddr)
+		if err != nil {
+			return fail("listen for spectators: %v", err)
+		}
+		opened = append(opened, spectatorListener)
+		s.log.Info("listening for spectators", "addr", spectatorListener.Addr().String())
+	}
+	var consoleListener net.Listener
+	if s.config.ConsoleAddr != "" {
+		consoleListener, err = s.listen(s.config.ConsoleAddr)
+		if err != nil {
+			return fail("listen for admin console: %v", err)
+		}
+		opened = append(opened, consoleListener)
+		s.log.Info("serving admin console", "addr", consoleListener.Addr().String())
+	}
+	logSecurity(s.log, s.security)
+
+	if t := s.config.Tunnel; t != nil {
+		t.LogCommands(s.log)
+		if t.JumpHost != "" {
+			go t.MaintainReverseTunnel(s.log)
+		}
+	}
+
+	var adminListener net.Listener
+	if s.config.AdminSocket != "" {
+		os.Remove(s.config.AdminSocket)
+		adminListener, err = net.Listen("unix", s.config.AdminSocket)
+		if err != nil {
+			return fail("listen for admin API: %v", err)
+		}
+		opened = append(opened, adminListener)
+		s.log.Info("serving admin API", "socket", s.config.AdminSocket)
+		go func() {
+			err := http.Serve(adminListener, s.AdminHandler())
+			s.log.Info("admin API stopped", "err", err)
+		}()
+	}
+
+	var metricsListener net.Listener
+	if s.config.MetricsAddr != "" {
+		metricsListener, err = net.Listen("tcp", s.config.MetricsAddr)
+		if err != nil {
+			return fail("listen for metrics: %v", err)
+		}
+		opened = append(opened, metricsListener)
+		s.log.Info("serving metrics", "url", fmt.Sprintf("http://%v/metrics", metricsListener.Addr()))
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", s.MetricsHandler())
+		mux.Handle("/healthz", s.HealthHandler())
+		go func() {
+			err := http.Serve(metricsListener, mux)
+			s.log.Info("metrics stopped", "err", err)
+		}()
+	}
+
+	var apiListener net.Listener
+	var apiServer *http.Server
+	if s.config.APIAddr != "" {
+		apiListener, err = net.Listen("tcp", s.config.APIAddr)
+		if err != nil {
+			return fail("listen for game API: %v", err)
+		}
+		opened = append(opened, apiListener)
+		s.log.Info("serving game API", "url", fmt.Sprintf("http://%v/state", apiListener.Addr()))
+		apiServer = &http.Server{Handler: s.APIHandler()}
+		go func() {
+			err := apiServer.Serve(apiListener)
+			s.log.Info("game API stopped", "err", err)
+		}()
+	}
+
+	var debugListener net.Listener
+	if s.config.DebugAddr != "" {
+		debugListener, err = net.Listen("tcp", s.config.DebugAddr)
+		if err != nil {
+			return fail("listen for profiling: %v", err)
+		}
+		opened = append(opened, debugListener)
+		s.log.Info("serving profiler", "url", fmt.Sprintf("http://%v/debug/pprof/", debugListener.Addr()))
+		go func() {
+			err := http.Serve(debugListener, DebugHandler())
+			s.log.Info("profiler stopped", "err", err)
+		}()
+	}
+
+	var webListener net.Listener
+	if s.config.WebSocketAddr != "" {
+		wsListener, err := s.listen(s.config.WebSocketAddr)
+		if err != nil {
+			return fail("listen for WebSockets: %v", err)
+		}
+		webListener = rfb.ServeWebSocket(wsListener, s.config.WebSocketOrigins)
+		opened = append(opened, webListener)
+		s.log.Info("listening for WebSockets", "addr", webListener.Addr().String())
+	}
+
+	var httpListener, httpSockets net.Listener
+	if s.config.HTTPAddr != "" || sharedHTTP != nil {
+		httpListener = sharedHTTP
+		if httpListener == nil {
+			httpListener, err = s.listen(s.config.HTTPAddr)
+			if err != nil {
+				return fail("listen for HTTP: %v", err)
+			}
+			opened = append(opened, httpListener)
+		}
+		sockets := rfb.NewWebSocketListener(httpListener.Addr(), s.config.WebSocketOrigins)
+		httpSockets = sockets
+		opened = append(opened, httpSockets)
+		scheme := "http"
+		if s.config.TLS != nil {
+			scheme = "https"
+		}
+		s.log.Info("serving browser viewer", "url", fmt.Sprintf("%v://%v/", scheme, httpListener.Addr()))
+		go func() {
+			err := http.Serve(httpListener, WebHandler(sockets))
+			s.log.Info("browser viewer stopped", "err", err)
+		}()
+	}
+
+	if s.config.RecordDir != "" {
+		if err := os.MkdirAll(s.config.RecordDir, 0755); err != nil {
+			return fail("create recording directory: %v", err)
+		}
+		s.log.Info("recording sessions", "dir", s.config.RecordDir)
+	}
+
+	var snapshotDone chan bool
+	if s.config.SnapshotFile != "" {
+		snapshot, err := CreateSnapshotFile(s.config.SnapshotFile, UIWidth, UIHeight)
+		if err != nil {
+			return fail("create snapshot file: %v", err)
+		}
+		s.log.Info("writing snapshots", "path", s.config.SnapshotFile)
+		snapshotDone = make(chan bool)
+		go s.writeSnapshots(snapshot, snapshotDone)
+	}
+
+	tickDone := make(chan bool)
+	go s.tickGame(tickDone)
+	var saveDone chan bool
+	var saveExited chan struct{}
+	if s.config.Store != nil {
+		saveDone, saveExited = make(chan bool), make(chan struct{})
+		go s.saveGame(saveDone, saveExited)
+	}
+
+	s.lock.Lock()
+	s.listener = ln
+	s.extraListeners = extraListeners
+	s.spectatorListener = spectatorListener
+	s.consoleListener = consoleListener
+	s.adminListener = adminListener
+	s.metricsListener = metricsListener
+	s.apiListener = apiListener
+	s.apiServer = apiServer
+	s.debugListener = debugListener
+	s.webListener = webListener
+	s.httpListener = httpListener
+	s.httpSockets = httpSockets
+	s.snapshotDone = snapshotDone
+	s.tickDone = tickDone
+	s.saveDone, s.saveExited = saveDone, saveExited
+	s.done = make(chan error, 1)
+	s.lock.Unlock()
+	s.serving.Store(true)
+	go func() {
+		err := s.rfb.Serve(&trackingListener{Listener: ln, server: s})
+		s.serving.Store(false)
+		s.done <- err
+	}()
+	for _, extra := range extraListeners {
+		go s.rfb.Serve(&trackingListener{Listener: extra, server: s})
+	}
+	if spectatorListener != nil {
+		go s.rfb.Serve(&trackingListener{Listener: spectatorListener, server: s, role: roleSpectator})
+	}
+	if consoleListener != nil {
+		go s.rfb.Serve(&trackingListener{Listener: consoleListener, server: s, role: roleConsole})
+	}
+	if webListener != nil {
+		go s.rfb.Serve(&trackingListener{Listener: webListener, server: s})
+	}
+	if httpSockets != nil {
+		go s.rfb.Serve(&trackingListener{Listener: httpSockets, server: s})
+	}
+
+	for _, addr := range s.config.Connect {
+		if err := s.Connect(addr); err != nil {
+			s.log.Warn("couldn't connect to viewer", "err", err)
+		}
+	}
+	return nil
+}
+
+// listen listens for players on addr, with TLS if it's configured. Addresses starting with "unix:" are UNIX sockets,
+// which replace any stale socket left at the path.
+func (s *Server) listen(addr string) (net.Listener, error) {
+	network := "tcp"
+	if strings.HasPrefix(addr, "unix:") {
+		network, addr = "unix", strings.TrimPrefix(addr, "unix:")
+		os.Remove(addr)
+	}
+	lc := net.ListenConfig{KeepAlive: s.config.KeepAlive}
+	ln, err := lc.Listen(context.Background(), network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if s.config.TLS != nil {
+		ln = tls.NewListener(ln, s.config.TLS)
+	}
+	return ln, nil
+}
+
+// validateListenAddr checks that addr is something listen accepts, so mistakes are caught before anything starts.
+func validateListenAddr(addr string) error {
+	if strings.HasPrefix(addr, "unix:") {
+		if strings.TrimPrefix(addr, "unix:") == "" {
+			return fmt.Errorf("invalid listen address %q: missing socket path", addr)
+		}
+		return nil
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid listen address %q: %v (want host:port, such as 127.0.0.1:5900 or :5900)", addr, err)
+	}
+	if strings.ContainsAny(host, " /") {
+		return fmt.Errorf("invalid listen address %q: bad host %q", addr, host)
+	}
+	if _, err := net.LookupPort("tcp", port); err != nil {
+		return fmt.Errorf("invalid listen address %q: %v", addr, err)
+	}
+	return nil
+}
+
+// Connect joins a viewer to the game by connecting to it, for viewers that listen for reverse connections, such as
+// those behind firewalls. If addr has no port, the usual port for reverse connections, 5500, is used.
+func (s *Server) Connect(addr string) error {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "5500")
+	}
+	dialer := net.Dialer{KeepAlive: s.config.KeepAlive}
+	conn, err := dialer.Dial("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("connect to %v: %v", addr, err)
+	}
+	tracked := s.track(conn, rolePlayer)
+	s.connLog(tracked).Info("connected to listening viewer")
+	go s.rfb.Handle(tracked)
+	return nil
+}
+
+// Addr returns the address the server is listening on, or nil if it hasn't started.
+func (s *Server) Addr() net.Addr {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// WebSocketAddr returns the address the server is listening for WebSockets on, or nil if it isn't.
+func (s *Server) WebSocketAddr() net.Addr {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.webListener == nil {
+		return nil
+	}
+	return s.webListener.Addr()
+}
+
+// HTTPAddr returns the address the browser viewer is served on, or nil if it isn't.
+func (s *Server) HTTPAddr() net.Addr {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.httpListener == nil {
+		return nil
+	}
+	return s.httpListener.Addr()
+}
+
+// MetricsAddr returns the address metrics are served on, or nil if they aren't or the server hasn't started.
+func (s *Server) MetricsAddr() net.Addr {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.metricsListener == nil {
+		return nil
+	}
+	return s.metricsListener.Addr()
+}
+
+// SpectatorAddr returns the address spectators connect on, or nil if they can't or the server hasn't started.
+func (s *Server) SpectatorAddr() net.Addr {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.spectatorListener == nil {
+		return nil
+	}
+	return s.spectatorListener.Addr()
+}
+
+// ConsoleAddr returns the address the admin console is served on, or nil if it isn't or the server hasn't started.
+func (s *Server) ConsoleAddr() net.Addr {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.consoleListener == nil {
+		return nil
+	}
+	return s.consoleListener.Addr()
+}
+
+// APIAddr returns the address the game API is served on, or nil if it isn't or the server hasn't started.
+func (s *Server) APIAddr() net.Addr {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.apiListener == nil {
+		return nil
+	}
+	return s.apiListener.Addr()
+}
+
+// DebugAddr returns the address the profiler is served on, or nil if it isn't or the server hasn't started.
+func (s *Server) DebugAddr() net.Addr {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.debugListener == nil {
+		return nil
+	}
+	return s.debugListener.Addr()
+}
+
+// Wait blocks until the server stops accepting connections, and returns why.
+func (s *Server) Wait() error {
+	s.lock.Lock()
+	done := s.done
+	s.lock.Unlock()
+	if done == nil {
+		return fmt.Errorf("server hasn't started")
+	}
+	return <-done
+}
+
+// Stop closes the listener and every open connection.
+func (s *Server) Stop() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.listener == nil {
+		return fmt.Errorf("server hasn't started")
+	}
+	err := s.listener.Close()
+	for _, l := range s.extraListeners {
+		l.Close()
+	}
+	if s.spectatorListener != nil {
+		s.spectatorListener.Close()
+	}
+	if s.consoleListener != nil {
+		s.consoleListener.Close()
+	}
+	if s.webListener != nil {
+		s.webListener.Close()
+	}
+	if s.httpSockets != nil {
+		s.httpSockets.Close()
+	}
+	s.stopServices()
+	for conn := range s.conns {
+		conn.Conn.Close() // Bypasses trackedConn.Close, which needs s.lock. Serve closes it again when it notices.
+	}
+	return err
+}
+
+// Shutdown stops accepting connections and shows each player a last screen saying the server is closing, then waits
+// for their connections to end until ctx is done, when it closes the rest. Wait returns rfb.ErrServerClosed once it
+// starts. See rfb.Server.Shutdown.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.lock.Lock()
+	if s.listener == nil {
+		s.lock.Unlock()
+		return fmt.Errorf("server hasn't started")
+	}
+	s.stopServices()
+	s.lock.Unlock()
+	return s.rfb.Shutdown(ctx)
+}
+
+// stopServices closes everything but the RFB listeners and connections. s.lock must be held.
+func (s *Server) stopServices() {
+	if s.adminListener != nil {
+		s.adminListener.Close()
+	}
+	if s.httpListener != nil {
+		s.httpListener.Close()
+	}
+	if s.metricsListener != nil {
+		s.metricsListener.Close()
+	}
+	if s.apiServer != nil {
+		s.apiServer.Close()
+	}
+	if s.debugListener != nil {
+		s.debugListener.Close()
+	}
+	if s.snapshotDone != nil {
+		close(s.snapshotDone)
+		s.snapshotDone = nil
+	}
+	if s.saveDone != nil {
+		close(s.saveDone)
+		<-s.saveExited
+		s.saveDone = nil
+	}
+	if s.tickDone != nil {
+		close(s.tickDone)
+		s.tickDone = nil
+	}
+}
+
+// Ticks the games at Config.FPS until done is closed, so players waiting for something to change see the round end as
+// soon as it does, all at once.
+func (s *Server) tickGame(done chan bool) {
+	ticker := time.NewTicker(time.Second / time.Duration(s.config.FPS))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for _, g := range s.games() {
+				g.Tick()
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// Draws the scene into snapshot at Config.FPS until done is closed.
+func (s *Server) writeSnapshots(snapshot *SnapshotFile, done chan bool) {
+	defer snapshot.Close()
+	img := image.NewRGBA(image.Rect(0, 0, UIWidth, UIHeight))
+	ticker := time.NewTicker(time.Second / time.Duration(s.config.FPS))
+	defer ticker.Stop()
+	for {
+		DrawScene(img, s.game.Overview(), s.config.CountdownStyle)
+		snapshot.Update(img)
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+	}
+}
+
+// games returns every game being served: the one Game returns, and with Config.Rooms, the rest of the rooms'.
+func (s *Server) games() []*game.GameServer {
+	if s.lobby == nil {
+		return []*game.GameServer{s.game}
+	}
+	var games []*game.GameServer
+	for _, room := range s.lobby.Rooms() {
+		games = append(games, room.Game)
+	}
+	return games
+}
+
+// Kick disconnects a player from g, the game Game returns or one of the rooms'.
+func (s *Server) Kick(g *game.GameServer, playerId game.PlayerId) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for conn := range s.conns {
+		if conn.playing(g, playerId) {
+			conn.log.Info("kicking player")
+			return conn.Conn.Close() // See Stop.
+		}
+	}
+	return fmt.Errorf("player %d isn't connected", playerId)
+}
+
+// Screenshot returns what the viewer of a player connected to g is showing.
+func (s *Server) Screenshot(g *game.GameServer, playerId game.PlayerId) (*image.RGBA, error) {
+	s.lock.Lock()
+	var ui *UI
+	for conn := range s.conns {
+		if conn.playing(g, playerId) {
+			ui = conn.ui
+		}
+	}
+	s.lock.Unlock()
+	if ui == nil {
+		return nil, fmt.Errorf("player %d isn't connected", playerId)
+	}
+	img := ui.Screenshot()
+	if img == nil {
+		return nil, fmt.Errorf("player %d hasn't been sent a whole frame yet", playerId)
+	}
+	return img, nil
+}
+
+// PlayerStats returns what the connection of a player connected to g has carried so far.
+func (s *Server) PlayerStats(g *game.GameServer, playerId game.PlayerId) (rfb.ConnStats, error) {
+	s.lock.Lock()
+	var conn *trackedConn
+	for c := range s.conns {
+		if c.playing(g, playerId) {
+			conn = c
+		}
+	}
+	s.lock.Unlock()
+	if conn == nil {
+		return rfb.ConnStats{}, fmt.Errorf("player %d isn't connected", playerId)
+	}
+	stats, ok := s.rfb.Stats(conn)
+	if !ok {
+		return rfb.ConnStats{}, fmt.Errorf("player %d isn't connected", playerId)
+	}
+	return stats, nil
+}
+
+// rejoined records that conn's player rejoined the game as playerId, disconnecting any other viewer still playing as
+// them, such as one whose connection dropped without the server noticing yet.
+func (s *Server) rejoined(conn *trackedConn, playerId game.PlayerId) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for other := range s.conns {
+		if other != conn && other.playing(conn.game, playerId) {
+			other.ui.replaced.Store(true) // So closing it leaves the player in the game.
+			other.log.Info("player rejoined on another connection; disconnecting", "other", conn.id)
+			other.Conn.Close() // See Stop.
+		}
+	}
+	conn.player = playerId
+	conn.retag()
+	conn.log.Info("rejoined as an earlier player")
+}
+
+// Run serves the game until the listener fails.
+func Run(config Config) error {
+	s, err := NewServer(config)
+	if err != nil {
+		return err
+	}
+	if err := s.Start(); err != nil {
+		return err
+	}
+	return s.Wait()
+}
+
+func newSecurityRegistry(username, password string) *rfb.SecurityRegistry {
+	security := &rfb.SecurityRegistry{}
+	if password != "" {
+		security.Register(&rfb.ARDSecurityHandler{Verify: func(u, p string) bool {
+			return rfb.ARDCredentialsMatch(u, p, username, password)
+		}})
+		// For every other viewer. VNC authentication has no username and only uses the first 8 bytes of the password.
+		vnc := &rfb.VNCSecurityHandler{Verify: func(challenge rfb.VNCAuthenticationChallengeMessage, response rfb.VNCAuthenticationResponseMessage) bool {
+			want := rfb.VNCAuthenticationResponse(challenge, password)
+			return subtle.ConstantTimeCompare(want[:], response[:]) == 1
+		}}
+		security.Register(vnc)
+		security.Register(&rfb.TightSecurityHandler{Auth: []rfb.SecurityHandler{vnc}})
+		return security
+	}
+
+	// Using VNC authentication because the built-in macOS client won't connect otherwise. Accepts any password.
+	vnc := &rfb.VNCSecurityHandler{}
+	security.Register(vnc)
+	security.Register(&rfb.TightSecurityHandler{Auth: []rfb.SecurityHandler{vnc}})
+	return security
+}
+
+// Remembers accepted connections so Stop can close them.
+type trackingListener struct {
+	net.Listener
+	server *Server
+	role   connRole // What viewers that connect do.
+}
+
+// Accept returns the next connection the server admits, closing the ones it turns away.
+func (l *trackingListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		tracked, reason := l.server.admit(conn, l.role)
+		if tracked != nil {
+			return tracked, nil
+		}
+		l.server.turnedAway.Add(1)
+		l.server.log.Info("turned connection away", "remote", conn.RemoteAddr().String(), "reason", reason)
+		conn.Close()
+	}
+}
+
+// track remembers conn so Stop can close it.
+func (s *Server) track(conn net.Conn, role connRole) *trackedConn {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.trackLocked(conn, role)
+}
+
+// Assumes s.lock has been obtained.
+func (s *Server) trackLocked(conn net.Conn, role connRole) *trackedConn {
+	s.nextConnId++
+	tracked := &trackedConn{Conn: conn, server: s, id: s.nextConnId, role: role, addr: remoteAddr(conn)}
+	tracked.connLog = s.log.With("conn", tracked.id, "remote", conn.RemoteAddr().String())
+	tracked.log = tracked.connLog
+	s.conns[tracked] = true
+	return tracked
+}
+
+type trackedConn struct {
+	net.Conn
+	server *Server
+	id     uint64           // Tags the connection's log lines, since remote addresses can repeat.
+	log    *slog.Logger     // Tags lines with the connection's ID, remote address, and room and player (see retag).
+	game   *game.GameServer // The game the player is in. Nil until the handshake finishes, or while in the lobby.
+	player game.PlayerId    // Zero until the handshake finishes, or while in the lobby.
+	ui     *UI              // Nil until the handshake finishes.
+
+	role    connRole
+	addr    netip.Addr   // Where the connection comes from, if it's an IP address. See Config.MaxConnsPerIP.
+	room    string       // The name of the room the player is in, with Config.Rooms.
+	connLog *slog.Logger // Tags lines with just the connection's ID and remote address.
+}
+
+// connRole is what a viewer does, which depends on the address it connected to.
+type connRole int
+
+const (
+	rolePlayer    connRole = iota
+	roleSpectator          // Watches the game. See Config.SpectatorAddr.
+	roleConsole            // Runs the game. See Config.ConsoleAddr.
+)
+
+// retag tags the connection's log lines with the room and player it's playing as now. Assumes c.server.lock has been
+// obtained.
+func (c *trackedConn) retag() {
+	c.log = c.connLog
+	if c.room != "" {
+		c.log = c.log.With("room", c.room)
+	}
+	if c.game != nil {
+		c.log = c.log.With("player", c.player)
+	}
+}
+
+// playing reports whether the connection's player is playerId in g. Assumes c.server.lock has been obtained.
+func (c *trackedConn) playing(g *game.GameServer, playerId game.PlayerId) bool {
+	return c.game == g && c.player == playerId
+}
+
+func (c *trackedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.server.bytesReceived.Add(uint64(n))
+	return n, err
+}
+
+func (c *trackedConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.server.bytesSent.Add(uint64(n))
+	return n, err
+}
+
+func (c *trackedConn) Close() error {
+	c.server.lock.Lock()
+	delete(c.server.conns, c)
+	c.server.lock.Unlock()
+	return c.Conn.Close()
+}
@@ -0,0 +1,828 @@
+package vncrps
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/alltom/vncrps/rfb"
+	"github.com/alltom/vncrps/rfb/fbs"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestServerEndToEnd(t *testing.T) {
+	server, err := NewServer(Config{Addr: "127.0.0.1:0", Seed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, client := dial(t, server)
+	defer conn.Close()
+	if got, want := client.Framebuffer.Bounds().Size(), (image.Point{UIWidth, UIHeight}); got != want {
+		t.Errorf("got framebuffer size %v, want %v", got, want)
+	}
+
+	update, err := client.Update(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	area := 0
+	for _, rect := range update.Rectangles {
+		if !rfb.IsPseudoEncoding(rect.EncodingType) {
+			area += int(rect.Width) * int(rect.Height)
+		}
+	}
+	if area != UIWidth*UIHeight {
+		t.Fatalf("got %d pixels of rectangles, want the whole %dx%d framebuffer", area, UIWidth, UIHeight)
+	}
+	if client.Cursor == nil || client.Cursor.Image.Bounds() != arrowCursor.Image.Bounds() {
+		t.Errorf("got cursor %v, want the arrow", client.Cursor)
+	}
+	if got := client.Framebuffer.RGBAAt(0, 0); got != (color.RGBA{0xff, 0xff, 0xff, 0xff}) {
+		t.Errorf("got background %v, want white", got)
+	}
+
+	if err := server.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Wait(); err == nil {
+		t.Error("Wait returned nil after Stop")
+	}
+	if _, err := client.ReadMessage(); err == nil {
+		t.Error("connection is still open after Stop")
+	}
+}
+
+func TestServerMaxPlayers(t *testing.T) {
+	server, err := NewServer(Config{Addr: "127.0.0.1:0", MaxPlayers: 1, Seed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	conn, client := dial(t, server)
+	defer conn.Close()
+	if _, err := client.Update(false); err != nil {
+		t.Fatal(err)
+	}
+	fullConn, full := dial(t, server)
+	defer fullConn.Close()
+	if _, err := full.Update(false); err != nil {
+		t.Fatal(err)
+	}
+	if !hasText(full.Framebuffer, image.Rect(8, 32, UIWidth-8, 48)) {
+		t.Error("second viewer wasn't told the game is full")
+	}
+	if n := len(server.Game().Standings()); n != 1 {
+		t.Errorf("game has %d players, want 1", n)
+	}
+
+	// Once the first player leaves, there's room again.
+	conn.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(server.Game().Standings()) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	nextConn, next := dial(t, server)
+	defer nextConn.Close()
+	if _, err := next.Update(false); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(server.Game().Standings()); n != 1 {
+		t.Errorf("game has %d players after the first left, want 1", n)
+	}
+}
+
+func TestServerMetrics(t *testing.T) {
+	server, err := NewServer(Config{Addr: "127.0.0.1:0", MetricsAddr: "127.0.0.1:0", Seed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	conn, client := dial(t, server)
+	defer conn.Close()
+	if _, err := client.Update(false); err != nil {
+		t.Fatal(err)
+	}
+	// A viewer that hangs up before finishing the handshake.
+	failed, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed.Close()
+
+	want := []string{
+		"vncrps_players 1\n",
+		"vncrps_handshake_failures_total 1\n",
+		`vncrps_phase_changes_total{phase="waiting"} `,
+		`vncrps_connection_fps{conn="1",player="1"} `,
+		`vncrps_connection_sent_bytes_total{conn="1",player="1"} `,
+		"vncrps_sent_bytes_total ",
+		"vncrps_rounds_total ",
+	}
+	var metrics string
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		resp, err := http.Get(fmt.Sprintf("http://%v/metrics", server.MetricsAddr()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		metrics = string(body)
+		if strings.Contains(metrics, want[1]) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for _, w := range want {
+		if !strings.Contains(metrics, w) {
+			t.Errorf("metrics are missing %q:\n%s", w, metrics)
+		}
+	}
+}
+
+func TestServerHealth(t *testing.T) {
+	server, err := NewServer(Config{Addr: "127.0.0.1:0", MetricsAddr: "127.0.0.1:0", Seed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	conn, client := dial(t, server)
+	defer conn.Close()
+	if _, err := client.Update(false); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Get(fmt.Sprintf("http://%v/healthz", server.MetricsAddr()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var health Health
+	err = json.NewDecoder(resp.Body).Decode(&health)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || !health.Listening || health.Connections != 1 || health.Players != 1 || health.Phase != "waiting" || health.Goroutines == 0 ||
+		!reflect.DeepEqual(health.Security, server.Security()) {
+		t.Errorf("/healthz = %d %+v, want 200 with one player waiting and the security summary", resp.StatusCode, health)
+	}
+
+	server.Stop()
+	server.Wait()
+	if server.Health().Listening {
+		t.Error("server is still listening after stopping")
+	}
+}
+
+func TestServerDebugAddr(t *testing.T) {
+	server, err := NewServer(Config{Addr: "127.0.0.1:0", DebugAddr: "127.0.0.1:0", Seed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	resp, err := http.Get(fmt.Sprintf("http://%v/debug/pprof/heap?debug=1", server.DebugAddr()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "heap profile") {
+		t.Errorf("got %v for the heap profile:\n%.200s", resp.Status, body)
+	}
+}
+
+func TestServerPushesRoundEnd(t *testing.T) {
+	var now atomic.Int64
+	server, err := NewServer(Config{Addr: "127.0.0.1:0", Seed: 1, Now: func() time.Time { return time.Unix(now.Load(), 0) }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	var clients []*rfb.Client
+	for i := 0; i < 2; i++ {
+		conn, client := dial(t, server)
+		defer conn.Close()
+		if _, err := client.Update(false); err != nil {
+			t.Fatal(err)
+		}
+		clients = append(clients, client)
+	}
+	for _, client := range clients {
+		if _, err := client.Update(false); err != nil { // Now that both have joined.
+			t.Fatal(err)
+		}
+	}
+
+	// With the clock stopped, nothing changes, so incremental requests wait until the round ends.
+	updates := make(chan error, len(clients))
+	for _, client := range clients {
+		client := client
+		go func() {
+			_, err := client.Update(true)
+			updates <- err
+		}()
+	}
+	select {
+	case err := <-updates:
+		t.Fatalf("got an update (err %v) before anything changed", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	now.Add(11)
+	for range clients {
+		select {
+		case err := <-updates:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("players weren't sent the end of the round")
+		}
+	}
+}
+
+// syncBuffer is a bytes.Buffer that's safe for concurrent use.
+type syncBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.String()
+}
+
+func TestServerLogs(t *testing.T) {
+	logs := &syncBuffer{}
+	logger := slog.New(slog.NewJSONHandler(logs, nil))
+	server, err := NewServer(Config{Addr: "127.0.0.1:0", Seed: 1, Logger: logger})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	conn, client := dial(t, server)
+	if _, err := client.Update(false); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Kick(server.Game(), 1); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	var joined, kicked bool
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var entry struct {
+			Msg    string
+			Conn   uint64
+			Remote string
+			Player int
+		}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("log line %q isn't JSON: %v", line, err)
+		}
+		tagged := entry.Conn == 1 && entry.Remote == conn.LocalAddr().String() && entry.Player == 1
+		switch entry.Msg {
+		case "joined the game":
+			joined = tagged
+		case "kicking player":
+			kicked = tagged
+		}
+	}
+	if !joined || !kicked {
+		t.Errorf("joining and kicking weren't logged with the connection's ID, address, and player:\n%s", logs)
+	}
+}
+
+func TestServerShutdown(t *testing.T) {
+	server, err := NewServer(Config{Addr: "127.0.0.1:0", Seed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, client := dial(t, server)
+	defer conn.Close()
+	if _, err := client.Update(false); err != nil {
+		t.Fatal(err)
+	}
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- server.Shutdown(context.Background()) }()
+	if err := server.Wait(); err != rfb.ErrServerClosed {
+		t.Errorf("Wait returned %v, want rfb.ErrServerClosed", err)
+	}
+
+	// The player sees a goodbye screen, and is then disconnected.
+	if _, err := client.Update(false); err != nil {
+		t.Fatal(err)
+	}
+	if !hasText(client.Framebuffer, image.Rect(8, 32, RankingsSplitX-8, 48)) {
+		t.Errorf("last frame doesn't say goodbye")
+	}
+	if _, err := client.Update(true); err == nil {
+		t.Error("connection is still open after its last update")
+	}
+	if err := <-shutdown; err != nil {
+		t.Errorf("Shutdown returned %v", err)
+	}
+}
+
+// hasText reports whether any pixel in rect isn't white.
+func hasText(img *image.RGBA, rect image.Rectangle) bool {
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			if img.RGBAAt(x, y) != (color.RGBA{0xff, 0xff, 0xff, 0xff}) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Connects to server as a viewer.
+func TestServerSpectator(t *testing.T) {
+	server, err := NewServer(Config{Addr: "127.0.0.1:0", SpectatorAddr: "127.0.0.1:0", Seed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	conn, client := dialAddr(t, server.SpectatorAddr())
+	defer conn.Close()
+	if _, err := client.Update(false); err != nil {
+		t.Fatal(err)
+	}
+	if !hasText(client.Framebuffer, image.Rect(8, 8, RankingsSplitX-8, 24)) {
+		t.Error("spectator wasn't shown the game")
+	}
+	if n := len(server.Game().Standings()); n != 0 {
+		t.Errorf("game has %d players after a spectator connected, want 0", n)
+	}
+}
+
+func dial(t *testing.T, server *Server) (net.Conn, *rfb.Client) {
+	return dialAddr(t, server.Addr())
+}
+
+func dialAddr(t *testing.T, addr net.Addr) (net.Conn, *rfb.Client) {
+	conn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	client, err := rfb.NewClient(conn, rfb.ClientConfig{Shared: true})
+	if err != nil {
+		conn.Close()
+		t.Fatal(err)
+	}
+	return conn, client
+}
+
+// Logs in the way viewers without Apple Remote Desktop authentication do.
+func TestServerVNCAuthentication(t *testing.T) {
+	server, err := NewServer(Config{Addr: "127.0.0.1:0", Username: "admin", Password: "secret", Seed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+	login := func(password string) error {
+		conn, err := net.Dial("tcp", server.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+		client, err := rfb.NewClient(conn, rfb.ClientConfig{Password: password, Shared: true}) // No username, so not ARD.
+		if err != nil {
+			return err
+		}
+		_, err = client.Update(false)
+		return err
+	}
+
+	if err := login("secret"); err != nil {
+		t.Errorf("logging in with VNC authentication failed: %v", err)
+	}
+	var failed *rfb.AuthenticationFailedError
+	for _, password := range []string{"guess", ""} {
+		if err := login(password); !errors.As(err, &failed) {
+			t.Errorf("logging in with password %q returned %v, want it refused", password, err)
+		}
+	}
+}
+
+func TestServerResize(t *testing.T) {
+	server, err := NewServer(Config{Addr: "127.0.0.1:0", Seed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	conn, client := dial(t, server)
+	defer conn.Close()
+	if _, err := client.Update(false); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		size, want image.Point
+	}{
+		{image.Pt(480, 400), image.Pt(480, 400)},
+		{image.Pt(100, 100), image.Pt(480, 400)}, // Too small for the layout.
+	} {
+		if err := client.SetDesktopSize(test.size.X, test.size.Y); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.Update(true); err != nil {
+			t.Fatal(err)
+		}
+		if got := client.Framebuffer.Bounds().Size(); got != test.want {
+			t.Errorf("asked for %v, got %v, want %v", test.size, got, test.want)
+		}
+	}
+	if _, err := client.Update(false); err != nil {
+		t.Fatal(err)
+	}
+	if got := client.Framebuffer.RGBAAt(479, 399); got != (color.RGBA{0xff, 0xff, 0xff, 0xff}) {
+		t.Errorf("got %v in the bottom right corner, want white", got)
+	}
+}
+
+func TestServerConnect(t *testing.T) {
+	viewer, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer viewer.Close()
+
+	server, err := NewServer(Config{Addr: "127.0.0.1:0", Seed: 1, Connect: []string{viewer.Addr().String()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	conn, err := viewer.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	client, err := rfb.NewClient(conn, rfb.ClientConfig{Shared: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Update(false); err != nil {
+		t.Fatal(err)
+	}
+	if got := client.Framebuffer.RGBAAt(0, 0); got != (color.RGBA{0xff, 0xff, 0xff, 0xff}) {
+		t.Errorf("got background %v, want white", got)
+	}
+	if players := len(server.Game().Overview().Rankings); players != 1 {
+		t.Errorf("%d players joined, want 1", players)
+	}
+}
+
+func TestServerHTTP(t *testing.T) {
+	server, err := NewServer(Config{Addr: "127.0.0.1:0", HTTPAddr: "127.0.0.1:0", Seed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	resp, err := http.Get(fmt.Sprintf("http://%v/", server.HTTPAddr()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(page), "/websockify") {
+		t.Fatalf("got status %d and page %q, want a page that connects to /websockify", resp.StatusCode, page)
+	}
+
+	conn, err := net.Dial("tcp", server.HTTPAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	fmt.Fprintf(conn, "GET /websockify HTTP/1.1\r\nHost: %v\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n", server.HTTPAddr())
+	r := bufio.NewReader(conn)
+	resp, err = http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("got status %d, want %d", resp.StatusCode, http.StatusSwitchingProtocols)
+	}
+	// The server speaks first: a binary frame with its protocol version.
+	var frame [14]byte
+	if _, err := io.ReadFull(r, frame[:]); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(frame[2:]); frame[0] != 0x82 || got != "RFB 003.008\n" {
+		t.Errorf("got frame %q, want the RFB version in a binary frame", frame)
+	}
+}
+
+func TestServerSharePort(t *testing.T) {
+	server, err := NewServer(Config{Addr: "127.0.0.1:0", SharePort: true, Seed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	if server.HTTPAddr().String() != server.Addr().String() {
+		t.Errorf("serving HTTP on %v, want %v", server.HTTPAddr(), server.Addr())
+	}
+	resp, err := http.Get(fmt.Sprintf("http://%v/", server.Addr()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(page), "/websockify") {
+		t.Fatalf("got status %d and page %q, want the viewer page", resp.StatusCode, page)
+	}
+
+	conn, client := dial(t, server)
+	defer conn.Close()
+	if _, err := client.Update(false); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerTLS(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+
+	server, err := NewServer(Config{Addr: "127.0.0.1:0", TLS: config, Seed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	conn, err := tls.Dial("tcp", server.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	client, err := rfb.NewClient(conn, rfb.ClientConfig{Shared: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Update(false); err != nil {
+		t.Fatal(err)
+	}
+	if got := client.Framebuffer.RGBAAt(0, 0); got != (color.RGBA{0xff, 0xff, 0xff, 0xff}) {
+		t.Errorf("got background %v, want white", got)
+	}
+}
+
+func TestServerListenUnix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vncrps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "rfb.sock")
+
+	server, err := NewServer(Config{Addr: "127.0.0.1:0", Listen: []string{"unix:" + path}, Seed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	client, err := rfb.NewClient(conn, rfb.ClientConfig{Shared: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Update(false); err != nil {
+		t.Fatal(err)
+	}
+	if players := len(server.Game().Overview().Rankings); players != 1 {
+		t.Errorf("%d players joined, want 1", players)
+	}
+}
+
+func TestServerRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vncrps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server, err := NewServer(Config{Addr: "127.0.0.1:0", RecordDir: filepath.Join(dir, "recordings"), Seed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	conn, client := dial(t, server)
+	defer conn.Close()
+	if _, err := client.Update(false); err != nil {
+		t.Fatal(err)
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "recordings", "*.fbs"))
+	if err != nil || len(paths) != 1 {
+		t.Fatalf("found recordings %v (%v), want one", paths, err)
+	}
+	f, err := os.Open(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := fbs.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames, err := fbs.DecodeFrames(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != 1 || frames[0].Bounds() != image.Rect(0, 0, UIWidth, UIHeight) {
+		t.Fatalf("recorded %d frames, want one of the whole UI", len(frames))
+	}
+	if got := frames[0].RGBAAt(0, 0); got != (color.RGBA{0xff, 0xff, 0xff, 0xff}) {
+		t.Errorf("recorded background %v, want white", got)
+	}
+}
+
+func TestServerConsole(t *testing.T) {
+	server, err := NewServer(Config{Addr: "127.0.0.1:0", ConsoleAddr: "127.0.0.1:0", Seed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	playerConn, player := dial(t, server)
+	defer playerConn.Close()
+	if _, err := player.Update(false); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, client := dialAddr(t, server.ConsoleAddr())
+	defer conn.Close()
+	for i := 0; i < 2; i++ { // The first update only resizes the framebuffer.
+		if _, err := client.Update(false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if width := client.Framebuffer.Bounds().Dx(); width != consoleWidth {
+		t.Errorf("console is %d pixels wide, want %d", width, consoleWidth)
+	}
+	if n := len(server.Game().Standings()); n != 1 {
+		t.Errorf("game has %d players after the console connected, want 1", n)
+	}
+
+	// The first player's kick button.
+	x, y := consoleWidth-100, consoleTop+8
+	client.PointerEvent(rfb.ButtonLeft, x, y)
+	client.PointerEvent(0, x, y)
+	for {
+		if _, err := player.Update(true); err != nil {
+			break // Kicked.
+		}
+	}
+	if n := len(server.Game().Standings()); n != 0 {
+		t.Errorf("game has %d players after the console kicked the only one, want 0", n)
+	}
+}
+
+func TestServerBots(t *testing.T) {
+	if _, err := NewServer(Config{Addr: "127.0.0.1:0", Bots: "clever", Seed: 1}); err == nil {
+		t.Errorf("NewServer with unknown bots succeeded")
+	}
+
+	server, err := NewServer(Config{Addr: "127.0.0.1:0", Bots: "counter", Seed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Game().AddPlayer()
+	var bots []string
+	for _, player := range server.Game().Standings() {
+		if player.Bot {
+			bots = append(bots, player.Name)
+		}
+	}
+	if want := []string{"Bot (counter)"}; !reflect.DeepEqual(bots, want) {
+		t.Errorf("bots = %q, want %q", bots, want)
+	}
+}
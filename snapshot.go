@@ -0,0 +1,90 @@
+package vncrps
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	"os"
+)
+
+// The layout of a snapshot file, for tools that read it. All integers are little-endian.
+//
+//	offset  size  field
+//	0       8     magic, "VRPSNAP1"
+//	8       4     width in pixels
+//	12      4     height in pixels
+//	16      4     stride: bytes per row of pixels
+//	20      4     pixel format: 0 is 8-bit RGBA, in that order, not premultiplied (all pixels are opaque anyway)
+//	24      8     sequence number: odd while a frame is being written, even once it's complete
+//	32            height rows of stride bytes each
+//
+// To read a consistent frame, read the sequence number, then the pixels, then the sequence number again, and retry
+// if either read was odd or they differ. See cmd/vncrpssnap for an example.
+const (
+	SnapshotMagic      = "VRPSNAP1"
+	SnapshotHeaderSize = 32
+
+	snapshotSequenceOffset = 24
+)
+
+// SnapshotFile is a memory-mapped file that always holds the latest frame, so local tools such as streaming software
+// or LED boards can show the game without speaking RFB. It's updated in place; readers never see the file change size.
+type SnapshotFile struct {
+	f        *os.File
+	data     []byte
+	pixels   *image.RGBA // Aliases data.
+	sequence uint64
+}
+
+// CreateSnapshotFile creates or truncates the file at path and maps it for frames of the given size.
+func CreateSnapshotFile(path string, width, height int) (*SnapshotFile, error) {
+	stride := 4 * width
+	size := SnapshotHeaderSize + stride*height
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Truncate(int64(size)); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("resize %v: %v", path, err)
+	}
+	data, err := mmapFile(f, size)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("map %v: %v", path, err)
+	}
+
+	copy(data, SnapshotMagic)
+	binary.LittleEndian.PutUint32(data[8:], uint32(width))
+	binary.LittleEndian.PutUint32(data[12:], uint32(height))
+	binary.LittleEndian.PutUint32(data[16:], uint32(stride))
+	binary.LittleEndian.PutUint32(data[20:], 0)
+	binary.LittleEndian.PutUint64(data[snapshotSequenceOffset:], 0)
+
+	return &SnapshotFile{
+		f:      f,
+		data:   data,
+		pixels: &image.RGBA{Pix: data[SnapshotHeaderSize:], Stride: stride, Rect: image.Rect(0, 0, width, height)},
+	}, nil
+}
+
+// Update copies img into the file as the next frame. img should be fully rendered already, since readers retry while
+// a frame is being copied.
+func (s *SnapshotFile) Update(img image.Image) {
+	s.sequence++
+	binary.LittleEndian.PutUint64(s.data[snapshotSequenceOffset:], s.sequence)
+	draw.Draw(s.pixels, s.pixels.Rect, img, img.Bounds().Min, draw.Src)
+	s.sequence++
+	binary.LittleEndian.PutUint64(s.data[snapshotSequenceOffset:], s.sequence)
+}
+
+// Close unmaps the file. The file is left in place with the last frame.
+func (s *SnapshotFile) Close() error {
+	err := munmapFile(s.data)
+	if cerr := s.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,48 @@
+package vncrps
+
+import (
+	"encoding/binary"
+	"github.com/alltom/vncrps/game"
+	"image"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSnapshotFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot")
+	snapshot, err := CreateSnapshotFile(path, UIWidth, UIHeight)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g := game.NewGameServer(time.Now, 1)
+	g.AddPlayer()
+	img := image.NewRGBA(image.Rect(0, 0, UIWidth, UIHeight))
+	DrawScene(img, g.Overview(), CountdownClock)
+	snapshot.Update(img)
+	if err := snapshot.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != SnapshotHeaderSize+4*UIWidth*UIHeight {
+		t.Fatalf("file is %d bytes", len(data))
+	}
+	if string(data[:8]) != SnapshotMagic {
+		t.Errorf("magic is %q", data[:8])
+	}
+	if w, h := binary.LittleEndian.Uint32(data[8:]), binary.LittleEndian.Uint32(data[12:]); w != UIWidth || h != UIHeight {
+		t.Errorf("size is %dx%d", w, h)
+	}
+	if sequence := binary.LittleEndian.Uint64(data[24:]); sequence != 2 {
+		t.Errorf("sequence is %d after one frame, want 2", sequence)
+	}
+	if got, want := data[SnapshotHeaderSize:SnapshotHeaderSize+4], img.Pix[:4]; string(got) != string(want) {
+		t.Errorf("first pixel is %v, want %v", got, want)
+	}
+}
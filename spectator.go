@@ -0,0 +1,64 @@
+package vncrps
+
+import (
+	"github.com/alltom/vncrps/game"
+	"github.com/alltom/vncrps/rfb"
+	"image"
+	"image/draw"
+)
+
+// spectatorScreen shows a viewer that connected on Config.SpectatorAddr the game as DrawScene does, without adding a
+// player. The framebuffer grows to fit all the rankings for viewers that can be resized, up to maxUISize, and the wheel
+// scrolls through the rest. It implements rfb.Handler.
+type spectatorScreen struct {
+	game           *game.GameServer
+	size           image.Point
+	countdownStyle CountdownStyle
+	closing        bool
+
+	buttonMask uint8 // As of the last pointer event, for telling when the wheel turns.
+	scrollRows int   // Rankings rows scrolled past with the wheel.
+}
+
+func newSpectatorScreen(g *game.GameServer, countdownStyle CountdownStyle) *spectatorScreen {
+	return &spectatorScreen{game: g, size: image.Pt(UIWidth, UIHeight), countdownStyle: countdownStyle}
+}
+
+func (s *spectatorScreen) Resize(width, height int) {
+	s.size = image.Pt(width, height)
+}
+
+func (s *spectatorScreen) DesktopSize() image.Point {
+	return image.Pt(UIWidth, rankingsFramebufferHeight(len(s.game.Standings()), UIHeight))
+}
+
+func (s *spectatorScreen) Render(img draw.Image, rect image.Rectangle) {
+	if s.closing {
+		draw.Draw(img, rect, image.White, image.ZP, draw.Src)
+		label("THE SERVER IS SHUTTING DOWN", image.Rect(8, 8, s.size.X-8, 24), img)
+		return
+	}
+	state := s.game.Overview()
+	s.scrollRows = clampScroll(s.scrollRows, len(state.Rankings), s.size.Y)
+	drawScene(img, s.size, state, s.countdownStyle, s.scrollRows)
+}
+
+// Changed reports when the game changes, which is when the scene might look different.
+func (s *spectatorScreen) Changed() <-chan struct{} {
+	return s.game.Changed()
+}
+
+// ServerClosing makes the last frame say the server is going away.
+func (s *spectatorScreen) ServerClosing() {
+	s.closing = true
+}
+
+func (s *spectatorScreen) PointerEvent(m *rfb.PointerEventMessage) {
+	if _, dy := m.ScrollDelta(s.buttonMask); dy != 0 && int(m.X) >= RankingsSplitX {
+		s.scrollRows += dy // Render keeps it in range.
+	}
+	s.buttonMask = m.ButtonMask
+}
+
+func (s *spectatorScreen) KeyEvent(m *rfb.KeyEventMessage) {}
+func (s *spectatorScreen) CutText(text string)             {}
@@ -0,0 +1,85 @@
+package vncrps
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/alltom/vncrps/game"
+	"os"
+	"path/filepath"
+)
+
+// Store keeps the game's players, ranks, and history somewhere that survives restarts. See Config.Store.
+type Store interface {
+	// Load returns what was last saved, or nil if nothing has been.
+	Load() (*game.SavedGame, error)
+	Save(saved *game.SavedGame) error
+}
+
+// FileStore is a Store that keeps the game in a JSON file. Each save replaces the file whole, so a crash partway
+// through leaves the last one.
+type FileStore struct {
+	Path string
+}
+
+func (f *FileStore) Load() (*game.SavedGame, error) {
+	data, err := os.ReadFile(f.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var saved game.SavedGame
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("parse %v: %v", f.Path, err)
+	}
+	return &saved, nil
+}
+
+func (f *FileStore) Save(saved *game.SavedGame) error {
+	data, err := json.MarshalIndent(saved, "", "\t")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(f.Path, append(data, '\n'))
+}
+
+// writeFileAtomically replaces the file at path with data whole, so a crash partway through leaves the old one.
+func writeFileAtomically(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // Fails harmlessly once it's been renamed.
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write %v: %v", tmp.Name(), err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write %v: %v", tmp.Name(), err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// saveGame saves the game whenever players come and go or a round is judged, and once more when done is closed,
+// then closes exited.
+func (s *Server) saveGame(done chan bool, exited chan struct{}) {
+	defer close(exited)
+	events, stop := s.game.Subscribe(16) // Dropped events don't matter, since each save has everything.
+	defer stop()
+	for {
+		select {
+		case e := <-events:
+			if e.Type == game.EventMovePicked || e.Type == game.EventRoundStarted || e.Type == game.EventRematch {
+				continue
+			}
+		case <-done:
+			if err := s.config.Store.Save(s.game.Save()); err != nil {
+				s.log.Warn("couldn't save game", "err", err)
+			}
+			return
+		}
+		if err := s.config.Store.Save(s.game.Save()); err != nil {
+			s.log.Warn("couldn't save game", "err", err)
+		}
+	}
+}
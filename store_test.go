@@ -0,0 +1,49 @@
+package vncrps
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestServerStore(t *testing.T) {
+	store := &FileStore{Path: filepath.Join(t.TempDir(), "game.json")}
+	server, err := NewServer(Config{Addr: "127.0.0.1:0", Seed: 1, Store: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	playerId := server.Game().AddPlayer()
+	if err := server.Game().Rename(playerId, "Ada"); err != nil {
+		t.Fatal(err)
+	}
+	state, err := server.Game().GetState(playerId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Stop()
+
+	saved, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved == nil || len(saved.Players) != 1 || saved.Players[0].Name != "Ada" {
+		t.Fatalf("saved %+v, want Ada", saved)
+	}
+
+	restarted, err := NewServer(Config{Addr: "127.0.0.1:0", Seed: 1, Store: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, err := restarted.Game().Rejoin(state.RejoinCode, 0); err != nil || id != playerId {
+		t.Errorf("Rejoin after restarting = %v, %v; want %v", id, err, playerId)
+	}
+}
+
+func TestFileStoreLoadMissing(t *testing.T) {
+	store := &FileStore{Path: filepath.Join(t.TempDir(), "game.json")}
+	if saved, err := store.Load(); saved != nil || err != nil {
+		t.Errorf("Load() of a missing file = %v, %v; want nothing", saved, err)
+	}
+}
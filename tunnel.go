@@ -0,0 +1,137 @@
+package vncrps
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// TunnelHelper describes how players reach a server that only listens on localhost.
+//
+// Players either forward a local port straight to the server's SSH host, or, if JumpHost is set, the server keeps a
+// reverse tunnel open to the jump host and players forward to that instead.
+type TunnelHelper struct {
+	ListenAddr string // Address the RFB server listens on. Must be a loopback address.
+	SSHHost    string // Host players SSH to when there's no jump host, e.g. "rps.example.com" or "rps.example.com:2222".
+	Players    []string
+
+	JumpHost       string // Optional "user@host[:port]" to maintain a reverse tunnel to.
+	JumpRemotePort int    // Port on the jump host's loopback interface that forwards back to ListenAddr.
+}
+
+// Validate returns an error if the helper can't produce working commands.
+func (t *TunnelHelper) Validate() error {
+	loopback, err := isLoopbackAddr(t.ListenAddr)
+	if err != nil {
+		return err
+	}
+	if !loopback {
+		return fmt.Errorf("SSH tunnel mode requires a loopback listen address, but got %q", t.ListenAddr)
+	}
+	if t.SSHHost == "" && t.JumpHost == "" {
+		return fmt.Errorf("SSH tunnel mode requires an SSH host or a jump host")
+	}
+	if t.JumpHost != "" && (t.JumpRemotePort <= 0 || t.JumpRemotePort > 65535) {
+		return fmt.Errorf("jump host remote port must be in 1-65535, but is %d", t.JumpRemotePort)
+	}
+	return nil
+}
+
+// Command returns the command a player should paste into a terminal to reach the server.
+// If player is empty, a "<user>" placeholder is used for the SSH login.
+func (t *TunnelHelper) Command(player string) string {
+	if player == "" {
+		player = "<user>"
+	}
+	_, listenPort, _ := net.SplitHostPort(t.ListenAddr)
+
+	sshHost, remotePort := t.SSHHost, listenPort
+	if t.JumpHost != "" {
+		sshHost = t.JumpHost
+		if i := strings.Index(sshHost, "@"); i >= 0 {
+			sshHost = sshHost[i+1:]
+		}
+		remotePort = fmt.Sprintf("%d", t.JumpRemotePort)
+	}
+
+	args := []string{"ssh", "-N"}
+	if host, port, err := net.SplitHostPort(sshHost); err == nil {
+		sshHost = host
+		args = append(args, "-p", port)
+	}
+	args = append(args, "-L", fmt.Sprintf("%s:127.0.0.1:%s", listenPort, remotePort), fmt.Sprintf("%s@%s", player, sshHost))
+	return strings.Join(args, " ")
+}
+
+// Commands returns one ready-to-paste command per configured player, or a single placeholder command if there are none.
+func (t *TunnelHelper) Commands() []string {
+	if len(t.Players) == 0 {
+		return []string{t.Command("")}
+	}
+	var cmds []string
+	for _, player := range t.Players {
+		cmds = append(cmds, t.Command(player))
+	}
+	return cmds
+}
+
+// LogCommands logs the per-player commands so the host can hand them out.
+func (t *TunnelHelper) LogCommands(logger *slog.Logger) {
+	_, listenPort, _ := net.SplitHostPort(t.ListenAddr)
+	logger.Info("SSH tunnel mode: players should run one of these commands, then point their viewer at localhost",
+		"port", listenPort)
+	for _, cmd := range t.Commands() {
+		logger.Info("SSH tunnel command", "cmd", cmd)
+	}
+}
+
+// TunnelCommands returns the commands players run to reach the server through Config.Tunnel, one per player, or none
+// if it isn't set.
+func (s *Server) TunnelCommands() []string {
+	if s.config.Tunnel == nil {
+		return []string{}
+	}
+	return s.config.Tunnel.Commands()
+}
+
+// reverseTunnelArgs returns the arguments to ssh that open the reverse tunnel to the jump host.
+func (t *TunnelHelper) reverseTunnelArgs() []string {
+	host, port := t.JumpHost, ""
+	if h, p, err := net.SplitHostPort(t.JumpHost); err == nil {
+		host, port = h, p
+	}
+	args := []string{
+		"-N",
+		"-o", "ExitOnForwardFailure=yes",
+		"-o", "ServerAliveInterval=30",
+		"-R", fmt.Sprintf("127.0.0.1:%d:%s", t.JumpRemotePort, t.ListenAddr),
+	}
+	if port != "" {
+		args = append(args, "-p", port)
+	}
+	return append(args, host)
+}
+
+// MaintainReverseTunnel keeps an "ssh -R" tunnel open to the jump host, restarting it with backoff whenever it exits.
+// It never returns.
+func (t *TunnelHelper) MaintainReverseTunnel(logger *slog.Logger) {
+	backoff := time.Second
+	for {
+		args := t.reverseTunnelArgs()
+		logger.Info("opening reverse tunnel", "cmd", "ssh "+strings.Join(args, " "))
+		start := time.Now()
+		err := exec.Command("ssh", args...).Run()
+		logger.Warn("reverse tunnel closed", "host", t.JumpHost, "err", err)
+
+		if time.Since(start) > time.Minute {
+			backoff = time.Second
+		}
+		time.Sleep(backoff)
+		if backoff < time.Minute {
+			backoff *= 2
+		}
+	}
+}
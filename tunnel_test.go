@@ -0,0 +1,64 @@
+package vncrps
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTunnelValidate(t *testing.T) {
+	for _, test := range []struct {
+		tunnel TunnelHelper
+		ok     bool
+	}{
+		{TunnelHelper{ListenAddr: "127.0.0.1:5900", SSHHost: "rps.example.com"}, true},
+		{TunnelHelper{ListenAddr: "localhost:5900", JumpHost: "me@jump.example.com", JumpRemotePort: 5900}, true},
+		{TunnelHelper{ListenAddr: "0.0.0.0:5900", SSHHost: "rps.example.com"}, false},
+		{TunnelHelper{ListenAddr: "127.0.0.1:5900"}, false},
+		{TunnelHelper{ListenAddr: "127.0.0.1:5900", JumpHost: "me@jump.example.com"}, false},
+		{TunnelHelper{ListenAddr: "127.0.0.1:5900", JumpHost: "me@jump.example.com", JumpRemotePort: 65536}, false},
+		{TunnelHelper{ListenAddr: "nonsense", SSHHost: "rps.example.com"}, false},
+	} {
+		if err := test.tunnel.Validate(); (err == nil) != test.ok {
+			t.Errorf("%+v.Validate() = %v, want ok %v", test.tunnel, err, test.ok)
+		}
+	}
+}
+
+func TestTunnelCommands(t *testing.T) {
+	for _, test := range []struct {
+		tunnel TunnelHelper
+		want   []string
+	}{
+		{
+			TunnelHelper{ListenAddr: "127.0.0.1:5900", SSHHost: "rps.example.com"},
+			[]string{"ssh -N -L 5900:127.0.0.1:5900 <user>@rps.example.com"},
+		},
+		{
+			TunnelHelper{ListenAddr: "127.0.0.1:5901", SSHHost: "rps.example.com:2222", Players: []string{"alice", "bob"}},
+			[]string{"ssh -N -p 2222 -L 5901:127.0.0.1:5901 alice@rps.example.com", "ssh -N -p 2222 -L 5901:127.0.0.1:5901 bob@rps.example.com"},
+		},
+		{
+			TunnelHelper{ListenAddr: "127.0.0.1:5900", JumpHost: "me@jump.example.com:2200", JumpRemotePort: 6000, Players: []string{"alice"}},
+			[]string{"ssh -N -p 2200 -L 5900:127.0.0.1:6000 alice@jump.example.com"},
+		},
+	} {
+		if got := test.tunnel.Commands(); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%+v.Commands() = %q, want %q", test.tunnel, got, test.want)
+		}
+	}
+}
+
+func TestReverseTunnelArgs(t *testing.T) {
+	for _, test := range []struct {
+		jumpHost string
+		want     []string
+	}{
+		{"me@jump.example.com", []string{"-N", "-o", "ExitOnForwardFailure=yes", "-o", "ServerAliveInterval=30", "-R", "127.0.0.1:6000:127.0.0.1:5900", "me@jump.example.com"}},
+		{"me@jump.example.com:2200", []string{"-N", "-o", "ExitOnForwardFailure=yes", "-o", "ServerAliveInterval=30", "-R", "127.0.0.1:6000:127.0.0.1:5900", "-p", "2200", "me@jump.example.com"}},
+	} {
+		tunnel := TunnelHelper{ListenAddr: "127.0.0.1:5900", JumpHost: test.jumpHost, JumpRemotePort: 6000}
+		if got := tunnel.reverseTunnelArgs(); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ssh arguments for jump host %q are %q, want %q", test.jumpHost, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,771 @@
+package vncrps
+
+import (
+	"fmt"
+	"github.com/alltom/vncrps/game"
+	"github.com/alltom/vncrps/rfb"
+	"github.com/alltom/vncrps/rfb/keysym"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	UIWidth        = 320
+	UIHeight       = 320
+	RankingsSplitX = 240
+
+	maxUISize = 4096 // The largest width or height clients may resize the UI to.
+
+	// Players who have won this many matches in a row get a badge in the rankings, like "P3 W2", and once they've won
+	// celebratedStreak, a banner when the results are shown.
+	badgedStreak     = 2
+	celebratedStreak = 3
+)
+
+var (
+	primaryColor      = color.NRGBA{0x60, 0x02, 0xee, 0xff}
+	primaryLightColor = color.NRGBA{0x99, 0x46, 0xff, 0xff}
+)
+
+// UI is one player's view of the game. It implements rfb.Handler.
+type UI struct {
+	server   *game.GameServer // Nil while the player is in the lobby.
+	playerId game.PlayerId
+	size     image.Point // The client's framebuffer size.
+	wantSize image.Point // The size the client asked for, or the default.
+
+	keyEvent     rfb.KeyEventMessage
+	pointerEvent rfb.PointerEventMessage
+
+	moveButtons [game.NumMoves]ButtonState // Indexed by game.Move.
+	move        *game.Move
+
+	// If true, a summary of each round is copied to the player's clipboard.
+	SendRoundSummaries bool
+	summarizedRound    int
+
+	bellPhase game.Phase // The phase when PendingMessages last ran.
+
+	// How time left is shown. The player can change it on the settings screen.
+	CountdownStyle CountdownStyle
+
+	// What the client's framebuffer holds, and the rankings drawn in it, for finding content that moved.
+	frameLock   sync.Mutex // Guards frame, which Screenshot reads from other goroutines.
+	frame       *image.RGBA
+	rankingRows []string
+	drawnRows   []string
+	scrollRows  int          // Rankings rows scrolled past with the wheel.
+	rankingsBy  rankingsView // What the rankings show. The player can switch.
+	sortButton  ButtonState
+	copies      []rfb.CopyRegion
+
+	bindings        InputBindings
+	settingsOpen    bool
+	rebinding       *game.Move // The move waiting for a key on the settings screen.
+	rebindButtons   [game.NumMoves]ButtonState
+	shownBinding    int // Which move's key the settings screen shows, when there are too many to show at once.
+	nextKeyButton   ButtonState
+	swapButton      ButtonState
+	countdownButton ButtonState
+	settingsButton  ButtonState
+	overButton      bool // Whether the pointer was over a button when the UI was last drawn.
+
+	// Set for players who choose a room (see NewLobbyUI), who can go back to the lobby and pick another.
+	lobby *Lobby
+	room  *Room
+	// On the settings screen, players whose viewers disconnected can type the rejoin code they had to play as who they
+	// were. See game.GameServer.Rejoin.
+	typingCode   bool
+	typedCode    string
+	rejoinError  string
+	rejoinButton ButtonState
+	rejoined     func(playerId game.PlayerId) // If set, called when the player rejoins as who they were.
+	replaced     atomic.Bool                  // Set once the player rejoins elsewhere, so Close leaves them be.
+
+	// Players start out typing the name they want to be shown by instead of P1, P2, and so on, which they can skip.
+	// See game.GameServer.Rename.
+	typingName bool
+	typedName  string
+	nameError  string // Why the name the player typed wasn't taken.
+	name       string // The name the player chose, which they keep in each room they join.
+
+	// If set, called when the player joins a room, and with nil when they go back to the lobby.
+	joined        func(room *Room, playerId game.PlayerId)
+	joinButtons   [maxRooms]ButtonState
+	newRoomButton ButtonState
+	lobbyButton   ButtonState
+	lobbyError    string // Why the player couldn't join the room they last picked.
+
+	// What the last two calls to Update drew, and the regions that changed since the last Render, for Damage.
+	ops, lastOps []drawOp
+	damage       []image.Rectangle
+
+	closing bool // Whether the server is shutting down.
+}
+
+func NewUI(gameServer *game.GameServer) *UI {
+	playerId := gameServer.AddPlayer()
+	ui := &UI{server: gameServer, playerId: playerId, size: image.Pt(UIWidth, UIHeight), wantSize: image.Pt(UIWidth, UIHeight), bindings: DefaultInputBindings, typingName: true}
+	if state, err := gameServer.GetState(playerId); err == nil && state.LastRound != nil {
+		ui.summarizedRound = state.LastRound.Round // Only summarize rounds the player saw.
+	}
+	return ui
+}
+
+// NewLobbyUI returns a UI for a player who starts in the lobby, choosing which of its rooms to play in.
+func NewLobbyUI(lobby *Lobby) *UI {
+	return &UI{lobby: lobby, size: image.Pt(UIWidth, UIHeight), wantSize: image.Pt(UIWidth, UIHeight), bindings: DefaultInputBindings, typingName: true}
+}
+
+// The layout fills the framebuffer, with the rankings panel on the right and the buttons anchored to the bottom.
+func (ui *UI) Resize(width, height int) {
+	ui.size = image.Pt(width, height)
+	ui.lastOps = nil // So everything is damaged.
+	ui.frameLock.Lock()
+	ui.frame = nil
+	ui.frameLock.Unlock()
+}
+
+// Screenshot returns a copy of what the player's viewer is showing, or nil if it hasn't been sent a whole frame since
+// it last changed size.
+func (ui *UI) Screenshot() *image.RGBA {
+	ui.frameLock.Lock()
+	defer ui.frameLock.Unlock()
+	if ui.frame == nil {
+		return nil
+	}
+	img := image.NewRGBA(ui.frame.Rect)
+	copy(img.Pix, ui.frame.Pix)
+	return img
+}
+
+// DesktopSize grows the framebuffer from the size the client asked for when the rankings don't fit, up to maxUISize.
+func (ui *UI) DesktopSize() image.Point {
+	size := ui.wantSize
+	if ui.server == nil {
+		return size
+	}
+	if state, err := ui.server.GetState(ui.playerId); err == nil {
+		size.Y = rankingsFramebufferHeight(len(state.Rankings), size.Y)
+	}
+	return size
+}
+
+func (ui *UI) Render(img draw.Image, rect image.Rectangle) {
+	ui.Update(img, &ui.keyEvent, &ui.pointerEvent)
+	ui.trackFrame(img, rect)
+}
+
+// Damage returns the regions that changed since the last Render, which the server only needs to compare with what the
+// client has.
+func (ui *UI) Damage() []image.Rectangle {
+	damage := ui.damage
+	ui.damage = nil
+	return damage
+}
+
+// Changed reports when the game changes, or the lobby when the player is in it, which is when the UI might look
+// different, unless the player sends input.
+func (ui *UI) Changed() <-chan struct{} {
+	if ui.server == nil {
+		return ui.lobby.Changed()
+	}
+	return ui.server.Changed()
+}
+
+func (ui *UI) Copies() []rfb.CopyRegion {
+	copies := ui.copies
+	ui.copies = nil
+	return copies
+}
+
+// Cursor shows a hand over buttons and an arrow everywhere else.
+func (ui *UI) Cursor() *rfb.Cursor {
+	if ui.overButton {
+		return handCursor
+	}
+	return arrowCursor
+}
+
+// SetDesktopSize lets the viewer make the UI bigger, but not smaller than the layout needs.
+func (ui *UI) SetDesktopSize(width, height int) bool {
+	if width < UIWidth || height < UIHeight || width > maxUISize || height > maxUISize {
+		return false
+	}
+	ui.wantSize = image.Pt(width, height)
+	return true
+}
+
+func (ui *UI) KeyEvent(m *rfb.KeyEventMessage) {
+	ui.keyEvent = *m
+	if m.Pressed {
+		ui.handleKey(m.KeySym, m.KeyCode)
+	}
+	ui.Update(image.NewNRGBA(image.ZR), &ui.keyEvent, &ui.pointerEvent)
+}
+
+func (ui *UI) handleKey(keySym, keyCode uint32) {
+	if ui.rebinding != nil {
+		if keySym != keysym.Escape {
+			ui.bindings.Bind(*ui.rebinding, keySym)
+			ui.saveBindings()
+		}
+		ui.rebinding = nil
+		return
+	}
+	if ui.typingCode {
+		ui.typeCode(keySym)
+		return
+	}
+	if keySym == keysym.Tab {
+		ui.settingsOpen = !ui.settingsOpen
+		return
+	}
+	if ui.typingName && !ui.settingsOpen && ui.server != nil {
+		ui.typeName(keySym)
+		return
+	}
+	if ui.settingsOpen || ui.server == nil {
+		return
+	}
+
+	move, ok := ui.bindings.MoveForKey(keySym, keyCode)
+	if !ok {
+		return
+	}
+	state, err := ui.server.GetState(ui.playerId)
+	if err != nil || state.Phase != game.PhasePicking || state.Opponent == nil {
+		return
+	}
+	ui.server.Pick(ui.playerId, move)
+}
+
+func (ui *UI) PointerEvent(m *rfb.PointerEventMessage) {
+	if _, dy := m.ScrollDelta(ui.pointerEvent.ButtonMask); dy != 0 && int(m.X) >= RankingsSplitX {
+		ui.scrollRows += dy // Update keeps it in range.
+	}
+	ui.pointerEvent = *m
+	ui.pointerEvent.ButtonMask = ui.bindings.ButtonMask(m.ButtonMask)
+	ui.Update(image.NewNRGBA(image.ZR), &ui.keyEvent, &ui.pointerEvent)
+}
+
+func (ui *UI) CutText(text string) {
+}
+
+// PendingMessages rings the bell when a round the player is in starts and when its results come in, so players who
+// have looked away know to look back.
+func (ui *UI) PendingMessages() []rfb.ServerMessage {
+	if ui.server == nil {
+		return nil
+	}
+	state, err := ui.server.GetState(ui.playerId)
+	if err != nil {
+		return nil
+	}
+	var messages []rfb.ServerMessage
+	if state.Phase != ui.bellPhase {
+		ui.bellPhase = state.Phase
+		if state.Opponent != nil && (state.Phase == game.PhasePicking || state.Phase == game.PhaseReview) {
+			messages = append(messages, &rfb.BellMessage{})
+		}
+	}
+	if ui.SendRoundSummaries && state.LastRound != nil && state.LastRound.Round != ui.summarizedRound {
+		ui.summarizedRound = state.LastRound.Round
+		messages = append(messages, &rfb.ServerCutTextMessage{Text: state.LastRound.String()})
+	}
+	return messages
+}
+
+// Update handles input and draws the UI into img, which may be empty when only input needs handling. It returns the
+// regions that may look different than they did after the last call, which Damage accumulates.
+func (ui *UI) Update(img draw.Image, keyEvent *rfb.KeyEventMessage, pointerEvent *rfb.PointerEventMessage) []image.Rectangle {
+	if ui.server == nil {
+		ui.ops = ui.ops[:0]
+		ui.fill(image.Rectangle{Max: ui.size}, color.White, img)
+		ui.overButton = false
+		ui.drawLobby(img, pointerEvent)
+		return ui.drawn()
+	}
+	state, err := ui.server.GetState(ui.playerId)
+	if err != nil {
+		ui.lastOps = nil
+		return ui.addDamage([]image.Rectangle{{Max: ui.size}})
+	}
+
+	ui.ops = ui.ops[:0]
+	ui.fill(image.Rectangle{Max: ui.size}, color.White, img)
+	ui.overButton = false
+
+	y := 8
+	width, height := ui.size.X, ui.size.Y
+	splitX := (width + RankingsSplitX) / 2
+	ui.drawnRows = ui.drawnRows[:0]
+	ui.scrollRows = clampScroll(ui.scrollRows, len(state.Rankings), height)
+	if ui.rankingsBy == rankingsByRating {
+		game.SortByRating(state.Rankings)
+	}
+	var column []string
+	for _, player := range state.Rankings[ui.scrollRows:] {
+		column = append(column, ui.rankingsBy.column(player))
+		if x := width - 8 - len(column[len(column)-1])*7; x < splitX { // Records can be wider than ranks.
+			splitX = x
+		}
+	}
+	for i, player := range state.Rankings[ui.scrollRows:] {
+		name := player.Name
+		if player.PlayerId == ui.playerId {
+			name += "*"
+		}
+		if player.Streak >= badgedStreak {
+			name += fmt.Sprintf(" W%d", player.Streak)
+		}
+		rank := column[i]
+		rankX := splitX
+		if nameEnd := RankingsSplitX + 8 + (len(name)+1)*7; nameEnd > rankX { // Make room for a badge if there is any.
+			rankX = max(splitX, min(nameEnd, width-8-len(rank)*7))
+		}
+		ui.label(name, image.Rect(RankingsSplitX+8, y, splitX-8, y+8), img)
+		ui.label(rank, image.Rect(rankX, y, width-8, y+8), img)
+		ui.drawnRows = append(ui.drawnRows, name+"\x00"+rank)
+		y += 16
+	}
+
+	switch {
+	case ui.closing:
+		ui.label("THE SERVER IS SHUTTING DOWN", image.Rect(8, 8, RankingsSplitX-8, 24), img)
+		ui.label("Thanks for playing!", image.Rect(8, 32, RankingsSplitX-8, 48), img)
+	case ui.settingsOpen:
+		ui.drawSettings(img, state, pointerEvent)
+	case state.Phase == game.PhaseWaiting:
+		ui.label("Waiting for other players...", image.Rect(8, 8, width-8, 24), img)
+	case state.Phase == game.PhasePicking:
+		countdownY := 32 + (len(state.Moves)+2)/3*40 // Below the move buttons.
+		ui.fill(image.Rect(0, 0, RankingsSplitX, height), color.RGBA{0xff, 0xff, 0, 0xff}, img)
+
+		if state.Opponent == nil {
+			ui.label("YOU MUST SIT OUT THIS ROUND", image.Rect(8, 8, width-8, 24), img)
+			ui.label("(must be an odd number of players)", image.Rect(8, 32, width-8, 40), img)
+		} else if state.MatchOver {
+			ui.label("YOUR MATCH IS OVER", image.Rect(8, 8, RankingsSplitX-8, 24), img)
+			ui.label(matchResult(state), image.Rect(8, 32, RankingsSplitX-8, 48), img)
+			ui.label("Waiting for the others...", image.Rect(8, 56, RankingsSplitX-8, 72), img)
+		} else {
+			title := "CHOOSE YOUR WEAPON"
+			if len(state.Draws) > 0 {
+				title = fmt.Sprintf("BOTH PICKED %v! AGAIN!", state.Draws[len(state.Draws)-1])
+			}
+			ui.label(title, image.Rect(8, 8, width-8, 24), img)
+			for i, move := range state.Moves { // Three to a row.
+				x, y := 8+i%3*77, 32+i/3*40
+				if ui.button(&ui.moveButtons[move], strings.ToLower(move.String()), image.Rect(x, y, x+69, y+32), img, pointerEvent) {
+					ui.server.Pick(ui.playerId, move)
+				}
+			}
+
+			if state.BestOf > 1 {
+				ui.label(matchScore(state), image.Rect(8, countdownY+24, RankingsSplitX-8, countdownY+40), img)
+			}
+			ui.label(fmt.Sprintf("WHAT WILL %s CHOOSE?", state.Opponent.Name), image.Rect(8, 200, width-8, 216), img)
+		}
+
+		ui.label(fmt.Sprintf("%s left...", ui.CountdownStyle.Format(state.TimeLeftInPhase)), image.Rect(8, countdownY, width-8, countdownY+16), img)
+
+	case state.Phase == game.PhaseReview:
+		if state.Opponent == nil {
+			ui.label("Wait for it...", image.Rect(8, 8, RankingsSplitX-8, 24), img)
+		} else {
+			mine := "YOUR MOVE: none"
+			if state.PlayerMove != nil {
+				mine = fmt.Sprintf("YOUR MOVE: %v", state.PlayerMove)
+			}
+			ui.label(mine, image.Rect(8, 8, RankingsSplitX-8, 24), img)
+
+			theirs := fmt.Sprintf("%s's MOVE: none", state.Opponent.Name)
+			if state.OpponentMove != nil {
+				theirs = fmt.Sprintf("%s's MOVE: %v", state.Opponent.Name, state.OpponentMove)
+			}
+			ui.label(theirs, image.Rect(8, 32, RankingsSplitX-8, 48), img)
+
+			winner := "-- there was no winner --"
+			if state.Winner != nil {
+				if *state.Winner == ui.playerId {
+					winner = "YOU WIN!!"
+				} else if *state.Winner == state.Opponent.PlayerId {
+					winner = "THEY WON!!"
+				}
+			}
+			if state.BestOf > 1 {
+				winner = "-- nobody won this game --"
+				if state.Winner != nil && *state.Winner == ui.playerId {
+					winner = "YOU WIN THIS GAME!"
+				} else if state.Winner != nil && *state.Winner == state.Opponent.PlayerId {
+					winner = "THEY WIN THIS GAME!"
+				}
+				match := matchScore(state)
+				if state.MatchOver {
+					match = strings.ToUpper(matchResult(state))
+				}
+				ui.label(match, image.Rect(8, 80, RankingsSplitX-8, 96), img)
+			}
+			ui.label(winner, image.Rect(8, 56, RankingsSplitX-8, 72), img)
+		}
+
+		if celebration := celebration(state); celebration != "" {
+			ui.fill(image.Rect(0, 112, RankingsSplitX, 144), primaryLightColor, img)
+			ui.label(celebration, image.Rect(8, 120, RankingsSplitX-8, 136), img)
+		}
+		if rivalry := rivalry(state); rivalry != "" {
+			ui.label(rivalry, image.Rect(8, 152, RankingsSplitX-8, 168), img)
+		}
+	}
+
+	if ui.button(&ui.settingsButton, "settings", image.Rect(8, height-64, 85, height-32), img, pointerEvent) {
+		ui.settingsOpen = !ui.settingsOpen
+		ui.rebinding = nil
+	}
+	if ui.lobby != nil && ui.button(&ui.lobbyButton, "lobby", image.Rect(93, height-64, 170, height-32), img, pointerEvent) {
+		ui.leaveRoom()
+	}
+	if ui.button(&ui.sortButton, ui.rankingsBy.String(), image.Rect(178, height-64, 231, height-32), img, pointerEvent) {
+		ui.rankingsBy = (ui.rankingsBy + 1) % numRankingsViews
+	}
+	if !ui.closing && !ui.settingsOpen {
+		p := state.Player
+		stats := fmt.Sprintf("RATING %d, W-L-D %d-%d-%d", p.Rating, p.Wins, p.Losses, p.Draws)
+		switch {
+		case ui.nameError != "":
+			stats = ui.nameError
+		case ui.typingName:
+			stats = fmt.Sprintf("NAME (Return): %s_", ui.typedName)
+		}
+		ui.label(stats, image.Rect(8, height-88, RankingsSplitX-8, height-72), img)
+	}
+
+	if state.Announcement != "" {
+		ui.label(state.Announcement, image.Rect(8, height-24, width-8, height-8), img)
+	}
+
+	return ui.drawn()
+}
+
+// celebration announces the longest streak a match just extended to celebratedStreak or more, like
+// "P3 HAS WON 3 IN A ROW!", or returns "" if none did.
+func celebration(state *game.GameState) string {
+	if state.LastRound == nil {
+		return ""
+	}
+	var best *game.PlayerInfo
+	for _, m := range state.LastRound.Matchups {
+		for i := range m.Players {
+			player := &m.Players[i]
+			if m.Over && m.Winner != nil && *m.Winner == player.PlayerId && player.Streak >= celebratedStreak &&
+				(best == nil || player.Streak > best.Streak) {
+				best = player
+			}
+		}
+	}
+	if best == nil {
+		return ""
+	}
+	if best.PlayerId == state.Player.PlayerId {
+		return fmt.Sprintf("YOU'VE WON %d IN A ROW!", best.Streak)
+	}
+	return fmt.Sprintf("%s HAS WON %d IN A ROW!", best.Name, best.Streak)
+}
+
+// rankingsView is what the rankings column shows, and the order it's in.
+type rankingsView int
+
+const (
+	rankingsByRank   rankingsView = iota // Rank, highest first.
+	rankingsByRating                     // Rating, highest first.
+	rankingsByRecord                     // Wins, losses, and draws, like "3-1-0", highest rank first.
+	numRankingsViews
+)
+
+// String labels the button that switches views.
+func (v rankingsView) String() string {
+	switch v {
+	case rankingsByRating:
+		return "rating"
+	case rankingsByRecord:
+		return "W-L-D"
+	default:
+		return "rank"
+	}
+}
+
+func (v rankingsView) column(player game.PlayerInfo) string {
+	switch v {
+	case rankingsByRating:
+		return fmt.Sprintf("%d", player.Rating)
+	case rankingsByRecord:
+		return fmt.Sprintf("%d-%d-%d", player.Wins, player.Losses, player.Draws)
+	default:
+		return fmt.Sprintf("%d", player.Rank)
+	}
+}
+
+// matchScore describes where the player's best-of match stands, like "Game 2 of 3, you lead 1-0".
+func matchScore(state *game.GameState) string {
+	mine, theirs := state.Score[0], state.Score[1]
+	standing := fmt.Sprintf("tied %d-%d", mine, theirs)
+	if mine > theirs {
+		standing = fmt.Sprintf("you lead %d-%d", mine, theirs)
+	} else if mine < theirs {
+		standing = fmt.Sprintf("you trail %d-%d", mine, theirs)
+	}
+	return fmt.Sprintf("Game %d of %d, %s", state.Game, state.BestOf, standing)
+}
+
+// matchResult describes how the player's finished match went, like "You won the match 2-1".
+func matchResult(state *game.GameState) string {
+	mine, theirs := state.Score[0], state.Score[1]
+	switch {
+	case mine > theirs:
+		return fmt.Sprintf("You won the match %d-%d", mine, theirs)
+	case mine < theirs:
+		return fmt.Sprintf("You lost the match %d-%d", mine, theirs)
+	default:
+		return fmt.Sprintf("The match was a draw, %d-%d", mine, theirs)
+	}
+}
+
+// rivalry describes how the player's matches against their opponent have gone, like "You lead P4 3-1 lifetime", or
+// returns "" if they haven't finished one.
+func rivalry(state *game.GameState) string {
+	if state.Opponent == nil {
+		return ""
+	}
+	record, name := state.HeadToHead, state.Opponent.Name
+	score := fmt.Sprintf("%d-%d", record.Wins, record.Losses)
+	if record.Draws > 0 {
+		score += fmt.Sprintf("-%d", record.Draws)
+	}
+	switch {
+	case record.Wins > record.Losses:
+		return fmt.Sprintf("You lead %s %s lifetime", name, score)
+	case record.Wins < record.Losses:
+		return fmt.Sprintf("You trail %s %s lifetime", name, score)
+	case record.Draws > 0 || record.Wins > 0:
+		return fmt.Sprintf("Even with %s %s lifetime", name, score)
+	default:
+		return ""
+	}
+}
+
+// drawn finishes an Update, returning what it drew differently than the last.
+func (ui *UI) drawn() []image.Rectangle {
+	damage := drawOpsDamage(ui.lastOps, ui.ops)
+	ui.ops, ui.lastOps = ui.lastOps, ui.ops
+	return ui.addDamage(damage)
+}
+
+func (ui *UI) drawSettings(img draw.Image, state *game.GameState, pointerEvent *rfb.PointerEventMessage) {
+	ui.label("SETTINGS (Tab to close)", image.Rect(8, 8, RankingsSplitX-8, 24), img)
+
+	y := 32
+	moves := state.Moves
+	if len(moves) > 3 { // There's only room for one at a time, with a button to show the next.
+		ui.shownBinding %= len(moves)
+		moves = moves[ui.shownBinding : ui.shownBinding+1]
+	}
+	for _, move := range moves {
+		key := keySymName(ui.bindings.Keys[move])
+		if ui.rebinding != nil && *ui.rebinding == move {
+			key = "press a key..."
+		}
+		ui.label(fmt.Sprintf("%v: %s", move, key), image.Rect(8, y+8, 154, y+24), img)
+		if ui.button(&ui.rebindButtons[move], "change", image.Rect(162, y, 231, y+32), img, pointerEvent) {
+			m := move
+			ui.rebinding = &m
+		}
+		y += 40
+	}
+	if len(state.Moves) > 3 {
+		ui.label(fmt.Sprintf("Key %d of %d", ui.shownBinding+1, len(state.Moves)), image.Rect(8, y+8, 154, y+24), img)
+		if ui.button(&ui.nextKeyButton, "next", image.Rect(162, y, 231, y+32), img, pointerEvent) {
+			ui.shownBinding++
+			ui.rebinding = nil
+		}
+		y += 40
+	}
+
+	swap := "off"
+	if ui.bindings.SwapMouseButtons {
+		swap = "on"
+	}
+	ui.label(fmt.Sprintf("Swap mouse buttons: %s", swap), image.Rect(8, y+8, 154, y+24), img)
+	if ui.button(&ui.swapButton, "toggle", image.Rect(162, y, 231, y+32), img, pointerEvent) {
+		ui.bindings.SwapMouseButtons = !ui.bindings.SwapMouseButtons
+		ui.saveBindings()
+	}
+	y += 40
+
+	ui.label(fmt.Sprintf("Countdown: %s", ui.CountdownStyle.Format(9*time.Second)), image.Rect(8, y+8, 154, y+24), img)
+	if ui.button(&ui.countdownButton, "toggle", image.Rect(162, y, 231, y+32), img, pointerEvent) {
+		if ui.CountdownStyle == CountdownClock {
+			ui.CountdownStyle = CountdownSeconds
+		} else {
+			ui.CountdownStyle = CountdownClock
+		}
+	}
+	y += 40
+
+	code := fmt.Sprintf("Your code: %s", state.RejoinCode)
+	switch {
+	case ui.typingCode:
+		code = fmt.Sprintf("Old code: %s_", ui.typedCode)
+	case ui.rejoinError != "":
+		code = ui.rejoinError
+	}
+	ui.label(code, image.Rect(8, y+8, 154, y+24), img)
+	if ui.button(&ui.rejoinButton, "rejoin", image.Rect(162, y, 231, y+32), img, pointerEvent) {
+		ui.typingCode, ui.typedCode, ui.rejoinError = true, "", ""
+	}
+}
+
+// ServerClosing makes the last frame say goodbye.
+func (ui *UI) ServerClosing() {
+	ui.closing = true
+}
+
+func (ui *UI) Close() error {
+	switch {
+	case ui.replaced.Load():
+		if ui.room != nil {
+			ui.lobby.left(ui.room)
+		}
+	case ui.room != nil:
+		ui.lobby.leave(ui.room, ui.playerId)
+	case ui.server != nil:
+		ui.server.RemovePlayer(ui.playerId)
+	}
+	return nil
+}
+
+// drawOp is something Update drew, for finding what changed between frames.
+type drawOp struct {
+	rect image.Rectangle // Everything it drew in.
+	what string          // What it drew there.
+}
+
+// drawOpsDamage returns the regions drawn differently in two frames that drew ops in the same order.
+func drawOpsDamage(old, new []drawOp) []image.Rectangle {
+	var damage []image.Rectangle
+	for i := 0; i < len(old) || i < len(new); i++ {
+		switch {
+		case i >= len(new):
+			damage = append(damage, old[i].rect)
+		case i >= len(old):
+			damage = append(damage, new[i].rect)
+		case old[i] != new[i]:
+			damage = append(damage, old[i].rect, new[i].rect)
+		}
+	}
+	return damage
+}
+
+// Past this many regions, damage is merged into one rectangle around all of it.
+const maxDamageRects = 32
+
+// addDamage adds damage to what Damage will return, and returns it.
+func (ui *UI) addDamage(damage []image.Rectangle) []image.Rectangle {
+	for _, r := range damage {
+		if !r.Empty() {
+			ui.damage = append(ui.damage, r)
+		}
+	}
+	if len(ui.damage) > maxDamageRects {
+		var all image.Rectangle
+		for _, r := range ui.damage {
+			all = all.Union(r)
+		}
+		ui.damage = append(ui.damage[:0], all)
+	}
+	return damage
+}
+
+func (ui *UI) fill(rect image.Rectangle, c color.Color, img draw.Image) {
+	draw.Draw(img, rect, image.NewUniform(c), image.ZP, draw.Src)
+	ui.ops = append(ui.ops, drawOp{rect, fmt.Sprint("fill ", c)})
+}
+
+func (ui *UI) label(text string, rect image.Rectangle, img draw.Image) {
+	label(text, rect, img)
+	ui.ops = append(ui.ops, drawOp{labelBounds(text, rect), "label " + text})
+}
+
+// labelBounds returns where label draws text, which can stick out of rect.
+func labelBounds(text string, rect image.Rectangle) image.Rectangle {
+	fd := &font.Drawer{Face: basicfont.Face7x13, Dot: fixed.Point26_6{X: fixed.I(rect.Min.X), Y: fixed.I(rect.Max.Y)}}
+	bounds, _ := fd.BoundString(text)
+	return image.Rect(bounds.Min.X.Floor(), bounds.Min.Y.Floor(), bounds.Max.X.Ceil(), bounds.Max.Y.Ceil())
+}
+
+func label(text string, rect image.Rectangle, img draw.Image) {
+	fd := &font.Drawer{
+		Dst:  img,
+		Src:  image.NewUniform(color.Black),
+		Face: basicfont.Face7x13,
+		Dot:  fixed.Point26_6{X: fixed.I(rect.Min.X), Y: fixed.I(rect.Max.Y)},
+	}
+	fd.DrawString(text)
+}
+
+type ButtonState struct {
+	clicking bool
+}
+
+func (ui *UI) button(state *ButtonState, text string, rect image.Rectangle, img draw.Image, pointerEvent *rfb.PointerEventMessage) bool {
+	hovering := image.Pt(int(pointerEvent.X), int(pointerEvent.Y)).In(rect)
+	ui.overButton = ui.overButton || hovering
+	buttonDown := pointerEvent.ButtonMask&rfb.ButtonLeft != 0
+
+	// TODO: Require that the click started on the button.
+	var clicked bool
+	if state.clicking {
+		if !buttonDown {
+			clicked = hovering
+			state.clicking = false
+		}
+	} else {
+		if hovering && buttonDown {
+			state.clicking = true
+		}
+	}
+
+	c := image.Uniform{primaryColor}
+	if hovering {
+		if buttonDown {
+			c.C = color.Black
+		} else {
+			c.C = primaryLightColor
+		}
+	}
+	drawButton(text, rect, c.C, img)
+	textRect := image.Rect(rect.Min.X+8, rect.Max.Y-8, rect.Min.X+8, rect.Max.Y-8)
+	ui.ops = append(ui.ops, drawOp{rect.Union(labelBounds(text, textRect)), fmt.Sprint("button ", text, " ", c.C)})
+
+	return clicked
+}
+
+// drawButton draws a button labeled text, filled with c.
+func drawButton(text string, rect image.Rectangle, c color.Color, img draw.Image) {
+	draw.Draw(img, rect, image.NewUniform(c), image.ZP, draw.Src)
+	fd := &font.Drawer{
+		Dst:  img,
+		Src:  image.NewUniform(color.White),
+		Face: basicfont.Face7x13,
+		Dot:  fixed.Point26_6{X: fixed.I(rect.Min.X + 8), Y: fixed.I(rect.Max.Y - 8)},
+	}
+	fd.DrawString(text)
+}
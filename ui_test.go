@@ -0,0 +1,227 @@
+package vncrps
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/alltom/vncrps/game"
+	"github.com/alltom/vncrps/rfb"
+	"github.com/alltom/vncrps/rfb/keysym"
+	"image"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBell(t *testing.T) {
+	now := time.Unix(0, 0)
+	g := game.NewGameServer(func() time.Time { return now }, 1)
+	ui := NewUI(g)
+	bells := func() int {
+		n := 0
+		for _, m := range ui.PendingMessages() {
+			if _, ok := m.(*rfb.BellMessage); ok {
+				n++
+			}
+		}
+		return n
+	}
+
+	if n := bells(); n != 0 {
+		t.Errorf("rang %d times while waiting for an opponent", n)
+	}
+	NewUI(g)
+	if n := bells(); n != 1 {
+		t.Errorf("rang %d times when the round started, want 1", n)
+	}
+	if n := bells(); n != 0 {
+		t.Errorf("rang %d more times during the round", n)
+	}
+	now = now.Add(11 * time.Second)
+	if n := bells(); n != 1 {
+		t.Errorf("rang %d times when the results came in, want 1", n)
+	}
+}
+
+func TestRankingsScroll(t *testing.T) {
+	g := game.NewGameServer(time.Now, 1)
+	ui := NewUI(g)
+	for i := 0; i < 4; i++ {
+		NewUI(g)
+	}
+	ui.Resize(UIWidth, rankingsHeight(2))
+	scroll := func(x uint16, button uint8, notches int) {
+		for i := 0; i < notches; i++ {
+			ui.PointerEvent(&rfb.PointerEventMessage{ButtonMask: button, X: x, Y: 8})
+			ui.PointerEvent(&rfb.PointerEventMessage{X: x, Y: 8})
+		}
+	}
+
+	scroll(RankingsSplitX+8, rfb.ButtonWheelDown, 1)
+	if ui.scrollRows != 1 {
+		t.Errorf("scrolled %d rows after one notch down, want 1", ui.scrollRows)
+	}
+	scroll(RankingsSplitX+8, rfb.ButtonWheelDown, 10)
+	if ui.scrollRows != 3 {
+		t.Errorf("scrolled %d rows past the end of 5 rankings with 2 shown, want 3", ui.scrollRows)
+	}
+	scroll(8, rfb.ButtonWheelUp, 1)
+	if ui.scrollRows != 3 {
+		t.Errorf("scrolled to row %d with the pointer outside the rankings, want 3", ui.scrollRows)
+	}
+	scroll(RankingsSplitX+8, rfb.ButtonWheelUp, 10)
+	if ui.scrollRows != 0 {
+		t.Errorf("scrolled %d rows after scrolling back past the top, want 0", ui.scrollRows)
+	}
+}
+
+func TestRankingsPastMaxUISize(t *testing.T) {
+	g := game.NewGameServer(time.Now, 1)
+	ui := NewUI(g)
+	fit := (maxUISize - 32) / rankingRowHeight
+	for i := 0; i < fit+10; i++ {
+		g.AddPlayer()
+	}
+	spectator := newSpectatorScreen(g, CountdownClock)
+	for _, size := range []image.Point{ui.DesktopSize(), spectator.DesktopSize()} {
+		if size.Y != maxUISize {
+			t.Errorf("desktop height for %d players is %d, want it clamped to %d", fit+11, size.Y, maxUISize)
+		}
+	}
+
+	spectator.Resize(UIWidth, maxUISize)
+	unscrolled := image.NewRGBA(image.Rect(0, 0, UIWidth, maxUISize))
+	spectator.Render(unscrolled, unscrolled.Rect)
+	for i := 0; i < 20; i++ {
+		spectator.PointerEvent(&rfb.PointerEventMessage{ButtonMask: rfb.ButtonWheelDown, X: RankingsSplitX + 8, Y: 8})
+		spectator.PointerEvent(&rfb.PointerEventMessage{X: RankingsSplitX + 8, Y: 8})
+	}
+	img := image.NewRGBA(image.Rect(0, 0, UIWidth, maxUISize))
+	spectator.Render(img, img.Rect)
+	if spectator.scrollRows != 11 {
+		t.Errorf("spectator scrolled %d rows past the end of %d rankings with %d shown, want 11", spectator.scrollRows, fit+11, fit)
+	}
+	if bytes.Equal(img.Pix, unscrolled.Pix) {
+		t.Error("the rankings look the same after scrolling to the end")
+	}
+}
+
+func TestUIDamage(t *testing.T) {
+	now := time.Unix(0, 0)
+	g := game.NewGameServer(func() time.Time { return now }, 1)
+	ui := NewUI(g)
+	NewUI(g)
+	frame := image.Rect(0, 0, UIWidth, UIHeight)
+	render := func() []image.Rectangle {
+		ui.Render(image.NewRGBA(frame), frame)
+		return ui.Damage()
+	}
+
+	if damage := render(); len(damage) == 0 || damage[0] != frame {
+		t.Errorf("first frame's damage is %v, want all of %v", damage, frame)
+	}
+	if damage := render(); len(damage) != 0 {
+		t.Errorf("unchanged frame's damage is %v", damage)
+	}
+	now = now.Add(time.Second)
+	countdown := image.Rect(0, 56, RankingsSplitX, 96)
+	damage := render()
+	if len(damage) == 0 {
+		t.Error("countdown changed without damage")
+	}
+	for _, r := range damage {
+		if !r.In(countdown) {
+			t.Errorf("countdown changing damaged %v, outside %v", r, countdown)
+		}
+	}
+}
+
+func TestUIName(t *testing.T) {
+	g := game.NewGameServer(time.Now, 1)
+	typeKeys := func(ui *UI, keys string) {
+		for _, key := range keys {
+			keySym := uint32(key)
+			switch key {
+			case '\r':
+				keySym = keysym.Return
+			case '\b':
+				keySym = keysym.BackSpace
+			case '\x1b':
+				keySym = keysym.Escape
+			}
+			ui.KeyEvent(&rfb.KeyEventMessage{Pressed: true, KeySym: keySym})
+		}
+	}
+	ada, other := NewUI(g), NewUI(g)
+	typeKeys(ada, "Adx\ba\r")
+	typeKeys(other, "ada\r")
+	if !other.typingName || other.nameError == "" {
+		t.Errorf("taking a name that's taken left typingName %v and nameError %q, want to type another", other.typingName, other.nameError)
+	}
+	typeKeys(other, "\x1b")
+
+	var names []string
+	for _, ui := range []*UI{ada, other} {
+		state, err := g.GetState(ui.playerId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, state.Player.Name)
+	}
+	if want := []string{"Ada", fmt.Sprintf("P%d", other.playerId)}; !reflect.DeepEqual(names, want) {
+		t.Errorf("players are named %q, want %q", names, want)
+	}
+}
+
+func TestUIRejoin(t *testing.T) {
+	g := game.NewGameServer(time.Now, 1)
+	old := NewUI(g)
+	state, err := g.GetState(old.playerId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old.Close()
+
+	ui := NewUI(g)
+	var rejoined game.PlayerId
+	ui.rejoined = func(playerId game.PlayerId) { rejoined = playerId }
+	ui.typingCode = true // As if the rejoin button had been clicked.
+	for _, key := range strings.ToLower(state.RejoinCode) + "\r" {
+		keySym := uint32(key)
+		if key == '\r' {
+			keySym = keysym.Return
+		}
+		ui.KeyEvent(&rfb.KeyEventMessage{Pressed: true, KeySym: keySym})
+	}
+	if ui.playerId != old.playerId || rejoined != old.playerId {
+		t.Errorf("playing as %d after typing the old player's code, want %d", ui.playerId, old.playerId)
+	}
+	if standings := g.Standings(); len(standings) != 1 {
+		t.Errorf("game has %d players after rejoining, want 1", len(standings))
+	}
+}
+
+func TestUIRejoinKeepsBindings(t *testing.T) {
+	g := game.NewGameServer(time.Now, 1)
+	old := NewUI(g)
+	old.rebinding = new(game.Move) // As if rock's rebind button had been clicked.
+	old.KeyEvent(&rfb.KeyEventMessage{Pressed: true, KeySym: 'j'})
+	state, err := g.GetState(old.playerId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := old.bindings
+	if want == DefaultInputBindings {
+		t.Fatal("rebinding rock didn't change the bindings")
+	}
+	old.Close()
+
+	ui := NewUI(g)
+	if ui.bindings != DefaultInputBindings {
+		t.Errorf("new player has bindings %+v, want the defaults", ui.bindings)
+	}
+	ui.rejoin(state.RejoinCode)
+	if ui.bindings != want {
+		t.Errorf("bindings after rejoining are %+v, want %+v", ui.bindings, want)
+	}
+}
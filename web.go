@@ -0,0 +1,75 @@
+package vncrps
+
+import (
+	"github.com/alltom/vncrps/rfb"
+	"net/http"
+)
+
+// Where the viewer page loads noVNC from. Browsers cache it, so only the first visit needs it.
+const noVNCModule = "https://cdn.jsdelivr.net/npm/@novnc/novnc@1.4.0/core/rfb.js"
+
+// The path WebHandler serves WebSockets on, which is where noVNC's own pages look by default.
+const webSocketPath = "/websockify"
+
+// viewerPage is a full-window noVNC viewer that connects back to the server it was loaded from. It asks the server to
+// match the window's size and only prompts for credentials when the server requires a login.
+const viewerPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Rock/Paper/Scissors</title>
+<style>
+html, body { margin: 0; height: 100%; background: #000; color: #ccc; font: 14px sans-serif; overflow: hidden; }
+#screen { position: absolute; inset: 0; }
+#status { position: absolute; left: 0; right: 0; bottom: 0; padding: 4px 8px; background: rgba(0, 0, 0, 0.6); }
+#status:empty { display: none; }
+</style>
+</head>
+<body>
+<div id="screen"></div>
+<div id="status">Connecting…</div>
+<script type="module">
+import RFB from "` + noVNCModule + `";
+
+const status = document.getElementById("status");
+const url = (location.protocol === "https:" ? "wss://" : "ws://") + location.host + "` + webSocketPath + `";
+const rfb = new RFB(document.getElementById("screen"), url);
+rfb.resizeSession = true;
+rfb.scaleViewport = true;
+rfb.focusOnClick = true;
+
+rfb.addEventListener("connect", () => { status.textContent = ""; rfb.focus(); });
+rfb.addEventListener("disconnect", e => {
+	status.textContent = e.detail.clean ? "Disconnected. Reload to play again." : "Lost the connection. Reload to try again.";
+});
+rfb.addEventListener("securityfailure", e => { status.textContent = "Couldn't log in: " + (e.detail.reason || "wrong username or password") + "."; });
+rfb.addEventListener("credentialsrequired", e => {
+	// Without a login, the server accepts any VNC password.
+	const credentials = { password: "" };
+	if (e.detail.types.includes("username")) {
+		credentials.username = prompt("Username:") || "";
+		credentials.password = prompt("Password:") || "";
+	}
+	rfb.sendCredentials(credentials);
+});
+</script>
+</body>
+</html>
+`
+
+// WebHandler serves a page that plays the game in the browser with noVNC, and the WebSocket endpoint it connects to,
+// whose connections ws accepts.
+func WebHandler(ws *rfb.WebSocketListener) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle(webSocketPath, ws)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(viewerPage))
+	})
+	return mux
+}